@@ -0,0 +1,202 @@
+//! Benchmarks for the remote configuration pipeline with large configuration sets.
+//!
+//! Each benchmark reports the median of several runs. Absolute durations depend on the machine, so
+//! regressions are detected against a baseline measured on the same machine: the medians are
+//! written to the file set in `CONFIG_PIPELINE_BENCH_OUTPUT`, and when
+//! `CONFIG_PIPELINE_BENCH_BASELINE` points to the output of a previous run (e.g. of the base
+//! branch), a benchmark fails if its median exceeds the baseline one by more than
+//! `CONFIG_PIPELINE_BENCH_MAX_REGRESSION` (a ratio, `0.25` by default). Run with:
+//!
+//! ```sh
+//! cargo bench --package newrelic_agent_control --bench config_pipeline
+//! ```
+
+use newrelic_agent_control::agent_control::agent_id::AgentID;
+use newrelic_agent_control::agent_type::agent_type_id::AgentTypeID;
+use newrelic_agent_control::opamp::remote_config::hash::{ConfigState, Hash};
+use newrelic_agent_control::opamp::remote_config::validators::regexes::RegexValidator;
+use newrelic_agent_control::opamp::remote_config::{
+    AGENT_CONFIG_OVERRIDE_PREFIX, AGENT_CONFIG_PREFIX, ConfigurationMap, OpampRemoteConfig,
+};
+use newrelic_agent_control::sub_agent::identity::AgentIdentity;
+use newrelic_agent_control::sub_agent::remote_config_parser::{
+    AgentRemoteConfigParser, RemoteConfigParser,
+};
+use newrelic_agent_control::values::config::{Config, RemoteConfig};
+use newrelic_agent_control::values::yaml_config::YAMLConfig;
+use std::collections::{BTreeMap, HashMap};
+use std::hint::black_box;
+use std::process::ExitCode;
+use std::time::{Duration, Instant};
+
+const OUTPUT_ENV_VAR: &str = "CONFIG_PIPELINE_BENCH_OUTPUT";
+const BASELINE_ENV_VAR: &str = "CONFIG_PIPELINE_BENCH_BASELINE";
+const MAX_REGRESSION_ENV_VAR: &str = "CONFIG_PIPELINE_BENCH_MAX_REGRESSION";
+const DEFAULT_MAX_REGRESSION: f64 = 0.25;
+const RUNS: usize = 10;
+
+/// Number of files in the remote configuration.
+const FILES: usize = 300;
+/// Entries per file, each file being ~10KB so the whole set is a few MB.
+const ENTRIES_PER_FILE: usize = 50;
+
+/// Median duration of each benchmark in nanoseconds, by benchmark name.
+type Medians = BTreeMap<String, u64>;
+
+fn main() -> ExitCode {
+    let max_regression = std::env::var(MAX_REGRESSION_ENV_VAR)
+        .ok()
+        .map(|ratio| {
+            ratio
+                .parse::<f64>()
+                .unwrap_or_else(|err| panic!("invalid {MAX_REGRESSION_ENV_VAR} '{ratio}': {err}"))
+        })
+        .unwrap_or(DEFAULT_MAX_REGRESSION);
+    let baseline: Option<Medians> = std::env::var(BASELINE_ENV_VAR).ok().map(|path| {
+        let content = std::fs::read(&path)
+            .unwrap_or_else(|err| panic!("reading the baseline '{path}': {err}"));
+        serde_json::from_slice(&content)
+            .unwrap_or_else(|err| panic!("invalid baseline '{path}': {err}"))
+    });
+
+    let files = config_files();
+    let configs = yaml_configs(&files);
+    let size: usize = files.values().map(String::len).sum();
+    println!("Config set: {FILES} files, {:.2} MB", size as f64 / 1e6);
+
+    let medians: Medians = [
+        ("merge", bench("merge", || merge(&configs))),
+        ("handle", bench("handle", || handle(&files))),
+    ]
+    .into_iter()
+    .map(|(name, median)| (name.to_string(), median.as_nanos() as u64))
+    .collect();
+
+    if let Ok(path) = std::env::var(OUTPUT_ENV_VAR) {
+        let output = serde_json::to_vec_pretty(&medians).expect("medians are serializable");
+        std::fs::write(&path, output)
+            .unwrap_or_else(|err| panic!("writing the results to '{path}': {err}"));
+    }
+
+    let Some(baseline) = baseline else {
+        println!("No baseline set in {BASELINE_ENV_VAR}, skipping the regression check");
+        return ExitCode::SUCCESS;
+    };
+    // Every benchmark is checked, so all the regressions are reported.
+    let regressions = medians
+        .iter()
+        .filter(|(name, median)| !check_regression(name, **median, &baseline, max_regression))
+        .count();
+    if regressions == 0 {
+        ExitCode::SUCCESS
+    } else {
+        ExitCode::FAILURE
+    }
+}
+
+/// Runs `f` [RUNS] times and returns its median duration.
+fn bench<T>(name: &str, mut f: impl FnMut() -> T) -> Duration {
+    // Warm up
+    black_box(f());
+
+    let mut durations: Vec<Duration> = (0..RUNS)
+        .map(|_| {
+            let start = Instant::now();
+            black_box(f());
+            start.elapsed()
+        })
+        .collect();
+    durations.sort();
+    let median = durations[RUNS / 2];
+    println!(
+        "{name:<8} median {median:>12?} (min {:?}, max {:?})",
+        durations[0],
+        durations[RUNS - 1]
+    );
+    median
+}
+
+/// Checks that `median` doesn't exceed the baseline median of the benchmark by more than
+/// `max_regression`. Benchmarks missing from the baseline are not checked.
+fn check_regression(name: &str, median: u64, baseline: &Medians, max_regression: f64) -> bool {
+    let Some(base) = baseline.get(name) else {
+        println!("{name:<8} no baseline");
+        return true;
+    };
+    let change = median as f64 / *base as f64 - 1.0;
+    let within_threshold = change <= max_regression;
+    println!(
+        "{name:<8} baseline {:>12?} ({:+.1}%, max {:+.1}%): {}",
+        Duration::from_nanos(*base),
+        change * 100.0,
+        max_regression * 100.0,
+        if within_threshold { "ok" } else { "REGRESSED" }
+    );
+    within_threshold
+}
+
+/// Generates the remote configuration files. Keys don't collide across files, as the remote
+/// config parser rejects duplicated keys.
+fn config_files() -> HashMap<String, String> {
+    (0..FILES)
+        .map(|file| {
+            let content = (0..ENTRIES_PER_FILE)
+                .map(|entry| {
+                    format!(
+                        "integration_{file}_{entry}:\n  interval: 30s\n  timeout: 10s\n  labels:\n    env: production\n    team: team-{entry}\n  inventory_source: config/integration_{file}_{entry}\n  arguments:\n    - --host=host-{entry}.example.com\n    - --port={entry}\n    - --metrics=true\n  description: \"{}\"\n",
+                        "x".repeat(64)
+                    )
+                })
+                .collect::<String>();
+            (format!("{AGENT_CONFIG_PREFIX}-{file}"), content)
+        })
+        .collect()
+}
+
+fn yaml_configs(files: &HashMap<String, String>) -> Vec<YAMLConfig> {
+    files
+        .values()
+        .map(|content| YAMLConfig::try_from(content.as_str()).expect("valid config"))
+        .collect()
+}
+
+fn remote_config(files: &HashMap<String, String>) -> OpampRemoteConfig {
+    let mut config_map = files.clone();
+    config_map.insert(
+        format!("{AGENT_CONFIG_OVERRIDE_PREFIX}-0"),
+        "integration_0_0:\n  interval: 15s\n".to_string(),
+    );
+    OpampRemoteConfig::new(
+        AgentID::try_from("infra-agent").expect("valid agent id"),
+        Hash::from("hash"),
+        ConfigState::Applying,
+        ConfigurationMap::new(config_map),
+    )
+}
+
+/// Merge of the parsed configuration files, each one overriding the previous ones.
+fn merge(configs: &[YAMLConfig]) -> YAMLConfig {
+    configs
+        .iter()
+        .cloned()
+        .fold(YAMLConfig::default(), YAMLConfig::merge_override)
+}
+
+/// Handling of a remote configuration by a sub-agent: validation, parsing and merge with the
+/// local mandatory configuration.
+fn handle(files: &HashMap<String, String>) -> Config {
+    let agent_identity = AgentIdentity::from((
+        AgentID::try_from("infra-agent").expect("valid agent id"),
+        AgentTypeID::try_from("newrelic/com.newrelic.infrastructure:0.1.0")
+            .expect("valid agent type id"),
+    ));
+    let parser = AgentRemoteConfigParser::new(vec![RegexValidator::default()]);
+    let remote_config: RemoteConfig = parser
+        .parse(agent_identity, &remote_config(files))
+        .expect("valid remote config")
+        .expect("non-empty remote config");
+    let local_mandatory =
+        YAMLConfig::try_from("integration_0_1:\n  interval: 60s\n").expect("valid config");
+
+    Config::RemoteConfig(remote_config).with_local_mandatory(local_mandatory)
+}
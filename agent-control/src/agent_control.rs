@@ -5,32 +5,50 @@ pub mod agent_id;
 pub mod config;
 pub mod config_repository;
 pub mod config_validator;
+pub mod control_socket;
+pub mod credentials;
 pub mod defaults;
 pub mod error;
+pub mod feature_flags;
 mod health_checker;
 pub mod http_server;
+pub mod infra_config_import;
 pub mod pid_cache;
 pub mod resource_cleaner;
 pub mod run;
 pub mod uptime_report;
 pub mod version_updater;
 
-use crate::agent_control::defaults::AGENT_CONTROL_ID;
+use crate::agent_control::config_repository::in_progress::{
+    ConfigApplyInProgress, ConfigApplyTracker,
+};
+use crate::agent_control::config_repository::repository::AgentControlConfigLoader;
+use crate::agent_control::control_socket::protocol::{
+    ControlCommand, ControlRequest, ControlResponse,
+};
+use crate::agent_control::defaults::{AGENT_CONTROL_ID, MANAGED_AGENTS_ATTRIBUTE_KEY};
 use crate::agent_control::run::GracefulShutdownReason;
+use crate::audit::{AuditEvent, AuditTrail};
 use crate::checkers::health::health_checker::{HealthChecker, spawn_health_checker};
 use crate::checkers::health::with_start_time::HealthWithStartTime;
 use crate::event::AgentControlInternalEvent;
+use crate::event::cancellation::CancellationMessage;
 use crate::event::channel::EventPublisher;
 use crate::event::{
-    AgentControlEvent, ApplicationEvent, OpAMPEvent, broadcaster::unbounded::UnboundedBroadcast,
-    channel::EventConsumer,
+    AgentControlEvent, ApplicationEvent, OpAMPEvent, SubAgentEvent,
+    broadcaster::unbounded::UnboundedBroadcast, channel::EventConsumer,
 };
-use crate::opamp::attributes::update_opamp_attributes;
+use crate::instrumentation::config::logs::level_reload::LogLevelReloader;
+use crate::lifecycle_hooks::{LifecycleEvent, LifecycleHooks};
+use crate::opamp::attributes::{publish_update_attributes_event, update_opamp_attributes};
+use crate::opamp::instance_id::getter::InstanceIDGetter;
 use crate::opamp::remote_config::report::report_state;
 use crate::opamp::remote_config::validators::RemoteConfigValidator;
 use crate::opamp::remote_config::{OpampRemoteConfig, OpampRemoteConfigError, hash::ConfigState};
+use crate::package::syncer::PackagesSyncer;
 use crate::sub_agent::{
-    NotStartedSubAgent, SubAgentBuilder, collection::StartedSubAgents, identity::AgentIdentity,
+    NotStartedSubAgent, StartedSubAgent, SubAgentBuilder, collection::StartedSubAgents,
+    identity::AgentIdentity, watchdog::StallAction,
 };
 use crate::values::config::RemoteConfig as RemoteConfigValues;
 use crate::values::yaml_config::YAMLConfig;
@@ -42,9 +60,12 @@ use crossbeam::channel::{never, tick};
 use crossbeam::select;
 use error::{AgentControlError, BuildingSubagentErrors};
 use opamp_client::StartedClient;
+use opamp_client::opamp::proto::{PackageStatusEnum, PackagesAvailable};
+use opamp_client::operation::settings::AgentDescription;
 use resource_cleaner::ResourceCleaner;
-use std::sync::Arc;
-use std::time::SystemTime;
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, SystemTime};
 use tracing::{debug, error, info, info_span, instrument, trace, warn};
 use uptime_report::UptimeReporter;
 use version_updater::updater::VersionUpdater;
@@ -83,6 +104,16 @@ where
     version_updater: VU,
     initial_config: AgentControlConfig,
     health_checker_builder: HCB,
+    control_consumer: EventConsumer<ControlRequest>,
+    sub_agent_consumer: EventConsumer<SubAgentEvent>,
+    config_loader: Option<Arc<dyn AgentControlConfigLoader>>,
+    io_cancellation: Mutex<Option<EventPublisher<CancellationMessage>>>,
+    instance_id_getter: Option<Arc<dyn InstanceIDGetter>>,
+    config_apply_tracker: Option<Arc<dyn ConfigApplyTracker>>,
+    packages_syncer: Option<Arc<dyn PackagesSyncer>>,
+    lifecycle_hooks: LifecycleHooks,
+    audit_trail: AuditTrail,
+    log_level_reloader: LogLevelReloader,
 }
 
 impl<S, O, SL, RV, DV, RC, VU, HC, HCB> AgentControl<S, O, SL, RV, DV, RC, VU, HC, HCB>
@@ -132,6 +163,110 @@ where
             health_checker_builder,
             version_updater,
             initial_config,
+            control_consumer: EventConsumer::from(never()),
+            sub_agent_consumer: EventConsumer::from(never()),
+            config_loader: None,
+            io_cancellation: Mutex::default(),
+            instance_id_getter: None,
+            config_apply_tracker: None,
+            packages_syncer: None,
+            lifecycle_hooks: LifecycleHooks::default(),
+            audit_trail: AuditTrail::default(),
+            log_level_reloader: LogLevelReloader::default(),
+        }
+    }
+
+    /// Sets the consumer of the requests received through the local control API.
+    pub fn with_control_consumer(self, control_consumer: EventConsumer<ControlRequest>) -> Self {
+        Self {
+            control_consumer,
+            ..self
+        }
+    }
+
+    /// Sets the consumer of the sub-agent events, used to expose the health of the sub-agents
+    /// through the local control API.
+    pub fn with_sub_agent_consumer(self, sub_agent_consumer: EventConsumer<SubAgentEvent>) -> Self {
+        Self {
+            sub_agent_consumer,
+            ..self
+        }
+    }
+
+    /// Sets the loader of the full Agent Control config, used to apply the configured log level
+    /// when the configuration is reloaded. See [AgentControl::with_log_level_reloader].
+    pub fn with_config_loader(self, config_loader: Arc<dyn AgentControlConfigLoader>) -> Self {
+        Self {
+            config_loader: Some(config_loader),
+            ..self
+        }
+    }
+
+    /// Sets the publisher whose consumers cancel their pending I/O operations when it is dropped,
+    /// which happens when Agent Control starts shutting down.
+    pub fn with_io_cancellation(
+        self,
+        io_cancellation: EventPublisher<CancellationMessage>,
+    ) -> Self {
+        Self {
+            io_cancellation: Mutex::new(Some(io_cancellation)),
+            ..self
+        }
+    }
+
+    /// Sets the getter of the sub-agents instance ids, used to report the agents managed by this
+    /// Agent Control so the backend can relate them.
+    pub fn with_instance_id_getter(self, instance_id_getter: Arc<dyn InstanceIDGetter>) -> Self {
+        Self {
+            instance_id_getter: Some(instance_id_getter),
+            ..self
+        }
+    }
+
+    /// Sets the tracker persisting a marker while a remote config is applied, so an apply
+    /// interrupted by a host reboot is reverted on the next start.
+    pub fn with_config_apply_tracker(
+        self,
+        config_apply_tracker: Arc<dyn ConfigApplyTracker>,
+    ) -> Self {
+        Self {
+            config_apply_tracker: Some(config_apply_tracker),
+            ..self
+        }
+    }
+
+    /// Sets the syncer staging the packages offered by the OpAMP server. Offered packages are
+    /// ignored without it.
+    pub fn with_packages_syncer(self, packages_syncer: Arc<dyn PackagesSyncer>) -> Self {
+        Self {
+            packages_syncer: Some(packages_syncer),
+            ..self
+        }
+    }
+
+    /// Sets the hooks notified of the configurations applied to Agent Control and of the stalled
+    /// agents.
+    pub fn with_lifecycle_hooks(self, lifecycle_hooks: LifecycleHooks) -> Self {
+        Self {
+            lifecycle_hooks,
+            ..self
+        }
+    }
+
+    /// Sets the reloader of the logging filters, changing their level to the configured one when
+    /// the configuration is reloaded.
+    pub fn with_log_level_reloader(self, log_level_reloader: LogLevelReloader) -> Self {
+        Self {
+            log_level_reloader,
+            ..self
+        }
+    }
+
+    /// Sets the audit trail recording the configurations applied to Agent Control.
+    pub fn with_audit_trail(self, audit_trail: AuditTrail) -> Self {
+        Self {
+            audit_trail,
+            ..self
         }
     }
 
@@ -146,6 +281,7 @@ where
         // the result as read by the `AgentControlConfigLoader`.
         let (running_sub_agents, build_and_start_result) =
             self.build_and_run_sub_agents(&self.initial_config.dynamic.agents);
+        self.report_managed_agents(&running_sub_agents);
 
         // Get the state corresponding to
         let build_and_start_config_state = if let Err(err) = build_and_start_result {
@@ -211,6 +347,16 @@ where
         Ok(shutdown_reason)
     }
 
+    /// Cancels the pending I/O operations, so the sub-agents can be stopped even if they are
+    /// blocked by a hung filesystem.
+    fn cancel_pending_io(&self) {
+        let _ = self
+            .io_cancellation
+            .lock()
+            .expect("io cancellation lock poisoned")
+            .take();
+    }
+
     // Recreates a Sub Agent by its agent_id meaning:
     //  * Remove and stop the existing running Sub Agent from the Running Sub Agents
     //  * Recreate the Final Agent using the Agent Type and the latest persisted config
@@ -313,8 +459,21 @@ where
             never()
         };
 
+        // Checks that the runtimes of the sub-agents keep making progress.
+        let watchdog_config = &self.initial_config.watchdog;
+        let watchdog_ticker = if watchdog_config.enabled() {
+            tick(watchdog_config.check_interval())
+        } else {
+            never()
+        };
+
         // Count the received remote configs during execution
         let mut remote_config_count = 0;
+        // Whether the sub-agents were stopped through the control API
+        let mut paused = false;
+        // Last health reported by Agent Control and by each sub-agent, exposed through the control API
+        let mut last_health = None;
+        let mut agents_health = HashMap::new();
         loop {
             select! {
                 recv(&opamp_receiver.as_ref()) -> opamp_event_res => {
@@ -332,6 +491,11 @@ where
                                     remote_config_count += 1;
                                     trace!(monotonic_counter.remote_configs_received = remote_config_count);
 
+                                    if paused {
+                                        info!("Resuming paused agents to apply the remote config");
+                                        let _ = self.resume_sub_agents(&mut sub_agents, &current_dynamic_config, &mut paused)
+                                            .inspect_err(|err| error!(error_msg = %err, "Error resuming agents"));
+                                    }
                                     match self.handle_remote_config(remote_config, &mut sub_agents, &current_dynamic_config) {
                                         Ok(new_dynamic_config) => {
                                             // A new config has been applied from remote, so we update the current to this.
@@ -343,7 +507,15 @@ where
                                     };
                                 }
                                 OpAMPEvent::Connected => self.agent_control_publisher.broadcast(AgentControlEvent::OpAMPConnected),
-                                OpAMPEvent::ConnectFailed(error_code, error_message) => self.agent_control_publisher.broadcast(AgentControlEvent::OpAMPConnectFailed(error_code, error_message))
+                                OpAMPEvent::ConnectFailed(error_code, error_message) => self.agent_control_publisher.broadcast(AgentControlEvent::OpAMPConnectFailed(error_code, error_message)),
+                                OpAMPEvent::PackagesAvailable(packages_available) => {
+                                    if paused {
+                                        info!("Resuming paused agents to install the offered packages");
+                                        let _ = self.resume_sub_agents(&mut sub_agents, &current_dynamic_config, &mut paused)
+                                            .inspect_err(|err| error!(error_msg = %err, "Error resuming agents"));
+                                    }
+                                    self.handle_packages_available(&packages_available, &mut sub_agents, &current_dynamic_config);
+                                }
                             }
                         }
                     }
@@ -358,6 +530,7 @@ where
                         Ok(internal_event) => {
                             match internal_event {
                                 AgentControlInternalEvent::HealthUpdated(health) => {
+                                    last_health = Some(health.clone());
                                     self.report_health(health);
                                 },
                                 AgentControlInternalEvent::AgentControlAttributesUpdated(attributes) => {
@@ -369,8 +542,16 @@ where
                                 AgentControlInternalEvent::SelfUpdateRestartRequested() => {
                                     debug!("Stopping Agent Control to apply self-update");
                                     self.agent_control_publisher.broadcast(AgentControlEvent::AgentControlStopped);
+                                    self.cancel_pending_io();
                                     sub_agents.stop();
                                     break GracefulShutdownReason::SelfUpdate;
+                                },
+                                AgentControlInternalEvent::SubAgentCrashLooped(agent_id) => {
+                                    error!(%agent_id, "Stopping Agent Control, an executable of the agent exhausted its restart policy");
+                                    self.agent_control_publisher.broadcast(AgentControlEvent::AgentControlStopped);
+                                    self.cancel_pending_io();
+                                    sub_agents.stop();
+                                    break GracefulShutdownReason::SubAgentCrashLoop(agent_id);
                                 }}
                         },
                     }
@@ -378,12 +559,36 @@ where
                 recv(self.application_event_consumer.as_ref()) -> agent_control_event => {
                     let span = info_span!("process_application_event", id=AGENT_CONTROL_ID);
                     let _span_guard = span.enter();
+                    if let Ok(ApplicationEvent::ReloadRequested) = agent_control_event {
+                        // Errors are already logged, there is nobody to report them to.
+                        let _ = self.handle_control_command(&ControlCommand::Reload, &mut sub_agents, &mut current_dynamic_config, &mut paused, last_health.as_ref(), &mut agents_health);
+                        continue;
+                    }
                     let _= agent_control_event.inspect_err(|err| error!(error = %err, select_arm = "application_event_consumer", "Receiving application event"));
                     debug!("Stopping Agent Control event processor");
                     self.agent_control_publisher.broadcast(AgentControlEvent::AgentControlStopped);
+                    self.cancel_pending_io();
                     sub_agents.stop();
                     break GracefulShutdownReason::ExternalRequested;
                 },
+                recv(self.control_consumer.as_ref()) -> control_request_res => {
+                    let span = info_span!("process_control_request", id=AGENT_CONTROL_ID);
+                    let _span_guard = span.enter();
+                    match control_request_res {
+                        Err(err) => {
+                            debug!("Error receiving control request {err}");
+                        },
+                        Ok(request) => {
+                            let response = self.handle_control_command(&request.command, &mut sub_agents, &mut current_dynamic_config, &mut paused, last_health.as_ref(), &mut agents_health);
+                            request.respond(response);
+                        }
+                    }
+                },
+                recv(self.sub_agent_consumer.as_ref()) -> sub_agent_event_res => {
+                    if let Ok(SubAgentEvent::HealthUpdated(agent_identity, health)) = sub_agent_event_res {
+                        agents_health.insert(agent_identity.id, health);
+                    }
+                },
                 recv(uptime_reporter.receiver()) -> _tick => { let _ = uptime_reporter.report(); },
                 recv(&self_update_retry_ticker) -> _tick => {
                     let span = info_span!("self_update_retry", id=AGENT_CONTROL_ID);
@@ -392,8 +597,306 @@ where
                         debug!(error_msg = %err, "Scheduled self-update retry suppressed or failed");
                     }
                 },
+                recv(&watchdog_ticker) -> _tick => {
+                    let span = info_span!("watchdog", id=AGENT_CONTROL_ID);
+                    let _span_guard = span.enter();
+                    self.handle_stalled_sub_agents(&mut sub_agents, &current_dynamic_config);
+                },
+            }
+        }
+    }
+
+    /// Reports the sub-agents whose runtime stopped making progress and, if configured, requests them
+    /// to stop, starting them again once their runtime finished. The stalled runtime still owns its
+    /// supervisor, so starting the replacement earlier would run its executables twice.
+    fn handle_stalled_sub_agents(
+        &self,
+        sub_agents: &mut StartedSubAgents<BuilderStartedSubAgent<S>>,
+        current_dynamic_config: &AgentControlDynamicConfig,
+    ) {
+        for agent_id in sub_agents.remove_finished() {
+            let Some(agent_config) = current_dynamic_config.agents.get(&agent_id) else {
+                continue;
+            };
+            info!(%agent_id, "Stalled sub agent stopped, starting it again");
+            let agent_identity = AgentIdentity::from((&agent_id, &agent_config.agent_type));
+            let _ = self
+                .build_and_run_sub_agent(&agent_identity, sub_agents)
+                .inspect_err(
+                    |err| error!(%agent_id, error_msg = %err, "Error restarting stalled sub agent"),
+                );
+        }
+
+        let watchdog_config = &self.initial_config.watchdog;
+        let restart = watchdog_config.on_stall == StallAction::Restart;
+        let stalled: Vec<(AgentID, Duration)> = sub_agents
+            .stalled(watchdog_config.deadline())
+            .into_iter()
+            .map(|(agent_id, sub_agent, stalled_for)| {
+                warn!(%agent_id, stalled_for_secs = stalled_for.as_secs(), "Sub agent runtime stalled");
+                sub_agent.report_stalled(stalled_for);
+                (agent_id.clone(), stalled_for)
+            })
+            .collect();
+
+        for (agent_id, stalled_for) in stalled {
+            self.lifecycle_hooks.notify(LifecycleEvent::Stalled {
+                agent_id: agent_id.to_string(),
+                stalled_for_secs: stalled_for.as_secs(),
+                restarted: restart,
+            });
+            if restart {
+                let _ = sub_agents.request_stop(&agent_id).inspect_err(
+                    |err| error!(%agent_id, error_msg = %err, "Error stopping stalled sub agent"),
+                );
+            }
+        }
+    }
+
+    /// Executes a command received through the local control API and returns its response.
+    fn handle_control_command(
+        &self,
+        command: &ControlCommand,
+        sub_agents: &mut StartedSubAgents<BuilderStartedSubAgent<S>>,
+        current_dynamic_config: &mut AgentControlDynamicConfig,
+        paused: &mut bool,
+        last_health: Option<&HealthWithStartTime>,
+        agents_health: &mut HashMap<AgentID, HealthWithStartTime>,
+    ) -> ControlResponse {
+        info!(?command, "Executing control command");
+        let result = match command {
+            ControlCommand::Status => {
+                let agents: Vec<String> = current_dynamic_config
+                    .agents
+                    .keys()
+                    .map(|id| id.to_string())
+                    .collect();
+                return ControlResponse::with_result(serde_json::json!({
+                    "paused": *paused,
+                    "agents": agents,
+                }));
+            }
+            ControlCommand::ListAgents => {
+                let agents: Vec<_> = current_dynamic_config
+                    .agents
+                    .iter()
+                    .map(|(id, config)| {
+                        serde_json::json!({
+                            "id": id.to_string(),
+                            "agent_type": config.agent_type.to_string(),
+                        })
+                    })
+                    .collect();
+                return ControlResponse::with_result(serde_json::json!({
+                    "paused": *paused,
+                    "agents": agents,
+                }));
             }
+            ControlCommand::Health => {
+                let Some(health) = last_health else {
+                    return ControlResponse::failure("health not reported yet");
+                };
+                // Agents that didn't report their health yet are left out.
+                let agents: serde_json::Map<_, _> = current_dynamic_config
+                    .agents
+                    .keys()
+                    .filter_map(|id| {
+                        agents_health.get(id).map(|health| {
+                            (
+                                id.to_string(),
+                                serde_json::json!({
+                                    "healthy": health.is_healthy(),
+                                    "status": health.status(),
+                                    "last_error": health.last_error(),
+                                }),
+                            )
+                        })
+                    })
+                    .collect();
+                return ControlResponse::with_result(serde_json::json!({
+                    "healthy": health.is_healthy(),
+                    "status": health.status(),
+                    "last_error": health.last_error(),
+                    "agents": agents,
+                }));
+            }
+            ControlCommand::Reload | ControlCommand::Rollback if *paused => {
+                Err(AgentControlError::ControlCommand(
+                    "agents are paused, resume them first".to_string(),
+                ))
+            }
+            ControlCommand::Reload => {
+                match self.reload_dynamic_config(sub_agents, current_dynamic_config) {
+                    Ok(applied) => {
+                        // The health of the previous runs doesn't tell whether the new config works.
+                        applied.iter().for_each(|id| _ = agents_health.remove(id));
+                        let agents: Vec<String> = applied.iter().map(|id| id.to_string()).collect();
+                        return ControlResponse::with_result(serde_json::json!({
+                            "agents": agents,
+                        }));
+                    }
+                    Err(err) => Err(err),
+                }
+            }
+            ControlCommand::Rollback => self
+                .sa_dynamic_config_store
+                .delete()
+                .map_err(AgentControlError::from)
+                .and_then(|_| self.reload_dynamic_config(sub_agents, current_dynamic_config))
+                .map(|applied| applied.iter().for_each(|id| _ = agents_health.remove(id))),
+            ControlCommand::Pause => {
+                if !*paused {
+                    std::mem::take(sub_agents).stop();
+                    *paused = true;
+                    self.report_managed_agents(sub_agents);
+                }
+                Ok(())
+            }
+            ControlCommand::Resume => {
+                self.resume_sub_agents(sub_agents, current_dynamic_config, paused)
+            }
+            ControlCommand::SetLogLevel { .. } => Err(AgentControlError::ControlCommand(
+                "the log level is handled by the control socket".to_string(),
+            )),
+            ControlCommand::Signal { .. } => Err(AgentControlError::ControlCommand(
+                "signals are handled by the control socket".to_string(),
+            )),
+        };
+
+        match result {
+            Ok(()) => ControlResponse::ok(),
+            Err(err) => {
+                error!(error_msg = %err, "Error executing control command");
+                ControlResponse::failure(err)
+            }
+        }
+    }
+
+    /// Loads the persisted dynamic config again and applies the differences with the current one:
+    /// new agents are started, removed ones stopped and unchanged ones left untouched.
+    /// The configured log level is applied as well when a config loader was provided.
+    /// Returns the agents that were started or recreated.
+    fn reload_dynamic_config(
+        &self,
+        sub_agents: &mut StartedSubAgents<BuilderStartedSubAgent<S>>,
+        current_dynamic_config: &mut AgentControlDynamicConfig,
+    ) -> Result<Vec<AgentID>, AgentControlError> {
+        if let Some(config_loader) = &self.config_loader {
+            config_loader
+                .load()?
+                .log
+                .apply_level(&self.log_level_reloader)
+                .map_err(|err| AgentControlError::LogLevel(err.to_string()))?;
         }
+
+        let new_dynamic_config = self.sa_dynamic_config_store.load()?;
+        self.dynamic_config_validator
+            .validate(&new_dynamic_config)
+            .map_err(|err| AgentControlError::RemoteConfigValidator(err.to_string()))?;
+
+        let applied = new_dynamic_config
+            .agents
+            .iter()
+            .filter(|(id, config)| current_dynamic_config.agents.get(*id) != Some(*config))
+            .map(|(id, _)| id.clone())
+            .collect();
+        self.apply_remote_config_agents(current_dynamic_config, &new_dynamic_config, sub_agents)?;
+        *current_dynamic_config = new_dynamic_config;
+
+        if let Some(opamp_client) = &self.opamp_client {
+            opamp_client.update_effective_config()?;
+        }
+        Ok(applied)
+    }
+
+    /// Stages the packages offered by the OpAMP server and, if any of them changed, recreates the
+    /// sub-agents so the ones using them install the offered packages.
+    fn handle_packages_available(
+        &self,
+        packages_available: &PackagesAvailable,
+        sub_agents: &mut StartedSubAgents<BuilderStartedSubAgent<S>>,
+        current_dynamic_config: &AgentControlDynamicConfig,
+    ) {
+        let Some(packages_syncer) = &self.packages_syncer else {
+            debug!("Ignoring the packages offered, accepting packages is disabled");
+            return;
+        };
+
+        let synced = packages_syncer.sync(packages_available);
+        for status in synced.statuses.packages.values() {
+            if status.status == i32::from(PackageStatusEnum::InstallFailed) {
+                warn!(
+                    package = status.name,
+                    version = status.server_offered_version,
+                    "Offered package not installed: {}",
+                    status.error_message
+                );
+            }
+        }
+        if !synced.updated {
+            return;
+        }
+
+        for (agent_id, agent_config) in &current_dynamic_config.agents {
+            let agent_identity = AgentIdentity::from((agent_id, &agent_config.agent_type));
+            // Agents that failed to start are not running, they are started too.
+            let _ = sub_agents.stop_and_remove(agent_id);
+            let _ = self
+                .build_and_run_sub_agent(&agent_identity, sub_agents)
+                .inspect_err(|err| {
+                    error!(%agent_id, error_msg = %err, "Error starting agent with the offered packages")
+                });
+        }
+    }
+
+    /// Starts again the sub-agents of the current config if they were paused.
+    fn resume_sub_agents(
+        &self,
+        sub_agents: &mut StartedSubAgents<BuilderStartedSubAgent<S>>,
+        current_dynamic_config: &AgentControlDynamicConfig,
+        paused: &mut bool,
+    ) -> Result<(), AgentControlError> {
+        if !*paused {
+            return Ok(());
+        }
+        let (running_sub_agents, result) =
+            self.build_and_run_sub_agents(&current_dynamic_config.agents);
+        *sub_agents = running_sub_agents;
+        *paused = false;
+        self.report_managed_agents(sub_agents);
+        result
+    }
+
+    /// Reports the instance ids of the running sub-agents as an Agent Control attribute, so the
+    /// backend can relate Agent Control to the agents it manages.
+    fn report_managed_agents(&self, sub_agents: &StartedSubAgents<BuilderStartedSubAgent<S>>) {
+        let Some(instance_id_getter) = &self.instance_id_getter else {
+            return;
+        };
+        let mut managed_agents: Vec<String> = sub_agents
+            .agent_ids()
+            .filter_map(|agent_id| {
+                instance_id_getter
+                    .get(agent_id)
+                    .inspect_err(
+                        |err| warn!(%agent_id, "Could not get the agent instance id: {err}"),
+                    )
+                    .ok()
+                    .map(|instance_id| format!("{agent_id}={instance_id}"))
+            })
+            .collect();
+        managed_agents.sort();
+
+        publish_update_attributes_event(
+            &self.agent_control_internal_publisher,
+            AgentControlInternalEvent::AgentControlAttributesUpdated(AgentDescription {
+                non_identifying_attributes: HashMap::from([(
+                    MANAGED_AGENTS_ATTRIBUTE_KEY.to_string(),
+                    managed_agents.join(",").into(),
+                )]),
+                ..Default::default()
+            }),
+        );
     }
 
     /// Agent Control on remote config
@@ -419,11 +922,25 @@ where
             opamp_client,
         )?;
 
-        match self.validate_apply_store_remote_config(
+        if let Some(tracker) = &self.config_apply_tracker {
+            let previous = self.sa_dynamic_config_store.get_remote_config()?;
+            let _ = tracker
+                .mark(&ConfigApplyInProgress::Remote { previous })
+                .inspect_err(|err| warn!("Could not mark the config apply as in progress: {err}"));
+        }
+        let apply_result = self.validate_apply_store_remote_config(
             &opamp_remote_config,
             sub_agents,
             current_dynamic_config,
-        ) {
+        );
+        // The config is either applied or discarded, a restart no longer leaves it half-applied.
+        if let Some(tracker) = &self.config_apply_tracker {
+            let _ = tracker
+                .clear()
+                .inspect_err(|err| warn!("Could not clear the in-progress config apply: {err}"));
+        }
+
+        match apply_result {
             // Remote config partially applied, the config was stored so it needs to be updated to fail state.
             Err(AgentControlError::BuildingSubagents(err)) => {
                 let error_message =
@@ -438,6 +955,16 @@ where
             // Remote config failed to apply, the config was not stored.
             Err(err) => {
                 let error_message = format!("Error applying Agent Control remote config: {err}");
+                self.lifecycle_hooks.notify(LifecycleEvent::ConfigFailed {
+                    agent_id: AGENT_CONTROL_ID.to_string(),
+                    hash: opamp_remote_config.hash.to_string(),
+                    error_message: error_message.clone(),
+                });
+                self.agent_control_publisher
+                    .broadcast(AgentControlEvent::ConfigFailed(
+                        opamp_remote_config.hash.clone(),
+                        error_message.clone(),
+                    ));
                 let config_state = ConfigState::Failed { error_message };
                 report_state(config_state, opamp_remote_config.hash, opamp_client)?;
                 Err(err)
@@ -445,6 +972,18 @@ where
             Ok(new_dynamic_config) => {
                 self.sa_dynamic_config_store
                     .update_state(ConfigState::Applied)?;
+                self.audit_trail.record(AuditEvent::ConfigApplied {
+                    agent_id: AGENT_CONTROL_ID.to_string(),
+                    hash: opamp_remote_config.hash.to_string(),
+                });
+                self.lifecycle_hooks.notify(LifecycleEvent::ConfigApplied {
+                    agent_id: AGENT_CONTROL_ID.to_string(),
+                    hash: opamp_remote_config.hash.to_string(),
+                });
+                self.agent_control_publisher
+                    .broadcast(AgentControlEvent::ConfigApplied(
+                        opamp_remote_config.hash.clone(),
+                    ));
                 report_state(ConfigState::Applied, opamp_remote_config.hash, opamp_client)?;
                 opamp_client.update_effective_config()?;
                 Ok(new_dynamic_config)
@@ -575,6 +1114,7 @@ where
             self.agent_control_publisher
                 .broadcast(AgentControlEvent::SubAgentRemoved(agent_id.clone()));
         }
+        self.report_managed_agents(running_sub_agents);
 
         if !errors.is_empty() {
             Err(AgentControlError::BuildingSubagents(errors))
@@ -605,25 +1145,34 @@ mod tests {
     use super::AgentControl;
     use super::agent_id::AgentID;
     use super::config::{AgentControlConfig, AgentControlDynamicConfig};
+    use super::config_repository::in_progress::{ConfigApplyInProgress, ConfigApplyTracker};
     use super::config_repository::repository::AgentControlDynamicConfigRepository;
     use super::config_repository::repository::tests::InMemoryAgentControlDynamicConfigRepository;
     use super::config_validator::tests::TestDynamicConfigValidator;
+    use super::control_socket::protocol::{ControlCommand, ControlRequest, ControlResponse};
     use super::error::AgentControlError;
     use super::resource_cleaner::tests::MockResourceCleaner;
     use super::version_updater::updater::UpdaterError;
     use super::version_updater::updater::tests::MockVersionUpdater;
+    use crate::agent_control::defaults::MANAGED_AGENTS_ATTRIBUTE_KEY;
     use crate::agent_control::health_checker::AgentControlHealthCheckerConfig;
     use crate::agent_type::agent_type_id::AgentTypeID;
-    use crate::checkers::health::health_checker::Unhealthy;
     use crate::checkers::health::health_checker::tests::MockHealthCheck;
+    use crate::checkers::health::health_checker::{Healthy, Unhealthy};
     use crate::checkers::health::with_start_time::HealthWithStartTime;
     use crate::event::broadcaster::unbounded::UnboundedBroadcast;
     use crate::event::channel::{EventConsumer, EventPublisher, pub_sub};
-    use crate::event::{AgentControlEvent, ApplicationEvent, OpAMPEvent};
+    use crate::event::{
+        AgentControlEvent, AgentControlInternalEvent, ApplicationEvent, OpAMPEvent, SubAgentEvent,
+    };
     use crate::opamp::client_builder::tests::MockStartedOpAMPClient;
+    use crate::opamp::instance_id::InstanceID;
+    use crate::opamp::instance_id::getter::tests::MockInstanceIDGetter;
+    use crate::opamp::instance_id::storer::StorerError;
     use crate::opamp::remote_config::hash::{ConfigState, Hash};
     use crate::opamp::remote_config::validators::tests::TestRemoteConfigValidator;
     use crate::opamp::remote_config::{AGENT_CONFIG_PREFIX, ConfigurationMap, OpampRemoteConfig};
+    use crate::package::syncer::SyncedPackages;
     use crate::sub_agent::collection::StartedSubAgents;
     use crate::sub_agent::error::SubAgentBuilderError;
     use crate::sub_agent::identity::AgentIdentity;
@@ -636,9 +1185,10 @@ mod tests {
     use mockall::{Sequence, predicate};
     use opamp_client::opamp::proto::RemoteConfigStatus;
     use opamp_client::opamp::proto::RemoteConfigStatuses::{Applied, Applying, Failed};
+    use opamp_client::operation::settings::AgentDescription;
     use rstest::rstest;
     use std::collections::{HashMap, HashSet};
-    use std::sync::Arc;
+    use std::sync::{Arc, Mutex};
     use std::thread::{sleep, spawn};
     use std::time::{Duration, SystemTime};
 
@@ -655,6 +1205,36 @@ mod tests {
         fn(SystemTime) -> Option<MockHealthCheck>,
     >;
 
+    /// Records the markers written around the config applies, `None` for the removed ones.
+    #[derive(Default)]
+    struct TestConfigApplyTracker(Mutex<Vec<Option<ConfigApplyInProgress>>>);
+
+    impl ConfigApplyTracker for TestConfigApplyTracker {
+        fn mark(&self, in_progress: &ConfigApplyInProgress) -> Result<(), StorerError> {
+            self.0.lock().unwrap().push(Some(in_progress.clone()));
+            Ok(())
+        }
+
+        fn clear(&self) -> Result<(), StorerError> {
+            self.0.lock().unwrap().push(None);
+            Ok(())
+        }
+    }
+
+    /// Reports the offered packages as staged, `updated` telling whether any of them changed.
+    struct TestPackagesSyncer {
+        updated: bool,
+    }
+
+    impl PackagesSyncer for TestPackagesSyncer {
+        fn sync(&self, _packages_available: &PackagesAvailable) -> SyncedPackages {
+            SyncedPackages {
+                updated: self.updated,
+                ..Default::default()
+            }
+        }
+    }
+
     /// Holds test data to interact with AC events and perform particular assertions in tests
     struct TestData {
         channels: Channels,
@@ -1233,6 +1813,9 @@ agents:
             client.should_update_effective_config(1);
         });
 
+        let tracker = Arc::new(TestConfigApplyTracker::default());
+        let agent_control = agent_control.with_config_apply_tracker(tracker.clone());
+
         let result = agent_control.handle_remote_config(
             opamp_remote_config,
             &mut running_sub_agents,
@@ -1244,6 +1827,15 @@ agents:
             assert_eq!(dynamic_config.agents.into_iter().next().unwrap().0, local_identities[0].id);
         });
         t.assert_no_persisted_remote_config();
+        // The previous remote config is recorded while applying, then the marker is removed.
+        let markers = tracker.0.lock().unwrap();
+        assert_matches!(
+            markers.as_slice(),
+            [
+                Some(ConfigApplyInProgress::Remote { previous: Some(_) }),
+                None
+            ]
+        );
     }
 
     #[test]
@@ -1483,6 +2075,48 @@ chart_version: 0.0.2 # not actually used, we rely on a mock
 
         assert_matches!(result, Err(AgentControlError::RemoteConfigValidator(_)));
         t.assert_no_persisted_remote_config();
+        assert_matches!(
+            t.channels.broadcast_subscriber.as_ref().try_recv(),
+            Ok(AgentControlEvent::ConfigFailed(hash, _)) if hash == opamp_remote_config.hash
+        );
+    }
+
+    #[rstest]
+    #[case::updated(true)]
+    #[case::unchanged(false)]
+    fn test_handle_packages_available(#[case] updated: bool) {
+        let (t, agent_control) = TestAgentControl::setup();
+        let mut agent_control =
+            agent_control.with_packages_syncer(Arc::new(TestPackagesSyncer { updated }));
+        agent_control.set_noop_updater();
+
+        let current_config: &str = r#"
+agents:
+  id1:
+    agent_type: "newrelic/remote.example.a:0.0.1"
+  id2:
+    agent_type: "newrelic/remote.example.b:0.0.2"
+        "#;
+        let (current_dynamic_config, mut running_sub_agents) =
+            t.build_current_config_and_sub_agents(current_config);
+        let stops = if updated { 1 } else { 0 };
+        running_sub_agents.agents().values_mut().for_each(|agent| {
+            agent.expect_stop().times(stops).returning(|| Ok(()));
+        });
+        if updated {
+            // Agents are recreated so they install the staged packages
+            agent_control.set_sub_agent_build_success_no_stop(
+                t.identities_from_agents_config(current_config),
+            );
+        }
+
+        agent_control.handle_packages_available(
+            &PackagesAvailable::default(),
+            &mut running_sub_agents,
+            &current_dynamic_config,
+        );
+
+        assert_eq!(running_sub_agents.agents().len(), 2);
     }
 
     #[test]
@@ -1633,6 +2267,178 @@ chart_version: 0.0.2 # not actually used, we rely on a mock
         assert_eq!(expected, ev);
     }
 
+    #[test]
+    fn test_process_events_control_pause_and_resume() {
+        let (t, mut agent_control) = TestAgentControl::setup();
+        agent_control.set_noop_resource_cleaner();
+        agent_control.set_noop_updater();
+        agent_control.set_initial_config_local(TestData::SINGLE_AGENT_CONFIG.to_string());
+        let identities = t.identities_from_agents_config(TestData::SINGLE_AGENT_CONFIG);
+        // built again on resume and stopped on shutdown
+        agent_control.set_sub_agent_build_success(identities.clone());
+
+        // the running sub agent, stopped on pause
+        let mut sub_agent = MockStartedSubAgent::new();
+        sub_agent.should_stop();
+        let sub_agents =
+            StartedSubAgents::from(HashMap::from([(identities[0].id.clone(), sub_agent)]));
+
+        let (control_publisher, control_consumer) = pub_sub();
+        let agent_control = agent_control.with_control_consumer(control_consumer);
+        let event_processor = spawn(move || {
+            agent_control.process_events(sub_agents);
+        });
+
+        let send = |command| {
+            let (request, response) = ControlRequest::new(command);
+            control_publisher.publish(request).unwrap();
+            response.recv().unwrap()
+        };
+
+        assert_eq!(send(ControlCommand::Pause), ControlResponse::ok());
+        let status = send(ControlCommand::Status).result.unwrap();
+        assert_eq!(status["paused"], true);
+        assert_eq!(status["agents"][0], identities[0].id.to_string());
+        assert!(!send(ControlCommand::Reload).ok);
+        assert!(!send(ControlCommand::Health).ok);
+        let agents = send(ControlCommand::ListAgents).result.unwrap();
+        assert_eq!(
+            agents["agents"][0]["agent_type"],
+            identities[0].agent_type_id.to_string()
+        );
+        assert_eq!(send(ControlCommand::Resume), ControlResponse::ok());
+        let status = send(ControlCommand::Status).result.unwrap();
+        assert_eq!(status["paused"], false);
+
+        t.publish_stop_event();
+        assert!(event_processor.join().is_ok());
+    }
+
+    #[test]
+    fn test_process_events_control_health_reports_agents() {
+        let (t, mut agent_control) = TestAgentControl::setup();
+        agent_control.set_noop_resource_cleaner();
+        agent_control.set_noop_updater();
+        agent_control.set_opamp_expectations(|client| {
+            client.expect_set_health().returning(|_| Ok(()));
+        });
+        agent_control.set_initial_config_local(TestData::SINGLE_AGENT_CONFIG.to_string());
+        let identities = t.identities_from_agents_config(TestData::SINGLE_AGENT_CONFIG);
+
+        let mut sub_agent = MockStartedSubAgent::new();
+        sub_agent.should_stop();
+        let sub_agents =
+            StartedSubAgents::from(HashMap::from([(identities[0].id.clone(), sub_agent)]));
+
+        let (control_publisher, control_consumer) = pub_sub();
+        let (sub_agent_publisher, sub_agent_consumer) = pub_sub();
+        let internal_publisher = agent_control.agent_control_internal_publisher.clone();
+        let agent_control = agent_control
+            .with_control_consumer(control_consumer)
+            .with_sub_agent_consumer(sub_agent_consumer);
+        let event_processor = spawn(move || agent_control.process_events(sub_agents));
+
+        internal_publisher
+            .publish(AgentControlInternalEvent::HealthUpdated(
+                HealthWithStartTime::new(Healthy::new().into(), SystemTime::UNIX_EPOCH),
+            ))
+            .unwrap();
+        sub_agent_publisher
+            .publish(SubAgentEvent::HealthUpdated(
+                identities[0].clone(),
+                HealthWithStartTime::new(
+                    Unhealthy::new("exited".to_string()).into(),
+                    SystemTime::UNIX_EPOCH,
+                ),
+            ))
+            .unwrap();
+        // The events are processed in any order along with the control requests.
+        sleep(Duration::from_millis(50));
+
+        let (request, response) = ControlRequest::new(ControlCommand::Health);
+        control_publisher.publish(request).unwrap();
+        let health = response.recv().unwrap().result.unwrap();
+        assert_eq!(health["healthy"], true);
+        let agent_health = &health["agents"][identities[0].id.to_string()];
+        assert_eq!(agent_health["healthy"], false);
+        assert_eq!(agent_health["last_error"], "exited");
+
+        t.publish_stop_event();
+        assert!(event_processor.join().is_ok());
+    }
+
+    #[test]
+    fn test_process_events_reload_requested() {
+        let (t, mut agent_control) = TestAgentControl::setup();
+        agent_control.set_noop_resource_cleaner();
+        agent_control.set_noop_updater();
+        agent_control.set_opamp_expectations(|client| {
+            client.should_update_effective_config(1);
+        });
+        agent_control.set_initial_config_local(TestData::SINGLE_AGENT_CONFIG.to_string());
+        let identities = t.identities_from_agents_config(TestData::SINGLE_AGENT_CONFIG);
+
+        // the running sub agent, removed from the reloaded config
+        let mut sub_agent = MockStartedSubAgent::new();
+        sub_agent.should_stop();
+        let sub_agents =
+            StartedSubAgents::from(HashMap::from([(identities[0].id.clone(), sub_agent)]));
+
+        let event_processor = spawn(move || agent_control.process_events(sub_agents));
+
+        t.dyn_config_store
+            .values_repository
+            .store_local(
+                &AgentID::AgentControl,
+                &YAMLConfig::try_from("agents: {}").unwrap(),
+            )
+            .unwrap();
+        t.channels
+            .app_publisher
+            .publish(ApplicationEvent::ReloadRequested)
+            .unwrap();
+
+        let expected = AgentControlEvent::SubAgentRemoved(identities[0].id.clone());
+        let ev = t.channels.broadcast_subscriber.as_ref().recv().unwrap();
+        assert_eq!(expected, ev);
+
+        t.publish_stop_event();
+        assert_eq!(
+            event_processor.join().unwrap(),
+            GracefulShutdownReason::ExternalRequested
+        );
+    }
+
+    #[test]
+    fn test_process_events_sub_agent_crash_looped() {
+        let (t, mut agent_control) = TestAgentControl::setup();
+        agent_control.set_noop_resource_cleaner();
+        agent_control.set_noop_updater();
+        let agent_id = AgentID::try_from("crashing-agent").unwrap();
+
+        let mut sub_agent = MockStartedSubAgent::new();
+        sub_agent.should_stop();
+        let sub_agents = StartedSubAgents::from(HashMap::from([(agent_id.clone(), sub_agent)]));
+
+        let internal_publisher = agent_control.agent_control_internal_publisher.clone();
+        let event_processor = spawn(move || agent_control.process_events(sub_agents));
+
+        internal_publisher
+            .publish(AgentControlInternalEvent::SubAgentCrashLooped(
+                agent_id.clone(),
+            ))
+            .unwrap();
+
+        assert_eq!(
+            t.channels.broadcast_subscriber.as_ref().recv().unwrap(),
+            AgentControlEvent::AgentControlStopped
+        );
+        assert_eq!(
+            event_processor.join().unwrap(),
+            GracefulShutdownReason::SubAgentCrashLoop(agent_id)
+        );
+    }
+
     // Having one running sub agent, receive a valid config with no agents
     // and we assert on Agent Control Healthy event
     // And it should publish SubAgentRemoved
@@ -1744,4 +2550,38 @@ agents:
             assert!(config.state.is_applied());
         });
     }
+
+    #[test]
+    fn test_report_managed_agents() {
+        let (_t, agent_control) = TestAgentControl::setup();
+        let infra_agent_id = AgentID::try_from("infra-agent").unwrap();
+        let nrdot_id = AgentID::try_from("nrdot").unwrap();
+        let infra_agent_instance_id = InstanceID::create();
+        let nrdot_instance_id = InstanceID::create();
+
+        let mut instance_id_getter = MockInstanceIDGetter::new();
+        instance_id_getter.should_get(&infra_agent_id, infra_agent_instance_id.clone());
+        instance_id_getter.should_get(&nrdot_id, nrdot_instance_id.clone());
+        let agent_control = agent_control.with_instance_id_getter(Arc::new(instance_id_getter));
+
+        let sub_agents = StartedSubAgents::from(HashMap::from([
+            (nrdot_id, MockStartedSubAgent::new()),
+            (infra_agent_id, MockStartedSubAgent::new()),
+        ]));
+        agent_control.report_managed_agents(&sub_agents);
+
+        let expected = AgentControlInternalEvent::AgentControlAttributesUpdated(AgentDescription {
+            non_identifying_attributes: HashMap::from([(
+                MANAGED_AGENTS_ATTRIBUTE_KEY.to_string(),
+                format!("infra-agent={infra_agent_instance_id},nrdot={nrdot_instance_id}").into(),
+            )]),
+            ..Default::default()
+        });
+        let event = agent_control
+            .agent_control_internal_consumer
+            .as_ref()
+            .try_recv()
+            .unwrap();
+        assert_eq!(event, expected);
+    }
 }
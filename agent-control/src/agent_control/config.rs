@@ -4,20 +4,43 @@
 use super::agent_id::AgentID;
 use super::http_server::config::ServerConfig;
 use super::uptime_report::UptimeReportConfig;
+use crate::agent_control::control_socket::config::ControlSocketConfig;
+use crate::agent_control::credentials::Credentials;
 use crate::agent_control::defaults::{
     AC_OCI_AGENT_TYPES_DEFAULT_REPOSITORY, AC_OCI_AGENT_TYPES_PUBLIC_KEY_URL,
     AC_OCI_DEFAULT_REGISTRY, AC_OCI_PACKAGE_DEFAULT_REPOSITORY, AC_OCI_PACKAGE_PUBLIC_KEY_URL,
+    REMOTE_CONFIG_MAX_SIZE_BYTES,
 };
+use crate::agent_control::feature_flags::FeatureFlags;
 use crate::agent_control::health_checker::AgentControlHealthCheckerConfig;
 use crate::agent_type::runtime_config::on_host::package::rendered::{Repository, Version};
 use crate::agent_type::variable::constraints::VariableConstraints;
-use crate::http::config::ProxyConfig;
+use crate::audit::AuditConfig;
+use crate::cli::common::region::Region;
+use crate::http::config::{EgressConfig, ProxyConfig, TlsConfig};
+use crate::http::dns::DnsConfig;
+use crate::http::exchange_log::ExchangeLogConfig;
+use crate::http::pinning::PublicKeyPin;
+use crate::instrumentation::agent_logs::AgentLogsConfig;
 use crate::instrumentation::config::logs::config::LoggingConfig;
+use crate::lifecycle_hooks::LifecycleHookConfig;
 use crate::opamp::auth::config::AuthConfig;
+use crate::opamp::auth::enrollment::EnrollmentConfig;
 use crate::opamp::client_builder::PollInterval;
+use crate::opamp::http::signing::RequestSigningConfig;
+use crate::opamp::network_wait::NetworkWaitConfig;
 use crate::opamp::remote_config::OpampRemoteConfig;
+use crate::opamp::remote_config::validators::fluent_bit::FluentBitPluginsPolicy;
+use crate::opamp::remote_config::validators::otel_components::OtelComponentsPolicy;
 use crate::opamp::remote_config::validators::signature::validator::SignatureValidatorConfig;
 use crate::secrets_provider::SecretsProvidersConfig;
+use crate::sub_agent::config_verification::RemoteConfigStatusConfig;
+use crate::sub_agent::on_host::command::allow_list::ExecutableAllowList;
+use crate::sub_agent::on_host::crash::CrashReportsConfig;
+use crate::sub_agent::on_host::default_exporter::DefaultExporterConfig;
+use crate::sub_agent::on_host::process_watch::ProcessWatchConfig;
+use crate::sub_agent::on_host::restart_limiter::RestartStormConfig;
+use crate::sub_agent::watchdog::WatchdogConfig;
 use crate::utils::retry::BackoffPolicy;
 use crate::values::yaml_config::YAMLConfig;
 use crate::{
@@ -28,9 +51,10 @@ use http::HeaderMap;
 use kube::api::TypeMeta;
 use oci_client::secrets::RegistryAuth;
 use serde::{Deserialize, Deserializer, Serialize, de};
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 use std::fmt::Display;
 use std::num::NonZeroUsize;
+use std::path::PathBuf;
 use std::str::FromStr;
 use std::time::Duration;
 use thiserror::Error;
@@ -49,6 +73,12 @@ pub struct AgentControlConfig {
     #[serde(default)]
     pub host_id: String,
 
+    /// Resource attributes, like `deployment.environment`, added to the telemetry of the on-host
+    /// OpenTelemetry collectors next to the ones Agent Control detects. They are reported as
+    /// non-identifying attributes of Agent Control too.
+    #[serde(default)]
+    pub resource_attributes: BTreeMap<String, String>,
+
     /// this is the only part of the config that can be changed with opamp.
     #[serde(flatten)]
     pub dynamic: AgentControlDynamicConfig,
@@ -64,10 +94,22 @@ pub struct AgentControlConfig {
     #[serde(default)]
     pub server: ServerConfig,
 
+    /// Local control API (Unix socket) configuration.
+    #[serde(default)]
+    pub control_socket: ControlSocketConfig,
+
     /// Proxy configuration for outbound connections.
     #[serde(default)]
     pub proxy: ProxyConfig,
 
+    /// Interface or source address the OpAMP connections are bound to.
+    #[serde(default)]
+    pub egress: EgressConfig,
+
+    /// Logging of the exchanges with Fleet Control and the OCI registries.
+    #[serde(default)]
+    pub http_exchange_log: ExchangeLogConfig,
+
     /// Self-instrumentation (telemetry about Agent Control itself) configuration.
     #[serde(default)]
     pub self_instrumentation: InstrumentationConfig,
@@ -80,6 +122,10 @@ pub struct AgentControlConfig {
     #[serde(default)]
     pub health_check: AgentControlHealthCheckerConfig,
 
+    /// Watchdog of the sub-agent runtimes. See [crate::sub_agent::watchdog].
+    #[serde(default)]
+    pub watchdog: WatchdogConfig,
+
     /// A "key-value store" intended to modify agent type definitions, loaded at start time.
     #[serde(default)]
     pub agent_type_var_constraints: VariableConstraints,
@@ -104,6 +150,106 @@ pub struct AgentControlConfig {
     /// Reuses the global `oci` registry/auth; see [AgentTypeConfig].
     #[serde(default)]
     pub agent_types: AgentTypeConfig,
+
+    /// Feature flags gating risky behaviors, they can be overridden remotely.
+    /// See [crate::agent_control::feature_flags].
+    #[serde(default)]
+    pub feature_flags: FeatureFlags,
+
+    /// Release channel of the host, reported to Fleet Control so it can target early builds of
+    /// packages and configurations to it.
+    #[serde(default)]
+    pub release_channel: ReleaseChannel,
+
+    /// New Relic credentials shared with the sub-agents.
+    #[serde(default)]
+    pub credentials: Credentials,
+
+    /// Storage (filesystem) configuration.
+    #[serde(default)]
+    pub storage: StorageConfig,
+
+    /// Audit trail configuration. See [crate::audit].
+    #[serde(default)]
+    pub audit: AuditConfig,
+
+    /// Hooks notified on lifecycle events. See [crate::lifecycle_hooks].
+    #[serde(default)]
+    pub lifecycle_hooks: Vec<LifecycleHookConfig>,
+
+    /// Glob patterns of the executable paths on-host sub-agents may run. Empty allows any path.
+    #[serde(default)]
+    pub allowed_executables: ExecutableAllowList,
+
+    /// OpenTelemetry collector components the remote configs of on-host collectors can enable.
+    /// See [crate::opamp::remote_config::validators::otel_components].
+    #[serde(default)]
+    pub otel_components: OtelComponentsPolicy,
+
+    /// Fluent Bit plugins the remote configs of on-host Fluent Bit agents can use.
+    /// See [crate::opamp::remote_config::validators::fluent_bit].
+    #[serde(default)]
+    pub fluent_bit_plugins: FluentBitPluginsPolicy,
+
+    /// New Relic exporter added to the on-host collector configs without exporters.
+    /// See [crate::sub_agent::on_host::default_exporter].
+    #[serde(default)]
+    pub otel_default_exporter: DefaultExporterConfig,
+
+    /// Agent log files forwarded through the self-instrumentation endpoint.
+    /// See [crate::instrumentation::agent_logs].
+    #[serde(default)]
+    pub agent_logs: AgentLogsConfig,
+
+    /// Crash reports of the on-host executables. See [crate::sub_agent::on_host::crash].
+    #[serde(default)]
+    pub crash_reports: CrashReportsConfig,
+
+    /// Watch of the on-host processes started outside Agent Control.
+    /// See [crate::sub_agent::on_host::process_watch].
+    #[serde(default)]
+    pub process_watch: ProcessWatchConfig,
+
+    /// Protection against restart storms of the on-host executables.
+    /// See [crate::sub_agent::on_host::restart_limiter].
+    #[serde(default)]
+    pub restart_storm_protection: RestartStormConfig,
+
+    /// Remote configuration status reported to Fleet Control.
+    /// See [crate::sub_agent::config_verification].
+    #[serde(default)]
+    pub remote_config_status: RemoteConfigStatusConfig,
+}
+
+/// Release channel a host is subscribed to. Reported as an identifying attribute of Agent Control
+/// and its sub-agents.
+#[derive(Debug, Default, Deserialize, Serialize, PartialEq, Eq, Clone, Copy)]
+#[serde(rename_all = "lowercase")]
+pub enum ReleaseChannel {
+    /// Generally available builds.
+    #[default]
+    Stable,
+    /// Pre-release builds.
+    Beta,
+    /// Earliest builds, rolled out before any other channel.
+    Canary,
+}
+
+impl ReleaseChannel {
+    /// Name of the channel, as reported to Fleet Control.
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Stable => "stable",
+            Self::Beta => "beta",
+            Self::Canary => "canary",
+        }
+    }
+}
+
+impl Display for ReleaseChannel {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(self.as_str())
+    }
 }
 
 /// Configuration for the on-host self-update mechanism.
@@ -282,6 +428,57 @@ impl From<&UpgradeBackoffConfig> for BackoffPolicy {
 pub struct PackagesConfig {
     /// Indicates whether package signature verification is enabled or not
     pub signature_verification_enabled: SignatureVerificationEnabled,
+    /// Whether packages whose signature can't be verified are rejected or downloaded anyway
+    #[serde(default)]
+    pub signature_verification_mode: SignatureVerificationMode,
+    /// Local JWKS file holding the keys trusted to sign packages, in addition to the keys of the
+    /// package public key url
+    #[serde(default)]
+    pub trusted_keys_path: Option<PathBuf>,
+    /// Whether the packages offered by Fleet Control through OpAMP are downloaded and installed
+    #[serde(default)]
+    pub accept_offered_packages: bool,
+}
+
+/// What to do with artifacts whose signature can't be verified.
+#[derive(Debug, Default, Deserialize, Clone, Copy, PartialEq)]
+#[serde(rename_all = "lowercase")]
+pub enum SignatureVerificationMode {
+    /// Reject unsigned or tampered artifacts.
+    #[default]
+    Strict,
+    /// Log a warning and use the artifact anyway. Meant to roll out signing without disruption.
+    Permissive,
+}
+
+const DEFAULT_IO_TIMEOUT: Duration = Duration::from_secs(60);
+
+/// Maximum duration of a filesystem operation.
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_IO_TIMEOUT)]
+pub struct IoTimeout(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// Configuration of the storage holding the Agent Control and agents data.
+#[derive(Debug, Default, Deserialize, Clone, PartialEq)]
+#[serde(default)]
+pub struct StorageConfig {
+    /// Timeout of filesystem operations, so a hung mount (e.g. NFS) surfaces as an error instead
+    /// of blocking Agent Control.
+    pub io_timeout: IoTimeout,
+    /// Writable directory holding all the data and logs written by Agent Control, for hosts whose
+    /// root filesystem is read-only. See [crate::agent_control::run::BasePaths::with_writable_root].
+    pub writable_root: Option<PathBuf>,
+    /// Directory, usually on a tmpfs mount, holding the files rendered for each sub-agent. They are
+    /// rendered again from the stored configs when the sub-agents start, so they don't need to
+    /// persist. See [crate::agent_control::run::BasePaths::with_ephemeral_dir].
+    pub ephemeral_dir: Option<PathBuf>,
+}
+
+impl StorageConfig {
+    /// Timeout of filesystem operations.
+    pub fn io_timeout(&self) -> Duration {
+        self.io_timeout.0
+    }
 }
 
 /// OCI registry configuration shared by Agent Control and agent package pulls.
@@ -480,6 +677,9 @@ pub struct AgentControlDynamicConfig {
         deserialize_with = "deserialize_chart_version"
     )]
     pub cd_chart_version: Option<String>,
+    /// Remote overrides of the locally configured feature flags.
+    #[serde(skip_serializing_if = "FeatureFlags::is_empty", default)]
+    pub feature_flag_overrides: FeatureFlags,
 }
 
 /// This implementation reads all configuration entries whose keys start with
@@ -511,6 +711,16 @@ pub struct AgentControlDynamicConfig {
 impl TryFrom<&OpampRemoteConfig> for AgentControlDynamicConfig {
     type Error = AgentControlConfigError;
     fn try_from(value: &OpampRemoteConfig) -> Result<Self, Self::Error> {
+        let size = value
+            .agent_configs_iter()
+            .map(|(_, content)| content.len())
+            .sum::<usize>();
+        if size > REMOTE_CONFIG_MAX_SIZE_BYTES {
+            return Err(AgentControlConfigError(format!(
+                "config of {size} bytes exceeds the maximum size of {REMOTE_CONFIG_MAX_SIZE_BYTES} bytes"
+            )));
+        }
+
         let mut merged_agents = SubAgentsMap::new();
         let mut remaining_config = YAMLConfig::try_from(&AgentControlDynamicConfig::default())
             .map_err(|err| {
@@ -532,7 +742,7 @@ impl TryFrom<&OpampRemoteConfig> for AgentControlDynamicConfig {
                 })?;
             }
 
-            remaining_config = YAMLConfig::try_append(remaining_config.clone(), yaml_configuration)
+            remaining_config = YAMLConfig::try_append(remaining_config, yaml_configuration)
                 .map_err(|err| AgentControlConfigError(format!("appending config: {err}")))?;
         }
 
@@ -642,7 +852,7 @@ pub struct AuthSecret {
 /// Configuration for the OpAMP (Fleet Control) client.
 #[derive(Debug, PartialEq, Clone)]
 pub struct OpAMPClientConfig {
-    /// OpAMP server endpoint.
+    /// OpAMP server endpoint. Defaults to the one of the configured `region`.
     pub endpoint: Url,
     /// Poll interval for the OpAMP client.
     pub poll_interval: PollInterval,
@@ -650,10 +860,25 @@ pub struct OpAMPClientConfig {
     pub headers: HeaderMap,
     /// Authentication configuration for the OpAMP communications.
     pub auth_config: Option<AuthConfig>,
+    /// Enrollment of the host with an install token, used when `auth_config` is not set.
+    pub enrollment: Option<EnrollmentConfig>,
     /// Unique identifier for the fleet in which the super agent will join upon initialization.
     pub fleet_id: String,
     /// Contains the signature_validation configuration
     pub signature_validation: SignatureValidatorConfig,
+    /// Boot-time wait for network availability before connecting.
+    pub network_wait: NetworkWaitConfig,
+    /// DNS caching and pinning for the OpAMP endpoint.
+    pub dns: DnsConfig,
+    /// Public keys the certificate chain of the OpAMP endpoint must contain one of. Empty
+    /// disables pinning.
+    pub pinned_public_keys: Vec<PublicKeyPin>,
+    /// HMAC signing of the OpAMP requests. Requests are not signed if not set.
+    pub request_signing: Option<RequestSigningConfig>,
+    /// TLS settings of the connection to the OpAMP endpoint.
+    pub tls: TlsConfig,
+    /// Proxy for the OpAMP communications, used instead of the Agent Control `proxy` if set.
+    pub proxy: Option<ProxyConfig>,
 }
 
 impl<'de> Deserialize<'de> for OpAMPClientConfig {
@@ -664,7 +889,10 @@ impl<'de> Deserialize<'de> for OpAMPClientConfig {
         // intermediate serialization type to validate `default` and `required` fields
         #[derive(Debug, Deserialize)]
         struct IntermediateOpAMPClientConfig {
-            endpoint: Url,
+            #[serde(default)]
+            endpoint: Option<Url>,
+            #[serde(default)]
+            region: Option<Region>,
             #[serde(default)]
             poll_interval: PollInterval,
             #[serde(default, with = "http_serde::header_map")]
@@ -672,13 +900,44 @@ impl<'de> Deserialize<'de> for OpAMPClientConfig {
             #[serde(default)]
             auth_config: Option<AuthConfig>,
             #[serde(default)]
+            enrollment: Option<EnrollmentConfig>,
+            #[serde(default)]
             fleet_id: String,
             #[serde(default)]
             signature_validation: SignatureValidatorConfig,
+            #[serde(default)]
+            network_wait: NetworkWaitConfig,
+            #[serde(default)]
+            dns: DnsConfig,
+            #[serde(default)]
+            pinned_public_keys: Vec<PublicKeyPin>,
+            #[serde(default)]
+            request_signing: Option<RequestSigningConfig>,
+            #[serde(default)]
+            tls: TlsConfig,
+            #[serde(default)]
+            proxy: Option<ProxyConfig>,
         }
 
         let mut intermediate_spec = IntermediateOpAMPClientConfig::deserialize(deserializer)?;
 
+        // The region provides the endpoints that are not explicitly set.
+        let region_url = |uri: http::Uri| Url::parse(&uri.to_string()).map_err(de::Error::custom);
+        let endpoint = match (intermediate_spec.endpoint, intermediate_spec.region) {
+            (Some(endpoint), _) => endpoint,
+            (None, Some(region)) => region_url(region.opamp_endpoint())?,
+            (None, None) => return Err(de::Error::missing_field("endpoint")),
+        };
+        if let Some(region) = intermediate_spec.region
+            && intermediate_spec
+                .signature_validation
+                .public_key_server_url
+                .is_none()
+        {
+            intermediate_spec.signature_validation.public_key_server_url =
+                Some(region_url(region.public_key_endpoint())?);
+        }
+
         let censored_headers = intermediate_spec
             .headers
             .iter_mut()
@@ -693,12 +952,19 @@ impl<'de> Deserialize<'de> for OpAMPClientConfig {
             .collect::<HeaderMap>();
 
         Ok(OpAMPClientConfig {
-            endpoint: intermediate_spec.endpoint,
+            endpoint,
             poll_interval: intermediate_spec.poll_interval,
             headers: censored_headers,
             auth_config: intermediate_spec.auth_config,
+            enrollment: intermediate_spec.enrollment,
             fleet_id: intermediate_spec.fleet_id,
             signature_validation: intermediate_spec.signature_validation,
+            network_wait: intermediate_spec.network_wait,
+            dns: intermediate_spec.dns,
+            pinned_public_keys: intermediate_spec.pinned_public_keys,
+            request_signing: intermediate_spec.request_signing,
+            tls: intermediate_spec.tls,
+            proxy: intermediate_spec.proxy,
         })
     }
 }
@@ -941,6 +1207,7 @@ pub fn default_group_version_kinds_no_flux() -> Vec<TypeMeta> {
 #[allow(missing_docs)]
 pub mod tests {
     use super::*;
+    use crate::http::config::TlsVersion;
     use crate::opamp::remote_config::hash::Hash;
     use crate::opamp::remote_config::{AGENT_CONFIG_PREFIX, ConfigurationMap, OpampRemoteConfig};
     use crate::{
@@ -991,7 +1258,14 @@ pub mod tests {
                 poll_interval: PollInterval::default(),
                 headers: HeaderMap::default(),
                 auth_config: None,
+                enrollment: None,
                 signature_validation: Default::default(),
+                network_wait: Default::default(),
+                dns: Default::default(),
+                pinned_public_keys: Default::default(),
+                request_signing: Default::default(),
+                tls: Default::default(),
+                proxy: None,
             }
         }
     }
@@ -1339,6 +1613,96 @@ k8s:
         )
     }
 
+    #[test]
+    fn test_fleet_control_region() {
+        let config = serde_saphyr::from_str::<AgentControlConfig>(
+            r#"
+fleet_control:
+  region: eu
+agents: {}
+"#,
+        )
+        .unwrap();
+        let fleet_control = config.fleet_control.unwrap();
+        assert_eq!(
+            fleet_control.endpoint.as_str(),
+            "https://opamp.service.eu.newrelic.com/v1/opamp"
+        );
+        assert_eq!(
+            fleet_control
+                .signature_validation
+                .public_key_server_url
+                .unwrap()
+                .as_str(),
+            "https://publickeys.eu.newrelic.com/r/blob-management/global/agentconfiguration/jwks.json"
+        );
+
+        // Explicit endpoints override the ones of the region.
+        let config = serde_saphyr::from_str::<AgentControlConfig>(
+            r#"
+fleet_control:
+  region: staging
+  endpoint: https://opamp.example.com/v1/opamp
+  signature_validation:
+    public_key_server_url: https://keys.example.com/jwks.json
+agents: {}
+"#,
+        )
+        .unwrap();
+        let fleet_control = config.fleet_control.unwrap();
+        assert_eq!(
+            fleet_control.endpoint.as_str(),
+            "https://opamp.example.com/v1/opamp"
+        );
+        assert_eq!(
+            fleet_control
+                .signature_validation
+                .public_key_server_url
+                .unwrap()
+                .as_str(),
+            "https://keys.example.com/jwks.json"
+        );
+
+        assert!(
+            serde_saphyr::from_str::<AgentControlConfig>("fleet_control: {}\nagents: {}\n")
+                .is_err()
+        );
+    }
+
+    #[test]
+    fn test_fleet_control_tls_and_proxy_config() {
+        let config = serde_saphyr::from_str::<AgentControlConfig>(
+            r#"
+fleet_control:
+  endpoint: https://opamp.example.com
+  proxy:
+    url: http://opamp-proxy:3128
+  tls:
+    ca_bundle_file: /etc/ssl/private-ca.pem
+    client_cert_file: /etc/newrelic-agent-control/client.pem
+    client_key_file: /etc/newrelic-agent-control/client.key
+    min_version: "1.3"
+agents: {}
+"#,
+        )
+        .unwrap();
+        let fleet_control = config.fleet_control.unwrap();
+        assert_eq!(
+            fleet_control.proxy.unwrap().url_as_string(),
+            "http://opamp-proxy:3128/"
+        );
+        assert_eq!(
+            fleet_control.tls,
+            TlsConfig {
+                ca_bundle_file: "/etc/ssl/private-ca.pem".into(),
+                client_cert_file: "/etc/newrelic-agent-control/client.pem".into(),
+                client_key_file: "/etc/newrelic-agent-control/client.key".into(),
+                insecure_skip_verify: false,
+                min_version: Some(TlsVersion::Tls13),
+            }
+        );
+    }
+
     #[test]
     fn test_default_package_compiles() {
         let _ = AgentControlPackage::default();
@@ -1819,4 +2183,29 @@ agent_types:
                 .contains("cannot specify both")
         );
     }
+
+    #[rstest]
+    #[case::default("agents: {}", ReleaseChannel::Stable)]
+    #[case::beta("agents: {}\nrelease_channel: beta", ReleaseChannel::Beta)]
+    #[case::canary("agents: {}\nrelease_channel: canary", ReleaseChannel::Canary)]
+    fn test_release_channel(#[case] yaml: &str, #[case] expected: ReleaseChannel) {
+        let config: AgentControlConfig = serde_saphyr::from_str(yaml).unwrap();
+        assert_eq!(config.release_channel, expected);
+    }
+
+    #[test]
+    fn test_release_channel_rejects_unknown() {
+        assert!(
+            serde_saphyr::from_str::<AgentControlConfig>("agents: {}\nrelease_channel: nightly")
+                .is_err()
+        );
+    }
+
+    #[rstest]
+    #[case::default("agents: {}", Duration::from_secs(60))]
+    #[case::custom("agents: {}\nstorage:\n  io_timeout: 5s", Duration::from_secs(5))]
+    fn test_storage_io_timeout(#[case] yaml: &str, #[case] expected: Duration) {
+        let config: AgentControlConfig = serde_saphyr::from_str(yaml).unwrap();
+        assert_eq!(config.storage.io_timeout(), expected);
+    }
 }
@@ -1,4 +1,5 @@
 //! Loading and persistence of Agent Control configuration (local and remote).
 
+pub mod in_progress;
 pub mod repository;
 pub mod store;
@@ -0,0 +1,279 @@
+//! Persisted marker tracking an Agent Control config apply until it completes.
+//!
+//! The marker holds the configuration Agent Control was running on and is written right before a
+//! new one is stored, then removed once it has been applied or discarded. If the host reboots in
+//! the middle of an apply, the next start finds the marker and reverts to the last applied
+//! configuration instead of carrying a half-applied one.
+
+use crate::agent_control::agent_id::AgentID;
+use crate::agent_control::config::AgentControlConfigError;
+use crate::agent_control::config_repository::repository::AgentControlDynamicConfigRepository;
+use crate::agent_control::defaults::{AGENT_CONTROL_ID, STORE_KEY_CONFIG_APPLY_IN_PROGRESS};
+use crate::data_store::DataStore;
+use crate::lifecycle_hooks::{LifecycleEvent, LifecycleHooks};
+use crate::opamp::instance_id::storer::StorerError;
+use crate::resource_ownership::ResourceOwnership;
+use crate::values::config::RemoteConfig;
+use serde::{Deserialize, Serialize};
+use std::io::ErrorKind;
+use std::path::PathBuf;
+use std::sync::Arc;
+use thiserror::Error;
+use tracing::{debug, warn};
+
+/// Config apply that started storing a new configuration but has not completed yet.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(tag = "source", rename_all = "snake_case")]
+pub enum ConfigApplyInProgress {
+    /// A remote configuration from Fleet Control was being applied.
+    Remote {
+        /// Remote configuration applied before, `None` if Agent Control ran on its local one.
+        previous: Option<RemoteConfig>,
+    },
+    /// A local configuration set was being applied.
+    Local {
+        /// Local configuration files being replaced, with their content before.
+        previous: Vec<LocalConfigFile>,
+    },
+}
+
+/// Content of a local configuration file before it was replaced.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct LocalConfigFile {
+    /// Path of the file.
+    pub path: PathBuf,
+    /// Content of the file, `None` if it didn't exist.
+    pub content: Option<String>,
+}
+
+/// Errors produced while reverting an interrupted config apply.
+#[derive(Debug, Error)]
+pub enum ConfigApplyMarkerError {
+    /// The marker could not be read or removed.
+    #[error("{0}")]
+    Storer(#[from] StorerError),
+    /// The previous remote configuration could not be restored.
+    #[error("restoring the remote config: {0}")]
+    RemoteConfig(#[from] AgentControlConfigError),
+    /// A previous local configuration file could not be restored.
+    #[error("restoring '{0}': {1}")]
+    LocalConfig(String, String),
+}
+
+/// Persists the [`ConfigApplyInProgress`] marker around the config applies of Agent Control.
+pub trait ConfigApplyTracker: Send + Sync {
+    /// Persists the marker for a configuration that is about to be stored.
+    fn mark(&self, in_progress: &ConfigApplyInProgress) -> Result<(), StorerError>;
+    /// Removes the persisted marker. Removing a marker that does not exist is not an error.
+    fn clear(&self) -> Result<(), StorerError>;
+}
+
+/// Reads and writes the [`ConfigApplyInProgress`] marker in the Agent Control data store.
+pub struct ConfigApplyMarker<D>
+where
+    D: DataStore,
+{
+    data_store: Arc<D>,
+    lifecycle_hooks: LifecycleHooks,
+}
+
+impl<D> From<Arc<D>> for ConfigApplyMarker<D>
+where
+    D: DataStore,
+{
+    fn from(data_store: Arc<D>) -> Self {
+        Self {
+            data_store,
+            lifecycle_hooks: LifecycleHooks::default(),
+        }
+    }
+}
+
+impl<D> ConfigApplyTracker for ConfigApplyMarker<D>
+where
+    D: DataStore + Send + Sync,
+{
+    fn mark(&self, in_progress: &ConfigApplyInProgress) -> Result<(), StorerError> {
+        debug!("Marking config apply as in progress");
+        self.data_store
+            .set_remote_data(
+                &AgentID::AgentControl,
+                ResourceOwnership::AgentControl,
+                STORE_KEY_CONFIG_APPLY_IN_PROGRESS,
+                in_progress,
+            )
+            .map_err(Into::into)
+    }
+
+    fn clear(&self) -> Result<(), StorerError> {
+        self.data_store
+            .delete_remote_data(&AgentID::AgentControl, STORE_KEY_CONFIG_APPLY_IN_PROGRESS)
+            .map_err(Into::into)
+    }
+}
+
+impl<D> ConfigApplyMarker<D>
+where
+    D: DataStore + Send + Sync,
+{
+    /// Sets the hooks notified when an interrupted config apply is reverted.
+    pub fn with_lifecycle_hooks(self, lifecycle_hooks: LifecycleHooks) -> Self {
+        Self {
+            lifecycle_hooks,
+            ..self
+        }
+    }
+
+    /// Returns the persisted marker, if any.
+    pub fn get(&self) -> Result<Option<ConfigApplyInProgress>, StorerError> {
+        self.data_store
+            .get_remote_data(&AgentID::AgentControl, STORE_KEY_CONFIG_APPLY_IN_PROGRESS)
+            .map_err(Into::into)
+    }
+
+    /// Reverts the config apply left in progress by a previous run, restoring the configuration
+    /// recorded in its marker through `config_store`, and removes the marker. Returns `None` when
+    /// no config apply was in progress.
+    pub fn resolve<R>(
+        &self,
+        config_store: &R,
+    ) -> Result<Option<ConfigApplyInProgress>, ConfigApplyMarkerError>
+    where
+        R: AgentControlDynamicConfigRepository,
+    {
+        let Some(in_progress) = self.get()? else {
+            return Ok(None);
+        };
+
+        warn!("A config apply was interrupted, reverting to the last applied config");
+        match &in_progress {
+            ConfigApplyInProgress::Remote {
+                previous: Some(previous),
+            } => config_store.store(previous)?,
+            ConfigApplyInProgress::Remote { previous: None } => config_store.delete()?,
+            ConfigApplyInProgress::Local { previous } => {
+                for file in previous {
+                    restore_local_config_file(file)?;
+                }
+            }
+        }
+        self.lifecycle_hooks.notify(LifecycleEvent::Rollback {
+            agent_id: AGENT_CONTROL_ID.to_string(),
+            reason: "config apply interrupted, reverted to the last applied config".to_string(),
+        });
+
+        self.clear()?;
+        Ok(Some(in_progress))
+    }
+}
+
+fn restore_local_config_file(file: &LocalConfigFile) -> Result<(), ConfigApplyMarkerError> {
+    let restored = match &file.content {
+        Some(content) => std::fs::write(&file.path, content),
+        None => std::fs::remove_file(&file.path).or_else(|err| match err.kind() {
+            ErrorKind::NotFound => Ok(()),
+            _ => Err(err),
+        }),
+    };
+    restored.map_err(|err| {
+        ConfigApplyMarkerError::LocalConfig(file.path.display().to_string(), err.to_string())
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_control::config_repository::repository::tests::MockAgentControlDynamicConfigStore;
+    use crate::on_host::file_store::FileStore;
+    use crate::opamp::remote_config::hash::{ConfigState, Hash};
+    use crate::values::yaml_config::YAMLConfig;
+    use fs::directory_manager::DirectoryManagerFs;
+    use fs::file::LocalFile;
+    use tempfile::TempDir;
+
+    fn marker(tmp_dir: &TempDir) -> ConfigApplyMarker<FileStore<LocalFile, DirectoryManagerFs>> {
+        ConfigApplyMarker::from(Arc::new(FileStore::new_local_fs(
+            tmp_dir.path().join("local"),
+            tmp_dir.path().join("remote"),
+        )))
+    }
+
+    #[test]
+    fn test_resolve_without_marker() {
+        let tmp_dir = TempDir::new().unwrap();
+        let config_store = MockAgentControlDynamicConfigStore::new();
+
+        assert!(marker(&tmp_dir).resolve(&config_store).unwrap().is_none());
+    }
+
+    #[test]
+    fn test_resolve_restores_previous_remote_config() {
+        let tmp_dir = TempDir::new().unwrap();
+        let marker = marker(&tmp_dir);
+        let previous = RemoteConfig {
+            config: YAMLConfig::try_from("agents: {}").unwrap(),
+            hash: Hash::new("agents: {}"),
+            state: ConfigState::Applied,
+        };
+        let in_progress = ConfigApplyInProgress::Remote {
+            previous: Some(previous.clone()),
+        };
+        marker.mark(&in_progress).unwrap();
+
+        let mut config_store = MockAgentControlDynamicConfigStore::new();
+        config_store
+            .expect_store()
+            .once()
+            .withf(move |config| config == &previous)
+            .returning(|_| Ok(()));
+
+        assert_eq!(marker.resolve(&config_store).unwrap(), Some(in_progress));
+        assert_eq!(marker.get().unwrap(), None);
+    }
+
+    #[test]
+    fn test_resolve_deletes_remote_config_without_previous() {
+        let tmp_dir = TempDir::new().unwrap();
+        let marker = marker(&tmp_dir);
+        marker
+            .mark(&ConfigApplyInProgress::Remote { previous: None })
+            .unwrap();
+
+        let mut config_store = MockAgentControlDynamicConfigStore::new();
+        config_store.expect_delete().once().returning(|| Ok(()));
+
+        assert!(marker.resolve(&config_store).unwrap().is_some());
+        assert_eq!(marker.get().unwrap(), None);
+    }
+
+    #[test]
+    fn test_resolve_restores_previous_local_config() {
+        let tmp_dir = TempDir::new().unwrap();
+        let marker = marker(&tmp_dir);
+        let replaced = tmp_dir.path().join("replaced.yaml");
+        let created = tmp_dir.path().join("created.yaml");
+        std::fs::write(&replaced, "new").unwrap();
+        std::fs::write(&created, "new").unwrap();
+        marker
+            .mark(&ConfigApplyInProgress::Local {
+                previous: vec![
+                    LocalConfigFile {
+                        path: replaced.clone(),
+                        content: Some("previous".to_string()),
+                    },
+                    LocalConfigFile {
+                        path: created.clone(),
+                        content: None,
+                    },
+                ],
+            })
+            .unwrap();
+
+        let config_store = MockAgentControlDynamicConfigStore::new();
+        assert!(marker.resolve(&config_store).unwrap().is_some());
+
+        assert_eq!(std::fs::read_to_string(&replaced).unwrap(), "previous");
+        assert!(!created.exists());
+        assert_eq!(marker.get().unwrap(), None);
+    }
+}
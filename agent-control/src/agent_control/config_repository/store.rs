@@ -9,6 +9,8 @@ use crate::agent_control::config_repository::repository::{
     AgentControlConfigLoader, AgentControlDynamicConfigRepository,
 };
 use crate::agent_control::defaults::{AGENT_CONTROL_CONFIG_ENV_VAR_PREFIX, default_capabilities};
+use crate::agent_type::templates::Templateable;
+use crate::agent_type::variable::secret_variables::load_env_vars;
 use crate::opamp::remote_config::hash::ConfigState;
 use crate::resource_ownership::ResourceOwnership;
 use crate::values::config::RemoteConfig;
@@ -100,8 +102,13 @@ where
 
     /// Load configs from local and remote sources.
     /// From the remote config only the AgentControlDynamicConfig is retrieve and if available applied
-    /// on top of the local config
+    /// on top of the local config. The local mandatory config, if any, is applied last so its
+    /// values cannot be overridden neither by the remote config nor by the environment.
+    ///
+    /// `${nr-env:VAR}` placeholders in the local configs are replaced by the value of the `VAR`
+    /// environment variable.
     fn _load_config(&self) -> Result<AgentControlConfig, AgentControlConfigError> {
+        let env_vars = load_env_vars();
         let local_config_string: String = self
             .values_repository
             .load_local(&self.agent_control_id)
@@ -113,6 +120,30 @@ where
             ))?
             .get_yaml_config()
             .clone()
+            .template_with(&env_vars)
+            .map_err(|e| {
+                AgentControlConfigError(format!("expanding Agent Control local config: {e}"))
+            })?
+            .try_into()
+            .map_err(|e: YAMLConfigError| AgentControlConfigError(e.to_string()))?;
+
+        let local_mandatory_config = self
+            .values_repository
+            .load_local_mandatory(&self.agent_control_id)
+            .map_err(|e| {
+                AgentControlConfigError(format!(
+                    "loading Agent Control local mandatory config: {e}"
+                ))
+            })?;
+        let local_mandatory_config_string: String = local_mandatory_config
+            .clone()
+            .unwrap_or_default()
+            .template_with(&env_vars)
+            .map_err(|e| {
+                AgentControlConfigError(format!(
+                    "expanding Agent Control local mandatory config: {e}"
+                ))
+            })?
             .try_into()
             .map_err(|e: YAMLConfigError| AgentControlConfigError(e.to_string()))?;
 
@@ -131,6 +162,10 @@ where
                     .prefix_separator("_")
                     .separator("__"),
             )
+            .add_source(File::from_str(
+                local_mandatory_config_string.as_str(),
+                FileFormat::Yaml,
+            ))
             .build()
             .map_err(|e| AgentControlConfigError(format!("building config: {e}")))?
             .try_deserialize::<AgentControlConfig>()
@@ -145,6 +180,10 @@ where
                 AgentControlConfigError(format!("loading Agent Control remote config: {e}"))
             })?
         {
+            let remote_config = match local_mandatory_config {
+                Some(local_mandatory) => remote_config.with_local_mandatory(local_mandatory),
+                None => remote_config,
+            };
             let dynamic_config: AgentControlDynamicConfig =
                 remote_config.get_yaml_config().clone().try_into()?;
             config.dynamic = dynamic_config;
@@ -166,8 +205,14 @@ fn sanitize_local_dynamic_config(
             chart_version
         );
     }
+    if !dynamic_config.feature_flag_overrides.is_empty() {
+        warn!(
+            "The 'feature_flag_overrides' value was found in the local configuration but is not supported and will be ignored, use 'feature_flags' instead"
+        );
+    }
     AgentControlDynamicConfig {
         chart_version: None,
+        feature_flag_overrides: Default::default(),
         ..dynamic_config
     }
 }
@@ -262,6 +307,7 @@ pub(crate) mod tests {
                 version: None,
                 chart_version: Some("1.0.0".to_string()),
                 cd_chart_version: None,
+                feature_flag_overrides: Default::default(),
             },
             host_id: "some".to_string(),
             ..Default::default()
@@ -269,6 +315,64 @@ pub(crate) mod tests {
         assert_eq!(actual, expected)
     }
 
+    #[test]
+    #[parallel]
+    fn load_local_mandatory_overrides_remote_and_local() {
+        let config_repository = InMemoryConfigRepository::default();
+
+        let local_config = r#"
+        agents: {}
+        host_id: some
+        "#
+        .try_into()
+        .unwrap();
+        config_repository
+            .store_local(&AgentID::AgentControl, &local_config)
+            .unwrap();
+
+        let remote_config: YAMLConfig = r#"
+        agents:
+          rolldice:
+            agent_type: "namespace/name:0.0.2"
+        "#
+        .try_into()
+        .unwrap();
+        config_repository
+            .store_remote(
+                &AgentID::AgentControl,
+                ResourceOwnership::AgentControl,
+                &remote_config.into(),
+            )
+            .unwrap();
+
+        let local_mandatory_config = r#"
+        agents: {}
+        host_id: pinned
+        "#
+        .try_into()
+        .unwrap();
+        config_repository.store_local_mandatory(&AgentID::AgentControl, &local_mandatory_config);
+
+        let store = AgentControlConfigStore::new(Arc::new(config_repository));
+        let actual = AgentControlConfigLoader::load(&store).unwrap();
+
+        // Only the values set in the mandatory config are overridden, the remote agents are kept
+        let expected = AgentControlConfig {
+            dynamic: AgentControlDynamicConfig {
+                agents: HashMap::from([(
+                    AgentID::try_from("rolldice").unwrap(),
+                    SubAgentConfig {
+                        agent_type: AgentTypeID::try_from("namespace/name:0.0.2").unwrap(),
+                    },
+                )]),
+                ..Default::default()
+            },
+            host_id: "pinned".to_string(),
+            ..Default::default()
+        };
+        assert_eq!(actual, expected)
+    }
+
     #[test]
     #[serial]
     fn load_config_env_vars() {
@@ -357,6 +461,37 @@ pub(crate) mod tests {
         assert_eq!(actual, expected);
     }
 
+    #[test]
+    #[serial]
+    fn load_config_expands_env_placeholders() {
+        let config_repository = InMemoryConfigRepository::default();
+
+        let local_config = r#"
+        agents: {}
+        host_id: ${nr-env:AC_TEST_HOST_ID}
+        "#
+        .try_into()
+        .unwrap();
+
+        config_repository
+            .store_local(&AgentID::AgentControl, &local_config)
+            .unwrap();
+
+        let store = AgentControlConfigStore::new(Arc::new(config_repository));
+        // Placeholders of unset variables are an error.
+        assert!(AgentControlConfigLoader::load(&store).is_err());
+
+        let env_var_name = "AC_TEST_HOST_ID";
+        unsafe { env::set_var(env_var_name, "from-env") };
+
+        let actual = AgentControlConfigLoader::load(&store).unwrap();
+
+        // Env cleanup
+        unsafe { env::remove_var(env_var_name) };
+
+        assert_eq!(actual.host_id, "from-env");
+    }
+
     #[test]
     #[parallel]
     fn load_local_sanitized_values() {
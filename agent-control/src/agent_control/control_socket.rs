@@ -0,0 +1,33 @@
+//! Local control API exposed over a Unix domain socket.
+//!
+//! Host tooling (the CLI, installers, configuration management) can inspect and drive a running
+//! Agent Control without opening any HTTP port. Requests and responses are JSON documents, one per
+//! line, e.g. `{"command":"status"}`. Only peers running as root, as the Agent Control user or as
+//! one of the explicitly allowed users are served.
+
+use std::io;
+use thiserror::Error;
+
+#[cfg(target_family = "unix")]
+pub mod client;
+pub mod config;
+pub mod protocol;
+#[cfg(target_family = "unix")]
+pub mod server;
+
+/// Errors produced by the control socket server and client.
+#[derive(Error, Debug)]
+pub enum ControlSocketError {
+    /// The socket could not be created.
+    #[error("could not bind control socket '{0}': {1}")]
+    Bind(String, io::Error),
+    /// The credentials of the connected peer could not be read.
+    #[error("could not read peer credentials: {0}")]
+    PeerCredentials(String),
+    /// The connected peer is not allowed to use the control API.
+    #[error("peer with uid {0} is not allowed to use the control API")]
+    Unauthorized(u32),
+    /// The request could not be handed to Agent Control or it did not reply in time.
+    #[error("could not process the request: {0}")]
+    Dispatch(String),
+}
@@ -0,0 +1,36 @@
+//! Client for the local control API, used by the CLI and tests.
+
+use super::ControlSocketError;
+use super::protocol::{ControlCommand, ControlResponse};
+use std::io::{BufRead, BufReader, Write};
+use std::os::unix::net::UnixStream;
+use std::path::Path;
+use std::time::Duration;
+
+/// Sends `command` to the control socket at `path` and waits up to `timeout` for its response.
+pub fn send_command(
+    path: &Path,
+    command: &ControlCommand,
+    timeout: Duration,
+) -> Result<ControlResponse, ControlSocketError> {
+    let dispatch_err = |err: std::io::Error| ControlSocketError::Dispatch(err.to_string());
+
+    let mut stream = UnixStream::connect(path).map_err(|err| {
+        ControlSocketError::Dispatch(format!("could not connect to '{}': {err}", path.display()))
+    })?;
+    stream
+        .set_read_timeout(Some(timeout))
+        .map_err(dispatch_err)?;
+
+    let mut payload =
+        serde_json::to_vec(command).map_err(|err| ControlSocketError::Dispatch(err.to_string()))?;
+    payload.push(b'\n');
+    stream.write_all(&payload).map_err(dispatch_err)?;
+
+    let mut line = String::new();
+    BufReader::new(stream)
+        .read_line(&mut line)
+        .map_err(dispatch_err)?;
+    serde_json::from_str(&line)
+        .map_err(|err| ControlSocketError::Dispatch(format!("invalid response: {err}")))
+}
@@ -0,0 +1,43 @@
+//! Configuration of the local control API socket.
+
+use serde::Deserialize;
+use std::path::PathBuf;
+
+/// Configuration of the local control API. Disabled by default.
+#[derive(Debug, Default, Deserialize, PartialEq, Clone)]
+#[serde(default)]
+pub struct ControlSocketConfig {
+    /// Whether the control socket is created.
+    pub enabled: bool,
+    /// Path of the socket. Defaults to `control.sock` in the Agent Control data directory.
+    pub path: Option<PathBuf>,
+    /// Uids allowed to use the control API besides root and the user running Agent Control.
+    pub allowed_uids: Vec<u32>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_control_socket_config_deserialization() {
+        let config: ControlSocketConfig = serde_saphyr::from_str(
+            r#"
+enabled: true
+path: /run/newrelic-agent-control/control.sock
+allowed_uids: [1000]
+"#,
+        )
+        .unwrap();
+
+        assert_eq!(
+            config,
+            ControlSocketConfig {
+                enabled: true,
+                path: Some(PathBuf::from("/run/newrelic-agent-control/control.sock")),
+                allowed_uids: vec![1000],
+            }
+        );
+        assert!(!ControlSocketConfig::default().enabled);
+    }
+}
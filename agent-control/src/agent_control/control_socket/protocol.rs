@@ -0,0 +1,148 @@
+//! Messages exchanged over the control socket.
+
+use crossbeam::channel::{Receiver, Sender, bounded};
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+
+/// Commands accepted by the control API.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(tag = "command", rename_all = "snake_case")]
+pub enum ControlCommand {
+    /// Returns whether the agents are paused and the list of configured agents.
+    Status,
+    /// Returns the configured agents along with their agent type.
+    ListAgents,
+    /// Returns the last health reported by Agent Control.
+    Health,
+    /// Loads the persisted configuration again and applies it.
+    Reload,
+    /// Discards the persisted remote configuration and falls back to the local one.
+    Rollback,
+    /// Stops every sub-agent, keeping Agent Control running.
+    Pause,
+    /// Starts again the sub-agents stopped by [ControlCommand::Pause].
+    Resume,
+    /// Changes the Agent Control log level.
+    SetLogLevel {
+        /// New level: `trace`, `debug`, `info`, `warn` or `error`.
+        level: String,
+    },
+    /// Delivers a signal to the running processes of an on-host agent without restarting them.
+    Signal {
+        /// Id of the agent.
+        agent_id: String,
+        /// Signal name, with or without the `SIG` prefix (e.g. `SIGHUP` or `USR1`).
+        signal: String,
+    },
+}
+
+/// Reply to a [ControlCommand].
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct ControlResponse {
+    /// Whether the command succeeded.
+    pub ok: bool,
+    /// Command output, if any.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub result: Option<Value>,
+    /// Error message when the command failed.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+impl ControlResponse {
+    /// Successful response without output.
+    pub fn ok() -> Self {
+        Self {
+            ok: true,
+            result: None,
+            error: None,
+        }
+    }
+
+    /// Successful response carrying `result`.
+    pub fn with_result(result: Value) -> Self {
+        Self {
+            result: Some(result),
+            ..Self::ok()
+        }
+    }
+
+    /// Failed response carrying the error message.
+    pub fn failure(error: impl ToString) -> Self {
+        Self {
+            ok: false,
+            result: None,
+            error: Some(error.to_string()),
+        }
+    }
+}
+
+/// A [ControlCommand] handed to the Agent Control event loop, along with the channel to reply on.
+#[derive(Debug)]
+pub struct ControlRequest {
+    /// The command to execute.
+    pub command: ControlCommand,
+    reply: Sender<ControlResponse>,
+}
+
+impl ControlRequest {
+    /// Returns the request for `command` and the receiver of its response.
+    pub fn new(command: ControlCommand) -> (Self, Receiver<ControlResponse>) {
+        let (reply, response) = bounded(1);
+        (Self { command, reply }, response)
+    }
+
+    /// Sends the response back. The requester may have given up waiting, so failures are ignored.
+    pub fn respond(self, response: ControlResponse) {
+        let _ = self.reply.send(response);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+
+    #[rstest]
+    #[case::status(r#"{"command":"status"}"#, ControlCommand::Status)]
+    #[case::rollback(r#"{"command":"rollback"}"#, ControlCommand::Rollback)]
+    #[case::list_agents(r#"{"command":"list_agents"}"#, ControlCommand::ListAgents)]
+    #[case::set_log_level(
+        r#"{"command":"set_log_level","level":"debug"}"#,
+        ControlCommand::SetLogLevel { level: "debug".to_string() }
+    )]
+    #[case::signal(
+        r#"{"command":"signal","agent_id":"nr-infra","signal":"SIGHUP"}"#,
+        ControlCommand::Signal { agent_id: "nr-infra".to_string(), signal: "SIGHUP".to_string() }
+    )]
+    fn test_command_deserialization(#[case] input: &str, #[case] expected: ControlCommand) {
+        assert_eq!(
+            serde_json::from_str::<ControlCommand>(input).unwrap(),
+            expected
+        );
+    }
+
+    #[test]
+    fn test_unknown_command() {
+        assert!(serde_json::from_str::<ControlCommand>(r#"{"command":"restart"}"#).is_err());
+    }
+
+    #[test]
+    fn test_response_serialization() {
+        assert_eq!(
+            serde_json::to_string(&ControlResponse::ok()).unwrap(),
+            r#"{"ok":true}"#
+        );
+        assert_eq!(
+            serde_json::to_string(&ControlResponse::failure("boom")).unwrap(),
+            r#"{"ok":false,"error":"boom"}"#
+        );
+    }
+
+    #[test]
+    fn test_request_reply() {
+        let (request, response) = ControlRequest::new(ControlCommand::Pause);
+        request.respond(ControlResponse::ok());
+        assert_eq!(response.recv().unwrap(), ControlResponse::ok());
+    }
+}
@@ -0,0 +1,381 @@
+//! Unix domain socket server for the local control API.
+
+use super::ControlSocketError;
+use super::protocol::{ControlCommand, ControlRequest, ControlResponse};
+use crate::agent_control::agent_id::AgentID;
+use crate::event::channel::EventPublisher;
+use crate::instrumentation::config::logs::level_reload::LogLevelReloader;
+use crate::sub_agent::on_host::processes::SupervisedProcesses;
+use crate::utils::threads::spawn_named_thread;
+use nix::unistd::{Uid, geteuid};
+use std::fs;
+use std::io::{BufRead, BufReader, Write};
+use std::os::unix::fs::{FileTypeExt, PermissionsExt};
+use std::os::unix::net::{UnixListener, UnixStream};
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::thread::JoinHandle;
+use std::time::Duration;
+use tracing::{debug, info, warn};
+
+const CONTROL_SOCKET_THREAD_NAME: &str = "control-socket";
+/// Only the owner and its group can connect, authorization is enforced on top of it.
+const CONTROL_SOCKET_MODE: u32 = 0o660;
+/// The socket is created in a directory only accessible by the owner, see [bind].
+const PRIVATE_DIR_MODE: u32 = 0o700;
+const PRIVATE_DIR_PREFIX: &str = ".control-socket-";
+const CLIENT_READ_TIMEOUT: Duration = Duration::from_secs(10);
+/// Reload and resume may rebuild every sub-agent, which can take a while.
+const REPLY_TIMEOUT: Duration = Duration::from_secs(60);
+
+/// Control socket server. The socket is removed and the serving thread stopped on drop.
+pub struct ControlSocketServer {
+    path: PathBuf,
+    stopped: Arc<AtomicBool>,
+    thread: Option<JoinHandle<()>>,
+}
+
+impl ControlSocketServer {
+    /// Creates the socket at `path` and starts serving it. The log level is changed through
+    /// `log_level_reloader` and signals are delivered to the agent processes registered in
+    /// `processes`. Other commands are published through `publisher` to be executed by the Agent
+    /// Control event loop.
+    pub fn start(
+        path: PathBuf,
+        allowed_uids: Vec<u32>,
+        publisher: EventPublisher<ControlRequest>,
+        processes: SupervisedProcesses,
+        log_level_reloader: LogLevelReloader,
+    ) -> Result<Self, ControlSocketError> {
+        let listener = bind(&path)
+            .map_err(|err| ControlSocketError::Bind(path.to_string_lossy().to_string(), err))?;
+        info!(path = %path.display(), "Control socket listening");
+
+        let stopped = Arc::new(AtomicBool::new(false));
+        let thread_stopped = stopped.clone();
+        let thread = spawn_named_thread(CONTROL_SOCKET_THREAD_NAME, move || {
+            for stream in listener.incoming() {
+                if thread_stopped.load(Ordering::Relaxed) {
+                    break;
+                }
+                match stream {
+                    Ok(stream) => {
+                        let _ = serve(
+                            stream,
+                            &allowed_uids,
+                            &publisher,
+                            &processes,
+                            &log_level_reloader,
+                        )
+                        .inspect_err(|err| warn!("Control socket request failed: {err}"));
+                    }
+                    Err(err) => warn!("Could not accept control socket connection: {err}"),
+                }
+            }
+            debug!("Control socket stopped");
+        });
+
+        Ok(Self {
+            path,
+            stopped,
+            thread: Some(thread),
+        })
+    }
+}
+
+impl Drop for ControlSocketServer {
+    fn drop(&mut self) {
+        self.stopped.store(true, Ordering::Relaxed);
+        // Unblock the pending `accept` so the serving thread can notice it was stopped.
+        let _ = UnixStream::connect(&self.path);
+        if let Some(thread) = self.thread.take() {
+            let _ = thread.join();
+        }
+        let _ = fs::remove_file(&self.path);
+    }
+}
+
+/// Binds the socket, replacing a stale one left behind by a previous run.
+///
+/// The socket is bound inside a directory only the owner can access and moved to `path` once its
+/// permissions are restricted, so other users can't connect to it in between.
+fn bind(path: &Path) -> std::io::Result<UnixListener> {
+    if let Ok(metadata) = fs::symlink_metadata(path) {
+        if !metadata.file_type().is_socket() {
+            return Err(std::io::Error::new(
+                std::io::ErrorKind::AlreadyExists,
+                "path exists and is not a socket",
+            ));
+        }
+        fs::remove_file(path)?;
+    }
+    let parent = path
+        .parent()
+        .filter(|parent| !parent.as_os_str().is_empty())
+        .unwrap_or(Path::new("."));
+    let private_dir = tempfile::Builder::new()
+        .prefix(PRIVATE_DIR_PREFIX)
+        .permissions(fs::Permissions::from_mode(PRIVATE_DIR_MODE))
+        .tempdir_in(parent)?;
+    // Kept short, as the length of socket paths is limited.
+    let private_path = private_dir.path().join("s");
+    let listener = UnixListener::bind(&private_path)?;
+    fs::set_permissions(
+        &private_path,
+        fs::Permissions::from_mode(CONTROL_SOCKET_MODE),
+    )?;
+    fs::rename(&private_path, path)?;
+    Ok(listener)
+}
+
+/// Serves every request sent over the connection until the peer closes it.
+fn serve(
+    stream: UnixStream,
+    allowed_uids: &[u32],
+    publisher: &EventPublisher<ControlRequest>,
+    processes: &SupervisedProcesses,
+    log_level_reloader: &LogLevelReloader,
+) -> Result<(), ControlSocketError> {
+    let uid = peer_uid(&stream)?;
+    let mut writer = stream
+        .try_clone()
+        .map_err(|err| ControlSocketError::Dispatch(err.to_string()))?;
+    if !is_authorized(uid, allowed_uids) {
+        let _ = write_response(
+            &mut writer,
+            &ControlResponse::failure(ControlSocketError::Unauthorized(uid)),
+        );
+        return Err(ControlSocketError::Unauthorized(uid));
+    }
+    let _ = stream.set_read_timeout(Some(CLIENT_READ_TIMEOUT));
+
+    for line in BufReader::new(stream).lines() {
+        let Ok(line) = line else { break };
+        if line.trim().is_empty() {
+            continue;
+        }
+        let response = match serde_json::from_str::<ControlCommand>(&line) {
+            Ok(command) => {
+                debug!(uid, ?command, "Control command received");
+                dispatch(command, publisher, processes, log_level_reloader)
+            }
+            Err(err) => ControlResponse::failure(format!("invalid request: {err}")),
+        };
+        write_response(&mut writer, &response)
+            .map_err(|err| ControlSocketError::Dispatch(err.to_string()))?;
+    }
+    Ok(())
+}
+
+/// Executes the command: the log level is changed and signals are delivered right away, anything
+/// else is handed to the Agent Control event loop and its response awaited.
+fn dispatch(
+    command: ControlCommand,
+    publisher: &EventPublisher<ControlRequest>,
+    processes: &SupervisedProcesses,
+    log_level_reloader: &LogLevelReloader,
+) -> ControlResponse {
+    match &command {
+        ControlCommand::SetLogLevel { level } => {
+            return match log_level_reloader.set_log_level(level) {
+                Ok(()) => ControlResponse::ok(),
+                Err(err) => ControlResponse::failure(err),
+            };
+        }
+        ControlCommand::Signal { agent_id, signal } => {
+            return match AgentID::try_from(agent_id.as_str()) {
+                Ok(agent_id) => match processes.signal(&agent_id, signal) {
+                    Ok(pids) => ControlResponse::with_result(serde_json::json!({ "pids": pids })),
+                    Err(err) => ControlResponse::failure(err),
+                },
+                Err(err) => ControlResponse::failure(err),
+            };
+        }
+        _ => {}
+    }
+
+    let (request, response) = ControlRequest::new(command);
+    if let Err(err) = publisher.publish(request) {
+        return ControlResponse::failure(ControlSocketError::Dispatch(err.to_string()));
+    }
+    response.recv_timeout(REPLY_TIMEOUT).unwrap_or_else(|err| {
+        ControlResponse::failure(ControlSocketError::Dispatch(err.to_string()))
+    })
+}
+
+fn write_response(writer: &mut UnixStream, response: &ControlResponse) -> std::io::Result<()> {
+    let mut payload = serde_json::to_vec(response)?;
+    payload.push(b'\n');
+    writer.write_all(&payload)
+}
+
+/// Root and the user running Agent Control are always allowed.
+fn is_authorized(uid: u32, allowed_uids: &[u32]) -> bool {
+    Uid::from_raw(uid).is_root() || uid == geteuid().as_raw() || allowed_uids.contains(&uid)
+}
+
+#[cfg(target_os = "linux")]
+fn peer_uid(stream: &UnixStream) -> Result<u32, ControlSocketError> {
+    use nix::sys::socket::{getsockopt, sockopt::PeerCredentials};
+    getsockopt(stream, PeerCredentials)
+        .map(|credentials| credentials.uid())
+        .map_err(|err| ControlSocketError::PeerCredentials(err.to_string()))
+}
+
+#[cfg(not(target_os = "linux"))]
+fn peer_uid(stream: &UnixStream) -> Result<u32, ControlSocketError> {
+    nix::unistd::getpeereid(stream)
+        .map(|(uid, _)| uid.as_raw())
+        .map_err(|err| ControlSocketError::PeerCredentials(err.to_string()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::event::channel::pub_sub;
+    use tempfile::TempDir;
+
+    fn send(path: &Path, request: &str) -> ControlResponse {
+        let mut stream = UnixStream::connect(path).unwrap();
+        stream.write_all(format!("{request}\n").as_bytes()).unwrap();
+        let mut line = String::new();
+        BufReader::new(stream).read_line(&mut line).unwrap();
+        serde_json::from_str(&line).unwrap()
+    }
+
+    #[test]
+    fn test_commands_are_forwarded_to_the_event_loop() {
+        let tmp_dir = TempDir::new().unwrap();
+        let path = tmp_dir.path().join("control.sock");
+        let (publisher, consumer) = pub_sub();
+        let server = ControlSocketServer::start(
+            path.clone(),
+            Vec::new(),
+            publisher,
+            SupervisedProcesses::default(),
+            LogLevelReloader::default(),
+        )
+        .unwrap();
+
+        let event_loop = std::thread::spawn(move || {
+            let request: ControlRequest = consumer.as_ref().recv().unwrap();
+            assert_eq!(request.command, ControlCommand::Pause);
+            request.respond(ControlResponse::ok());
+        });
+
+        assert_eq!(send(&path, r#"{"command":"pause"}"#), ControlResponse::ok());
+        event_loop.join().unwrap();
+
+        drop(server);
+        assert!(!path.exists());
+    }
+
+    #[test]
+    fn test_invalid_request() {
+        let tmp_dir = TempDir::new().unwrap();
+        let path = tmp_dir.path().join("control.sock");
+        let (publisher, _consumer) = pub_sub();
+        let _server = ControlSocketServer::start(
+            path.clone(),
+            Vec::new(),
+            publisher,
+            SupervisedProcesses::default(),
+            LogLevelReloader::default(),
+        )
+        .unwrap();
+
+        let response = send(&path, r#"{"command":"restart"}"#);
+        assert!(!response.ok);
+        assert!(response.error.unwrap().starts_with("invalid request"));
+    }
+
+    #[test]
+    fn test_signal_is_delivered_by_the_server() {
+        use std::os::unix::process::ExitStatusExt;
+
+        let tmp_dir = TempDir::new().unwrap();
+        let path = tmp_dir.path().join("control.sock");
+        let (publisher, _consumer) = pub_sub();
+        let processes = SupervisedProcesses::default();
+        let _server = ControlSocketServer::start(
+            path.clone(),
+            Vec::new(),
+            publisher,
+            processes.clone(),
+            LogLevelReloader::default(),
+        )
+        .unwrap();
+
+        let response = send(
+            &path,
+            r#"{"command":"signal","agent_id":"nr-infra","signal":"SIGUSR1"}"#,
+        );
+        assert!(!response.ok);
+
+        let mut child = std::process::Command::new("sleep")
+            .arg("10")
+            .spawn()
+            .unwrap();
+        let agent_id = AgentID::try_from("nr-infra").unwrap();
+        processes.register(&agent_id, "infra-agent", child.id(), "sleep");
+
+        let response = send(
+            &path,
+            r#"{"command":"signal","agent_id":"nr-infra","signal":"SIGUSR1"}"#,
+        );
+        assert_eq!(
+            response,
+            ControlResponse::with_result(serde_json::json!({ "pids": [child.id()] }))
+        );
+        assert_eq!(
+            child.wait().unwrap().signal(),
+            Some(nix::sys::signal::Signal::SIGUSR1 as i32)
+        );
+    }
+
+    #[test]
+    fn test_stale_socket_is_replaced() {
+        let tmp_dir = TempDir::new().unwrap();
+        let path = tmp_dir.path().join("control.sock");
+        let stale = UnixListener::bind(&path).unwrap();
+        drop(stale);
+
+        assert!(bind(&path).is_ok());
+    }
+
+    #[test]
+    fn test_bind_restricts_the_socket_permissions() {
+        let tmp_dir = TempDir::new().unwrap();
+        let path = tmp_dir.path().join("control.sock");
+
+        let _listener = bind(&path).unwrap();
+
+        let metadata = fs::symlink_metadata(&path).unwrap();
+        assert!(metadata.file_type().is_socket());
+        assert_eq!(metadata.permissions().mode() & 0o777, CONTROL_SOCKET_MODE);
+        // The private directory the socket was bound in is removed.
+        assert_eq!(fs::read_dir(tmp_dir.path()).unwrap().count(), 1);
+        assert!(UnixStream::connect(&path).is_ok());
+    }
+
+    #[test]
+    fn test_bind_refuses_to_replace_regular_files() {
+        let tmp_dir = TempDir::new().unwrap();
+        let path = tmp_dir.path().join("control.sock");
+        fs::write(&path, "not a socket").unwrap();
+
+        assert!(bind(&path).is_err());
+    }
+
+    #[test]
+    fn test_authorization() {
+        let own_uid = geteuid().as_raw();
+        let other_uid = if own_uid == 4242 { 4243 } else { 4242 };
+
+        assert!(is_authorized(0, &[]));
+        assert!(is_authorized(own_uid, &[]));
+        assert!(is_authorized(other_uid, &[other_uid]));
+        assert!(!is_authorized(other_uid, &[]));
+    }
+}
@@ -0,0 +1,108 @@
+//! New Relic credentials shared by Agent Control with its sub-agents.
+//!
+//! Credentials are set once in the Agent Control config and exposed to agent types as
+//! `${nr-ac:credentials.<field>}` variables, so agent types don't need to read them from other
+//! settings such as the OpAMP headers.
+
+use crate::agent_type::variable::Variable;
+use crate::cli::common::region::Region;
+use serde::Deserialize;
+use std::fmt::{self, Debug, Formatter};
+
+/// Name of the Agent Control variables group holding the credentials.
+const CREDENTIALS_VARIABLE_PREFIX: &str = "credentials";
+/// Replacement of secret values when printed.
+const REDACTED: &str = "<redacted>";
+
+/// New Relic credentials available to sub-agents.
+#[derive(Default, Deserialize, PartialEq, Clone)]
+#[serde(default)]
+pub struct Credentials {
+    /// License key used by agents to send data.
+    pub license_key: Option<String>,
+    /// User API key.
+    pub api_key: Option<String>,
+    /// New Relic account id.
+    pub account_id: Option<String>,
+    /// New Relic region (e.g. `US` or `EU`).
+    pub region: Option<Region>,
+}
+
+impl Debug for Credentials {
+    fn fmt(&self, f: &mut Formatter<'_>) -> fmt::Result {
+        f.debug_struct("Credentials")
+            .field("license_key", &self.license_key.as_ref().map(|_| REDACTED))
+            .field("api_key", &self.api_key.as_ref().map(|_| REDACTED))
+            .field("account_id", &self.account_id)
+            .field("region", &self.region)
+            .finish()
+    }
+}
+
+impl Credentials {
+    /// Returns the Agent Control variables for the credentials that are set.
+    pub fn variables(&self) -> impl Iterator<Item = (String, Variable)> {
+        [
+            ("license_key", self.license_key.clone()),
+            ("api_key", self.api_key.clone()),
+            ("account_id", self.account_id.clone()),
+            ("region", self.region.map(|region| region.to_string())),
+        ]
+        .into_iter()
+        .filter_map(|(name, value)| {
+            value.map(|value| {
+                (
+                    format!("{CREDENTIALS_VARIABLE_PREFIX}.{name}"),
+                    Variable::new_final_string_variable(value),
+                )
+            })
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    #[test]
+    fn test_variables_of_set_credentials() {
+        let credentials: Credentials =
+            serde_saphyr::from_str("license_key: some-license\nregion: eu\n").unwrap();
+
+        let variables = credentials.variables().collect::<HashMap<_, _>>();
+        assert_eq!(
+            variables,
+            HashMap::from([
+                (
+                    "credentials.license_key".to_string(),
+                    Variable::new_final_string_variable("some-license"),
+                ),
+                (
+                    "credentials.region".to_string(),
+                    Variable::new_final_string_variable("EU"),
+                ),
+            ])
+        );
+    }
+
+    #[test]
+    fn test_unknown_region_is_rejected() {
+        assert!(serde_saphyr::from_str::<Credentials>("region: mars\n").is_err());
+    }
+
+    #[test]
+    fn test_debug_redacts_keys() {
+        let credentials = Credentials {
+            license_key: Some("some-license".to_string()),
+            api_key: Some("some-api-key".to_string()),
+            account_id: Some("1234".to_string()),
+            region: None,
+        };
+
+        let debug = format!("{credentials:?}");
+        assert!(!debug.contains("some-license"));
+        assert!(!debug.contains("some-api-key"));
+        assert!(debug.contains("1234"));
+    }
+}
@@ -38,6 +38,8 @@ pub const OPAMP_SUPERVISOR_KEY: &str = "supervisor.key";
 
 /// OpAMP attribute key for the agent version.
 pub const OPAMP_AGENT_VERSION_ATTRIBUTE_KEY: &str = "agent.version";
+/// OpAMP attribute key for the release channel of the host.
+pub const RELEASE_CHANNEL_ATTRIBUTE_KEY: &str = "release.channel";
 
 /// File name holding the agent environment variables.
 pub const ENVIRONMENT_VARIABLES_FILE_NAME: &str = "environment_variables.yaml";
@@ -61,6 +63,8 @@ pub const AC_OCI_AGENT_TYPES_PUBLIC_KEY_URL: &str =
 // Auth
 /// File name of the authentication private key.
 pub const AUTH_PRIVATE_KEY_FILE_NAME: &str = "auth_key";
+/// File name of the client id of the system identity created on enrollment.
+pub const AUTH_CLIENT_ID_FILE_NAME: &str = "auth_client_id";
 
 // Keys non-identifying attributes
 /// OpAMP attribute key for the parent agent id.
@@ -71,6 +75,15 @@ pub const HOST_NAME_ATTRIBUTE_KEY: &str = opentelemetry_semantic_conventions::at
 pub const CLUSTER_NAME_ATTRIBUTE_KEY: &str = "cluster.name";
 /// OpAMP attribute key for the host id.
 pub const HOST_ID_ATTRIBUTE_KEY: &str = opentelemetry_semantic_conventions::attribute::HOST_ID;
+/// OpAMP attribute key for the operating-system version.
+pub const OS_VERSION_ATTRIBUTE_KEY: &str =
+    opentelemetry_semantic_conventions::attribute::OS_VERSION;
+/// OpAMP attribute key for the cloud provider of the host.
+pub const CLOUD_PROVIDER_ATTRIBUTE_KEY: &str =
+    opentelemetry_semantic_conventions::attribute::CLOUD_PROVIDER;
+/// OpAMP attribute key for the cloud region of the host.
+pub const CLOUD_REGION_ATTRIBUTE_KEY: &str =
+    opentelemetry_semantic_conventions::attribute::CLOUD_REGION;
 /// OpAMP attribute key for the fleet GUID.
 pub const FLEET_ID_ATTRIBUTE_KEY: &str = "fleet.guid";
 /// OpAMP attribute key signalling whether external continuous delivery is enabled.
@@ -81,6 +94,17 @@ pub const CD_REMOTE_UPDATE_ENABLED_ATTRIBUTE_KEY: &str = "cd.remote_update.enabl
 pub const APM_APPLICATION_ID: &str = "apm.application.id";
 /// OpAMP attribute key for the execution mode.
 pub const EXECUTION_MODE_ATTRIBUTE_KEY: &str = "execution.mode";
+/// OpAMP attribute key for the GUID of the New Relic entity of the agent, once known.
+pub const ENTITY_GUID_ATTRIBUTE_KEY: &str = "entity.guid";
+/// OpAMP attribute key for the provider of the instrumentation, used for entity synthesis.
+pub const INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY: &str = "instrumentation.provider";
+/// Instrumentation provider attribute value for Agent Control and its agents.
+pub const INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE: &str = "newrelic";
+/// OpAMP attribute key for the agents managed by Agent Control, as comma separated
+/// `<agent id>=<instance id>` pairs.
+pub const MANAGED_AGENTS_ATTRIBUTE_KEY: &str = "managed.agents";
+/// OpAMP attribute key for the on-host integrations enabled for an agent, comma separated.
+pub const INTEGRATIONS_ATTRIBUTE_KEY: &str = "integrations";
 
 /// OpAMP attribute key for the operating-system type.
 pub const OS_ATTRIBUTE_KEY: &str = "os.type";
@@ -93,6 +117,9 @@ pub const OS_ATTRIBUTE_VALUE: &str = "linux";
 /// Operating-system attribute value for the current target.
 #[cfg(target_os = "windows")]
 pub const OS_ATTRIBUTE_VALUE: &str = "windows";
+/// Operating-system attribute value for the current target.
+#[cfg(target_os = "freebsd")]
+pub const OS_ATTRIBUTE_VALUE: &str = "freebsd";
 
 // Paths
 cfg_if::cfg_if! {
@@ -103,6 +130,13 @@ cfg_if::cfg_if! {
         pub const AGENT_CONTROL_DATA_DIR: &str = "/opt/homebrew/var/lib/newrelic-agent-control";
         /// Log directory for the current target.
         pub const AGENT_CONTROL_LOG_DIR: &str = "/opt/homebrew/var/log/newrelic-agent-control";
+    } else if #[cfg(target_os = "freebsd")] {
+        /// Directory holding the local (non-remote) Agent Control data for the current target.
+        pub const AGENT_CONTROL_LOCAL_DATA_DIR: &str = "/usr/local/etc/newrelic-agent-control";
+        /// Base data directory for the current target.
+        pub const AGENT_CONTROL_DATA_DIR: &str = "/var/db/newrelic-agent-control";
+        /// Log directory for the current target.
+        pub const AGENT_CONTROL_LOG_DIR: &str = "/var/log/newrelic-agent-control";
     } else if #[cfg(target_os = "windows")] {
         /// Directory holding the local (non-remote) Agent Control data for the current target.
         pub const AGENT_CONTROL_LOCAL_DATA_DIR: &str = "C:\\Program Files\\New Relic\\newrelic-agent-control";
@@ -121,8 +155,18 @@ cfg_if::cfg_if! {
     }
 }
 
+/// Folder name for the Agent Control local data when running in a confined package (snap or
+/// flatpak).
+pub const CONFINED_LOCAL_DATA_FOLDER_NAME: &str = "config";
+/// Folder name for the Agent Control data when `storage.writable_root` is set.
+pub const WRITABLE_ROOT_DATA_FOLDER_NAME: &str = "data";
+/// Folder name for the Agent Control logs when `storage.writable_root` is set.
+pub const WRITABLE_ROOT_LOG_FOLDER_NAME: &str = "log";
+
 /// - **On-host**: Used as the filename for the PID file (e.g., `newrelic-agent-control.pid`).
 pub const PID_FILE_NAME: &str = "newrelic-agent-control.pid";
+/// File caching the last detected cloud instance id, in the remote data directory.
+pub const CLOUD_INSTANCE_ID_CACHE_FILE_NAME: &str = "cloud_instance_id";
 
 /// - **On-host**: Used as the directory name (e.g., `.../fleet-data/` or `.../local-data/`).
 /// - **k8s**: Used as a ConfigMap prefix, followed by a hyphen (e.g., `local-data-agentid`).
@@ -134,11 +178,21 @@ pub const FOLDER_NAME_FLEET_DATA: &str = "fleet-data";
 /// - **k8s**: Used as the data key within the local ConfigMap.
 pub const STORE_KEY_LOCAL_DATA_CONFIG: &StoreKey = "local_config";
 
+/// - **On-host**: Used as the base filename, combined with ".yaml" (e.g., `local_mandatory_config.yaml`).
+/// - **k8s**: Used as the data key within the local ConfigMap.
+///
+/// Holds the local values that take precedence over the remote configuration.
+pub const STORE_KEY_LOCAL_MANDATORY_DATA_CONFIG: &StoreKey = "local_mandatory_config";
+
 /// - **On-host**: Used as the base filename, combined with ".yaml" (e.g., `remote_config.yaml`).
 /// - **k8s**: Used as the data key within the OpAMP/fleet ConfigMap.
 pub const STORE_KEY_OPAMP_DATA_CONFIG: &StoreKey = "remote_config";
 /// Store key for the persisted OpAMP instance id.
 pub const STORE_KEY_INSTANCE_ID: &StoreKey = "instance_id";
+/// Store key for the marker of an on-host self-update awaiting its restart.
+pub const STORE_KEY_SELF_UPDATE_IN_PROGRESS: &StoreKey = "self_update_in_progress";
+/// Store key for the marker of an Agent Control config apply that has not completed yet.
+pub const STORE_KEY_CONFIG_APPLY_IN_PROGRESS: &StoreKey = "config_apply_in_progress";
 /// Directory holding dynamically-fetched agent types.
 pub const DYNAMIC_AGENT_TYPES_DIR: &str = "dynamic-agent-types";
 /// File name holding the persisted OpAMP instance id.
@@ -150,14 +204,23 @@ pub const AGENT_FILESYSTEM_FOLDER_NAME: &str = "filesystem";
 pub const SHARED_FILESYSTEM_FOLDER_NAME: &str = "shared-filesystem";
 /// Folder name holding downloaded packages.
 pub const PACKAGES_FOLDER_NAME: &str = "packages";
+/// Folder name, in the data directory, holding the imported offline bundle.
+pub const OFFLINE_BUNDLE_FOLDER_NAME: &str = "offline-bundle";
+/// File name of the local control API Unix socket, created in the data directory.
+pub const CONTROL_SOCKET_FILE_NAME: &str = "control.sock";
 /// File name of the Agent Control log file.
 pub const AGENT_CONTROL_LOG_FILENAME: &str = "newrelic-agent-control.log";
 /// Suffix for per-agent stdout log files.
 pub const STDOUT_LOG_FILE_NAME_SUFFIX: &str = "stdout.log";
 /// Suffix for per-agent stderr log files.
 pub const STDERR_LOG_FILE_NAME_SUFFIX: &str = "stderr.log";
+/// Directory, inside the agent logs directory, holding the crash reports of its executables.
+pub const CRASH_REPORTS_DIR_NAME: &str = "crashes";
 /// Environment-variable prefix for Agent Control configuration overrides.
 pub const AGENT_CONTROL_CONFIG_ENV_VAR_PREFIX: &str = "NR_AC";
+/// Maximum size in bytes of the agent configuration values of a remote configuration. Bigger
+/// configurations are rejected before being parsed, keeping the memory used to parse them bounded.
+pub const REMOTE_CONFIG_MAX_SIZE_BYTES: usize = 8 * 1024 * 1024;
 
 /// Returns the default OpAMP [`Capabilities`] advertised by Agent Control.
 pub fn default_capabilities() -> Capabilities {
@@ -170,6 +233,19 @@ pub fn default_capabilities() -> Capabilities {
     )
 }
 
+/// Returns the OpAMP [`Capabilities`] advertised by Agent Control when it accepts the packages
+/// offered by the server. Package statuses are not reported since the OpAMP client can't send them.
+pub fn accepts_packages_capabilities() -> Capabilities {
+    capabilities!(
+        AgentCapabilities::ReportsHealth,
+        AgentCapabilities::AcceptsRemoteConfig,
+        AgentCapabilities::ReportsEffectiveConfig,
+        AgentCapabilities::ReportsRemoteConfig,
+        AgentCapabilities::ReportsStatus,
+        AgentCapabilities::AcceptsPackages
+    )
+}
+
 /// Returns the default OpAMP [`CustomCapabilities`] advertised by Agent Control.
 pub fn default_custom_capabilities() -> CustomCapabilities {
     CustomCapabilities {
@@ -181,6 +257,10 @@ pub fn default_custom_capabilities() -> CustomCapabilities {
 pub const AGENT_TYPE_NAME_INFRA_AGENT: &str = "com.newrelic.infrastructure";
 /// Agent type name of the New Relic OpenTelemetry collector (NRDOT).
 pub const AGENT_TYPE_NAME_NRDOT: &str = "com.newrelic.opentelemetry.collector";
+/// Agent type name of the upstream OpenTelemetry collector.
+pub const AGENT_TYPE_NAME_OTEL_COLLECTOR: &str = "io.opentelemetry.collector";
+/// Agent type name of the on-host Fluent Bit agent.
+pub const AGENT_TYPE_NAME_FLUENT_BIT: &str = "io.fluentbit";
 
 // Fleet Control auto generated agent id
 /// Fleet-Control auto-generated agent id for the infrastructure agent.
@@ -197,5 +277,8 @@ pub(crate) mod tests {
         // Detect possible breaking changes when upgrading opentelemetry-semantic-conventions
         assert_eq!(HOST_NAME_ATTRIBUTE_KEY, "host.name");
         assert_eq!(HOST_ID_ATTRIBUTE_KEY, "host.id");
+        assert_eq!(OS_VERSION_ATTRIBUTE_KEY, "os.version");
+        assert_eq!(CLOUD_PROVIDER_ATTRIBUTE_KEY, "cloud.provider");
+        assert_eq!(CLOUD_REGION_ATTRIBUTE_KEY, "cloud.region");
     }
 }
@@ -58,6 +58,14 @@ pub enum AgentControlError {
     /// One or more sub-agents failed to build/apply.
     #[error("failed to build agents: {0}")]
     BuildingSubagents(BuildingSubagentErrors),
+
+    /// A command received through the local control API could not be executed.
+    #[error("control command error: {0}")]
+    ControlCommand(String),
+
+    /// The configured log level could not be applied.
+    #[error("applying log level: {0}")]
+    LogLevel(String),
 }
 
 /// Accumulates per-agent errors collected while building or applying sub-agents.
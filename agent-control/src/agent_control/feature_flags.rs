@@ -0,0 +1,234 @@
+//! Feature flags gating risky behaviors so they can be rolled out gradually across a fleet.
+//!
+//! Flags are set in the local Agent Control config (`feature_flags`) and can be overridden, flag by
+//! flag, through the remote configuration (`feature_flag_overrides`), which allows rolling a
+//! behavior back from Fleet Control without touching the hosts. A flag is either turned on or off,
+//! or enabled for a percentage of the fleet. Instances are assigned to a stable bucket derived from
+//! their instance id, so the same instances stay enabled while the percentage grows.
+
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+
+/// Behaviors that can be gated by a feature flag.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum FeatureFlag {
+    /// Remote-driven self-update of Agent Control on-host.
+    SelfUpdate,
+}
+
+impl FeatureFlag {
+    /// Name of the flag in the configuration.
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::SelfUpdate => "self_update",
+        }
+    }
+
+    /// Whether the behavior is enabled when the flag is not configured.
+    fn default_enabled(self) -> bool {
+        match self {
+            Self::SelfUpdate => true,
+        }
+    }
+}
+
+/// Percentage of the fleet a flag is enabled for.
+#[derive(Debug, Clone, Copy, PartialEq, Serialize, Deserialize)]
+#[serde(try_from = "u8", into = "u8")]
+pub struct RolloutPercentage(u8);
+
+impl TryFrom<u8> for RolloutPercentage {
+    type Error = String;
+
+    fn try_from(value: u8) -> Result<Self, Self::Error> {
+        if value > 100 {
+            return Err(format!(
+                "rollout percentage must be between 0 and 100, got {value}"
+            ));
+        }
+        Ok(Self(value))
+    }
+}
+
+impl From<RolloutPercentage> for u8 {
+    fn from(value: RolloutPercentage) -> Self {
+        value.0
+    }
+}
+
+/// Value of a flag: either `true`/`false` or the percentage of the fleet it is enabled for.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(untagged)]
+pub enum FlagSetting {
+    /// Enabled or disabled for every instance.
+    Toggle(bool),
+    /// Enabled for the instances whose bucket falls below the percentage.
+    Rollout(RolloutPercentage),
+}
+
+impl FlagSetting {
+    fn is_enabled_for(&self, bucket: u8) -> bool {
+        match self {
+            Self::Toggle(enabled) => *enabled,
+            Self::Rollout(percentage) => bucket < percentage.0,
+        }
+    }
+}
+
+/// Configured flags by name. Unknown names are kept but ignored, so configs targeting newer
+/// versions are still accepted.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct FeatureFlags(HashMap<String, FlagSetting>);
+
+impl FeatureFlags {
+    /// Returns true if no flag is configured.
+    pub fn is_empty(&self) -> bool {
+        self.0.is_empty()
+    }
+
+    fn get(&self, flag: FeatureFlag) -> Option<&FlagSetting> {
+        self.0.get(flag.as_str())
+    }
+}
+
+impl<const N: usize> From<[(FeatureFlag, FlagSetting); N]> for FeatureFlags {
+    fn from(value: [(FeatureFlag, FlagSetting); N]) -> Self {
+        Self(
+            value
+                .into_iter()
+                .map(|(flag, setting)| (flag.as_str().to_string(), setting))
+                .collect(),
+        )
+    }
+}
+
+/// Evaluates the flags for a given Agent Control instance.
+#[derive(Debug, Clone, Default)]
+pub struct FeatureFlagEvaluator {
+    local: FeatureFlags,
+    rollout_key: String,
+}
+
+impl FeatureFlagEvaluator {
+    /// Builds an evaluator from the locally configured flags. `rollout_key` identifies the instance
+    /// when computing percentage rollouts, typically its instance id.
+    pub fn new(local: FeatureFlags, rollout_key: impl Into<String>) -> Self {
+        Self {
+            local,
+            rollout_key: rollout_key.into(),
+        }
+    }
+
+    /// Returns whether `flag` is enabled. Remote `overrides` take precedence over the local flags.
+    pub fn is_enabled(&self, flag: FeatureFlag, overrides: &FeatureFlags) -> bool {
+        overrides
+            .get(flag)
+            .or_else(|| self.local.get(flag))
+            .map(|setting| setting.is_enabled_for(bucket(flag, &self.rollout_key)))
+            .unwrap_or(flag.default_enabled())
+    }
+}
+
+/// Returns the bucket, in `0..100`, of the instance for the flag. It uses FNV-1a instead of the std
+/// hasher, whose output may change between Rust versions and would reshuffle the rollouts.
+fn bucket(flag: FeatureFlag, rollout_key: &str) -> u8 {
+    const FNV_OFFSET_BASIS: u64 = 0xcbf29ce484222325;
+    const FNV_PRIME: u64 = 0x100000001b3;
+
+    // The flag name is part of the input so each flag is rolled out to a different subset.
+    let hash = flag
+        .as_str()
+        .bytes()
+        .chain([b':'])
+        .chain(rollout_key.bytes())
+        .fold(FNV_OFFSET_BASIS, |hash, byte| {
+            (hash ^ u64::from(byte)).wrapping_mul(FNV_PRIME)
+        });
+    (hash % 100) as u8
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+
+    #[test]
+    fn test_feature_flags_deserialization() {
+        let flags: FeatureFlags = serde_saphyr::from_str(
+            r#"
+self_update: false
+some_future_flag: 25
+"#,
+        )
+        .unwrap();
+
+        assert_eq!(
+            flags.get(FeatureFlag::SelfUpdate),
+            Some(&FlagSetting::Toggle(false))
+        );
+        assert_eq!(
+            flags.0.get("some_future_flag"),
+            Some(&FlagSetting::Rollout(RolloutPercentage(25)))
+        );
+        assert!(serde_saphyr::from_str::<FeatureFlags>("self_update: 101").is_err());
+    }
+
+    #[rstest]
+    #[case::default(FeatureFlags::default(), FeatureFlags::default(), true)]
+    #[case::local(
+        FeatureFlags::from([(FeatureFlag::SelfUpdate, FlagSetting::Toggle(false))]),
+        FeatureFlags::default(),
+        false
+    )]
+    #[case::remote_override(
+        FeatureFlags::from([(FeatureFlag::SelfUpdate, FlagSetting::Toggle(true))]),
+        FeatureFlags::from([(FeatureFlag::SelfUpdate, FlagSetting::Toggle(false))]),
+        false
+    )]
+    #[case::full_rollout(
+        FeatureFlags::from([(FeatureFlag::SelfUpdate, FlagSetting::Toggle(false))]),
+        FeatureFlags::from([(FeatureFlag::SelfUpdate, FlagSetting::Rollout(RolloutPercentage(100)))]),
+        true
+    )]
+    #[case::no_rollout(
+        FeatureFlags::default(),
+        FeatureFlags::from([(FeatureFlag::SelfUpdate, FlagSetting::Rollout(RolloutPercentage(0)))]),
+        false
+    )]
+    fn test_is_enabled(
+        #[case] local: FeatureFlags,
+        #[case] overrides: FeatureFlags,
+        #[case] expected: bool,
+    ) {
+        let evaluator = FeatureFlagEvaluator::new(local, "instance-id");
+        assert_eq!(
+            evaluator.is_enabled(FeatureFlag::SelfUpdate, &overrides),
+            expected
+        );
+    }
+
+    #[test]
+    fn test_rollout_percentage_distribution() {
+        let overrides = FeatureFlags::from([(
+            FeatureFlag::SelfUpdate,
+            FlagSetting::Rollout(RolloutPercentage(30)),
+        )]);
+        let enabled = (0..1000)
+            .filter(|i| {
+                FeatureFlagEvaluator::new(FeatureFlags::default(), format!("instance-{i}"))
+                    .is_enabled(FeatureFlag::SelfUpdate, &overrides)
+            })
+            .count();
+        // Roughly 30% of the instances, with some margin for the hash distribution.
+        assert!((200..400).contains(&enabled), "enabled: {enabled}");
+    }
+
+    #[test]
+    fn test_bucket_is_stable() {
+        assert_eq!(
+            bucket(FeatureFlag::SelfUpdate, "instance-id"),
+            bucket(FeatureFlag::SelfUpdate, "instance-id")
+        );
+        assert!(bucket(FeatureFlag::SelfUpdate, "instance-id") < 100);
+    }
+}
@@ -5,6 +5,7 @@ use thiserror::Error;
 
 pub mod async_bridge;
 pub mod config;
+mod probe_handler;
 pub mod runner;
 pub mod server;
 pub(super) mod status;
@@ -0,0 +1,110 @@
+//! Actix handlers serving the `/healthz` and `/readyz` probe endpoints, so orchestrators like
+//! systemd, Kubernetes or load balancers can check Agent Control.
+//!
+//! Both endpoints return a [ProbeReport](super::status::ProbeReport) as JSON, with a `200 OK`
+//! status when the probe succeeds and a `503 Service Unavailable` one otherwise:
+//! - `/healthz` succeeds when Agent Control is healthy.
+//! - `/readyz` succeeds when Agent Control and every agent are healthy.
+
+use crate::agent_control::http_server::status::Status;
+use actix_web::HttpResponse;
+use actix_web::http::StatusCode;
+use actix_web::http::header::ContentType;
+use actix_web::web::Data;
+use std::sync::Arc;
+use tokio::sync::RwLock;
+
+pub(super) async fn healthz_handler(status: Data<Arc<RwLock<Status>>>) -> HttpResponse {
+    let status = status.read().await;
+    probe_response(&status, status.is_live())
+}
+
+pub(super) async fn readyz_handler(status: Data<Arc<RwLock<Status>>>) -> HttpResponse {
+    let status = status.read().await;
+    probe_response(&status, status.is_ready())
+}
+
+fn probe_response(status: &Status, healthy: bool) -> HttpResponse {
+    let body = serde_json::to_string(&status.probe_report(healthy)).unwrap();
+    let status_code = if healthy {
+        StatusCode::OK
+    } else {
+        StatusCode::SERVICE_UNAVAILABLE
+    };
+
+    HttpResponse::build(status_code)
+        .content_type(ContentType::json())
+        .body(body)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_control::agent_id::AgentID;
+    use crate::agent_control::http_server::status::SubAgentStatus;
+    use crate::agent_type::agent_type_id::AgentTypeID;
+    use crate::checkers::health::health_checker::{Healthy, Unhealthy};
+    use crate::checkers::health::with_start_time::HealthWithStartTime;
+    use crate::sub_agent::identity::AgentIdentity;
+    use actix_web::body::MessageBody;
+    use std::collections::HashMap;
+    use std::time::SystemTime;
+
+    fn status(agent_healthy: bool) -> Data<Arc<RwLock<Status>>> {
+        let agent_identity = AgentIdentity::from((
+            AgentID::try_from("some-agent-id").unwrap(),
+            AgentTypeID::try_from("namespace/some_agent_type:0.0.1").unwrap(),
+        ));
+        let mut sub_agent_status = SubAgentStatus::with_identity(agent_identity.clone());
+        let health = if agent_healthy {
+            Healthy::default().into()
+        } else {
+            Unhealthy::default()
+                .with_last_error("some error".to_string())
+                .into()
+        };
+        sub_agent_status.update_health(HealthWithStartTime::new(health, SystemTime::UNIX_EPOCH));
+        sub_agent_status.last_applied_config_hash = Some("a1b2c3".to_string());
+
+        let mut st = Status::default()
+            .with_sub_agents(HashMap::from([(agent_identity.id, sub_agent_status)]));
+        st.agent_control.set_health(HealthWithStartTime::new(
+            Healthy::default().into(),
+            SystemTime::now(),
+        ));
+        st.fleet.connected(SystemTime::UNIX_EPOCH);
+
+        Data::new(Arc::new(RwLock::new(st)))
+    }
+
+    fn body(response: HttpResponse) -> String {
+        String::from_utf8(response.into_body().try_into_bytes().unwrap().to_vec()).unwrap()
+    }
+
+    #[tokio::test(flavor = "multi_thread")]
+    async fn test_probes_with_healthy_agents() {
+        let response = healthz_handler(status(true)).await;
+        assert_eq!(response.status(), StatusCode::OK);
+
+        let response = readyz_handler(status(true)).await;
+        assert_eq!(response.status(), StatusCode::OK);
+        assert_eq!(
+            body(response),
+            r#"{"healthy":true,"agent_control":{"healthy":true},"last_opamp_connection_time_unix_nano":0,"agents":{"some-agent-id":{"healthy":true,"last_applied_config_hash":"a1b2c3"}}}"#
+        );
+    }
+
+    #[tokio::test(flavor = "multi_thread")]
+    async fn test_probes_with_unhealthy_agent() {
+        // Agent Control is still alive
+        let response = healthz_handler(status(false)).await;
+        assert_eq!(response.status(), StatusCode::OK);
+
+        let response = readyz_handler(status(false)).await;
+        assert_eq!(response.status(), StatusCode::SERVICE_UNAVAILABLE);
+        assert_eq!(
+            body(response),
+            r#"{"healthy":false,"agent_control":{"healthy":true},"last_opamp_connection_time_unix_nano":0,"agents":{"some-agent-id":{"healthy":false,"last_error":"some error","last_applied_config_hash":"a1b2c3"}}}"#
+        );
+    }
+}
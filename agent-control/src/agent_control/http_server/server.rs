@@ -1,8 +1,10 @@
-//! Actix-based status HTTP server: serves the `/status` endpoint and updates status from events.
+//! Actix-based status HTTP server: serves the `/status`, `/healthz` and `/readyz` endpoints and
+//! updates status from events.
 
 use crate::agent_control::config::OpAMPClientConfig;
 use crate::agent_control::http_server::StatusServerError;
 use crate::agent_control::http_server::config::{DEFAULT_WORKERS, ServerConfig};
+use crate::agent_control::http_server::probe_handler::{healthz_handler, readyz_handler};
 use crate::agent_control::http_server::status::Status;
 use crate::agent_control::http_server::status_handler::status_handler;
 use crate::agent_control::http_server::status_updater::on_agent_control_event_update_status;
@@ -137,6 +139,8 @@ async fn run_server(
             // The line below logs all requests as info level
             // .wrap(middleware::Logger::default())
             .service(web::resource("/status").to(status_handler))
+            .service(web::resource("/healthz").to(healthz_handler))
+            .service(web::resource("/readyz").to(readyz_handler))
     })
     .bind((server_config.host.to_string(), server_config.port.into()))
     {
@@ -1,4 +1,5 @@
-//! Serializable status model exposed by the `/status` endpoint (Agent Control, fleet and agents).
+//! Serializable status model exposed by the `/status` endpoint (Agent Control, fleet and agents),
+//! and the summary exposed by the `/healthz` and `/readyz` probe endpoints.
 
 use crate::agent_control::agent_id::AgentID;
 
@@ -10,7 +11,7 @@ use crate::sub_agent::identity::AgentIdentity;
 use opamp_client::operation::settings::{AgentDescription, DescriptionValueType};
 use serde::Serialize;
 
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 use std::time::SystemTime;
 use url::Url;
 
@@ -81,6 +82,8 @@ pub struct AgentControlStatus {
     status: String,
     #[serde(skip_serializing_if = "AgentAttributes::is_empty")]
     pub(super) attributes: AgentAttributes,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub(super) last_applied_config_hash: Option<String>,
 }
 
 impl AgentControlStatus {
@@ -125,6 +128,9 @@ pub struct OpAMPStatus {
     error_code: Option<LastErrorCode>,
     #[serde(skip_serializing_if = "Option::is_none")]
     error_message: Option<LastErrorMessage>,
+    /// Only exposed by the probe endpoints, see [ProbeReport].
+    #[serde(skip)]
+    pub(super) last_connected_time_unix_nano: Option<u64>,
 }
 
 impl OpAMPStatus {
@@ -134,6 +140,11 @@ impl OpAMPStatus {
         self.error_message = None;
     }
 
+    pub(super) fn connected(&mut self, time: SystemTime) {
+        self.reachable();
+        self.last_connected_time_unix_nano = Some(time_to_unix_timestamp(time));
+    }
+
     pub(super) fn unreachable(
         &mut self,
         error_code: Option<LastErrorCode>,
@@ -186,6 +197,8 @@ impl OpAMPStatus {
 /// - `agent_start_time_unix_nano`: A `u64` representing the start time of the Sub Agent in nanoseconds since the Unix epoch.
 /// - `health_info`: A `HealthInfo` struct containing the health-related information of the Sub Agent.
 /// - `attributes`: A map of dynamic agent attributes such as version, instance_uid, ...
+/// - `config_warnings`: Non-fatal warnings about the current configuration of the Sub Agent.
+/// - `last_applied_config_hash`: Hash of the last remote configuration applied to the Sub Agent.
 #[derive(Debug, Serialize, PartialEq, Clone)]
 pub(super) struct SubAgentStatus {
     agent_id: AgentID,
@@ -196,6 +209,10 @@ pub(super) struct SubAgentStatus {
     health_info: Option<HealthInfo>,
     #[serde(skip_serializing_if = "AgentAttributes::is_empty")]
     pub(super) attributes: AgentAttributes,
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub(super) config_warnings: Vec<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub(super) last_applied_config_hash: Option<String>,
 }
 
 /// Health-related information of a Sub Agent.
@@ -238,6 +255,8 @@ impl SubAgentStatus {
             agent_start_time_unix_nano: 0,
             health_info: None,
             attributes: Default::default(),
+            config_warnings: Vec::new(),
+            last_applied_config_hash: None,
         }
     }
 
@@ -282,6 +301,99 @@ impl Status {
     }
 }
 
+/// Summary of the health of Agent Control and its agents, served by the probe endpoints.
+///
+/// Example:
+/// ```json
+/// {
+///   "healthy": false,
+///   "agent_control": {
+///     "healthy": true,
+///     "last_applied_config_hash": "a1b2c3"
+///   },
+///   "last_opamp_connection_time_unix_nano": 1672531205000000000,
+///   "agents": {
+///     "nr-infra": {
+///       "healthy": false,
+///       "status": "starting",
+///       "last_error": "process exited with code 1"
+///     }
+///   }
+/// }
+/// ```
+#[derive(Debug, Serialize, PartialEq)]
+pub(super) struct ProbeReport<'a> {
+    healthy: bool,
+    agent_control: ComponentProbe<'a>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    last_opamp_connection_time_unix_nano: Option<u64>,
+    agents: BTreeMap<String, ComponentProbe<'a>>,
+}
+
+/// Health of Agent Control or one of its agents in a [ProbeReport].
+#[derive(Debug, Serialize, PartialEq)]
+struct ComponentProbe<'a> {
+    healthy: bool,
+    #[serde(skip_serializing_if = "str::is_empty")]
+    status: &'a str,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    last_error: Option<&'a str>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    last_applied_config_hash: Option<&'a str>,
+}
+
+impl Status {
+    /// Whether Agent Control reported itself healthy. Checked by the `/healthz` endpoint.
+    pub(super) fn is_live(&self) -> bool {
+        self.agent_control.healthy
+    }
+
+    /// Whether Agent Control and every agent reported themselves healthy. Checked by the
+    /// `/readyz` endpoint.
+    pub(super) fn is_ready(&self) -> bool {
+        self.is_live()
+            && self.agents.values().all(|agent| {
+                agent
+                    .health_info
+                    .as_ref()
+                    .is_some_and(|health| health.healthy)
+            })
+    }
+
+    /// Builds the summary served by the probe endpoints, `healthy` being the probe result.
+    pub(super) fn probe_report(&self, healthy: bool) -> ProbeReport<'_> {
+        let agent_control = &self.agent_control;
+        let agents = self
+            .agents
+            .iter()
+            .map(|(agent_id, agent)| {
+                let health = agent.health_info.as_ref();
+                let probe = ComponentProbe {
+                    healthy: health.is_some_and(|health| health.healthy),
+                    status: health
+                        .map(|health| health.status.as_str())
+                        .unwrap_or_default(),
+                    last_error: health.and_then(|health| health.last_error.as_deref()),
+                    last_applied_config_hash: agent.last_applied_config_hash.as_deref(),
+                };
+                (agent_id.to_string(), probe)
+            })
+            .collect();
+
+        ProbeReport {
+            healthy,
+            agent_control: ComponentProbe {
+                healthy: agent_control.healthy,
+                status: &agent_control.status,
+                last_error: agent_control.last_error.as_deref(),
+                last_applied_config_hash: agent_control.last_applied_config_hash.as_deref(),
+            },
+            last_opamp_connection_time_unix_nano: self.fleet.last_connected_time_unix_nano,
+            agents,
+        }
+    }
+}
+
 fn time_to_unix_timestamp(time: SystemTime) -> u64 {
     time.duration_since(SystemTime::UNIX_EPOCH)
         .unwrap_or_default()
@@ -323,6 +435,7 @@ pub mod tests {
                 last_error: None,
                 status,
                 attributes: Default::default(),
+                last_applied_config_hash: None,
             }
         }
         pub fn new_unhealthy(status: String, last_error: String) -> Self {
@@ -331,11 +444,19 @@ pub mod tests {
                 last_error: Some(last_error),
                 status,
                 attributes: Default::default(),
+                last_applied_config_hash: None,
             }
         }
         pub fn with_attributes(self, attributes: HashMap<String, String>) -> Self {
             Self { attributes, ..self }
         }
+
+        pub fn with_config_warnings(self, config_warnings: Vec<String>) -> Self {
+            Self {
+                config_warnings,
+                ..self
+            }
+        }
     }
 
     impl SubAgentStatus {
@@ -351,6 +472,8 @@ pub mod tests {
                 agent_start_time_unix_nano,
                 health_info: Some(health_info),
                 attributes: Default::default(),
+                config_warnings: Vec::new(),
+                last_applied_config_hash: None,
             }
         }
 
@@ -385,6 +508,7 @@ pub mod tests {
                 reachable: true,
                 error_code: None,
                 error_message: None,
+                last_connected_time_unix_nano: None,
             }
         }
         pub fn enabled_and_unreachable(
@@ -398,6 +522,7 @@ pub mod tests {
                 reachable: false,
                 error_code: Some(error_code),
                 error_message: Some(error_message),
+                last_connected_time_unix_nano: None,
             }
         }
     }
@@ -410,6 +535,7 @@ pub mod tests {
                 last_error: None,
                 status: "".to_string(),
                 attributes: Default::default(),
+                last_applied_config_hash: None,
             },
             fleet: OpAMPStatus {
                 enabled: true,
@@ -417,6 +543,7 @@ pub mod tests {
                 reachable: true,
                 error_code: None,
                 error_message: None,
+                last_connected_time_unix_nano: None,
             },
             agents: SubAgentsStatus::from([
                 (
@@ -427,6 +554,8 @@ pub mod tests {
                         agent_start_time_unix_nano: 0,
                         health_info: None,
                         attributes: Default::default(),
+                        config_warnings: Vec::new(),
+                        last_applied_config_hash: None,
                     },
                 ),
                 (
@@ -443,6 +572,8 @@ pub mod tests {
                             status_time_unix_nano: 0,
                         }),
                         attributes: Default::default(),
+                        config_warnings: Vec::new(),
+                        last_applied_config_hash: None,
                     },
                 ),
                 (
@@ -459,6 +590,8 @@ pub mod tests {
                             status_time_unix_nano: 0,
                         }),
                         attributes: Default::default(),
+                        config_warnings: Vec::new(),
+                        last_applied_config_hash: None,
                     },
                 ),
             ]),
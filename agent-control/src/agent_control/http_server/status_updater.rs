@@ -3,6 +3,7 @@
 use crate::agent_control::http_server::status::{Status, SubAgentStatus, build_agent_attributes};
 use crate::event::{AgentControlEvent, SubAgentEvent};
 use std::sync::Arc;
+use std::time::SystemTime;
 use tokio::sync::RwLock;
 use tokio::sync::mpsc::UnboundedReceiver;
 use tracing::{debug, trace, warn};
@@ -67,7 +68,7 @@ async fn update_agent_control_status(
         }
         AgentControlEvent::OpAMPConnected => {
             trace!("opamp server is reachable");
-            status.fleet.reachable();
+            status.fleet.connected(SystemTime::now());
         }
         AgentControlEvent::OpAMPConnectFailed(error_code, error_message) => {
             debug!(
@@ -82,6 +83,12 @@ async fn update_agent_control_status(
             let attributes_update = build_agent_attributes(agent_description);
             status.agent_control.attributes.extend(attributes_update);
         }
+        AgentControlEvent::ConfigApplied(hash) => {
+            status.agent_control.last_applied_config_hash = Some(hash.to_string());
+        }
+        AgentControlEvent::ConfigFailed(hash, _) => {
+            trace!(%hash, "Agent Control remote config failed, keeping the last applied one");
+        }
     }
 }
 
@@ -122,6 +129,23 @@ async fn update_sub_agent_status(sub_agent_event: SubAgentEvent, status: Arc<RwL
                 .attributes
                 .extend(attributes_update);
         }
+        SubAgentEvent::ConfigLinted(agent_identity, config_warnings) => {
+            status
+                .agents
+                .entry(agent_identity.id.clone())
+                .or_insert_with(|| SubAgentStatus::with_identity(agent_identity))
+                .config_warnings = config_warnings;
+        }
+        SubAgentEvent::ConfigApplied(agent_identity, hash) => {
+            status
+                .agents
+                .entry(agent_identity.id.clone())
+                .or_insert_with(|| SubAgentStatus::with_identity(agent_identity))
+                .last_applied_config_hash = Some(hash.to_string());
+        }
+        SubAgentEvent::ConfigFailed(agent_identity, hash, _) => {
+            trace!(agent_id = %agent_identity.id, %hash, "Remote config failed, keeping the last applied one");
+        }
     }
 }
 
@@ -369,9 +393,14 @@ mod tests {
         #[case] current_status: Status,
         #[case] expected_status: Status,
     ) {
+        let connected = event == AgentControlEvent::OpAMPConnected;
         let status = Arc::new(RwLock::new(current_status));
         update_agent_control_status(event, status.clone()).await;
-        assert_eq!(expected_status, *status.read().await);
+        let mut status = status.write().await;
+        if connected {
+            assert!(status.fleet.last_connected_time_unix_nano.take().is_some());
+        }
+        assert_eq!(expected_status, *status);
     }
 
     #[rstest]
@@ -645,6 +674,30 @@ mod tests {
             )]),
         },
     )]
+    #[case::config_linted_replaces_warnings(
+        SubAgentEvent::ConfigLinted(
+            agent_identity("some-agent-id"),
+            vec!["some warning".to_string()],
+        ),
+        Status {
+            agent_control: fixed_agent_control(),
+            fleet: fixed_fleet(),
+            agents: SubAgentsStatus::from([(
+                AgentID::try_from("some-agent-id").unwrap(),
+                SubAgentStatus::with_identity(agent_identity("some-agent-id"))
+                    .with_config_warnings(vec!["previous warning".to_string()]),
+            )]),
+        },
+        Status {
+            agent_control: fixed_agent_control(),
+            fleet: fixed_fleet(),
+            agents: SubAgentsStatus::from([(
+                AgentID::try_from("some-agent-id").unwrap(),
+                SubAgentStatus::with_identity(agent_identity("some-agent-id"))
+                    .with_config_warnings(vec!["some warning".to_string()]),
+            )]),
+        },
+    )]
     #[tokio::test]
     async fn test_update_sub_agent_status(
         #[case] event: SubAgentEvent,
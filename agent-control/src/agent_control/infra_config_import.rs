@@ -0,0 +1,320 @@
+//! One-time import of the configuration of an Infrastructure agent installed on the host before
+//! Agent Control.
+//!
+//! When an agent of the Infrastructure agent type has no local configuration yet, the
+//! `newrelic-infra.yml` file and the `integrations.d` and `logging.d` directories of the existing
+//! installation are imported as its local configuration, so the agent keeps reporting the same
+//! data once Agent Control supervises it. Once written, the local configuration is never replaced,
+//! which makes the import run only on the first start.
+//!
+//! The imported configuration can also be translated into OpenTelemetry collector configuration,
+//! see [collector].
+
+pub mod collector;
+
+use crate::agent_control::agent_id::AgentID;
+use crate::agent_control::config::SubAgentsMap;
+use crate::agent_control::defaults::{
+    AGENT_TYPE_NAME_INFRA_AGENT, FOLDER_NAME_LOCAL_DATA, STORE_KEY_LOCAL_DATA_CONFIG,
+};
+use crate::on_host::file_store::build_config_name;
+use crate::values::yaml_config::YAMLConfig;
+use serde_json::{Map, Value};
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use tracing::{debug, info, warn};
+
+#[cfg(target_family = "unix")]
+const INFRA_CONFIG_FILE: &str = "/etc/newrelic-infra.yml";
+#[cfg(target_family = "unix")]
+const INFRA_CONFIG_DIR: &str = "/etc/newrelic-infra";
+#[cfg(target_family = "windows")]
+const INFRA_CONFIG_FILE: &str = r"C:\Program Files\New Relic\newrelic-infra\newrelic-infra.yml";
+#[cfg(target_family = "windows")]
+const INFRA_CONFIG_DIR: &str = r"C:\Program Files\New Relic\newrelic-infra";
+
+/// Variables of the Infrastructure agent type holding its configuration.
+const CONFIG_AGENT_VARIABLE: &str = "config_agent";
+const CONFIG_INTEGRATIONS_VARIABLE: &str = "config_integrations";
+const CONFIG_LOGGING_VARIABLE: &str = "config_logging";
+
+/// Location of the configuration of an existing Infrastructure agent installation.
+#[derive(Debug, Clone)]
+pub struct InfraConfigPaths {
+    /// Agent configuration file.
+    pub config_file: PathBuf,
+    /// Directory holding the on-host integrations configuration files.
+    pub integrations_dir: PathBuf,
+    /// Directory holding the log forwarding configuration files.
+    pub logging_dir: PathBuf,
+}
+
+impl Default for InfraConfigPaths {
+    fn default() -> Self {
+        Self {
+            config_file: PathBuf::from(INFRA_CONFIG_FILE),
+            integrations_dir: Path::new(INFRA_CONFIG_DIR).join("integrations.d"),
+            logging_dir: Path::new(INFRA_CONFIG_DIR).join("logging.d"),
+        }
+    }
+}
+
+/// Settings imported as the local configuration of an agent.
+#[derive(Debug, Default, Clone, PartialEq)]
+pub struct ImportedInfraConfig {
+    /// Top-level settings of the agent configuration file.
+    pub settings: Vec<String>,
+    /// Imported integrations configuration files.
+    pub integrations: Vec<String>,
+    /// Imported log forwarding configuration files.
+    pub logging: Vec<String>,
+}
+
+impl ImportedInfraConfig {
+    fn is_empty(&self) -> bool {
+        self.settings.is_empty() && self.integrations.is_empty() && self.logging.is_empty()
+    }
+}
+
+/// Imports the existing Infrastructure agent configuration as the local configuration of every
+/// agent of the Infrastructure agent type that doesn't have one, returning what was imported for
+/// each of them.
+pub fn import_infra_config(
+    paths: &InfraConfigPaths,
+    local_dir: &Path,
+    agents: &SubAgentsMap,
+) -> io::Result<Vec<(AgentID, ImportedInfraConfig)>> {
+    let mut infra_agents = agents
+        .iter()
+        .filter(|(_, config)| config.agent_type.name() == AGENT_TYPE_NAME_INFRA_AGENT)
+        .map(|(agent_id, _)| agent_id)
+        .filter(|agent_id| !local_config_path(local_dir, agent_id).exists())
+        .peekable();
+    if infra_agents.peek().is_none() {
+        return Ok(Vec::new());
+    }
+
+    let (values, imported) = load_infra_config(paths)?;
+    if imported.is_empty() {
+        debug!("No existing Infrastructure agent configuration to import");
+        return Ok(Vec::new());
+    }
+    let content = String::try_from(values)
+        .map_err(|err| io::Error::new(io::ErrorKind::InvalidData, err.to_string()))?;
+
+    let mut result = Vec::new();
+    for agent_id in infra_agents {
+        let path = local_config_path(local_dir, agent_id);
+        if let Some(parent) = path.parent() {
+            fs::create_dir_all(parent)?;
+        }
+        fs::write(&path, &content)?;
+        info!(
+            %agent_id,
+            settings = ?imported.settings,
+            integrations = ?imported.integrations,
+            logging = ?imported.logging,
+            "Imported the existing Infrastructure agent configuration"
+        );
+        result.push((agent_id.clone(), imported.clone()));
+    }
+    Ok(result)
+}
+
+/// Reads the existing configuration as the values of the Infrastructure agent type.
+fn load_infra_config(paths: &InfraConfigPaths) -> io::Result<(YAMLConfig, ImportedInfraConfig)> {
+    let mut values = Map::new();
+    let mut imported = ImportedInfraConfig::default();
+
+    if let Some(config) = read_yaml(&paths.config_file)? {
+        imported.settings = config
+            .as_object()
+            .map(|settings| settings.keys().cloned().collect())
+            .unwrap_or_default();
+        imported.settings.sort();
+        values.insert(CONFIG_AGENT_VARIABLE.to_string(), config);
+    }
+    let integrations = read_yaml_dir(&paths.integrations_dir)?;
+    if !integrations.is_empty() {
+        imported.integrations = integrations.keys().cloned().collect();
+        imported.integrations.sort();
+        values.insert(
+            CONFIG_INTEGRATIONS_VARIABLE.to_string(),
+            Value::Object(integrations),
+        );
+    }
+    let logging = read_yaml_dir(&paths.logging_dir)?;
+    if !logging.is_empty() {
+        imported.logging = logging.keys().cloned().collect();
+        imported.logging.sort();
+        values.insert(CONFIG_LOGGING_VARIABLE.to_string(), Value::Object(logging));
+    }
+
+    let values = serde_json::from_value(Value::Object(values))
+        .map_err(|err| io::Error::new(io::ErrorKind::InvalidData, err))?;
+    Ok((values, imported))
+}
+
+/// Reads the YAML files of `dir` keyed by their name. Files that can't be parsed are skipped.
+fn read_yaml_dir(dir: &Path) -> io::Result<Map<String, Value>> {
+    let entries = match fs::read_dir(dir) {
+        Ok(entries) => entries,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(Map::new()),
+        Err(err) => return Err(err),
+    };
+    let mut files = Map::new();
+    for entry in entries {
+        let path = entry?.path();
+        let is_yaml = path
+            .extension()
+            .is_some_and(|ext| ext == "yaml" || ext == "yml");
+        let Some(name) = path.file_name().and_then(|name| name.to_str()) else {
+            continue;
+        };
+        if !is_yaml || !path.is_file() {
+            continue;
+        }
+        if let Some(content) = read_yaml(&path)? {
+            files.insert(name.to_string(), content);
+        }
+    }
+    Ok(files)
+}
+
+/// Reads the YAML file at `path`, if it exists and is valid.
+fn read_yaml(path: &Path) -> io::Result<Option<Value>> {
+    let content = match fs::read_to_string(path) {
+        Ok(content) => content,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(None),
+        Err(err) => return Err(err),
+    };
+    match serde_saphyr::from_str::<Value>(&content) {
+        Ok(Value::Null) => Ok(None),
+        Ok(value) => Ok(Some(value)),
+        Err(err) => {
+            warn!(file = %path.display(), "Skipping invalid Infrastructure agent configuration: {err}");
+            Ok(None)
+        }
+    }
+}
+
+fn local_config_path(local_dir: &Path, agent_id: &AgentID) -> PathBuf {
+    local_dir
+        .join(FOLDER_NAME_LOCAL_DATA)
+        .join(agent_id)
+        .join(build_config_name(STORE_KEY_LOCAL_DATA_CONFIG))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_control::config::AgentControlDynamicConfig;
+    use serde_json::json;
+    use tempfile::TempDir;
+
+    const AGENTS: &str = r#"
+agents:
+  nr-infra:
+    agent_type: newrelic/com.newrelic.infrastructure:0.1.0
+  otel:
+    agent_type: newrelic/io.opentelemetry.collector:0.1.0
+"#;
+
+    fn infra_installation(dir: &Path) -> InfraConfigPaths {
+        let paths = InfraConfigPaths {
+            config_file: dir.join("newrelic-infra.yml"),
+            integrations_dir: dir.join("integrations.d"),
+            logging_dir: dir.join("logging.d"),
+        };
+        fs::create_dir_all(&paths.integrations_dir).unwrap();
+        fs::write(
+            &paths.config_file,
+            "license_key: some-key\ndisplay_name: host\n",
+        )
+        .unwrap();
+        fs::write(
+            paths.integrations_dir.join("nginx-config.yml"),
+            "integrations:\n  - name: nri-nginx\n",
+        )
+        .unwrap();
+        fs::write(
+            paths.integrations_dir.join("nginx-config.yml.sample"),
+            "integrations: []\n",
+        )
+        .unwrap();
+        fs::write(
+            paths.integrations_dir.join("invalid.yml"),
+            "integrations: [\n",
+        )
+        .unwrap();
+        paths
+    }
+
+    #[test]
+    fn test_import_infra_config() {
+        let tmp_dir = TempDir::new().unwrap();
+        let paths = infra_installation(&tmp_dir.path().join("etc"));
+        let local_dir = tmp_dir.path().join("local");
+        let agents = serde_saphyr::from_str::<AgentControlDynamicConfig>(AGENTS)
+            .unwrap()
+            .agents;
+
+        let imported = import_infra_config(&paths, &local_dir, &agents).unwrap();
+
+        let infra_id = AgentID::try_from("nr-infra").unwrap();
+        assert_eq!(
+            imported,
+            vec![(
+                infra_id.clone(),
+                ImportedInfraConfig {
+                    settings: vec!["display_name".to_string(), "license_key".to_string()],
+                    integrations: vec!["nginx-config.yml".to_string()],
+                    logging: Vec::new(),
+                }
+            )]
+        );
+        let values = fs::read_to_string(local_config_path(&local_dir, &infra_id)).unwrap();
+        let values = YAMLConfig::try_from(values).unwrap();
+        assert_eq!(
+            values.get(CONFIG_AGENT_VARIABLE),
+            Some(&json!({"license_key": "some-key", "display_name": "host"}))
+        );
+        assert_eq!(
+            values.get(CONFIG_INTEGRATIONS_VARIABLE),
+            Some(&json!({"nginx-config.yml": {"integrations": [{"name": "nri-nginx"}]}}))
+        );
+        assert!(values.get(CONFIG_LOGGING_VARIABLE).is_none());
+        let otel_id = AgentID::try_from("otel").unwrap();
+        assert!(!local_config_path(&local_dir, &otel_id).exists());
+
+        // The local configuration is not replaced on later starts
+        fs::write(&paths.config_file, "license_key: other-key\n").unwrap();
+        assert!(
+            import_infra_config(&paths, &local_dir, &agents)
+                .unwrap()
+                .is_empty()
+        );
+    }
+
+    #[test]
+    fn test_import_infra_config_without_installation() {
+        let tmp_dir = TempDir::new().unwrap();
+        let paths = InfraConfigPaths {
+            config_file: tmp_dir.path().join("newrelic-infra.yml"),
+            integrations_dir: tmp_dir.path().join("integrations.d"),
+            logging_dir: tmp_dir.path().join("logging.d"),
+        };
+        let local_dir = tmp_dir.path().join("local");
+        let agents = serde_saphyr::from_str::<AgentControlDynamicConfig>(AGENTS)
+            .unwrap()
+            .agents;
+
+        assert!(
+            import_infra_config(&paths, &local_dir, &agents)
+                .unwrap()
+                .is_empty()
+        );
+        assert!(!local_dir.exists());
+    }
+}
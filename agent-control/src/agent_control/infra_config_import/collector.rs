@@ -0,0 +1,323 @@
+//! Translation of the Infrastructure agent configuration into OpenTelemetry collector
+//! configuration, so hosts can be migrated to the collector gradually.
+//!
+//! The common settings are translated into a collector configuration fragment, meant to be merged
+//! into the configuration of the collector:
+//! - `license_key`, `proxy` and `ca_bundle_file` configure the `otlphttp/newrelic` exporter.
+//! - `custom_attributes` are added to the telemetry by the `resource/custom_attributes` processor.
+//! - The `logs` blocks of the log forwarding configuration files become `filelog`, `journald` and
+//!   `syslog` receivers of the `logs/infra` pipeline.
+//!
+//! Log forwarding settings without equivalent are reported as untranslated.
+
+use serde::Deserialize;
+use serde_json::{Map, Value, json};
+
+const EXPORTER: &str = "otlphttp/newrelic";
+const CUSTOM_ATTRIBUTES_PROCESSOR: &str = "resource/custom_attributes";
+const BATCH_PROCESSOR: &str = "batch";
+const LOGS_PIPELINE: &str = "logs/infra";
+
+const OTLP_ENDPOINT_US: &str = "https://otlp.nr-data.net";
+const OTLP_ENDPOINT_EU: &str = "https://otlp.eu01.nr-data.net";
+/// License keys of accounts in the EU region start with this prefix.
+const EU_LICENSE_KEY_PREFIX: &str = "eu";
+/// Header carrying the license key in the New Relic OTLP ingest.
+const API_KEY_HEADER: &str = "api-key";
+/// Syslog format parsed by the Infrastructure agent when none is set.
+const DEFAULT_SYSLOG_PARSER: &str = "rfc3164";
+
+/// Collector configuration translated from the Infrastructure agent configuration.
+#[derive(Debug, Default, PartialEq)]
+pub struct Translation {
+    /// Collector configuration fragment.
+    pub config: Value,
+    /// Settings that could not be translated.
+    pub untranslated: Vec<String>,
+}
+
+#[derive(Debug, Default, Deserialize)]
+struct InfraConfig {
+    #[serde(default)]
+    license_key: Option<String>,
+    #[serde(default)]
+    proxy: Option<String>,
+    #[serde(default)]
+    ca_bundle_file: Option<String>,
+    #[serde(default)]
+    custom_attributes: Map<String, Value>,
+}
+
+#[derive(Debug, Default, Deserialize)]
+struct LoggingConfig {
+    #[serde(default)]
+    logs: Vec<LogBlock>,
+}
+
+#[derive(Debug, Deserialize)]
+struct LogBlock {
+    name: String,
+    #[serde(default)]
+    file: Option<String>,
+    #[serde(default)]
+    systemd: Option<String>,
+    #[serde(default)]
+    syslog: Option<SyslogBlock>,
+    #[serde(default)]
+    attributes: Map<String, Value>,
+    /// Settings without a collector equivalent, such as `pattern`, `tcp` or `fluentbit`.
+    #[serde(flatten)]
+    other: Map<String, Value>,
+}
+
+#[derive(Debug, Deserialize)]
+struct SyslogBlock {
+    uri: String,
+    #[serde(default)]
+    parser: Option<String>,
+}
+
+/// Translates the agent configuration `infra_config` and the log forwarding configuration files
+/// `logging`, keyed by file name, into collector configuration.
+pub fn translate(
+    infra_config: &Value,
+    logging: &Map<String, Value>,
+) -> Result<Translation, serde_json::Error> {
+    let infra_config: InfraConfig = match infra_config {
+        Value::Null => InfraConfig::default(),
+        config => serde_json::from_value(config.clone())?,
+    };
+    let mut untranslated = Vec::new();
+
+    let mut receivers = Map::new();
+    for (file, content) in logging {
+        let logging_config: LoggingConfig = serde_json::from_value(content.clone())?;
+        for block in logging_config.logs {
+            match log_receiver(&block) {
+                Some((name, receiver)) => {
+                    receivers.insert(name, receiver);
+                }
+                None => untranslated.push(format!("{file}: logs '{}'", block.name)),
+            }
+            untranslated.extend(
+                block
+                    .other
+                    .keys()
+                    .map(|setting| format!("{file}: logs '{}' {setting}", block.name)),
+            );
+        }
+    }
+
+    let mut processors = Map::new();
+    if !infra_config.custom_attributes.is_empty() {
+        let attributes = infra_config
+            .custom_attributes
+            .iter()
+            .map(|(key, value)| json!({"key": key, "value": value, "action": "upsert"}))
+            .collect::<Vec<_>>();
+        processors.insert(
+            CUSTOM_ATTRIBUTES_PROCESSOR.to_string(),
+            json!({ "attributes": attributes }),
+        );
+    }
+
+    let mut config = json!({
+        "exporters": { EXPORTER: exporter(&infra_config) },
+    });
+    if !receivers.is_empty() {
+        let pipeline_processors = processors
+            .keys()
+            .cloned()
+            .chain([BATCH_PROCESSOR.to_string()])
+            .collect::<Vec<_>>();
+        processors.insert(BATCH_PROCESSOR.to_string(), json!({}));
+        config["service"] = json!({
+            "pipelines": {
+                LOGS_PIPELINE: {
+                    "receivers": receivers.keys().collect::<Vec<_>>(),
+                    "processors": pipeline_processors,
+                    "exporters": [EXPORTER],
+                }
+            }
+        });
+        config["receivers"] = Value::Object(receivers);
+    }
+    if !processors.is_empty() {
+        config["processors"] = Value::Object(processors);
+    }
+
+    Ok(Translation {
+        config,
+        untranslated,
+    })
+}
+
+fn exporter(infra_config: &InfraConfig) -> Value {
+    let endpoint = match &infra_config.license_key {
+        Some(key) if key.starts_with(EU_LICENSE_KEY_PREFIX) => OTLP_ENDPOINT_EU,
+        _ => OTLP_ENDPOINT_US,
+    };
+    let mut exporter = json!({ "endpoint": endpoint });
+    if let Some(license_key) = &infra_config.license_key {
+        exporter["headers"] = json!({ API_KEY_HEADER: license_key });
+    }
+    if let Some(proxy) = &infra_config.proxy {
+        exporter["proxy_url"] = json!(proxy);
+    }
+    if let Some(ca_file) = &infra_config.ca_bundle_file {
+        exporter["tls"] = json!({ "ca_file": ca_file });
+    }
+    exporter
+}
+
+/// Returns the name and configuration of the receiver equivalent to the log forwarding block.
+fn log_receiver(block: &LogBlock) -> Option<(String, Value)> {
+    let (receiver_type, mut receiver) = if let Some(file) = &block.file {
+        ("filelog", json!({ "include": [file] }))
+    } else if let Some(unit) = &block.systemd {
+        ("journald", json!({ "units": [unit] }))
+    } else if let Some(syslog) = &block.syslog {
+        let (protocol, address) = syslog.uri.split_once("://")?;
+        if protocol != "tcp" && protocol != "udp" {
+            return None;
+        }
+        let parser = syslog.parser.as_deref().unwrap_or(DEFAULT_SYSLOG_PARSER);
+        (
+            "syslog",
+            json!({ "protocol": parser, protocol: { "listen_address": address } }),
+        )
+    } else {
+        return None;
+    };
+    if !block.attributes.is_empty() {
+        receiver["attributes"] = Value::Object(block.attributes.clone());
+    }
+    Some((format!("{receiver_type}/{}", block.name), receiver))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn logging(content: &str) -> Map<String, Value> {
+        Map::from_iter([(
+            "logging.yml".to_string(),
+            serde_saphyr::from_str(content).unwrap(),
+        )])
+    }
+
+    #[test]
+    fn test_translate() {
+        let infra_config = serde_saphyr::from_str(
+            r#"
+license_key: eu01xxlicense
+proxy: http://proxy:8080
+ca_bundle_file: /etc/ssl/ca.pem
+custom_attributes:
+  environment: production
+"#,
+        )
+        .unwrap();
+        let logging = logging(
+            r#"
+logs:
+  - name: app
+    file: /var/log/app/*.log
+    attributes:
+      team: backend
+  - name: sshd
+    systemd: sshd
+  - name: syslog
+    syslog:
+      uri: tcp://0.0.0.0:5140
+      parser: rfc5424
+"#,
+        );
+
+        let translation = translate(&infra_config, &logging).unwrap();
+        assert_eq!(
+            translation.config,
+            json!({
+                "exporters": {
+                    "otlphttp/newrelic": {
+                        "endpoint": "https://otlp.eu01.nr-data.net",
+                        "headers": {"api-key": "eu01xxlicense"},
+                        "proxy_url": "http://proxy:8080",
+                        "tls": {"ca_file": "/etc/ssl/ca.pem"},
+                    }
+                },
+                "processors": {
+                    "batch": {},
+                    "resource/custom_attributes": {
+                        "attributes": [
+                            {"key": "environment", "value": "production", "action": "upsert"}
+                        ]
+                    },
+                },
+                "receivers": {
+                    "filelog/app": {
+                        "include": ["/var/log/app/*.log"],
+                        "attributes": {"team": "backend"},
+                    },
+                    "journald/sshd": {"units": ["sshd"]},
+                    "syslog/syslog": {
+                        "protocol": "rfc5424",
+                        "tcp": {"listen_address": "0.0.0.0:5140"},
+                    },
+                },
+                "service": {
+                    "pipelines": {
+                        "logs/infra": {
+                            "receivers": ["filelog/app", "journald/sshd", "syslog/syslog"],
+                            "processors": ["resource/custom_attributes", "batch"],
+                            "exporters": ["otlphttp/newrelic"],
+                        }
+                    }
+                },
+            })
+        );
+        assert!(translation.untranslated.is_empty());
+    }
+
+    #[test]
+    fn test_translate_untranslated_settings() {
+        let logging = logging(
+            r#"
+logs:
+  - name: app
+    file: /var/log/app.log
+    pattern: ERROR
+  - name: fluentbit
+    fluentbit:
+      config_file: /etc/fluent-bit.conf
+"#,
+        );
+
+        let translation = translate(&json!({"license_key": "license"}), &logging).unwrap();
+        assert_eq!(
+            translation.config["exporters"]["otlphttp/newrelic"]["endpoint"],
+            json!("https://otlp.nr-data.net")
+        );
+        assert_eq!(
+            translation.config["service"]["pipelines"]["logs/infra"]["receivers"],
+            json!(["filelog/app"])
+        );
+        assert_eq!(
+            translation.untranslated,
+            vec![
+                "logging.yml: logs 'app' pattern".to_string(),
+                "logging.yml: logs 'fluentbit'".to_string(),
+                "logging.yml: logs 'fluentbit' fluentbit".to_string(),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_translate_without_logs() {
+        let translation = translate(&Value::Null, &Map::new()).unwrap();
+        assert_eq!(
+            translation.config,
+            json!({"exporters": {"otlphttp/newrelic": {"endpoint": "https://otlp.nr-data.net"}}})
+        );
+    }
+}
@@ -61,7 +61,8 @@ where
     F: FileWriter + FileReader,
 {
     /// The method store will first read the file path and if it finds content, will try to match
-    /// the content (a pid) with an existing pid in the "/proc" folder from the filesystem.
+    /// the content (a pid) with an existing pid in the "/proc" folder from the filesystem, or with
+    /// a running process if there is no "/proc" folder.
     /// If one is found an error will be returned, meaning there is already an instance
     /// of the agent-control running.
     /// If no pid is found in the "/proc" folder the pid will be stored in the file cache.
@@ -84,12 +85,8 @@ where
             .trim()
             .to_string();
 
-        if !pid_string.is_empty() {
-            let mut proc_path = self.proc_path.clone();
-            proc_path.push(pid_string);
-            if proc_path.exists() {
-                return Err(PIDCacheError::RunningProcessAlreadyCached);
-            }
+        if !pid_string.is_empty() && self.is_running(&pid_string) {
+            return Err(PIDCacheError::RunningProcessAlreadyCached);
         }
 
         let pid_string = format!("{pid}");
@@ -97,6 +94,24 @@ where
             .write(self.file_path.as_path(), pid_string)
             .map_err(PIDCacheError::SaveError)
     }
+
+    /// Checks whether the cached pid belongs to a running process. Hosts without `/proc` mounted
+    /// (FreeBSD, macOS) are checked sending the null signal to the process instead.
+    fn is_running(&self, pid: &str) -> bool {
+        if self.proc_path.is_dir() {
+            return self.proc_path.join(pid).exists();
+        }
+        #[cfg(target_family = "unix")]
+        if let Ok(pid) = pid.parse() {
+            use nix::{errno::Errno, sys::signal, unistd::Pid};
+            // EPERM means the process exists but belongs to another user.
+            return matches!(
+                signal::kill(Pid::from_raw(pid), None),
+                Ok(()) | Err(Errno::EPERM)
+            );
+        }
+        false
+    }
 }
 
 #[cfg(test)]
@@ -164,6 +179,21 @@ pub mod tests {
         );
     }
 
+    #[cfg(target_family = "unix")]
+    #[test]
+    fn test_running_pid_without_proc_folder() {
+        let pid_path = PathBuf::from("/an/invented/path/not-existing");
+        let pid_cache = PIDCache::new(
+            MockLocalFile::default(),
+            MockDirectoryManager::default(),
+            pid_path,
+            PathBuf::from("/an/invented/proc/not-existing"),
+        );
+
+        assert!(pid_cache.is_running(&std::process::id().to_string()));
+        assert!(!pid_cache.is_running(&i32::MAX.to_string()));
+    }
+
     #[test]
     fn test_new_pid_when_no_running_pid_stores_ok() {
         let pid_not_running_anymore: u32 = 123;
@@ -2,8 +2,11 @@
 
 use super::defaults::{
     AGENT_CONTROL_DATA_DIR, AGENT_CONTROL_LOCAL_DATA_DIR, AGENT_CONTROL_LOG_DIR,
-    DYNAMIC_AGENT_TYPES_DIR,
+    AGENT_FILESYSTEM_FOLDER_NAME, CONFINED_LOCAL_DATA_FOLDER_NAME, DYNAMIC_AGENT_TYPES_DIR,
+    FOLDER_NAME_FLEET_DATA, FOLDER_NAME_LOCAL_DATA, PACKAGES_FOLDER_NAME,
+    WRITABLE_ROOT_DATA_FOLDER_NAME, WRITABLE_ROOT_LOG_FOLDER_NAME,
 };
+use crate::agent_control::agent_id::AgentID;
 use crate::agent_control::config::{AgentControlConfig, AgentControlConfigError};
 use crate::agent_control::config_repository::store::AgentControlConfigStore;
 use crate::agent_control::http_server::runner::Runner;
@@ -12,17 +15,40 @@ use crate::agent_type::registry::{Registry, RegistryConfig};
 use crate::command::RunnerContext;
 use crate::data_store::DataStore;
 use crate::event::broadcaster::unbounded::UnboundedBroadcast;
+use crate::event::cancellation::CancellationMessage;
 use crate::event::{AgentControlEvent, ApplicationEvent, SubAgentEvent, channel::EventConsumer};
+use crate::instrumentation::config::logs::level_reload::LogLevelReloader;
 use crate::oci;
 use crate::opamp::remote_config::validators::signature::validator::SignatureValidator;
+use crate::utils::confinement::Confinement;
 use crate::values::ConfigRepo;
+use fs::directory_manager::DirectoryManager;
 use oci_client::client::ClientConfig;
 use std::error::Error;
-use std::path::PathBuf;
+use std::fs::OpenOptions;
+use std::io;
+use std::path::{Path, PathBuf};
 use std::sync::Arc;
+use std::time::Duration;
 use tokio::runtime::Runtime;
 use tracing::debug;
 
+/// File created and removed to check that the data directory is writable.
+const WRITABLE_CHECK_FILE_NAME: &str = ".ac-writable-check";
+
+/// Error returned when a directory Agent Control writes to is not writable.
+#[derive(Debug, thiserror::Error)]
+#[error(
+    "directory '{}' is not writable: {source}. If the root filesystem is read-only, set `storage.writable_root` \
+     to a directory on a writable volume (e.g. `NR_AC_STORAGE__WRITABLE_ROOT=/mnt/data/newrelic-agent-control`)",
+    .path.display()
+)]
+pub struct NotWritableError {
+    path: PathBuf,
+    #[source]
+    source: io::Error,
+}
+
 /// Error returned when running Agent Control fails.
 #[derive(Debug, thiserror::Error)]
 #[error("{0}")]
@@ -35,6 +61,9 @@ pub enum GracefulShutdownReason {
     ExternalRequested,
     /// A successful self-update occurred, and the process needs to be restarted to apply the update.
     SelfUpdate,
+    /// An executable of the sub-agent exhausted its restart policy, the process exits so the
+    /// service manager can restart it.
+    SubAgentCrashLoop(AgentID),
 }
 
 // k8s and on_host need to be public to allow integration tests to access the fn run_agent_control.
@@ -60,18 +89,125 @@ pub struct BasePaths {
     pub remote_dir: PathBuf,
     /// Directory holding log files.
     pub log_dir: PathBuf,
+    /// Directory holding the files rendered for each sub-agent instead of `remote_dir`, if any.
+    pub ephemeral_dir: Option<PathBuf>,
+    /// File holding the local configuration of Agent Control instead of `local_dir`, if any.
+    pub config_file: Option<PathBuf>,
 }
 
 impl Default for BasePaths {
     fn default() -> Self {
+        if let Some(confinement) = Confinement::detect() {
+            return Self::confined(&confinement);
+        }
         Self {
             local_dir: PathBuf::from(AGENT_CONTROL_LOCAL_DATA_DIR),
             remote_dir: PathBuf::from(AGENT_CONTROL_DATA_DIR),
             log_dir: PathBuf::from(AGENT_CONTROL_LOG_DIR),
+            ephemeral_dir: None,
+            config_file: None,
         }
     }
 }
 
+impl BasePaths {
+    /// Places every directory, including the control socket, under the writable root of the
+    /// confined package, as the standard system directories can't be reached from it.
+    pub fn confined(confinement: &Confinement) -> Self {
+        let root = confinement.root();
+        Self {
+            local_dir: root.join(CONFINED_LOCAL_DATA_FOLDER_NAME),
+            remote_dir: root.join(WRITABLE_ROOT_DATA_FOLDER_NAME),
+            log_dir: root.join(WRITABLE_ROOT_LOG_FOLDER_NAME),
+            ephemeral_dir: None,
+            config_file: None,
+        }
+    }
+
+    /// Directory holding the local configuration of Agent Control and each sub-agent.
+    pub fn local_data_dir(&self) -> PathBuf {
+        self.local_dir.join(FOLDER_NAME_LOCAL_DATA)
+    }
+
+    /// Directory holding the dynamic agent type definitions.
+    pub fn dynamic_agent_types_dir(&self) -> PathBuf {
+        self.local_dir.join(DYNAMIC_AGENT_TYPES_DIR)
+    }
+
+    /// Directory holding the remote configurations, hashes and states received from fleet.
+    pub fn fleet_data_dir(&self) -> PathBuf {
+        self.remote_dir.join(FOLDER_NAME_FLEET_DATA)
+    }
+
+    /// Directory holding the files rendered for each sub-agent.
+    pub fn agent_filesystem_dir(&self) -> PathBuf {
+        self.ephemeral_dir
+            .as_ref()
+            .unwrap_or(&self.remote_dir)
+            .join(AGENT_FILESYSTEM_FOLDER_NAME)
+    }
+
+    /// Directory holding the packages downloaded for each sub-agent.
+    pub fn packages_dir(&self) -> PathBuf {
+        self.remote_dir.join(PACKAGES_FOLDER_NAME)
+    }
+
+    /// Path of a state file of Agent Control, such as its control socket.
+    pub fn state_file(&self, name: &str) -> PathBuf {
+        self.remote_dir.join(name)
+    }
+
+    /// Redirects everything Agent Control writes (data and logs) under `writable_root`, for hosts
+    /// whose root filesystem is read-only. Local directories are only read and kept as they are.
+    pub fn with_writable_root(self, writable_root: &Path) -> Self {
+        Self {
+            remote_dir: writable_root.join(WRITABLE_ROOT_DATA_FOLDER_NAME),
+            log_dir: writable_root.join(WRITABLE_ROOT_LOG_FOLDER_NAME),
+            ..self
+        }
+    }
+
+    /// Places the files rendered for each sub-agent under `ephemeral_dir`, e.g. a tmpfs mount to
+    /// reduce the wear of flash storage. The fleet data (remote configs, instance id) and the
+    /// packages are kept in `remote_dir`, so the rendered files can be reproduced from them.
+    pub fn with_ephemeral_dir(self, ephemeral_dir: PathBuf) -> Self {
+        Self {
+            ephemeral_dir: Some(ephemeral_dir),
+            ..self
+        }
+    }
+
+    /// Checks that the data directory can be written, creating it if missing, so a read-only
+    /// filesystem is reported at startup instead of when the first remote config is stored.
+    pub fn check_writable(&self) -> Result<(), NotWritableError> {
+        let not_writable = |source| NotWritableError {
+            path: self.remote_dir.clone(),
+            source,
+        };
+        std::fs::create_dir_all(&self.remote_dir).map_err(not_writable)?;
+        let check_file = self.remote_dir.join(WRITABLE_CHECK_FILE_NAME);
+        OpenOptions::new()
+            .write(true)
+            .create(true)
+            .truncate(true)
+            .open(&check_file)
+            .map_err(not_writable)?;
+        std::fs::remove_file(&check_file).map_err(not_writable)
+    }
+
+    /// Creates the directories Agent Control writes to, with the permissions set by the
+    /// [DirectoryManager]. Local directories are provided by the installation and not created.
+    pub fn create_remote_dirs<D: DirectoryManager>(&self, dir_manager: &D) -> io::Result<()> {
+        [
+            self.fleet_data_dir(),
+            self.agent_filesystem_dir(),
+            self.packages_dir(),
+        ]
+        .iter()
+        .try_for_each(|dir| dir_manager.create(dir))
+    }
+}
+
 /// Structure with all the data required to run the agent control.
 pub struct AgentControlRunner {
     /// Config loaded at startup from local files. Used to bootstrap
@@ -91,6 +227,7 @@ pub struct AgentControlRunner {
     runtime: Arc<Runtime>,
     http_server_runner: Option<Runner>,
     self_replace_target: Option<PathBuf>,
+    log_level_reloader: LogLevelReloader,
 }
 
 impl AgentControlRunner {
@@ -128,8 +265,10 @@ impl AgentControlRunner {
         let oci_client = oci::Client::try_new(
             oci_client_config,
             context.bootstrap_config.proxy.clone(),
+            context.bootstrap_config.egress.clone(),
             runtime.clone(),
-        )?;
+        )?
+        .with_exchange_log(context.bootstrap_config.http_exchange_log);
 
         let agent_type_registry =
             Arc::new(build_agent_type_registry(&context, oci_client.clone())?);
@@ -159,8 +298,23 @@ impl AgentControlRunner {
             base_paths: context.base_paths,
             signature_validator,
             self_replace_target: context.self_replace_target,
+            log_level_reloader: context.log_level_reloader,
         })
     }
+
+    /// Returns a consumer receiving the events of Agent Control, like its applied and failed
+    /// remote configs, so programs embedding it can react to them. Dropping the consumer
+    /// unsubscribes it.
+    pub fn subscribe_agent_control_events(&mut self) -> EventConsumer<AgentControlEvent> {
+        EventConsumer::from(self.agent_control_publisher.subscribe())
+    }
+
+    /// Returns a consumer receiving the events of the sub-agents, like their applied and failed
+    /// remote configs, so programs embedding Agent Control can react to them. Dropping the
+    /// consumer unsubscribes it.
+    pub fn subscribe_sub_agent_events(&mut self) -> EventConsumer<SubAgentEvent> {
+        EventConsumer::from(self.sub_agent_publisher.subscribe())
+    }
 }
 
 fn build_agent_type_registry(
@@ -190,7 +344,7 @@ fn build_agent_type_registry(
     Ok(Registry::build(
         context.running_mode,
         RegistryConfig {
-            dynamic_agent_types_path: context.base_paths.local_dir.join(DYNAMIC_AGENT_TYPES_DIR),
+            dynamic_agent_types_path: context.base_paths.dynamic_agent_types_dir(),
         },
         downloader,
     ))
@@ -202,16 +356,169 @@ type RepositoryAndStore<D> = (
 );
 
 /// Helper to handle configuration repository and store for all running modes.
+///
+/// If `cancellation` is provided, the repository operations are cancelled when its publisher is
+/// dropped. If `io_timeout` is provided, they fail if they don't complete in time.
 pub fn setup_config_repository_and_store<D: DataStore + Send + Sync + 'static>(
     data_store: Arc<D>,
     with_remote: bool,
+    cancellation: Option<EventConsumer<CancellationMessage>>,
+    io_timeout: Option<Duration>,
 ) -> RepositoryAndStore<D> {
     debug!("Initializing yaml_config_repository");
     let mut repository = ConfigRepo::new(data_store);
     if with_remote {
         repository = repository.with_remote();
     }
+    if let Some(cancellation) = cancellation {
+        repository = repository.with_cancellation(cancellation);
+    }
+    if let Some(io_timeout) = io_timeout {
+        repository = repository.with_io_timeout(io_timeout);
+    }
     let repository = Arc::new(repository);
     let store = Arc::new(AgentControlConfigStore::new(repository.clone()));
     (repository, store)
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use fs::directory_manager::DirectoryManagerFs;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_base_paths_layout() {
+        let local_dir = PathBuf::from("local");
+        let remote_dir = PathBuf::from("remote");
+        let base_paths = BasePaths {
+            local_dir: local_dir.clone(),
+            remote_dir: remote_dir.clone(),
+            log_dir: PathBuf::from("log"),
+            ephemeral_dir: None,
+            config_file: None,
+        };
+
+        assert_eq!(base_paths.local_data_dir(), local_dir.join("local-data"));
+        assert_eq!(
+            base_paths.dynamic_agent_types_dir(),
+            local_dir.join("dynamic-agent-types")
+        );
+        assert_eq!(base_paths.fleet_data_dir(), remote_dir.join("fleet-data"));
+        assert_eq!(
+            base_paths.agent_filesystem_dir(),
+            remote_dir.join("filesystem")
+        );
+        assert_eq!(base_paths.packages_dir(), remote_dir.join("packages"));
+        assert_eq!(
+            base_paths.state_file("control.sock"),
+            remote_dir.join("control.sock")
+        );
+    }
+
+    #[test]
+    fn test_create_remote_dirs() {
+        let tmp_dir = TempDir::new().unwrap();
+        let base_paths = BasePaths {
+            local_dir: tmp_dir.path().join("local"),
+            remote_dir: tmp_dir.path().join("remote"),
+            log_dir: tmp_dir.path().join("log"),
+            ephemeral_dir: None,
+            config_file: None,
+        };
+
+        base_paths.create_remote_dirs(&DirectoryManagerFs).unwrap();
+
+        assert!(base_paths.fleet_data_dir().is_dir());
+        assert!(base_paths.agent_filesystem_dir().is_dir());
+        assert!(base_paths.packages_dir().is_dir());
+        assert!(!base_paths.local_dir.exists());
+    }
+
+    #[test]
+    fn test_with_ephemeral_dir() {
+        let remote_dir = PathBuf::from("remote");
+        let ephemeral_dir = PathBuf::from("tmpfs");
+        let base_paths = BasePaths {
+            local_dir: PathBuf::from("local"),
+            remote_dir: remote_dir.clone(),
+            log_dir: PathBuf::from("log"),
+            ephemeral_dir: None,
+            config_file: None,
+        }
+        .with_ephemeral_dir(ephemeral_dir.clone());
+
+        assert_eq!(
+            base_paths.agent_filesystem_dir(),
+            ephemeral_dir.join("filesystem")
+        );
+        assert_eq!(base_paths.fleet_data_dir(), remote_dir.join("fleet-data"));
+        assert_eq!(base_paths.packages_dir(), remote_dir.join("packages"));
+    }
+
+    #[test]
+    fn test_with_writable_root() {
+        let local_dir = PathBuf::from("local");
+        let writable_root = PathBuf::from("writable");
+        let base_paths = BasePaths {
+            local_dir: local_dir.clone(),
+            remote_dir: PathBuf::from("remote"),
+            log_dir: PathBuf::from("log"),
+            ephemeral_dir: None,
+            config_file: None,
+        }
+        .with_writable_root(&writable_root);
+
+        assert_eq!(base_paths.local_dir, local_dir);
+        assert_eq!(base_paths.remote_dir, writable_root.join("data"));
+        assert_eq!(base_paths.log_dir, writable_root.join("log"));
+    }
+
+    #[test]
+    fn test_confined_base_paths() {
+        let root = PathBuf::from("/var/snap/newrelic-agent-control/common");
+        let base_paths = BasePaths::confined(&Confinement::Snap { root: root.clone() });
+
+        assert_eq!(base_paths.local_dir, root.join("config"));
+        assert_eq!(base_paths.remote_dir, root.join("data"));
+        assert_eq!(base_paths.log_dir, root.join("log"));
+        assert_eq!(
+            base_paths.state_file("control.sock"),
+            root.join("data/control.sock")
+        );
+    }
+
+    #[test]
+    fn test_check_writable() {
+        let tmp_dir = TempDir::new().unwrap();
+        let base_paths = BasePaths::default().with_writable_root(tmp_dir.path());
+
+        base_paths.check_writable().unwrap();
+
+        assert!(base_paths.remote_dir.is_dir());
+        assert_eq!(base_paths.remote_dir.read_dir().unwrap().count(), 0);
+    }
+
+    #[cfg(target_family = "unix")]
+    #[test]
+    fn test_check_writable_read_only() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let tmp_dir = TempDir::new().unwrap();
+        let base_paths = BasePaths::default().with_writable_root(tmp_dir.path());
+        std::fs::create_dir(&base_paths.remote_dir).unwrap();
+        std::fs::set_permissions(
+            &base_paths.remote_dir,
+            std::fs::Permissions::from_mode(0o555),
+        )
+        .unwrap();
+        // Permissions are not enforced for root.
+        if nix::unistd::Uid::effective().is_root() {
+            return;
+        }
+
+        let err = base_paths.check_writable().unwrap_err();
+
+        assert!(err.to_string().contains("storage.writable_root"));
+    }
+}
@@ -1,16 +1,17 @@
 //! Wiring and entry point for running Agent Control in a Kubernetes environment.
 
 use crate::agent_control::AgentControl;
-use crate::agent_control::config::{K8sConfig, helmrelease_v2_type_meta};
+use crate::agent_control::config::{K8sConfig, ReleaseChannel, helmrelease_v2_type_meta};
 use crate::agent_control::config_repository::repository::AgentControlConfigLoader;
 use crate::agent_control::config_validator::RegistryDynamicConfigValidator;
 use crate::agent_control::config_validator::k8s::K8sReleaseNamesConfigValidator;
 use crate::agent_control::defaults::{
     AGENT_CONTROL_VERSION, CD_EXTERNAL_ENABLED_ATTRIBUTE_KEY,
     CD_REMOTE_UPDATE_ENABLED_ATTRIBUTE_KEY, CLUSTER_NAME_ATTRIBUTE_KEY, FLEET_ID_ATTRIBUTE_KEY,
-    HOST_NAME_ATTRIBUTE_KEY, OPAMP_AC_CHART_VERSION_ATTRIBUTE_KEY,
-    OPAMP_AGENT_VERSION_ATTRIBUTE_KEY, OPAMP_CD_CHART_VERSION_ATTRIBUTE_KEY, default_capabilities,
-    default_custom_capabilities,
+    HOST_NAME_ATTRIBUTE_KEY, INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY,
+    INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE, OPAMP_AC_CHART_VERSION_ATTRIBUTE_KEY,
+    OPAMP_AGENT_VERSION_ATTRIBUTE_KEY, OPAMP_CD_CHART_VERSION_ATTRIBUTE_KEY,
+    RELEASE_CHANNEL_ATTRIBUTE_KEY, default_capabilities, default_custom_capabilities,
 };
 use crate::agent_control::health_checker::k8s::agent_control_health_checker_builder;
 use crate::agent_control::http_server::runner::Runner;
@@ -24,12 +25,14 @@ use crate::agent_type::variable::Variable;
 use crate::agent_type::version_config::{
     AGENT_CONTROL_VERSION_CHECKER_INITIAL_DELAY, VersionCheckerInterval,
 };
+use crate::audit::AuditTrail;
 use crate::checkers::version::k8s::checkers::spawn_version_checker;
 use crate::checkers::version::k8s::helmrelease::HelmReleaseVersionChecker;
 use crate::environment::Environment;
 use crate::event::channel::{EventPublisher, pub_sub};
 use crate::event::{AgentControlEvent, AgentControlInternalEvent};
 use crate::k8s::client::SyncK8sClient;
+use crate::lifecycle_hooks::LifecycleHooks;
 use crate::opamp::client_builder::BuildOpAMPClient;
 use crate::opamp::client_builder::OpAMPClientBuilder;
 use crate::opamp::effective_config::loader::EffectiveConfigLoaderBuilder;
@@ -69,6 +72,10 @@ pub const K8S_CONFIG_ONLY_AGENTS_CUSTOM_CAPABILITY: &str = "com.newrelic.k8s_con
 impl AgentControlRunner {
     /// Runs Agent Control in Kubernetes mode until a graceful shutdown is requested.
     pub fn run_k8s(self) -> Result<GracefulShutdownReason, RunError> {
+        let lifecycle_hooks = LifecycleHooks::new(
+            self.bootstrap_config.lifecycle_hooks.clone(),
+            self.bootstrap_config.proxy.clone(),
+        );
         let k8s_config = self.bootstrap_config.k8s.clone().ok_or(RunError(
             "k8s config missing while running on k8s".to_string(),
         ))?;
@@ -90,7 +97,7 @@ impl AgentControlRunner {
         );
 
         let (yaml_config_repository, config_storer) =
-            setup_config_repository_and_store(k8s_store.clone(), maybe_opamp.is_some());
+            setup_config_repository_and_store(k8s_store.clone(), maybe_opamp.is_some(), None, None);
         let agent_control_config = config_storer
             .load()
             .map_err(|err| RunError(format!("failed to load Agent Control config: {err}")))?;
@@ -105,8 +112,10 @@ impl AgentControlRunner {
         info!("Instance Identifiers: {}", identifiers);
 
         let instance_id_storer = Arc::new(Storer::from(k8s_store.clone()));
-        let instance_id_getter =
-            InstanceIDWithIdentifiersGetter::new(instance_id_storer, identifiers.clone());
+        let instance_id_getter = Arc::new(InstanceIDWithIdentifiersGetter::new(
+            instance_id_storer,
+            identifiers.clone(),
+        ));
 
         let opamp_client_builder = maybe_opamp.map(|config| {
             OpAMPClientBuilder::new(
@@ -115,7 +124,8 @@ impl AgentControlRunner {
                     config,
                     self.bootstrap_config.proxy.clone(),
                     secret_retriever,
-                ),
+                )
+                .with_exchange_log(self.bootstrap_config.http_exchange_log),
                 EffectiveConfigLoaderBuilder::new(yaml_config_repository.clone()),
             )
         });
@@ -123,7 +133,10 @@ impl AgentControlRunner {
         let agent_identity = AgentIdentity::new_agent_control_identity();
         let agent_description = agent_description(
             &agent_identity,
-            agent_control_additional_opamp_identifying_attributes(&k8s_config),
+            agent_control_additional_opamp_identifying_attributes(
+                &k8s_config,
+                self.bootstrap_config.release_channel,
+            ),
             agent_control_opamp_non_identifying_attributes(&identifiers, &k8s_config),
         );
 
@@ -161,7 +174,10 @@ impl AgentControlRunner {
                 NAMESPACE_AGENTS_VARIABLE_NAME.to_string(),
                 Variable::new_final_string_variable(k8s_config.namespace_agents.clone()),
             ),
-        ]);
+        ])
+        .into_iter()
+        .chain(self.bootstrap_config.credentials.variables())
+        .collect::<HashMap<_, _>>();
 
         let template_renderer = TemplateRenderer::default()
             .with_agent_control_variables(agent_control_variables.clone().into_iter());
@@ -198,13 +214,17 @@ impl AgentControlRunner {
 
         let sub_agent_builder = K8sSubAgentBuilder {
             opamp_builder,
-            instance_id_getter,
+            instance_id_getter: instance_id_getter.clone(),
             k8s_config: k8s_config.clone(),
             supervisor_builder: Arc::new(supervisor_builder),
             remote_config_parser: Arc::new(remote_config_parser),
             config_repository: yaml_config_repository.clone(),
             effective_agents_assembler: agents_assembler,
             sub_agent_publisher: self.sub_agent_publisher,
+            release_channel: self.bootstrap_config.release_channel,
+            remote_config_status: self.bootstrap_config.remote_config_status.clone(),
+            lifecycle_hooks: lifecycle_hooks.clone(),
+            audit_trail: AuditTrail::default(),
         };
 
         let garbage_collector = K8sGarbageCollector {
@@ -284,6 +304,9 @@ impl AgentControlRunner {
             health_checker_builder,
             agent_control_config,
         )
+        .with_instance_id_getter(instance_id_getter)
+        .with_lifecycle_hooks(lifecycle_hooks)
+        .with_log_level_reloader(self.log_level_reloader)
         .run()
         .map_err(|err| RunError(err.to_string()))
     }
@@ -344,7 +367,7 @@ pub fn build_ac_opamp_start_settings(
 }
 
 /// Builds the OpAMP non-identifying attributes for Agent Control on Kubernetes (hostname, fleet id,
-/// cluster name and CD-related flags).
+/// cluster name, CD-related flags and instrumentation provider).
 pub fn agent_control_opamp_non_identifying_attributes(
     identifiers: &Identifiers,
     k8s_config: &K8sConfig,
@@ -374,16 +397,27 @@ pub fn agent_control_opamp_non_identifying_attributes(
             CD_REMOTE_UPDATE_ENABLED_ATTRIBUTE_KEY.to_string(),
             k8s_config.cd_remote_update.into(),
         ),
+        (
+            INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY.to_string(),
+            INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE.to_string().into(),
+        ),
     ])
 }
 
 fn agent_control_additional_opamp_identifying_attributes(
     k8s_config: &K8sConfig,
+    release_channel: ReleaseChannel,
 ) -> HashMap<String, DescriptionValueType> {
-    let mut attributes = HashMap::from([(
-        OPAMP_AGENT_VERSION_ATTRIBUTE_KEY.to_string(),
-        DescriptionValueType::String(AGENT_CONTROL_VERSION.to_string()),
-    )]);
+    let mut attributes = HashMap::from([
+        (
+            OPAMP_AGENT_VERSION_ATTRIBUTE_KEY.to_string(),
+            DescriptionValueType::String(AGENT_CONTROL_VERSION.to_string()),
+        ),
+        (
+            RELEASE_CHANNEL_ATTRIBUTE_KEY.to_string(),
+            release_channel.to_string().into(),
+        ),
+    ]);
 
     if k8s_config.current_chart_version.is_empty() {
         warn!("Agent Control chart version was not set, it will not be reported");
@@ -474,12 +508,21 @@ mod tests {
     #[test]
     fn test_agent_control_additional_opamp_identifying_attributes_chart_version_unset() {
         let k8s_config = K8sConfig::default();
-        let expected = HashMap::from([(
-            "agent.version".to_string(),
-            DescriptionValueType::String(AGENT_CONTROL_VERSION.to_string()),
-        )]);
+        let expected = HashMap::from([
+            (
+                "agent.version".to_string(),
+                DescriptionValueType::String(AGENT_CONTROL_VERSION.to_string()),
+            ),
+            (
+                "release.channel".to_string(),
+                DescriptionValueType::String("stable".to_string()),
+            ),
+        ]);
         assert_eq!(
-            agent_control_additional_opamp_identifying_attributes(&k8s_config),
+            agent_control_additional_opamp_identifying_attributes(
+                &k8s_config,
+                ReleaseChannel::Stable
+            ),
             expected
         );
     }
@@ -495,13 +538,20 @@ mod tests {
                 "agent.version".to_string(),
                 DescriptionValueType::String(AGENT_CONTROL_VERSION.to_string()),
             ),
+            (
+                "release.channel".to_string(),
+                DescriptionValueType::String("canary".to_string()),
+            ),
             (
                 "chart.version".to_string(),
                 DescriptionValueType::String("1.2.3".to_string()),
             ),
         ]);
         assert_eq!(
-            agent_control_additional_opamp_identifying_attributes(&k8s_config),
+            agent_control_additional_opamp_identifying_attributes(
+                &k8s_config,
+                ReleaseChannel::Canary
+            ),
             expected
         );
     }
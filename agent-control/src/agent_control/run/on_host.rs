@@ -1,54 +1,88 @@
 //! Wiring and entry point for running Agent Control in an on-host environment.
 
 use crate::agent_control::AgentControl;
-use crate::agent_control::config::{AgentControlConfig, OpAMPClientConfig};
+use crate::agent_control::agent_id::AgentID;
+use crate::agent_control::config::{AgentControlConfig, OpAMPClientConfig, ReleaseChannel};
+use crate::agent_control::config_repository::in_progress::ConfigApplyMarker;
 use crate::agent_control::config_repository::repository::AgentControlConfigLoader;
 use crate::agent_control::config_validator::RegistryDynamicConfigValidator;
+#[cfg(target_family = "unix")]
+use crate::agent_control::control_socket::{
+    config::ControlSocketConfig, protocol::ControlRequest, server::ControlSocketServer,
+};
 use crate::agent_control::defaults::{
-    AGENT_CONTROL_VERSION, AGENT_FILESYSTEM_FOLDER_NAME, EXECUTION_MODE_ATTRIBUTE_KEY,
-    FLEET_ID_ATTRIBUTE_KEY, FOLDER_NAME_FLEET_DATA, HOST_ID_ATTRIBUTE_KEY, HOST_NAME_ATTRIBUTE_KEY,
-    OPAMP_AGENT_VERSION_ATTRIBUTE_KEY, OS_ATTRIBUTE_KEY, OS_ATTRIBUTE_VALUE, default_capabilities,
-    default_custom_capabilities,
+    AGENT_CONTROL_VERSION, CLOUD_INSTANCE_ID_CACHE_FILE_NAME, CLOUD_PROVIDER_ATTRIBUTE_KEY,
+    CLOUD_REGION_ATTRIBUTE_KEY, CONTROL_SOCKET_FILE_NAME, EXECUTION_MODE_ATTRIBUTE_KEY,
+    FLEET_ID_ATTRIBUTE_KEY, HOST_ID_ATTRIBUTE_KEY, HOST_NAME_ATTRIBUTE_KEY,
+    INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY, INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE,
+    OPAMP_AGENT_VERSION_ATTRIBUTE_KEY, OS_ATTRIBUTE_KEY, OS_ATTRIBUTE_VALUE,
+    OS_VERSION_ATTRIBUTE_KEY, RELEASE_CHANNEL_ATTRIBUTE_KEY, accepts_packages_capabilities,
+    default_capabilities, default_custom_capabilities,
 };
+use crate::agent_control::feature_flags::FeatureFlagEvaluator;
 use crate::agent_control::http_server::runner::Runner;
+use crate::agent_control::infra_config_import::{InfraConfigPaths, import_infra_config};
 use crate::agent_control::resource_cleaner::on_host::OnHostCleaner;
 use crate::agent_control::run::{
     AgentControlRunner, GracefulShutdownReason, RunError, RunningMode,
     setup_config_repository_and_store,
 };
 use crate::agent_control::version_updater::on_host::OnHostACUpdater;
+use crate::agent_control::version_updater::on_host::in_progress::SelfUpdateMarker;
 use crate::agent_control::version_updater::on_host::verify::ProcessVerifyExecutor;
 use crate::agent_type::render::TemplateRenderer;
 use crate::agent_type::variable::Variable;
+use crate::audit::AuditTrail;
 use crate::checkers::health::noop::NoOpHealthChecker;
 use crate::environment::Environment;
 use crate::event::channel::{EventConsumer, pub_sub};
 use crate::event::{AgentControlEvent, OpAMPEvent};
-use crate::http::config::ProxyConfig;
+use crate::http::client::HttpClient;
+use crate::http::config::{EgressConfig, HttpConfig, ProxyConfig};
+use crate::http::exchange_log::ExchangeLogConfig;
+use crate::instrumentation::agent_logs::start_agent_logs;
+use crate::instrumentation::config::logs::level_reload::LogLevelReloader;
+use crate::lifecycle_hooks::LifecycleHooks;
 use crate::on_host::file_store::FileStore;
+use crate::opamp::auth::enrollment::enroll;
 use crate::opamp::auth::token_retriever::TokenRetrieverImpl;
 use crate::opamp::callbacks::AgentCallbacks;
 use crate::opamp::client_builder::BuildOpAMPClient;
 use crate::opamp::client_builder::OpAMPClientBuilder;
 use crate::opamp::effective_config::loader::{EffectiveConfigLoader, EffectiveConfigLoaderBuilder};
-use crate::opamp::http::builder::OpAMPHttpClientBuilder;
+use crate::opamp::http::builder::{OpAMPHttpClientBuilder, opamp_http_config};
 use crate::opamp::http::client::HttpOpAMPClient;
 use crate::opamp::instance_id::getter::{InstanceIDGetter, InstanceIDWithIdentifiersGetter};
-use crate::opamp::instance_id::on_host::identifiers::{Identifiers, IdentifiersProvider};
+use crate::opamp::instance_id::on_host::identifiers::{
+    HostDescription, Identifiers, IdentifiersProvider,
+};
 use crate::opamp::instance_id::storer::Storer;
+use crate::opamp::network_wait::wait_for_network;
 use crate::opamp::operations::agent_description;
 use crate::opamp::remote_config::validators::SupportedRemoteConfigValidator;
+use crate::opamp::remote_config::validators::fluent_bit::FluentBitValidator;
+use crate::opamp::remote_config::validators::otel_components::OtelComponentsValidator;
 use crate::opamp::remote_config::validators::regexes::RegexValidator;
+use crate::opamp::remote_config::validators::version_requirements::VersionRequirementsValidator;
 use crate::package::oci::downloader::OCIPackageArtifactDownloader;
 use crate::package::oci::package_manager::OCIPackageManager;
+use crate::package::offline::OfflinePackageDownloader;
+use crate::package::syncer::{
+    OfflinePackagesSyncer, PACKAGE_DOWNLOAD_CONNECT_TIMEOUT, PACKAGE_DOWNLOAD_TIMEOUT,
+};
 use crate::secret_retriever::on_host::retrieve::OnHostSecretRetriever;
 use crate::secrets_provider::SecretsProviders;
 use crate::secrets_provider::file::FileSecretProvider;
+use crate::signature::public_key_fetcher::read_key_ring;
 use crate::sub_agent::effective_agents_assembler::LocalEffectiveAgentsAssembler;
 use crate::sub_agent::identity::AgentIdentity;
 use crate::sub_agent::on_host::builder::OnHostSubAgentBuilder;
 use crate::sub_agent::on_host::builder::SupervisorBuilderOnHost;
+use crate::sub_agent::on_host::default_exporter::DefaultExporter;
+use crate::sub_agent::on_host::processes::SupervisedProcesses;
+use crate::sub_agent::on_host::restart_limiter::RestartLimiter;
 use crate::sub_agent::remote_config_parser::AgentRemoteConfigParser;
+use crate::utils::platform;
 use crate::utils::time::SystemClock;
 use crate::values::ConfigRepo;
 use fs::directory_manager::DirectoryManagerFs;
@@ -57,14 +91,21 @@ use opamp_client::http::StartedHttpClient;
 use opamp_client::http::client::OpAMPHttpClient;
 use opamp_client::operation::settings::{AgentDescription, DescriptionValueType, StartSettings};
 use self_replacer::BinaryReplacer;
-use std::collections::HashMap;
-use std::path::PathBuf;
+use std::collections::{BTreeMap, HashMap};
+use std::path::{Path, PathBuf};
 use std::sync::Arc;
 use std::time::SystemTime;
-use tracing::{debug, info};
+use tracing::{debug, info, warn};
 
 /// Agent Control variable name carrying the host id.
 pub const HOST_ID_VARIABLE_NAME: &str = "host_id";
+/// Agent Control variable name carrying the operating system, like `linux`.
+pub const OS_VARIABLE_NAME: &str = "os";
+/// Agent Control variable name carrying the architecture, like `amd64`.
+pub const ARCH_VARIABLE_NAME: &str = "arch";
+/// Agent Control variable name carrying the resource attributes of the OpenTelemetry collectors,
+/// in the `OTEL_RESOURCE_ATTRIBUTES` format.
+pub const RESOURCE_ATTRIBUTES_VARIABLE_NAME: &str = "resource_attributes";
 /// Local OCI registry URL used by tests (debug builds only).
 #[cfg(debug_assertions)]
 pub const OCI_TEST_REGISTRY_URL: &str = "localhost:5001";
@@ -91,34 +132,105 @@ type OnHostOpAMPConsumer = EventConsumer<OpAMPEvent>;
 impl AgentControlRunner {
     /// Runs Agent Control in on-host mode until a graceful shutdown is requested.
     pub fn run_onhost(self) -> Result<GracefulShutdownReason, RunError> {
-        let local_dir = self.base_paths.local_dir;
-        let remote_dir = self.base_paths.remote_dir;
-        let file_store = Arc::new(FileStore::new_local_fs(
-            local_dir.clone(),
-            remote_dir.clone(),
-        ));
+        let audit_trail = AuditTrail::new(&self.bootstrap_config.audit);
+        let lifecycle_hooks = LifecycleHooks::new(
+            self.bootstrap_config.lifecycle_hooks.clone(),
+            self.bootstrap_config.proxy.clone(),
+        )
+        .with_audit_trail(audit_trail.clone());
+
+        let local_dir = self.base_paths.local_dir.clone();
+        let remote_dir = self.base_paths.remote_dir.clone();
+        let _ = self
+            .base_paths
+            .create_remote_dirs(&DirectoryManagerFs)
+            .inspect_err(|err| warn!("Could not create Agent Control directories: {err}"));
+        let file_store = Arc::new(
+            FileStore::new_local_fs(local_dir.clone(), remote_dir.clone())
+                .with_agent_control_config(self.base_paths.config_file.clone()),
+        );
+
+        // A host reboot in the middle of a self-update leaves its marker behind, resolve it before
+        // the persisted config (which may still point to the target version) is applied again.
+        let self_update_marker = SelfUpdateMarker::from(file_store.clone())
+            .with_lifecycle_hooks(lifecycle_hooks.clone())
+            .with_audit_trail(audit_trail.clone());
+        let _ = self_update_marker
+            .resolve(AGENT_CONTROL_VERSION)
+            .inspect_err(|err| warn!("Could not resolve the in-progress self-update: {err}"));
 
         let maybe_opamp = self.bootstrap_config.fleet_control;
 
-        let (yaml_config_repository, config_storer) =
-            setup_config_repository_and_store(file_store.clone(), maybe_opamp.is_some());
+        // Blocking filesystem operations are cancelled on shutdown, so a hung filesystem (e.g. NFS)
+        // doesn't prevent Agent Control from exiting.
+        let (io_cancellation_publisher, io_cancellation_consumer) = pub_sub();
+        let io_timeout = self.bootstrap_config.storage.io_timeout();
+        let (yaml_config_repository, config_storer) = setup_config_repository_and_store(
+            file_store.clone(),
+            maybe_opamp.is_some(),
+            Some(io_cancellation_consumer),
+            Some(io_timeout),
+        );
+        // Likewise, a reboot in the middle of a config apply leaves its marker behind. The apply is
+        // reverted so Agent Control starts on the last applied config.
+        let config_apply_marker = Arc::new(
+            ConfigApplyMarker::from(file_store.clone())
+                .with_lifecycle_hooks(lifecycle_hooks.clone()),
+        );
+        let _ = config_apply_marker
+            .resolve(config_storer.as_ref())
+            .inspect_err(|err| warn!("Could not revert the interrupted config apply: {err}"));
+
         let agent_control_config = config_storer
             .load()
             .map_err(|err| RunError(format!("failed to load Agent Control config: {err}")))?;
 
-        let identifiers = ac_identifiers(&agent_control_config)?;
+        // Hosts already running the Infrastructure agent keep its configuration on the first start.
+        let _ = import_infra_config(
+            &InfraConfigPaths::default(),
+            &local_dir,
+            &agent_control_config.dynamic.agents,
+        )
+        .inspect_err(|err| {
+            warn!("Could not import the existing Infrastructure agent configuration: {err}")
+        });
+
+        let (identifiers, host_description) =
+            ac_identifiers(&agent_control_config, &remote_dir, true)?;
 
-        let agent_control_variables = HashMap::from([(
-            HOST_ID_VARIABLE_NAME.to_string(),
-            Variable::new_final_string_variable(identifiers.host_id.clone()),
-        )]);
+        let agent_control_variables = HashMap::from([
+            (
+                HOST_ID_VARIABLE_NAME.to_string(),
+                Variable::new_final_string_variable(identifiers.host_id.clone()),
+            ),
+            (
+                OS_VARIABLE_NAME.to_string(),
+                Variable::new_final_string_variable(platform::os()),
+            ),
+            (
+                ARCH_VARIABLE_NAME.to_string(),
+                Variable::new_final_string_variable(platform::arch()),
+            ),
+            (
+                RESOURCE_ATTRIBUTES_VARIABLE_NAME.to_string(),
+                Variable::new_final_string_variable(otel_resource_attributes(
+                    &identifiers,
+                    &agent_control_config.resource_attributes,
+                )),
+            ),
+        ])
+        .into_iter()
+        .chain(self.bootstrap_config.credentials.variables())
+        .collect::<HashMap<_, _>>();
 
         let instance_id_storer = Arc::new(Storer::from(file_store));
-        let instance_id_getter =
-            InstanceIDWithIdentifiersGetter::new(instance_id_storer.clone(), identifiers.clone());
+        let instance_id_getter = Arc::new(InstanceIDWithIdentifiersGetter::new(
+            instance_id_storer.clone(),
+            identifiers.clone(),
+        ));
 
-        let agent_filesystem_base = remote_dir.join(AGENT_FILESYSTEM_FOLDER_NAME);
-        let fleet_data_base = remote_dir.join(FOLDER_NAME_FLEET_DATA);
+        let agent_filesystem_base = self.base_paths.agent_filesystem_dir();
+        let fleet_data_base = self.base_paths.fleet_data_dir();
         let dir_manager = Arc::new(DirectoryManagerFs);
         let resource_cleaner = OnHostCleaner::new(
             instance_id_storer,
@@ -136,18 +248,47 @@ impl AgentControlRunner {
                 .map(|id| id.as_str()),
         );
 
+        if let Some(opamp_config) = &maybe_opamp {
+            let _ = wait_for_network(
+                &opamp_config.network_wait,
+                &opamp_config.endpoint,
+                &self.bootstrap_config.proxy,
+            )
+            .inspect_err(|err| warn!("Starting the OpAMP client anyway: {err}"));
+        }
+
+        let maybe_opamp = maybe_opamp
+            .map(|config| {
+                let http_config = opamp_http_config(
+                    &config,
+                    &self.bootstrap_config.proxy,
+                    self.bootstrap_config.egress.clone(),
+                );
+                enroll(config, &remote_dir, &http_config)
+            })
+            .transpose()
+            .map_err(|err| RunError(format!("failed to enroll Agent Control: {err}")))?;
+
         let opamp_client_builder = maybe_opamp.map(|config| {
             opamp_client_builder(
                 local_dir.clone(),
                 config,
-                self.bootstrap_config.proxy,
+                self.bootstrap_config.proxy.clone(),
+                self.bootstrap_config.egress.clone(),
+                self.bootstrap_config.http_exchange_log,
                 yaml_config_repository.clone(),
             )
         });
 
         let agent_identity = AgentIdentity::new_agent_control_identity();
-        let agent_description =
-            build_ac_onhost_agent_description(&agent_identity, &identifiers, RunningMode::Normal);
+        let agent_description = build_ac_onhost_agent_description(
+            &agent_identity,
+            &identifiers,
+            &host_description,
+            &agent_control_config.resource_attributes,
+            self.bootstrap_config.release_channel,
+            RunningMode::Normal,
+        );
 
         self.agent_control_publisher
             .broadcast(AgentControlEvent::AgentDescriptionUpdated(
@@ -162,6 +303,7 @@ impl AgentControlRunner {
                     &instance_id_getter,
                     &agent_identity,
                     agent_description,
+                    agent_control_config.agent_packages.accept_offered_packages,
                 )?;
                 start_ac_opamp_client(builder, agent_identity, opamp_start_settings)
             })
@@ -170,6 +312,16 @@ impl AgentControlRunner {
             .map(|(client, consumer)| (Some(client), Some(consumer)))
             .unwrap_or_default();
 
+        // Percentage rollouts of feature flags are evaluated per instance.
+        let feature_flags = FeatureFlagEvaluator::new(
+            agent_control_config.feature_flags.clone(),
+            instance_id_getter
+                .get(&AgentID::AgentControl)
+                .map(|instance_id| instance_id.to_string())
+                .inspect_err(|err| warn!("Could not get the instance id for feature flags: {err}"))
+                .unwrap_or_default(),
+        );
+
         let template_renderer = TemplateRenderer::default()
             .with_agent_control_variables(agent_control_variables.clone().into_iter());
 
@@ -180,51 +332,117 @@ impl AgentControlRunner {
                 .map_err(|e| RunError(format!("failed to load secrets providers: {e}")))?;
         }
 
-        let agents_assembler = Arc::new(LocalEffectiveAgentsAssembler::new(
+        let mut agents_assembler = LocalEffectiveAgentsAssembler::new(
             self.agent_type_registry.clone(),
             template_renderer,
             self.bootstrap_config.agent_type_var_constraints,
             secrets_providers,
             &remote_dir,
-        ));
+        )
+        .with_agent_filesystem_dir(self.base_paths.agent_filesystem_dir());
+        if self.bootstrap_config.otel_default_exporter.enabled {
+            let default_exporter = DefaultExporter::try_new(&self.bootstrap_config.credentials)
+                .map_err(|e| RunError(format!("failed to build the default exporter: {e}")))?;
+            agents_assembler = agents_assembler.with_default_exporter(default_exporter);
+        }
+        let agents_assembler = Arc::new(agents_assembler);
 
+        let packages_config = &agent_control_config.agent_packages;
+        let trusted_package_keys = packages_config
+            .trusted_keys_path
+            .as_deref()
+            .map(read_key_ring)
+            .transpose()
+            .map_err(|e| RunError(format!("failed to load the trusted package keys: {e}")))?
+            .unwrap_or_default();
         let agents_package_manager = OCIPackageManager::new(
-            OCIPackageArtifactDownloader::new(
-                self.oci_client.clone(),
-                self.bootstrap_config.oci.registry.clone(),
-                self.bootstrap_config.oci.auth.clone(),
-                agent_control_config
-                    .agent_packages
-                    .signature_verification_enabled
-                    .into(),
+            OfflinePackageDownloader::new(
+                OCIPackageArtifactDownloader::new(
+                    self.oci_client.clone(),
+                    self.bootstrap_config.oci.registry.clone(),
+                    self.bootstrap_config.oci.auth.clone(),
+                    packages_config.signature_verification_enabled.into(),
+                )
+                .with_trusted_keys(trusted_package_keys)
+                .with_verification_mode(packages_config.signature_verification_mode),
+                &remote_dir,
             ),
             DirectoryManagerFs,
             remote_dir.clone(),
-        );
+        )
+        .with_io_timeout(io_timeout)
+        .with_audit_trail(audit_trail.clone());
+
+        // Packages offered through OpAMP are staged where the package manager above serves them.
+        let packages_syncer = packages_config
+            .accept_offered_packages
+            .then(|| {
+                HttpClient::new(
+                    HttpConfig::new(
+                        PACKAGE_DOWNLOAD_TIMEOUT,
+                        PACKAGE_DOWNLOAD_CONNECT_TIMEOUT,
+                        self.bootstrap_config.proxy.clone(),
+                    )
+                    .with_egress(self.bootstrap_config.egress.clone()),
+                )
+                .map(|client| OfflinePackagesSyncer::new(client, &remote_dir))
+            })
+            .transpose()
+            .map_err(|e| RunError(format!("failed to build the packages http client: {e}")))?;
+
+        let (agent_control_internal_publisher, agent_control_internal_consumer) = pub_sub();
 
+        // Shared with the control socket to deliver signals to the agent processes.
+        let supervised_processes = SupervisedProcesses::default();
         let supervisor_builder = SupervisorBuilderOnHost {
-            logging_path: self.base_paths.log_dir,
+            logging_path: self.base_paths.log_dir.clone(),
             package_manager: Arc::new(agents_package_manager),
+            allowed_executables: self.bootstrap_config.allowed_executables,
+            crash_reports: self.bootstrap_config.crash_reports,
+            process_watch: self.bootstrap_config.process_watch,
+            supervised_processes: supervised_processes.clone(),
+            restart_limiter: RestartLimiter::new(self.bootstrap_config.restart_storm_protection),
+            lifecycle_hooks: lifecycle_hooks.clone(),
+            audit_trail: audit_trail.clone(),
+            crash_loop_publisher: Some(agent_control_internal_publisher.clone()),
         };
 
         let signature_validator = Arc::new(self.signature_validator);
         let remote_config_validators = vec![
             SupportedRemoteConfigValidator::Signature(signature_validator.clone()),
             SupportedRemoteConfigValidator::Regex(RegexValidator::default()),
+            SupportedRemoteConfigValidator::OtelComponents(OtelComponentsValidator::new(
+                self.bootstrap_config.otel_components,
+            )),
+            SupportedRemoteConfigValidator::FluentBit(FluentBitValidator::new(
+                self.bootstrap_config.fluent_bit_plugins,
+            )),
+            SupportedRemoteConfigValidator::VersionRequirements(VersionRequirementsValidator::new(
+                supervised_processes.clone(),
+            )),
         ];
         let remote_config_parser = AgentRemoteConfigParser::new(remote_config_validators);
 
         let opamp_builder =
             opamp_client_builder.map(|builder| builder.with_startup_check_disabled());
 
+        // Exposes the health of the sub-agents through the control socket. Clones of the
+        // broadcaster share its subscribers.
+        #[cfg(target_family = "unix")]
+        let sub_agent_consumer = EventConsumer::from(self.sub_agent_publisher.clone().subscribe());
         let sub_agent_builder = OnHostSubAgentBuilder {
             opamp_builder,
-            instance_id_getter,
+            instance_id_getter: instance_id_getter.clone(),
             supervisor_builder: Arc::new(supervisor_builder),
             remote_config_parser: Arc::new(remote_config_parser),
             yaml_config_repository,
             effective_agents_assembler: agents_assembler,
             sub_agent_publisher: self.sub_agent_publisher,
+            release_channel: self.bootstrap_config.release_channel,
+            host_id: identifiers.host_id.clone(),
+            remote_config_status: self.bootstrap_config.remote_config_status.clone(),
+            lifecycle_hooks: lifecycle_hooks.clone(),
+            audit_trail: audit_trail.clone(),
         };
 
         let dynamic_config_validator =
@@ -237,7 +455,19 @@ impl AgentControlRunner {
             .transpose()
             .map_err(|err| RunError(format!("failed to start HTTP server: {err}")))?;
 
-        let (agent_control_internal_publisher, agent_control_internal_consumer) = pub_sub();
+        // The agent logs tail stops on Drop. We need to keep it while the agent control is running.
+        let _agent_logs = start_agent_logs(
+            &agent_control_config.agent_logs,
+            agent_control_config
+                .self_instrumentation
+                .clone()
+                .with_proxy_config(self.bootstrap_config.proxy.clone())
+                .opentelemetry
+                .as_ref(),
+        )
+        .inspect_err(|err| warn!("Could not forward the agent logs: {err}"))
+        .ok()
+        .flatten();
 
         let agent_control_package_manager = OCIPackageManager::new(
             OCIPackageArtifactDownloader::new(
@@ -252,7 +482,9 @@ impl AgentControlRunner {
             .with_retry_policy((&agent_control_config.self_update.download_retry).into()),
             DirectoryManagerFs,
             remote_dir.clone(),
-        );
+        )
+        .with_io_timeout(io_timeout)
+        .with_audit_trail(audit_trail.clone());
 
         let self_replacer = match self.self_replace_target {
             Some(target) => BinaryReplacer::with_target(target),
@@ -269,13 +501,26 @@ impl AgentControlRunner {
             agent_control_config.self_update.package.clone(),
             agent_control_config.self_update.upgrade_backoff.clone(),
             SystemClock,
-        );
-
-        AgentControl::new(
+        )
+        .with_in_progress_marker(self_update_marker)
+        .with_audit_trail(audit_trail.clone())
+        .with_feature_flags(feature_flags);
+
+        // The control socket is removed on Drop. We need to keep it while the agent control is running.
+        #[cfg(target_family = "unix")]
+        let (_control_socket, control_consumer) = start_control_socket(
+            &agent_control_config.control_socket,
+            self.base_paths.state_file(CONTROL_SOCKET_FILE_NAME),
+            supervised_processes,
+            self.log_level_reloader.clone(),
+        )?
+        .unzip();
+
+        let agent_control = AgentControl::new(
             maybe_client,
             sub_agent_builder,
             SystemTime::now(),
-            config_storer,
+            config_storer.clone(),
             self.agent_control_publisher,
             self.application_event_consumer,
             maybe_sa_opamp_consumer,
@@ -288,13 +533,62 @@ impl AgentControlRunner {
             |t| Some(NoOpHealthChecker::new(t)),
             agent_control_config,
         )
-        .run()
-        .map_err(|err| RunError(err.to_string()))
+        .with_config_loader(config_storer)
+        .with_io_cancellation(io_cancellation_publisher)
+        .with_instance_id_getter(instance_id_getter)
+        .with_config_apply_tracker(config_apply_marker)
+        .with_lifecycle_hooks(lifecycle_hooks)
+        .with_audit_trail(audit_trail)
+        .with_log_level_reloader(self.log_level_reloader);
+        let agent_control = match packages_syncer {
+            Some(packages_syncer) => agent_control.with_packages_syncer(Arc::new(packages_syncer)),
+            None => agent_control,
+        };
+        #[cfg(target_family = "unix")]
+        let agent_control = match control_consumer {
+            Some(consumer) => agent_control
+                .with_control_consumer(consumer)
+                .with_sub_agent_consumer(sub_agent_consumer),
+            None => agent_control,
+        };
+
+        agent_control.run().map_err(|err| RunError(err.to_string()))
     }
 }
 
-/// Resolves the on-host instance [`Identifiers`] (host id, hostname, fleet id) from the config.
-pub fn ac_identifiers(config: &AgentControlConfig) -> Result<Identifiers, RunError> {
+/// Starts the local control API if enabled, returning the server and the consumer of its requests.
+#[cfg(target_family = "unix")]
+fn start_control_socket(
+    config: &ControlSocketConfig,
+    default_path: PathBuf,
+    supervised_processes: SupervisedProcesses,
+    log_level_reloader: LogLevelReloader,
+) -> Result<Option<(ControlSocketServer, EventConsumer<ControlRequest>)>, RunError> {
+    if !config.enabled {
+        return Ok(None);
+    }
+    let path = config.path.clone().unwrap_or(default_path);
+    let (control_publisher, control_consumer) = pub_sub();
+    let server = ControlSocketServer::start(
+        path,
+        config.allowed_uids.clone(),
+        control_publisher,
+        supervised_processes,
+        log_level_reloader,
+    )
+    .map_err(|err| RunError(format!("failed to start control socket: {err}")))?;
+    Ok(Some((server, control_consumer)))
+}
+
+/// Resolves the on-host instance [`Identifiers`] (host id, hostname, fleet id) from the config,
+/// along with the [`HostDescription`] of the host. The cloud instance id cached in `remote_dir` is
+/// used when it can't be detected, and it is only updated when `update_cloud_id_cache` is set, so
+/// commands just inspecting the host don't modify it.
+pub fn ac_identifiers(
+    config: &AgentControlConfig,
+    remote_dir: &Path,
+    update_cloud_id_cache: bool,
+) -> Result<(Identifiers, HostDescription), RunError> {
     let fleet_id = config
         .fleet_control
         .as_ref()
@@ -305,20 +599,61 @@ pub fn ac_identifiers(config: &AgentControlConfig) -> Result<Identifiers, RunErr
         .map_err(|err| RunError(format!("failed to build the identifiers provider: {err}")))?
         .with_host_id(config.host_id.to_string())
         .with_fleet_id(fleet_id);
-
-    let identifiers = identifiers_provider
-        .provide()
+    let cloud_id_cache = remote_dir.join(CLOUD_INSTANCE_ID_CACHE_FILE_NAME);
+    let identifiers_provider = if update_cloud_id_cache {
+        identifiers_provider.with_cloud_id_cache(cloud_id_cache)
+    } else {
+        identifiers_provider.with_read_only_cloud_id_cache(cloud_id_cache)
+    };
+
+    let (identifiers, host_description) = identifiers_provider
+        .provide_with_description()
         .map_err(|err| RunError(format!("failure obtaining identifiers: {err}")))?;
     info!("Instance Identifiers: {:?}", identifiers);
+    debug!("Host description: {:?}", host_description);
+
+    Ok((identifiers, host_description))
+}
 
-    Ok(identifiers)
+/// Returns the resource attributes of the OpenTelemetry collectors in the `OTEL_RESOURCE_ATTRIBUTES`
+/// format, so the `env` detector of their `resourcedetection` processor adds them to the telemetry.
+/// The configured attributes take precedence over the detected ones.
+fn otel_resource_attributes(
+    identifiers: &Identifiers,
+    configured: &BTreeMap<String, String>,
+) -> String {
+    let detected = [
+        ("host.id", &identifiers.host_id),
+        ("host.name", &identifiers.hostname),
+    ]
+    .into_iter()
+    .filter(|(_, value)| !value.is_empty())
+    .map(|(key, value)| (key.to_string(), value.to_string()));
+
+    detected
+        .chain(configured.clone())
+        .collect::<BTreeMap<_, _>>()
+        .iter()
+        // Values are percent-encoded, so they can contain the list separators.
+        .map(|(key, value)| {
+            let value = value
+                .replace('%', "%25")
+                .replace(',', "%2C")
+                .replace('=', "%3D");
+            format!("{key}={value}")
+        })
+        .collect::<Vec<_>>()
+        .join(",")
 }
 
-/// Builds the on-host OpAMP client builder from the OpAMP/proxy config and config repository.
+/// Builds the on-host OpAMP client builder from the OpAMP/proxy/egress/exchange log config and
+/// config repository.
 pub fn opamp_client_builder(
     local_dir: PathBuf,
     opamp_config: OpAMPClientConfig,
     proxy_config: ProxyConfig,
+    egress: EgressConfig,
+    exchange_log: ExchangeLogConfig,
     yaml_config_repository: Arc<ConfigRepo<FileStore<LocalFile, DirectoryManagerFs>>>,
 ) -> OnHostOpAMPClientBuilder {
     let secret_retriever = OnHostSecretRetriever::new(
@@ -328,7 +663,9 @@ pub fn opamp_client_builder(
     );
 
     let poll_interval = opamp_config.poll_interval;
-    let http_builder = OpAMPHttpClientBuilder::new(opamp_config, proxy_config, secret_retriever);
+    let http_builder = OpAMPHttpClientBuilder::new(opamp_config, proxy_config, secret_retriever)
+        .with_egress(egress)
+        .with_exchange_log(exchange_log);
     let loader = EffectiveConfigLoaderBuilder::new(yaml_config_repository.clone());
 
     OpAMPClientBuilder::new(poll_interval, http_builder, loader)
@@ -339,6 +676,7 @@ pub fn build_ac_opamp_start_settings(
     instance_id_getter: &impl InstanceIDGetter,
     agent_identity: &AgentIdentity,
     agent_description: AgentDescription,
+    accept_packages: bool,
 ) -> Result<StartSettings, RunError> {
     let instance_id = instance_id_getter
         .get(&agent_identity.id)
@@ -346,22 +684,35 @@ pub fn build_ac_opamp_start_settings(
 
     Ok(StartSettings {
         instance_uid: instance_id.into(),
-        capabilities: default_capabilities(),
+        capabilities: if accept_packages {
+            accepts_packages_capabilities()
+        } else {
+            default_capabilities()
+        },
         custom_capabilities: Some(default_custom_capabilities()),
         agent_description,
     })
 }
 
-/// Builds the [AgentDescription] for Agent Control on-host.
+/// Builds the [AgentDescription] for Agent Control on-host. The configured resource attributes
+/// are reported as non-identifying attributes too.
 pub fn build_ac_onhost_agent_description(
     agent_identity: &AgentIdentity,
     identifiers: &Identifiers,
+    host_description: &HostDescription,
+    resource_attributes: &BTreeMap<String, String>,
+    release_channel: ReleaseChannel,
     running_mode: RunningMode,
 ) -> AgentDescription {
     agent_description(
         agent_identity,
-        ac_identifying_attributes(),
-        ac_non_identifying_attributes(identifiers, running_mode),
+        ac_identifying_attributes(release_channel),
+        ac_non_identifying_attributes(
+            identifiers,
+            host_description,
+            resource_attributes,
+            running_mode,
+        ),
     )
 }
 
@@ -377,18 +728,50 @@ pub fn start_ac_opamp_client(
         .map_err(|err| RunError(format!("error initializing OpAMP client: {err}")))
 }
 
-fn ac_identifying_attributes() -> HashMap<String, DescriptionValueType> {
-    HashMap::from([(
-        OPAMP_AGENT_VERSION_ATTRIBUTE_KEY.to_string(),
-        DescriptionValueType::String(AGENT_CONTROL_VERSION.to_string()),
-    )])
+fn ac_identifying_attributes(
+    release_channel: ReleaseChannel,
+) -> HashMap<String, DescriptionValueType> {
+    HashMap::from([
+        (
+            OPAMP_AGENT_VERSION_ATTRIBUTE_KEY.to_string(),
+            DescriptionValueType::String(AGENT_CONTROL_VERSION.to_string()),
+        ),
+        (
+            RELEASE_CHANNEL_ATTRIBUTE_KEY.to_string(),
+            release_channel.to_string().into(),
+        ),
+    ])
 }
 
+/// The detected attributes take precedence over the configured ones, so these can't change how
+/// Fleet Control identifies the host.
 fn ac_non_identifying_attributes(
     identifiers: &Identifiers,
+    host_description: &HostDescription,
+    resource_attributes: &BTreeMap<String, String>,
     running_mode: RunningMode,
 ) -> HashMap<String, DescriptionValueType> {
-    let mut attributes = HashMap::from([
+    let mut attributes: HashMap<String, DescriptionValueType> = resource_attributes
+        .iter()
+        .map(|(key, value)| (key.clone(), value.clone().into()))
+        .collect();
+
+    let described = [
+        (OS_VERSION_ATTRIBUTE_KEY, &host_description.os_version),
+        (
+            CLOUD_PROVIDER_ATTRIBUTE_KEY,
+            &host_description.cloud_provider,
+        ),
+        (CLOUD_REGION_ATTRIBUTE_KEY, &host_description.cloud_region),
+    ];
+    attributes.extend(
+        described
+            .into_iter()
+            .filter(|(_, value)| !value.is_empty())
+            .map(|(key, value)| (key.to_string(), value.clone().into())),
+    );
+
+    attributes.extend([
         (
             HOST_NAME_ATTRIBUTE_KEY.to_string(),
             identifiers.hostname.clone().into(),
@@ -405,6 +788,10 @@ fn ac_non_identifying_attributes(
             OS_ATTRIBUTE_KEY.to_string(),
             OS_ATTRIBUTE_VALUE.to_string().into(),
         ),
+        (
+            INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY.to_string(),
+            INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE.to_string().into(),
+        ),
     ]);
 
     // Only add execution mode attribute in verify mode
@@ -417,3 +804,78 @@ fn ac_non_identifying_attributes(
 
     attributes
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_otel_resource_attributes() {
+        let identifiers = Identifiers {
+            host_id: "host-id".to_string(),
+            hostname: "my-host".to_string(),
+            ..Default::default()
+        };
+        let configured = BTreeMap::from([
+            ("deployment.environment".to_string(), "prod,eu".to_string()),
+            ("host.name".to_string(), "renamed".to_string()),
+        ]);
+
+        assert_eq!(
+            otel_resource_attributes(&identifiers, &BTreeMap::new()),
+            "host.id=host-id,host.name=my-host"
+        );
+        assert_eq!(
+            otel_resource_attributes(&identifiers, &configured),
+            "deployment.environment=prod%2Ceu,host.id=host-id,host.name=renamed"
+        );
+        assert_eq!(
+            otel_resource_attributes(&Identifiers::default(), &BTreeMap::new()),
+            ""
+        );
+    }
+
+    #[test]
+    fn test_ac_non_identifying_attributes() {
+        let identifiers = Identifiers {
+            host_id: "host-id".to_string(),
+            hostname: "my-host".to_string(),
+            ..Default::default()
+        };
+        let host_description = HostDescription {
+            os_version: "22.04".to_string(),
+            cloud_provider: "aws".to_string(),
+            ..Default::default()
+        };
+        let configured = BTreeMap::from([
+            ("deployment.environment".to_string(), "prod".to_string()),
+            ("host.id".to_string(), "overridden".to_string()),
+        ]);
+
+        let attributes = ac_non_identifying_attributes(
+            &identifiers,
+            &host_description,
+            &configured,
+            RunningMode::Normal,
+        );
+
+        assert_eq!(
+            attributes.get("deployment.environment"),
+            Some(&"prod".to_string().into())
+        );
+        assert_eq!(
+            attributes.get("host.id"),
+            Some(&"host-id".to_string().into())
+        );
+        assert_eq!(
+            attributes.get("os.version"),
+            Some(&"22.04".to_string().into())
+        );
+        assert_eq!(
+            attributes.get("cloud.provider"),
+            Some(&"aws".to_string().into())
+        );
+        assert!(!attributes.contains_key("cloud.region"));
+        assert!(!attributes.contains_key(EXECUTION_MODE_ATTRIBUTE_KEY));
+    }
+}
@@ -1,5 +1,6 @@
 //! On-host self-update [`VersionUpdater`]: downloads, verifies and self-replaces the AC binary.
 
+pub mod in_progress;
 pub mod verify;
 
 use crate::agent_control::agent_id::AgentID;
@@ -7,15 +8,23 @@ use crate::agent_control::config::{
     AgentControlDynamicConfig, AgentControlPackage, UpgradeBackoffConfig,
 };
 use crate::agent_control::defaults::AGENT_CONTROL_VERSION;
+use crate::agent_control::feature_flags::{FeatureFlag, FeatureFlagEvaluator, FeatureFlags};
 use crate::agent_control::version_updater::updater::{UpdaterError, VersionUpdater};
 use crate::agent_type::runtime_config::on_host::package::rendered::{Oci, Repository, Version};
+use crate::audit::{AuditEvent, AuditTrail};
+use crate::data_store::DataStore;
 use crate::event::AgentControlInternalEvent;
 use crate::event::channel::EventPublisher;
+use crate::on_host::file_store::FileStore;
 use crate::package::manager::{PackageData, PackageManager};
 use crate::utils::backoff_gate::{BackoffGate, SuppressionReason};
 use crate::utils::retry::BackoffPolicy;
 use crate::utils::time::Clock;
+use fs::directory_manager::DirectoryManagerFs;
+use fs::file::LocalFile;
+use in_progress::{SelfUpdateInProgress, SelfUpdateMarker};
 use self_replacer::SelfReplacer;
+use std::sync::Mutex;
 use thiserror::Error;
 use tracing::{debug, debug_span, warn};
 use url::Url;
@@ -41,12 +50,13 @@ pub enum BuildError {
 
 /// On-host [`VersionUpdater`] that installs and self-replaces the Agent Control binary, with a
 /// backoff gate throttling re-attempts at a failing upgrade.
-pub struct OnHostACUpdater<P, V, C, R>
+pub struct OnHostACUpdater<P, V, C, R, D = FileStore<LocalFile, DirectoryManagerFs>>
 where
     P: PackageManager,
     V: VerifyExecutor,
     C: Clock,
     R: SelfReplacer,
+    D: DataStore,
 {
     ac_remote_update_enabled: bool,
     agent_control_internal_publisher: EventPublisher<AgentControlInternalEvent>,
@@ -58,26 +68,77 @@ where
     /// Throttles re-attempts at a failing upgrade so we don't hammer the registry every
     /// OpAMP poll. Keyed by target [`Version`]: a new desired version resets the cooldown.
     upgrade_gate: BackoffGate<Version, C>,
+    /// Persists the upgrade being applied so an interrupted upgrade is detected on next start.
+    in_progress_marker: Option<SelfUpdateMarker<D>>,
+    /// Gates the self-update through [FeatureFlag::SelfUpdate].
+    feature_flags: FeatureFlagEvaluator,
+    /// Flag overrides of the last dynamic config, so [`retry`](VersionUpdater::retry) honors them.
+    feature_flag_overrides: Mutex<FeatureFlags>,
+    /// Records the replaced binaries.
+    audit_trail: AuditTrail,
 }
 
-impl<P, V, C, R> VersionUpdater for OnHostACUpdater<P, V, C, R>
+impl<P, V, C, R, D> VersionUpdater for OnHostACUpdater<P, V, C, R, D>
 where
     P: PackageManager,
     V: VerifyExecutor,
     C: Clock,
     R: SelfReplacer,
+    D: DataStore,
 {
-    /// Only `config.version` is consumed from the dynamic config. This contract is what
-    /// lets [`retry`](Self::retry) reconstruct a config from just the gate's tracked version.
-    /// If `update` ever starts reading additional dynamic-config fields, that reconstruction must
-    /// be revisited (or replaced with a stored snapshot of the last config) to not use defaults.
+    /// Only `config.version` and `config.feature_flag_overrides` are consumed from the dynamic
+    /// config. The overrides are kept so [`retry`](Self::retry) can re-drive the upgrade from just
+    /// the gate's tracked version. If `update` ever starts reading additional dynamic-config fields,
+    /// they must be kept as well to not use defaults on retries.
     fn update(&self, config: &AgentControlDynamicConfig) -> Result<(), UpdaterError> {
+        *self
+            .feature_flag_overrides
+            .lock()
+            .expect("feature flag overrides lock poisoned") = config.feature_flag_overrides.clone();
+        self.update_to(config.version.as_ref())
+    }
+
+    fn retry(&self) -> Result<(), UpdaterError> {
+        // The gate's tracked key is the last version we tried to reach; if it is cleared there is
+        // nothing pending, otherwise re-drive the normal update path for that version.
+        let version = self.upgrade_gate.current_key();
+        if version.is_some() {
+            self.update_to(version.as_ref())
+        } else {
+            Ok(())
+        }
+    }
+}
+
+impl<P, V, C, R, D> OnHostACUpdater<P, V, C, R, D>
+where
+    P: PackageManager,
+    V: VerifyExecutor,
+    C: Clock,
+    R: SelfReplacer,
+    D: DataStore,
+{
+    /// Upgrades to `version` unless self-update is disabled or the desired version is the current one.
+    fn update_to(&self, version: Option<&Version>) -> Result<(), UpdaterError> {
         if !self.ac_remote_update_enabled {
             debug!("Remote update is disabled, skipping update process");
             return Ok(());
         }
 
-        let Some(new_version) = &config.version else {
+        let overrides = self
+            .feature_flag_overrides
+            .lock()
+            .expect("feature flag overrides lock poisoned")
+            .clone();
+        if !self
+            .feature_flags
+            .is_enabled(FeatureFlag::SelfUpdate, &overrides)
+        {
+            debug!("Remote update is disabled by feature flag, skipping update process");
+            return Ok(());
+        }
+
+        let Some(new_version) = version else {
             debug!("Version is not specified in the dynamic config");
             return Ok(());
         };
@@ -106,28 +167,6 @@ where
             .map_err(|e| self.suppressed_error(new_version, e))?
     }
 
-    fn retry(&self) -> Result<(), UpdaterError> {
-        // The gate's tracked key is the last version we tried to reach; if it is cleared there is
-        // nothing pending, otherwise re-drive the normal `update` path for that version.
-        let version = self.upgrade_gate.current_key();
-        if version.is_some() {
-            self.update(&AgentControlDynamicConfig {
-                version,
-                ..Default::default()
-            })
-        } else {
-            Ok(())
-        }
-    }
-}
-
-impl<P, V, C, R> OnHostACUpdater<P, V, C, R>
-where
-    P: PackageManager,
-    V: VerifyExecutor,
-    C: Clock,
-    R: SelfReplacer,
-{
     /// Builds the updater from the self-update toggle, event publisher, collaborators, package
     /// source, backoff configuration and clock.
     #[allow(clippy::too_many_arguments)]
@@ -152,6 +191,35 @@ where
             // The gate owns the exponential-backoff-plus-jitter schedule (it never sleeps; it
             // records a "next attempt" instant checked across OpAMP polls).
             upgrade_gate: BackoffGate::new(BackoffPolicy::from(&backoff), clock),
+            in_progress_marker: None,
+            feature_flags: FeatureFlagEvaluator::default(),
+            feature_flag_overrides: Mutex::default(),
+            audit_trail: AuditTrail::default(),
+        }
+    }
+
+    /// Gates the self-update with the [FeatureFlag::SelfUpdate] flag, see [FeatureFlagEvaluator].
+    pub fn with_feature_flags(self, feature_flags: FeatureFlagEvaluator) -> Self {
+        Self {
+            feature_flags,
+            ..self
+        }
+    }
+
+    /// Persists every upgrade that reaches the self-replace step through `marker`, see
+    /// [`SelfUpdateMarker::resolve`].
+    pub fn with_in_progress_marker(self, marker: SelfUpdateMarker<D>) -> Self {
+        Self {
+            in_progress_marker: Some(marker),
+            ..self
+        }
+    }
+
+    /// Records every replaced binary in `audit_trail`.
+    pub fn with_audit_trail(self, audit_trail: AuditTrail) -> Self {
+        Self {
+            audit_trail,
+            ..self
         }
     }
 
@@ -187,7 +255,7 @@ where
 
     /// Performs a single upgrade attempt: install → verify → self-replace → request restart.
     fn try_upgrade(&self, new_version: Version) -> Result<(), UpdaterError> {
-        let package_data = self.get_package_data(new_version);
+        let package_data = self.get_package_data(new_version.clone());
 
         let new_binary_path = self
             .package_manager
@@ -210,12 +278,29 @@ where
 
         debug!("Attempting to self-replace with new binary",);
 
+        let in_progress = SelfUpdateInProgress {
+            from_version: AGENT_CONTROL_VERSION.to_string(),
+            to_version: new_version.to_string(),
+        };
+        if let Some(marker) = &self.in_progress_marker {
+            let _ = marker
+                .mark(&in_progress)
+                .inspect_err(|err| warn!("Could not persist the in-progress self-update: {err}"));
+        }
+
         self.self_replacer
             .self_replace(&new_binary_path)
             .map_err(|e| {
+                // The binary was not replaced, so there is nothing to resolve on next start.
+                self.clear_in_progress_marker();
                 UpdaterError::UpdateFailed(format!("self replacing Agent Control binary: {e}"))
             })?;
 
+        self.audit_trail.record(AuditEvent::BinaryReplaced {
+            from_version: in_progress.from_version,
+            to_version: in_progress.to_version,
+        });
+
         debug!("Agent Control binary replaced, stopping to allow the new version to start");
         self.agent_control_internal_publisher
             .publish(AgentControlInternalEvent::SelfUpdateRestartRequested())
@@ -224,6 +309,14 @@ where
         Ok(())
     }
 
+    fn clear_in_progress_marker(&self) {
+        if let Some(marker) = &self.in_progress_marker {
+            let _ = marker
+                .clear()
+                .inspect_err(|err| warn!("Could not clear the in-progress self-update: {err}"));
+        }
+    }
+
     fn get_package_data(&self, new_version: Version) -> PackageData {
         PackageData {
             id: AGENT_CONTROL_BIN_PACKAGE_ID.to_string(),
@@ -244,11 +337,13 @@ mod tests {
         AgentControlPackage, UpgradeBaseDelay, UpgradeJitter, UpgradeMaxConsecutiveFailures,
         UpgradeMaxDelay,
     };
+    use crate::agent_control::feature_flags::FlagSetting;
     use crate::event::channel::pub_sub;
     use crate::package::manager::tests::MockPackageManager;
     use crate::package::oci::package_manager::OCIPackageManagerError;
     use crate::utils::time::SystemClock;
     use mockall::mock;
+    use rstest::rstest;
     use self_replacer::BinaryReplacer;
     use std::path::Path;
     use std::str::FromStr;
@@ -347,6 +442,24 @@ mod tests {
         assert!(updater.update(&config).is_ok());
     }
 
+    #[rstest]
+    #[case::local(FeatureFlags::from([(FeatureFlag::SelfUpdate, FlagSetting::Toggle(false))]), FeatureFlags::default())]
+    #[case::remote_override(FeatureFlags::default(), FeatureFlags::from([(FeatureFlag::SelfUpdate, FlagSetting::Toggle(false))]))]
+    fn update_is_noop_when_disabled_by_feature_flag(
+        #[case] local: FeatureFlags,
+        #[case] overrides: FeatureFlags,
+    ) {
+        // The package manager has no expectations, installing would panic
+        let updater = new_test_updater_with(UpgradeBackoffConfig::default(), SystemClock)
+            .with_feature_flags(FeatureFlagEvaluator::new(local, "instance-id"));
+        let config = AgentControlDynamicConfig {
+            feature_flag_overrides: overrides,
+            ..config_with_version("99.0.0")
+        };
+        assert!(updater.update(&config).is_ok());
+        assert!(updater.retry().is_ok());
+    }
+
     #[test]
     fn update_is_noop_when_version_not_specified() {
         let updater = new_test_updater_with(UpgradeBackoffConfig::default(), SystemClock);
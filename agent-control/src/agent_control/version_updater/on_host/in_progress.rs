@@ -0,0 +1,221 @@
+//! Persisted marker tracking an on-host self-update across the restart it triggers.
+//!
+//! The marker is written right before the binary is replaced and resolved (then removed) by the
+//! next Agent Control start. If the host reboots in the middle of an upgrade, the next boot can
+//! tell whether the new binary made it into place or the previous one is still running, instead
+//! of silently carrying a half-applied upgrade.
+
+use crate::agent_control::agent_id::AgentID;
+use crate::agent_control::defaults::{AGENT_CONTROL_ID, STORE_KEY_SELF_UPDATE_IN_PROGRESS};
+use crate::audit::{AuditEvent, AuditTrail};
+use crate::data_store::DataStore;
+use crate::lifecycle_hooks::{LifecycleEvent, LifecycleHooks};
+use crate::opamp::instance_id::storer::StorerError;
+use crate::resource_ownership::ResourceOwnership;
+use serde::{Deserialize, Serialize};
+use std::sync::Arc;
+use tracing::{debug, info, warn};
+
+/// Self-update that started replacing the binary but has not been confirmed by a restart yet.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct SelfUpdateInProgress {
+    /// Version running when the upgrade started.
+    pub from_version: String,
+    /// Version the upgrade was moving to.
+    pub to_version: String,
+}
+
+/// How a self-update left in progress by a previous run was resolved.
+#[derive(Debug, PartialEq)]
+pub enum InterruptedSelfUpdate {
+    /// The running binary is the target version, the upgrade completed.
+    Completed(SelfUpdateInProgress),
+    /// The running binary is not the target version, the upgrade never reached the restart and
+    /// the previous binary is still in place. The desired version is re-driven by the updater.
+    RolledBack(SelfUpdateInProgress),
+}
+
+/// Reads and writes the [`SelfUpdateInProgress`] marker in the Agent Control data store.
+pub struct SelfUpdateMarker<D>
+where
+    D: DataStore,
+{
+    data_store: Arc<D>,
+    lifecycle_hooks: LifecycleHooks,
+    audit_trail: AuditTrail,
+}
+
+impl<D> From<Arc<D>> for SelfUpdateMarker<D>
+where
+    D: DataStore,
+{
+    fn from(data_store: Arc<D>) -> Self {
+        Self {
+            data_store,
+            lifecycle_hooks: LifecycleHooks::default(),
+            audit_trail: AuditTrail::default(),
+        }
+    }
+}
+
+impl<D> SelfUpdateMarker<D>
+where
+    D: DataStore,
+{
+    /// Sets the hooks notified when a marker is resolved as a completed or rolled back upgrade.
+    pub fn with_lifecycle_hooks(self, lifecycle_hooks: LifecycleHooks) -> Self {
+        Self {
+            lifecycle_hooks,
+            ..self
+        }
+    }
+
+    /// Sets the audit trail recording the upgrades rolled back when a marker is resolved.
+    pub fn with_audit_trail(self, audit_trail: AuditTrail) -> Self {
+        Self {
+            audit_trail,
+            ..self
+        }
+    }
+
+    /// Persists the marker for an upgrade that is about to replace the binary.
+    pub fn mark(&self, in_progress: &SelfUpdateInProgress) -> Result<(), StorerError> {
+        debug!(
+            from_version = %in_progress.from_version,
+            to_version = %in_progress.to_version,
+            "Marking self-update as in progress"
+        );
+        self.data_store
+            .set_remote_data(
+                &AgentID::AgentControl,
+                ResourceOwnership::AgentControl,
+                STORE_KEY_SELF_UPDATE_IN_PROGRESS,
+                in_progress,
+            )
+            .map_err(Into::into)
+    }
+
+    /// Returns the persisted marker, if any.
+    pub fn get(&self) -> Result<Option<SelfUpdateInProgress>, StorerError> {
+        self.data_store
+            .get_remote_data(&AgentID::AgentControl, STORE_KEY_SELF_UPDATE_IN_PROGRESS)
+            .map_err(Into::into)
+    }
+
+    /// Removes the persisted marker. Removing a marker that does not exist is not an error.
+    pub fn clear(&self) -> Result<(), StorerError> {
+        self.data_store
+            .delete_remote_data(&AgentID::AgentControl, STORE_KEY_SELF_UPDATE_IN_PROGRESS)
+            .map_err(Into::into)
+    }
+
+    /// Resolves a marker left behind by a previous run against the `running_version` and removes
+    /// it. Returns `None` when no upgrade was in progress.
+    pub fn resolve(
+        &self,
+        running_version: &str,
+    ) -> Result<Option<InterruptedSelfUpdate>, StorerError> {
+        let Some(in_progress) = self.get()? else {
+            return Ok(None);
+        };
+
+        let resolution = if in_progress.to_version == running_version {
+            info!(
+                from_version = %in_progress.from_version,
+                to_version = %in_progress.to_version,
+                "Self-update completed after restart"
+            );
+            self.lifecycle_hooks
+                .notify(LifecycleEvent::UpgradeComplete {
+                    from_version: in_progress.from_version.clone(),
+                    to_version: in_progress.to_version.clone(),
+                });
+            InterruptedSelfUpdate::Completed(in_progress)
+        } else {
+            warn!(
+                from_version = %in_progress.from_version,
+                to_version = %in_progress.to_version,
+                running_version,
+                "Self-update was interrupted before restarting, continuing with the running version"
+            );
+            self.audit_trail.record(AuditEvent::SelfUpdateRolledBack {
+                from_version: in_progress.from_version.clone(),
+                to_version: in_progress.to_version.clone(),
+            });
+            self.lifecycle_hooks.notify(LifecycleEvent::Rollback {
+                agent_id: AGENT_CONTROL_ID.to_string(),
+                reason: format!(
+                    "self-update to {} interrupted, continuing with {running_version}",
+                    in_progress.to_version
+                ),
+            });
+            InterruptedSelfUpdate::RolledBack(in_progress)
+        };
+
+        self.clear()?;
+        Ok(Some(resolution))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::on_host::file_store::FileStore;
+    use fs::directory_manager::DirectoryManagerFs;
+    use fs::file::LocalFile;
+    use tempfile::TempDir;
+
+    fn marker(tmp_dir: &TempDir) -> SelfUpdateMarker<FileStore<LocalFile, DirectoryManagerFs>> {
+        SelfUpdateMarker::from(Arc::new(FileStore::new_local_fs(
+            tmp_dir.path().join("local"),
+            tmp_dir.path().join("remote"),
+        )))
+    }
+
+    fn in_progress() -> SelfUpdateInProgress {
+        SelfUpdateInProgress {
+            from_version: "1.0.0".to_string(),
+            to_version: "1.1.0".to_string(),
+        }
+    }
+
+    #[test]
+    fn test_resolve_without_marker() {
+        let tmp_dir = TempDir::new().unwrap();
+        let marker = marker(&tmp_dir);
+
+        assert_eq!(marker.resolve("1.0.0").unwrap(), None);
+    }
+
+    #[test]
+    fn test_resolve_completed_upgrade() {
+        let tmp_dir = TempDir::new().unwrap();
+        let marker = marker(&tmp_dir);
+        marker.mark(&in_progress()).unwrap();
+
+        assert_eq!(
+            marker.resolve("1.1.0").unwrap(),
+            Some(InterruptedSelfUpdate::Completed(in_progress()))
+        );
+        assert_eq!(marker.get().unwrap(), None);
+    }
+
+    #[test]
+    fn test_resolve_interrupted_upgrade() {
+        let tmp_dir = TempDir::new().unwrap();
+        let marker = marker(&tmp_dir);
+        marker.mark(&in_progress()).unwrap();
+
+        assert_eq!(
+            marker.resolve("1.0.0").unwrap(),
+            Some(InterruptedSelfUpdate::RolledBack(in_progress()))
+        );
+        assert_eq!(marker.get().unwrap(), None);
+    }
+
+    #[test]
+    fn test_clear_without_marker() {
+        let tmp_dir = TempDir::new().unwrap();
+        assert!(marker(&tmp_dir).clear().is_ok());
+    }
+}
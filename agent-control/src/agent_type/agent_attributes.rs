@@ -2,7 +2,8 @@
 use super::variable::{Variable, namespace::Namespace};
 use crate::agent_control::agent_id::AgentID;
 use crate::agent_control::defaults::{AGENT_FILESYSTEM_FOLDER_NAME, SHARED_FILESYSTEM_FOLDER_NAME};
-use std::{collections::HashMap, path::PathBuf};
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
 use thiserror::Error;
 use tracing::debug;
 
@@ -55,6 +56,15 @@ impl AgentAttributes {
         }
     }
 
+    /// Places the sub-agent's dedicated filesystem directory under `filesystem_dir` instead of the
+    /// remote directory.
+    pub fn with_filesystem_dir(self, filesystem_dir: &Path) -> Self {
+        Self {
+            agent_filesystem_dir: filesystem_dir.join(&self.agent_id),
+            ..self
+        }
+    }
+
     /// returns the variables from the sub-agent attributes source 'nr-sub'.
     pub fn sub_agent_variables(&self) -> HashMap<String, Variable> {
         HashMap::from([
@@ -140,4 +150,20 @@ mod tests {
             final_string(&b.sub_agent_variables(), key),
         );
     }
+
+    #[test]
+    fn agent_filesystem_dir_can_be_relocated() {
+        let remote_dir = PathBuf::from(AGENT_CONTROL_DATA_DIR);
+        let filesystem_dir = PathBuf::from("tmpfs").join("filesystem");
+        let attrs = AgentAttributes::try_new(AgentID::try_from("my-agent").unwrap(), remote_dir)
+            .unwrap()
+            .with_filesystem_dir(&filesystem_dir);
+
+        let vars = attrs.sub_agent_variables();
+
+        assert_eq!(
+            final_string(&vars, AgentAttributes::VARIABLE_FILESYSTEM_AGENT_DIR),
+            filesystem_dir.join("my-agent").to_string_lossy(),
+        );
+    }
 }
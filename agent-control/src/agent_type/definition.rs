@@ -19,6 +19,7 @@ use crate::agent_type::runtime_config::on_host::OnHost;
 use crate::agent_type::runtime_config::on_host::rendered::RenderedPackages;
 use crate::agent_type::variable::constraints::VariableConstraints;
 use crate::agent_type::variable::namespace::Namespace;
+use crate::package::bundle;
 use crate::package::oci::package_manager::get_package_path;
 use crate::{agent_control::agent_id::AgentID, package::manager::PackageData};
 use crate::{agent_type::variable::tree::VarTree, values::yaml_config::YAMLConfig};
@@ -316,8 +317,9 @@ impl From<VariableTree> for Variables {
 
 // TODO refactor Variables into a struct with methods
 
-/// Adds a reserved `${nr-sub:packages.<id>.dir}` variable for each rendered package, pointing at
-/// the directory where the package is stored on disk.
+/// Adds the reserved `${nr-sub:packages.<id>.dir}` variable for each rendered package, pointing at
+/// the directory where the package is stored on disk, and `${nr-sub:packages.<id>.artifacts_dir}`,
+/// pointing at the binaries of the host architecture if the package is a multi-architecture bundle.
 pub fn include_packages_variables(
     mut variables: Variables,
     packages: &RenderedPackages,
@@ -359,6 +361,10 @@ pub fn include_packages_variables(
             })?;
         debug!(package_id = %package_id, path = %path.display(), "Setting reserved variable for package directory");
 
+        variables.insert(
+            Namespace::SubAgent.namespaced_name(format!("packages.{}.artifacts_dir", package_id)),
+            Variable::new_final_string_variable(bundle::artifacts_dir(&path).to_string_lossy()),
+        );
         variables.insert(
             Namespace::SubAgent.namespaced_name(format!("packages.{}.dir", package_id)),
             Variable::new_final_string_variable(path.to_string_lossy()),
@@ -147,7 +147,7 @@ pub mod tests {
     use super::*;
     use crate::agent_type::agent_type_id::AgentTypeID;
     use crate::environment::Environment;
-    use crate::http::config::ProxyConfig;
+    use crate::http::config::{EgressConfig, ProxyConfig};
     use crate::oci::artifact_definitions::{LayerMediaType, ManifestArtifactType};
     use crate::oci::tests::FakeOciServer;
     use crate::utils::test_runtime::tokio_runtime;
@@ -218,6 +218,7 @@ pub mod tests {
                 ..Default::default()
             },
             ProxyConfig::default(),
+            EgressConfig::default(),
             tokio_runtime(),
         )
         .unwrap();
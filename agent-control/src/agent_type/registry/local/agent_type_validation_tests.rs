@@ -8,7 +8,9 @@
 
 use super::LocalRegistry;
 use crate::agent_control::run::k8s::{NAMESPACE_AGENTS_VARIABLE_NAME, NAMESPACE_VARIABLE_NAME};
-use crate::agent_control::run::on_host::HOST_ID_VARIABLE_NAME;
+use crate::agent_control::run::on_host::{
+    HOST_ID_VARIABLE_NAME, RESOURCE_ATTRIBUTES_VARIABLE_NAME,
+};
 use crate::agent_type::variable::constraints::VariableConstraints;
 use crate::environment::Environment;
 use crate::{
@@ -22,7 +24,7 @@ use crate::{
     values::yaml_config::YAMLConfig,
 };
 use std::collections::HashSet;
-use std::{collections::HashMap, iter, ops::Deref, sync::LazyLock};
+use std::{collections::HashMap, ops::Deref, sync::LazyLock};
 
 type CaseDescription = &'static str;
 type YamlContents = &'static str;
@@ -370,6 +372,26 @@ static AGENT_TYPE_FLUENTBIT: LazyLock<AgentTypeValuesTestCase> =
             ]),
         }
         .into(),
+        values_linux: AgentTypeValues {
+            cases: HashMap::from([
+                ("mandatory fields only", "{}"),
+                (
+                    "check all value types are correct",
+                    r#"
+                config: "some file contents"
+                parsers: "some file contents"
+                includes:
+                    inputs.conf: "some file contents"
+                log_level: "debug"
+                backoff_delay: "10s"
+                health_check.port: 12345
+                health_check.restart_after_failures: 3
+                "#,
+                ),
+            ]),
+            ..Default::default()
+        }
+        .into(),
         ..Default::default()
     });
 
@@ -425,6 +447,7 @@ static AGENT_TYPE_OTEL_COLLECTOR: LazyLock<AgentTypeValuesTestCase> =
                 backoff_delay: "10s"
                 health_check.path: "/health"
                 health_check.port: 12345
+                health_check.restart_after_failures: 3
                 "#,
                 ),
             ]),
@@ -442,6 +465,7 @@ static AGENT_TYPE_OTEL_COLLECTOR: LazyLock<AgentTypeValuesTestCase> =
                 backoff_delay: "10s"
                 health_check.path: "/health"
                 health_check.port: 12345
+                health_check.restart_after_failures: 3
                 "#,
                 ),
             ]),
@@ -502,6 +526,7 @@ static AGENT_TYPE_OTEL_COLLECTOR_OLD: LazyLock<AgentTypeValuesTestCase> =
                 backoff_delay: "10s"
                 health_check.path: "/health"
                 health_check.port: 12345
+                health_check.restart_after_failures: 3
                 "#,
                 ),
             ]),
@@ -772,10 +797,19 @@ fn iterate_test_cases(environment: Environment) {
                 .into_iter(),
             ),
             Environment::Linux | Environment::Windows => TemplateRenderer::default()
-                .with_agent_control_variables(iter::once((
-                    HOST_ID_VARIABLE_NAME.to_string(),
-                    Variable::new_final_string_variable("my-namespace".to_string()),
-                ))),
+                .with_agent_control_variables(
+                    HashMap::from([
+                        (
+                            HOST_ID_VARIABLE_NAME.to_string(),
+                            Variable::new_final_string_variable("my-namespace".to_string()),
+                        ),
+                        (
+                            RESOURCE_ATTRIBUTES_VARIABLE_NAME.to_string(),
+                            Variable::new_final_string_variable("host.id=my-host".to_string()),
+                        ),
+                    ])
+                    .into_iter(),
+                ),
         };
 
         values.cases.iter().for_each(|(scenario, yaml)| {
@@ -7,6 +7,7 @@ pub mod k8s;
 pub mod on_host;
 pub mod rendered;
 pub mod restart_policy;
+pub mod scheduling;
 pub mod templateable_value;
 pub mod version_config;
 
@@ -1,4 +1,4 @@
-//! Health-check configuration for on-host agents (HTTP or file-based checks).
+//! Health-check configuration for on-host agents (HTTP, TCP, exec or file-based checks).
 use duration_str::deserialize_duration;
 use serde::Deserialize;
 use std::{collections::HashMap, time::Duration};
@@ -12,6 +12,7 @@ use crate::checkers::health::health_checker::{HealthCheckInterval, InitialDelay}
 use super::templateable_value::TemplateableValue;
 
 const DEFAULT_HEALTH_CHECK_TIMEOUT: Duration = Duration::from_secs(15);
+const DEFAULT_DATA_FLOW_MAX_IDLE: Duration = Duration::from_secs(300);
 
 pub mod rendered;
 
@@ -36,6 +37,15 @@ pub struct OnHostHealthConfig {
     /// Details on the type of health check. Defined by the `HealthCheck` enumeration.
     #[serde(default, flatten)]
     pub(crate) check: Option<OnHostHealthCheck>,
+
+    /// Number of consecutive failed checks after which the executables are restarted. Zero
+    /// never restarts them.
+    #[serde(default)]
+    pub(crate) restart_after_failures: TemplateableValue<u32>,
+
+    /// Check of the data sent by an OpenTelemetry collector, next to the one of `check`.
+    #[serde(default)]
+    pub(crate) data_flow: Option<DataFlowHealth>,
 }
 
 /// The maximum duration a health check may run before being considered failed.
@@ -45,15 +55,71 @@ pub struct HealthCheckTimeout(#[serde(deserialize_with = "deserialize_duration")
 
 /// Enumeration representing the possible types of health checks.
 ///
-/// Variants include `HttpHealth` and `ExecHealth`, corresponding to health checks via HTTP and execute command, respectively.
+/// Variants include `HttpHealth`, `TcpHealth`, `ExecHealth` and `FileHealth`, corresponding to
+/// health checks via HTTP, TCP connection, execute command and health file, respectively.
 #[derive(Debug, Deserialize, Clone, PartialEq)]
 pub(crate) enum OnHostHealthCheck {
     #[serde(rename = "http")]
     HttpHealth(HttpHealth),
+    #[serde(rename = "tcp")]
+    TcpHealth(TcpHealth),
+    #[serde(rename = "exec")]
+    ExecHealth(ExecHealth),
     #[serde(rename = "file")]
     FileHealth(FileHealth),
 }
 
+/// Represents a health check connecting to a TCP port, healthy if the connection is accepted.
+#[derive(Debug, Deserialize, Clone, PartialEq)]
+pub(crate) struct TcpHealth {
+    #[serde(default)]
+    pub(crate) host: TemplateableValue<HttpHost>,
+
+    /// The port to connect to.
+    pub(crate) port: TemplateableValue<u16>,
+}
+
+/// Represents a health check executing a command, healthy if it exits successfully.
+#[derive(Debug, Deserialize, Clone, PartialEq)]
+pub(crate) struct ExecHealth {
+    /// Path of the command to execute.
+    pub(crate) path: String,
+
+    /// Arguments of the command.
+    #[serde(default)]
+    pub(crate) args: Vec<String>,
+}
+
+/// Represents a health check of the data sent by an OpenTelemetry collector, read from the exporter
+/// metrics of its internal telemetry endpoint. Unhealthy when no data is sent for `max_idle`.
+#[derive(Debug, Deserialize, Clone, PartialEq)]
+pub(crate) struct DataFlowHealth {
+    #[serde(default)]
+    pub(crate) host: TemplateableValue<HttpHost>,
+    /// The port of the Prometheus endpoint of the collector internal telemetry.
+    pub(crate) port: TemplateableValue<u16>,
+    /// Time without data sent after which the collector is unhealthy.
+    #[serde(default)]
+    pub(crate) max_idle: DataFlowMaxIdle,
+}
+
+/// The time a collector can go without sending data before it is unhealthy.
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_DATA_FLOW_MAX_IDLE)]
+pub struct DataFlowMaxIdle(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+impl Templateable for DataFlowHealth {
+    type Output = rendered::DataFlowHealth;
+
+    fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+        Ok(Self::Output {
+            host: self.host.template_with(variables)?,
+            port: self.port.template_with(variables)?,
+            max_idle: self.max_idle.into(),
+        })
+    }
+}
+
 #[derive(Debug, Deserialize, Clone, PartialEq)]
 pub(crate) struct FileHealth {
     pub(crate) path: String,
@@ -187,6 +253,11 @@ impl Templateable for OnHostHealthConfig {
             interval: self.interval,
             initial_delay: self.initial_delay,
             timeout: self.timeout,
+            restart_after_failures: self.restart_after_failures.template_with(variables)?,
+            data_flow: self
+                .data_flow
+                .map(|data_flow| data_flow.template_with(variables))
+                .transpose()?,
         })
     }
 }
@@ -206,6 +277,24 @@ impl Templateable for OnHostHealthCheck {
                 };
                 rendered::OnHostHealthCheck::HttpHealth(health_conf)
             }
+            OnHostHealthCheck::TcpHealth(conf) => {
+                let health_conf = rendered::TcpHealth {
+                    host: conf.host.template_with(variables)?,
+                    port: conf.port.template_with(variables)?,
+                };
+                rendered::OnHostHealthCheck::TcpHealth(health_conf)
+            }
+            OnHostHealthCheck::ExecHealth(conf) => {
+                let health_conf = ExecHealth {
+                    path: conf.path.template_with(variables)?,
+                    args: conf
+                        .args
+                        .into_iter()
+                        .map(|arg| arg.template_with(variables))
+                        .collect::<Result<_, _>>()?,
+                };
+                rendered::OnHostHealthCheck::ExecHealth(health_conf)
+            }
             OnHostHealthCheck::FileHealth(conf) => {
                 let health_conf = FileHealth {
                     path: conf.path.template_with(variables)?,
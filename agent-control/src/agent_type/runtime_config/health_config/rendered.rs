@@ -1,8 +1,9 @@
 //! Health-check configuration for on-host agents after templating.
 use std::collections::HashMap;
+use std::time::Duration;
 
 use crate::agent_type::runtime_config::health_config::{
-    FileHealth, HealthCheckTimeout, HttpHost, HttpPath, HttpPort,
+    ExecHealth, FileHealth, HealthCheckTimeout, HttpHost, HttpPath, HttpPort,
 };
 use crate::checkers::health::health_checker::{HealthCheckInterval, InitialDelay};
 
@@ -17,14 +18,37 @@ pub struct OnHostHealthConfig {
     pub(crate) timeout: HealthCheckTimeout,
     /// Details on the type of health check. Defined by the `HealthCheck` enumeration.
     pub(crate) check: Option<OnHostHealthCheck>,
+    /// Number of consecutive failed checks after which the executables are restarted. Zero
+    /// never restarts them.
+    pub(crate) restart_after_failures: u32,
+    /// Check of the data sent by an OpenTelemetry collector, next to the one of `check`.
+    pub(crate) data_flow: Option<DataFlowHealth>,
 }
 
 #[derive(Debug, Clone, PartialEq)]
 pub(crate) enum OnHostHealthCheck {
     HttpHealth(HttpHealth),
+    TcpHealth(TcpHealth),
+    ExecHealth(ExecHealth),
     FileHealth(FileHealth),
 }
 
+#[derive(Debug, Clone, PartialEq)]
+pub(crate) struct DataFlowHealth {
+    pub(crate) host: HttpHost,
+    /// The port of the Prometheus endpoint of the collector internal telemetry.
+    pub(crate) port: u16,
+    /// Time without data sent after which the collector is unhealthy.
+    pub(crate) max_idle: Duration,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub(crate) struct TcpHealth {
+    pub(crate) host: HttpHost,
+    /// The port to connect to.
+    pub(crate) port: u16,
+}
+
 #[derive(Debug, Clone, PartialEq)]
 pub(crate) struct HttpHealth {
     pub(crate) host: HttpHost,
@@ -7,6 +7,7 @@ use crate::agent_type::runtime_config::on_host::executable::Executable;
 use crate::agent_type::runtime_config::on_host::filesystem::FileSystem;
 use crate::agent_type::runtime_config::on_host::package::{Package, PackageID};
 use crate::agent_type::runtime_config::on_host::rendered::RenderedPackages;
+use crate::agent_type::runtime_config::on_host::validation::ConfigValidation;
 use crate::agent_type::templates::Templateable;
 use serde::{Deserialize, Deserializer};
 use std::collections::{HashMap, HashSet};
@@ -15,6 +16,7 @@ pub mod executable;
 pub mod filesystem;
 pub mod package;
 pub mod rendered;
+pub mod validation;
 
 /// The definition for an on-host supervisor.
 ///
@@ -32,6 +34,9 @@ pub struct OnHost {
     filesystem: FileSystem,
     #[serde(default)]
     packages: Packages,
+    /// Command validating the candidate configuration before it is applied.
+    #[serde(default)]
+    validation: Option<ConfigValidation>,
 }
 
 type Packages = HashMap<PackageID, Package>;
@@ -81,6 +86,10 @@ impl Templateable for OnHost {
             health: self.health.template_with(&extended_vars)?,
             filesystem: self.filesystem.template_with(&extended_vars)?,
             packages: rendered_packages,
+            validation: self
+                .validation
+                .map(|validation| validation.template_with(&extended_vars))
+                .transpose()?,
         })
     }
 }
@@ -90,7 +99,7 @@ mod tests {
     use super::*;
 
     use crate::agent_type::agent_attributes::AgentAttributes;
-    use crate::agent_type::runtime_config::health_config::HealthCheckTimeout;
+    use crate::agent_type::runtime_config::health_config::{self, HealthCheckTimeout};
     use crate::agent_type::runtime_config::on_host::executable::{Args, Env};
     use crate::agent_type::runtime_config::on_host::package::{Download, Oci};
     use crate::agent_type::runtime_config::restart_policy::{
@@ -103,6 +112,7 @@ mod tests {
     use serde_json::Number;
     use std::collections::HashMap;
     use std::path::PathBuf;
+    use std::time::Duration;
 
     #[test]
     fn test_basic_parsing() {
@@ -127,6 +137,7 @@ mod tests {
             backoff_delay: TemplateableValue::from_template("1s".to_string()),
             max_retries: TemplateableValue::from_template("3".to_string()),
             last_retry_interval: TemplateableValue::from_template("30s".to_string()),
+            ..Default::default()
         };
 
         // Restart policy values
@@ -180,7 +191,7 @@ mod tests {
 executables:
   - id: test
     path: ${nr-sub:packages.my-pkg.dir}
-    args: []
+    args: ["${nr-sub:packages.my-pkg.artifacts_dir}"]
 packages:
   my-pkg:
     download:
@@ -207,16 +218,196 @@ packages:
 
         let rendered = on_host.template_with(&vars).unwrap();
         let exe = rendered.executables.first().unwrap();
+        let package_dir = PathBuf::from("remote")
+            .join("packages")
+            .join("agent-id")
+            .join("stored_packages")
+            .join("my-pkg")
+            .join("oci_my__repo_latest");
+        assert_eq!(exe.path, package_dir.to_string_lossy().to_string());
+        assert_eq!(
+            exe.args.0,
+            vec![
+                package_dir
+                    .join("artifacts")
+                    .join(crate::utils::platform::arch())
+                    .to_string_lossy()
+                    .to_string()
+            ]
+        );
+    }
+
+    #[test]
+    fn test_listen_sockets_are_templated() {
+        let yaml = r#"
+executables:
+  - id: otelcol
+    path: /usr/bin/otelcol
+    listen_sockets:
+      - name: otlp
+        address: tcp://0.0.0.0:${nr-var:port}
+"#;
+        let on_host: OnHost = serde_saphyr::from_str(yaml).unwrap();
+        let variables = Variables::from([(
+            "nr-var:port".to_string(),
+            Variable::new_final_string_variable("4317"),
+        )]);
+
+        let rendered = on_host.template_with(&variables).unwrap();
+        assert_eq!(
+            rendered.executables.first().unwrap().listen_sockets,
+            vec![executable::rendered::ListenSocket {
+                name: "otlp".to_string(),
+                address: "tcp://0.0.0.0:4317".to_string(),
+            }]
+        );
+    }
+
+    #[test]
+    fn test_shutdown_timeout_is_templated() {
+        let yaml = r#"
+executables:
+  - id: otelcol
+    path: /usr/bin/otelcol
+    shutdown_timeout: ${nr-var:shutdown_timeout}
+  - id: other
+    path: /usr/bin/other
+"#;
+        let on_host: OnHost = serde_saphyr::from_str(yaml).unwrap();
+        let variables = Variables::from([(
+            "nr-var:shutdown_timeout".to_string(),
+            Variable::new_final_string_variable("30s"),
+        )]);
+
+        let rendered = on_host.template_with(&variables).unwrap();
+        let timeouts = rendered
+            .executables
+            .into_iter()
+            .map(|e| Duration::from(e.shutdown_timeout))
+            .collect::<Vec<_>>();
+        assert_eq!(
+            timeouts,
+            vec![
+                Duration::from_secs(30),
+                executable::DEFAULT_SHUTDOWN_TIMEOUT
+            ]
+        );
+    }
+
+    #[test]
+    fn test_platform_path_is_selected() {
+        let yaml = format!(
+            r#"
+executables:
+  - id: otelcol
+    path: /usr/bin/otelcol
+    platform_paths:
+      {}: ${{nr-var:dir}}/otelcol
+      plan9/mips: /bin/otelcol
+"#,
+            crate::utils::platform::os_arch()
+        );
+        let on_host: OnHost = serde_saphyr::from_str(&yaml).unwrap();
+        let variables = Variables::from([(
+            "nr-var:dir".to_string(),
+            Variable::new_final_string_variable("/opt/otelcol"),
+        )]);
+
+        let rendered = on_host.template_with(&variables).unwrap();
+        assert_eq!(
+            rendered.executables.first().unwrap().path,
+            "/opt/otelcol/otelcol"
+        );
+
+        let on_host: OnHost = serde_saphyr::from_str(
+            r#"
+executables:
+  - id: otelcol
+    path: /usr/bin/otelcol
+    platform_paths:
+      plan9/mips: /bin/otelcol
+"#,
+        )
+        .unwrap();
+        let rendered = on_host.template_with(&Variables::default()).unwrap();
+        assert_eq!(
+            rendered.executables.first().unwrap().path,
+            "/usr/bin/otelcol"
+        );
+    }
+
+    #[test]
+    fn test_health_probes_are_templated() {
+        let variables = Variables::from([
+            (
+                "nr-var:port".to_string(),
+                Variable::new_final_string_variable("4317"),
+            ),
+            (
+                "nr-var:failures".to_string(),
+                Variable::new_final_string_variable("3"),
+            ),
+        ]);
+
+        let tcp: OnHostHealthConfig = serde_saphyr::from_str(
+            r#"
+tcp:
+  port: ${nr-var:port}
+restart_after_failures: ${nr-var:failures}
+"#,
+        )
+        .unwrap();
+        let rendered = tcp.template_with(&variables).unwrap();
+        assert_eq!(rendered.restart_after_failures, 3);
+        assert_eq!(
+            rendered.check,
+            Some(health_config::rendered::OnHostHealthCheck::TcpHealth(
+                health_config::rendered::TcpHealth {
+                    host: "127.0.0.1".to_string().into(),
+                    port: 4317,
+                }
+            ))
+        );
+
+        let exec: OnHostHealthConfig = serde_saphyr::from_str(
+            r#"
+exec:
+  path: /usr/bin/probe
+  args: [--port, "${nr-var:port}"]
+"#,
+        )
+        .unwrap();
+        let rendered = exec.template_with(&variables).unwrap();
+        assert_eq!(rendered.restart_after_failures, 0);
         assert_eq!(
-            exe.path,
-            PathBuf::from("remote")
-                .join("packages")
-                .join("agent-id")
-                .join("stored_packages")
-                .join("my-pkg")
-                .join("oci_my__repo_latest")
-                .to_string_lossy()
-                .to_string(),
+            rendered.check,
+            Some(health_config::rendered::OnHostHealthCheck::ExecHealth(
+                health_config::ExecHealth {
+                    path: "/usr/bin/probe".to_string(),
+                    args: vec!["--port".to_string(), "4317".to_string()],
+                }
+            ))
+        );
+
+        let data_flow: OnHostHealthConfig = serde_saphyr::from_str(
+            r#"
+http:
+  port: 13133
+data_flow:
+  port: ${nr-var:port}
+  max_idle: 10m
+"#,
+        )
+        .unwrap();
+        let rendered = data_flow.template_with(&variables).unwrap();
+        assert!(rendered.check.is_some());
+        assert_eq!(
+            rendered.data_flow,
+            Some(health_config::rendered::DataFlowHealth {
+                host: "127.0.0.1".to_string().into(),
+                port: 4317,
+                max_idle: Duration::from_secs(600),
+            })
         );
     }
 
@@ -296,11 +487,42 @@ restart_policy:
         assert_eq!(BackoffStrategyConfig::default(), backoff_strategy);
     }
 
+    #[test]
+    fn test_backoff_growth_fields() {
+        let backoff_strategy: BackoffStrategyConfig = serde_saphyr::from_str(
+            r#"
+type: exponential
+multiplier: 1.5
+max_delay: 5m
+jitter: 0.2
+"#,
+        )
+        .unwrap();
+        let rendered = backoff_strategy
+            .template_with(&Variables::default())
+            .unwrap();
+
+        assert_eq!(f64::from(rendered.multiplier), 1.5);
+        assert_eq!(Duration::from(rendered.max_delay), Duration::from_secs(300));
+        assert_eq!(f64::from(rendered.jitter), 0.2);
+
+        for (field, value) in [("multiplier", "0.5"), ("jitter", "1.5")] {
+            let backoff_strategy: BackoffStrategyConfig =
+                serde_saphyr::from_str(&format!("{field}: {value}")).unwrap();
+            assert!(
+                backoff_strategy
+                    .template_with(&Variables::default())
+                    .is_err()
+            );
+        }
+    }
+
     #[test]
     fn test_replacer() {
         let exec = Executable {
             id: "otelcol".to_string(),
             path: TemplateableValue::from_template("${nr-var:bin}/otelcol".to_string()),
+            platform_paths: HashMap::new(),
             args: Args(vec![
                 TemplateableValue::from_template("--verbose".to_string()),
                 TemplateableValue::from_template(
@@ -326,8 +548,13 @@ restart_policy:
                     last_retry_interval: TemplateableValue::from_template(
                         "${nr-var:backoff.interval}".to_string(),
                     ),
+                    ..Default::default()
                 },
+                ..Default::default()
             },
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
 
         let normalized_values = HashMap::from([
@@ -409,8 +636,13 @@ restart_policy:
                     backoff_delay: BackoffDelay::from_secs(10),
                     max_retries: 30.into(),
                     last_retry_interval: BackoffLastRetryInterval::from_secs(300),
+                    ..Default::default()
                 },
+                ..Default::default()
             },
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
 
         assert_eq!(exec_actual, exec_expected);
@@ -421,6 +653,7 @@ restart_policy:
         let exec = Executable {
             id: "otelcol".to_string(),
             path: TemplateableValue::from_template("${nr-var:bin}/otelcol".to_string()),
+            platform_paths: HashMap::new(),
             args: Args(vec![
                 TemplateableValue::from_template("--verbose".to_string()),
                 TemplateableValue::from_template(
@@ -446,8 +679,13 @@ restart_policy:
                     last_retry_interval: TemplateableValue::from_template(
                         "${nr-var:backoff.interval}".to_string(),
                     ),
+                    ..Default::default()
                 },
+                ..Default::default()
             },
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
 
         let normalized_values = HashMap::from([
@@ -520,8 +758,13 @@ restart_policy:
                     backoff_delay: BackoffDelay::from_secs(10),
                     max_retries: 30.into(),
                     last_retry_interval: BackoffLastRetryInterval::from_secs(300),
+                    ..Default::default()
                 },
+                ..Default::default()
             },
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
 
         assert_eq!(exec_actual, exec_expected);
@@ -568,6 +811,7 @@ restart_policy:
         let input = Executable {
             id: "myapp".to_string(),
             path: TemplateableValue::from_template("${nr-var:path}".to_string()),
+            platform_paths: HashMap::new(),
             args: Args(vec![TemplateableValue::from_template(
                 "${nr-var:args}".to_string(),
             )]),
@@ -589,8 +833,13 @@ restart_policy:
                     last_retry_interval: TemplateableValue::from_template(
                         "${nr-var:backoff.interval}".to_string(),
                     ),
+                    ..Default::default()
                 },
+                ..Default::default()
             },
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
         let expected_output = executable::rendered::Executable {
             id: "myapp".to_string(),
@@ -606,8 +855,13 @@ restart_policy:
                     backoff_delay: BackoffDelay::from_secs(10),
                     max_retries: 30.into(),
                     last_retry_interval: BackoffLastRetryInterval::from_secs(300),
+                    ..Default::default()
                 },
+                ..Default::default()
             },
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
         let actual_output = input.template_with(&variables).unwrap();
         assert_eq!(actual_output, expected_output);
@@ -638,6 +892,8 @@ executables:
             initial_delay: InitialDelay::default(),
             timeout: HealthCheckTimeout::default(),
             check: None,
+            restart_after_failures: TemplateableValue::default(),
+            data_flow: None,
         };
 
         // Create a default OnHost instance to compare
@@ -645,6 +901,7 @@ executables:
             executables: vec![Executable {
                 id: "otelcol".to_string(),
                 path: TemplateableValue::from_template("${nr-var:bin}/otelcol".to_string()),
+                platform_paths: HashMap::new(),
                 args: Args(vec![
                     TemplateableValue::from_template("-c".to_string()),
                     TemplateableValue::from_template("${nr-var:deployment.k8s.image}".to_string()),
@@ -655,14 +912,20 @@ executables:
                         backoff_delay: TemplateableValue::from_template("1s".to_string()),
                         max_retries: TemplateableValue::from_template("3".to_string()),
                         last_retry_interval: TemplateableValue::from_template("30s".to_string()),
+                        ..Default::default()
                     },
+                    ..Default::default()
                 },
                 env: Env::default(),
+                scheduling: Default::default(),
+                listen_sockets: Vec::new(),
+                shutdown_timeout: Default::default(),
             }],
             enable_file_logging: TemplateableValue::default(),
             health: default_health_config,
             filesystem: FileSystem::default(),
             packages: Default::default(),
+            validation: None,
         };
 
         // Compare the default OnHost instance with the parsed instance
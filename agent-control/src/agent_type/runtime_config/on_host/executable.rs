@@ -1,17 +1,26 @@
 //! On-host executable definition: binary path, arguments, environment and restart policy.
 use std::collections::HashMap;
+use std::time::Duration;
 
+use duration_str::deserialize_duration;
 use serde::Deserialize;
+use wrapper_with_default::WrapperWithDefault;
 
 use crate::agent_type::{
     definition::Variables,
     error::AgentTypeError,
-    runtime_config::{restart_policy::RestartPolicyConfig, templateable_value::TemplateableValue},
+    runtime_config::{
+        restart_policy::RestartPolicyConfig, scheduling::SchedulingConfig,
+        templateable_value::TemplateableValue,
+    },
     templates::Templateable,
 };
+use crate::utils::platform;
 
 pub mod rendered;
 
+pub(crate) const DEFAULT_SHUTDOWN_TIMEOUT: Duration = Duration::from_secs(10);
+
 #[derive(Debug, Deserialize, Default, Clone, PartialEq)]
 pub(super) struct Executable {
     /// Executable identifier for the health checker.
@@ -20,6 +29,11 @@ pub(super) struct Executable {
     /// Executable binary path. If not an absolute path, the PATH will be searched in an OS-defined way.
     pub(super) path: TemplateableValue<String>, // make it templatable
 
+    /// Executable binary paths by platform (`<os>/<arch>`, like `linux/arm64`), used instead of
+    /// `path` on the matching hosts.
+    #[serde(default)]
+    pub(super) platform_paths: HashMap<String, TemplateableValue<String>>,
+
     /// Arguments passed to the executable.
     #[serde(default)]
     pub(super) args: Args,
@@ -31,18 +45,66 @@ pub(super) struct Executable {
     /// Defines how the executable will be restarted in case of failure.
     #[serde(default)]
     pub(super) restart_policy: RestartPolicyConfig,
+
+    /// Defines how the executable is scheduled relative to other processes in the host.
+    #[serde(default)]
+    pub(super) scheduling: SchedulingConfig,
+
+    /// Listening sockets created by the supervisor and inherited by the process.
+    #[serde(default)]
+    pub(super) listen_sockets: Vec<ListenSocket>,
+
+    /// Time the process has to exit after the termination signal before it is killed.
+    #[serde(default)]
+    pub(super) shutdown_timeout: TemplateableValue<ShutdownTimeout>,
 }
 
 impl Templateable for Executable {
     type Output = rendered::Executable;
 
-    fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+    fn template_with(mut self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+        let path = self
+            .platform_paths
+            .remove(&platform::os_arch())
+            .unwrap_or(self.path);
         Ok(Self::Output {
             id: self.id.template_with(variables)?,
-            path: self.path.template_with(variables)?,
+            path: path.template_with(variables)?,
             args: self.args.template_with(variables)?,
             env: self.env.template_with(variables)?,
             restart_policy: self.restart_policy.template_with(variables)?,
+            scheduling: self.scheduling.template_with(variables)?,
+            listen_sockets: self
+                .listen_sockets
+                .into_iter()
+                .map(|s| s.template_with(variables))
+                .collect::<Result<Vec<_>, _>>()?,
+            shutdown_timeout: self.shutdown_timeout.template_with(variables)?,
+        })
+    }
+}
+
+/// Time the process has to exit after the termination signal before it is killed.
+#[derive(Debug, Deserialize, PartialEq, Clone, Copy, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_SHUTDOWN_TIMEOUT)]
+pub struct ShutdownTimeout(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// Listening socket passed to the executable, systemd socket-activation style.
+#[derive(Debug, Deserialize, Clone, PartialEq)]
+pub struct ListenSocket {
+    /// Name of the socket, exposed to the process in `LISTEN_FDNAMES`.
+    pub(super) name: String,
+    /// Address to listen on: `tcp://<host>:<port>` or `unix://<path>`.
+    pub(super) address: TemplateableValue<String>,
+}
+
+impl Templateable for ListenSocket {
+    type Output = rendered::ListenSocket;
+
+    fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+        Ok(Self::Output {
+            name: self.name.template_with(variables)?,
+            address: self.address.template_with(variables)?,
         })
     }
 }
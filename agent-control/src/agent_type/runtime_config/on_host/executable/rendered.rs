@@ -1,5 +1,7 @@
 //! On-host executable definition after templating.
+use crate::agent_type::runtime_config::on_host::executable::ShutdownTimeout;
 use crate::agent_type::runtime_config::restart_policy::rendered::RestartPolicyConfig;
+use crate::agent_type::runtime_config::scheduling::rendered::SchedulingConfig;
 use serde::Deserialize;
 use std::collections::HashMap;
 
@@ -16,6 +18,21 @@ pub struct Executable {
     pub env: Env,
     /// Defines how the executable will be restarted in case of failure.
     pub restart_policy: RestartPolicyConfig,
+    /// Defines how the executable is scheduled relative to other processes in the host.
+    pub scheduling: SchedulingConfig,
+    /// Listening sockets created by the supervisor and inherited by the process.
+    pub listen_sockets: Vec<ListenSocket>,
+    /// Time the process has to exit after the termination signal before it is killed.
+    pub shutdown_timeout: ShutdownTimeout,
+}
+
+/// Rendered listening socket.
+#[derive(Debug, Default, Clone, PartialEq, Eq, Hash)]
+pub struct ListenSocket {
+    /// Name of the socket, exposed to the process in `LISTEN_FDNAMES`.
+    pub name: String,
+    /// Address to listen on: `tcp://<host>:<port>` or `unix://<path>`.
+    pub address: String,
 }
 
 /// Rendered environment variables.
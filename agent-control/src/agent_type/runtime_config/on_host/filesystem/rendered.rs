@@ -212,6 +212,31 @@ impl FileSystem {
         Ok(())
     }
 
+    /// Returns the content of the files directly inside the directory at `dir`, relative to the
+    /// base dir, keyed by file name. Returns `None` if no such directory is declared.
+    pub fn dir_files(&self, dir: &Path) -> Option<HashMap<&Path, &str>> {
+        match self.entries.get(&self.base_dir.join(dir))? {
+            RenderedEntry::File { .. } => None,
+            RenderedEntry::Dir { children, .. } => Some(
+                children
+                    .iter()
+                    .filter_map(|(path, child)| match child {
+                        RenderedEntry::File { content, .. } => {
+                            Some((path.as_path(), content.as_str()))
+                        }
+                        _ => None,
+                    })
+                    .collect(),
+            ),
+            RenderedEntry::DirContentFromMap { files } => Some(
+                files
+                    .iter()
+                    .map(|(path, content)| (path.as_path(), content.as_str()))
+                    .collect(),
+            ),
+        }
+    }
+
     /// Deletes the on-disk path of every ephemeral entry in the tree.
     /// A persistent entry whose ancestor is ephemeral is wiped along with the ancestor
     pub fn delete_ephemeral(&self) -> Result<(), FileSystemEntriesError> {
@@ -221,6 +246,25 @@ impl FileSystem {
         Ok(())
     }
 
+    /// Directory the entries are rendered under.
+    pub fn base_dir(&self) -> &Path {
+        &self.base_dir
+    }
+
+    /// Returns a copy of the tree rendered under `base_dir` instead, to write the files without
+    /// touching the current ones.
+    pub fn relocated(&self, base_dir: &Path) -> Self {
+        let entries = self
+            .entries
+            .iter()
+            .filter_map(|(path, entry)| {
+                let relative = path.strip_prefix(&self.base_dir).ok()?;
+                Some((base_dir.join(relative), entry.clone()))
+            })
+            .collect();
+        Self::new(base_dir.to_path_buf(), entries)
+    }
+
     fn manifest_path(&self) -> PathBuf {
         self.base_dir.join(MANAGED_PATHS_MANIFEST_FILENAME)
     }
@@ -394,4 +438,28 @@ mod tests {
                 .is_empty()
         );
     }
+
+    #[test]
+    fn relocated_keeps_the_tree_under_the_new_base_dir() {
+        let base_dir = PathBuf::from("/agent/dir");
+        let file = RenderedEntry::File {
+            content: "config".to_string(),
+            persistent: false,
+        };
+        let filesystem = FileSystem::new(
+            base_dir.clone(),
+            HashMap::from([
+                (base_dir.join("otel-config"), file.clone()),
+                (PathBuf::from("/elsewhere"), file.clone()),
+            ]),
+        );
+
+        let relocated = filesystem.relocated(Path::new("/tmp/candidate"));
+
+        assert_eq!(relocated.base_dir(), Path::new("/tmp/candidate"));
+        assert_eq!(
+            relocated.entries,
+            HashMap::from([(PathBuf::from("/tmp/candidate/otel-config"), file)])
+        );
+    }
 }
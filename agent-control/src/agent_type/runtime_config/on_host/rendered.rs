@@ -3,9 +3,13 @@ use crate::agent_type::runtime_config::on_host::package::PackageID;
 use crate::agent_type::runtime_config::on_host::package::rendered::Package;
 use crate::agent_type::runtime_config::{
     health_config::rendered::OnHostHealthConfig,
-    on_host::{executable::rendered::Executable, filesystem::rendered::FileSystem},
+    on_host::{
+        executable::rendered::{Args, Executable},
+        filesystem::rendered::FileSystem,
+    },
 };
 use std::collections::HashMap;
+use std::time::Duration;
 
 /// On-host deployment configuration after templating.
 #[derive(Debug, Clone, PartialEq)]
@@ -20,6 +24,19 @@ pub struct OnHost {
     pub filesystem: FileSystem,
     /// Packages to download for this agent.
     pub packages: RenderedPackages,
+    /// Command validating the configuration before it is applied.
+    pub validation: Option<ConfigValidation>,
+}
+
+/// Validation command after templating.
+#[derive(Debug, Clone, PartialEq)]
+pub struct ConfigValidation {
+    /// Path of the command.
+    pub path: String,
+    /// Arguments passed to the command.
+    pub args: Args,
+    /// Time the command has to finish before the config is rejected.
+    pub timeout: Duration,
 }
 
 /// Rendered packages keyed by their [`PackageID`].
@@ -0,0 +1,46 @@
+//! On-host validation command run on the candidate configuration before it is applied.
+use crate::agent_type::definition::Variables;
+use crate::agent_type::error::AgentTypeError;
+use crate::agent_type::runtime_config::on_host::executable::Args;
+use crate::agent_type::runtime_config::on_host::rendered;
+use crate::agent_type::runtime_config::templateable_value::TemplateableValue;
+use crate::agent_type::templates::Templateable;
+use duration_str::deserialize_duration;
+use serde::Deserialize;
+use std::time::Duration;
+use wrapper_with_default::WrapperWithDefault;
+
+pub(crate) const DEFAULT_VALIDATION_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// Command checking the configuration of the agent, e.g. `otelcol validate --config <file>`.
+///
+/// It runs on a copy of the rendered filesystem, so paths under `${nr-sub:filesystem_agent_dir}`
+/// in its arguments point to the candidate files. A non-zero exit status rejects the config.
+#[derive(Debug, Deserialize, Clone, PartialEq)]
+pub struct ConfigValidation {
+    /// Path of the command.
+    pub(super) path: TemplateableValue<String>,
+    /// Arguments passed to the command.
+    #[serde(default)]
+    pub(super) args: Args,
+    /// Time the command has to finish before the config is rejected.
+    #[serde(default)]
+    pub(super) timeout: ValidationTimeout,
+}
+
+/// Time the validation command has to finish.
+#[derive(Debug, Deserialize, PartialEq, Clone, Copy, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_VALIDATION_TIMEOUT)]
+pub struct ValidationTimeout(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+impl Templateable for ConfigValidation {
+    type Output = rendered::ConfigValidation;
+
+    fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+        Ok(Self::Output {
+            path: self.path.template_with(variables)?,
+            args: self.args.template_with(variables)?,
+            timeout: self.timeout.into(),
+        })
+    }
+}
@@ -18,6 +18,13 @@ pub struct RestartPolicyConfig {
     /// Strategy configuration to retry in case of failure.
     #[serde(default)]
     pub backoff_strategy: BackoffStrategyConfig,
+    /// Time the executable can keep failing before it is disabled instead of restarted.
+    #[serde(default)]
+    pub failure_window: TemplateableValue<FailureWindow>,
+    /// Uptime after which the executable is considered recovered, starting the backoff and the
+    /// failure window from scratch on its next failure.
+    #[serde(default)]
+    pub stable_uptime: TemplateableValue<StableUptime>,
 }
 
 impl Templateable for RestartPolicyConfig {
@@ -26,6 +33,8 @@ impl Templateable for RestartPolicyConfig {
     fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
         Ok(Self::Output {
             backoff_strategy: self.backoff_strategy.template_with(variables)?,
+            failure_window: self.failure_window.template_with(variables)?,
+            stable_uptime: self.stable_uptime.template_with(variables)?,
         })
     }
 }
@@ -37,6 +46,13 @@ TODO: refine values with real executions
 pub(super) const DEFAULT_BACKOFF_DELAY: Duration = Duration::from_secs(2);
 pub(super) const DEFAULT_BACKOFF_MAX_RETRIES: usize = 0;
 pub(super) const DEFAULT_BACKOFF_LAST_RETRY_INTERVAL: Duration = Duration::from_secs(600);
+pub(super) const DEFAULT_BACKOFF_MULTIPLIER: f64 = 2.0;
+/// A zero max delay leaves the delays unbounded.
+pub(super) const DEFAULT_BACKOFF_MAX_DELAY: Duration = Duration::ZERO;
+pub(super) const DEFAULT_BACKOFF_JITTER: f64 = 0.0;
+/// A zero failure window never disables the executable.
+pub(super) const DEFAULT_FAILURE_WINDOW: Duration = Duration::ZERO;
+pub(crate) const DEFAULT_STABLE_UPTIME: Duration = Duration::from_secs(300);
 
 /// The delay applied before retrying a failed execution.
 #[derive(Debug, Deserialize, PartialEq, Clone, WrapperWithDefault)]
@@ -67,6 +83,76 @@ impl BackoffLastRetryInterval {
 #[wrapper_default_value(DEFAULT_BACKOFF_MAX_RETRIES)]
 pub struct MaxRetries(usize);
 
+/// The factor the delay grows by on each retry of the exponential strategy.
+#[derive(Debug, Deserialize, PartialEq, Clone, Copy, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_BACKOFF_MULTIPLIER)]
+pub struct BackoffMultiplier(f64);
+
+impl FromStr for BackoffMultiplier {
+    type Err = AgentTypeError;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s.trim().parse::<f64>() {
+            Ok(multiplier) if multiplier >= 1.0 && multiplier.is_finite() => Ok(Self(multiplier)),
+            _ => Err(AgentTypeError::ValueNotParseableFromString(s.to_string())),
+        }
+    }
+}
+
+/// The upper bound of the delay between retries. Zero leaves it unbounded.
+#[derive(Debug, Deserialize, PartialEq, Clone, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_BACKOFF_MAX_DELAY)]
+pub struct BackoffMaxDelay(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+impl BackoffMaxDelay {
+    /// Builds a max delay of the given number of seconds.
+    pub fn from_secs(value: u64) -> Self {
+        Self(Duration::from_secs(value))
+    }
+}
+
+/// The fraction of each delay, from `0` to `1`, randomly subtracted from it so restarts of many
+/// agents don't happen at the same time.
+#[derive(Debug, Deserialize, PartialEq, Clone, Copy, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_BACKOFF_JITTER)]
+pub struct BackoffJitter(f64);
+
+impl FromStr for BackoffJitter {
+    type Err = AgentTypeError;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s.trim().parse::<f64>() {
+            Ok(jitter) if (0.0..=1.0).contains(&jitter) => Ok(Self(jitter)),
+            _ => Err(AgentTypeError::ValueNotParseableFromString(s.to_string())),
+        }
+    }
+}
+
+/// The time an executable can keep failing, without running long enough to be considered
+/// recovered, before it is disabled. Zero never disables it.
+#[derive(Debug, Deserialize, PartialEq, Clone, Copy, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_FAILURE_WINDOW)]
+pub struct FailureWindow(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+impl FailureWindow {
+    /// Builds a failure window of the given number of seconds.
+    pub fn from_secs(value: u64) -> Self {
+        Self(Duration::from_secs(value))
+    }
+}
+
+/// The uptime after which an executable is considered recovered from its previous failures.
+#[derive(Debug, Deserialize, PartialEq, Clone, Copy, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_STABLE_UPTIME)]
+pub struct StableUptime(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+impl StableUptime {
+    /// Builds a stable uptime of the given number of seconds.
+    pub fn from_secs(value: u64) -> Self {
+        Self(Duration::from_secs(value))
+    }
+}
+
 /// Backoff strategy configuration controlling how failed executions are retried.
 #[derive(Debug, Deserialize, PartialEq, Clone)]
 #[serde(default)]
@@ -80,6 +166,12 @@ pub struct BackoffStrategyConfig {
     pub max_retries: TemplateableValue<MaxRetries>,
     /// The interval after the last retry.
     pub last_retry_interval: TemplateableValue<BackoffLastRetryInterval>,
+    /// The factor the delay grows by on each retry of the exponential strategy.
+    pub multiplier: TemplateableValue<BackoffMultiplier>,
+    /// The upper bound of the delay between retries.
+    pub max_delay: TemplateableValue<BackoffMaxDelay>,
+    /// The fraction of each delay randomly subtracted from it.
+    pub jitter: TemplateableValue<BackoffJitter>,
 }
 
 impl Templateable for BackoffStrategyConfig {
@@ -90,12 +182,18 @@ impl Templateable for BackoffStrategyConfig {
         let backoff_delay = self.backoff_delay.template_with(variables)?;
         let max_retries = self.max_retries.template_with(variables)?;
         let last_retry_interval = self.last_retry_interval.template_with(variables)?;
+        let multiplier = self.multiplier.template_with(variables)?;
+        let max_delay = self.max_delay.template_with(variables)?;
+        let jitter = self.jitter.template_with(variables)?;
 
         let result = Self::Output {
             backoff_type,
             backoff_delay,
             max_retries,
             last_retry_interval,
+            multiplier,
+            max_delay,
+            jitter,
         };
         Ok(result)
     }
@@ -134,6 +232,9 @@ impl Default for BackoffStrategyConfig {
             backoff_delay: TemplateableValue::new(DEFAULT_BACKOFF_DELAY.into()),
             max_retries: TemplateableValue::new(DEFAULT_BACKOFF_MAX_RETRIES.into()),
             last_retry_interval: TemplateableValue::new(DEFAULT_BACKOFF_LAST_RETRY_INTERVAL.into()),
+            multiplier: TemplateableValue::new(DEFAULT_BACKOFF_MULTIPLIER.into()),
+            max_delay: TemplateableValue::new(DEFAULT_BACKOFF_MAX_DELAY.into()),
+            jitter: TemplateableValue::new(DEFAULT_BACKOFF_JITTER.into()),
         }
     }
 }
@@ -1,6 +1,7 @@
 //! Restart policy configuration after templating.
 use crate::agent_type::runtime_config::restart_policy::{
-    BackoffDelay, BackoffLastRetryInterval, BackoffStrategyType, MaxRetries,
+    BackoffDelay, BackoffJitter, BackoffLastRetryInterval, BackoffMaxDelay, BackoffMultiplier,
+    BackoffStrategyType, FailureWindow, MaxRetries, StableUptime,
 };
 
 /// Rendered restart policy configuration.
@@ -8,6 +9,10 @@ use crate::agent_type::runtime_config::restart_policy::{
 pub struct RestartPolicyConfig {
     /// Strategy configuration to retry in case of failure.
     pub backoff_strategy: BackoffStrategyConfig,
+    /// Time the executable can keep failing before it is disabled instead of restarted.
+    pub failure_window: FailureWindow,
+    /// Uptime after which the executable is considered recovered from its previous failures.
+    pub stable_uptime: StableUptime,
 }
 
 /// Rendered backoff strategy configuration.
@@ -21,4 +26,10 @@ pub struct BackoffStrategyConfig {
     pub max_retries: MaxRetries,
     /// The interval after the last retry.
     pub last_retry_interval: BackoffLastRetryInterval,
+    /// The factor the delay grows by on each retry of the exponential strategy.
+    pub multiplier: BackoffMultiplier,
+    /// The upper bound of the delay between retries.
+    pub max_delay: BackoffMaxDelay,
+    /// The fraction of each delay randomly subtracted from it.
+    pub jitter: BackoffJitter,
 }
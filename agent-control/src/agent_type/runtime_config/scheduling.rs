@@ -0,0 +1,184 @@
+//! Scheduling configuration (nice level, I/O priority and CPU affinity) for on-host executables.
+use crate::agent_type::definition::Variables;
+use crate::agent_type::error::AgentTypeError;
+use crate::agent_type::templates::Templateable;
+use serde::Deserialize;
+use std::str::FromStr;
+
+use super::templateable_value::TemplateableValue;
+
+pub mod rendered;
+
+/// Highest priority nice level.
+const MIN_NICE_LEVEL: i32 = -20;
+/// Lowest priority nice level.
+const MAX_NICE_LEVEL: i32 = 19;
+/// Highest priority level within the realtime and best-effort I/O classes.
+const MAX_IO_PRIORITY_LEVEL: u8 = 7;
+
+/// Defines how the executable is scheduled relative to other processes in the host, so heavy
+/// agents can be deprioritized on shared hosts. Every setting is inherited from Agent Control
+/// when omitted.
+#[derive(Debug, Deserialize, PartialEq, Clone, Default)]
+pub struct SchedulingConfig {
+    /// Nice level, from `-20` (highest priority) to `19` (lowest priority).
+    #[serde(default)]
+    pub nice: TemplateableValue<NiceLevel>,
+    /// I/O scheduling class and level: `idle`, `best-effort[:<level>]` or `realtime[:<level>]`.
+    #[serde(default)]
+    pub io_priority: TemplateableValue<IoPriority>,
+    /// CPUs the executable may run on, in `taskset` list format (e.g. `0,2-3`).
+    #[serde(default)]
+    pub cpu_affinity: TemplateableValue<CpuAffinity>,
+}
+
+impl Templateable for SchedulingConfig {
+    type Output = rendered::SchedulingConfig;
+
+    fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+        Ok(Self::Output {
+            nice: self.nice.template_with(variables)?,
+            io_priority: self.io_priority.template_with(variables)?,
+            cpu_affinity: self.cpu_affinity.template_with(variables)?,
+        })
+    }
+}
+
+/// Nice level of the executable. `None` keeps the one of Agent Control.
+#[derive(Debug, PartialEq, Clone, Copy, Default)]
+pub struct NiceLevel(pub Option<i32>);
+
+impl FromStr for NiceLevel {
+    type Err = AgentTypeError;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s.trim().parse::<i32>() {
+            Ok(level) if (MIN_NICE_LEVEL..=MAX_NICE_LEVEL).contains(&level) => {
+                Ok(Self(Some(level)))
+            }
+            _ => Err(AgentTypeError::ValueNotParseableFromString(s.to_string())),
+        }
+    }
+}
+
+/// I/O scheduling class of the executable, along with its level within the class where it
+/// applies. Only supported on Linux.
+#[derive(Debug, PartialEq, Clone, Copy, Default)]
+pub enum IoPriority {
+    /// Keeps the I/O priority of Agent Control.
+    #[default]
+    Inherit,
+    /// Only gets disk time when no other process needs it.
+    Idle,
+    /// The default class, with a level from `0` (highest priority) to `7`.
+    BestEffort(u8),
+    /// Always gets first access to the disk, with a level from `0` (highest priority) to `7`.
+    Realtime(u8),
+}
+
+impl FromStr for IoPriority {
+    type Err = AgentTypeError;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        let err = || AgentTypeError::ValueNotParseableFromString(s.to_string());
+        let (class, level) = match s.trim().split_once(':') {
+            Some((class, level)) => (class, Some(level.parse::<u8>().map_err(|_| err())?)),
+            None => (s.trim(), None),
+        };
+        if level.is_some_and(|level| level > MAX_IO_PRIORITY_LEVEL) {
+            return Err(err());
+        }
+        // Level 4 is the kernel default within a class.
+        match (class, level) {
+            ("idle", None) => Ok(Self::Idle),
+            ("best-effort", level) => Ok(Self::BestEffort(level.unwrap_or(4))),
+            ("realtime", level) => Ok(Self::Realtime(level.unwrap_or(4))),
+            _ => Err(err()),
+        }
+    }
+}
+
+/// CPUs the executable may run on. Empty keeps the affinity of Agent Control. Only supported
+/// on Linux.
+#[derive(Debug, PartialEq, Clone, Default)]
+pub struct CpuAffinity(pub Vec<usize>);
+
+impl FromStr for CpuAffinity {
+    type Err = AgentTypeError;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        let err = || AgentTypeError::ValueNotParseableFromString(s.to_string());
+        let mut cpus = Vec::new();
+        for range in s.split(',').map(str::trim) {
+            match range.split_once('-') {
+                Some((first, last)) => {
+                    let first = first.trim().parse::<usize>().map_err(|_| err())?;
+                    let last = last.trim().parse::<usize>().map_err(|_| err())?;
+                    if first > last {
+                        return Err(err());
+                    }
+                    cpus.extend(first..=last);
+                }
+                None => cpus.push(range.parse().map_err(|_| err())?),
+            }
+        }
+        cpus.sort_unstable();
+        cpus.dedup();
+        Ok(Self(cpus))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_type::variable::Variable;
+    use rstest::rstest;
+
+    #[rstest]
+    #[case::nice("10", Some(NiceLevel(Some(10))))]
+    #[case::highest_nice("-20", Some(NiceLevel(Some(-20))))]
+    #[case::out_of_range_nice("20", None)]
+    #[case::not_a_number("low", None)]
+    fn test_nice_level(#[case] input: &str, #[case] expected: Option<NiceLevel>) {
+        assert_eq!(input.parse::<NiceLevel>().ok(), expected);
+    }
+
+    #[rstest]
+    #[case::idle("idle", Some(IoPriority::Idle))]
+    #[case::best_effort_default_level("best-effort", Some(IoPriority::BestEffort(4)))]
+    #[case::best_effort("best-effort:7", Some(IoPriority::BestEffort(7)))]
+    #[case::realtime("realtime:0", Some(IoPriority::Realtime(0)))]
+    #[case::idle_with_level("idle:3", None)]
+    #[case::out_of_range_level("best-effort:8", None)]
+    #[case::unknown_class("urgent", None)]
+    fn test_io_priority(#[case] input: &str, #[case] expected: Option<IoPriority>) {
+        assert_eq!(input.parse::<IoPriority>().ok(), expected);
+    }
+
+    #[rstest]
+    #[case::single("3", Some(vec![3]))]
+    #[case::list_and_ranges("4, 0-2,1", Some(vec![0, 1, 2, 4]))]
+    #[case::reversed_range("3-1", None)]
+    #[case::empty_item("1,,2", None)]
+    fn test_cpu_affinity(#[case] input: &str, #[case] expected: Option<Vec<usize>>) {
+        assert_eq!(input.parse::<CpuAffinity>().ok().map(|a| a.0), expected);
+    }
+
+    #[test]
+    fn test_omitted_settings_are_inherited() {
+        let config: SchedulingConfig = serde_saphyr::from_str("nice: ${nr-var:nice}").unwrap();
+        let variables = Variables::from([(
+            "nr-var:nice".to_string(),
+            Variable::new_final_string_variable("5"),
+        )]);
+
+        assert_eq!(
+            config.template_with(&variables).unwrap(),
+            rendered::SchedulingConfig {
+                nice: NiceLevel(Some(5)),
+                io_priority: IoPriority::Inherit,
+                cpu_affinity: CpuAffinity::default(),
+            }
+        );
+    }
+}
@@ -0,0 +1,20 @@
+//! Scheduling configuration after templating.
+use crate::agent_type::runtime_config::scheduling::{CpuAffinity, IoPriority, NiceLevel};
+
+/// Rendered scheduling configuration.
+#[derive(Debug, PartialEq, Clone, Default)]
+pub struct SchedulingConfig {
+    /// Nice level of the executable.
+    pub nice: NiceLevel,
+    /// I/O scheduling class and level of the executable.
+    pub io_priority: IoPriority,
+    /// CPUs the executable may run on.
+    pub cpu_affinity: CpuAffinity,
+}
+
+impl SchedulingConfig {
+    /// Returns whether every setting is inherited from Agent Control.
+    pub fn is_inherited(&self) -> bool {
+        self == &Self::default()
+    }
+}
@@ -1,6 +1,10 @@
 //! A value that may be provided as a template string and resolved to its typed value during
 //! rendering.
-use super::restart_policy::{BackoffDelay, BackoffLastRetryInterval, MaxRetries};
+use super::on_host::executable::ShutdownTimeout;
+use super::restart_policy::{
+    BackoffDelay, BackoffLastRetryInterval, BackoffMaxDelay, FailureWindow, MaxRetries,
+    StableUptime,
+};
 use crate::agent_type::definition::Variables;
 use crate::agent_type::error::AgentTypeError;
 use crate::agent_type::templates::Templateable;
@@ -127,6 +131,70 @@ impl Templateable for TemplateableValue<BackoffLastRetryInterval> {
     }
 }
 
+impl Templateable for TemplateableValue<BackoffMaxDelay> {
+    type Output = BackoffMaxDelay;
+
+    fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+        let templated_string = self.template.template_with(variables)?;
+        let value = if templated_string.is_empty() {
+            BackoffMaxDelay::default()
+        } else {
+            duration_str::parse(&templated_string)
+                .map(BackoffMaxDelay::from)
+                .map_err(|_| AgentTypeError::ValueNotParseableFromString(templated_string))?
+        };
+        Ok(value)
+    }
+}
+
+impl Templateable for TemplateableValue<FailureWindow> {
+    type Output = FailureWindow;
+
+    fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+        let templated_string = self.template.template_with(variables)?;
+        let value = if templated_string.is_empty() {
+            FailureWindow::default()
+        } else {
+            duration_str::parse(&templated_string)
+                .map(FailureWindow::from)
+                .map_err(|_| AgentTypeError::ValueNotParseableFromString(templated_string))?
+        };
+        Ok(value)
+    }
+}
+
+impl Templateable for TemplateableValue<StableUptime> {
+    type Output = StableUptime;
+
+    fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+        let templated_string = self.template.template_with(variables)?;
+        let value = if templated_string.is_empty() {
+            StableUptime::default()
+        } else {
+            duration_str::parse(&templated_string)
+                .map(StableUptime::from)
+                .map_err(|_| AgentTypeError::ValueNotParseableFromString(templated_string))?
+        };
+        Ok(value)
+    }
+}
+
+impl Templateable for TemplateableValue<ShutdownTimeout> {
+    type Output = ShutdownTimeout;
+
+    fn template_with(self, variables: &Variables) -> Result<Self::Output, AgentTypeError> {
+        let templated_string = self.template.template_with(variables)?;
+        let value = if templated_string.is_empty() {
+            ShutdownTimeout::default()
+        } else {
+            duration_str::parse(&templated_string)
+                .map(ShutdownTimeout::from)
+                .map_err(|_| AgentTypeError::ValueNotParseableFromString(templated_string))?
+        };
+        Ok(value)
+    }
+}
+
 impl Templateable for TemplateableValue<MaxRetries> {
     type Output = MaxRetries;
 
@@ -1,6 +1,6 @@
 //! A single configuration value as resolved from an agent type variable's spec.
 use std::{
-    collections::HashMap as Map,
+    collections::{BTreeMap, HashMap as Map},
     fmt::{Display, Formatter},
 };
 
@@ -52,17 +52,41 @@ impl Display for TrivialValue {
             TrivialValue::MapStringString(n) => {
                 let flatten: Vec<String> = n
                     .iter()
+                    .collect::<BTreeMap<_, _>>()
+                    .into_iter()
                     // FIXME is this what we really want? key=value?
-                    .map(|(key, value)| format!("{key}={value}")) 
+                    .map(|(key, value)| format!("{key}={value}"))
                     .collect();
                 write!(f, "{}", flatten.join(" "))
             }
             TrivialValue::MapStringYaml(n) => write!(
                 f,
                 "{}",
-                serde_saphyr::to_string(n)
+                serde_saphyr::to_string(&n.iter().collect::<BTreeMap<_, _>>())
                     .expect("A value of type HashMap<String, serde_json::Value> should always be serializable")
             )
         }
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn test_maps_are_displayed_sorted() {
+        let map_string_string = TrivialValue::MapStringString(Map::from([
+            ("c".to_string(), "3".to_string()),
+            ("a".to_string(), "1".to_string()),
+            ("b".to_string(), "2".to_string()),
+        ]));
+        assert_eq!(map_string_string.to_string(), "a=1 b=2 c=3");
+
+        let map_string_yaml = TrivialValue::MapStringYaml(Map::from([
+            ("c".to_string(), json!({"z": 1, "y": 2})),
+            ("a".to_string(), json!(true)),
+        ]));
+        assert_eq!(map_string_yaml.to_string(), "a: true\nc:\n  y: 2\n  z: 1\n");
+    }
+}
@@ -21,6 +21,8 @@ pub enum Namespace {
     EnvironmentVariable,
     /// Secrets retrieved from a HashiCorp Vault.
     Vault,
+    /// Secrets retrieved from AWS Secrets Manager.
+    AwsSecretsManager,
     /// Secrets retrieved from a file.
     File,
     /// Secrets retrieved from Kubernetes Secrets.
@@ -43,6 +45,8 @@ impl Namespace {
     const ENVIRONMENT_VARIABLE: &'static str = "env";
     /// Encapsulates the secrets retrieved from a HashiCorp Vault
     const VAULT_SECRET: &'static str = "vault";
+    /// Encapsulates the secrets retrieved from AWS Secrets Manager
+    const AWS_SECRETS_MANAGER_SECRET: &'static str = "awssm";
     /// Encapsulates the secrets retrieved from K8s Secrets
     const K8S_SECRET: &'static str = "kubesec";
     const FILE_SECRET: &'static str = "file";
@@ -56,6 +60,7 @@ impl Namespace {
     pub fn is_secret_variable(s: &str) -> bool {
         [
             Namespace::Vault,
+            Namespace::AwsSecretsManager,
             Namespace::K8sSecret,
             Namespace::File,
             Namespace::EnvironmentVariable,
@@ -73,6 +78,7 @@ impl Display for Namespace {
             Self::AgentControl => Self::AC,
             Self::EnvironmentVariable => Self::ENVIRONMENT_VARIABLE,
             Self::Vault => Self::VAULT_SECRET,
+            Self::AwsSecretsManager => Self::AWS_SECRETS_MANAGER_SECRET,
             Self::File => Self::FILE_SECRET,
             Self::K8sSecret => Self::K8S_SECRET,
         };
@@ -105,6 +111,10 @@ mod tests {
             "nr-vault:test".to_string(),
             Namespace::Vault.namespaced_name("test")
         );
+        assert_eq!(
+            "nr-awssm:test".to_string(),
+            Namespace::AwsSecretsManager.namespaced_name("test")
+        );
         assert_eq!(
             "nr-kubesec:test".to_string(),
             Namespace::K8sSecret.namespaced_name("test")
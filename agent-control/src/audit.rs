@@ -0,0 +1,262 @@
+//! Audit trail of security-relevant actions (binary replacements, applied configurations,
+//! self-update rollbacks, executed commands and changes to the supervised processes made outside
+//! Agent Control).
+//!
+//! Every action is logged and, when [AuditConfig::enabled] is set, also reported to the audit
+//! facility of the host, so security teams can track it with their host-native tooling:
+//! - Linux: the kernel audit subsystem (collected by `auditd`).
+//! - Windows: the Application Event Log.
+//!
+//! Other platforms only log the actions. The actions are recorded through an [AuditTrail], handed
+//! to the components performing them.
+
+#[cfg(target_os = "linux")]
+mod linux;
+#[cfg(target_os = "windows")]
+mod windows;
+
+use serde::Deserialize;
+use std::fmt::{self, Display, Formatter};
+use std::io;
+use std::sync::Arc;
+use tracing::{info, warn};
+
+/// Target of the log records emitted for audited actions.
+pub const AUDIT_LOG_TARGET: &str = "audit";
+
+/// Configuration of the audit trail.
+#[derive(Debug, Default, Deserialize, PartialEq, Clone)]
+#[serde(default)]
+pub struct AuditConfig {
+    /// Report the audited actions to the audit facility of the host besides logging them.
+    /// Requires the privileges to write to it (e.g. `CAP_AUDIT_WRITE` on Linux).
+    pub enabled: bool,
+}
+
+/// Security-relevant action performed by Agent Control.
+#[derive(Debug, Clone, PartialEq)]
+pub enum AuditEvent {
+    /// The Agent Control binary was replaced by a self-update.
+    BinaryReplaced {
+        /// Version running when the binary was replaced.
+        from_version: String,
+        /// Version of the new binary.
+        to_version: String,
+    },
+    /// A remote configuration was applied.
+    ConfigApplied {
+        /// Agent the configuration was applied to.
+        agent_id: String,
+        /// Hash of the applied configuration.
+        hash: String,
+    },
+    /// An interrupted self-update was rolled back to the running version.
+    SelfUpdateRolledBack {
+        /// Version running when the self-update started.
+        from_version: String,
+        /// Version the self-update was moving to.
+        to_version: String,
+    },
+    /// A command was executed on behalf of an agent or the operator, like the post-download hook
+    /// of a package or a lifecycle hook.
+    CommandExecuted {
+        /// Path of the executed command.
+        path: String,
+        /// Arguments of the executed command.
+        args: Vec<String>,
+    },
+    /// A supervised process was killed by a signal not sent by Agent Control.
+    ProcessKilledExternally {
+        /// Agent the process belongs to.
+        agent_id: String,
+        /// Path of the process executable.
+        executable: String,
+        /// Id of the killed process.
+        pid: u32,
+        /// Signal that killed the process.
+        signal: String,
+    },
+    /// A process running a supervised executable, started outside Agent Control, was terminated
+    /// so the supervised one is the only instance.
+    UnsupervisedProcessTerminated {
+        /// Agent the executable belongs to.
+        agent_id: String,
+        /// Path of the process executable.
+        executable: String,
+        /// Id of the terminated process.
+        pid: u32,
+    },
+}
+
+impl AuditEvent {
+    /// Name identifying the kind of action.
+    pub fn operation(&self) -> &'static str {
+        match self {
+            Self::BinaryReplaced { .. } => "binary-replaced",
+            Self::ConfigApplied { .. } => "config-applied",
+            Self::SelfUpdateRolledBack { .. } => "self-update-rolled-back",
+            Self::CommandExecuted { .. } => "command-executed",
+            Self::ProcessKilledExternally { .. } => "process-killed-externally",
+            Self::UnsupervisedProcessTerminated { .. } => "unsupervised-process-terminated",
+        }
+    }
+}
+
+/// Formats the event as `key=value` fields, as expected by the audit facilities.
+impl Display for AuditEvent {
+    fn fmt(&self, f: &mut Formatter<'_>) -> fmt::Result {
+        write!(f, "op={}", self.operation())?;
+        match self {
+            Self::BinaryReplaced {
+                from_version,
+                to_version,
+            }
+            | Self::SelfUpdateRolledBack {
+                from_version,
+                to_version,
+            } => write!(
+                f,
+                " from_version={from_version:?} to_version={to_version:?}"
+            ),
+            Self::ConfigApplied { agent_id, hash } => {
+                write!(f, " agent_id={agent_id:?} hash={hash:?}")
+            }
+            Self::CommandExecuted { path, args } => {
+                write!(f, " path={path:?} args={:?}", args.join(" "))
+            }
+            Self::ProcessKilledExternally {
+                agent_id,
+                executable,
+                pid,
+                signal,
+            } => write!(
+                f,
+                " agent_id={agent_id:?} executable={executable:?} pid={pid} signal={signal}"
+            ),
+            Self::UnsupervisedProcessTerminated {
+                agent_id,
+                executable,
+                pid,
+            } => write!(
+                f,
+                " agent_id={agent_id:?} executable={executable:?} pid={pid}"
+            ),
+        }
+    }
+}
+
+/// Destination of the audited actions.
+trait AuditSink: Send + Sync {
+    fn write(&self, event: &AuditEvent) -> io::Result<()>;
+}
+
+/// Records the security-relevant actions, cheap to clone and shared by every component performing
+/// them. The default only logs the actions.
+#[derive(Clone, Default)]
+pub struct AuditTrail {
+    sink: Option<Arc<dyn AuditSink>>,
+}
+
+impl fmt::Debug for AuditTrail {
+    fn fmt(&self, f: &mut Formatter<'_>) -> fmt::Result {
+        f.debug_struct("AuditTrail")
+            .field("host_facility", &self.sink.is_some())
+            .finish()
+    }
+}
+
+impl AuditTrail {
+    /// Creates the audit trail, also reporting to the audit facility of the host if enabled.
+    pub fn new(config: &AuditConfig) -> Self {
+        if !config.enabled {
+            return Self::default();
+        }
+
+        #[cfg(target_os = "linux")]
+        let sink: Option<Arc<dyn AuditSink>> = Some(Arc::new(linux::KernelAudit));
+        #[cfg(target_os = "windows")]
+        let sink: Option<Arc<dyn AuditSink>> = Some(Arc::new(windows::EventLog));
+        #[cfg(not(any(target_os = "linux", target_os = "windows")))]
+        let sink: Option<Arc<dyn AuditSink>> = None;
+
+        if sink.is_none() {
+            warn!("The host audit facility is not supported on this platform");
+        }
+        Self { sink }
+    }
+
+    /// Records a security-relevant action. Failures writing to the audit facility are logged and
+    /// don't interrupt the action.
+    pub fn record(&self, event: AuditEvent) {
+        info!(target: AUDIT_LOG_TARGET, operation = event.operation(), "{event}");
+        if let Some(sink) = &self.sink {
+            let _ = sink
+                .write(&event)
+                .inspect_err(|err| warn!("Could not write to the host audit facility: {err}"));
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+    use std::sync::Mutex;
+
+    #[derive(Default)]
+    struct RecordedEvents(Mutex<Vec<AuditEvent>>);
+
+    impl AuditSink for RecordedEvents {
+        fn write(&self, event: &AuditEvent) -> io::Result<()> {
+            self.0.lock().unwrap().push(event.clone());
+            Ok(())
+        }
+    }
+
+    #[test]
+    fn test_record_writes_to_the_sink() {
+        let sink = Arc::new(RecordedEvents::default());
+        let audit_trail = AuditTrail {
+            sink: Some(sink.clone()),
+        };
+        let event = AuditEvent::ConfigApplied {
+            agent_id: "infra".into(),
+            hash: "abc".into(),
+        };
+
+        audit_trail.clone().record(event.clone());
+        // Without the host facility the action is only logged.
+        AuditTrail::new(&AuditConfig::default()).record(event.clone());
+
+        assert_eq!(*sink.0.lock().unwrap(), vec![event]);
+    }
+
+    #[rstest]
+    #[case::binary_replaced(
+        AuditEvent::BinaryReplaced { from_version: "1.0.0".into(), to_version: "1.1.0".into() },
+        r#"op=binary-replaced from_version="1.0.0" to_version="1.1.0""#
+    )]
+    #[case::config_applied(
+        AuditEvent::ConfigApplied { agent_id: "infra".into(), hash: "abc".into() },
+        r#"op=config-applied agent_id="infra" hash="abc""#
+    )]
+    #[case::rolled_back(
+        AuditEvent::SelfUpdateRolledBack { from_version: "1.0.0".into(), to_version: "1.1.0".into() },
+        r#"op=self-update-rolled-back from_version="1.0.0" to_version="1.1.0""#
+    )]
+    #[case::command_executed(
+        AuditEvent::CommandExecuted { path: "/bin/sh".into(), args: vec!["-c".into(), "echo \"hi\"".into()] },
+        r#"op=command-executed path="/bin/sh" args="-c echo \"hi\"""#
+    )]
+    #[case::process_killed_externally(
+        AuditEvent::ProcessKilledExternally { agent_id: "infra".into(), executable: "/usr/bin/newrelic-infra".into(), pid: 42, signal: "SIGKILL".into() },
+        r#"op=process-killed-externally agent_id="infra" executable="/usr/bin/newrelic-infra" pid=42 signal=SIGKILL"#
+    )]
+    #[case::unsupervised_process_terminated(
+        AuditEvent::UnsupervisedProcessTerminated { agent_id: "infra".into(), executable: "/usr/bin/newrelic-infra".into(), pid: 43 },
+        r#"op=unsupervised-process-terminated agent_id="infra" executable="/usr/bin/newrelic-infra" pid=43"#
+    )]
+    fn test_event_display(#[case] event: AuditEvent, #[case] expected: &str) {
+        assert_eq!(event.to_string(), expected);
+    }
+}
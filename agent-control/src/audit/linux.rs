@@ -0,0 +1,79 @@
+//! Reports audited actions to the Linux kernel audit subsystem through a netlink socket, the same
+//! way `libaudit` does for user space messages.
+
+use super::{AuditEvent, AuditSink};
+use nix::sys::socket::{
+    AddressFamily, MsgFlags, NetlinkAddr, SockFlag, SockProtocol, SockType, sendto, socket,
+};
+use std::io;
+use std::os::fd::AsRawFd;
+
+/// `AUDIT_TRUSTED_APP` message type, used by trusted applications to report their own actions.
+const AUDIT_TRUSTED_APP: u16 = 1121;
+/// `NLM_F_REQUEST` netlink flag.
+const NLM_F_REQUEST: u16 = 0x1;
+/// Size of the netlink message header (`struct nlmsghdr`).
+const NLMSG_HDRLEN: usize = 16;
+
+/// Writes audited actions to the kernel audit subsystem.
+pub(super) struct KernelAudit;
+
+impl AuditSink for KernelAudit {
+    fn write(&self, event: &AuditEvent) -> io::Result<()> {
+        let fd = socket(
+            AddressFamily::Netlink,
+            SockType::Raw,
+            SockFlag::SOCK_CLOEXEC,
+            SockProtocol::NetlinkAudit,
+        )?;
+        // The kernel is addressed with port id 0.
+        let kernel = NetlinkAddr::new(0, 0);
+        sendto(
+            fd.as_raw_fd(),
+            &netlink_message(&event.to_string()),
+            &kernel,
+            MsgFlags::empty(),
+        )?;
+        Ok(())
+    }
+}
+
+/// Builds a netlink message holding `text` as a null-terminated user space audit record.
+fn netlink_message(text: &str) -> Vec<u8> {
+    let payload_len = text.len() + 1;
+    // Messages are aligned to 4 bytes.
+    let len = (NLMSG_HDRLEN + payload_len).next_multiple_of(4);
+    let mut message = Vec::with_capacity(len);
+    message.extend_from_slice(&(len as u32).to_ne_bytes());
+    message.extend_from_slice(&AUDIT_TRUSTED_APP.to_ne_bytes());
+    message.extend_from_slice(&NLM_F_REQUEST.to_ne_bytes());
+    // Sequence number and port id, filled by the kernel.
+    message.extend_from_slice(&0u32.to_ne_bytes());
+    message.extend_from_slice(&0u32.to_ne_bytes());
+    message.extend_from_slice(text.as_bytes());
+    message.resize(len, 0);
+    message
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_netlink_message() {
+        let message = netlink_message("op=test");
+
+        assert_eq!(message.len(), 24);
+        assert_eq!(u32::from_ne_bytes(message[0..4].try_into().unwrap()), 24);
+        assert_eq!(
+            u16::from_ne_bytes(message[4..6].try_into().unwrap()),
+            AUDIT_TRUSTED_APP
+        );
+        assert_eq!(
+            u16::from_ne_bytes(message[6..8].try_into().unwrap()),
+            NLM_F_REQUEST
+        );
+        assert_eq!(&message[16..23], b"op=test");
+        assert_eq!(message[23], 0);
+    }
+}
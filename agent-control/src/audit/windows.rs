@@ -0,0 +1,38 @@
+//! Reports audited actions to the Windows Application Event Log.
+
+use super::{AuditEvent, AuditSink};
+use crate::command::windows::WINDOWS_SERVICE_NAME;
+use std::io;
+use windows::Win32::System::EventLog::{
+    DeregisterEventSource, EVENTLOG_INFORMATION_TYPE, RegisterEventSourceW, ReportEventW,
+};
+use windows::core::{HSTRING, PCWSTR};
+
+/// Identifier of the events reported for audited actions.
+const AUDIT_EVENT_ID: u32 = 1000;
+
+/// Writes audited actions to the Application Event Log, using the service name as source.
+pub(super) struct EventLog;
+
+impl AuditSink for EventLog {
+    fn write(&self, event: &AuditEvent) -> io::Result<()> {
+        let source = HSTRING::from(WINDOWS_SERVICE_NAME);
+        let message = HSTRING::from(event.to_string());
+        // SAFETY: the strings outlive the calls and the handle is deregistered once used.
+        unsafe {
+            let handle = RegisterEventSourceW(None, &source)?;
+            let result = ReportEventW(
+                handle,
+                EVENTLOG_INFORMATION_TYPE,
+                0,
+                AUDIT_EVENT_ID,
+                None,
+                0,
+                Some(&[PCWSTR(message.as_ptr())]),
+                None,
+            );
+            let _ = DeregisterEventSource(handle);
+            result.map_err(io::Error::from)
+        }
+    }
+}
@@ -1,7 +1,11 @@
 use std::process::ExitCode;
 
 use clap::{CommandFactory, Parser, error::ErrorKind};
-use newrelic_agent_control::cli::on_host::migrate_folders;
+#[cfg(target_family = "unix")]
+use newrelic_agent_control::cli::on_host::apply;
+use newrelic_agent_control::cli::on_host::{
+    import_opamp_supervisor, migrate_folders, purge, regenerate_instance_id,
+};
 use newrelic_agent_control::cli::{common::logs, on_host::config_gen};
 use tracing::{Level, error};
 
@@ -24,6 +28,20 @@ enum Commands {
     GenerateConfig(config_gen::Args),
     /// Migrates legacy on-host directories (>v1.4.0) to the new layout. Intended to be run by post-installation package scripts only.
     FilesBackwardsCompatibilityMigrationFromV120,
+    /// Applies a local configuration set, or a signed offline bundle, to the running Agent Control
+    /// through its control socket. Exits successfully only once the configuration has been
+    /// applied, so it can be run repeatedly by configuration management tools.
+    #[cfg(target_family = "unix")]
+    Apply(apply::Args),
+    /// Stops the agents and removes the configuration, packages, state and logs managed by
+    /// Agent Control. Intended to be run when uninstalling it.
+    Purge(purge::Args),
+    /// Imports the instance uid and the effective configuration of an OpenTelemetry
+    /// opamp-supervisor as an agent, easing the migration of its collector to Agent Control.
+    ImportOpampSupervisor(import_opamp_supervisor::Args),
+    /// Replaces the persisted instance id of Agent Control or one of its agents, so it is reported
+    /// to Fleet Control as a new instance. Takes effect once Agent Control is restarted.
+    RegenerateInstanceId(regenerate_instance_id::Args),
 }
 
 fn main() -> ExitCode {
@@ -45,6 +63,11 @@ fn main() -> ExitCode {
             }
         },
         Commands::FilesBackwardsCompatibilityMigrationFromV120 => migrate_folders::migrate(),
+        #[cfg(target_family = "unix")]
+        Commands::Apply(args) => apply::apply(args),
+        Commands::Purge(args) => purge::purge(args),
+        Commands::ImportOpampSupervisor(args) => import_opamp_supervisor::import(args),
+        Commands::RegenerateInstanceId(args) => regenerate_instance_id::regenerate(args),
     };
 
     if let Err(err) = result {
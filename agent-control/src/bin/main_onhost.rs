@@ -2,8 +2,9 @@
 //!
 //! It implements the basic functionality of parsing the command line arguments and either
 //! performing one-shot actions or starting the main agent control process.
-use newrelic_agent_control::agent_control::run::AgentControlRunner;
 use newrelic_agent_control::agent_control::run::on_host::AGENT_CONTROL_MODE_ON_HOST;
+use newrelic_agent_control::agent_control::run::{AgentControlRunner, GracefulShutdownReason};
+use newrelic_agent_control::command::exit_status::{ExitError, ExitStatus};
 use newrelic_agent_control::command::{Command, Context};
 use newrelic_agent_control::utils::is_elevated::is_elevated;
 use std::error::Error;
@@ -67,7 +68,11 @@ fn main() -> ExitCode {
 fn _main(context: Context) -> Result<(), Box<dyn Error>> {
     #[cfg(not(feature = "disable-asroot"))]
     if !is_elevated()? {
-        return Err("Program must run with elevated permissions".into());
+        return Err(ExitError::new(
+            ExitStatus::PermissionDenied,
+            "Program must run with elevated permissions",
+        )
+        .into());
     }
 
     #[cfg(all(target_family = "unix", not(feature = "multiple-instances")))]
@@ -94,7 +99,12 @@ fn _main(context: Context) -> Result<(), Box<dyn Error>> {
         }
     }
 
-    // Shutdown reason is not used passed to this point.
-    run_result?;
-    Ok(())
+    match run_result? {
+        GracefulShutdownReason::SubAgentCrashLoop(agent_id) => Err(ExitError::new(
+            ExitStatus::SubAgentCrashLoop,
+            format!("an executable of the agent '{agent_id}' exhausted its restart policy"),
+        )
+        .into()),
+        _ => Ok(()),
+    }
 }
@@ -1,5 +1,6 @@
 //! Kubernetes GUID checker built from a set of resources, plus the thread that runs it.
 use crate::agent_control::config::instrumentation_v1beta3_type_meta;
+use crate::agent_control::defaults::ENTITY_GUID_ATTRIBUTE_KEY;
 use crate::agent_type::guid_config::{GuidCheckerInitialDelay, GuidCheckerInterval};
 use crate::checkers::guid::k8s::resources::instrumentation::K8sGuidInstrumentation;
 use crate::checkers::guid::{EntityGuid, GuidCheckError, GuidChecker};
@@ -109,7 +110,12 @@ where
                                 current_guid.opamp_field.clone(),
                                 current_guid.guid.clone().into(),
                             )]),
-                            ..Default::default()
+                            // Reported for entity synthesis too, so the agent and its entity
+                            // get related.
+                            non_identifying_attributes: HashMap::from([(
+                                ENTITY_GUID_ATTRIBUTE_KEY.to_string(),
+                                current_guid.guid.clone().into(),
+                            )]),
                         }),
                     );
                     last_guid = Some(current_guid);
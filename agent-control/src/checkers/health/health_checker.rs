@@ -6,6 +6,7 @@ use crate::event::cancellation::CancellationMessage;
 use crate::event::channel::EventConsumer;
 use crate::k8s;
 use crate::sub_agent::identity::ID_ATTRIBUTE_NAME;
+use crate::utils::error_kind::{ClassifiedError, ErrorKind};
 use crate::utils::thread_context::{NotStartedThreadContext, StartedThreadContext};
 use duration_str::deserialize_duration;
 use serde::Deserialize;
@@ -79,6 +80,18 @@ pub enum HealthCheckerError {
     K8sError(#[from] k8s::Error),
 }
 
+impl ClassifiedError for HealthCheckerError {
+    fn kind(&self) -> ErrorKind {
+        match self {
+            Self::K8sError(_) => ErrorKind::Transient,
+            Self::SystemTime(_) => ErrorKind::Fatal,
+            Self::Generic(_)
+            | Self::MissingK8sObjectField { .. }
+            | Self::InvalidK8sObject { .. } => ErrorKind::Misconfiguration,
+        }
+    }
+}
+
 impl Health {
     /// Returns `true` if the health is healthy.
     pub fn is_healthy(&self) -> bool {
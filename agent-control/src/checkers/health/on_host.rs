@@ -1,4 +1,9 @@
-//! On-host health checkers (exec, file, and HTTP based) and their aggregation.
+//! On-host health checkers (exec, file, HTTP, TCP, probe command and data flow based) and their
+//! aggregation.
+/// Health derived from the exit status of a probe command.
+pub mod command;
+/// Health derived from the data sent by an OpenTelemetry collector.
+pub mod data_flow;
 /// Health derived from supervised executables' reported health.
 pub mod exec;
 /// Health read from a file written by the agent.
@@ -7,3 +12,5 @@ pub mod file;
 pub mod health_checker;
 /// Health derived from an HTTP endpoint.
 pub mod http;
+/// Health derived from accepting connections on a TCP port.
+pub mod tcp;
@@ -0,0 +1,159 @@
+//! Health checker that derives health from the exit status of a probe command.
+use crate::checkers::health::health_checker::{
+    HealthChecker, HealthCheckerError, Healthy, Unhealthy,
+};
+use crate::checkers::health::with_start_time::{HealthWithStartTime, StartTime};
+use std::io::Read;
+use std::process::{Command, Stdio};
+use std::thread::{self, JoinHandle, sleep};
+use std::time::{Duration, Instant};
+
+/// Interval between checks of whether the probe command exited.
+const POLL_INTERVAL: Duration = Duration::from_millis(50);
+
+/// Runs a probe command, reporting healthy if it exits successfully before the timeout. Its
+/// standard output is reported as the status.
+pub struct CommandHealthChecker {
+    path: String,
+    args: Vec<String>,
+    timeout: Duration,
+    start_time: StartTime,
+}
+
+impl CommandHealthChecker {
+    /// Builds a checker running `path` with `args`, killing it after `timeout`.
+    pub fn new(path: String, args: Vec<String>, timeout: Duration, start_time: StartTime) -> Self {
+        Self {
+            path,
+            args,
+            timeout,
+            start_time,
+        }
+    }
+}
+
+impl HealthChecker for CommandHealthChecker {
+    fn check_health(&self) -> Result<HealthWithStartTime, HealthCheckerError> {
+        let mut child = Command::new(&self.path)
+            .args(&self.args)
+            .stdin(Stdio::null())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .spawn()
+            .map_err(|err| {
+                HealthCheckerError::Generic(format!("running probe command '{}': {err}", self.path))
+            })?;
+        // The pipes are drained while waiting, so a verbose command doesn't block on them.
+        let stdout = child.stdout.take().map(read_pipe);
+        let stderr = child.stderr.take().map(read_pipe);
+
+        let deadline = Instant::now() + self.timeout;
+        let exit_status = loop {
+            match child.try_wait() {
+                Ok(Some(exit_status)) => break exit_status,
+                Ok(None) if Instant::now() < deadline => sleep(POLL_INTERVAL),
+                Ok(None) => {
+                    let _ = child.kill();
+                    let _ = child.wait();
+                    return Ok(HealthWithStartTime::from_unhealthy(
+                        Unhealthy::new(format!(
+                            "probe command '{}' timed out after {}s",
+                            self.path,
+                            self.timeout.as_secs()
+                        )),
+                        self.start_time,
+                    ));
+                }
+                Err(err) => {
+                    return Err(HealthCheckerError::Generic(format!(
+                        "waiting for probe command '{}': {err}",
+                        self.path
+                    )));
+                }
+            }
+        };
+
+        let status = join_pipe(stdout);
+        if exit_status.success() {
+            return Ok(HealthWithStartTime::from_healthy(
+                Healthy::new().with_status(status),
+                self.start_time,
+            ));
+        }
+        let last_error = format!(
+            "probe command '{}' failed with '{exit_status}': {}",
+            self.path,
+            join_pipe(stderr)
+        );
+        Ok(HealthWithStartTime::from_unhealthy(
+            Unhealthy::new(last_error).with_status(status),
+            self.start_time,
+        ))
+    }
+}
+
+fn read_pipe<R: Read + Send + 'static>(mut pipe: R) -> JoinHandle<String> {
+    thread::spawn(move || {
+        let mut output = Vec::new();
+        let _ = pipe.read_to_end(&mut output);
+        String::from_utf8_lossy(&output).trim().to_string()
+    })
+}
+
+fn join_pipe(reader: Option<JoinHandle<String>>) -> String {
+    reader
+        .and_then(|reader| reader.join().ok())
+        .unwrap_or_default()
+}
+
+#[cfg(all(test, target_family = "unix"))]
+mod tests {
+    use super::*;
+
+    fn checker(script: &str, timeout: Duration) -> CommandHealthChecker {
+        CommandHealthChecker::new(
+            "/bin/sh".to_string(),
+            vec!["-c".to_string(), script.to_string()],
+            timeout,
+            StartTime::now(),
+        )
+    }
+
+    #[test]
+    fn test_successful_probe_is_healthy() {
+        let health = checker("echo ready", Duration::from_secs(5))
+            .check_health()
+            .unwrap();
+        assert!(health.is_healthy());
+        assert_eq!(health.status(), "ready");
+    }
+
+    #[test]
+    fn test_failed_probe_is_unhealthy() {
+        let health = checker("echo broken >&2; exit 3", Duration::from_secs(5))
+            .check_health()
+            .unwrap();
+        assert!(!health.is_healthy());
+        assert!(health.last_error().unwrap().ends_with(": broken"));
+    }
+
+    #[test]
+    fn test_probe_timeout_is_unhealthy() {
+        let health = checker("sleep 10", Duration::from_millis(100))
+            .check_health()
+            .unwrap();
+        assert!(!health.is_healthy());
+        assert!(health.last_error().unwrap().contains("timed out"));
+    }
+
+    #[test]
+    fn test_missing_probe_command_errors() {
+        let checker = CommandHealthChecker::new(
+            "/non/existing/probe".to_string(),
+            Vec::new(),
+            Duration::from_secs(1),
+            StartTime::now(),
+        );
+        assert!(checker.check_health().is_err());
+    }
+}
@@ -0,0 +1,231 @@
+//! Health checker that derives health from the data sent by an OpenTelemetry collector.
+//!
+//! A running collector with a broken exporter would look healthy to the rest of checks, so this
+//! one reads the `otelcol_exporter_sent_*` counters from the Prometheus endpoint of the collector
+//! internal telemetry and reports it unhealthy when they don't increase for `max_idle`.
+use super::http::HttpClient;
+use crate::agent_type::runtime_config::health_config::rendered::DataFlowHealth;
+use crate::checkers::health::health_checker::{
+    HealthChecker, HealthCheckerError, Healthy, Unhealthy,
+};
+use crate::checkers::health::with_start_time::{HealthWithStartTime, StartTime};
+use crate::http::client::HttpClient as InnerClient;
+use chrono::{DateTime, SecondsFormat, Utc};
+use std::cell::RefCell;
+use std::collections::HashMap;
+use std::time::{Duration, SystemTime};
+use url::Url;
+
+/// Path of the Prometheus endpoint of the collector internal telemetry.
+const METRICS_PATH: &str = "/metrics";
+/// Prefix of the counters of the data sent by the collector exporters, e.g.
+/// `otelcol_exporter_sent_spans` or `otelcol_exporter_sent_log_records_total`.
+const SENT_METRICS_PREFIX: &str = "otelcol_exporter_sent_";
+
+/// Progress of the data sent by the collector.
+struct DataFlow {
+    /// Last read total of data sent.
+    sent: f64,
+    /// Last time the total increased, or the start of the checks.
+    last_sent: SystemTime,
+    /// Start of the current period of data being sent, if any.
+    flowing_since: Option<SystemTime>,
+}
+
+/// Reports the collector unhealthy when its exporters don't send data for `max_idle`.
+pub struct DataFlowHealthChecker<C = InnerClient>
+where
+    C: HttpClient,
+{
+    client: C,
+    url: Url,
+    max_idle: Duration,
+    start_time: StartTime,
+    data_flow: RefCell<DataFlow>,
+}
+
+impl DataFlowHealthChecker<InnerClient> {
+    pub(crate) fn new(
+        client: InnerClient,
+        config: DataFlowHealth,
+        start_time: StartTime,
+    ) -> Result<Self, HealthCheckerError> {
+        let host: String = config.host.into();
+        let mut url = Url::parse(&format!("http://{host}"))
+            .map_err(|e| HealthCheckerError::Generic(e.to_string()))?;
+        let _ = url.set_port(Some(config.port));
+        url.set_path(METRICS_PATH);
+
+        Ok(Self::with_client(client, url, config.max_idle, start_time))
+    }
+}
+
+impl<C: HttpClient> DataFlowHealthChecker<C> {
+    fn with_client(client: C, url: Url, max_idle: Duration, start_time: StartTime) -> Self {
+        Self {
+            client,
+            url,
+            max_idle,
+            start_time,
+            data_flow: RefCell::new(DataFlow {
+                sent: 0.0,
+                last_sent: SystemTime::now(),
+                flowing_since: None,
+            }),
+        }
+    }
+
+    fn read_sent(&self) -> Result<f64, HealthCheckerError> {
+        let response = self
+            .client
+            .get(self.url.as_str(), &HashMap::new())
+            .map_err(|err| {
+                HealthCheckerError::Generic(format!("reading the collector metrics: {err}"))
+            })?;
+        Ok(sent_total(&String::from_utf8_lossy(response.body())))
+    }
+}
+
+impl<C: HttpClient> HealthChecker for DataFlowHealthChecker<C> {
+    fn check_health(&self) -> Result<HealthWithStartTime, HealthCheckerError> {
+        let sent = self.read_sent()?;
+        let now = SystemTime::now();
+        let mut data_flow = self.data_flow.borrow_mut();
+
+        // Counters start from scratch when the collector restarts.
+        let restarted = sent < data_flow.sent;
+        if sent > data_flow.sent || (restarted && sent > 0.0) {
+            data_flow.last_sent = now;
+            data_flow.flowing_since.get_or_insert(now);
+        }
+        data_flow.sent = sent;
+
+        let idle = now.duration_since(data_flow.last_sent).unwrap_or_default();
+        if idle > self.max_idle {
+            data_flow.flowing_since = None;
+        }
+
+        let status = match data_flow.flowing_since {
+            Some(since) => format!("data flowing: yes since {}", format_time(since)),
+            None => format!(
+                "data flowing: no since {}",
+                format_time(data_flow.last_sent)
+            ),
+        };
+        if idle > self.max_idle {
+            return Ok(HealthWithStartTime::from_unhealthy(
+                Unhealthy::new(format!(
+                    "the collector exporters sent no data for more than {:?}",
+                    self.max_idle
+                ))
+                .with_status(status),
+                self.start_time,
+            ));
+        }
+        Ok(HealthWithStartTime::from_healthy(
+            Healthy::new().with_status(status),
+            self.start_time,
+        ))
+    }
+}
+
+/// Sums the values of the exporter sent counters of a Prometheus text exposition.
+fn sent_total(metrics: &str) -> f64 {
+    metrics
+        .lines()
+        .map(str::trim)
+        .filter(|line| line.starts_with(SENT_METRICS_PREFIX))
+        .filter_map(|line| {
+            // The value follows the name and the optional labels: `name{labels} value [timestamp]`.
+            let sample = match line.rsplit_once('}') {
+                Some((_, sample)) => sample,
+                None => line.split_once(char::is_whitespace)?.1,
+            };
+            sample.split_whitespace().next()?.parse::<f64>().ok()
+        })
+        .sum()
+}
+
+fn format_time(time: SystemTime) -> String {
+    DateTime::<Utc>::from(time).to_rfc3339_opts(SecondsFormat::Secs, true)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::checkers::health::on_host::http::HttpClientError;
+    use crate::checkers::health::on_host::http::tests::MockHttpClient;
+    use http::Response;
+
+    const METRICS: &str = r#"
+# HELP otelcol_exporter_sent_spans_total Number of spans successfully sent to destination.
+# TYPE otelcol_exporter_sent_spans_total counter
+otelcol_exporter_sent_spans_total{exporter="otlphttp",service_name="otelcol"} 10
+otelcol_exporter_sent_metric_points_total{exporter="otlphttp"} 5.5
+otelcol_exporter_send_failed_spans_total{exporter="otlphttp"} 100
+otelcol_exporter_sent_log_records 2
+otelcol_process_uptime_total 30
+"#;
+
+    fn checker(
+        client: MockHttpClient,
+        max_idle: Duration,
+    ) -> DataFlowHealthChecker<MockHttpClient> {
+        DataFlowHealthChecker::with_client(
+            client,
+            Url::parse("http://127.0.0.1:8888/metrics").unwrap(),
+            max_idle,
+            StartTime::now(),
+        )
+    }
+
+    #[test]
+    fn test_sent_total() {
+        assert_eq!(sent_total(METRICS), 17.5);
+        assert_eq!(sent_total(""), 0.0);
+    }
+
+    #[test]
+    fn test_data_flowing() {
+        let mut client = MockHttpClient::new();
+        client.should_get(Response::new(METRICS.as_bytes().to_vec()));
+
+        let health = checker(client, Duration::from_secs(60))
+            .check_health()
+            .unwrap();
+        assert!(health.is_healthy());
+        assert!(health.status().starts_with("data flowing: yes since "));
+    }
+
+    #[test]
+    fn test_data_not_flowing() {
+        let mut client = MockHttpClient::new();
+        client
+            .expect_get()
+            .times(2)
+            .returning(|_, _| Ok(Response::new(Vec::new())));
+        let checker = checker(client, Duration::ZERO);
+
+        // Nothing sent yet, but still within the max idle time
+        checker.data_flow.borrow_mut().last_sent = SystemTime::now() + Duration::from_secs(60);
+        let health = checker.check_health().unwrap();
+        assert!(health.is_healthy());
+        assert!(health.status().starts_with("data flowing: no since "));
+
+        checker.data_flow.borrow_mut().last_sent = SystemTime::now() - Duration::from_secs(1);
+        let health = checker.check_health().unwrap();
+        assert!(!health.is_healthy());
+        assert!(health.status().starts_with("data flowing: no since "));
+    }
+
+    #[test]
+    fn test_metrics_not_available() {
+        let mut client = MockHttpClient::new();
+        client.should_not_get(HttpClientError::HttpClientError(
+            "connection refused".to_string(),
+        ));
+
+        let result = checker(client, Duration::from_secs(60)).check_health();
+        assert!(result.is_err());
+    }
+}
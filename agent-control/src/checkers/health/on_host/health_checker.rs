@@ -1,13 +1,22 @@
 //! The aggregate on-host health checker and its per-source variants.
+use super::command::CommandHealthChecker;
+use super::data_flow::DataFlowHealthChecker;
 use super::exec::ExecHealthChecker;
 use super::file::FileHealthChecker;
 use super::http::HttpHealthChecker;
-use crate::agent_type::runtime_config::health_config::rendered::OnHostHealthCheck;
+use super::tcp::TcpHealthChecker;
+use crate::agent_type::runtime_config::health_config::rendered::{
+    DataFlowHealth, OnHostHealthCheck,
+};
 use crate::checkers::health::health_checker::{HealthChecker, HealthCheckerError, Healthy};
 use crate::checkers::health::with_start_time::{HealthWithStartTime, StartTime};
 use crate::event::channel::EventConsumer;
 use crate::http::client::HttpClient;
+use crate::sub_agent::on_host::restart_requests::RestartRequests;
+use std::cell::Cell;
 use std::path::PathBuf;
+use std::time::Duration;
+use tracing::warn;
 
 /// A single on-host health-check source.
 pub enum OnHostHealthChecker {
@@ -15,13 +24,27 @@ pub enum OnHostHealthChecker {
     Exec(ExecHealthChecker),
     /// Health from an HTTP endpoint.
     Http(HttpHealthChecker),
+    /// Health from accepting connections on a TCP port.
+    Tcp(TcpHealthChecker),
+    /// Health from the exit status of a probe command.
+    Command(CommandHealthChecker),
     /// Health read from a file.
     File(FileHealthChecker),
+    /// Health from the data sent by an OpenTelemetry collector.
+    DataFlow(DataFlowHealthChecker),
 }
 /// Aggregates the configured on-host health sources, reporting the first unhealthy result.
 pub struct OnHostHealthCheckers {
     health_checkers: Vec<OnHostHealthChecker>,
     start_time: StartTime,
+    restart: Option<ProbeRestart>,
+}
+
+/// Restarts the executables after a number of consecutive failed probes.
+struct ProbeRestart {
+    after_failures: u32,
+    failures: Cell<u32>,
+    requests: RestartRequests,
 }
 
 impl OnHostHealthCheckers {
@@ -29,6 +52,8 @@ impl OnHostHealthCheckers {
         exec_health_consumer: EventConsumer<(String, HealthWithStartTime)>,
         http_client: HttpClient,
         health_check_type: Option<OnHostHealthCheck>,
+        data_flow: Option<DataFlowHealth>,
+        timeout: Duration,
         start_time: StartTime,
     ) -> Result<Self, HealthCheckerError> {
         let mut health_checkers = vec![OnHostHealthChecker::Exec(ExecHealthChecker::new(
@@ -37,11 +62,27 @@ impl OnHostHealthCheckers {
         match health_check_type {
             Some(OnHostHealthCheck::HttpHealth(http_config)) => {
                 health_checkers.push(OnHostHealthChecker::Http(HttpHealthChecker::new(
-                    http_client,
+                    http_client.clone(),
                     http_config,
                     start_time,
                 )?));
             }
+            Some(OnHostHealthCheck::TcpHealth(tcp_config)) => {
+                health_checkers.push(OnHostHealthChecker::Tcp(TcpHealthChecker::new(
+                    tcp_config.host.into(),
+                    tcp_config.port,
+                    timeout,
+                    start_time,
+                )));
+            }
+            Some(OnHostHealthCheck::ExecHealth(exec_config)) => {
+                health_checkers.push(OnHostHealthChecker::Command(CommandHealthChecker::new(
+                    exec_config.path,
+                    exec_config.args,
+                    timeout,
+                    start_time,
+                )));
+            }
             Some(OnHostHealthCheck::FileHealth(file_config)) => {
                 health_checkers.push(OnHostHealthChecker::File(FileHealthChecker::new(
                     PathBuf::from(file_config.path),
@@ -49,11 +90,57 @@ impl OnHostHealthCheckers {
             }
             _ => {}
         }
+        // Last, so the data flow is the reported status when everything is healthy.
+        if let Some(data_flow_config) = data_flow {
+            health_checkers.push(OnHostHealthChecker::DataFlow(DataFlowHealthChecker::new(
+                http_client,
+                data_flow_config,
+                start_time,
+            )?));
+        }
         Ok(OnHostHealthCheckers {
             health_checkers,
             start_time,
+            restart: None,
         })
     }
+
+    /// Requests the executables to restart once the configured check fails `after_failures`
+    /// consecutive times. Zero never requests it.
+    pub(crate) fn with_restart(self, after_failures: u32, requests: RestartRequests) -> Self {
+        Self {
+            restart: (after_failures > 0).then(|| ProbeRestart {
+                after_failures,
+                failures: Cell::new(0),
+                requests,
+            }),
+            ..self
+        }
+    }
+
+    /// Counts the consecutive failures of the configured check, requesting a restart when they
+    /// reach the threshold.
+    fn probed(
+        &self,
+        result: Result<HealthWithStartTime, HealthCheckerError>,
+    ) -> Result<HealthWithStartTime, HealthCheckerError> {
+        let Some(restart) = &self.restart else {
+            return result;
+        };
+        if result.as_ref().is_ok_and(|health| health.is_healthy()) {
+            restart.failures.set(0);
+            return result;
+        }
+        let failures = restart.failures.get() + 1;
+        if failures >= restart.after_failures {
+            warn!("Health check failed {failures} consecutive times, restarting the executables");
+            restart.requests.request();
+            restart.failures.set(0);
+        } else {
+            restart.failures.set(failures);
+        }
+        result
+    }
 }
 
 impl HealthChecker for OnHostHealthCheckers {
@@ -62,8 +149,19 @@ impl HealthChecker for OnHostHealthCheckers {
         for checker in &self.health_checkers {
             let health = match checker {
                 OnHostHealthChecker::Exec(exec_checker) => exec_checker.check_health()?,
-                OnHostHealthChecker::Http(http_checker) => http_checker.check_health()?,
-                OnHostHealthChecker::File(file_checker) => file_checker.check_health()?,
+                OnHostHealthChecker::Http(http_checker) => {
+                    self.probed(http_checker.check_health())?
+                }
+                OnHostHealthChecker::Tcp(tcp_checker) => self.probed(tcp_checker.check_health())?,
+                OnHostHealthChecker::Command(command_checker) => {
+                    self.probed(command_checker.check_health())?
+                }
+                OnHostHealthChecker::File(file_checker) => {
+                    self.probed(file_checker.check_health())?
+                }
+                OnHostHealthChecker::DataFlow(data_flow_checker) => {
+                    data_flow_checker.check_health()?
+                }
             };
 
             // We are overriding the status with any status from the health checks that is not empty.
@@ -126,6 +224,7 @@ status_time_unix_nano: 1725444001
         let on_host_health_checkers = OnHostHealthCheckers {
             health_checkers,
             start_time,
+            restart: None,
         };
 
         let result = on_host_health_checkers.check_health();
@@ -165,6 +264,7 @@ status_time_unix_nano: 1725444001
         let on_host_health_checkers = OnHostHealthCheckers {
             health_checkers,
             start_time,
+            restart: None,
         };
 
         let result = on_host_health_checkers.check_health();
@@ -203,6 +303,7 @@ status_time_unix_nano: 1725444001
         let on_host_health_checkers = OnHostHealthCheckers {
             health_checkers,
             start_time,
+            restart: None,
         };
 
         let result = on_host_health_checkers.check_health();
@@ -251,6 +352,7 @@ status_time_unix_nano: 1725444001
         let on_host_health_checkers = OnHostHealthCheckers {
             health_checkers,
             start_time,
+            restart: None,
         };
 
         let result = on_host_health_checkers.check_health();
@@ -299,6 +401,7 @@ status_time_unix_nano: 1725444001
         let on_host_health_checkers = OnHostHealthCheckers {
             health_checkers,
             start_time,
+            restart: None,
         };
 
         let result = on_host_health_checkers.check_health();
@@ -310,4 +413,49 @@ status_time_unix_nano: 1725444001
             Some("executable exec1 failed: exec error".to_string())
         );
     }
+
+    #[test]
+    fn test_consecutive_probe_failures_request_restart() {
+        let start_time = StartTime::now();
+        let (_exec_health_publisher, exec_health_consumer) = pub_sub();
+        let tmp_dir = TempDir::new().unwrap();
+        let health_file = tmp_dir.path().join("health");
+        let write_health = |healthy: bool| {
+            std::fs::write(
+                &health_file,
+                format!(
+                    "healthy: {healthy}\nstatus: \"\"\nstart_time_unix_nano: 1\nstatus_time_unix_nano: 1\n"
+                ),
+            )
+            .unwrap()
+        };
+
+        let requests = RestartRequests::default();
+        let watch = requests.watch();
+        let on_host_health_checkers = OnHostHealthCheckers {
+            health_checkers: vec![
+                OnHostHealthChecker::Exec(ExecHealthChecker::new(exec_health_consumer)),
+                OnHostHealthChecker::File(FileHealthChecker::new(health_file.clone())),
+            ],
+            start_time,
+            restart: None,
+        }
+        .with_restart(2, requests.clone());
+
+        // A healthy check in between resets the consecutive failures.
+        for healthy in [false, true, false] {
+            write_health(healthy);
+            let _ = on_host_health_checkers.check_health();
+        }
+        assert!(!watch.is_requested());
+
+        write_health(false);
+        let _ = on_host_health_checkers.check_health();
+        assert!(watch.is_requested());
+
+        // The count starts again after requesting the restart.
+        let watch = requests.watch();
+        let _ = on_host_health_checkers.check_health();
+        assert!(!watch.is_requested());
+    }
 }
@@ -0,0 +1,82 @@
+//! Health checker that derives health from accepting connections on a TCP port.
+use crate::checkers::health::health_checker::{
+    HealthChecker, HealthCheckerError, Healthy, Unhealthy,
+};
+use crate::checkers::health::with_start_time::{HealthWithStartTime, StartTime};
+use std::net::{TcpStream, ToSocketAddrs};
+use std::time::Duration;
+
+/// Reports healthy while the agent accepts connections on the configured address.
+pub struct TcpHealthChecker {
+    host: String,
+    port: u16,
+    timeout: Duration,
+    start_time: StartTime,
+}
+
+impl TcpHealthChecker {
+    /// Builds a checker connecting to `host:port`, giving up after `timeout`.
+    pub fn new(host: String, port: u16, timeout: Duration, start_time: StartTime) -> Self {
+        Self {
+            host,
+            port,
+            timeout,
+            start_time,
+        }
+    }
+}
+
+impl HealthChecker for TcpHealthChecker {
+    fn check_health(&self) -> Result<HealthWithStartTime, HealthCheckerError> {
+        let addresses = (self.host.as_str(), self.port)
+            .to_socket_addrs()
+            .map_err(|err| {
+                HealthCheckerError::Generic(format!(
+                    "resolving '{}:{}': {err}",
+                    self.host, self.port
+                ))
+            })?;
+
+        let mut last_error = format!("'{}:{}' resolved to no address", self.host, self.port);
+        for address in addresses {
+            match TcpStream::connect_timeout(&address, self.timeout) {
+                Ok(_) => {
+                    return Ok(HealthWithStartTime::from_healthy(
+                        Healthy::new(),
+                        self.start_time,
+                    ));
+                }
+                Err(err) => last_error = format!("connecting to {address}: {err}"),
+            }
+        }
+
+        Ok(HealthWithStartTime::from_unhealthy(
+            Unhealthy::new(last_error),
+            self.start_time,
+        ))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::net::TcpListener;
+
+    #[test]
+    fn test_tcp_health() {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let port = listener.local_addr().unwrap().port();
+        let checker = TcpHealthChecker::new(
+            "127.0.0.1".to_string(),
+            port,
+            Duration::from_secs(1),
+            StartTime::now(),
+        );
+        assert!(checker.check_health().unwrap().is_healthy());
+
+        drop(listener);
+        let health = checker.check_health().unwrap();
+        assert!(!health.is_healthy());
+        assert!(health.last_error().unwrap().contains("connecting to"));
+    }
+}
@@ -1,6 +1,7 @@
 //! Error type shared by the CLI commands and its mapping to process exit codes.
 use std::process::ExitCode;
 
+use crate::command::exit_status::ExitStatus;
 use crate::instrumentation::tracing::TracingError;
 use thiserror::Error;
 
@@ -22,22 +23,49 @@ pub enum CliError {
     /// A filesystem operation failed.
     #[error("file system error: {0}")]
     FileSystemError(String),
+
+    /// The provided configuration is invalid.
+    #[error("invalid configuration: {0}")]
+    InvalidConfig(String),
 }
 
 impl From<CliError> for ExitCode {
     /// Converts the error to an exit code.
     ///
-    /// We comply with the [Advanced Bash Scripting Guide] and
-    /// [BSD guidelines] for the exit codes.
-    ///
-    /// [Advanced Bash Scripting Guide]: https://tldp.org/LDP/abs/html/exitcodes.html
-    /// [BSD exit codes]: https://man.freebsd.org/cgi/man.cgi?query=sysexits&manpath=FreeBSD+4.3-RELEASE
+    /// The codes are the ones of the [ExitStatus] taxonomy shared with the Agent Control binary,
+    /// so a CLI failure is never mistaken for another failure class.
     fn from(value: CliError) -> Self {
+        ExitStatus::from(&value).into()
+    }
+}
+
+impl From<&CliError> for ExitStatus {
+    fn from(value: &CliError) -> Self {
         match value {
-            CliError::Precondition(_) => Self::from(69),
-            CliError::Tracing(_) => Self::from(70),
-            CliError::Command(_) => Self::from(1),
-            CliError::FileSystemError(_) => Self::from(1),
+            CliError::Precondition(_) => ExitStatus::PreconditionFailed,
+            CliError::Tracing(_) => ExitStatus::LoggingFailed,
+            CliError::Command(_) => ExitStatus::Failure,
+            CliError::FileSystemError(_) => ExitStatus::Failure,
+            CliError::InvalidConfig(_) => ExitStatus::ConfigInvalid,
         }
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+
+    #[rstest]
+    #[case::precondition(CliError::Precondition("agent control is running".to_string()), ExitStatus::PreconditionFailed)]
+    #[case::command(CliError::Command("failed".to_string()), ExitStatus::Failure)]
+    #[case::file_system(CliError::FileSystemError("read-only".to_string()), ExitStatus::Failure)]
+    #[case::invalid_config(CliError::InvalidConfig("bad".to_string()), ExitStatus::ConfigInvalid)]
+    fn test_exit_status(#[case] err: CliError, #[case] expected: ExitStatus) {
+        let status = ExitStatus::from(&err);
+        assert_eq!(status, expected);
+        // The OpAMP and crash loop codes are only reported by the Agent Control binary.
+        assert_ne!(status.code(), ExitStatus::OpAMPUnreachable.code());
+        assert_ne!(status.code(), ExitStatus::SubAgentCrashLoop.code());
+    }
+}
@@ -1,10 +1,8 @@
 //! Logging setup for the CLI commands.
-use std::path::PathBuf;
-
 use tracing::Level;
 
 use crate::{
-    agent_control::defaults::AGENT_CONTROL_LOG_DIR,
+    agent_control::run::BasePaths,
     cli::common::error::CliError,
     instrumentation::{
         config::logs::config::LoggingConfig,
@@ -23,7 +21,7 @@ format:
     );
     let logging_config: LoggingConfig =
         serde_saphyr::from_str(&log_config).expect("Logging config should be valid");
-    let tracing_config = TracingConfig::from_logging_path(PathBuf::from(AGENT_CONTROL_LOG_DIR))
+    let tracing_config = TracingConfig::from_logging_path(BasePaths::default().log_dir)
         .with_logging_config(logging_config);
     try_init_tracing(tracing_config).map_err(CliError::from)
 }
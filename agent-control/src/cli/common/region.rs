@@ -1,8 +1,11 @@
 //! This module defines configuration related to region.
+use clap::ValueEnum;
 use http::Uri;
 use nr_auth::{
     parameters::Environments, system_identity::input_data::environment::NewRelicEnvironment,
 };
+use serde::{Deserialize, Deserializer};
+use std::fmt::{self, Display, Formatter};
 
 const OPAMP_ENDPOINT_US: &str = "https://opamp.service.newrelic.com/v1/opamp";
 const OPAMP_ENDPOINT_EU: &str = "https://opamp.service.eu.newrelic.com/v1/opamp";
@@ -40,6 +43,28 @@ pub enum Region {
     STAGING,
 }
 
+// Deserializes the same (case-insensitive) names the cli accepts, e.g. `eu` or `stg`.
+impl<'de> Deserialize<'de> for Region {
+    fn deserialize<D>(deserializer: D) -> Result<Self, D::Error>
+    where
+        D: Deserializer<'de>,
+    {
+        let region = String::deserialize(deserializer)?;
+        <Self as ValueEnum>::from_str(&region, true).map_err(serde::de::Error::custom)
+    }
+}
+
+impl Display for Region {
+    fn fmt(&self, f: &mut Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            Self::US => "US",
+            Self::EU => "EU",
+            Self::JP => "JP",
+            Self::STAGING => "STAGING",
+        })
+    }
+}
+
 impl From<Region> for Environments {
     fn from(value: Region) -> Self {
         match value {
@@ -94,6 +119,18 @@ impl Region {
         endpoint.parse().expect("known uris should be valid")
     }
 
+    /// Returns the OTLP/HTTP exporter endpoint for this region.
+    pub fn otlp_http_endpoint(&self) -> Uri {
+        let host = match &self {
+            Self::US => OTLP_URL_US,
+            Self::EU => OTLP_URL_EU,
+            Self::JP => OTLP_URL_JP,
+            Self::STAGING => OTLP_URL_STAGING,
+        };
+        let endpoint = format!("https://{}:4318", host);
+        endpoint.parse().expect("known uris should be valid")
+    }
+
     /// Returns the token renewal endpoint for this region, also needed in Agent Control configuration.
     pub fn token_renewal_endpoint(&self) -> Uri {
         NewRelicEnvironment::from(self.to_owned()).token_renewal_endpoint()
@@ -114,6 +151,15 @@ mod tests {
         assert_eq!(Environments::from(region), expected);
     }
 
+    #[rstest]
+    #[case("US", Region::US)]
+    #[case("eu", Region::EU)]
+    #[case("jp", Region::JP)]
+    #[case("stg", Region::STAGING)]
+    fn test_region_deserialize(#[case] input: &str, #[case] expected: Region) {
+        assert_eq!(serde_saphyr::from_str::<Region>(input).unwrap(), expected);
+    }
+
     #[rstest]
     #[case(Region::US, "https://opamp.service.newrelic.com/v1/opamp")]
     #[case(Region::EU, "https://opamp.service.eu.newrelic.com/v1/opamp")]
@@ -185,4 +231,16 @@ mod tests {
             expected_endpoint.to_string()
         );
     }
+
+    #[rstest]
+    #[case(Region::US, "https://otlp.nr-data.net:4318/")]
+    #[case(Region::EU, "https://otlp.eu01.nr-data.net:4318/")]
+    #[case(Region::JP, "https://otlp.jp.nr-data.net:4318/")]
+    #[case(Region::STAGING, "https://staging-otlp.nr-data.net:4318/")]
+    fn test_otlp_http_endpoint(#[case] region: Region, #[case] expected_endpoint: &str) {
+        assert_eq!(
+            region.otlp_http_endpoint().to_string(),
+            expected_endpoint.to_string()
+        );
+    }
 }
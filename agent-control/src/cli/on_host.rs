@@ -1,3 +1,8 @@
 //! CLI commands for configuring and migrating Agent Control on host (non-Kubernetes) environments.
+#[cfg(target_family = "unix")]
+pub mod apply;
 pub mod config_gen;
+pub mod import_opamp_supervisor;
 pub mod migrate_folders;
+pub mod purge;
+pub mod regenerate_instance_id;
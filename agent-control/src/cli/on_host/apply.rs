@@ -0,0 +1,718 @@
+//! Implementation of the apply command for the on-host cli.
+//!
+//! Writes a local configuration set to the Agent Control local config and asks the running
+//! Agent Control to apply it through the control API, so configuration management tools can
+//! run it repeatedly and rely on its exit code. Since a remote configuration from Fleet Control
+//! takes precedence over the local one, applying fails while a remote configuration is stored.
+//!
+//! The configuration can also come from a signed offline bundle (see [offline]), which also
+//! provides the local configuration of the agents and their packages.
+//!
+//! The files of a configuration set are merged as text by their top-level keys (see
+//! [YAMLDocument]), so the stored local config keeps their comments and anchors.
+use crate::agent_control::agent_id::AgentID;
+use crate::agent_control::config::AgentControlConfig;
+use crate::agent_control::config_repository::in_progress::{
+    ConfigApplyInProgress, ConfigApplyMarker, ConfigApplyTracker, LocalConfigFile,
+};
+use crate::agent_control::config_repository::repository::AgentControlConfigLoader;
+use crate::agent_control::config_repository::store::AgentControlConfigStore;
+use crate::agent_control::control_socket::client::send_command;
+use crate::agent_control::control_socket::protocol::ControlCommand;
+use crate::agent_control::defaults::{
+    AGENT_CONTROL_ID, CONTROL_SOCKET_FILE_NAME, FOLDER_NAME_LOCAL_DATA, STORE_KEY_LOCAL_DATA_CONFIG,
+};
+use crate::agent_control::run::BasePaths;
+use crate::cli::common::error::CliError;
+use crate::data_store::DataStore;
+use crate::on_host::file_store::{FileStore, build_config_name};
+use crate::package::offline::{self, BUNDLE_AGENTS_DIR, BUNDLE_CONFIG_DIR};
+use crate::signature::public_key_fetcher::read_key_ring;
+use crate::values::ConfigRepo;
+use crate::values::config_repository::ConfigRepository;
+use crate::values::yaml_config::YAMLConfig;
+use crate::values::yaml_document::YAMLDocument;
+use serde_json::Value;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+use std::thread::sleep;
+use std::time::{Duration, Instant};
+use tracing::{debug, info, warn};
+
+const DEFAULT_APPLY_TIMEOUT: &str = "70s";
+/// Time between the health queries while waiting for the agents to apply the configuration.
+const HEALTH_POLL_INTERVAL: Duration = Duration::from_secs(1);
+
+/// Applies a local configuration set to the running Agent Control.
+#[derive(Debug, clap::Parser)]
+pub struct Args {
+    /// Configuration file, or directory whose `.yaml`/`.yml` files are merged in name order.
+    /// Top-level keys of later files override the ones of earlier files.
+    #[arg(long, required_unless_present = "bundle", conflicts_with = "bundle")]
+    local_config: Option<PathBuf>,
+
+    /// Signed offline bundle (tar.gz) holding the configuration set, the local configuration of
+    /// the agents and their packages. Its signature is verified with the trusted key ring of the
+    /// current Agent Control configuration (`agent_packages.trusted_keys_path`).
+    #[arg(long)]
+    bundle: Option<PathBuf>,
+
+    /// Agent Control local data directory.
+    #[arg(long, default_value_os_t = BasePaths::default().local_dir)]
+    local_dir: PathBuf,
+
+    /// Agent Control data directory, where the offline bundle is imported.
+    #[arg(long, default_value_os_t = BasePaths::default().remote_dir)]
+    remote_dir: PathBuf,
+
+    /// Path of the Agent Control control socket.
+    #[arg(long, default_value_os_t = default_control_socket())]
+    control_socket: PathBuf,
+
+    /// Maximum time to wait for Agent Control to apply the configuration and for the affected
+    /// agents to report themselves healthy.
+    #[arg(long, default_value = DEFAULT_APPLY_TIMEOUT, value_parser = parse_duration_arg)]
+    timeout: Duration,
+}
+
+fn default_control_socket() -> PathBuf {
+    BasePaths::default().state_file(CONTROL_SOCKET_FILE_NAME)
+}
+
+// helper needed because the arguments from the duration_str's parse function and the one expected by the clap
+// `value_parser` argument have incompatible lifetimes.
+fn parse_duration_arg(arg: &str) -> Result<Duration, String> {
+    duration_str::parse(arg)
+}
+
+/// Merged configuration of a configuration set.
+#[derive(Debug)]
+struct ConfigSet {
+    values: YAMLConfig,
+    /// Text stored as local config: the merged text of the files, or the serialized values when
+    /// the files can't be merged as text.
+    content: String,
+}
+
+/// Merges and validates the local configuration set, stores it as the Agent Control local config
+/// when it differs from the current one and reloads Agent Control. Succeeds only once Agent
+/// Control applied the configuration and it, along with the agents the configuration affects,
+/// reports itself healthy. Fails beforehand when a remote configuration would mask it.
+pub fn apply(args: Args) -> Result<(), CliError> {
+    let (config_set, agents_dir) = match (&args.bundle, &args.local_config) {
+        (Some(bundle), _) => {
+            let bundle_dir = import_bundle(&args, bundle)?;
+            (
+                bundle_dir.join(BUNDLE_CONFIG_DIR),
+                Some(bundle_dir.join(BUNDLE_AGENTS_DIR)),
+            )
+        }
+        (None, Some(local_config)) => (local_config.clone(), None),
+        (None, None) => {
+            return Err(CliError::Precondition(
+                "either a local configuration or a bundle is required".to_string(),
+            ));
+        }
+    };
+
+    let config = load_config_set(&config_set)?;
+    let agent_control_config = AgentControlConfig::try_from(config.values.clone())
+        .map_err(|err| CliError::InvalidConfig(err.to_string()))?;
+    let agents_config = agents_dir
+        .as_deref()
+        .map(load_agents_local_config)
+        .transpose()?
+        .unwrap_or_default();
+
+    // Remote configs are only loaded while Fleet Control is enabled.
+    if agent_control_config.fleet_control.is_some() {
+        let agent_ids = agents_config.iter().map(|(agent_id, _)| agent_id);
+        ensure_no_remote_config(&args, [AgentID::AgentControl].iter().chain(agent_ids))?;
+    }
+
+    // Agent Control reverts the local configuration if the host reboots before the apply completes.
+    let marker = ConfigApplyMarker::from(Arc::new(FileStore::new_local_fs(
+        args.local_dir.clone(),
+        args.remote_dir.clone(),
+    )));
+    let agent_ids = agents_config.iter().map(|(agent_id, _)| agent_id.as_str());
+    mark_apply_in_progress(
+        &marker,
+        &args.local_dir,
+        [AGENT_CONTROL_ID].into_iter().chain(agent_ids),
+    )?;
+
+    let result = store_and_reload(&args, &config, &agents_config);
+    let _ = marker
+        .clear()
+        .inspect_err(|err| warn!("Could not clear the in-progress config apply: {err}"));
+    result
+}
+
+/// Persists the marker holding the current content of the local configuration of `agent_ids`.
+fn mark_apply_in_progress<'a, D>(
+    marker: &ConfigApplyMarker<D>,
+    local_dir: &Path,
+    agent_ids: impl IntoIterator<Item = &'a str>,
+) -> Result<(), CliError>
+where
+    D: DataStore + Send + Sync,
+{
+    let previous = agent_ids
+        .into_iter()
+        .map(|agent_id| {
+            let path = local_config_path(local_dir, agent_id);
+            let content = fs::read_to_string(&path).ok();
+            LocalConfigFile { path, content }
+        })
+        .collect();
+    marker
+        .mark(&ConfigApplyInProgress::Local { previous })
+        .map_err(|err| {
+            CliError::FileSystemError(format!("marking the config apply as in progress: {err}"))
+        })
+}
+
+/// Stores the local configuration of Agent Control and of the agents, then reloads Agent Control
+/// and waits for the affected agents to be healthy.
+fn store_and_reload(
+    args: &Args,
+    config: &ConfigSet,
+    agents_config: &[(AgentID, ConfigSet)],
+) -> Result<(), CliError> {
+    let mut changed = store_local_config(
+        &local_config_path(&args.local_dir, AGENT_CONTROL_ID),
+        config,
+    )?;
+    changed |= store_agents_local_config(&args.local_dir, agents_config)?;
+    if changed {
+        info!("Local configuration updated");
+    } else {
+        debug!("Local configuration already up to date");
+    }
+
+    let deadline = Instant::now() + args.timeout;
+    let response = send_command(&args.control_socket, &ControlCommand::Reload, args.timeout)
+        .map_err(|err| CliError::Command(err.to_string()))?;
+    if !response.ok {
+        return Err(CliError::InvalidConfig(format!(
+            "Agent Control could not apply the configuration: {}",
+            response.error.unwrap_or_default()
+        )));
+    }
+
+    // The agents started or recreated by the reload, along with the ones of the bundle.
+    let mut agents = reloaded_agents(response.result.as_ref());
+    agents.extend(
+        agents_config
+            .iter()
+            .map(|(agent_id, _)| agent_id.to_string()),
+    );
+    agents.sort();
+    agents.dedup();
+    wait_until_healthy(&args.control_socket, &agents, deadline)?;
+
+    info!("Configuration applied");
+    Ok(())
+}
+
+/// Returns the ids of the agents listed in the result of the reload command.
+fn reloaded_agents(result: Option<&Value>) -> Vec<String> {
+    result
+        .and_then(|result| result["agents"].as_array())
+        .into_iter()
+        .flatten()
+        .filter_map(|agent_id| agent_id.as_str().map(String::from))
+        .collect()
+}
+
+/// Queries the health reported through the control API until Agent Control and every one of
+/// `agents` are healthy. Agents are commonly unhealthy for a while after starting, so an unhealthy
+/// agent only fails the command if it is still unhealthy once `deadline` is reached.
+fn wait_until_healthy(
+    control_socket: &Path,
+    agents: &[String],
+    deadline: Instant,
+) -> Result<(), CliError> {
+    loop {
+        let remaining = deadline.saturating_duration_since(Instant::now());
+        let pending = match send_command(control_socket, &ControlCommand::Health, remaining) {
+            Ok(response) if response.ok => match response.result {
+                Some(health) => match pending_health(&health, agents) {
+                    None => return Ok(()),
+                    Some(pending) => pending,
+                },
+                None => "Agent Control didn't report its health".to_string(),
+            },
+            Ok(response) => response.error.unwrap_or_default(),
+            Err(err) => err.to_string(),
+        };
+
+        if Instant::now() + HEALTH_POLL_INTERVAL > deadline {
+            return Err(CliError::InvalidConfig(format!(
+                "the configuration wasn't applied within the timeout: {pending}"
+            )));
+        }
+        debug!(pending, "Waiting for the configuration to be applied");
+        sleep(HEALTH_POLL_INTERVAL);
+    }
+}
+
+/// Returns why the result of the health command doesn't show Agent Control and every one of
+/// `agents` as healthy, or `None` if it does.
+fn pending_health(health: &Value, agents: &[String]) -> Option<String> {
+    if health["healthy"] != true {
+        return Some(format!(
+            "Agent Control is unhealthy: {}",
+            health["last_error"].as_str().unwrap_or_default()
+        ));
+    }
+    agents.iter().find_map(|agent_id| {
+        let agent_health = &health["agents"][agent_id.as_str()];
+        if agent_health.is_null() {
+            Some(format!("'{agent_id}' didn't report its health yet"))
+        } else if agent_health["healthy"] != true {
+            Some(format!(
+                "'{agent_id}' is unhealthy: {}",
+                agent_health["last_error"].as_str().unwrap_or_default()
+            ))
+        } else {
+            None
+        }
+    })
+}
+
+/// Imports the offline bundle, returning the directory it was imported to.
+///
+/// The bundle is verified with the trusted key ring of the configuration Agent Control is
+/// currently running with, since the bundle itself replaces that configuration.
+fn import_bundle(args: &Args, bundle: &Path) -> Result<PathBuf, CliError> {
+    let current_config = AgentControlConfigStore::new(Arc::new(config_repository(args)))
+        .load()
+        .map_err(|err| {
+            CliError::Precondition(format!("loading the current Agent Control config: {err}"))
+        })?;
+    let trusted_keys_path = current_config
+        .agent_packages
+        .trusted_keys_path
+        .ok_or_else(|| {
+            CliError::Precondition(
+                "applying a bundle requires a trusted key ring in the Agent Control config (agent_packages.trusted_keys_path)"
+                    .to_string(),
+            )
+        })?;
+    let trusted_keys = read_key_ring(&trusted_keys_path)
+        .map_err(|err| CliError::Precondition(format!("loading the trusted keys: {err}")))?;
+    offline::import_bundle(bundle, &trusted_keys, &args.remote_dir)
+        .map_err(|err| CliError::Command(format!("importing the offline bundle: {err}")))
+}
+
+/// Fails if Fleet Control stored a remote configuration for any of `agent_ids`, since it would
+/// take precedence over the local configuration being applied.
+fn ensure_no_remote_config<'a>(
+    args: &Args,
+    agent_ids: impl IntoIterator<Item = &'a AgentID>,
+) -> Result<(), CliError> {
+    let repository = config_repository(args);
+    for agent_id in agent_ids {
+        let remote_config = repository.get_remote_config(agent_id).map_err(|err| {
+            CliError::FileSystemError(format!("loading the remote config of '{agent_id}': {err}"))
+        })?;
+        if remote_config.is_some() {
+            return Err(CliError::Precondition(format!(
+                "a remote configuration from Fleet Control is active for '{agent_id}' and takes precedence over the local one"
+            )));
+        }
+    }
+    Ok(())
+}
+
+fn config_repository(args: &Args) -> impl ConfigRepository {
+    ConfigRepo::new(Arc::new(FileStore::new_local_fs(
+        args.local_dir.clone(),
+        args.remote_dir.clone(),
+    )))
+}
+
+fn local_config_path(local_dir: &Path, agent_id: impl AsRef<Path>) -> PathBuf {
+    local_dir
+        .join(FOLDER_NAME_LOCAL_DATA)
+        .join(agent_id)
+        .join(build_config_name(STORE_KEY_LOCAL_DATA_CONFIG))
+}
+
+/// Loads the `<agent-id>.yaml` files of `agents_dir`, holding the local configuration of each
+/// agent.
+fn load_agents_local_config(agents_dir: &Path) -> Result<Vec<(AgentID, ConfigSet)>, CliError> {
+    let entries = match fs::read_dir(agents_dir) {
+        Ok(entries) => entries,
+        Err(err) if err.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(err) => {
+            return Err(CliError::FileSystemError(format!(
+                "reading '{}': {err}",
+                agents_dir.display()
+            )));
+        }
+    };
+
+    let mut agents_config = Vec::new();
+    for file in entries.filter_map(|entry| entry.ok().map(|entry| entry.path())) {
+        let Some(agent_id) = file
+            .file_stem()
+            .and_then(|stem| stem.to_str())
+            .filter(|_| file.extension().is_some_and(|ext| ext == "yaml"))
+        else {
+            continue;
+        };
+        let agent_id = AgentID::try_from(agent_id).map_err(|err| {
+            CliError::InvalidConfig(format!("invalid agent id in '{}': {err}", file.display()))
+        })?;
+        agents_config.push((agent_id, load_config_set(&file)?));
+    }
+    Ok(agents_config)
+}
+
+/// Stores the local configuration of each agent. Returns whether any of them was written.
+fn store_agents_local_config(
+    local_dir: &Path,
+    agents_config: &[(AgentID, ConfigSet)],
+) -> Result<bool, CliError> {
+    let mut changed = false;
+    for (agent_id, config) in agents_config {
+        if store_local_config(&local_config_path(local_dir, agent_id), config)? {
+            info!(%agent_id, "Agent local configuration updated");
+            changed = true;
+        }
+    }
+    Ok(changed)
+}
+
+/// Reads `path`, merging every YAML file in name order when it is a directory.
+fn load_config_set(path: &Path) -> Result<ConfigSet, CliError> {
+    let files = if path.is_dir() {
+        let mut files = fs::read_dir(path)
+            .map_err(|err| {
+                CliError::FileSystemError(format!("reading '{}': {err}", path.display()))
+            })?
+            .filter_map(|entry| entry.ok().map(|entry| entry.path()))
+            .filter(|file| {
+                file.is_file()
+                    && file
+                        .extension()
+                        .is_some_and(|ext| ext == "yaml" || ext == "yml")
+            })
+            .collect::<Vec<_>>();
+        files.sort();
+        files
+    } else {
+        vec![path.to_path_buf()]
+    };
+
+    if files.is_empty() {
+        return Err(CliError::Precondition(format!(
+            "no configuration files found in '{}'",
+            path.display()
+        )));
+    }
+
+    let mut values = YAMLConfig::default();
+    let mut contents = Vec::with_capacity(files.len());
+    for file in &files {
+        let content = fs::read_to_string(file).map_err(|err| {
+            CliError::FileSystemError(format!("reading '{}': {err}", file.display()))
+        })?;
+        let config = YAMLConfig::try_from(content.as_str()).map_err(|err| {
+            CliError::InvalidConfig(format!("invalid YAML in '{}': {err}", file.display()))
+        })?;
+        values = YAMLConfig::merge_override(values, config);
+        contents.push(content);
+    }
+
+    // The text is only kept if it holds the merged values, e.g. an alias could refer to an
+    // anchor of an overridden key.
+    let merged_text = contents
+        .iter()
+        .map(|content| YAMLDocument::parse(content))
+        .collect::<Option<Vec<_>>>()
+        .and_then(|documents| documents.into_iter().reduce(YAMLDocument::merge_override))
+        .map(|document| document.to_string())
+        .filter(|content| YAMLConfig::try_from(content.as_str()).is_ok_and(|c| c == values));
+    let content = match merged_text {
+        Some(content) => content,
+        None => {
+            debug!(path = %path.display(), "Configuration set stored without its comments");
+            String::try_from(values.clone())
+                .map_err(|err| CliError::Command(format!("serializing configuration: {err}")))?
+        }
+    };
+
+    Ok(ConfigSet { values, content })
+}
+
+/// Writes the content of `config` to `path` unless it already holds it.
+/// Returns whether the file was written.
+///
+/// The content is written to a temporary file then renamed over `path`, so Agent Control never
+/// loads a partially written configuration.
+fn store_local_config(path: &Path, config: &ConfigSet) -> Result<bool, CliError> {
+    if fs::read_to_string(path).is_ok_and(|current| current == config.content) {
+        return Ok(false);
+    }
+
+    if let Some(parent) = path.parent() {
+        fs::create_dir_all(parent).map_err(|err| {
+            CliError::FileSystemError(format!("creating '{}': {err}", parent.display()))
+        })?;
+    }
+    let tmp_path = path.with_extension("yaml.tmp");
+    fs::write(&tmp_path, &config.content)
+        .and_then(|()| fs::rename(&tmp_path, path))
+        .map_err(|err| {
+            _ = fs::remove_file(&tmp_path);
+            CliError::FileSystemError(format!("writing '{}': {err}", path.display()))
+        })?;
+    Ok(true)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::opamp::remote_config::hash::{ConfigState, Hash};
+    use crate::resource_ownership::ResourceOwnership;
+    use crate::values::config::RemoteConfig;
+    use rstest::rstest;
+    use serde_json::json;
+    use tempfile::TempDir;
+
+    fn config_set(values: serde_json::Value) -> ConfigSet {
+        let values: YAMLConfig = serde_json::from_value(values).unwrap();
+        ConfigSet {
+            content: String::try_from(values.clone()).unwrap(),
+            values,
+        }
+    }
+
+    #[test]
+    fn test_load_config_set_merges_in_name_order() {
+        let tmp_dir = TempDir::new().unwrap();
+        fs::write(
+            tmp_dir.path().join("10-base.yaml"),
+            "agents: {}\n# audited\nlog:\n  level: info\n",
+        )
+        .unwrap();
+        fs::write(
+            tmp_dir.path().join("20-override.yml"),
+            "log:\n  level: debug # for the incident\n",
+        )
+        .unwrap();
+        fs::write(tmp_dir.path().join("README.md"), "ignored").unwrap();
+
+        let config = load_config_set(tmp_dir.path()).unwrap();
+        assert_eq!(
+            config.values,
+            serde_json::from_value(json!({"agents": {}, "log": {"level": "debug"}})).unwrap()
+        );
+        assert_eq!(
+            config.content,
+            "agents: {}\nlog:\n  level: debug # for the incident\n"
+        );
+    }
+
+    #[test]
+    fn test_load_config_set_keeps_comments_and_anchors() {
+        let tmp_dir = TempDir::new().unwrap();
+        let content = "# proxy pinned by ops\nproxy: &proxy http://proxy:8080\nfleet_control:\n  proxy: *proxy\n";
+        fs::write(tmp_dir.path().join("config.yaml"), content).unwrap();
+
+        assert_eq!(load_config_set(tmp_dir.path()).unwrap().content, content);
+    }
+
+    #[test]
+    fn test_load_config_set_falls_back_to_the_values() {
+        let tmp_dir = TempDir::new().unwrap();
+        // The alias refers to an anchor of an overridden key.
+        fs::write(
+            tmp_dir.path().join("10-base.yaml"),
+            "proxy: &proxy http://proxy:8080\nserver:\n  proxy: *proxy\n",
+        )
+        .unwrap();
+        fs::write(tmp_dir.path().join("20-override.yaml"), "proxy: other\n").unwrap();
+
+        let config = load_config_set(tmp_dir.path()).unwrap();
+        assert_eq!(
+            config.content,
+            String::try_from(config.values.clone()).unwrap()
+        );
+        assert_eq!(
+            config.values,
+            serde_json::from_value(
+                json!({"proxy": "other", "server": {"proxy": "http://proxy:8080"}})
+            )
+            .unwrap()
+        );
+    }
+
+    #[test]
+    fn test_load_config_set_empty_dir() {
+        let tmp_dir = TempDir::new().unwrap();
+        assert!(matches!(
+            load_config_set(tmp_dir.path()),
+            Err(CliError::Precondition(_))
+        ));
+    }
+
+    #[test]
+    fn test_store_agents_local_config() {
+        let tmp_dir = TempDir::new().unwrap();
+        let agents_dir = tmp_dir.path().join("agents");
+        fs::create_dir_all(&agents_dir).unwrap();
+        fs::write(agents_dir.join("nr-infra.yaml"), "config_agent: {}\n").unwrap();
+        fs::write(agents_dir.join("README.md"), "ignored").unwrap();
+        let local_dir = tmp_dir.path().join("local");
+
+        let agents_config = load_agents_local_config(&agents_dir).unwrap();
+        assert!(store_agents_local_config(&local_dir, &agents_config).unwrap());
+        assert!(!store_agents_local_config(&local_dir, &agents_config).unwrap());
+        assert!(local_config_path(&local_dir, "nr-infra").is_file());
+        assert!(
+            load_agents_local_config(&tmp_dir.path().join("none"))
+                .unwrap()
+                .is_empty()
+        );
+
+        fs::write(agents_dir.join("Invalid_ID.yaml"), "a: 1\n").unwrap();
+        assert!(matches!(
+            load_agents_local_config(&agents_dir),
+            Err(CliError::InvalidConfig(_))
+        ));
+    }
+
+    #[test]
+    fn test_import_bundle_requires_configured_trusted_keys() {
+        let tmp_dir = TempDir::new().unwrap();
+        let args = Args {
+            local_config: None,
+            bundle: Some(tmp_dir.path().join("bundle.tar.gz")),
+            local_dir: tmp_dir.path().join("local"),
+            remote_dir: tmp_dir.path().join("remote"),
+            control_socket: tmp_dir.path().join("ac.sock"),
+            timeout: Duration::from_secs(1),
+        };
+        store_local_config(
+            &local_config_path(&args.local_dir, AGENT_CONTROL_ID),
+            &config_set(json!({"agents": {}})),
+        )
+        .unwrap();
+
+        let err = import_bundle(&args, args.bundle.as_deref().unwrap()).unwrap_err();
+        assert!(
+            matches!(&err, CliError::Precondition(msg) if msg.contains("agent_packages.trusted_keys_path")),
+            "{err:?}"
+        );
+    }
+
+    #[test]
+    fn test_ensure_no_remote_config() {
+        let tmp_dir = TempDir::new().unwrap();
+        let args = Args {
+            local_config: Some(tmp_dir.path().join("config.yaml")),
+            bundle: None,
+            local_dir: tmp_dir.path().join("local"),
+            remote_dir: tmp_dir.path().join("remote"),
+            control_socket: tmp_dir.path().join("ac.sock"),
+            timeout: Duration::from_secs(1),
+        };
+        let agent_id = AgentID::try_from("nr-infra").unwrap();
+        ensure_no_remote_config(&args, [&AgentID::AgentControl, &agent_id]).unwrap();
+
+        let remote_config = RemoteConfig {
+            config: serde_json::from_value(json!({"agents": {}})).unwrap(),
+            hash: Hash::from("hash"),
+            state: ConfigState::Applied,
+        };
+        config_repository(&args)
+            .store_remote(&agent_id, ResourceOwnership::AgentControl, &remote_config)
+            .unwrap();
+        ensure_no_remote_config(&args, [&AgentID::AgentControl]).unwrap();
+        let err = ensure_no_remote_config(&args, [&AgentID::AgentControl, &agent_id]).unwrap_err();
+        assert!(
+            matches!(&err, CliError::Precondition(msg) if msg.contains("'nr-infra'")),
+            "{err:?}"
+        );
+    }
+
+    #[test]
+    fn test_store_local_config_is_idempotent() {
+        let tmp_dir = TempDir::new().unwrap();
+        let path = local_config_path(tmp_dir.path(), AGENT_CONTROL_ID);
+        let config = config_set(json!({"agents": {}}));
+
+        assert!(store_local_config(&path, &config).unwrap());
+        assert!(!store_local_config(&path, &config).unwrap());
+
+        let other = config_set(json!({"agents": {}, "a": 1}));
+        assert!(store_local_config(&path, &other).unwrap());
+        // The temporary file is renamed over the config.
+        assert!(!path.with_extension("yaml.tmp").exists());
+    }
+
+    #[test]
+    fn test_mark_apply_in_progress_records_previous_local_config() {
+        let tmp_dir = TempDir::new().unwrap();
+        let local_dir = tmp_dir.path().join("local");
+        let ac_path = local_config_path(&local_dir, AGENT_CONTROL_ID);
+        store_local_config(&ac_path, &config_set(json!({"agents": {}}))).unwrap();
+        let marker = ConfigApplyMarker::from(Arc::new(FileStore::new_local_fs(
+            local_dir.clone(),
+            tmp_dir.path().join("remote"),
+        )));
+
+        mark_apply_in_progress(&marker, &local_dir, [AGENT_CONTROL_ID, "nr-infra"]).unwrap();
+
+        let expected = ConfigApplyInProgress::Local {
+            previous: vec![
+                LocalConfigFile {
+                    content: Some(fs::read_to_string(&ac_path).unwrap()),
+                    path: ac_path,
+                },
+                LocalConfigFile {
+                    path: local_config_path(&local_dir, "nr-infra"),
+                    content: None,
+                },
+            ],
+        };
+        assert_eq!(marker.get().unwrap(), Some(expected));
+    }
+
+    #[rstest]
+    #[case::healthy(json!({"healthy": true, "agents": {"nr-infra": {"healthy": true}}}), None)]
+    #[case::agent_control_unhealthy(
+        json!({"healthy": false, "last_error": "boom", "agents": {"nr-infra": {"healthy": true}}}),
+        Some("Agent Control is unhealthy: boom")
+    )]
+    #[case::agent_unhealthy(
+        json!({"healthy": true, "agents": {"nr-infra": {"healthy": false, "last_error": "exited"}}}),
+        Some("'nr-infra' is unhealthy: exited")
+    )]
+    #[case::agent_not_reported(
+        json!({"healthy": true, "agents": {}}),
+        Some("'nr-infra' didn't report its health yet")
+    )]
+    fn test_pending_health(#[case] health: Value, #[case] expected: Option<&str>) {
+        assert_eq!(
+            pending_health(&health, &["nr-infra".to_string()]).as_deref(),
+            expected
+        );
+    }
+
+    #[test]
+    fn test_reloaded_agents() {
+        let result = json!({"agents": ["nr-infra", "nr-otel"]});
+        assert_eq!(
+            reloaded_agents(Some(&result)),
+            vec!["nr-infra".to_string(), "nr-otel".to_string()]
+        );
+        assert!(reloaded_agents(None).is_empty());
+    }
+}
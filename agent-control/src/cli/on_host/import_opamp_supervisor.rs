@@ -0,0 +1,355 @@
+//! Implementation of the import-opamp-supervisor command for the on-host cli.
+//!
+//! Migrates a collector managed by the upstream OpenTelemetry opamp-supervisor to Agent Control.
+//! The instance uid and the effective collector configuration kept in the supervisor storage
+//! directory are imported as the instance id and the local configuration of an agent, so it is
+//! reported to Fleet Control as the same instance and keeps running the same configuration.
+use crate::agent_control::agent_id::AgentID;
+use crate::agent_control::config::AgentControlConfig;
+use crate::agent_control::defaults::{FOLDER_NAME_LOCAL_DATA, STORE_KEY_LOCAL_DATA_CONFIG};
+use crate::agent_control::run::BasePaths;
+use crate::agent_control::run::on_host::ac_identifiers;
+use crate::cli::common::error::CliError;
+use crate::on_host::file_store::{FileStore, build_config_name};
+use crate::opamp::instance_id::InstanceID;
+use crate::opamp::instance_id::getter::DataStored;
+use crate::opamp::instance_id::on_host::identifiers::Identifiers;
+use crate::opamp::instance_id::storer::{InstanceIDStorer, Storer};
+use crate::values::yaml_config::YAMLConfig;
+use opamp_client::operation::instance_uid::InstanceUid;
+use serde::Deserialize;
+use serde_json::{Value, json};
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+use tracing::{debug, info};
+
+/// State file of the opamp-supervisor, holding the instance uid.
+const PERSISTENT_STATE_FILE_NAME: &str = "persistent_state.yaml";
+/// Last effective configuration of the collector written by the opamp-supervisor.
+const EFFECTIVE_CONFIG_FILE_NAME: &str = "effective.yaml";
+/// Extension the opamp-supervisor adds to the collector configuration to connect to it.
+const OPAMP_EXTENSION: &str = "opamp";
+/// Variable of the collector agent types holding the collector configuration.
+const COLLECTOR_CONFIG_VARIABLE: &str = "config";
+
+/// Imports the state of an opamp-supervisor as an Agent Control agent.
+#[derive(Debug, clap::Parser)]
+pub struct Args {
+    /// Storage directory of the opamp-supervisor (its `storage.directory` setting).
+    #[arg(long, required = true)]
+    storage_dir: PathBuf,
+
+    /// Id of the agent running the collector in Agent Control.
+    #[arg(long, required = true)]
+    agent_id: String,
+
+    /// Agent Control local data directory.
+    #[arg(long, default_value_os_t = BasePaths::default().local_dir)]
+    local_dir: PathBuf,
+
+    /// Agent Control remote data directory.
+    #[arg(long, default_value_os_t = BasePaths::default().remote_dir)]
+    remote_dir: PathBuf,
+
+    /// Replaces the local configuration of the agent if it already has one.
+    #[arg(long)]
+    overwrite: bool,
+}
+
+#[derive(Debug, Deserialize)]
+struct PersistentState {
+    instance_id: String,
+}
+
+/// State kept by the opamp-supervisor in its storage directory.
+#[derive(Debug, Default, PartialEq)]
+struct SupervisorState {
+    instance_id: Option<InstanceID>,
+    effective_config: Option<Value>,
+}
+
+/// Imports the instance uid and the effective configuration of the opamp-supervisor as the
+/// instance id and the local configuration of the agent.
+pub fn import(args: Args) -> Result<(), CliError> {
+    let agent_id = AgentID::try_from(args.agent_id.as_str())
+        .map_err(|err| CliError::InvalidConfig(format!("invalid agent id: {err}")))?;
+    let state = load_state(&args.storage_dir)?;
+
+    let identifiers = state
+        .instance_id
+        .is_some()
+        .then(|| {
+            let config = load_agent_control_config(&args.local_dir)?;
+            ac_identifiers(&config, &args.remote_dir, false)
+                .map_err(|err| CliError::Precondition(err.to_string()))
+        })
+        .transpose()?;
+
+    store_state(
+        state,
+        &agent_id,
+        identifiers,
+        &args.local_dir,
+        &args.remote_dir,
+        args.overwrite,
+    )?;
+
+    info!(
+        "Imported the opamp-supervisor state as agent '{agent_id}'. Add it to the Agent Control agents with a collector agent type to run it"
+    );
+    Ok(())
+}
+
+/// Reads the state kept in the opamp-supervisor storage directory.
+fn load_state(storage_dir: &Path) -> Result<SupervisorState, CliError> {
+    let instance_id = read_optional(&storage_dir.join(PERSISTENT_STATE_FILE_NAME))?
+        .map(|content| {
+            let state: PersistentState = serde_saphyr::from_str(&content).map_err(|err| {
+                CliError::InvalidConfig(format!("invalid opamp-supervisor state: {err}"))
+            })?;
+            parse_instance_id(&state.instance_id)
+        })
+        .transpose()?;
+
+    let effective_config = read_optional(&storage_dir.join(EFFECTIVE_CONFIG_FILE_NAME))?
+        .map(|content| {
+            let mut config: Value = serde_saphyr::from_str(&content).map_err(|err| {
+                CliError::InvalidConfig(format!("invalid effective configuration: {err}"))
+            })?;
+            remove_opamp_extension(&mut config);
+            Ok::<_, CliError>(config)
+        })
+        .transpose()?;
+
+    let state = SupervisorState {
+        instance_id,
+        effective_config,
+    };
+    if state == SupervisorState::default() {
+        return Err(CliError::Precondition(format!(
+            "no opamp-supervisor state found in '{}'",
+            storage_dir.display()
+        )));
+    }
+    Ok(state)
+}
+
+/// Parses the instance uid, persisted by the opamp-supervisor as a UUID.
+fn parse_instance_id(instance_id: &str) -> Result<InstanceID, CliError> {
+    let hex = instance_id.trim().replace('-', "").to_uppercase();
+    InstanceUid::try_from(hex)
+        .map(InstanceID::from)
+        .map_err(|err| CliError::InvalidConfig(format!("invalid instance uid: {err}")))
+}
+
+/// Removes the extension the opamp-supervisor adds to the collector configuration, as the
+/// collector doesn't connect to Agent Control.
+fn remove_opamp_extension(config: &mut Value) {
+    if let Some(extensions) = config.get_mut("extensions").and_then(Value::as_object_mut) {
+        extensions.remove(OPAMP_EXTENSION);
+    }
+    if let Some(enabled) = config
+        .pointer_mut("/service/extensions")
+        .and_then(Value::as_array_mut)
+    {
+        enabled.retain(|extension| extension.as_str() != Some(OPAMP_EXTENSION));
+    }
+}
+
+/// Loads the local configuration of Agent Control from `local_dir`.
+pub(crate) fn load_agent_control_config(local_dir: &Path) -> Result<AgentControlConfig, CliError> {
+    let path = local_config_path(local_dir, &AgentID::AgentControl);
+    let content = read_optional(&path)?.ok_or_else(|| {
+        CliError::Precondition(format!(
+            "Agent Control configuration '{}' not found",
+            path.display()
+        ))
+    })?;
+    YAMLConfig::try_from(content)
+        .map_err(|err| err.to_string())
+        .and_then(|config| AgentControlConfig::try_from(config).map_err(|err| err.to_string()))
+        .map_err(CliError::InvalidConfig)
+}
+
+/// Stores the instance id, bound to the host `identifiers`, and the local configuration of the
+/// agent.
+fn store_state(
+    state: SupervisorState,
+    agent_id: &AgentID,
+    identifiers: Option<Identifiers>,
+    local_dir: &Path,
+    remote_dir: &Path,
+    overwrite: bool,
+) -> Result<(), CliError> {
+    if let Some(config) = state.effective_config {
+        let path = local_config_path(local_dir, agent_id);
+        if path.exists() && !overwrite {
+            return Err(CliError::Precondition(format!(
+                "agent '{agent_id}' already has a local configuration, use --overwrite to replace it"
+            )));
+        }
+        let values: YAMLConfig =
+            serde_json::from_value(json!({ COLLECTOR_CONFIG_VARIABLE: config }))
+                .map_err(|err| CliError::InvalidConfig(err.to_string()))?;
+        let content = String::try_from(values)
+            .map_err(|err| CliError::Command(format!("serializing configuration: {err}")))?;
+        if let Some(parent) = path.parent() {
+            fs::create_dir_all(parent).map_err(|err| {
+                CliError::FileSystemError(format!("creating '{}': {err}", parent.display()))
+            })?;
+        }
+        fs::write(&path, content).map_err(|err| {
+            CliError::FileSystemError(format!("writing '{}': {err}", path.display()))
+        })?;
+        debug!("Local configuration written to '{}'", path.display());
+    }
+
+    if let (Some(instance_id), Some(identifiers)) = (state.instance_id, identifiers) {
+        let storer: Storer<_, Identifiers> = Storer::from(Arc::new(FileStore::new_local_fs(
+            local_dir.to_path_buf(),
+            remote_dir.to_path_buf(),
+        )));
+        storer
+            .set(
+                agent_id,
+                &DataStored {
+                    instance_id: instance_id.clone(),
+                    identifiers,
+                },
+            )
+            .map_err(|err| CliError::FileSystemError(format!("storing instance id: {err}")))?;
+        debug!("Instance id {instance_id} stored");
+    }
+    Ok(())
+}
+
+fn local_config_path(local_dir: &Path, agent_id: &AgentID) -> PathBuf {
+    local_dir
+        .join(FOLDER_NAME_LOCAL_DATA)
+        .join(agent_id)
+        .join(build_config_name(STORE_KEY_LOCAL_DATA_CONFIG))
+}
+
+fn read_optional(path: &Path) -> Result<Option<String>, CliError> {
+    match fs::read_to_string(path) {
+        Ok(content) => Ok(Some(content)),
+        Err(err) if err.kind() == io::ErrorKind::NotFound => Ok(None),
+        Err(err) => Err(CliError::FileSystemError(format!(
+            "reading '{}': {err}",
+            path.display()
+        ))),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    const INSTANCE_UID: &str = "0190592a-8287-7fb1-a6d9-1ecaa57032bd";
+    const EFFECTIVE_CONFIG: &str = r#"
+extensions:
+  health_check: {}
+  opamp:
+    server:
+      ws:
+        endpoint: ws://127.0.0.1:4320/v1/opamp
+receivers:
+  otlp: {}
+service:
+  extensions: [health_check, opamp]
+"#;
+
+    fn supervisor_storage() -> TempDir {
+        let storage_dir = TempDir::new().unwrap();
+        fs::write(
+            storage_dir.path().join(PERSISTENT_STATE_FILE_NAME),
+            format!("instance_id: {INSTANCE_UID}\n"),
+        )
+        .unwrap();
+        fs::write(
+            storage_dir.path().join(EFFECTIVE_CONFIG_FILE_NAME),
+            EFFECTIVE_CONFIG,
+        )
+        .unwrap();
+        storage_dir
+    }
+
+    #[test]
+    fn test_load_state() {
+        let storage_dir = supervisor_storage();
+
+        let state = load_state(storage_dir.path()).unwrap();
+        assert_eq!(
+            state.instance_id.unwrap().to_string(),
+            "0190592A82877FB1A6D91ECAA57032BD"
+        );
+        assert_eq!(
+            state.effective_config.unwrap(),
+            json!({
+                "extensions": {"health_check": {}},
+                "receivers": {"otlp": {}},
+                "service": {"extensions": ["health_check"]},
+            })
+        );
+    }
+
+    #[test]
+    fn test_load_state_without_state() {
+        let storage_dir = TempDir::new().unwrap();
+        assert!(matches!(
+            load_state(storage_dir.path()),
+            Err(CliError::Precondition(_))
+        ));
+    }
+
+    #[test]
+    fn test_store_state() {
+        let storage_dir = supervisor_storage();
+        let tmp_dir = TempDir::new().unwrap();
+        let (local_dir, remote_dir) = (tmp_dir.path().join("local"), tmp_dir.path().join("remote"));
+        let agent_id = AgentID::try_from("otel-collector").unwrap();
+        let identifiers = Identifiers {
+            host_id: "host-id".to_string(),
+            ..Default::default()
+        };
+
+        let state = load_state(storage_dir.path()).unwrap();
+        store_state(
+            state,
+            &agent_id,
+            Some(identifiers.clone()),
+            &local_dir,
+            &remote_dir,
+            false,
+        )
+        .unwrap();
+
+        let values = fs::read_to_string(local_config_path(&local_dir, &agent_id)).unwrap();
+        let values = YAMLConfig::try_from(values).unwrap();
+        assert_eq!(
+            values.get(COLLECTOR_CONFIG_VARIABLE).unwrap()["receivers"],
+            json!({"otlp": {}})
+        );
+
+        let storer: Storer<_, Identifiers> = Storer::from(Arc::new(FileStore::new_local_fs(
+            local_dir.clone(),
+            remote_dir.clone(),
+        )));
+        let stored = storer.get(&agent_id).unwrap().unwrap();
+        assert_eq!(stored.identifiers, identifiers);
+        assert_eq!(
+            stored.instance_id.to_string(),
+            "0190592A82877FB1A6D91ECAA57032BD"
+        );
+
+        // The local configuration is not replaced unless requested
+        let state = load_state(storage_dir.path()).unwrap();
+        assert!(matches!(
+            store_state(state, &agent_id, None, &local_dir, &remote_dir, false),
+            Err(CliError::Precondition(_))
+        ));
+    }
+}
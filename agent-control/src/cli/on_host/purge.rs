@@ -0,0 +1,317 @@
+//! Implementation of the purge command for the on-host cli.
+//!
+//! Stops the agents supervised by a running Agent Control and removes the configuration, packages,
+//! state and logs it manages, so uninstalling it leaves no orphaned processes or data behind.
+//! The identity of the host (the Fleet Control auth key and the instance ids of the agents) can be
+//! kept, so a later installation is reported as the same instance.
+//!
+//! Only the entries Agent Control creates are removed: the local directory can also hold the
+//! installed binaries (e.g. `C:\Program Files\New Relic\newrelic-agent-control` on Windows).
+use crate::agent_control::defaults::{
+    AGENT_FILESYSTEM_FOLDER_NAME, AUTH_CLIENT_ID_FILE_NAME, AUTH_PRIVATE_KEY_FILE_NAME,
+    CLOUD_INSTANCE_ID_CACHE_FILE_NAME, DYNAMIC_AGENT_TYPES_DIR, ENVIRONMENT_VARIABLES_FILE_NAME,
+    FOLDER_NAME_FLEET_DATA, FOLDER_NAME_LOCAL_DATA, INSTANCE_ID_FILENAME,
+    OFFLINE_BUNDLE_FOLDER_NAME, PACKAGES_FOLDER_NAME, SHARED_FILESYSTEM_FOLDER_NAME,
+};
+use crate::agent_control::run::BasePaths;
+use crate::cli::common::error::CliError;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+use tracing::{debug, info};
+
+const DEFAULT_STOP_TIMEOUT: &str = "70s";
+
+/// Entries of the local directory managed by Agent Control.
+const LOCAL_DIR_ENTRIES: [&str; 4] = [
+    FOLDER_NAME_LOCAL_DATA,
+    DYNAMIC_AGENT_TYPES_DIR,
+    ENVIRONMENT_VARIABLES_FILE_NAME,
+    AUTH_PRIVATE_KEY_FILE_NAME,
+];
+
+/// Entries of the remote directory managed by Agent Control. The pid file and the control socket
+/// belong to the running Agent Control, which removes them when it exits. The auth key and client
+/// id are the ones of the identity created on enrollment.
+const REMOTE_DIR_ENTRIES: [&str; 8] = [
+    FOLDER_NAME_FLEET_DATA,
+    AGENT_FILESYSTEM_FOLDER_NAME,
+    SHARED_FILESYSTEM_FOLDER_NAME,
+    PACKAGES_FOLDER_NAME,
+    OFFLINE_BUNDLE_FOLDER_NAME,
+    CLOUD_INSTANCE_ID_CACHE_FILE_NAME,
+    AUTH_PRIVATE_KEY_FILE_NAME,
+    AUTH_CLIENT_ID_FILE_NAME,
+];
+
+/// Removes the data managed by Agent Control.
+#[derive(Debug, clap::Parser)]
+pub struct Args {
+    /// Agent Control local data directory.
+    #[arg(long, default_value_os_t = BasePaths::default().local_dir)]
+    local_dir: PathBuf,
+
+    /// Agent Control remote data directory.
+    #[arg(long, default_value_os_t = BasePaths::default().remote_dir)]
+    remote_dir: PathBuf,
+
+    /// Agent Control logs directory.
+    #[arg(long, default_value_os_t = BasePaths::default().log_dir)]
+    log_dir: PathBuf,
+
+    /// Path of the Agent Control control socket.
+    #[cfg(target_family = "unix")]
+    #[arg(long, default_value_os_t = default_control_socket())]
+    control_socket: PathBuf,
+
+    /// Maximum time to wait for the running Agent Control to stop its agents.
+    #[arg(long, default_value = DEFAULT_STOP_TIMEOUT, value_parser = parse_duration_arg)]
+    timeout: Duration,
+
+    /// Keeps the Fleet Control auth key and the instance ids of the agents.
+    #[arg(long)]
+    keep_identity: bool,
+}
+
+#[cfg(target_family = "unix")]
+fn default_control_socket() -> PathBuf {
+    use crate::agent_control::defaults::CONTROL_SOCKET_FILE_NAME;
+
+    BasePaths::default().state_file(CONTROL_SOCKET_FILE_NAME)
+}
+
+// helper needed because the arguments from the duration_str's parse function and the one expected by the clap
+// `value_parser` argument have incompatible lifetimes.
+fn parse_duration_arg(arg: &str) -> Result<Duration, String> {
+    duration_str::parse(arg)
+}
+
+/// Stops the agents of the running Agent Control, if any, and removes the data it manages.
+pub fn purge(args: Args) -> Result<(), CliError> {
+    #[cfg(target_family = "unix")]
+    stop_agents(&args.control_socket, args.timeout)?;
+    #[cfg(target_family = "windows")]
+    stop_agents(args.timeout)?;
+
+    let keep = if args.keep_identity {
+        identity_files(&args.local_dir, &args.remote_dir)?
+    } else {
+        Vec::new()
+    };
+    for (dir, entries) in [
+        (&args.local_dir, LOCAL_DIR_ENTRIES.as_slice()),
+        (&args.remote_dir, REMOTE_DIR_ENTRIES.as_slice()),
+    ] {
+        for entry in entries {
+            remove_entry(&dir.join(entry), &keep)?;
+        }
+    }
+    // Every file in the logs directory is written by Agent Control and its agents.
+    debug!("Removing the contents of '{}'", args.log_dir.display());
+    remove_dir_contents(&args.log_dir, &keep)?;
+
+    info!("Agent Control data removed");
+    Ok(())
+}
+
+/// Pauses the running Agent Control, which stops every agent it supervises.
+#[cfg(target_family = "unix")]
+fn stop_agents(control_socket: &Path, timeout: Duration) -> Result<(), CliError> {
+    use crate::agent_control::control_socket::client::send_command;
+    use crate::agent_control::control_socket::protocol::ControlCommand;
+
+    if !control_socket.exists() {
+        debug!("Agent Control is not running, no agents to stop");
+        return Ok(());
+    }
+    let response = send_command(control_socket, &ControlCommand::Pause, timeout)
+        .map_err(|err| CliError::Command(format!("stopping the agents: {err}")))?;
+    if !response.ok {
+        return Err(CliError::Command(format!(
+            "Agent Control could not stop the agents: {}",
+            response.error.unwrap_or_default()
+        )));
+    }
+    info!("Agents stopped");
+    Ok(())
+}
+
+/// Stops the Agent Control Windows service, which stops every agent it supervises.
+#[cfg(target_family = "windows")]
+fn stop_agents(timeout: Duration) -> Result<(), CliError> {
+    use crate::command::windows::{WINDOWS_SERVICE_NAME, stop_scm_service};
+
+    let stopped = stop_scm_service(WINDOWS_SERVICE_NAME, timeout)
+        .map_err(|err| CliError::Command(format!("stopping the agents: {err}")))?;
+    if stopped {
+        info!("Agents stopped");
+    } else {
+        debug!("Agent Control is not running, no agents to stop");
+    }
+    Ok(())
+}
+
+/// Returns the files holding the identity of the host: the Fleet Control auth key, provisioned or
+/// created on enrollment along with its client id, the cached cloud instance id and the instance id
+/// of every agent.
+fn identity_files(local_dir: &Path, remote_dir: &Path) -> Result<Vec<PathBuf>, CliError> {
+    let mut files = vec![
+        local_dir.join(AUTH_PRIVATE_KEY_FILE_NAME),
+        remote_dir.join(AUTH_PRIVATE_KEY_FILE_NAME),
+        remote_dir.join(AUTH_CLIENT_ID_FILE_NAME),
+        remote_dir.join(CLOUD_INSTANCE_ID_CACHE_FILE_NAME),
+    ];
+    let fleet_data_dir = remote_dir.join(FOLDER_NAME_FLEET_DATA);
+    match fs::read_dir(&fleet_data_dir) {
+        Ok(entries) => files.extend(
+            entries
+                .filter_map(|entry| entry.ok())
+                .map(|entry| entry.path().join(INSTANCE_ID_FILENAME)),
+        ),
+        Err(err) if err.kind() == io::ErrorKind::NotFound => {}
+        Err(err) => return Err(fs_error("reading", &fleet_data_dir, err)),
+    }
+    Ok(files)
+}
+
+/// Removes everything inside `dir` but the `keep` paths, leaving `dir` in place.
+fn remove_dir_contents(dir: &Path, keep: &[PathBuf]) -> Result<(), CliError> {
+    let entries = match fs::read_dir(dir) {
+        Ok(entries) => entries,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(()),
+        Err(err) => return Err(fs_error("reading", dir, err)),
+    };
+    for entry in entries {
+        let path = entry.map_err(|err| fs_error("reading", dir, err))?.path();
+        remove_entry(&path, keep)?;
+    }
+    Ok(())
+}
+
+/// Removes the file or directory at `path`, if any, but the `keep` paths inside it.
+fn remove_entry(path: &Path, keep: &[PathBuf]) -> Result<(), CliError> {
+    if keep.iter().any(|kept| kept == path) {
+        return Ok(());
+    }
+    let metadata = match fs::symlink_metadata(path) {
+        Ok(metadata) => metadata,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(()),
+        Err(err) => return Err(fs_error("reading", path, err)),
+    };
+    // Directories holding kept files are emptied instead of removed.
+    let is_dir = metadata.is_dir();
+    if is_dir && keep.iter().any(|kept| kept.starts_with(path)) {
+        return remove_dir_contents(path, keep);
+    }
+    debug!("Removing '{}'", path.display());
+    let removed = if is_dir {
+        fs::remove_dir_all(path)
+    } else {
+        fs::remove_file(path)
+    };
+    removed.map_err(|err| fs_error("removing", path, err))
+}
+
+fn fs_error(action: &str, path: &Path, err: io::Error) -> CliError {
+    CliError::FileSystemError(format!("{action} '{}': {err}", path.display()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_control::defaults::{AGENT_CONTROL_ID, PACKAGES_FOLDER_NAME};
+    use tempfile::TempDir;
+
+    fn write(path: &Path) {
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(path, "content").unwrap();
+    }
+
+    fn args(tmp_dir: &TempDir, keep_identity: bool) -> Args {
+        Args {
+            local_dir: tmp_dir.path().join("local"),
+            remote_dir: tmp_dir.path().join("remote"),
+            log_dir: tmp_dir.path().join("log"),
+            #[cfg(target_family = "unix")]
+            control_socket: tmp_dir.path().join("missing.sock"),
+            timeout: Duration::from_secs(1),
+            keep_identity,
+        }
+    }
+
+    #[test]
+    fn test_purge() {
+        let tmp_dir = TempDir::new().unwrap();
+        let args = args(&tmp_dir, false);
+        write(
+            &args
+                .local_dir
+                .join("local-data/agent-control/local_config.yaml"),
+        );
+        write(&args.local_dir.join(AUTH_PRIVATE_KEY_FILE_NAME));
+        write(&args.remote_dir.join(PACKAGES_FOLDER_NAME).join("agent/bin"));
+        write(&args.log_dir.join("newrelic-agent-control.log"));
+        let dirs = [args.remote_dir.clone(), args.log_dir.clone()];
+        // Not managed by Agent Control, like the installed binaries.
+        let binary = args.local_dir.join("newrelic-agent-control.exe");
+        write(&binary);
+
+        purge(args).unwrap();
+
+        for dir in dirs {
+            assert!(dir.exists());
+            assert_eq!(fs::read_dir(dir).unwrap().count(), 0);
+        }
+        assert_eq!(fs::read_dir(binary.parent().unwrap()).unwrap().count(), 1);
+        assert!(binary.exists());
+    }
+
+    #[test]
+    fn test_purge_keeping_identity() {
+        let tmp_dir = TempDir::new().unwrap();
+        let args = args(&tmp_dir, true);
+        let auth_key = args.local_dir.join(AUTH_PRIVATE_KEY_FILE_NAME);
+        let fleet_data_dir = args.remote_dir.join(FOLDER_NAME_FLEET_DATA);
+        let instance_ids = [
+            fleet_data_dir
+                .join(AGENT_CONTROL_ID)
+                .join(INSTANCE_ID_FILENAME),
+            fleet_data_dir.join("agent").join(INSTANCE_ID_FILENAME),
+        ];
+        let remote_config = fleet_data_dir.join("agent/remote_config.yaml");
+        write(&auth_key);
+        write(
+            &args
+                .local_dir
+                .join("local-data/agent-control/local_config.yaml"),
+        );
+        write(&remote_config);
+        write(&args.remote_dir.join(PACKAGES_FOLDER_NAME).join("agent/bin"));
+        instance_ids.iter().for_each(|path| write(path));
+        let cloud_id_cache = args.remote_dir.join(CLOUD_INSTANCE_ID_CACHE_FILE_NAME);
+        write(&cloud_id_cache);
+        let enrolled_identity = [
+            args.remote_dir.join(AUTH_PRIVATE_KEY_FILE_NAME),
+            args.remote_dir.join(AUTH_CLIENT_ID_FILE_NAME),
+        ];
+        enrolled_identity.iter().for_each(|path| write(path));
+        let remote_dir = args.remote_dir.clone();
+
+        purge(args).unwrap();
+
+        assert!(auth_key.exists());
+        assert!(cloud_id_cache.exists());
+        assert!(enrolled_identity.iter().all(|path| path.exists()));
+        assert!(instance_ids.iter().all(|path| path.exists()));
+        assert!(!remote_config.exists());
+        assert!(!remote_dir.join(PACKAGES_FOLDER_NAME).exists());
+        assert_eq!(fs::read_dir(auth_key.parent().unwrap()).unwrap().count(), 1);
+    }
+
+    #[test]
+    fn test_purge_missing_dirs() {
+        let tmp_dir = TempDir::new().unwrap();
+        purge(args(&tmp_dir, true)).unwrap();
+    }
+}
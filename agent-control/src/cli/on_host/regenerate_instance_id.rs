@@ -0,0 +1,101 @@
+//! Implementation of the regenerate-instance-id command for the on-host cli.
+//!
+//! Replaces the persisted instance id of Agent Control or one of its agents, so it is reported to
+//! Fleet Control as a new instance, e.g. after cloning a host image that already had an identity.
+//! The running Agent Control keeps using the previous id until it is restarted.
+use crate::agent_control::agent_id::AgentID;
+use crate::agent_control::run::BasePaths;
+use crate::agent_control::run::on_host::ac_identifiers;
+use crate::cli::common::error::CliError;
+use crate::cli::on_host::import_opamp_supervisor::load_agent_control_config;
+use crate::on_host::file_store::FileStore;
+use crate::opamp::instance_id::InstanceID;
+use crate::opamp::instance_id::getter::InstanceIDWithIdentifiersGetter;
+use crate::opamp::instance_id::on_host::identifiers::Identifiers;
+use crate::opamp::instance_id::storer::Storer;
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+use tracing::info;
+
+/// Replaces the persisted instance id of Agent Control or one of its agents.
+#[derive(Debug, clap::Parser)]
+pub struct Args {
+    /// Id of the agent whose instance id is replaced. Defaults to Agent Control itself.
+    #[arg(long)]
+    agent_id: Option<String>,
+
+    /// Agent Control local data directory.
+    #[arg(long, default_value_os_t = BasePaths::default().local_dir)]
+    local_dir: PathBuf,
+
+    /// Agent Control remote data directory.
+    #[arg(long, default_value_os_t = BasePaths::default().remote_dir)]
+    remote_dir: PathBuf,
+}
+
+/// Persists a new instance id for the agent, bound to the identifiers of the host.
+pub fn regenerate(args: Args) -> Result<(), CliError> {
+    let agent_id = match &args.agent_id {
+        Some(agent_id) => AgentID::try_from(agent_id.as_str())
+            .map_err(|err| CliError::InvalidConfig(format!("invalid agent id: {err}")))?,
+        None => AgentID::AgentControl,
+    };
+    let config = load_agent_control_config(&args.local_dir)?;
+    let identifiers = ac_identifiers(&config, &args.remote_dir, false)
+        .map_err(|err| CliError::Precondition(err.to_string()))?;
+
+    let instance_id =
+        regenerate_instance_id(&agent_id, identifiers, &args.local_dir, &args.remote_dir)?;
+
+    info!(
+        "Instance id of '{agent_id}' regenerated: {instance_id}. Restart Agent Control to report it"
+    );
+    Ok(())
+}
+
+fn regenerate_instance_id(
+    agent_id: &AgentID,
+    identifiers: Identifiers,
+    local_dir: &Path,
+    remote_dir: &Path,
+) -> Result<InstanceID, CliError> {
+    let storer: Storer<_, Identifiers> = Storer::from(Arc::new(FileStore::new_local_fs(
+        local_dir.to_path_buf(),
+        remote_dir.to_path_buf(),
+    )));
+    InstanceIDWithIdentifiersGetter::new(Arc::new(storer), identifiers)
+        .regenerate(agent_id)
+        .map_err(|err| CliError::FileSystemError(format!("storing instance id: {err}")))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::opamp::instance_id::getter::InstanceIDGetter;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_regenerate_instance_id() {
+        let tmp_dir = TempDir::new().unwrap();
+        let local_dir = tmp_dir.path().join("local");
+        let remote_dir = tmp_dir.path().join("remote");
+        let agent_id = AgentID::try_from("otel-collector").unwrap();
+        let storer: Arc<Storer<_, Identifiers>> = Arc::new(Storer::from(Arc::new(
+            FileStore::new_local_fs(local_dir.clone(), remote_dir.clone()),
+        )));
+        let getter = InstanceIDWithIdentifiersGetter::new(storer, Identifiers::default());
+        let instance_id = getter.get(&agent_id).unwrap();
+        let other_instance_id = getter.get(&AgentID::AgentControl).unwrap();
+
+        let regenerated =
+            regenerate_instance_id(&agent_id, Identifiers::default(), &local_dir, &remote_dir)
+                .unwrap();
+
+        assert_ne!(regenerated, instance_id);
+        assert_eq!(getter.get(&agent_id).unwrap(), regenerated);
+        assert_eq!(
+            getter.get(&AgentID::AgentControl).unwrap(),
+            other_instance_id
+        );
+    }
+}
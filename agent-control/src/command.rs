@@ -9,12 +9,15 @@ use crate::agent_control::{
     config_repository::{repository::AgentControlConfigLoader, store::AgentControlConfigStore},
     run::BasePaths,
 };
+use crate::command::exit_status::{ExitError, ExitStatus};
 use crate::command::on_host_checks::config::check_config;
 use crate::command::on_host_checks::opamp::check_connectivity;
+use crate::command::on_host_checks::preflight::preflight;
 use crate::environment::Environment;
 use crate::event::ApplicationEvent;
 use crate::event::channel::{EventConsumer, EventPublisher, pub_sub};
 use crate::instrumentation::config::logs::config::LoggingConfig;
+use crate::instrumentation::config::logs::level_reload::LogLevelReloader;
 use crate::instrumentation::tracing::{
     TracingConfig, TracingGuardBox, try_init_stderr_tracing, try_init_tracing,
 };
@@ -29,6 +32,7 @@ use std::process::ExitCode;
 use std::sync::Arc;
 use tracing::{error, info};
 
+pub mod exit_status;
 mod on_host_checks;
 
 #[cfg(target_os = "windows")]
@@ -52,11 +56,11 @@ pub enum InitError {
     about = "New Relic Agent Control\n\
                   When run without a subcommand, starts the agent control as a long-running process \
                   that monitors and manages agents.\n\
-                  Use 'verify' or 'version' subcommands for specific tasks.",
+                  Use 'verify', 'preflight' or 'version' subcommands for specific tasks.",
     long_about = "New Relic Agent Control\n\
                   When run without a subcommand, starts the agent control as a long-running process \
                   that monitors and manages agents.\n\
-                  Use 'verify' or 'version' subcommands for specific tasks."
+                  Use 'verify', 'preflight' or 'version' subcommands for specific tasks."
 )]
 pub struct Command {
     /// The subcommand to execute. Defaults to `Run` if not specified for backward compatibility.
@@ -75,6 +79,8 @@ pub enum SubCommand {
     Version,
     /// Verify the agent control configuration and ability to be run
     Verify,
+    /// Check the host is ready to run agent control, writing a JSON report of each check
+    Preflight,
 }
 
 impl Display for SubCommand {
@@ -82,6 +88,7 @@ impl Display for SubCommand {
         let arg = match self {
             SubCommand::Version => format!("{:?}", self).to_lowercase(),
             SubCommand::Verify => format!("{:?}", self).to_lowercase(),
+            SubCommand::Preflight => format!("{:?}", self).to_lowercase(),
         };
         write!(f, "{}", arg)
     }
@@ -90,6 +97,11 @@ impl Display for SubCommand {
 /// Args contains the list of available args for the agentControl run command
 #[derive(Debug, Default, clap::Parser)]
 pub struct Args {
+    /// Reads the Agent Control configuration from this file instead of from the local
+    /// configuration path.
+    #[arg(long, global = true)]
+    config: Option<std::path::PathBuf>,
+
     /// Overrides the default local configuration path `/etc/newrelic-agent-control/`.
     #[cfg(debug_assertions)]
     #[arg(long)]
@@ -137,6 +149,8 @@ pub struct RunnerContext {
     pub application_event_consumer: EventConsumer<ApplicationEvent>,
     /// Overrides the binary that on-host self-update replaces. `None` means the current executable.
     pub self_replace_target: Option<std::path::PathBuf>,
+    /// Changes the level of the logging filters set up for Agent Control.
+    pub log_level_reloader: LogLevelReloader,
 }
 
 impl Command {
@@ -156,7 +170,7 @@ impl Command {
             Some(SubCommand::Verify) => {
                 let (exit_code, message) = match Command::verify(&parsed.args) {
                     Ok(_) => (ExitCode::SUCCESS, "Verification succeeded".to_string()),
-                    Err(err) => (ExitCode::FAILURE, err.to_string()),
+                    Err(err) => (ExitStatus::from_error(err.as_ref()).into(), err.to_string()),
                 };
 
                 let output = serde_json::to_string(&CommandResult { message })
@@ -165,6 +179,17 @@ impl Command {
 
                 exit_code
             }
+            Some(SubCommand::Preflight) => {
+                if let Err(err) = try_init_stderr_tracing(&LoggingConfig::default()) {
+                    eprintln!("failed to initialize tracing: {err}");
+                }
+                let report = preflight(&parsed.args);
+                let output = serde_json::to_string(&report)
+                    .unwrap_or_else(|e| format!("failed to serialize preflight report: {e}"));
+                println!("{}", output);
+
+                report.exit_status().into()
+            }
             None => {
                 // For backward compatibility, default to Run command using flattened args
                 Command::run(
@@ -204,7 +229,7 @@ impl Command {
                 // We are leveraging eprintln here instead of error! because if we fail to build the run context,
                 // it means we probably failed before initializing tracing, so we can't guarantee that the error will be logged.
                 eprintln!("Failed building the run context {}", err);
-                ExitCode::FAILURE
+                ExitStatus::from_error(err.as_ref()).into()
             }
             Ok(run_context) => match main_fn(run_context) {
                 Ok(_) => {
@@ -213,16 +238,17 @@ impl Command {
                 }
                 Err(err) => {
                     error!("The agent control main process exited with an error: {err}");
-                    ExitCode::FAILURE
+                    ExitStatus::from_error(err.as_ref()).into()
                 }
             },
         }
     }
 
-    fn build_bootstrap_context(
-        #[cfg_attr(not(debug_assertions), allow(unused_variables))] args: &Args,
-    ) -> Result<BootstrapContext, Box<dyn Error>> {
-        let base_paths = BasePaths::default();
+    fn build_bootstrap_context(args: &Args) -> Result<BootstrapContext, Box<dyn Error>> {
+        let base_paths = BasePaths {
+            config_file: args.config.clone(),
+            ..Default::default()
+        };
 
         #[cfg(debug_assertions)]
         let base_paths = set_debug_dirs(base_paths, args);
@@ -235,6 +261,17 @@ impl Command {
 
         let bootstrap_config = Self::build_bootstrap_config(&base_paths)?;
 
+        // Remote configs are not loaded for the bootstrap config, so the data directory can be
+        // redirected once it is known.
+        let base_paths = match &bootstrap_config.storage.writable_root {
+            Some(writable_root) => base_paths.with_writable_root(writable_root),
+            None => base_paths,
+        };
+        let base_paths = match &bootstrap_config.storage.ephemeral_dir {
+            Some(ephemeral_dir) => base_paths.with_ephemeral_dir(ephemeral_dir.clone()),
+            None => base_paths,
+        };
+
         Ok(BootstrapContext {
             bootstrap_config,
             base_paths,
@@ -257,6 +294,11 @@ impl Command {
             .transpose()
             .map_err(|e| format!("Failed to setup Windows service: {e}"))?;
 
+        // Must be set up before any other thread is spawned, see [create_reload_signal_handler].
+        #[cfg(target_family = "unix")]
+        create_reload_signal_handler(application_event_publisher.clone())
+            .map_err(|e| format!("Failed to create reload signal handler: {e}"))?;
+
         create_shutdown_signal_handler(application_event_publisher)
             .map_err(|e| format!("Failed to create shutdown signal handler: {e}"))?;
 
@@ -265,10 +307,19 @@ impl Command {
             bootstrap_config,
         } = Self::build_bootstrap_context(args)?;
 
+        // In Kubernetes the data directory is provided by the chart.
+        if running_mode != Environment::K8s {
+            base_paths
+                .check_writable()
+                .map_err(|err| ExitError::new(ExitStatus::StorageNotWritable, err.to_string()))?;
+        }
+
         let config_folder_name = base_paths.local_dir.display().to_string();
 
+        let log_level_reloader = LogLevelReloader::default();
         let tracing_config = TracingConfig::from_logging_path(base_paths.log_dir.clone())
             .with_logging_config(bootstrap_config.log.clone())
+            .with_level_reloader(log_level_reloader.clone())
             .with_instrumentation_config(
                 bootstrap_config
                     .self_instrumentation
@@ -289,6 +340,7 @@ impl Command {
                 application_event_consumer,
                 // None uses the current running executable.
                 self_replace_target: None,
+                log_level_reloader,
             },
             tracer,
             #[cfg(target_family = "windows")]
@@ -300,10 +352,10 @@ impl Command {
     /// Besides loading the configuration, it resolves specific environment variables that need to be resolved
     /// at runtime.
     fn build_bootstrap_config(base_paths: &BasePaths) -> Result<AgentControlConfig, InitError> {
-        let file_store = Arc::new(FileStore::new_local_fs(
-            base_paths.local_dir.clone(),
-            base_paths.remote_dir.clone(),
-        ));
+        let file_store = Arc::new(
+            FileStore::new_local_fs(base_paths.local_dir.clone(), base_paths.remote_dir.clone())
+                .with_agent_control_config(base_paths.config_file.clone()),
+        );
         // AC config is treated as other agents configs and the location of the local config file follows the same
         // fs layout. Example for linux is expected to be in '/etc/newrelic-agent-control/local-data/agent-control/'
         // In both K8s and onHost we read here the agent-control config that is used to bootstrap the AC from file.
@@ -334,12 +386,20 @@ impl Command {
         try_init_stderr_tracing(&LoggingConfig::default())
             .map_err(|e| format!("failed to initialize tracing: {e}"))?;
 
-        let verified_config =
-            check_config(args).map_err(|err| format!("configuration check failed: {err}"))?;
+        let verified_config = check_config(args).map_err(|err| {
+            ExitError::new(
+                ExitStatus::ConfigInvalid,
+                format!("configuration check failed: {err}"),
+            )
+        })?;
 
         if verified_config.maybe_opamp.is_some() {
-            check_connectivity(verified_config)
-                .map_err(|err| format!("OpAMP connectivity check failed: {err}"))?;
+            check_connectivity(verified_config).map_err(|err| {
+                ExitError::new(
+                    ExitStatus::OpAMPUnreachable,
+                    format!("OpAMP connectivity check failed: {err}"),
+                )
+            })?;
         } else {
             info!("OpAMP configuration not found. Skipping OpAMP connectivity check.");
         }
@@ -364,6 +424,36 @@ fn create_shutdown_signal_handler(
     .inspect_err(|e| error!("Could not set signal handler: {e}"))
 }
 
+/// Requests a configuration reload on SIGHUP by sending [ApplicationEvent::ReloadRequested] to the
+/// agent control event processor.
+///
+/// SIGHUP is blocked and waited for in a dedicated thread. Threads inherit the signal mask, so this
+/// needs to happen before any other thread is spawned, otherwise the signal could be delivered to
+/// the shutdown handler instead. Processes spawned for the sub-agents get the mask reset.
+#[cfg(target_family = "unix")]
+fn create_reload_signal_handler(publisher: EventPublisher<ApplicationEvent>) -> nix::Result<()> {
+    use crate::utils::threads::spawn_named_thread;
+    use nix::sys::signal::{SigSet, Signal};
+
+    let mut signals = SigSet::empty();
+    signals.add(Signal::SIGHUP);
+    signals.thread_block()?;
+
+    spawn_named_thread("reload-signal-handler", move || {
+        loop {
+            if let Err(e) = signals.wait() {
+                error!("Could not wait for SIGHUP: {e}");
+                break;
+            }
+            info!("Received SIGHUP. Reloading agent control configuration");
+            let _ = publisher
+                .publish(ApplicationEvent::ReloadRequested)
+                .inspect_err(|e| error!("Could not send agent control reload request: {}", e));
+        }
+    });
+    Ok(())
+}
+
 #[cfg(debug_assertions)]
 /// Set path override if local_dir, remote_dir, and logs_dir flags are set
 fn set_debug_dirs(base_paths: BasePaths, args: &Args) -> BasePaths {
@@ -390,5 +480,6 @@ mod tests {
     fn test_subcommand_display() {
         assert_eq!(SubCommand::Version.to_string(), "version");
         assert_eq!(SubCommand::Verify.to_string(), "verify");
+        assert_eq!(SubCommand::Preflight.to_string(), "preflight");
     }
 }
@@ -0,0 +1,139 @@
+//! Process exit statuses returned by the Agent Control binaries.
+//!
+//! The codes are part of the public interface: orchestration scripts and service managers branch
+//! on them to tell failure classes apart, so existing values must never change. They follow the
+//! [BSD sysexits] conventions where one applies.
+//!
+//! [BSD sysexits]: https://man.freebsd.org/cgi/man.cgi?query=sysexits&manpath=FreeBSD+4.3-RELEASE
+
+use super::InitError;
+use std::error::Error;
+use std::process::ExitCode;
+use thiserror::Error;
+
+/// Failure classes reported through the process exit code.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ExitStatus {
+    /// The command succeeded.
+    Success,
+    /// Any failure not covered by a more specific status.
+    Failure,
+    /// A condition required to run a CLI command is not met, like Agent Control not running or a
+    /// setting the command relies on not being configured (`EX_UNAVAILABLE`).
+    PreconditionFailed,
+    /// Logging could not be initialized (`EX_SOFTWARE`).
+    LoggingFailed,
+    /// The directory holding the Agent Control data is not writable (`EX_CANTCREAT`).
+    StorageNotWritable,
+    /// A sub-agent kept crashing until its restart policy was exhausted (`EX_TEMPFAIL`).
+    SubAgentCrashLoop,
+    /// The OpAMP server could not be reached (`EX_PROTOCOL`).
+    OpAMPUnreachable,
+    /// The process lacks the permissions it requires (`EX_NOPERM`).
+    PermissionDenied,
+    /// The configuration could not be loaded or is invalid (`EX_CONFIG`).
+    ConfigInvalid,
+}
+
+impl ExitStatus {
+    /// Returns the process exit code of the status.
+    pub const fn code(self) -> u8 {
+        match self {
+            Self::Success => 0,
+            Self::Failure => 1,
+            Self::PreconditionFailed => 69,
+            Self::LoggingFailed => 70,
+            Self::StorageNotWritable => 73,
+            Self::SubAgentCrashLoop => 75,
+            Self::OpAMPUnreachable => 76,
+            Self::PermissionDenied => 77,
+            Self::ConfigInvalid => 78,
+        }
+    }
+
+    /// Classifies `err` by looking for an [ExitError] or a known error type along its source chain.
+    /// Unknown errors are reported as [ExitStatus::Failure].
+    pub fn from_error(err: &(dyn Error + 'static)) -> Self {
+        std::iter::successors(Some(err), |err| err.source())
+            .find_map(|err| {
+                if let Some(exit_err) = err.downcast_ref::<ExitError>() {
+                    Some(exit_err.status)
+                } else if err.is::<InitError>() {
+                    Some(Self::ConfigInvalid)
+                } else {
+                    None
+                }
+            })
+            .unwrap_or(Self::Failure)
+    }
+}
+
+impl From<ExitStatus> for ExitCode {
+    fn from(value: ExitStatus) -> Self {
+        Self::from(value.code())
+    }
+}
+
+/// Error carrying the [ExitStatus] the process should exit with.
+#[derive(Debug, Error)]
+#[error("{message}")]
+pub struct ExitError {
+    status: ExitStatus,
+    message: String,
+}
+
+impl ExitError {
+    /// Returns an error reported with `status`.
+    pub fn new(status: ExitStatus, message: impl ToString) -> Self {
+        Self {
+            status,
+            message: message.to_string(),
+        }
+    }
+
+    /// The status the process should exit with.
+    pub fn status(&self) -> ExitStatus {
+        self.status
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+
+    #[derive(Debug, Error)]
+    #[error("wrapper")]
+    struct Wrapper(#[source] Box<dyn Error + 'static>);
+
+    #[rstest]
+    #[case::exit_error(
+        ExitError::new(ExitStatus::PermissionDenied, "not root").into(),
+        ExitStatus::PermissionDenied
+    )]
+    #[case::init_error(
+        InitError::InvalidConfig("bad".to_string()).into(),
+        ExitStatus::ConfigInvalid
+    )]
+    #[case::nested(
+        Box::new(Wrapper(ExitError::new(ExitStatus::OpAMPUnreachable, "down").into())),
+        ExitStatus::OpAMPUnreachable
+    )]
+    #[case::unknown("boom".into(), ExitStatus::Failure)]
+    fn test_from_error(#[case] err: Box<dyn Error>, #[case] expected: ExitStatus) {
+        assert_eq!(ExitStatus::from_error(err.as_ref()), expected);
+    }
+
+    #[test]
+    fn test_codes_are_stable() {
+        assert_eq!(ExitStatus::Success.code(), 0);
+        assert_eq!(ExitStatus::Failure.code(), 1);
+        assert_eq!(ExitStatus::PreconditionFailed.code(), 69);
+        assert_eq!(ExitStatus::LoggingFailed.code(), 70);
+        assert_eq!(ExitStatus::StorageNotWritable.code(), 73);
+        assert_eq!(ExitStatus::SubAgentCrashLoop.code(), 75);
+        assert_eq!(ExitStatus::OpAMPUnreachable.code(), 76);
+        assert_eq!(ExitStatus::PermissionDenied.code(), 77);
+        assert_eq!(ExitStatus::ConfigInvalid.code(), 78);
+    }
+}
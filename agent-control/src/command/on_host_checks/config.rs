@@ -17,6 +17,7 @@ use crate::{
 
 pub struct VerifiedConfig {
     pub local_dir: PathBuf,
+    pub remote_dir: PathBuf,
     pub maybe_opamp: Option<OpAMPClientConfig>,
     pub proxy_config: ProxyConfig,
     pub agent_control_config: AgentControlConfig,
@@ -30,17 +31,18 @@ pub fn check_config(args: &Args) -> Result<VerifiedConfig, Box<dyn std::error::E
         bootstrap_config,
     } = Command::build_bootstrap_context(args)
         .map_err(|err| format!("failed to build context: {err}"))?;
+    base_paths.check_writable()?;
 
     let local_dir = base_paths.local_dir;
     let remote_dir = base_paths.remote_dir;
-    let file_store = Arc::new(FileStore::new_local_fs(
-        local_dir.clone(),
-        remote_dir.clone(),
-    ));
+    let file_store = Arc::new(
+        FileStore::new_local_fs(local_dir.clone(), remote_dir.clone())
+            .with_agent_control_config(base_paths.config_file),
+    );
 
     let maybe_opamp = bootstrap_config.fleet_control;
     let (yaml_config_repository, config_storer) =
-        setup_config_repository_and_store(file_store.clone(), maybe_opamp.is_some());
+        setup_config_repository_and_store(file_store.clone(), maybe_opamp.is_some(), None, None);
     let agent_control_config = config_storer
         .load()
         .map_err(|err| format!("failed to load Agent Control config: {err}"))?;
@@ -51,6 +53,7 @@ pub fn check_config(args: &Args) -> Result<VerifiedConfig, Box<dyn std::error::E
 
     Ok(VerifiedConfig {
         local_dir,
+        remote_dir,
         maybe_opamp,
         proxy_config,
         agent_control_config,
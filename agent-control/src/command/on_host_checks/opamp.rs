@@ -19,7 +19,11 @@ use crate::{
 pub fn check_connectivity(
     verified_config: VerifiedConfig,
 ) -> Result<(), Box<dyn std::error::Error>> {
-    let identifiers = ac_identifiers(&verified_config.agent_control_config)?;
+    let (identifiers, host_description) = ac_identifiers(
+        &verified_config.agent_control_config,
+        &verified_config.remote_dir,
+        false,
+    )?;
 
     let instance_id_storer = Arc::new(Storer::from(verified_config.file_store.clone()));
     let instance_id_getter =
@@ -29,6 +33,8 @@ pub fn check_connectivity(
         verified_config.local_dir.clone(),
         verified_config.maybe_opamp.clone().unwrap(),
         verified_config.proxy_config.clone(),
+        verified_config.agent_control_config.egress.clone(),
+        verified_config.agent_control_config.http_exchange_log,
         verified_config.yaml_config_repository.clone(),
     );
 
@@ -51,10 +57,23 @@ pub fn check_connectivity(
     // - The check sends an `AgentToServer` message and processes a `ServerToAgent` via
     //   `process_message`, doing more work than strictly necessary for connectivity verification
     let agent_identity = AgentIdentity::new_agent_control_identity();
-    let agent_description =
-        build_ac_onhost_agent_description(&agent_identity, &identifiers, RunningMode::Verify);
-    let start_settings =
-        build_ac_opamp_start_settings(&instance_id_getter, &agent_identity, agent_description)?;
+    let agent_description = build_ac_onhost_agent_description(
+        &agent_identity,
+        &identifiers,
+        &host_description,
+        &verified_config.agent_control_config.resource_attributes,
+        verified_config.agent_control_config.release_channel,
+        RunningMode::Verify,
+    );
+    let start_settings = build_ac_opamp_start_settings(
+        &instance_id_getter,
+        &agent_identity,
+        agent_description,
+        verified_config
+            .agent_control_config
+            .agent_packages
+            .accept_offered_packages,
+    )?;
     let (client, _consumer) =
         start_ac_opamp_client(&opamp_client_builder, agent_identity, start_settings)?;
     client.stop()?;
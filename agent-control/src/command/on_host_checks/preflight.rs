@@ -0,0 +1,296 @@
+//! Checks of the host run before installing or starting Agent Control, reported as a JSON
+//! document so installation tooling can tell why a host is not ready.
+//!
+//! The checks run in order and the ones depending on a failed check are skipped:
+//! - `config`: the Agent Control configuration can be loaded.
+//! - `directories`: the local directory can be read and the data and log directories written.
+//! - `disk_space`: the data directory has enough free space for the agent packages. Only checked
+//!   on Unix.
+//! - `proxy`: the configured proxy accepts connections.
+//! - `dns`: the Fleet Control endpoint resolves.
+//! - `opamp`: Fleet Control can be reached through OpAMP.
+
+use crate::command::exit_status::ExitStatus;
+use crate::command::on_host_checks::config::check_config;
+use crate::command::on_host_checks::opamp::check_connectivity;
+use crate::command::{Args, BootstrapContext, Command};
+use http::Uri;
+use serde::Serialize;
+use std::error::Error;
+use std::fs::{self, OpenOptions};
+use std::net::{SocketAddr, TcpStream, ToSocketAddrs};
+use std::path::Path;
+use std::time::Duration;
+use tracing::{info, warn};
+
+/// Free space the data directory needs to hold the agent packages.
+#[cfg(target_family = "unix")]
+const MIN_FREE_DISK_SPACE: u64 = 256 * 1024 * 1024;
+const PROXY_CONNECT_TIMEOUT: Duration = Duration::from_secs(5);
+const WRITABLE_CHECK_FILE_NAME: &str = ".preflight";
+
+/// Result of a single check.
+#[derive(Debug, Serialize, PartialEq, Clone, Copy)]
+#[serde(rename_all = "lowercase")]
+pub enum CheckStatus {
+    /// The check succeeded.
+    Passed,
+    /// The check failed.
+    Failed,
+    /// The check doesn't apply or depends on a failed check.
+    Skipped,
+}
+
+/// Outcome of a check, as written to the report.
+#[derive(Debug, Serialize, PartialEq)]
+pub struct CheckResult {
+    /// Name of the check.
+    pub name: &'static str,
+    /// Result of the check.
+    pub status: CheckStatus,
+    /// Reason of the failure or the skip.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub message: Option<String>,
+    #[serde(skip)]
+    exit_status: ExitStatus,
+}
+
+/// Report of the preflight checks written to stdout.
+#[derive(Debug, Default, Serialize)]
+pub struct PreflightReport {
+    /// Whether every check passed or was skipped because it doesn't apply.
+    pub passed: bool,
+    /// Outcome of each check, in the order they ran.
+    pub checks: Vec<CheckResult>,
+}
+
+impl PreflightReport {
+    /// Exit status of the first failed check, if any.
+    pub fn exit_status(&self) -> ExitStatus {
+        self.checks
+            .iter()
+            .find(|check| check.status == CheckStatus::Failed)
+            .map_or(ExitStatus::Success, |check| check.exit_status)
+    }
+
+    /// Runs the check, recording its outcome. A failure is reported with `exit_status`.
+    fn check<T>(
+        &mut self,
+        name: &'static str,
+        exit_status: ExitStatus,
+        check: impl FnOnce() -> Result<T, Box<dyn Error>>,
+    ) -> Option<T> {
+        let (status, message, value) = match check() {
+            Ok(value) => {
+                info!("Preflight check '{name}' passed");
+                (CheckStatus::Passed, None, Some(value))
+            }
+            Err(err) => {
+                warn!("Preflight check '{name}' failed: {err}");
+                (CheckStatus::Failed, Some(err.to_string()), None)
+            }
+        };
+        self.checks.push(CheckResult {
+            name,
+            status,
+            message,
+            exit_status,
+        });
+        value
+    }
+
+    fn skip(&mut self, name: &'static str, reason: &str) {
+        self.checks.push(CheckResult {
+            name,
+            status: CheckStatus::Skipped,
+            message: Some(reason.to_string()),
+            exit_status: ExitStatus::Success,
+        });
+    }
+
+    fn finish(mut self) -> Self {
+        self.passed = self.exit_status() == ExitStatus::Success;
+        self
+    }
+}
+
+/// Runs the preflight checks.
+pub fn preflight(args: &Args) -> PreflightReport {
+    let mut report = PreflightReport::default();
+
+    let Some(BootstrapContext {
+        base_paths,
+        bootstrap_config,
+    }) = report.check("config", ExitStatus::ConfigInvalid, || {
+        Command::build_bootstrap_context(args)
+    })
+    else {
+        for name in ["directories", "disk_space", "proxy", "dns", "opamp"] {
+            report.skip(name, "the configuration could not be loaded");
+        }
+        return report.finish();
+    };
+
+    let directories = report.check("directories", ExitStatus::StorageNotWritable, || {
+        fs::read_dir(&base_paths.local_dir)
+            .map_err(|err| format!("{} is not readable: {err}", base_paths.local_dir.display()))?;
+        base_paths.check_writable()?;
+        check_writable(&base_paths.log_dir)
+    });
+    if directories.is_some() {
+        report.check("disk_space", ExitStatus::StorageNotWritable, || {
+            check_disk_space(&base_paths.remote_dir)
+        });
+    } else {
+        report.skip("disk_space", "the data directory is not writable");
+    }
+
+    let proxy_url = bootstrap_config.proxy.url_as_string();
+    let proxy = if proxy_url.is_empty() {
+        report.skip("proxy", "no proxy configured");
+        Some(())
+    } else {
+        report.check("proxy", ExitStatus::OpAMPUnreachable, || {
+            check_proxy(&proxy_url)
+        })
+    };
+
+    let Some(fleet_control) = &bootstrap_config.fleet_control else {
+        report.skip("dns", "Fleet Control is not configured");
+        report.skip("opamp", "Fleet Control is not configured");
+        return report.finish();
+    };
+    // The proxy resolves the endpoint when one is configured.
+    let dns = if proxy_url.is_empty() {
+        report.check("dns", ExitStatus::OpAMPUnreachable, || {
+            resolve(&fleet_control.endpoint)
+        })
+    } else {
+        report.skip("dns", "the endpoint is resolved by the proxy");
+        Some(())
+    };
+
+    if proxy.is_none() || dns.is_none() {
+        report.skip("opamp", "Fleet Control is not reachable");
+    } else {
+        report.check("opamp", ExitStatus::OpAMPUnreachable, || {
+            check_connectivity(check_config(args)?)
+        });
+    }
+    report.finish()
+}
+
+fn check_writable(dir: &Path) -> Result<(), Box<dyn Error>> {
+    let not_writable = |err: std::io::Error| format!("{} is not writable: {err}", dir.display());
+    fs::create_dir_all(dir).map_err(not_writable)?;
+    let check_file = dir.join(WRITABLE_CHECK_FILE_NAME);
+    OpenOptions::new()
+        .write(true)
+        .create(true)
+        .truncate(true)
+        .open(&check_file)
+        .map_err(not_writable)?;
+    fs::remove_file(&check_file).map_err(not_writable)?;
+    Ok(())
+}
+
+#[cfg(target_family = "unix")]
+fn check_disk_space(dir: &Path) -> Result<(), Box<dyn Error>> {
+    let stats = nix::sys::statvfs::statvfs(dir)?;
+    #[allow(clippy::useless_conversion)]
+    let available = u64::from(stats.blocks_available()) * u64::from(stats.fragment_size());
+    if available < MIN_FREE_DISK_SPACE {
+        return Err(format!(
+            "{} has {available} bytes available, at least {MIN_FREE_DISK_SPACE} are required",
+            dir.display()
+        )
+        .into());
+    }
+    Ok(())
+}
+
+#[cfg(target_family = "windows")]
+fn check_disk_space(_dir: &Path) -> Result<(), Box<dyn Error>> {
+    Ok(())
+}
+
+fn check_proxy(proxy_url: &str) -> Result<(), Box<dyn Error>> {
+    let uri = proxy_url.parse::<Uri>()?;
+    let host = uri.host().ok_or("the proxy url has no host")?;
+    let port = uri.port_u16().unwrap_or(match uri.scheme_str() {
+        Some("https") => 443,
+        _ => 80,
+    });
+    let addr = first_addr((host, port))?;
+    TcpStream::connect_timeout(&addr, PROXY_CONNECT_TIMEOUT)
+        .map_err(|err| format!("could not connect to the proxy {host}:{port}: {err}"))?;
+    Ok(())
+}
+
+fn resolve(endpoint: &url::Url) -> Result<(), Box<dyn Error>> {
+    let host = endpoint.host_str().ok_or("the endpoint has no host")?;
+    let port = endpoint.port_or_known_default().unwrap_or(443);
+    first_addr((host, port))?;
+    Ok(())
+}
+
+fn first_addr(addr: (&str, u16)) -> Result<SocketAddr, Box<dyn Error>> {
+    addr.to_socket_addrs()
+        .map_err(|err| format!("could not resolve {}: {err}", addr.0))?
+        .next()
+        .ok_or_else(|| format!("{} resolves to no address", addr.0).into())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::net::TcpListener;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_report() {
+        let mut report = PreflightReport::default();
+        report.check("config", ExitStatus::ConfigInvalid, || Ok(()));
+        report.skip("proxy", "no proxy configured");
+        report.check::<()>("directories", ExitStatus::StorageNotWritable, || {
+            Err("not writable".into())
+        });
+        report.check::<()>("opamp", ExitStatus::OpAMPUnreachable, || {
+            Err("unreachable".into())
+        });
+        let report = report.finish();
+
+        assert!(!report.passed);
+        assert_eq!(report.exit_status(), ExitStatus::StorageNotWritable);
+        assert_eq!(
+            serde_json::to_value(&report).unwrap(),
+            serde_json::json!({
+                "passed": false,
+                "checks": [
+                    {"name": "config", "status": "passed"},
+                    {"name": "proxy", "status": "skipped", "message": "no proxy configured"},
+                    {"name": "directories", "status": "failed", "message": "not writable"},
+                    {"name": "opamp", "status": "failed", "message": "unreachable"},
+                ]
+            })
+        );
+    }
+
+    #[test]
+    fn test_check_writable() {
+        let tmp_dir = TempDir::new().unwrap();
+        let dir = tmp_dir.path().join("logs");
+        check_writable(&dir).unwrap();
+        assert!(dir.is_dir());
+        assert!(!dir.join(WRITABLE_CHECK_FILE_NAME).exists());
+    }
+
+    #[test]
+    fn test_check_proxy() {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let port = listener.local_addr().unwrap().port();
+        check_proxy(&format!("http://127.0.0.1:{port}")).unwrap();
+        drop(listener);
+        assert!(check_proxy(&format!("http://127.0.0.1:{port}")).is_err());
+    }
+}
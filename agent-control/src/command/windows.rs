@@ -2,6 +2,7 @@
 //! running mode.
 
 use crate::agent_control::run::GracefulShutdownReason;
+use crate::command::exit_status::ExitStatus;
 use crate::event::channel::EventPublisher;
 use crate::{event::ApplicationEvent, utils::retry::retry};
 use std::error::Error;
@@ -16,6 +17,7 @@ use windows_service::{
     service_control_handler::{self, ServiceControlHandlerResult, ServiceStatusHandle},
     service_manager::{ServiceManager, ServiceManagerAccess},
 };
+use windows_sys::Win32::Foundation::ERROR_SERVICE_DOES_NOT_EXIST;
 
 /// Global handle used by the event handler to signal state changes (like StopPending) to Windows.
 /// This allows the closure to access the handle, which is only available after registration.
@@ -59,6 +61,9 @@ impl WindowsServiceStopHandler {
                 // ERROR_RESTART_APPLICATION(1467) indicates that the a restart is needed due to a self-update
                 Ok(GracefulShutdownReason::SelfUpdate) => ServiceExitCode::Win32(1467),
                 Ok(GracefulShutdownReason::ExternalRequested) => ServiceExitCode::Win32(0),
+                Ok(GracefulShutdownReason::SubAgentCrashLoop(_)) => {
+                    ServiceExitCode::ServiceSpecific(ExitStatus::SubAgentCrashLoop.code().into())
+                }
             };
 
             set_service_as_stopped(handle, exit_code)?;
@@ -220,6 +225,49 @@ pub fn scm_service_ready_to_stop(service_name: &str) -> Result<(), Box<dyn Error
     }
 }
 
+/// Stops the service with the given name through the Windows Service Control Manager, waiting up
+/// to `timeout` for it to be stopped. Returns whether the service was running.
+pub fn stop_scm_service(service_name: &str, timeout: Duration) -> Result<bool, Box<dyn Error>> {
+    let manager = ServiceManager::local_computer(None::<&str>, ServiceManagerAccess::CONNECT)?;
+    let service = match manager.open_service(
+        service_name,
+        ServiceAccess::QUERY_STATUS | ServiceAccess::STOP,
+    ) {
+        Ok(service) => service,
+        Err(windows_service::Error::Winapi(err))
+            if err.raw_os_error() == Some(ERROR_SERVICE_DOES_NOT_EXIST as i32) =>
+        {
+            return Ok(false);
+        }
+        Err(err) => return Err(err.into()),
+    };
+    let status = service.query_status()?;
+    if status.current_state == ServiceState::Stopped {
+        return Ok(false);
+    }
+    if status.current_state != ServiceState::StopPending {
+        service.stop()?;
+    }
+
+    let max_attempts = (timeout.as_millis() / SCM_CHECK_INTERVALS.as_millis()).max(1) as usize;
+    retry(
+        max_attempts,
+        SCM_CHECK_INTERVALS,
+        || -> Result<(), Box<dyn Error>> {
+            let state = service.query_status()?.current_state;
+            if state == ServiceState::Stopped {
+                Ok(())
+            } else {
+                Err(
+                    format!("service '{service_name}' is not stopped (current state: {state:?})")
+                        .into(),
+                )
+            }
+        },
+    )?;
+    Ok(true)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -233,4 +281,9 @@ mod tests {
         assert!(scm_service_ready_to_stop(ALWAYS_RUNNING_SERVICE).is_ok());
         assert!(scm_service_ready_to_stop(NONEXISTING_SERVICE).is_err());
     }
+
+    #[test]
+    fn test_stop_nonexisting_scm_service() {
+        assert!(!stop_scm_service(NONEXISTING_SERVICE, Duration::from_secs(1)).unwrap());
+    }
 }
@@ -8,10 +8,12 @@ pub mod broadcaster;
 pub mod cancellation;
 pub mod channel;
 
+use opamp_client::opamp::proto::PackagesAvailable;
 use opamp_client::operation::settings::AgentDescription;
 
 use crate::checkers::health::with_start_time::HealthWithStartTime;
 use crate::opamp::attributes::UpdatedAttributesMessage;
+use crate::opamp::remote_config::hash::Hash;
 use crate::opamp::{LastErrorCode, LastErrorMessage};
 use crate::sub_agent::identity::AgentIdentity;
 use crate::{agent_control::agent_id::AgentID, opamp::remote_config::OpampRemoteConfig};
@@ -26,6 +28,8 @@ pub enum OpAMPEvent {
     Connected,
     /// The OpAMP client failed to connect, carrying the optional error code and message.
     ConnectFailed(Option<LastErrorCode>, LastErrorMessage),
+    /// The OpAMP server offered packages to install.
+    PackagesAvailable(PackagesAvailable),
 }
 
 /// Defines application events: these events are sent directly to the application. Eg: OS-signals.
@@ -33,6 +37,8 @@ pub enum OpAMPEvent {
 pub enum ApplicationEvent {
     /// Requests the application to stop (e.g. triggered by an OS signal).
     StopRequested,
+    /// Requests the application to reload its configuration (e.g. triggered by SIGHUP).
+    ReloadRequested,
 }
 
 /// Defines the events produced by the AgentControl component.
@@ -50,6 +56,11 @@ pub enum AgentControlEvent {
     OpAMPConnected,
     /// The AgentControl OpAMP client failed to connect, carrying the optional error code and message.
     OpAMPConnectFailed(Option<LastErrorCode>, LastErrorMessage),
+    /// A remote configuration, identified by its hash, was applied to the AgentControl.
+    ConfigApplied(Hash),
+    /// A remote configuration, identified by its hash, failed to apply to the AgentControl,
+    /// carrying the error message.
+    ConfigFailed(Hash, String),
 }
 
 /// Defines the events produced by the SubAgent component.
@@ -61,6 +72,13 @@ pub enum SubAgentEvent {
     SubAgentStarted(AgentIdentity, SystemTime),
     /// The agent description of the identified sub-agent was updated.
     AgentDescriptionUpdated(AgentIdentity, AgentDescription),
+    /// The configuration of the identified sub-agent was linted, carrying the resulting warnings.
+    ConfigLinted(AgentIdentity, Vec<String>),
+    /// A remote configuration, identified by its hash, was applied to the identified sub-agent.
+    ConfigApplied(AgentIdentity, Hash),
+    /// A remote configuration, identified by its hash, failed to apply to the identified
+    /// sub-agent, carrying the error message.
+    ConfigFailed(AgentIdentity, Hash, String),
 }
 
 impl SubAgentEvent {
@@ -79,6 +97,8 @@ pub enum AgentControlInternalEvent {
     AgentControlAttributesUpdated(UpdatedAttributesMessage),
     /// A restart was requested as part of a self-update.
     SelfUpdateRestartRequested(),
+    /// An executable of the sub-agent exhausted its restart policy.
+    SubAgentCrashLooped(AgentID),
 }
 
 /// Defines internal events for the SubAgent component.
@@ -4,12 +4,29 @@
 //! message or a disconnection signals cancellation.
 
 use super::channel::EventConsumer;
-use crossbeam::channel::RecvTimeoutError;
+use crate::utils::threads::spawn_named_thread;
+use crossbeam::channel::{RecvTimeoutError, Sender, after, bounded, never, unbounded};
+use crossbeam::select;
+use std::panic::{AssertUnwindSafe, catch_unwind, resume_unwind};
+use std::sync::Arc;
+use std::sync::atomic::{AtomicBool, Ordering};
 use std::time::Duration;
+use thiserror::Error;
 
 /// The message type used to signal cancellation; the message itself carries no data.
 pub type CancellationMessage = ();
 
+/// Reason why an operation run through [IoWorker::run] didn't complete.
+#[derive(Debug, Error, PartialEq)]
+pub enum OperationInterrupted {
+    /// The consumer was cancelled.
+    #[error("operation cancelled")]
+    Cancelled,
+    /// The operation didn't complete within the timeout.
+    #[error("operation timed out after {0:?}")]
+    TimedOut(Duration),
+}
+
 impl EventConsumer<CancellationMessage> {
     /// Checks whether the consumer is cancelled immediately.
     ///
@@ -30,3 +47,172 @@ impl EventConsumer<CancellationMessage> {
         }
     }
 }
+
+/// Operation queued in an [IoWorker].
+type Job = Box<dyn FnOnce() + Send>;
+
+/// Long-lived thread running blocking operations, like filesystem I/O, one at a time, so callers
+/// can stop waiting for them without leaving a thread behind for each operation.
+///
+/// Operations run in order: one hanging (e.g. on a hung filesystem) delays the next ones, which
+/// are interrupted by their own cancellation or timeout. Interrupted operations that didn't start
+/// yet are skipped, so e.g. a cancelled write is never applied after the caller gave up on it.
+#[derive(Clone)]
+pub struct IoWorker {
+    jobs: Sender<Job>,
+}
+
+impl IoWorker {
+    /// Spawns the worker thread, which finishes once every clone of the worker is dropped.
+    pub fn new(thread_name: &str) -> Self {
+        let (jobs, job_receiver) = unbounded::<Job>();
+        spawn_named_thread(thread_name, move || {
+            for job in job_receiver {
+                job();
+            }
+        });
+        Self { jobs }
+    }
+
+    /// Runs `operation` in the worker and waits for its result unless `cancellation` is
+    /// cancelled or the optional `timeout` elapses first.
+    ///
+    /// An interrupted operation is skipped if it didn't start. One already running, e.g. blocked
+    /// in a system call, can't be stopped: it finishes in the background and its result is
+    /// discarded. As a message only wakes up one consumer, dropping the publisher is the way to
+    /// cancel every pending operation at once.
+    pub fn run<T, F>(
+        &self,
+        cancellation: &EventConsumer<CancellationMessage>,
+        timeout: Option<Duration>,
+        operation: F,
+    ) -> Result<T, OperationInterrupted>
+    where
+        F: FnOnce() -> T + Send + 'static,
+        T: Send + 'static,
+    {
+        let interrupted = Arc::new(AtomicBool::new(false));
+        let (result_sender, result_receiver) = bounded(1);
+        let job_interrupted = interrupted.clone();
+        let job: Job = Box::new(move || {
+            if job_interrupted.load(Ordering::Acquire) {
+                return;
+            }
+            // Panics are returned to the caller, so the worker keeps running the next operations.
+            // The receiver is gone if the operation was interrupted.
+            let _ = result_sender.send(catch_unwind(AssertUnwindSafe(operation)));
+        });
+        self.jobs
+            .send(job)
+            .expect("the worker thread runs while the worker exists");
+
+        let timeout_receiver = timeout.map(after).unwrap_or_else(never);
+
+        let result = select! {
+            recv(result_receiver) -> result => match result {
+                Ok(Ok(result)) => Ok(result),
+                Ok(Err(panic)) => resume_unwind(panic),
+                Err(_) => unreachable!("operations are only skipped once interrupted"),
+            },
+            recv(cancellation.as_ref()) -> _ => Err(OperationInterrupted::Cancelled),
+            recv(timeout_receiver) -> _ => Err(OperationInterrupted::TimedOut(
+                timeout.unwrap_or_default(),
+            )),
+        };
+        if result.is_err() {
+            interrupted.store(true, Ordering::Release);
+        }
+        result
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::event::channel::pub_sub;
+    use std::sync::Mutex;
+    use std::thread::{self, sleep};
+
+    fn not_cancellable() -> EventConsumer<CancellationMessage> {
+        EventConsumer::from(never())
+    }
+
+    #[test]
+    fn test_run_completes() {
+        let worker = IoWorker::new("test");
+
+        let result = worker.run(&not_cancellable(), Some(Duration::from_secs(10)), || 42);
+        assert_eq!(result, Ok(42));
+    }
+
+    #[test]
+    fn test_run_reuses_the_worker_thread() {
+        let worker = IoWorker::new("test");
+
+        let first = worker.run(&not_cancellable(), None, || thread::current().id());
+        let second = worker.run(&not_cancellable(), None, || thread::current().id());
+        assert_eq!(first, second);
+        assert_ne!(first, Ok(thread::current().id()));
+    }
+
+    #[test]
+    fn test_run_cancelled() {
+        let worker = IoWorker::new("test");
+        let (publisher, consumer) = pub_sub::<()>();
+        drop(publisher);
+
+        let result = worker.run(&consumer, None, || sleep(Duration::from_secs(10)));
+        assert_eq!(result, Err(OperationInterrupted::Cancelled));
+    }
+
+    #[test]
+    fn test_run_timed_out() {
+        let worker = IoWorker::new("test");
+        let timeout = Duration::from_millis(10);
+
+        let result = worker.run(&not_cancellable(), Some(timeout), || {
+            sleep(Duration::from_secs(10))
+        });
+        assert_eq!(result, Err(OperationInterrupted::TimedOut(timeout)));
+    }
+
+    #[test]
+    fn test_run_skips_interrupted_operations() {
+        let worker = IoWorker::new("test");
+        let (hung_sender, hung_receiver) = bounded::<()>(0);
+        let written = Arc::new(Mutex::new(Vec::new()));
+        let timeout = Duration::from_millis(10);
+
+        // The first operation hangs until released, so the write is interrupted while queued.
+        let result = worker.run(&not_cancellable(), Some(timeout), move || {
+            let _ = hung_receiver.recv();
+        });
+        assert_eq!(result, Err(OperationInterrupted::TimedOut(timeout)));
+        let write = written.clone();
+        let result = worker.run(&not_cancellable(), Some(timeout), move || {
+            write.lock().unwrap().push("cancelled write")
+        });
+        assert_eq!(result, Err(OperationInterrupted::TimedOut(timeout)));
+
+        drop(hung_sender);
+        let write = written.clone();
+        worker
+            .run(&not_cancellable(), None, move || {
+                write.lock().unwrap().push("write")
+            })
+            .unwrap();
+        assert_eq!(*written.lock().unwrap(), vec!["write"]);
+    }
+
+    #[test]
+    fn test_run_propagates_panics() {
+        let worker = IoWorker::new("test");
+
+        let result = std::panic::catch_unwind(AssertUnwindSafe(|| {
+            worker.run::<(), _>(&not_cancellable(), None, || panic!("operation failed"))
+        }));
+        assert!(result.is_err());
+        // The worker keeps running the next operations.
+        assert_eq!(worker.run(&not_cancellable(), None, || 42), Ok(42));
+    }
+}
@@ -2,3 +2,6 @@
 
 pub mod client;
 pub mod config;
+pub mod dns;
+pub mod exchange_log;
+pub mod pinning;
@@ -1,6 +1,7 @@
 //! # Helpers to build a reqwest blocking client and handle responses and handle responses
 //!
-use crate::http::config::HttpConfig;
+use crate::http::config::{HttpConfig, TlsVersion};
+use crate::http::dns::DnsResolver;
 use async_trait::async_trait;
 use bytes::Bytes;
 use http::{Request, Response};
@@ -9,18 +10,21 @@ use nr_auth::http_client::HttpClient as OauthHttpClient;
 use nr_auth::http_client::HttpClientError as OauthHttpClientError;
 use opamp_client::http::HttpClientError as OpampHttpClientError;
 use opentelemetry_http::HttpError;
-use reqwest::tls::TlsInfo;
+use reqwest::tls::{TlsInfo, Version};
 use reqwest::{
-    Certificate, Error as ReqwestError, Proxy,
-    blocking::{Client, Response as BlockingResponse},
+    Certificate, Error as ReqwestError, Identity, Proxy,
+    blocking::{Client, ClientBuilder, Response as BlockingResponse},
 };
 use resource_detection::cloud::http_client::HttpClient as CloudClient;
 use resource_detection::cloud::http_client::HttpClientError as CloudClientError;
+use rustls_pki_types::pem::PemObject;
+use rustls_pki_types::{CertificateDer, PrivateKeyDer};
 use std::{
     fmt::Display,
     fs::File,
     io::Read,
     path::{Path, PathBuf},
+    sync::Arc,
 };
 use tracing::warn;
 
@@ -29,6 +33,8 @@ const CERT_EXTENSION: &str = "pem";
 #[derive(Debug, Clone)]
 pub struct HttpClient {
     client: Client,
+    /// Custom DNS resolver, if required, kept to invalidate its cache on connection errors.
+    resolver: Option<DnsResolver>,
 }
 impl HttpClient {
     /// Builds a reqwest blocking client according to the provided configuration.
@@ -43,8 +49,53 @@ impl HttpClient {
             builder = builder.tls_info(true);
         }
 
+        let tls = http_config.tls;
         let proxy_config = http_config.proxy;
         let proxy_url = proxy_config.url_as_string();
+        if let Some(pinned_host) = http_config.pinned_host {
+            if tls.insecure_skip_verify {
+                return Err(HttpBuildError::ClientBuilder(
+                    "certificate pinning can't be combined with skipping the certificate verification"
+                        .to_string(),
+                ));
+            }
+            // The preconfigured TLS backend ignores the root certificates and the identity added to
+            // the builder.
+            let mut extra_roots = cert_ders_from_paths(&tls.ca_bundle_file, Path::new(""))?;
+            if !proxy_url.is_empty() {
+                extra_roots.extend(cert_ders_from_paths(
+                    proxy_config.ca_bundle_file(),
+                    proxy_config.ca_bundle_dir(),
+                )?);
+            }
+            let client_auth = tls
+                .client_identity_files()
+                .map(|(cert_file, key_file)| client_auth_ders(cert_file, key_file))
+                .transpose()?;
+            let tls_config = pinned_host
+                .tls_config(extra_roots, client_auth, tls.min_version)
+                .map_err(|err| {
+                    HttpBuildError::ClientBuilder(format!("invalid certificate pinning: {err}"))
+                })?;
+            builder = builder.tls_backend_preconfigured(tls_config);
+        } else {
+            for cert in certs_from_paths(&tls.ca_bundle_file, Path::new(""))? {
+                builder = builder.add_root_certificate(cert)
+            }
+            if let Some((cert_file, key_file)) = tls.client_identity_files() {
+                builder = builder.identity(client_identity(cert_file, key_file)?);
+            }
+            if let Some(min_version) = tls.min_version {
+                builder = builder.min_tls_version(match min_version {
+                    TlsVersion::Tls12 => Version::TLS_1_2,
+                    TlsVersion::Tls13 => Version::TLS_1_3,
+                });
+            }
+            if tls.insecure_skip_verify {
+                warn!("The verification of the server certificates is disabled");
+                builder = builder.danger_accept_invalid_certs(true);
+            }
+        }
         if !proxy_url.is_empty() {
             let proxy = Proxy::all(proxy_url).map_err(|err| {
                 HttpBuildError::ClientBuilder(format!("invalid proxy url: {err}"))
@@ -57,10 +108,29 @@ impl HttpClient {
             }
         }
 
+        let egress = http_config.egress;
+        if let Some(source_address) = egress.source_address {
+            builder = builder.local_address(source_address);
+        }
+        if let Some(interface) = &egress.interface {
+            builder = bind_interface(builder, interface)?;
+        }
+
+        let dns_config = http_config.dns;
+        for (host, addrs) in dns_config.pinned_socket_addrs() {
+            builder = builder.resolve_to_addrs(host, &addrs);
+        }
+        let resolver = dns_config
+            .custom_resolver_required()
+            .then(|| DnsResolver::new(&dns_config));
+        if let Some(resolver) = &resolver {
+            builder = builder.dns_resolver(Arc::new(resolver.clone()));
+        }
+
         let client = builder
             .build()
             .map_err(|err| HttpBuildError::ClientBuilder(err.to_string()))?;
-        Ok(Self { client })
+        Ok(Self { client, resolver })
     }
 
     /// Sends the request and returns the response, mapping a non-success status code to
@@ -76,7 +146,10 @@ impl HttpClient {
             .headers(request.headers().clone())
             .body(request.body().to_vec());
 
-        let res = req_builder.send().map_err(from_reqwest_error)?;
+        let res = req_builder
+            .send()
+            .map_err(from_reqwest_error)
+            .inspect_err(|err| self.invalidate_dns_cache(err))?;
 
         if res.status().is_success() {
             try_build_response(res)
@@ -89,6 +162,38 @@ impl HttpClient {
             Err(HttpResponseError::UnsuccessfulResponse { status_code, body })
         }
     }
+
+    /// Drops the cached DNS entries when the error suggests the resolved addresses are stale.
+    fn invalidate_dns_cache(&self, err: &HttpResponseError) {
+        if let Some(resolver) = &self.resolver
+            && matches!(
+                err,
+                HttpResponseError::ConnectError(_)
+                    | HttpResponseError::TimeoutError(_)
+                    | HttpResponseError::DnsError(_)
+            )
+        {
+            resolver.invalidate();
+        }
+    }
+}
+
+#[cfg(any(target_os = "linux", target_os = "macos"))]
+fn bind_interface(
+    builder: ClientBuilder,
+    interface: &str,
+) -> Result<ClientBuilder, HttpBuildError> {
+    Ok(builder.interface(interface))
+}
+
+#[cfg(not(any(target_os = "linux", target_os = "macos")))]
+fn bind_interface(
+    _builder: ClientBuilder,
+    interface: &str,
+) -> Result<ClientBuilder, HttpBuildError> {
+    Err(HttpBuildError::ClientBuilder(format!(
+        "binding connections to the interface '{interface}' is not supported on this platform"
+    )))
 }
 
 /// Errors that can occur while sending a request or processing its response.
@@ -308,6 +413,46 @@ fn certs_from_file(path: &Path) -> Result<Vec<Certificate>, HttpBuildError> {
     Ok(certs)
 }
 
+/// Returns the DER encoding of the certificates in the provided `ca_bundle_file` and
+/// `ca_bundle_dir` paths.
+fn cert_ders_from_paths(
+    ca_bundle_file: &Path,
+    ca_bundle_dir: &Path,
+) -> Result<Vec<CertificateDer<'static>>, HttpBuildError> {
+    let mut paths = cert_paths_from_dir(ca_bundle_dir)?;
+    if !ca_bundle_file.as_os_str().is_empty() {
+        paths.insert(0, ca_bundle_file.to_path_buf());
+    }
+    let mut certs = Vec::new();
+    for path in paths {
+        for cert in
+            CertificateDer::pem_file_iter(&path).map_err(|err| certificate_error(&path, err))?
+        {
+            certs.push(cert.map_err(|err| certificate_error(&path, err))?);
+        }
+    }
+    Ok(certs)
+}
+
+/// Loads the client certificate chain and its private key from the provided PEM files.
+fn client_identity(cert_file: &Path, key_file: &Path) -> Result<Identity, HttpBuildError> {
+    let mut pem = std::fs::read(cert_file).map_err(|err| certificate_error(cert_file, err))?;
+    pem.extend(std::fs::read(key_file).map_err(|err| certificate_error(key_file, err))?);
+    Identity::from_pem(&pem).map_err(|err| certificate_error(cert_file, err))
+}
+
+/// Returns the DER encoding of the client certificate chain and private key in the provided PEM
+/// files.
+fn client_auth_ders(
+    cert_file: &Path,
+    key_file: &Path,
+) -> Result<(Vec<CertificateDer<'static>>, PrivateKeyDer<'static>), HttpBuildError> {
+    let cert_chain = cert_ders_from_paths(cert_file, Path::new(""))?;
+    let key =
+        PrivateKeyDer::from_pem_file(key_file).map_err(|err| certificate_error(key_file, err))?;
+    Ok((cert_chain, key))
+}
+
 /// Returns all paths to be considered to load certificates under the provided directory path.
 pub fn cert_paths_from_dir(dir_path: &Path) -> Result<Vec<PathBuf>, HttpBuildError> {
     if dir_path.as_os_str().is_empty() {
@@ -348,7 +493,8 @@ fn path_has_cert_extension(path: &Path) -> bool {
 #[cfg(test)]
 pub(crate) mod tests {
     use super::*;
-    use crate::http::config::ProxyConfig;
+    use crate::http::config::{EgressConfig, ProxyConfig, TlsConfig};
+    use crate::http::pinning::{PinnedHost, PublicKeyPin};
     use assert_matches::assert_matches;
     use async_trait::async_trait;
     use http::StatusCode;
@@ -421,6 +567,84 @@ pub(crate) mod tests {
         assert_eq!(resp.text().unwrap(), expected_response.to_string())
     }
 
+    #[test]
+    fn test_http_client_egress_source_address() {
+        let mock_server = MockServer::start();
+        let mock = mock_server.mock(|when, then| {
+            when.any_request();
+            then.status(200);
+        });
+        let egress = EgressConfig {
+            source_address: Some("127.0.0.1".parse().unwrap()),
+            ..Default::default()
+        };
+        let http_config = HttpConfig::new(
+            Duration::from_secs(3),
+            Duration::from_secs(3),
+            Default::default(),
+        )
+        .with_egress(egress);
+        let http_client = HttpClient::new(http_config).unwrap();
+
+        let resp = http_client
+            .client
+            .get(mock_server.url("/").as_str())
+            .send()
+            .unwrap();
+        mock.assert();
+        assert_eq!(resp.status(), StatusCode::OK.as_u16());
+    }
+
+    #[test]
+    fn test_http_client_tls() {
+        let rcgen::CertifiedKey { cert, signing_key } =
+            rcgen::generate_simple_self_signed(vec!["localhost".to_string()]).unwrap();
+        let dir = tempdir().unwrap();
+        let cert_file = dir.path().join("client.pem");
+        let key_file = dir.path().join("client.key");
+        std::fs::write(&cert_file, cert.pem()).unwrap();
+        std::fs::write(&key_file, signing_key.serialize_pem()).unwrap();
+        let tls = TlsConfig {
+            ca_bundle_file: cert_file.clone(),
+            client_cert_file: cert_file,
+            client_key_file: key_file,
+            min_version: Some(TlsVersion::Tls13),
+            ..Default::default()
+        };
+        let pinned_host = PinnedHost::new("localhost", vec![PublicKeyPin::from_spki(b"spki")]);
+
+        HttpClient::new(HttpConfig::default().with_tls(tls.clone())).unwrap();
+        HttpClient::new(
+            HttpConfig::default()
+                .with_tls(tls.clone())
+                .with_pinned_host(pinned_host.clone()),
+        )
+        .unwrap();
+
+        let insecure = TlsConfig {
+            insecure_skip_verify: true,
+            ..Default::default()
+        };
+        HttpClient::new(HttpConfig::default().with_tls(insecure.clone())).unwrap();
+        assert_matches!(
+            HttpClient::new(
+                HttpConfig::default()
+                    .with_tls(insecure)
+                    .with_pinned_host(pinned_host)
+            ),
+            Err(HttpBuildError::ClientBuilder(_))
+        );
+
+        let missing_key = TlsConfig {
+            client_key_file: dir.path().join("missing.key"),
+            ..tls
+        };
+        assert_matches!(
+            HttpClient::new(HttpConfig::default().with_tls(missing_key)),
+            Err(HttpBuildError::CertificateError { .. })
+        );
+    }
+
     #[test]
     fn test_certs_from_paths_no_certificates() {
         let ca_bundle_file = PathBuf::default();
@@ -1,22 +1,30 @@
 //! Configuration types for the HTTP client (timeouts, proxy, and CA certificates).
 
+use super::dns::DnsConfig;
+use super::pinning::PinnedHost;
 use http::Uri;
 use serde::{Deserialize, Deserializer, Serialize, Serializer};
 use std::env::{self, VarError};
 use std::fmt::Display;
+use std::net::IpAddr;
 use std::path::{Path, PathBuf};
 use std::time::Duration;
 
 const DEFAULT_CLIENT_TIMEOUT: Duration = Duration::from_secs(30);
 
 /// Configuration for building an [`HttpClient`](super::client::HttpClient): timeouts, proxy
-/// settings, and whether to capture TLS info.
+/// settings, DNS resolution policy, certificate pinning, TLS settings and whether to capture TLS
+/// info.
 #[derive(Clone)]
 pub struct HttpConfig {
     pub(crate) timeout: Duration,
     pub(crate) conn_timeout: Duration,
     pub(crate) proxy: ProxyConfig,
     pub(crate) tls_info: bool,
+    pub(crate) dns: DnsConfig,
+    pub(crate) pinned_host: Option<PinnedHost>,
+    pub(crate) egress: EgressConfig,
+    pub(crate) tls: TlsConfig,
 }
 impl Default for HttpConfig {
     fn default() -> Self {
@@ -25,6 +33,10 @@ impl Default for HttpConfig {
             conn_timeout: DEFAULT_CLIENT_TIMEOUT,
             proxy: ProxyConfig::default(),
             tls_info: false,
+            dns: DnsConfig::default(),
+            pinned_host: None,
+            egress: EgressConfig::default(),
+            tls: TlsConfig::default(),
         }
     }
 }
@@ -38,6 +50,19 @@ impl HttpConfig {
             conn_timeout,
             proxy,
             tls_info: false,
+            dns: DnsConfig::default(),
+            pinned_host: None,
+            egress: EgressConfig::default(),
+            tls: TlsConfig::default(),
+        }
+    }
+    /// Returns a copy of this config with the given request `timeout` and connection
+    /// `conn_timeout`.
+    pub fn with_timeouts(self, timeout: Duration, conn_timeout: Duration) -> Self {
+        Self {
+            timeout,
+            conn_timeout,
+            ..self
         }
     }
     /// Returns a copy of this config with TLS info capture enabled.
@@ -47,7 +72,79 @@ impl HttpConfig {
             ..self
         }
     }
+    /// Returns a copy of this config with the provided DNS resolution policy.
+    pub fn with_dns(self, dns: DnsConfig) -> Self {
+        Self { dns, ..self }
+    }
+    /// Returns a copy of this config rejecting certificates of the pinned host that don't match
+    /// its pins.
+    pub fn with_pinned_host(self, pinned_host: PinnedHost) -> Self {
+        Self {
+            pinned_host: Some(pinned_host),
+            ..self
+        }
+    }
+    /// Returns a copy of this config binding the connections as `egress` sets.
+    pub fn with_egress(self, egress: EgressConfig) -> Self {
+        Self { egress, ..self }
+    }
+    /// Returns a copy of this config with the provided TLS settings.
+    pub fn with_tls(self, tls: TlsConfig) -> Self {
+        Self { tls, ..self }
+    }
 }
+
+/// Local end of the outbound connections, for multi-homed hosts where management traffic must
+/// leave through a dedicated interface.
+#[derive(Debug, Deserialize, PartialEq, Clone, Default)]
+pub struct EgressConfig {
+    /// Network interface the connections are bound to. Binding to a VRF device routes them
+    /// through the VRF. Only supported on Linux and macOS.
+    #[serde(default)]
+    pub interface: Option<String>,
+    /// Local address the connections are bound to.
+    #[serde(default)]
+    pub source_address: Option<IpAddr>,
+}
+
+/// TLS settings of the connections, for networks where the server certificate is issued by a
+/// private CA or client certificates are required.
+#[derive(Debug, Deserialize, PartialEq, Clone, Default)]
+#[serde(default)]
+pub struct TlsConfig {
+    /// PEM file with the CA certificates trusted besides the platform's certificate store.
+    pub ca_bundle_file: PathBuf,
+    /// PEM file with the client certificate chain presented to the server (mTLS).
+    pub client_cert_file: PathBuf,
+    /// PEM file with the private key of the client certificate.
+    pub client_key_file: PathBuf,
+    /// Accept any server certificate. Insecure, only meant for testing environments.
+    pub insecure_skip_verify: bool,
+    /// Lowest TLS version accepted. Defaults to TLS 1.2.
+    pub min_version: Option<TlsVersion>,
+}
+
+impl TlsConfig {
+    /// Returns the client certificate and key files, if a client certificate is configured.
+    pub fn client_identity_files(&self) -> Option<(&Path, &Path)> {
+        (!self.client_cert_file.as_os_str().is_empty()).then_some((
+            self.client_cert_file.as_path(),
+            self.client_key_file.as_path(),
+        ))
+    }
+}
+
+/// TLS protocol versions that can be set as the minimum accepted.
+#[derive(Debug, Deserialize, PartialEq, Clone, Copy)]
+pub enum TlsVersion {
+    /// TLS 1.2.
+    #[serde(rename = "1.2")]
+    Tls12,
+    /// TLS 1.3.
+    #[serde(rename = "1.3")]
+    Tls13,
+}
+
 const HTTP_PROXY_ENV_NAME: &str = "HTTP_PROXY";
 const HTTPS_PROXY_ENV_NAME: &str = "HTTPS_PROXY";
 
@@ -0,0 +1,379 @@
+//! DNS resolution policy for the HTTP client: a cache of resolved addresses kept for the TTL of
+//! their DNS records and invalidated on connection errors, static host to address pinning and IP
+//! family preference.
+//!
+//! Flaky resolvers in edge environments make every request pay for (and sometimes fail on) a
+//! lookup. Caching the resolved addresses for as long as their records are valid avoids that,
+//! while dropping the cache on connection errors forces a fresh lookup as soon as the cached
+//! addresses stop working. The system resolver doesn't report the TTL of the records, so the
+//! hosts are resolved querying the name servers of the system configuration when the cache is
+//! enabled.
+//!
+//! The client connects using Happy Eyeballs: the family of the first resolved address is tried
+//! first and the other family is raced shortly after, so ordering the addresses according to the
+//! [`IpPreference`] is enough to prefer one family without breaking IPv6-only or IPv4-only hosts.
+
+use duration_str::deserialize_option_duration;
+use hickory_resolver::TokioResolver;
+use hickory_resolver::config::LookupIpStrategy;
+use reqwest::dns::{Addrs, Name, Resolve, Resolving};
+use serde::Deserialize;
+use std::collections::HashMap;
+use std::fmt::{Debug, Formatter};
+use std::io;
+use std::net::{IpAddr, SocketAddr, ToSocketAddrs};
+use std::sync::{Arc, Mutex, OnceLock};
+use std::time::{Duration, Instant};
+use tracing::{debug, trace};
+
+/// IP family tried first when a host resolves to both IPv4 and IPv6 addresses.
+#[derive(Debug, Default, Deserialize, Clone, Copy, PartialEq)]
+#[serde(rename_all = "lowercase")]
+pub enum IpPreference {
+    /// Keep the order returned by the system resolver.
+    #[default]
+    Any,
+    /// Try IPv4 addresses first.
+    Ipv4,
+    /// Try IPv6 addresses first.
+    Ipv6,
+}
+
+impl IpPreference {
+    /// Moves the addresses of the preferred family first, keeping the relative order otherwise.
+    pub fn sort(&self, addrs: &mut [SocketAddr]) {
+        match self {
+            Self::Any => {}
+            Self::Ipv4 => addrs.sort_by_key(|addr| !addr.is_ipv4()),
+            Self::Ipv6 => addrs.sort_by_key(|addr| !addr.is_ipv6()),
+        }
+    }
+}
+
+/// DNS resolution settings for an HTTP client.
+#[derive(Debug, Default, Deserialize, Clone, PartialEq)]
+#[serde(default)]
+pub struct DnsConfig {
+    /// Whether resolved addresses are cached for the TTL of their records.
+    pub cache: bool,
+    /// Maximum time resolved addresses are cached for, even if their records are valid longer.
+    #[serde(deserialize_with = "deserialize_option_duration")]
+    pub max_cache_ttl: Option<Duration>,
+    /// Hosts resolved to a fixed set of addresses, bypassing DNS altogether.
+    pub static_addresses: HashMap<String, Vec<IpAddr>>,
+    /// IP family tried first.
+    pub ip_preference: IpPreference,
+}
+
+impl DnsConfig {
+    /// Returns true if resolved addresses should be cached.
+    pub fn cache_enabled(&self) -> bool {
+        self.cache && self.max_cache_ttl != Some(Duration::ZERO)
+    }
+
+    /// Returns true if the system resolver results need to go through a [`DnsResolver`].
+    pub fn custom_resolver_required(&self) -> bool {
+        self.cache_enabled() || self.ip_preference != IpPreference::Any
+    }
+
+    /// Returns the pinned addresses as socket addresses, sorted by IP preference. The port is `0`
+    /// so the client uses the one from the url (or the conventional one for the scheme).
+    pub fn pinned_socket_addrs(&self) -> impl Iterator<Item = (&str, Vec<SocketAddr>)> {
+        self.static_addresses.iter().map(|(host, ips)| {
+            let mut addrs: Vec<_> = ips.iter().map(|ip| SocketAddr::new(*ip, 0)).collect();
+            self.ip_preference.sort(&mut addrs);
+            (host.as_str(), addrs)
+        })
+    }
+}
+
+/// Addresses a host resolves to and the time their records are valid for.
+struct Resolved {
+    addrs: Vec<SocketAddr>,
+    ttl: Duration,
+}
+
+struct CachedAddrs {
+    addrs: Vec<SocketAddr>,
+    expires_at: Instant,
+}
+
+/// [`Resolve`] implementation ordering the resolved addresses by [`IpPreference`] and, if the
+/// cache is enabled, caching them for the TTL of their records capped by the maximum cache TTL.
+///
+/// Clones share the same cache, so the client holding the resolver can [invalidate](Self::invalidate)
+/// it when a connection fails.
+#[derive(Clone)]
+pub struct DnsResolver {
+    cache: bool,
+    max_cache_ttl: Option<Duration>,
+    ip_preference: IpPreference,
+    cached_addrs: Arc<Mutex<HashMap<String, CachedAddrs>>>,
+    /// Resolver reporting the TTL of the records, built on first use.
+    records_resolver: Arc<OnceLock<TokioResolver>>,
+}
+
+impl Debug for DnsResolver {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("DnsResolver")
+            .field("cache", &self.cache)
+            .field("max_cache_ttl", &self.max_cache_ttl)
+            .field("ip_preference", &self.ip_preference)
+            .finish_non_exhaustive()
+    }
+}
+
+impl DnsResolver {
+    /// Builds a resolver sorting the addresses by the `ip_preference` of `config` and caching them
+    /// as it sets.
+    pub fn new(config: &DnsConfig) -> Self {
+        Self {
+            cache: config.cache_enabled(),
+            max_cache_ttl: config.max_cache_ttl,
+            ip_preference: config.ip_preference,
+            cached_addrs: Arc::default(),
+            records_resolver: Arc::default(),
+        }
+    }
+
+    /// Drops every cached entry so the next request resolves its host again.
+    pub fn invalidate(&self) {
+        let mut cached_addrs = self.cached_addrs.lock().expect("DNS cache lock poisoned");
+        if !cached_addrs.is_empty() {
+            debug!("Invalidating cached DNS entries");
+            cached_addrs.clear();
+        }
+        if let Some(records_resolver) = self.records_resolver.get() {
+            records_resolver.clear_cache();
+        }
+    }
+
+    fn cached(&self, host: &str) -> Option<Vec<SocketAddr>> {
+        let cached_addrs = self.cached_addrs.lock().expect("DNS cache lock poisoned");
+        cached_addrs
+            .get(host)
+            .filter(|entry| entry.expires_at > Instant::now())
+            .map(|entry| entry.addrs.clone())
+    }
+
+    /// Sorts the `resolved` addresses of `host` and caches them, if enabled, for the TTL of their
+    /// records capped by the maximum cache TTL.
+    fn store(&self, host: &str, resolved: Resolved) -> Vec<SocketAddr> {
+        let Resolved { mut addrs, ttl } = resolved;
+        self.ip_preference.sort(&mut addrs);
+        let ttl = self.max_cache_ttl.map_or(ttl, |max| ttl.min(max));
+        if self.cache && !ttl.is_zero() {
+            trace!(host, ?ttl, "Caching DNS entry");
+            let mut cached_addrs = self.cached_addrs.lock().expect("DNS cache lock poisoned");
+            cached_addrs.insert(
+                host.to_string(),
+                CachedAddrs {
+                    addrs: addrs.clone(),
+                    expires_at: Instant::now() + ttl,
+                },
+            );
+        }
+        addrs
+    }
+
+    /// Resolves `host` with the system resolver, or querying its records if the cache is enabled
+    /// since only these report their TTL.
+    async fn lookup(&self, host: &str) -> io::Result<Resolved> {
+        if !self.cache {
+            let host = host.to_string();
+            let addrs = tokio::task::spawn_blocking(move || system_lookup(&host))
+                .await
+                .map_err(io::Error::other)??;
+            return Ok(Resolved {
+                addrs,
+                ttl: Duration::ZERO,
+            });
+        }
+        let lookup = self
+            .records_resolver()?
+            .lookup_ip(host)
+            .await
+            .map_err(io::Error::other)?;
+        Ok(Resolved {
+            addrs: lookup.iter().map(|ip| SocketAddr::new(ip, 0)).collect(),
+            ttl: lookup
+                .valid_until()
+                .saturating_duration_since(Instant::now()),
+        })
+    }
+
+    /// Returns the resolver querying the name servers of the system configuration, building it
+    /// on first use.
+    fn records_resolver(&self) -> io::Result<TokioResolver> {
+        if let Some(records_resolver) = self.records_resolver.get() {
+            return Ok(records_resolver.clone());
+        }
+        let mut builder = TokioResolver::builder_tokio().map_err(io::Error::other)?;
+        // Both families are needed to apply the IP preference.
+        builder.options_mut().ip_strategy = LookupIpStrategy::Ipv4AndIpv6;
+        Ok(self
+            .records_resolver
+            .get_or_init(|| builder.build())
+            .clone())
+    }
+}
+
+/// Resolves `host` with the system resolver.
+fn system_lookup(host: &str) -> io::Result<Vec<SocketAddr>> {
+    Ok((host, 0).to_socket_addrs()?.collect())
+}
+
+impl Resolve for DnsResolver {
+    fn resolve(&self, name: Name) -> Resolving {
+        let resolver = self.clone();
+        Box::pin(async move {
+            let host = name.as_str();
+            let addrs = match resolver.cached(host) {
+                Some(addrs) => {
+                    trace!(host, "Using cached DNS entry");
+                    addrs
+                }
+                None => {
+                    let resolved = resolver.lookup(host).await?;
+                    resolver.store(host, resolved)
+                }
+            };
+            let addrs: Addrs = Box::new(addrs.into_iter());
+            Ok(addrs)
+        })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+    use std::net::{Ipv4Addr, Ipv6Addr};
+
+    fn addrs(last_octet: u8) -> Vec<SocketAddr> {
+        vec![SocketAddr::new(
+            IpAddr::V4(Ipv4Addr::new(10, 0, 0, last_octet)),
+            0,
+        )]
+    }
+
+    fn resolved(last_octet: u8, ttl: Duration) -> Resolved {
+        Resolved {
+            addrs: addrs(last_octet),
+            ttl,
+        }
+    }
+
+    fn cache_config(max_cache_ttl: Option<Duration>) -> DnsConfig {
+        DnsConfig {
+            cache: true,
+            max_cache_ttl,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_cache_reuses_entries_within_their_ttl() {
+        let resolver = DnsResolver::new(&cache_config(None));
+
+        assert_eq!(
+            resolver.store("host", resolved(1, Duration::from_secs(60))),
+            addrs(1)
+        );
+        assert_eq!(resolver.cached("host"), Some(addrs(1)));
+    }
+
+    #[rstest]
+    #[case::zero_ttl(cache_config(None), Duration::ZERO)]
+    #[case::cache_disabled(DnsConfig::default(), Duration::from_secs(60))]
+    fn test_entries_not_cached(#[case] config: DnsConfig, #[case] ttl: Duration) {
+        let resolver = DnsResolver::new(&config);
+
+        assert_eq!(resolver.store("host", resolved(1, ttl)), addrs(1));
+        assert_eq!(resolver.cached("host"), None);
+    }
+
+    #[test]
+    fn test_max_cache_ttl_caps_the_record_ttl() {
+        let resolver = DnsResolver::new(&cache_config(Some(Duration::from_millis(10))));
+
+        resolver.store("host", resolved(1, Duration::from_secs(3600)));
+        assert_eq!(resolver.cached("host"), Some(addrs(1)));
+        std::thread::sleep(Duration::from_millis(20));
+        assert_eq!(resolver.cached("host"), None);
+    }
+
+    #[test]
+    fn test_invalidate_forces_resolution() {
+        let resolver = DnsResolver::new(&cache_config(None));
+
+        resolver.store("host", resolved(1, Duration::from_secs(60)));
+        resolver.clone().invalidate();
+        assert_eq!(resolver.cached("host"), None);
+    }
+
+    #[rstest]
+    #[case::any(IpPreference::Any, vec!["10.0.0.1:0", "[::1]:0", "10.0.0.2:0"])]
+    #[case::ipv4(IpPreference::Ipv4, vec!["10.0.0.1:0", "10.0.0.2:0", "[::1]:0"])]
+    #[case::ipv6(IpPreference::Ipv6, vec!["[::1]:0", "10.0.0.1:0", "10.0.0.2:0"])]
+    fn test_ip_preference_sort(#[case] preference: IpPreference, #[case] expected: Vec<&str>) {
+        let mut addrs = vec![
+            SocketAddr::new(IpAddr::V4(Ipv4Addr::new(10, 0, 0, 1)), 0),
+            SocketAddr::new(IpAddr::V6(Ipv6Addr::LOCALHOST), 0),
+            SocketAddr::new(IpAddr::V4(Ipv4Addr::new(10, 0, 0, 2)), 0),
+        ];
+        preference.sort(&mut addrs);
+
+        let expected: Vec<SocketAddr> = expected.iter().map(|a| a.parse().unwrap()).collect();
+        assert_eq!(addrs, expected);
+    }
+
+    #[test]
+    fn test_ipv6_only_resolution_with_ipv4_preference() {
+        let resolver = DnsResolver::new(&DnsConfig {
+            ip_preference: IpPreference::Ipv4,
+            ..Default::default()
+        });
+        let v6_only = vec![SocketAddr::new(IpAddr::V6(Ipv6Addr::LOCALHOST), 0)];
+
+        assert_eq!(
+            resolver.store(
+                "host",
+                Resolved {
+                    addrs: v6_only.clone(),
+                    ttl: Duration::ZERO
+                }
+            ),
+            v6_only
+        );
+    }
+
+    #[test]
+    fn test_dns_config_deserialization() {
+        let config: DnsConfig = serde_saphyr::from_str(
+            r#"
+cache: true
+max_cache_ttl: 30s
+static_addresses:
+  opamp.service.newrelic.com: ["10.0.0.1", "::1"]
+ip_preference: ipv6
+"#,
+        )
+        .unwrap();
+
+        assert!(config.cache_enabled());
+        assert_eq!(config.max_cache_ttl, Some(Duration::from_secs(30)));
+        let pinned: Vec<_> = config.pinned_socket_addrs().collect();
+        assert_eq!(pinned.len(), 1);
+        assert_eq!(pinned[0].0, "opamp.service.newrelic.com");
+        assert_eq!(
+            pinned[0].1,
+            vec![
+                "[::1]:0".parse::<SocketAddr>().unwrap(),
+                "10.0.0.1:0".parse().unwrap()
+            ]
+        );
+        assert!(config.custom_resolver_required());
+        assert!(!DnsConfig::default().cache_enabled());
+    }
+}
@@ -0,0 +1,174 @@
+//! Opt-in logging of the exchanges with Fleet Control and the OCI registries, to debug server-side
+//! issues.
+//!
+//! Each logged exchange reports a request id, the response status, the latency and the size of the
+//! payloads. Bodies are never logged. The request id is also sent in the `x-request-id` header of
+//! the OpAMP requests, so the exchange can be looked up in the server logs.
+//!
+//! ```yaml
+//! http_exchange_log:
+//!   enabled: true
+//!   sample_every: 10 # Logs one of every 10 exchanges
+//! ```
+
+use aws_lc_rs::rand::{SecureRandom, SystemRandom};
+use http::StatusCode;
+use serde::Deserialize;
+use std::fmt::Display;
+use std::num::NonZeroU64;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::Instant;
+use tracing::info;
+
+/// Header carrying the request id of the logged OpAMP requests.
+pub const REQUEST_ID_HEADER: &str = "x-request-id";
+
+const DEFAULT_SAMPLE_EVERY: NonZeroU64 = NonZeroU64::MIN;
+
+/// Configuration of the exchange logging.
+#[derive(Debug, Deserialize, PartialEq, Clone, Copy)]
+pub struct ExchangeLogConfig {
+    /// Log the exchanges. Disabled by default.
+    #[serde(default)]
+    pub enabled: bool,
+    /// Log one of every `sample_every` exchanges. Defaults to logging all of them.
+    #[serde(default = "default_sample_every")]
+    pub sample_every: NonZeroU64,
+}
+
+impl Default for ExchangeLogConfig {
+    fn default() -> Self {
+        Self {
+            enabled: false,
+            sample_every: DEFAULT_SAMPLE_EVERY,
+        }
+    }
+}
+
+fn default_sample_every() -> NonZeroU64 {
+    DEFAULT_SAMPLE_EVERY
+}
+
+/// Decides which exchanges are logged.
+#[derive(Debug, Default)]
+pub struct ExchangeLogger {
+    config: ExchangeLogConfig,
+    exchanges: AtomicU64,
+}
+
+impl ExchangeLogger {
+    /// Creates a logger with the given configuration.
+    pub fn new(config: ExchangeLogConfig) -> Self {
+        Self {
+            config,
+            exchanges: AtomicU64::new(0),
+        }
+    }
+
+    /// Starts an exchange with `url`, returning it when it is sampled to be logged.
+    pub fn start(&self, url: impl Display) -> Option<Exchange> {
+        if !self.config.enabled {
+            return None;
+        }
+        let exchange = self.exchanges.fetch_add(1, Ordering::Relaxed);
+        if exchange % self.config.sample_every.get() != 0 {
+            return None;
+        }
+        Some(Exchange {
+            request_id: request_id(exchange),
+            url: url.to_string(),
+            started: Instant::now(),
+        })
+    }
+}
+
+/// Result of a logged exchange.
+#[derive(Debug)]
+pub enum Outcome<'a> {
+    /// A response with the given status was received.
+    Status(StatusCode),
+    /// The exchange succeeded. Used when the client doesn't expose the response status.
+    Succeeded,
+    /// The exchange failed without a response.
+    Failed(&'a dyn Display),
+}
+
+/// An exchange sampled to be logged.
+#[derive(Debug)]
+pub struct Exchange {
+    request_id: String,
+    url: String,
+    started: Instant,
+}
+
+impl Exchange {
+    /// Id identifying the request in the logs.
+    pub fn request_id(&self) -> &str {
+        &self.request_id
+    }
+
+    /// Logs the finished exchange. Sizes are in bytes, `None` when unknown.
+    pub fn finish(self, outcome: Outcome, request_size: Option<u64>, response_size: Option<u64>) {
+        let latency_ms = self.started.elapsed().as_millis() as u64;
+        let (status, error) = match outcome {
+            Outcome::Status(status) => (Some(status.as_u16()), None),
+            Outcome::Succeeded => (None, None),
+            Outcome::Failed(err) => (None, Some(err.to_string())),
+        };
+        info!(
+            request_id = %self.request_id,
+            url = %self.url,
+            status,
+            error = error.as_deref(),
+            latency_ms,
+            request_size,
+            response_size,
+            "HTTP exchange"
+        );
+    }
+}
+
+/// Random request id, falling back to the exchange number if no random bytes are available.
+fn request_id(exchange: u64) -> String {
+    let mut bytes = [0u8; 8];
+    match SystemRandom::new().fill(&mut bytes) {
+        Ok(()) => format!("{:016x}", u64::from_be_bytes(bytes)),
+        Err(_) => format!("{exchange:016x}"),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_exchange_log_sampling() {
+        assert!(
+            ExchangeLogger::new(ExchangeLogConfig::default())
+                .start("https://example.com")
+                .is_none()
+        );
+
+        let logger = ExchangeLogger::new(ExchangeLogConfig {
+            enabled: true,
+            sample_every: NonZeroU64::new(3).unwrap(),
+        });
+        let sampled = (0..6)
+            .map(|_| logger.start("https://example.com").is_some())
+            .collect::<Vec<_>>();
+        assert_eq!(sampled, vec![true, false, false, true, false, false]);
+    }
+
+    #[test]
+    fn test_exchange_log_config() {
+        let config: ExchangeLogConfig = serde_saphyr::from_str("enabled: true").unwrap();
+        assert_eq!(
+            config,
+            ExchangeLogConfig {
+                enabled: true,
+                sample_every: NonZeroU64::MIN,
+            }
+        );
+        assert!(serde_saphyr::from_str::<ExchangeLogConfig>("sample_every: 0").is_err());
+    }
+}
@@ -0,0 +1,394 @@
+//! Certificate pinning for the HTTP client: the issuance path of the certificate presented by a
+//! host must contain a certificate whose public key matches one of the configured pins, besides
+//! being valid for the platform's certificate store.
+//!
+//! Pinning protects against a compromised (or rogue) CA and TLS interception: a CA-valid
+//! certificate issued for the host is still rejected unless its key, or the key of one of its
+//! issuers, is pinned. Only the certificates the end-entity certificate was actually issued by,
+//! checking the signatures, are considered: a pinned certificate appended to an unrelated chain
+//! doesn't match. Pins use the HPKP format, `sha256/<base64 SHA-256 of the DER-encoded
+//! SubjectPublicKeyInfo>`, which can be obtained with:
+//!
+//! ```sh
+//! openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der \
+//!   | openssl dgst -sha256 -binary | base64
+//! ```
+
+use super::config::TlsVersion;
+use aws_lc_rs::digest::{SHA256, SHA256_OUTPUT_LEN, digest};
+use aws_lc_rs::signature::{
+    ECDSA_P256_SHA256_ASN1, ECDSA_P256_SHA384_ASN1, ECDSA_P384_SHA256_ASN1, ECDSA_P384_SHA384_ASN1,
+    ED25519, RSA_PKCS1_2048_8192_SHA256, RSA_PKCS1_2048_8192_SHA384, RSA_PKCS1_2048_8192_SHA512,
+    UnparsedPublicKey, VerificationAlgorithm,
+};
+use base64::{Engine, prelude::BASE64_STANDARD};
+use rustls::client::danger::{HandshakeSignatureValid, ServerCertVerified, ServerCertVerifier};
+use rustls::pki_types::{CertificateDer, PrivateKeyDer, ServerName, UnixTime};
+use rustls::version::TLS13;
+use rustls::{
+    CertificateError, ClientConfig, DigitallySignedStruct, Error as TlsError, SignatureScheme,
+    SupportedProtocolVersion,
+};
+use rustls_platform_verifier::Verifier;
+use serde::{Deserialize, Deserializer};
+use std::fmt::{Display, Formatter};
+use std::sync::Arc;
+use tracing::warn;
+use x509_parser::oid_registry::{
+    OID_PKCS1_SHA256WITHRSA, OID_PKCS1_SHA384WITHRSA, OID_PKCS1_SHA512WITHRSA,
+    OID_SIG_ECDSA_WITH_SHA256, OID_SIG_ECDSA_WITH_SHA384, OID_SIG_ED25519, Oid,
+};
+use x509_parser::prelude::{FromDer, X509Certificate};
+
+const PIN_PREFIX: &str = "sha256/";
+
+/// Error returned when a public key pin cannot be parsed.
+#[derive(thiserror::Error, Debug)]
+#[error("invalid public key pin '{0}': expected 'sha256/<base64 encoded SHA-256 digest>'")]
+pub struct InvalidPinError(String);
+
+/// SHA-256 digest of a DER-encoded SubjectPublicKeyInfo.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct PublicKeyPin([u8; SHA256_OUTPUT_LEN]);
+
+impl PublicKeyPin {
+    /// Returns the pin of the given DER-encoded SubjectPublicKeyInfo.
+    pub fn from_spki(spki: &[u8]) -> Self {
+        let mut pin = [0; SHA256_OUTPUT_LEN];
+        pin.copy_from_slice(digest(&SHA256, spki).as_ref());
+        Self(pin)
+    }
+
+    /// Returns the pin of the public key of the given certificate.
+    fn from_certificate(cert: &X509Certificate<'_>) -> Self {
+        Self::from_spki(cert.public_key().raw)
+    }
+}
+
+impl TryFrom<&str> for PublicKeyPin {
+    type Error = InvalidPinError;
+
+    fn try_from(s: &str) -> Result<Self, Self::Error> {
+        let pin = s
+            .strip_prefix(PIN_PREFIX)
+            .and_then(|encoded| BASE64_STANDARD.decode(encoded).ok())
+            .and_then(|decoded| <[u8; SHA256_OUTPUT_LEN]>::try_from(decoded).ok())
+            .ok_or_else(|| InvalidPinError(s.to_string()))?;
+        Ok(Self(pin))
+    }
+}
+
+impl<'de> Deserialize<'de> for PublicKeyPin {
+    fn deserialize<D>(deserializer: D) -> Result<Self, D::Error>
+    where
+        D: Deserializer<'de>,
+    {
+        let s = String::deserialize(deserializer)?;
+        Self::try_from(s.as_str()).map_err(serde::de::Error::custom)
+    }
+}
+
+impl Display for PublicKeyPin {
+    fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{PIN_PREFIX}{}", BASE64_STANDARD.encode(self.0))
+    }
+}
+
+/// Public keys the certificate chain of `host` must contain one of.
+#[derive(Debug, Clone, PartialEq)]
+pub struct PinnedHost {
+    host: String,
+    pins: Vec<PublicKeyPin>,
+}
+
+impl PinnedHost {
+    /// Pins the certificates presented by `host` (a domain name or IP address) to `pins`.
+    pub fn new(host: &str, pins: Vec<PublicKeyPin>) -> Self {
+        // IPv6 hosts are bracketed in urls, but not in TLS server names.
+        let host = host.trim_start_matches('[').trim_end_matches(']');
+        Self {
+            host: host.to_ascii_lowercase(),
+            pins,
+        }
+    }
+
+    /// Builds a TLS configuration verifying certificates with the platform verifier, trusting
+    /// `extra_roots` too, and enforcing the pins for this host. The `client_auth` certificate chain
+    /// and key, if any, are presented to servers requesting client certificates.
+    pub fn tls_config(
+        self,
+        extra_roots: Vec<CertificateDer<'static>>,
+        client_auth: Option<(Vec<CertificateDer<'static>>, PrivateKeyDer<'static>)>,
+        min_version: Option<TlsVersion>,
+    ) -> Result<ClientConfig, TlsError> {
+        let provider = Arc::new(rustls::crypto::aws_lc_rs::default_provider());
+        let verifier = PinningVerifier {
+            inner: Verifier::new_with_extra_roots(extra_roots, provider.clone())?,
+            pinned_host: self,
+        };
+        let versions: &[&SupportedProtocolVersion] = match min_version {
+            Some(TlsVersion::Tls13) => &[&TLS13],
+            Some(TlsVersion::Tls12) | None => rustls::DEFAULT_VERSIONS,
+        };
+        let builder = ClientConfig::builder_with_provider(provider)
+            .with_protocol_versions(versions)?
+            .dangerous()
+            .with_custom_certificate_verifier(Arc::new(verifier));
+        match client_auth {
+            Some((cert_chain, key)) => builder.with_client_auth_cert(cert_chain, key),
+            None => Ok(builder.with_no_client_auth()),
+        }
+    }
+
+    /// Returns true if the host is not pinned or the issuance path of `end_entity`, built from
+    /// the presented `intermediates`, contains a pinned public key.
+    fn allows(
+        &self,
+        server_name: &ServerName<'_>,
+        end_entity: &CertificateDer<'_>,
+        intermediates: &[CertificateDer<'_>],
+    ) -> bool {
+        if server_name.to_str().to_ascii_lowercase() != self.host {
+            return true;
+        }
+        issuance_path(end_entity, intermediates)
+            .iter()
+            .map(PublicKeyPin::from_certificate)
+            .any(|pin| self.pins.contains(&pin))
+    }
+}
+
+/// Returns `end_entity` followed by its issuers among `intermediates`, as long as each one signed
+/// the previous certificate. Presented certificates out of that path are left out.
+fn issuance_path<'a>(
+    end_entity: &'a CertificateDer<'_>,
+    intermediates: &'a [CertificateDer<'_>],
+) -> Vec<X509Certificate<'a>> {
+    let Ok((_, mut current)) = X509Certificate::from_der(end_entity.as_ref()) else {
+        return Vec::new();
+    };
+    let mut candidates: Vec<_> = intermediates
+        .iter()
+        .filter_map(|cert| X509Certificate::from_der(cert.as_ref()).ok())
+        .map(|(_, cert)| cert)
+        .collect();
+
+    let mut path = Vec::new();
+    loop {
+        let issuer = candidates
+            .iter()
+            .position(|candidate| is_issued_by(&current, candidate));
+        path.push(current);
+        match issuer {
+            Some(index) => current = candidates.swap_remove(index),
+            None => return path,
+        }
+    }
+}
+
+/// Returns true if `cert` names `issuer` as its issuer and is signed with its key.
+fn is_issued_by(cert: &X509Certificate<'_>, issuer: &X509Certificate<'_>) -> bool {
+    let issuer_key = &issuer.public_key().subject_public_key.data;
+    cert.issuer() == issuer.subject()
+        && signature_algorithms(&cert.signature_algorithm.algorithm)
+            .into_iter()
+            .any(|algorithm| {
+                UnparsedPublicKey::new(algorithm, issuer_key)
+                    .verify(cert.tbs_certificate.as_ref(), &cert.signature_value.data)
+                    .is_ok()
+            })
+}
+
+/// Returns the algorithms that can verify a signature of the given type. ECDSA signatures don't
+/// specify the curve of the key, so every supported curve is tried.
+fn signature_algorithms(signature: &Oid<'_>) -> Vec<&'static dyn VerificationAlgorithm> {
+    let algorithms: &[&'static dyn VerificationAlgorithm] = if *signature == OID_PKCS1_SHA256WITHRSA
+    {
+        &[&RSA_PKCS1_2048_8192_SHA256]
+    } else if *signature == OID_PKCS1_SHA384WITHRSA {
+        &[&RSA_PKCS1_2048_8192_SHA384]
+    } else if *signature == OID_PKCS1_SHA512WITHRSA {
+        &[&RSA_PKCS1_2048_8192_SHA512]
+    } else if *signature == OID_SIG_ECDSA_WITH_SHA256 {
+        &[&ECDSA_P256_SHA256_ASN1, &ECDSA_P384_SHA256_ASN1]
+    } else if *signature == OID_SIG_ECDSA_WITH_SHA384 {
+        &[&ECDSA_P384_SHA384_ASN1, &ECDSA_P256_SHA384_ASN1]
+    } else if *signature == OID_SIG_ED25519 {
+        &[&ED25519]
+    } else {
+        &[]
+    };
+    algorithms.to_vec()
+}
+
+/// [`ServerCertVerifier`] checking the pins of a [`PinnedHost`] once the platform verifier
+/// accepts the certificate chain.
+#[derive(Debug)]
+struct PinningVerifier {
+    inner: Verifier,
+    pinned_host: PinnedHost,
+}
+
+impl ServerCertVerifier for PinningVerifier {
+    fn verify_server_cert(
+        &self,
+        end_entity: &CertificateDer<'_>,
+        intermediates: &[CertificateDer<'_>],
+        server_name: &ServerName<'_>,
+        ocsp_response: &[u8],
+        now: UnixTime,
+    ) -> Result<ServerCertVerified, TlsError> {
+        let verified = self.inner.verify_server_cert(
+            end_entity,
+            intermediates,
+            server_name,
+            ocsp_response,
+            now,
+        )?;
+        if !self
+            .pinned_host
+            .allows(server_name, end_entity, intermediates)
+        {
+            warn!(
+                host = %self.pinned_host.host,
+                "The certificate chain of the server doesn't match any pinned public key"
+            );
+            return Err(TlsError::InvalidCertificate(
+                CertificateError::ApplicationVerificationFailure,
+            ));
+        }
+        Ok(verified)
+    }
+
+    fn verify_tls12_signature(
+        &self,
+        message: &[u8],
+        cert: &CertificateDer<'_>,
+        dss: &DigitallySignedStruct,
+    ) -> Result<HandshakeSignatureValid, TlsError> {
+        self.inner.verify_tls12_signature(message, cert, dss)
+    }
+
+    fn verify_tls13_signature(
+        &self,
+        message: &[u8],
+        cert: &CertificateDer<'_>,
+        dss: &DigitallySignedStruct,
+    ) -> Result<HandshakeSignatureValid, TlsError> {
+        self.inner.verify_tls13_signature(message, cert, dss)
+    }
+
+    fn supported_verify_schemes(&self) -> Vec<SignatureScheme> {
+        self.inner.supported_verify_schemes()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rcgen::{
+        BasicConstraints, CertificateParams, DnType, IsCa, Issuer, KeyPair,
+        generate_simple_self_signed,
+    };
+
+    /// Returns a CA certificate, signed by `parent` or self-signed, and the issuer to sign
+    /// certificates with it.
+    fn ca(
+        name: &str,
+        parent: Option<&Issuer<'static, KeyPair>>,
+    ) -> (CertificateDer<'static>, Issuer<'static, KeyPair>) {
+        let key = KeyPair::generate().unwrap();
+        let mut params = CertificateParams::new(Vec::<String>::new()).unwrap();
+        params.is_ca = IsCa::Ca(BasicConstraints::Unconstrained);
+        params.distinguished_name.push(DnType::CommonName, name);
+        let cert = match parent {
+            Some(parent) => params.signed_by(&key, parent),
+            None => params.self_signed(&key),
+        }
+        .unwrap();
+        (cert.der().clone(), Issuer::new(params, key))
+    }
+
+    fn leaf(issuer: &Issuer<'static, KeyPair>) -> CertificateDer<'static> {
+        let key = KeyPair::generate().unwrap();
+        CertificateParams::new(vec!["opamp.example.com".to_string()])
+            .unwrap()
+            .signed_by(&key, issuer)
+            .unwrap()
+            .der()
+            .clone()
+    }
+
+    fn pin(cert: &CertificateDer<'_>) -> PublicKeyPin {
+        let (_, cert) = X509Certificate::from_der(cert.as_ref()).unwrap();
+        PublicKeyPin::from_certificate(&cert)
+    }
+
+    #[test]
+    fn test_pin_round_trip() {
+        let pin = PublicKeyPin::from_spki(b"some key");
+        assert_eq!(
+            PublicKeyPin::try_from(pin.to_string().as_str()).unwrap(),
+            pin
+        );
+    }
+
+    #[test]
+    fn test_invalid_pins() {
+        let valid_digest = BASE64_STANDARD.encode([0u8; SHA256_OUTPUT_LEN]);
+        assert!(PublicKeyPin::try_from(format!("sha256/{valid_digest}").as_str()).is_ok());
+        // Missing prefix
+        assert!(PublicKeyPin::try_from(valid_digest.as_str()).is_err());
+        // Not base64
+        assert!(PublicKeyPin::try_from("sha256/not base64!").is_err());
+        // Wrong digest length
+        let short_digest = BASE64_STANDARD.encode([0u8; 20]);
+        assert!(PublicKeyPin::try_from(format!("sha256/{short_digest}").as_str()).is_err());
+    }
+
+    #[test]
+    fn test_pinned_host_allows() {
+        let pinned = generate_simple_self_signed(vec!["opamp.example.com".to_string()]).unwrap();
+        let other = generate_simple_self_signed(vec!["opamp.example.com".to_string()]).unwrap();
+        let pinned_host = PinnedHost::new(
+            "OpAMP.example.com",
+            vec![PublicKeyPin::from_spki(
+                &pinned.signing_key.public_key_der(),
+            )],
+        );
+        let server_name = ServerName::try_from("opamp.example.com").unwrap();
+
+        assert!(pinned_host.allows(&server_name, pinned.cert.der(), &[]));
+        // A different key is rejected, even if it is valid for the host.
+        assert!(!pinned_host.allows(&server_name, other.cert.der(), &[]));
+        // Other hosts are not pinned.
+        let other_name = ServerName::try_from("proxy.example.com").unwrap();
+        assert!(pinned_host.allows(&other_name, other.cert.der(), &[]));
+    }
+
+    #[test]
+    fn test_pinned_host_allows_pinned_issuers() {
+        let (root, root_issuer) = ca("Root CA", None);
+        let (intermediate, intermediate_issuer) = ca("Intermediate CA", Some(&root_issuer));
+        let end_entity = leaf(&intermediate_issuer);
+        let server_name = ServerName::try_from("opamp.example.com").unwrap();
+        let chain = [intermediate.clone(), root.clone()];
+
+        for pin in [pin(&root), pin(&intermediate), pin(&end_entity)] {
+            let pinned_host = PinnedHost::new("opamp.example.com", vec![pin]);
+            assert!(pinned_host.allows(&server_name, &end_entity, &chain));
+        }
+    }
+
+    #[test]
+    fn test_pinned_host_rejects_pinned_certificates_out_of_the_path() {
+        let (pinned_ca, _) = ca("Pinned CA", None);
+        let (other_ca, other_issuer) = ca("Other CA", None);
+        let pinned_host = PinnedHost::new("opamp.example.com", vec![pin(&pinned_ca)]);
+        let server_name = ServerName::try_from("opamp.example.com").unwrap();
+
+        // A chain issued by another CA with the pinned CA certificate appended.
+        let end_entity = leaf(&other_issuer);
+        assert!(!pinned_host.allows(&server_name, &end_entity, &[other_ca, pinned_ca]));
+    }
+}
@@ -3,6 +3,7 @@
 //! Provides the configuration types and the [`tracing_subscriber`] layers used to report logs,
 //! metrics and traces to stderr, files and OpenTelemetry endpoints.
 
+pub mod agent_logs;
 pub mod config;
 pub mod tracing;
 pub mod tracing_layers;
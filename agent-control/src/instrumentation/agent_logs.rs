@@ -0,0 +1,424 @@
+//! Tails the log files of the agents supervised by Agent Control and forwards their records
+//! through OpenTelemetry, reusing the endpoint and credentials (headers) of the
+//! self-instrumentation. It gives visibility of the agents even when they can't ship their own
+//! logs.
+//!
+//! ```yaml
+//! agent_logs:
+//!   poll_interval: 5s
+//!   files:
+//!     - path: /var/log/newrelic-infra/newrelic-infra.log
+//!       agent_id: nr-infra
+//!       multiline_start: '^time='
+//! ```
+//!
+//! Files are read from their end when Agent Control starts, and from the beginning again when
+//! they shrink (truncated or rotated). When `multiline_start` is set, lines not matching it are
+//! appended to the previous record, so stack traces are forwarded as a single record. The severity
+//! of each record is taken from the first level keyword (`info`, `warn`, `error`...) it contains.
+
+use crate::event::cancellation::CancellationMessage;
+use crate::event::channel::EventConsumer;
+use crate::http::client::HttpClient;
+use crate::http::config::HttpConfig;
+use crate::instrumentation::config::otel::OtelConfig;
+use crate::instrumentation::tracing_layers::otel::{OtelBuildError, OtelLayers};
+use crate::utils::thread_context::{NotStartedThreadContext, StartedThreadContext};
+use duration_str::deserialize_duration;
+use opentelemetry::KeyValue;
+use opentelemetry::logs::{AnyValue, LogRecord, Logger, LoggerProvider, Severity};
+use opentelemetry_sdk::Resource;
+use opentelemetry_sdk::logs::SdkLogger;
+use regex::Regex;
+use serde::{Deserialize, Deserializer};
+use std::fs::File;
+use std::io::{Read, Seek, SeekFrom};
+use std::path::PathBuf;
+use std::sync::LazyLock;
+use std::time::{Duration, SystemTime};
+use tracing::{debug, error, info, warn};
+use wrapper_with_default::WrapperWithDefault;
+
+const THREAD_NAME: &str = "agent logs tail";
+const SERVICE_NAME: &str = "agent-control-agent-logs";
+const LOGGER_NAME: &str = "agent-logs";
+const DEFAULT_POLL_INTERVAL: Duration = Duration::from_secs(5);
+/// Maximum amount of data read from a file on each poll, the rest is read on the following ones.
+const MAX_READ_BYTES: u64 = 1024 * 1024;
+
+const FILE_PATH_ATTRIBUTE_KEY: &str = "log.file.path";
+const AGENT_ID_ATTRIBUTE_KEY: &str = "agent.id";
+
+static SEVERITY_REGEX: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(r"(?i)\b(trace|debug|info|warn|warning|error|err|fatal|panic|critical)\b")
+        .expect("the severity regex should be valid")
+});
+
+/// Configuration of the agent log files to forward.
+#[derive(Debug, Deserialize, PartialEq, Clone, Default)]
+pub struct AgentLogsConfig {
+    /// Log files to tail. Nothing is forwarded when empty.
+    #[serde(default)]
+    pub files: Vec<TailedFileConfig>,
+    /// Interval between reads of the files.
+    #[serde(default)]
+    pub poll_interval: AgentLogsPollInterval,
+}
+
+/// A log file to tail.
+#[derive(Debug, Deserialize, PartialEq, Clone)]
+pub struct TailedFileConfig {
+    /// Path of the file.
+    pub path: PathBuf,
+    /// Agent writing the file, added as `agent.id` attribute to its records.
+    #[serde(default)]
+    pub agent_id: Option<String>,
+    /// Pattern matching the first line of each record. Every line is a record when not set.
+    #[serde(default)]
+    pub multiline_start: Option<MultilineStart>,
+}
+
+/// Interval between reads of the tailed files.
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_POLL_INTERVAL)]
+pub struct AgentLogsPollInterval(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// Regex matching the first line of a multi-line record.
+#[derive(Debug, Clone)]
+pub struct MultilineStart(Regex);
+
+impl PartialEq for MultilineStart {
+    fn eq(&self, other: &Self) -> bool {
+        self.0.as_str() == other.0.as_str()
+    }
+}
+
+impl<'de> Deserialize<'de> for MultilineStart {
+    fn deserialize<D>(deserializer: D) -> Result<Self, D::Error>
+    where
+        D: Deserializer<'de>,
+    {
+        let pattern = String::deserialize(deserializer)?;
+        Regex::new(&pattern)
+            .map(Self)
+            .map_err(serde::de::Error::custom)
+    }
+}
+
+/// Keeps the thread tailing the agent log files, it is stopped on drop.
+pub struct StartedAgentLogs {
+    thread_context: Option<StartedThreadContext>,
+}
+
+impl Drop for StartedAgentLogs {
+    fn drop(&mut self) {
+        let Some(thread_context) = self.thread_context.take() else {
+            return;
+        };
+        let _ = thread_context
+            .stop_blocking()
+            .inspect_err(|err| error!("Error stopping the agent logs tail: {err}"));
+    }
+}
+
+/// Starts forwarding the configured agent log files to the self-instrumentation endpoint.
+/// Returns `None` if there are no files to tail or the self-instrumentation is not configured.
+pub fn start_agent_logs(
+    config: &AgentLogsConfig,
+    otel_config: Option<&OtelConfig>,
+) -> Result<Option<StartedAgentLogs>, OtelBuildError> {
+    if config.files.is_empty() {
+        return Ok(None);
+    }
+    let Some(otel_config) = otel_config else {
+        warn!("Agent logs are not forwarded: self_instrumentation.opentelemetry is not configured");
+        return Ok(None);
+    };
+
+    let http_client = HttpClient::new(HttpConfig::new(
+        otel_config.client_timeout.clone().into(),
+        otel_config.client_timeout.clone().into(),
+        otel_config.proxy.clone(),
+    ))?;
+    let attributes: Vec<KeyValue> = otel_config
+        .custom_attributes
+        .iter()
+        .map(|(k, v)| KeyValue::new(k.clone(), v.clone()))
+        .collect();
+    let resource = Resource::builder()
+        .with_service_name(SERVICE_NAME)
+        .with_attributes(attributes)
+        .build();
+    let provider = OtelLayers::logs_provider(http_client, otel_config, resource)?;
+
+    let mut tails: Vec<FileTail> = config.files.iter().cloned().map(FileTail::new).collect();
+    let interval: Duration = config.poll_interval.into();
+
+    let callback = move |stop_consumer: EventConsumer<CancellationMessage>| {
+        let logger = provider.logger(LOGGER_NAME);
+        loop {
+            for tail in tails.iter_mut() {
+                for record in tail.poll() {
+                    emit(&logger, &tail.config, record);
+                }
+            }
+            if stop_consumer.is_cancelled_with_timeout(interval) {
+                break;
+            }
+        }
+        for tail in tails.iter_mut() {
+            if let Some(record) = tail.pending.take() {
+                emit(&logger, &tail.config, record);
+            }
+        }
+        // Sends the remaining records before the thread finishes.
+        let _ = provider
+            .shutdown()
+            .inspect_err(|err| debug!("Error shutting down the agent logs provider: {err}"));
+    };
+
+    info!("Forwarding the logs of {} agent files", config.files.len());
+    Ok(Some(StartedAgentLogs {
+        thread_context: Some(NotStartedThreadContext::new(THREAD_NAME, callback).start()),
+    }))
+}
+
+fn emit(logger: &SdkLogger, config: &TailedFileConfig, record: String) {
+    let mut log_record = logger.create_log_record();
+    if let Some((severity, severity_text)) = severity(&record) {
+        log_record.set_severity_number(severity);
+        log_record.set_severity_text(severity_text);
+    }
+    log_record.set_observed_timestamp(SystemTime::now());
+    log_record.set_body(AnyValue::from(record));
+    log_record.add_attribute(
+        FILE_PATH_ATTRIBUTE_KEY,
+        config.path.to_string_lossy().to_string(),
+    );
+    if let Some(agent_id) = &config.agent_id {
+        log_record.add_attribute(AGENT_ID_ATTRIBUTE_KEY, agent_id.clone());
+    }
+    logger.emit(log_record);
+}
+
+/// Returns the severity of the first level keyword found in the record.
+fn severity(record: &str) -> Option<(Severity, &'static str)> {
+    let level = SEVERITY_REGEX.find(record)?.as_str().to_ascii_lowercase();
+    let severity = match level.as_str() {
+        "trace" => (Severity::Trace, "TRACE"),
+        "debug" => (Severity::Debug, "DEBUG"),
+        "info" => (Severity::Info, "INFO"),
+        "warn" | "warning" => (Severity::Warn, "WARN"),
+        "error" | "err" => (Severity::Error, "ERROR"),
+        _ => (Severity::Fatal, "FATAL"),
+    };
+    Some(severity)
+}
+
+/// Reads the lines appended to a file and groups them in records.
+struct FileTail {
+    config: TailedFileConfig,
+    /// Position the next read starts at, `None` until the file is found for the first time.
+    offset: Option<u64>,
+    /// Bytes read after the last complete line.
+    partial_line: Vec<u8>,
+    /// Record still receiving continuation lines.
+    pending: Option<String>,
+}
+
+impl FileTail {
+    fn new(config: TailedFileConfig) -> Self {
+        let mut tail = Self {
+            config,
+            offset: None,
+            partial_line: Vec::new(),
+            pending: None,
+        };
+        // Only records written from now on are forwarded.
+        tail.offset = tail.file_len();
+        tail
+    }
+
+    fn file_len(&self) -> Option<u64> {
+        std::fs::metadata(&self.config.path).ok().map(|m| m.len())
+    }
+
+    /// Returns the records completed since the last poll. A multi-line record is completed by the
+    /// start of the next one, or by a poll without new lines.
+    fn poll(&mut self) -> Vec<String> {
+        let mut records = Vec::new();
+        let read = self.read().unwrap_or_else(|err| {
+            debug!(path = %self.config.path.display(), "Could not read agent log file: {err}");
+            Vec::new()
+        });
+
+        let mut new_lines = false;
+        self.partial_line.extend(read);
+        while let Some(pos) = self.partial_line.iter().position(|b| *b == b'\n') {
+            let line: Vec<u8> = self.partial_line.drain(..=pos).collect();
+            let line = String::from_utf8_lossy(&line)
+                .trim_end_matches(['\n', '\r'])
+                .to_string();
+            self.push_line(line, &mut records);
+            new_lines = true;
+        }
+        if !new_lines {
+            records.extend(self.pending.take());
+        }
+        records
+    }
+
+    fn push_line(&mut self, line: String, records: &mut Vec<String>) {
+        let Some(MultilineStart(start)) = &self.config.multiline_start else {
+            records.push(line);
+            return;
+        };
+        match &mut self.pending {
+            Some(pending) if !start.is_match(&line) => {
+                pending.push('\n');
+                pending.push_str(&line);
+            }
+            _ => records.extend(self.pending.replace(line)),
+        }
+    }
+
+    /// Reads the data appended since the last read, starting over if the file shrank.
+    fn read(&mut self) -> std::io::Result<Vec<u8>> {
+        let mut file = File::open(&self.config.path)?;
+        let len = file.metadata()?.len();
+        let offset = match self.offset {
+            Some(offset) if offset <= len => offset,
+            // The file was truncated or rotated, or it didn't exist on start.
+            _ => {
+                self.partial_line.clear();
+                0
+            }
+        };
+        file.seek(SeekFrom::Start(offset))?;
+        let mut buf = Vec::new();
+        file.take(MAX_READ_BYTES).read_to_end(&mut buf)?;
+        self.offset = Some(offset + buf.len() as u64);
+        Ok(buf)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::Write;
+    use tempfile::NamedTempFile;
+
+    fn tail(file: &NamedTempFile, multiline_start: Option<&str>) -> FileTail {
+        FileTail::new(TailedFileConfig {
+            path: file.path().to_path_buf(),
+            agent_id: None,
+            multiline_start: multiline_start.map(|p| MultilineStart(Regex::new(p).unwrap())),
+        })
+    }
+
+    #[test]
+    fn test_config() {
+        let config: AgentLogsConfig = serde_saphyr::from_str(
+            r#"
+files:
+  - path: /var/log/agent.log
+    agent_id: nr-infra
+    multiline_start: '^time='
+poll_interval: 1s
+"#,
+        )
+        .unwrap();
+        assert_eq!(
+            config,
+            AgentLogsConfig {
+                files: vec![TailedFileConfig {
+                    path: PathBuf::from("/var/log/agent.log"),
+                    agent_id: Some("nr-infra".to_string()),
+                    multiline_start: Some(MultilineStart(Regex::new("^time=").unwrap())),
+                }],
+                poll_interval: Duration::from_secs(1).into(),
+            }
+        );
+
+        let invalid = serde_saphyr::from_str::<AgentLogsConfig>(
+            "files: [{path: /var/log/agent.log, multiline_start: '('}]",
+        );
+        assert!(invalid.is_err());
+    }
+
+    #[test]
+    fn test_severity() {
+        assert_eq!(
+            severity(r#"time="2024" level=info msg="started""#),
+            Some((Severity::Info, "INFO"))
+        );
+        assert_eq!(
+            severity("2024-01-01 WARNING disk almost full"),
+            Some((Severity::Warn, "WARN"))
+        );
+        assert_eq!(
+            severity("[ERROR] connection refused"),
+            Some((Severity::Error, "ERROR"))
+        );
+        assert_eq!(severity("panic: boom"), Some((Severity::Fatal, "FATAL")));
+        // Only whole words are level keywords.
+        assert_eq!(severity("information about errors"), None);
+    }
+
+    #[test]
+    fn test_tail_single_line_records() {
+        let mut file = NamedTempFile::new().unwrap();
+        writeln!(file, "written before start").unwrap();
+        let mut tail = tail(&file, None);
+        assert!(tail.poll().is_empty());
+
+        write!(file, "first\nsecond\nthi").unwrap();
+        assert_eq!(tail.poll(), vec!["first", "second"]);
+        writeln!(file, "rd").unwrap();
+        assert_eq!(tail.poll(), vec!["third"]);
+        assert!(tail.poll().is_empty());
+    }
+
+    #[test]
+    fn test_tail_multiline_records() {
+        let mut file = NamedTempFile::new().unwrap();
+        let mut tail = tail(&file, Some("^time="));
+
+        writeln!(file, "time=1 level=error msg=failed").unwrap();
+        writeln!(file, "  at main.go:10").unwrap();
+        writeln!(file, "time=2 level=info msg=retrying").unwrap();
+        assert_eq!(
+            tail.poll(),
+            vec!["time=1 level=error msg=failed\n  at main.go:10"]
+        );
+        // A quiet poll completes the pending record.
+        assert_eq!(tail.poll(), vec!["time=2 level=info msg=retrying"]);
+    }
+
+    #[test]
+    fn test_tail_truncated_file() {
+        let mut file = NamedTempFile::new().unwrap();
+        writeln!(file, "some long line written before start").unwrap();
+        let mut tail = tail(&file, None);
+
+        file.as_file().set_len(0).unwrap();
+        file.rewind().unwrap();
+        writeln!(file, "after").unwrap();
+        assert_eq!(tail.poll(), vec!["after"]);
+    }
+
+    #[test]
+    fn test_tail_file_created_later() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("agent.log");
+        let mut tail = FileTail::new(TailedFileConfig {
+            path: path.clone(),
+            agent_id: None,
+            multiline_start: None,
+        });
+        assert!(tail.poll().is_empty());
+
+        std::fs::write(&path, "created\n").unwrap();
+        assert_eq!(tail.poll(), vec!["created"]);
+    }
+}
@@ -3,3 +3,4 @@
 pub mod config;
 pub mod file_logging;
 pub mod format;
+pub mod level_reload;
@@ -2,16 +2,17 @@
 
 use super::file_logging::FileLoggingConfig;
 use super::format::LoggingFormat;
+use super::level_reload::LogLevelReloader;
 use serde::{Deserialize, Serialize, Serializer};
 use std::fmt::Debug;
 use std::str::FromStr;
 use thiserror::Error;
-use tracing::Level;
 use tracing::level_filters::LevelFilter;
+use tracing::{Level, warn};
 use tracing_subscriber::filter::{Directive, FilterExt, FilterFn};
 use tracing_subscriber::fmt::format::FmtSpan;
 use tracing_subscriber::layer::Filter;
-use tracing_subscriber::{EnvFilter, Registry};
+use tracing_subscriber::{EnvFilter, Registry, reload};
 
 // The list of crates with enabled logging is generated at build time in `build.rs` and injected as an env variable.
 const LOGGING_ENABLED_CRATES: &str = env!("LOGGING_ENABLED_CRATES");
@@ -39,6 +40,10 @@ pub enum LoggingConfigError {
     /// File logging could not be configured.
     #[error("configuring file logging: {0}")]
     FileLoggingConfig(String),
+
+    /// The logging filter could not be replaced at runtime.
+    #[error("reloading logging filter: {0}")]
+    Reload(String),
 }
 
 /// Defines the logging configuration Agent control.
@@ -66,8 +71,27 @@ pub struct LoggingConfig {
 impl LoggingConfig {
     /// Returns the configured filter according to the corresponding fields. The filter will also allow
     /// any span whose level doesn't exceed `SPAN_ATTRIBUTES_MAX_LEVEL`.
-    pub fn filter(&self) -> Result<impl Filter<Registry> + use<>, LoggingConfigError> {
-        let configured_logs_filter = self.logging_filter()?;
+    /// The level of the returned filter can be changed at runtime through `level_reloader`.
+    pub fn filter(
+        &self,
+        level_reloader: &LogLevelReloader,
+    ) -> Result<impl Filter<Registry> + use<>, LoggingConfigError> {
+        let (configured_logs_filter, handle) = reload::Layer::new(self.logging_filter()?);
+
+        let config = self.clone();
+        level_reloader.register(Box::new(move |level| {
+            let filter = LoggingConfig {
+                level: LogLevel(level),
+                insecure_fine_grained_level: None,
+                ..config.clone()
+            }
+            .logging_filter()?;
+            match handle.reload(filter) {
+                Ok(()) => Ok(true),
+                Err(err) if err.is_dropped() => Ok(false),
+                Err(err) => Err(LoggingConfigError::Reload(err.to_string())),
+            }
+        }));
 
         let allow_spans_filter = FilterFn::new(|metadata| {
             metadata.is_span() && metadata.level() <= SPAN_ATTRIBUTES_MAX_LEVEL
@@ -78,6 +102,21 @@ impl LoggingConfig {
         Ok(filter)
     }
 
+    /// Applies the configured `level` to the running logging filters registered in
+    /// `level_reloader`. Filters configured through `insecure_fine_grained_level` cannot be changed
+    /// at runtime, so they are left untouched.
+    pub fn apply_level(&self, level_reloader: &LogLevelReloader) -> Result<(), LoggingConfigError> {
+        if self
+            .insecure_fine_grained_level
+            .as_ref()
+            .is_some_and(|s| !s.is_empty())
+        {
+            warn!("Changes of 'insecure_fine_grained_level' require a restart to be applied");
+            return Ok(());
+        }
+        level_reloader.set_log_level(&self.level.as_level().to_string())
+    }
+
     /// Returns the span events to format: spans are shown on close when `show_spans` is set, otherwise none.
     pub fn fmt_span_events(&self) -> FmtSpan {
         if self.show_spans {
@@ -276,7 +315,7 @@ mod tests {
 
         impl TestCase {
             fn run(self) {
-                let filter = self.config.filter();
+                let filter = self.config.filter(&LogLevelReloader::default());
                 let err = filter
                     .err()
                     .unwrap_or_else(|| panic!("expected err got Ok - {}", self.name));
@@ -335,9 +374,13 @@ mod tests {
         };
 
         // Single layer to file for testing purposes
-        let (layer, file_guard) = tracing_layers::file::file(&config, dir.path().to_path_buf())
-            .unwrap()
-            .unwrap();
+        let (layer, file_guard) = tracing_layers::file::file(
+            &config,
+            dir.path().to_path_buf(),
+            &LogLevelReloader::default(),
+        )
+        .unwrap()
+        .unwrap();
 
         let subscriber = tracing_subscriber::Registry::default().with(layer);
 
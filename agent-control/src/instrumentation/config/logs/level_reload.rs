@@ -0,0 +1,95 @@
+//! Runtime changes of the Agent Control log level.
+//!
+//! Every logging filter built from a [LoggingConfig](super::config::LoggingConfig) registers a
+//! reloader in the [LogLevelReloader] it is built with, so the level of all the layers (stderr,
+//! file) can be changed at once without restarting Agent Control.
+
+use super::config::LoggingConfigError;
+use std::str::FromStr;
+use std::sync::{Arc, Mutex};
+use tracing::{Level, info};
+
+/// Rebuilds a registered filter with the provided level. Returns `false` once the subscriber
+/// owning the filter is gone, so the reloader can be discarded.
+pub(super) type Reloader = Box<dyn Fn(Level) -> Result<bool, LoggingConfigError> + Send + Sync>;
+
+/// Changes the level of the logging filters registered in it. Clones share the registered filters,
+/// so it can be handed to every component changing the level.
+#[derive(Clone, Default)]
+pub struct LogLevelReloader {
+    reloaders: Arc<Mutex<Vec<Reloader>>>,
+}
+
+impl LogLevelReloader {
+    /// Registers the reloader of a logging filter.
+    pub(super) fn register(&self, reloader: Reloader) {
+        self.reloaders
+            .lock()
+            .expect("log level reloaders lock poisoned")
+            .push(reloader);
+    }
+
+    /// Sets the log level of every registered logging filter. The level applies to the Agent
+    /// Control crates only, any `insecure_fine_grained_level` directive is discarded. Fails if any
+    /// of the filters couldn't be changed.
+    pub fn set_log_level(&self, level: &str) -> Result<(), LoggingConfigError> {
+        let level = Level::from_str(level).map_err(|err| LoggingConfigError::InvalidDirective {
+            directive: level.to_string(),
+            field_name: "level".to_string(),
+            err: err.to_string(),
+        })?;
+
+        let mut reloaders = self
+            .reloaders
+            .lock()
+            .expect("log level reloaders lock poisoned");
+        let mut result = Ok(());
+        reloaders.retain(|reloader| match reloader(level) {
+            Ok(alive) => alive,
+            Err(err) => {
+                result = Err(err);
+                true
+            }
+        });
+        if result.is_ok() {
+            info!(%level, "Log level changed");
+        }
+        result
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use assert_matches::assert_matches;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+
+    #[test]
+    fn test_set_invalid_log_level() {
+        assert_matches!(
+            LogLevelReloader::default().set_log_level("verbose"),
+            Err(LoggingConfigError::InvalidDirective { field_name, .. }) => {
+                assert_eq!(field_name, "level");
+            }
+        );
+    }
+
+    #[test]
+    fn test_set_log_level_discards_dropped_filters() {
+        let reloader = LogLevelReloader::default();
+        let reloads = Arc::new(AtomicUsize::new(0));
+        let counter = reloads.clone();
+        reloader.register(Box::new(move |level| {
+            assert_eq!(level, Level::DEBUG);
+            counter.fetch_add(1, Ordering::Relaxed);
+            Ok(true)
+        }));
+        reloader.register(Box::new(|_| Ok(false)));
+
+        reloader.clone().set_log_level("debug").unwrap();
+        reloader.set_log_level("debug").unwrap();
+
+        assert_eq!(reloads.load(Ordering::Relaxed), 2);
+        assert_eq!(reloader.reloaders.lock().unwrap().len(), 1);
+    }
+}
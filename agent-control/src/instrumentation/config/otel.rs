@@ -1,4 +1,5 @@
-//! Configuration to report Agent Control self-instrumentation (metrics and logs) through OpenTelemetry.
+//! Configuration to report Agent Control self-instrumentation (metrics, logs and traces) through
+//! OpenTelemetry.
 
 use duration_str::deserialize_duration;
 use opentelemetry_sdk::logs;
@@ -24,6 +25,8 @@ const DEFAULT_FILTER: &str = "newrelic_agent_control=debug,opamp_client=debug,of
 const METRICS_SUFFIX: &str = "/v1/metrics";
 /// Logs suffix for the OpenTelemetry endpoint
 const LOGS_SUFFIX: &str = "/v1/logs";
+/// Traces suffix for the OpenTelemetry endpoint
+const TRACES_SUFFIX: &str = "/v1/traces";
 
 /// Represents the OpenTelemetry configuration
 #[derive(Debug, Deserialize, PartialEq, Clone)]
@@ -34,12 +37,15 @@ pub struct OtelConfig {
     /// Logs configuration
     #[serde(default)]
     pub(crate) logs: LogsConfig,
-    /// Filter metrics and logs. By default [DEFAULT_FILTER] is used. This is marked as
+    /// Traces configuration
+    #[serde(default)]
+    pub(crate) traces: TracesConfig,
+    /// Filter metrics, logs and traces. By default [DEFAULT_FILTER] is used. This is marked as
     /// insecure because sensitive data could be sent if some crates are not filtered like the http client.
     #[serde(default = "default_insecure_level")]
     pub(crate) insecure_level: String,
     /// OpenTelemetry HTTP base endpoint to report instrumentation, to send each instrumentation
-    /// type, the corresponding suffix will be added [METRICS_SUFFIX], [LOGS_SUFFIX].
+    /// type, the corresponding suffix will be added [METRICS_SUFFIX], [LOGS_SUFFIX], [TRACES_SUFFIX].
     pub(crate) endpoint: Url,
     /// Headers to include in every request to the OpenTelemetry endpoint
     #[serde(default)]
@@ -84,6 +90,11 @@ impl OtelConfig {
         self.target_endpoint(LOGS_SUFFIX)
     }
 
+    /// Returns the otel endpoint to report traces to.
+    pub(crate) fn traces_endpoint(&self) -> String {
+        self.target_endpoint(TRACES_SUFFIX)
+    }
+
     /// Helper to get the endpoint for each data type
     ///
     /// # Panics
@@ -120,6 +131,15 @@ pub(crate) struct LogsConfig {
     pub(crate) batch_config: BatchConfig,
 }
 
+/// Defines the configuration settings to report traces to OpenTelemetry
+#[derive(Debug, Deserialize, Serialize, Default, PartialEq, Clone)]
+pub(crate) struct TracesConfig {
+    /// Indicates if traces are enabled or not. The spans of the key flows, like processing remote
+    /// configurations, merging them, restarting executables or installing packages, are reported.
+    #[serde(default)]
+    pub(crate) enabled: bool,
+}
+
 /// Type to represent a client timeout. It adds a default implementation to [std::time::Duration].
 #[derive(Debug, Deserialize, Serialize, Clone, PartialEq, WrapperWithDefault)]
 #[wrapper_default_value(DEFAULT_CLIENT_TIMEOUT)]
@@ -185,6 +205,8 @@ logs:
   batch_config:
     scheduled_delay: 30s
     max_size: 512
+traces:
+  enabled: true
 "#;
 
     impl Default for OtelConfig {
@@ -197,6 +219,7 @@ logs:
             Self {
                 metrics: Default::default(),
                 logs: Default::default(),
+                traces: Default::default(),
                 insecure_level: default_insecure_level(),
                 endpoint: endpoint.parse().unwrap(),
                 headers: Default::default(),
@@ -217,6 +240,10 @@ logs:
             config.logs_endpoint(),
             "https://some.endpoint:4318/v1/logs".to_string()
         );
+        assert_eq!(
+            config.traces_endpoint(),
+            "https://some.endpoint:4318/v1/traces".to_string()
+        );
     }
 
     #[test]
@@ -238,6 +265,8 @@ logs:
 
         assert!(!config.logs.enabled);
 
+        assert!(!config.traces.enabled);
+
         assert_eq!(
             Duration::from(config.client_timeout),
             DEFAULT_CLIENT_TIMEOUT
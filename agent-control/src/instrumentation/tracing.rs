@@ -3,7 +3,10 @@
 use super::{
     config::{
         InstrumentationConfig,
-        logs::config::{LoggingConfig, LoggingConfigError},
+        logs::{
+            config::{LoggingConfig, LoggingConfigError},
+            level_reload::LogLevelReloader,
+        },
     },
     tracing_layers::{
         file::file,
@@ -46,6 +49,7 @@ pub struct TracingConfig {
     logging_path: PathBuf,
     logging_config: LoggingConfig,
     instrumentation_config: InstrumentationConfig,
+    level_reloader: LogLevelReloader,
 }
 
 impl TracingConfig {
@@ -55,6 +59,7 @@ impl TracingConfig {
             logging_path,
             logging_config: Default::default(),
             instrumentation_config: Default::default(),
+            level_reloader: Default::default(),
         }
     }
 
@@ -76,13 +81,21 @@ impl TracingConfig {
             ..self
         }
     }
+
+    /// Sets the reloader the logging filters are registered in, to change their level at runtime.
+    pub fn with_level_reloader(self, level_reloader: LogLevelReloader) -> Self {
+        Self {
+            level_reloader,
+            ..self
+        }
+    }
 }
 
 /// Initializes tracing with stderr output only, without file or OpenTelemetry layers.
 ///
 /// Intended for short-lived commands (e.g. verify) that must not write to the running AC log files.
 pub fn try_init_stderr_tracing(config: &LoggingConfig) -> Result<(), TracingError> {
-    let layers = vec![stderr(config)?];
+    let layers = vec![stderr(config, &LogLevelReloader::default())?];
     try_init_tracing_subscriber(layers)
 }
 
@@ -105,10 +118,14 @@ pub fn try_init_stderr_tracing(config: &LoggingConfig) -> Result<(), TracingErro
 /// ```
 pub fn try_init_tracing(config: TracingConfig) -> Result<Vec<TracingGuardBox>, TracingError> {
     // Currently stderr output is always on, we could consider allowing to turn it off.
-    let mut layers = Vec::from([stderr(&config.logging_config)?]);
+    let mut layers = Vec::from([stderr(&config.logging_config, &config.level_reloader)?]);
     let mut guards = Vec::<TracingGuardBox>::new();
 
-    if let Some((file_layer, file_guard)) = file(&config.logging_config, config.logging_path)? {
+    if let Some((file_layer, file_guard)) = file(
+        &config.logging_config,
+        config.logging_path,
+        &config.level_reloader,
+    )? {
         layers.push(file_layer);
         guards.push(Box::new(file_guard));
     }
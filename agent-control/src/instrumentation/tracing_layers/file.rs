@@ -2,6 +2,7 @@
 
 use crate::instrumentation::config::logs::config::{LoggingConfig, LoggingConfigError};
 use crate::instrumentation::config::logs::format::Formatter;
+use crate::instrumentation::config::logs::level_reload::LogLevelReloader;
 use crate::instrumentation::tracing::{LayerBox, TracingGuard};
 use std::path::PathBuf;
 use tracing_appender::non_blocking::WorkerGuard;
@@ -17,10 +18,12 @@ pub type FileTracingExporter = WorkerGuard;
 impl TracingGuard for FileTracingExporter {}
 
 /// Returns an Optional [LayerBox] corresponding to a file output and the corresponding [WorkerGuard].
-/// The result will be None if the file logger is not enabled.
+/// The result will be None if the file logger is not enabled. The level of the layer can be changed
+/// through `level_reloader`.
 pub fn file(
     config: &LoggingConfig,
     default_dir: PathBuf,
+    level_reloader: &LogLevelReloader,
 ) -> Result<Option<(LayerBox, FileTracingExporter)>, LoggingConfigError> {
     let target = config.format.target;
     let timestamp_fmt = config.format.timestamp.0.clone();
@@ -39,12 +42,12 @@ pub fn file(
                 Formatter::Pretty => layer
                     .with_ansi(false) // Disable colors for file
                     .fmt_fields(PrettyFields::new())
-                    .with_filter(config.filter()?)
+                    .with_filter(config.filter(level_reloader)?)
                     .boxed(),
                 Formatter::Json => layer
                     .json()
                     .flatten_event(true)
-                    .with_filter(config.filter()?)
+                    .with_filter(config.filter(level_reloader)?)
                     .boxed(),
             };
             Ok((layer, guard))
@@ -1,16 +1,18 @@
-//! Builds the [`tracing_subscriber`] layers that report logs and metrics through OpenTelemetry.
+//! Builds the [`tracing_subscriber`] layers that report logs, metrics and traces through OpenTelemetry.
 
 use crate::http::client::{HttpBuildError, HttpClient};
 use crate::http::config::HttpConfig;
 use crate::instrumentation::config::otel::OtelConfig;
 use crate::instrumentation::tracing::{LayerBox, TracingGuard};
 use opentelemetry::KeyValue;
+use opentelemetry::trace::TracerProvider;
 use opentelemetry_appender_tracing::layer::OpenTelemetryTracingBridge;
 use opentelemetry_http::HttpClient as OtelHttpClient;
 use opentelemetry_otlp::{ExporterBuildError, WithExportConfig, WithHttpConfig};
 use opentelemetry_sdk::Resource;
 use opentelemetry_sdk::logs::{BatchLogProcessor, SdkLoggerProvider};
 use opentelemetry_sdk::metrics::{PeriodicReader, SdkMeterProvider};
+use opentelemetry_sdk::trace::{BatchSpanProcessor, SdkTracerProvider};
 use thiserror::Error;
 use tracing_opentelemetry::MetricsLayer;
 use tracing_subscriber::{EnvFilter, Layer};
@@ -48,6 +50,7 @@ pub struct OtelLayers {
     logs_layer_builder: Option<(SdkLoggerProvider, EnvFilter)>,
     // Metrics are reported regardless of the configured level, there are no filtering options supported for now.
     metrics_layer_builder: Option<SdkMeterProvider>,
+    traces_layer_builder: Option<(SdkTracerProvider, EnvFilter)>,
 }
 
 impl OtelLayers {
@@ -73,7 +76,7 @@ impl OtelLayers {
     where
         C: OtelHttpClient + Send + Sync + Clone + 'static,
     {
-        if !(config.metrics.enabled || config.logs.enabled) {
+        if !(config.metrics.enabled || config.logs.enabled || config.traces.enabled) {
             return Ok(Self::default());
         }
 
@@ -101,7 +104,16 @@ impl OtelLayers {
 
         let logs_layer_builder = if config.logs.enabled {
             Some((
-                Self::logs_provider(client, config, resource)?,
+                Self::logs_provider(client.clone(), config, resource.clone())?,
+                Self::filter(&config.insecure_level)?,
+            ))
+        } else {
+            None
+        };
+
+        let traces_layer_builder = if config.traces.enabled {
+            Some((
+                Self::traces_provider(client, config, resource)?,
                 Self::filter(&config.insecure_level)?,
             ))
         } else {
@@ -111,6 +123,7 @@ impl OtelLayers {
         Ok(Self {
             logs_layer_builder,
             metrics_layer_builder,
+            traces_layer_builder,
         })
     }
 
@@ -148,7 +161,8 @@ impl OtelLayers {
             .build())
     }
 
-    fn logs_provider<C>(
+    /// Builds a logs provider exporting to the configured logs endpoint.
+    pub(crate) fn logs_provider<C>(
         client: C,
         config: &OtelConfig,
         resource: Resource,
@@ -173,6 +187,28 @@ impl OtelLayers {
             .build())
     }
 
+    /// Builds a traces provider exporting to the configured traces endpoint.
+    pub(crate) fn traces_provider<C>(
+        client: C,
+        config: &OtelConfig,
+        resource: Resource,
+    ) -> Result<SdkTracerProvider, OtelBuildError>
+    where
+        C: OtelHttpClient + Send + Sync + 'static,
+    {
+        let exporter = opentelemetry_otlp::SpanExporter::builder()
+            .with_http()
+            .with_http_client(client)
+            .with_endpoint(config.traces_endpoint())
+            .with_headers(config.headers.clone())
+            .build()?;
+
+        Ok(SdkTracerProvider::builder()
+            .with_span_processor(BatchSpanProcessor::builder(exporter).build())
+            .with_resource(resource)
+            .build())
+    }
+
     /// Consumes the providers and returns the combined [`LayerBox`] together with the [`OtelGuard`]
     /// that must be kept alive while telemetry is emitted.
     pub fn layers(self) -> (LayerBox, OtelGuard) {
@@ -191,6 +227,13 @@ impl OtelLayers {
             layers.push(Box::new(layer.with_filter(logs_filter)));
         }
 
+        if let Some((traces_provider, traces_filter)) = self.traces_layer_builder {
+            guard._traces_provider = Some(traces_provider.clone());
+            let layer =
+                tracing_opentelemetry::layer().with_tracer(traces_provider.tracer(SERVICE_NAME));
+            layers.push(Box::new(layer.with_filter(traces_filter)));
+        }
+
         (layers.boxed(), guard)
     }
 }
@@ -201,6 +244,7 @@ impl OtelLayers {
 pub struct OtelGuard {
     _logs_provider: Option<SdkLoggerProvider>,
     _metrics_provider: Option<SdkMeterProvider>,
+    _traces_provider: Option<SdkTracerProvider>,
 }
 
 impl TracingGuard for OtelGuard {}
@@ -211,12 +255,14 @@ mod tests {
 
     use http::Response;
     use opentelemetry_sdk::Resource;
-    use tracing::{debug, info, trace};
+    use tracing::{debug, info, info_span, trace};
     use tracing_subscriber::EnvFilter;
     use tracing_subscriber::layer::SubscriberExt;
 
     use crate::http::client::tests::MockOtelHttpClient;
-    use crate::instrumentation::config::otel::{LogsConfig, MetricsConfig, OtelConfig};
+    use crate::instrumentation::config::otel::{
+        LogsConfig, MetricsConfig, OtelConfig, TracesConfig,
+    };
     use crate::instrumentation::tracing_layers::otel::OtelLayers;
 
     #[test]
@@ -313,4 +359,47 @@ mod tests {
             std::thread::sleep(Duration::from_secs(2));
         });
     }
+
+    #[test]
+    fn test_traces_layer() {
+        let mut mock_http_client = MockOtelHttpClient::new();
+        // Asserts the spans allowed by the filter are sent
+        mock_http_client
+            .expect_send_bytes()
+            .once()
+            .withf(|req| {
+                let body = String::from_utf8_lossy(req.body().as_ref());
+                req.uri().path().eq("/v1/traces")
+                    && body.contains("apply_remote_config")
+                    && !body.contains("filtered_out")
+            })
+            .returning(|_| {
+                Ok(Response::builder()
+                    .status(200)
+                    .body(opentelemetry_http::Bytes::default())
+                    .unwrap())
+            });
+
+        let traces_provider = OtelLayers::traces_provider(
+            mock_http_client,
+            &OtelConfig {
+                traces: TracesConfig { enabled: true },
+                ..Default::default()
+            },
+            Resource::builder().build(),
+        )
+        .unwrap();
+
+        let otel_layers = OtelLayers {
+            traces_layer_builder: Some((traces_provider, EnvFilter::builder().parse_lossy("info"))),
+            ..Default::default()
+        };
+        let (layers, _guard) = otel_layers.layers();
+        let subscriber = tracing_subscriber::Registry::default().with(layers);
+        tracing::subscriber::with_default(subscriber, || {
+            info_span!("apply_remote_config").in_scope(|| {
+                tracing::debug_span!("filtered_out").in_scope(|| {});
+            });
+        });
+    }
 }
@@ -2,13 +2,18 @@
 
 use crate::instrumentation::config::logs::config::{LoggingConfig, LoggingConfigError};
 use crate::instrumentation::config::logs::format::Formatter;
+use crate::instrumentation::config::logs::level_reload::LogLevelReloader;
 use crate::instrumentation::tracing::LayerBox;
 use tracing_subscriber::Layer;
 use tracing_subscriber::fmt::format::PrettyFields;
 use tracing_subscriber::fmt::time::ChronoLocal;
 
-/// Returns the [LayerBox] corresponding to the standard error.
-pub fn stderr(config: &LoggingConfig) -> Result<LayerBox, LoggingConfigError> {
+/// Returns the [LayerBox] corresponding to the standard error, its level can be changed through
+/// `level_reloader`.
+pub fn stderr(
+    config: &LoggingConfig,
+    level_reloader: &LogLevelReloader,
+) -> Result<LayerBox, LoggingConfigError> {
     let target = config.format.target;
     let timestamp_fmt = config.format.timestamp.0.clone();
 
@@ -22,12 +27,12 @@ pub fn stderr(config: &LoggingConfig) -> Result<LayerBox, LoggingConfigError> {
         Formatter::Pretty => layer
             .with_ansi(config.format.ansi_colors)
             .fmt_fields(PrettyFields::new())
-            .with_filter(config.filter()?)
+            .with_filter(config.filter(level_reloader)?)
             .boxed(),
         Formatter::Json => layer
             .json()
             .flatten_event(true)
-            .with_filter(config.filter()?)
+            .with_filter(config.filter(level_reloader)?)
             .boxed(),
     };
     Ok(layer)
@@ -5,6 +5,7 @@
 
 pub mod agent_control;
 pub mod agent_type;
+pub mod audit;
 pub mod checkers;
 pub mod cli;
 pub mod command;
@@ -14,6 +15,7 @@ pub mod event;
 pub mod http;
 pub mod instrumentation;
 pub mod k8s;
+pub mod lifecycle_hooks;
 pub mod oci;
 pub mod on_host;
 pub mod opamp;
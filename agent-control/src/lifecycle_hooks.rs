@@ -0,0 +1,531 @@
+//! Notification hooks run on lifecycle events (applied and failed configurations, rollbacks,
+//! executables in a crash loop, stalled agents and completed upgrades), so customers can integrate the actions of
+//! Agent Control with their own alerting and CMDBs.
+//!
+//! Each hook either executes a command, which receives the event as JSON in its standard input and
+//! its name in the `NR_AC_HOOK_EVENT` environment variable, or POSTs the event as JSON to a
+//! webhook:
+//!
+//! ```yaml
+//! lifecycle_hooks:
+//!   - events: [config-applied, rollback]
+//!     exec:
+//!       path: /usr/local/bin/update-cmdb
+//!       args: ["--source", "agent-control"]
+//!   - events: [crash-loop]
+//!     webhook:
+//!       url: https://alerts.example.com/agent-control
+//!       headers:
+//!         Authorization: Bearer some-token
+//!     timeout: 5s
+//! ```
+//!
+//! Hooks run in the background and their failures are only logged, so they never delay or
+//! interrupt the action that triggered them. The configured hooks are held by [LifecycleHooks],
+//! which is handed to the components notifying the events.
+
+use crate::audit::{AuditEvent, AuditTrail};
+use crate::http::client::HttpClient;
+use crate::http::config::{HttpConfig, ProxyConfig};
+use duration_str::deserialize_duration;
+use http::header::CONTENT_TYPE;
+use http::{Method, Request};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::io::{self, Write};
+use std::process::{Command, Stdio};
+use std::sync::Arc;
+use std::thread;
+use std::time::{Duration, Instant};
+use tracing::{debug, warn};
+use url::Url;
+
+/// Environment variable holding the name of the event in the executed hooks.
+pub const HOOK_EVENT_ENV_VAR: &str = "NR_AC_HOOK_EVENT";
+
+const DEFAULT_HOOK_TIMEOUT: Duration = Duration::from_secs(30);
+const POLL_INTERVAL: Duration = Duration::from_millis(100);
+
+/// Kind of lifecycle event a hook runs on.
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq)]
+#[serde(rename_all = "kebab-case")]
+pub enum LifecycleEventKind {
+    /// A remote configuration was applied.
+    ConfigApplied,
+    /// A remote configuration failed to apply.
+    ConfigFailed,
+    /// A configuration or a self-update was rolled back.
+    Rollback,
+    /// An executable kept failing and won't be restarted anymore.
+    CrashLoop,
+    /// The runtime of an agent stopped making progress.
+    Stalled,
+    /// A self-update completed.
+    UpgradeComplete,
+}
+
+/// Lifecycle event notified to the hooks.
+#[derive(Debug, Serialize, Clone, PartialEq)]
+#[serde(tag = "event", rename_all = "kebab-case")]
+pub enum LifecycleEvent {
+    /// A remote configuration was applied.
+    ConfigApplied {
+        /// Agent the configuration was applied to.
+        agent_id: String,
+        /// Hash of the applied configuration.
+        hash: String,
+    },
+    /// A remote configuration failed to apply.
+    ConfigFailed {
+        /// Agent the configuration was meant for.
+        agent_id: String,
+        /// Hash of the failed configuration.
+        hash: String,
+        /// Why it failed.
+        error_message: String,
+    },
+    /// A configuration or a self-update was rolled back.
+    Rollback {
+        /// Agent whose configuration was rolled back, Agent Control for self-updates.
+        agent_id: String,
+        /// Why it was rolled back.
+        reason: String,
+    },
+    /// An executable kept failing and won't be restarted anymore.
+    CrashLoop {
+        /// Agent the executable belongs to.
+        agent_id: String,
+        /// Id of the executable.
+        executable: String,
+        /// Why it won't be restarted.
+        reason: String,
+    },
+    /// The runtime of an agent stopped making progress.
+    Stalled {
+        /// Stalled agent.
+        agent_id: String,
+        /// Seconds since the runtime last made progress.
+        stalled_for_secs: u64,
+        /// Whether the agent was restarted.
+        restarted: bool,
+    },
+    /// A self-update completed.
+    UpgradeComplete {
+        /// Version running before the self-update.
+        from_version: String,
+        /// Version running after the self-update.
+        to_version: String,
+    },
+}
+
+impl LifecycleEvent {
+    /// Kind of the event.
+    pub fn kind(&self) -> LifecycleEventKind {
+        match self {
+            Self::ConfigApplied { .. } => LifecycleEventKind::ConfigApplied,
+            Self::ConfigFailed { .. } => LifecycleEventKind::ConfigFailed,
+            Self::Rollback { .. } => LifecycleEventKind::Rollback,
+            Self::CrashLoop { .. } => LifecycleEventKind::CrashLoop,
+            Self::Stalled { .. } => LifecycleEventKind::Stalled,
+            Self::UpgradeComplete { .. } => LifecycleEventKind::UpgradeComplete,
+        }
+    }
+
+    /// Name of the event, as set in `events` and in the notified JSON.
+    pub fn name(&self) -> &'static str {
+        match self.kind() {
+            LifecycleEventKind::ConfigApplied => "config-applied",
+            LifecycleEventKind::ConfigFailed => "config-failed",
+            LifecycleEventKind::Rollback => "rollback",
+            LifecycleEventKind::CrashLoop => "crash-loop",
+            LifecycleEventKind::Stalled => "stalled",
+            LifecycleEventKind::UpgradeComplete => "upgrade-complete",
+        }
+    }
+}
+
+/// Configuration of a lifecycle hook.
+#[derive(Debug, Deserialize, Clone, PartialEq)]
+pub struct LifecycleHookConfig {
+    /// Events the hook runs on. Runs on every event when empty.
+    #[serde(default)]
+    pub events: Vec<LifecycleEventKind>,
+    /// What the hook does.
+    #[serde(flatten)]
+    pub action: HookAction,
+    /// Maximum duration of the hook. The command is killed, or the request abandoned, after it.
+    #[serde(
+        default = "default_hook_timeout",
+        deserialize_with = "deserialize_duration"
+    )]
+    pub timeout: Duration,
+}
+
+fn default_hook_timeout() -> Duration {
+    DEFAULT_HOOK_TIMEOUT
+}
+
+/// Action of a lifecycle hook.
+#[derive(Debug, Deserialize, Clone, PartialEq)]
+#[serde(rename_all = "lowercase")]
+pub enum HookAction {
+    /// Executes a command.
+    Exec {
+        /// Path of the command.
+        path: String,
+        /// Arguments of the command.
+        #[serde(default)]
+        args: Vec<String>,
+    },
+    /// POSTs the event to a webhook.
+    Webhook {
+        /// Url of the webhook.
+        url: Url,
+        /// Headers added to the request.
+        #[serde(default)]
+        headers: HashMap<String, String>,
+    },
+}
+
+impl LifecycleHookConfig {
+    fn runs_on(&self, event: &LifecycleEvent) -> bool {
+        self.events.is_empty() || self.events.contains(&event.kind())
+    }
+}
+
+/// Configured lifecycle hooks, cheap to clone and shared by every component notifying events. The
+/// default has no hooks, so notifying does nothing.
+#[derive(Debug, Default, Clone)]
+pub struct LifecycleHooks {
+    hooks: Arc<[LifecycleHookConfig]>,
+    proxy: ProxyConfig,
+    audit_trail: AuditTrail,
+}
+
+impl LifecycleHooks {
+    /// Creates the lifecycle hooks, webhooks are posted through `proxy`.
+    pub fn new(hooks: Vec<LifecycleHookConfig>, proxy: ProxyConfig) -> Self {
+        Self {
+            hooks: hooks.into(),
+            proxy,
+            audit_trail: AuditTrail::default(),
+        }
+    }
+
+    /// Records the executed commands in `audit_trail`.
+    pub fn with_audit_trail(self, audit_trail: AuditTrail) -> Self {
+        Self {
+            audit_trail,
+            ..self
+        }
+    }
+
+    /// Runs, in the background, the hooks configured for the event.
+    pub fn notify(&self, event: LifecycleEvent) {
+        let event = Arc::new(event);
+        for hook in self.hooks.iter().filter(|hook| hook.runs_on(&event)) {
+            spawn_hook(hook.clone(), event.clone(), self.clone());
+        }
+    }
+}
+
+fn spawn_hook(hook: LifecycleHookConfig, event: Arc<LifecycleEvent>, hooks: LifecycleHooks) {
+    let spawned = thread::Builder::new()
+        .name("lifecycle hook".to_string())
+        .spawn(move || {
+            let _ = run(&hook, &event, hooks.proxy, &hooks.audit_trail)
+                .inspect_err(|err| warn!(event = event.name(), "Lifecycle hook failed: {err}"));
+        });
+    if let Err(err) = spawned {
+        warn!("Could not start a lifecycle hook: {err}");
+    }
+}
+
+/// Runs the hook for the event, waiting for it to finish.
+fn run(
+    hook: &LifecycleHookConfig,
+    event: &LifecycleEvent,
+    proxy: ProxyConfig,
+    audit_trail: &AuditTrail,
+) -> Result<(), String> {
+    let payload = serde_json::to_vec(event).map_err(|err| format!("encoding the event: {err}"))?;
+    match &hook.action {
+        HookAction::Exec { path, args } => exec(
+            path,
+            args,
+            event.name(),
+            &payload,
+            hook.timeout,
+            audit_trail,
+        )
+        .map_err(|err| format!("executing '{path}': {err}")),
+        HookAction::Webhook { url, headers } => post(url, headers, payload, hook.timeout, proxy)
+            .map_err(|err| format!("posting to '{url}': {err}")),
+    }
+}
+
+fn exec(
+    path: &str,
+    args: &[String],
+    event_name: &str,
+    payload: &[u8],
+    timeout: Duration,
+    audit_trail: &AuditTrail,
+) -> io::Result<()> {
+    let mut child = Command::new(path)
+        .args(args)
+        .env(HOOK_EVENT_ENV_VAR, event_name)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .spawn()?;
+    audit_trail.record(AuditEvent::CommandExecuted {
+        path: path.to_string(),
+        args: args.to_vec(),
+    });
+    if let Some(mut stdin) = child.stdin.take() {
+        // The command may not read the event.
+        let _ = stdin.write_all(payload);
+    }
+
+    let deadline = Instant::now() + timeout;
+    loop {
+        if let Some(status) = child.try_wait()? {
+            if !status.success() {
+                return Err(io::Error::other(format!(
+                    "exited with code {:?}",
+                    status.code()
+                )));
+            }
+            debug!(path, event = event_name, "Lifecycle hook executed");
+            return Ok(());
+        }
+        if Instant::now() >= deadline {
+            let _ = child.kill();
+            let _ = child.wait();
+            return Err(io::Error::other(format!("timed out after {timeout:?}")));
+        }
+        thread::sleep(POLL_INTERVAL);
+    }
+}
+
+fn post(
+    url: &Url,
+    headers: &HashMap<String, String>,
+    payload: Vec<u8>,
+    timeout: Duration,
+    proxy: ProxyConfig,
+) -> Result<(), String> {
+    let client =
+        HttpClient::new(HttpConfig::new(timeout, timeout, proxy)).map_err(|err| err.to_string())?;
+    let mut request = Request::builder()
+        .method(Method::POST)
+        .uri(url.as_str())
+        .header(CONTENT_TYPE, "application/json");
+    for (name, value) in headers {
+        request = request.header(name, value);
+    }
+    let request = request.body(payload).map_err(|err| err.to_string())?;
+    client.send(request).map_err(|err| err.to_string())?;
+    debug!(%url, "Lifecycle hook notified");
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use httpmock::prelude::*;
+
+    #[test]
+    fn test_lifecycle_hook_config() {
+        let hooks: Vec<LifecycleHookConfig> = serde_saphyr::from_str(
+            r#"
+- events: [config-applied, rollback]
+  exec:
+    path: /usr/local/bin/update-cmdb
+    args: ["--source", "agent-control"]
+- webhook:
+    url: https://alerts.example.com/agent-control
+  timeout: 5s
+"#,
+        )
+        .unwrap();
+        assert_eq!(
+            hooks,
+            vec![
+                LifecycleHookConfig {
+                    events: vec![
+                        LifecycleEventKind::ConfigApplied,
+                        LifecycleEventKind::Rollback
+                    ],
+                    action: HookAction::Exec {
+                        path: "/usr/local/bin/update-cmdb".to_string(),
+                        args: vec!["--source".to_string(), "agent-control".to_string()],
+                    },
+                    timeout: DEFAULT_HOOK_TIMEOUT,
+                },
+                LifecycleHookConfig {
+                    events: Vec::new(),
+                    action: HookAction::Webhook {
+                        url: "https://alerts.example.com/agent-control".parse().unwrap(),
+                        headers: HashMap::new(),
+                    },
+                    timeout: Duration::from_secs(5),
+                },
+            ]
+        );
+        assert!(hooks[0].runs_on(&rollback()));
+        assert!(!hooks[0].runs_on(&LifecycleEvent::UpgradeComplete {
+            from_version: "1.0.0".to_string(),
+            to_version: "1.1.0".to_string(),
+        }));
+        assert!(hooks[1].runs_on(&rollback()));
+    }
+
+    fn rollback() -> LifecycleEvent {
+        LifecycleEvent::Rollback {
+            agent_id: "nr-infra".to_string(),
+            reason: "agent unhealthy".to_string(),
+        }
+    }
+
+    #[test]
+    fn test_lifecycle_event_payload() {
+        assert_eq!(
+            serde_json::to_value(rollback()).unwrap(),
+            serde_json::json!({
+                "event": "rollback",
+                "agent_id": "nr-infra",
+                "reason": "agent unhealthy",
+            })
+        );
+        assert_eq!(rollback().name(), "rollback");
+    }
+
+    #[test]
+    fn test_webhook_hook() {
+        let server = MockServer::start();
+        let mock = server.mock(|when, then| {
+            when.method(POST)
+                .path("/hook")
+                .header("authorization", "Bearer token")
+                .json_body(serde_json::to_value(rollback()).unwrap());
+            then.status(200);
+        });
+        let hook = LifecycleHookConfig {
+            events: Vec::new(),
+            action: HookAction::Webhook {
+                url: server.url("/hook").parse().unwrap(),
+                headers: HashMap::from([("Authorization".to_string(), "Bearer token".to_string())]),
+            },
+            timeout: DEFAULT_HOOK_TIMEOUT,
+        };
+
+        run(
+            &hook,
+            &rollback(),
+            ProxyConfig::default(),
+            &AuditTrail::default(),
+        )
+        .unwrap();
+        mock.assert();
+    }
+
+    #[test]
+    fn test_notify_runs_the_hooks_of_the_event() {
+        let server = MockServer::start();
+        let mock = server.mock(|when, then| {
+            when.method(POST)
+                .path("/hook")
+                .json_body(serde_json::to_value(rollback()).unwrap());
+            then.status(200);
+        });
+        let lifecycle_hooks = LifecycleHooks::new(
+            vec![LifecycleHookConfig {
+                events: vec![LifecycleEventKind::Rollback],
+                action: HookAction::Webhook {
+                    url: server.url("/hook").parse().unwrap(),
+                    headers: HashMap::new(),
+                },
+                timeout: DEFAULT_HOOK_TIMEOUT,
+            }],
+            ProxyConfig::default(),
+        );
+
+        lifecycle_hooks.notify(LifecycleEvent::UpgradeComplete {
+            from_version: "1.0.0".to_string(),
+            to_version: "1.1.0".to_string(),
+        });
+        lifecycle_hooks.notify(rollback());
+
+        let deadline = Instant::now() + Duration::from_secs(5);
+        while mock.hits() == 0 && Instant::now() < deadline {
+            thread::sleep(POLL_INTERVAL);
+        }
+        mock.assert();
+
+        // Without hooks notifying does nothing.
+        LifecycleHooks::default().notify(rollback());
+    }
+
+    #[cfg(target_family = "unix")]
+    #[test]
+    fn test_exec_hook() {
+        let tmp_dir = tempfile::tempdir().unwrap();
+        let output = tmp_dir.path().join("event");
+        let hook = LifecycleHookConfig {
+            events: Vec::new(),
+            action: HookAction::Exec {
+                path: "sh".to_string(),
+                args: vec![
+                    "-c".to_string(),
+                    format!("echo \"${HOOK_EVENT_ENV_VAR}\" > {}", output.display()),
+                ],
+            },
+            timeout: DEFAULT_HOOK_TIMEOUT,
+        };
+        run(
+            &hook,
+            &rollback(),
+            ProxyConfig::default(),
+            &AuditTrail::default(),
+        )
+        .unwrap();
+        assert_eq!(std::fs::read_to_string(&output).unwrap(), "rollback\n");
+
+        let failing = LifecycleHookConfig {
+            action: HookAction::Exec {
+                path: "sh".to_string(),
+                args: vec!["-c".to_string(), "exit 3".to_string()],
+            },
+            ..hook.clone()
+        };
+        assert!(
+            run(
+                &failing,
+                &rollback(),
+                ProxyConfig::default(),
+                &AuditTrail::default()
+            )
+            .is_err()
+        );
+
+        let slow = LifecycleHookConfig {
+            action: HookAction::Exec {
+                path: "sleep".to_string(),
+                args: vec!["5".to_string()],
+            },
+            timeout: Duration::from_millis(200),
+            ..hook
+        };
+        assert!(
+            run(
+                &slow,
+                &rollback(),
+                ProxyConfig::default(),
+                &AuditTrail::default()
+            )
+            .is_err()
+        );
+    }
+}
@@ -4,9 +4,12 @@ use std::num::NonZeroUsize;
 use std::time::Duration;
 use std::{path::Path, sync::Arc};
 
-use crate::agent_control::config::OciAuth;
+use crate::agent_control::config::{OciAuth, SignatureVerificationMode};
+use crate::http::config::{EgressConfig, ProxyConfig};
+use crate::http::exchange_log::{ExchangeLogConfig, ExchangeLogger, Outcome};
+use crate::signature::public_key::PublicKey;
+use crate::signature::public_key_fetcher::PublicKeyFetcher;
 use crate::utils::retry::{BackoffPolicy, retry_with_backoff};
-use crate::{http::config::ProxyConfig, signature::public_key_fetcher::PublicKeyFetcher};
 
 use futures::TryStreamExt;
 use oci_client::{
@@ -16,7 +19,7 @@ use oci_client::{
     secrets::RegistryAuth,
 };
 use tokio::runtime::Runtime;
-use tracing::debug;
+use tracing::{debug, warn};
 use url::Url;
 
 pub mod artifact_definitions;
@@ -42,35 +45,72 @@ pub struct Client {
     client: oci_client::Client,
     runtime: Arc<Runtime>,
     public_key_fetcher: PublicKeyFetcher,
+    exchange_log: Arc<ExchangeLogger>,
 }
 
 impl Client {
     /// Builds a [`Client`] from the given OCI client and proxy configuration, applying the proxy
-    /// settings and constructing the public-key fetcher used for signature verification. Async
-    /// operations are driven on the provided `runtime`.
+    /// settings and constructing the public-key fetcher used for signature verification, whose
+    /// connections are bound as `egress` sets. Async operations are driven on the provided
+    /// `runtime`.
     pub fn try_new(
         client_config: ClientConfig,
         proxy_config: ProxyConfig,
+        egress: EgressConfig,
         runtime: Arc<Runtime>,
     ) -> Result<Self, OciClientError> {
         let client_config = proxy::setup_proxy(client_config, proxy_config.clone())?;
-        let public_key_fetcher = Self::try_build_public_key_fetcher(proxy_config)?;
+        let public_key_fetcher = Self::try_build_public_key_fetcher(proxy_config, egress)?;
         Ok(Self {
             client: oci_client::Client::new(client_config),
             public_key_fetcher,
             runtime,
+            exchange_log: Arc::default(),
         })
     }
 
+    /// Returns a copy of this client logging the pulls as `config` sets.
+    pub fn with_exchange_log(self, config: ExchangeLogConfig) -> Self {
+        Self {
+            exchange_log: Arc::new(ExchangeLogger::new(config)),
+            ..self
+        }
+    }
+
+    /// Runs `pull` logging it as an exchange with the registry if it is sampled. `response_size`
+    /// returns the size of the pulled content, if known.
+    fn logged<T>(
+        &self,
+        reference: &Reference,
+        response_size: impl FnOnce(&T) -> Option<u64>,
+        pull: impl FnOnce() -> Result<T, OciClientError>,
+    ) -> Result<T, OciClientError> {
+        let Some(exchange) = self.exchange_log.start(reference) else {
+            return pull();
+        };
+        let result = pull();
+        match &result {
+            Ok(pulled) => exchange.finish(Outcome::Succeeded, None, response_size(pulled)),
+            Err(err) => exchange.finish(Outcome::Failed(err), None, None),
+        }
+        result
+    }
+
     /// Wraps [oci_client::Client::pull_image_manifest].
     pub fn pull_image_manifest(
         &self,
         reference: &Reference,
         auth: &RegistryAuth,
     ) -> Result<(OciImageManifest, String), OciClientError> {
-        self.runtime
-            .block_on(self.client.pull_image_manifest(reference, auth))
-            .map_err(|err| OciClientError::PullManifest(err.into()))
+        self.logged(
+            reference,
+            |_| None,
+            || {
+                self.runtime
+                    .block_on(self.client.pull_image_manifest(reference, auth))
+                    .map_err(|err| OciClientError::PullManifest(err.into()))
+            },
+        )
     }
 
     /// Pulls  the specified blob through [oci_client::Client::pull_blob] and stores it in the specified file path.
@@ -79,6 +119,20 @@ impl Client {
         reference: &Reference,
         layer: impl AsLayerDescriptor,
         path: impl AsRef<Path>,
+    ) -> Result<(), OciClientError> {
+        let path = path.as_ref();
+        self.logged(
+            reference,
+            |_| path.metadata().ok().map(|metadata| metadata.len()),
+            || self.pull_blob_to_file_unlogged(reference, layer, path),
+        )
+    }
+
+    fn pull_blob_to_file_unlogged(
+        &self,
+        reference: &Reference,
+        layer: impl AsLayerDescriptor,
+        path: &Path,
     ) -> Result<(), OciClientError> {
         self.runtime.block_on(async {
             let mut file = tokio::fs::File::create(path).await.map_err(|err| {
@@ -110,6 +164,19 @@ impl Client {
         reference: &Reference,
         layer: impl AsLayerDescriptor,
         max_size_bytes: usize,
+    ) -> Result<Vec<u8>, OciClientError> {
+        self.logged(
+            reference,
+            |blob: &Vec<u8>| Some(blob.len() as u64),
+            || self.pull_blob_unlogged(reference, layer, max_size_bytes),
+        )
+    }
+
+    fn pull_blob_unlogged(
+        &self,
+        reference: &Reference,
+        layer: impl AsLayerDescriptor,
+        max_size_bytes: usize,
     ) -> Result<Vec<u8>, OciClientError> {
         self.runtime.block_on(async {
             let mut stream = self
@@ -166,7 +233,8 @@ impl Client {
     /// - The signature itself is base64-encoded in the layer's annotations under `dev.cosignproject.cosign/signature`
     ///
     /// Public keys are fetched from `public_key_url` and verification will be performed using each key in the
-    /// corresponding payload. Signature verification succeeds if one of the signatures in the corresponding manifest
+    /// corresponding payload, along with the locally `trusted_keys`. If the keys can't be fetched, the trusted keys
+    /// are used alone (if there are any). Signature verification succeeds if one of the signatures in the corresponding manifest
     /// (one signature layer) corresponds to one of the public keys. Such verification uses Ed25519 algorithm.
     ///
     /// If verification succeeds, the verified `reference`, **including digest**, is returned.
@@ -175,12 +243,21 @@ impl Client {
         &self,
         reference: &Reference,
         public_key_url: &Url,
+        trusted_keys: &[PublicKey],
         auth: &RegistryAuth,
     ) -> Result<Reference, OciClientError> {
-        let public_keys = self
-            .public_key_fetcher
-            .fetch(public_key_url)
-            .map_err(|err| OciClientError::Verify(format!("could not fetch public keys: {err}")))?;
+        let mut public_keys = trusted_keys.to_vec();
+        match self.public_key_fetcher.fetch(public_key_url) {
+            Ok(keys) => public_keys.extend(keys),
+            Err(err) if !trusted_keys.is_empty() => {
+                warn!("Could not fetch public keys, verifying with the trusted keys only: {err}");
+            }
+            Err(err) => {
+                return Err(OciClientError::Verify(format!(
+                    "could not fetch public keys: {err}"
+                )));
+            }
+        }
         self.runtime
             .block_on(self.verify_signature_with_public_keys(reference, &public_keys, auth))
     }
@@ -195,6 +272,8 @@ pub struct OciArtifactFetcher {
     client: Client,
     auth: RegistryAuth,
     policy: BackoffPolicy,
+    trusted_keys: Vec<PublicKey>,
+    verification_mode: SignatureVerificationMode,
 }
 
 impl OciArtifactFetcher {
@@ -207,6 +286,8 @@ impl OciArtifactFetcher {
                 .map(RegistryAuth::from)
                 .unwrap_or(RegistryAuth::Anonymous),
             policy: DEFAULT_NO_RETRY_POLICY,
+            trusted_keys: Vec::new(),
+            verification_mode: SignatureVerificationMode::default(),
         }
     }
 
@@ -216,6 +297,22 @@ impl OciArtifactFetcher {
         Self { policy, ..self }
     }
 
+    /// Returns a new fetcher that also verifies signatures with the provided trusted keys.
+    pub fn with_trusted_keys(self, trusted_keys: Vec<PublicKey>) -> Self {
+        Self {
+            trusted_keys,
+            ..self
+        }
+    }
+
+    /// Returns a new fetcher handling signature verification failures as `verification_mode` sets.
+    pub fn with_verification_mode(self, verification_mode: SignatureVerificationMode) -> Self {
+        Self {
+            verification_mode,
+            ..self
+        }
+    }
+
     /// Resolves the reference (verifying its signature when `public_key_url` is `Some`) and fetches
     /// the artifact from it, retrying the whole operation per the configured policy.
     ///
@@ -242,17 +339,30 @@ impl OciArtifactFetcher {
     /// When `public_key_url` is `Some`, the artifact's signature is verified via
     /// [Client::verify_signature] and the returned reference is digest-pinned (assuring the artifact
     /// downloaded is the one verified). When it is `None`, signature verification is skipped and the
-    /// `base` reference is returned unchanged.
+    /// `base` reference is returned unchanged. In permissive mode, the `base` reference is also
+    /// returned if the verification fails.
     fn verified_reference(
         &self,
         base: &Reference,
         public_key_url: Option<&Url>,
     ) -> Result<Reference, OciClientError> {
-        match public_key_url {
-            Some(public_key_url) => self
-                .client
-                .verify_signature(base, public_key_url, &self.auth),
-            None => Ok(base.clone()),
+        let Some(public_key_url) = public_key_url else {
+            return Ok(base.clone());
+        };
+        match self
+            .client
+            .verify_signature(base, public_key_url, &self.trusted_keys, &self.auth)
+        {
+            Err(OciClientError::Verify(err))
+                if self.verification_mode == SignatureVerificationMode::Permissive =>
+            {
+                warn!(
+                    reference = %base,
+                    "Using the artifact in permissive mode, its signature verification failed: {err}"
+                );
+                Ok(base.clone())
+            }
+            result => result,
         }
     }
 }
@@ -281,6 +391,7 @@ pub mod tests {
                 ..Default::default()
             },
             ProxyConfig::default(),
+            EgressConfig::default(),
             tokio_runtime(),
         )
         .expect("Failed to create test Client")
@@ -316,7 +427,7 @@ pub mod tests {
         assert!(image_ref.digest().is_none()); // The reference to be verified doesn't have digest
 
         let result =
-            client.verify_signature(&image_ref, &jwks_server.url, &RegistryAuth::Anonymous);
+            client.verify_signature(&image_ref, &jwks_server.url, &[], &RegistryAuth::Anonymous);
 
         if signer_position.is_some() {
             let verified_ref = result.expect("verification should succeed");
@@ -345,7 +456,7 @@ pub mod tests {
         let image_ref = mock_server.reference();
 
         let result =
-            client.verify_signature(&image_ref, &jwks_server.url, &RegistryAuth::Anonymous);
+            client.verify_signature(&image_ref, &jwks_server.url, &[], &RegistryAuth::Anonymous);
 
         assert_matches!(result, Err(OciClientError::Verify(_)));
     }
@@ -488,6 +599,50 @@ pub mod tests {
         );
     }
 
+    #[test]
+    fn test_fetch_verifies_with_trusted_keys() {
+        let trusted_key_pair = TestKeyPair::new(0);
+        let jwks_server = JwksMockServer::new(vec![
+            serde_json::to_value(TestKeyPair::new(1).public_key_jwk()).unwrap(),
+        ]);
+        let server = FakeOciServer::new("repo", "v1")
+            .with_layer(b"content", "fake-media_type")
+            .with_signature(&trusted_key_pair)
+            .build();
+
+        let fetcher = create_fetcher().with_trusted_keys(vec![trusted_key_pair.public_key()]);
+        let blob = fetcher
+            .fetch(
+                &server.reference(),
+                Some(&jwks_server.url),
+                pull_first_layer,
+            )
+            .unwrap();
+        assert_eq!(blob, b"content");
+    }
+
+    #[test]
+    fn test_fetch_unsigned_in_permissive_mode() {
+        let key_pair = TestKeyPair::new(0);
+        let jwks_server = JwksMockServer::new(vec![
+            serde_json::to_value(key_pair.public_key_jwk()).unwrap(),
+        ]);
+        let server = FakeOciServer::new("repo", "v1")
+            .with_layer(b"content", "fake-media_type")
+            .build(); // unsigned
+
+        let fetcher =
+            create_fetcher().with_verification_mode(SignatureVerificationMode::Permissive);
+        let blob = fetcher
+            .fetch(
+                &server.reference(),
+                Some(&jwks_server.url),
+                pull_first_layer,
+            )
+            .unwrap();
+        assert_eq!(blob, b"content");
+    }
+
     #[test]
     fn test_fetch_returns_last_error_on_missing_manifest() {
         let server = MockServer::start();
@@ -2,7 +2,7 @@ use super::{Client, OciClientError};
 use crate::{
     http::{
         client::HttpClient,
-        config::{HttpConfig, ProxyConfig},
+        config::{EgressConfig, HttpConfig, ProxyConfig},
     },
     signature::{public_key::PublicKey, public_key_fetcher::PublicKeyFetcher},
 };
@@ -59,12 +59,14 @@ impl Client {
     /// Helper to build the [PublicKeyFetcher] corresponding to the client.
     pub(super) fn try_build_public_key_fetcher(
         proxy_config: ProxyConfig,
+        egress: EgressConfig,
     ) -> Result<PublicKeyFetcher, OciClientError> {
         let http_config = HttpConfig::new(
             DEFAULT_PUBLIC_KEY_FETCH_TIMEOUT,
             DEFAULT_PUBLIC_KEY_FETCH_TIMEOUT,
             proxy_config,
-        );
+        )
+        .with_egress(egress);
         let http_client = HttpClient::new(http_config)
             .map_err(|err| OciClientError::Build(format!("failure building http-client: {err}")))?;
 
@@ -246,7 +248,7 @@ pub fn triangulate(reference: &Reference, digest: &str) -> Reference {
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::http::config::ProxyConfig;
+    use crate::http::config::{EgressConfig, ProxyConfig};
     use crate::signature::public_key::tests::TestKeyPair;
     use crate::{oci::tests::FakeOciServer, utils::test_runtime::tokio_runtime};
     use assert_matches::assert_matches;
@@ -525,6 +527,7 @@ mod tests {
                 ..Default::default()
             },
             ProxyConfig::default(),
+            EgressConfig::default(),
             tokio_runtime(),
         )
         .expect("Failed to create test Client")
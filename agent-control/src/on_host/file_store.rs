@@ -21,7 +21,7 @@ use tracing::{debug, error, trace};
 use crate::{
     agent_control::{
         agent_id::AgentID,
-        defaults::{FOLDER_NAME_FLEET_DATA, FOLDER_NAME_LOCAL_DATA},
+        defaults::{FOLDER_NAME_FLEET_DATA, FOLDER_NAME_LOCAL_DATA, STORE_KEY_LOCAL_DATA_CONFIG},
     },
     data_store::{DataStore, StoreKey},
     resource_ownership::ResourceOwnership,
@@ -40,6 +40,7 @@ where
     file_rw: F,
     remote_dir: RwLock<RemoteDir>,
     local_dir: LocalDir,
+    agent_control_config: Option<PathBuf>,
 }
 
 /// Base directory holding local (on-host provided) configuration.
@@ -79,6 +80,7 @@ impl FileStore<LocalFile, DirectoryManagerFs> {
             directory_manager: DirectoryManagerFs,
             local_dir,
             remote_dir,
+            agent_control_config: None,
         }
     }
 }
@@ -98,6 +100,16 @@ where
             directory_manager,
             local_dir,
             remote_dir,
+            agent_control_config: None,
+        }
+    }
+
+    /// Reads the local config of Agent Control from `config_file`, if set, instead of from the
+    /// local directory.
+    pub fn with_agent_control_config(self, config_file: Option<PathBuf>) -> Self {
+        Self {
+            agent_control_config: config_file,
+            ..self
         }
     }
 
@@ -180,7 +192,15 @@ where
         T: DeserializeOwned,
     {
         debug!(%agent_id, "Getting local data at key '{key}'");
-        self.get(self.local_dir.get_file_path(agent_id, key))
+        let path = match &self.agent_control_config {
+            Some(config_file)
+                if agent_id == &AgentID::AgentControl && key == STORE_KEY_LOCAL_DATA_CONFIG =>
+            {
+                config_file.clone()
+            }
+            _ => self.local_dir.get_file_path(agent_id, key),
+        };
+        self.get(path)
     }
 
     fn set_remote_data<T>(
@@ -259,7 +279,7 @@ pub fn build_config_name(name: &str) -> String {
 #[cfg(test)]
 #[allow(missing_docs)]
 mod tests {
-    use std::{collections::HashMap, io, path::PathBuf, sync::Arc};
+    use std::{collections::HashMap, io, path::PathBuf, sync::Arc, thread::sleep, time::Duration};
 
     use assert_matches::assert_matches;
     use fs::{
@@ -277,9 +297,11 @@ mod tests {
             agent_id::AgentID,
             defaults::{
                 INSTANCE_ID_FILENAME, STORE_KEY_INSTANCE_ID, STORE_KEY_LOCAL_DATA_CONFIG,
-                STORE_KEY_OPAMP_DATA_CONFIG, default_capabilities,
+                STORE_KEY_LOCAL_MANDATORY_DATA_CONFIG, STORE_KEY_OPAMP_DATA_CONFIG,
+                default_capabilities,
             },
         },
+        event::channel::pub_sub,
         opamp::{
             instance_id::{
                 InstanceID,
@@ -530,6 +552,10 @@ state: applied
 
         // Expectations
         file_rw.should_read(&test_path, yaml_config_content.to_string());
+        file_rw.should_not_read_file_not_found(
+            &local_dir_path.get_file_path(&agent_id, STORE_KEY_LOCAL_MANDATORY_DATA_CONFIG),
+            "some_error_message".to_string(),
+        );
 
         let file_store = Arc::new(FileStore::new(
             file_rw,
@@ -558,6 +584,44 @@ state: applied
         );
     }
 
+    #[rstest]
+    fn test_load_agent_control_config_file(agent_id: AgentID) {
+        let mut file_rw = MockLocalFile::new();
+        let dir_manager = MockDirectoryManager::new();
+        let local_dir_path = LocalDir::from(PathBuf::from("some/local/path/"));
+        let config_file = PathBuf::from("/etc/newrelic/agent-control.yaml");
+
+        // Expectations
+        file_rw.should_read(&config_file, "host_id: from-file".to_string());
+        file_rw.should_read(
+            &local_dir_path.get_file_path(&agent_id, STORE_KEY_LOCAL_DATA_CONFIG),
+            "some_config: true".to_string(),
+        );
+
+        let file_store = Arc::new(
+            FileStore::new(
+                file_rw,
+                dir_manager,
+                local_dir_path.into(),
+                PathBuf::from("some/remote/path/"),
+            )
+            .with_agent_control_config(Some(config_file)),
+        );
+        let repo = ConfigRepo::new(file_store);
+
+        let ac_config = repo.load_local(&AgentID::AgentControl).unwrap().unwrap();
+        assert_eq!(
+            ac_config.get_yaml_config().get("host_id").unwrap(),
+            &Value::String("from-file".to_string())
+        );
+        // Only the config of Agent Control is read from the file.
+        let agent_config = repo.load_local(&agent_id).unwrap().unwrap();
+        assert_eq!(
+            agent_config.get_yaml_config().get("some_config").unwrap(),
+            &Value::Bool(true)
+        );
+    }
+
     #[rstest]
     fn test_load_when_remote_enabled_file_not_found_fallbacks_to_local(agent_id: AgentID) {
         let mut file_rw = MockLocalFile::new();
@@ -572,6 +636,10 @@ state: applied
 
         let yaml_config_content = "some_config: true\nanother_item: false";
         file_rw.should_read(&local_path, yaml_config_content.to_string());
+        file_rw.should_not_read_file_not_found(
+            &local_dir_path.get_file_path(&agent_id, STORE_KEY_LOCAL_MANDATORY_DATA_CONFIG),
+            "some_error_message".to_string(),
+        );
 
         let file_store = Arc::new(FileStore::new(
             file_rw,
@@ -596,6 +664,54 @@ state: applied
         );
     }
 
+    #[rstest]
+    fn test_load_local_mandatory_overrides_remote(agent_id: AgentID) {
+        let mut file_rw = MockLocalFile::new();
+        let dir_manager = MockDirectoryManager::new();
+        let remote_dir_path = RemoteDir::from(PathBuf::from("some/remote/path/"));
+        let local_dir_path = LocalDir::from(PathBuf::from("some/local/path/"));
+        let remote_path = remote_dir_path.get_file_path(&agent_id, STORE_KEY_OPAMP_DATA_CONFIG);
+        let mandatory_path =
+            local_dir_path.get_file_path(&agent_id, STORE_KEY_LOCAL_MANDATORY_DATA_CONFIG);
+
+        // Expectations
+        file_rw.should_read(
+            &remote_path,
+            r#"
+config:
+    proxy: remote-proxy
+    another_item: false
+hash: a-hash
+state: applied
+"#
+            .to_string(),
+        );
+        file_rw.should_read(&mandatory_path, "proxy: pinned-proxy".to_string());
+
+        let file_store = Arc::new(FileStore::new(
+            file_rw,
+            dir_manager,
+            local_dir_path.into(),
+            remote_dir_path.into(),
+        ));
+        let repo = ConfigRepo::new(file_store).with_remote();
+
+        let config = repo
+            .load_remote_fallback_local(&agent_id, &default_capabilities())
+            .expect("unexpected error loading config")
+            .expect("expected some configuration, got None");
+
+        assert_eq!(
+            config.get_yaml_config().get("proxy").unwrap(),
+            &Value::String("pinned-proxy".to_string())
+        );
+        assert_eq!(
+            config.get_yaml_config().get("another_item").unwrap(),
+            &Value::Bool(false)
+        );
+        assert_eq!(config.get_hash(), Some(&Hash::from("a-hash")));
+    }
+
     #[rstest]
     fn test_load_local_file_not_found_should_return_none(agent_id: AgentID) {
         let mut file_rw = MockLocalFile::new();
@@ -725,6 +841,89 @@ state: applied
         assert_matches!(result, Err(ConfigRepositoryError::StoreError(_)));
     }
 
+    #[rstest]
+    fn test_load_local_cancellable(agent_id: AgentID) {
+        let mut file_rw = MockLocalFile::new();
+        let remote_dir_path = RemoteDir::from(PathBuf::from("some/remote/path/"));
+        let local_dir_path = LocalDir::from(PathBuf::from("some/local/path/"));
+
+        // Expectations
+        file_rw.should_read(
+            &local_dir_path.get_file_path(&agent_id, STORE_KEY_LOCAL_DATA_CONFIG),
+            "some_config: true".to_string(),
+        );
+
+        let file_store = Arc::new(FileStore::new(
+            file_rw,
+            MockDirectoryManager::new(),
+            local_dir_path.into(),
+            remote_dir_path.into(),
+        ));
+        let (_cancellation_publisher, cancellation_consumer) = pub_sub();
+        let repo = ConfigRepo::new(file_store).with_cancellation(cancellation_consumer);
+
+        let config = repo
+            .load_local(&agent_id)
+            .expect("unexpected error loading config")
+            .expect("expected some configuration, got None");
+
+        assert_eq!(
+            config.get_yaml_config().get("some_config").unwrap(),
+            &Value::Bool(true)
+        );
+    }
+
+    #[rstest]
+    fn test_load_local_cancelled(agent_id: AgentID) {
+        let mut file_rw = MockLocalFile::new();
+        let remote_dir_path = RemoteDir::from(PathBuf::from("some/remote/path/"));
+        let local_dir_path = LocalDir::from(PathBuf::from("some/local/path/"));
+
+        // Expectations: the filesystem hangs
+        file_rw.expect_read().returning(|_| {
+            sleep(Duration::from_secs(10));
+            Ok(String::new())
+        });
+
+        let file_store = Arc::new(FileStore::new(
+            file_rw,
+            MockDirectoryManager::new(),
+            local_dir_path.into(),
+            remote_dir_path.into(),
+        ));
+        let (cancellation_publisher, cancellation_consumer) = pub_sub();
+        let repo = ConfigRepo::new(file_store).with_cancellation(cancellation_consumer);
+        drop(cancellation_publisher);
+
+        let result = repo.load_local(&agent_id);
+        assert_matches!(result, Err(ConfigRepositoryError::Cancelled));
+    }
+
+    #[rstest]
+    fn test_load_local_timeout(agent_id: AgentID) {
+        let mut file_rw = MockLocalFile::new();
+        let remote_dir_path = RemoteDir::from(PathBuf::from("some/remote/path/"));
+        let local_dir_path = LocalDir::from(PathBuf::from("some/local/path/"));
+
+        // Expectations: the filesystem hangs
+        file_rw.expect_read().returning(|_| {
+            sleep(Duration::from_secs(10));
+            Ok(String::new())
+        });
+
+        let file_store = Arc::new(FileStore::new(
+            file_rw,
+            MockDirectoryManager::new(),
+            local_dir_path.into(),
+            remote_dir_path.into(),
+        ));
+        let io_timeout = Duration::from_millis(10);
+        let repo = ConfigRepo::new(file_store).with_io_timeout(io_timeout);
+
+        let result = repo.load_local(&agent_id);
+        assert_matches!(result, Err(ConfigRepositoryError::Timeout(timeout)) if timeout == io_timeout);
+    }
+
     #[rstest]
     fn test_store_remote_error_writing_file(agent_id: AgentID) {
         let mut file_rw = MockLocalFile::new();
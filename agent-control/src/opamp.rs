@@ -7,6 +7,7 @@ pub mod client_builder;
 pub mod effective_config;
 pub mod http;
 pub mod instance_id;
+pub mod network_wait;
 pub mod operations;
 pub mod remote_config;
 
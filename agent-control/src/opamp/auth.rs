@@ -1,3 +1,4 @@
 //! Authentication for the OpAMP connection: configuration and access-token retrieval.
 pub mod config;
+pub mod enrollment;
 pub mod token_retriever;
@@ -41,10 +41,11 @@ pub struct LocalConfig {
 }
 
 impl LocalConfig {
-    /// Builds a [`LocalConfig`] whose private key is located in the provided local data directory.
-    pub fn new(local_data_dir: PathBuf) -> Self {
+    /// Builds a [`LocalConfig`] whose private key is located in the provided data directory: the
+    /// local one for provisioned keys, the remote one for the keys generated on enrollment.
+    pub fn new(data_dir: PathBuf) -> Self {
         Self {
-            private_key_path: local_data_dir.join(AUTH_PRIVATE_KEY_FILE_NAME),
+            private_key_path: data_dir.join(AUTH_PRIVATE_KEY_FILE_NAME),
         }
     }
 }
@@ -0,0 +1,264 @@
+//! Enrollment of the host on first start: a short-lived install token is exchanged for a system
+//! identity of its own, so deployment artifacts don't need to carry long-lived credentials.
+//!
+//! The private key of the identity is generated locally and, as the client id of the identity,
+//! stored in the remote data directory, the one Agent Control can write to. Both are read back
+//! through the file secrets provider on the following starts, so the install token is only used
+//! once.
+use super::config::{AuthConfig, LocalConfig, ProviderConfig};
+use crate::agent_control::config::OpAMPClientConfig;
+use crate::agent_control::defaults::AUTH_CLIENT_ID_FILE_NAME;
+use crate::http::client::HttpClient;
+use crate::http::config::HttpConfig;
+use crate::secrets_provider::SecretsProvider;
+use crate::secrets_provider::file::FileSecretProvider;
+use fs::file::{LocalFile, writer::FileWriter};
+use http::Uri;
+use nr_auth::{
+    key::{
+        generation::{KeyType, PublicKeyPem},
+        local::{LocalKeyPairGenerator, LocalKeyPairGeneratorConfig},
+    },
+    system_identity::{
+        iam_client::http::{HttpIAMClient, IAMAuthCredential},
+        identity_creator::L2IdentityCreator,
+        input_data::{SystemIdentityCreationMetadata, environment::NewRelicEnvironment},
+    },
+};
+use serde::Deserialize;
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+use thiserror::Error;
+use tracing::{debug, info};
+
+const IDENTITY_CREATION_TIMEOUT: Duration = Duration::from_secs(10);
+const DEFAULT_TOKEN_RETRIES: u8 = 3;
+
+/// Enrollment configuration, used when no `auth_config` is provided.
+#[derive(Debug, Deserialize, PartialEq, Clone)]
+pub struct EnrollmentConfig {
+    /// File holding the install token: a short-lived token allowed to create system identities.
+    pub token_path: PathBuf,
+    /// Organization the system identity of the host is created in.
+    pub organization_id: String,
+    /// Endpoint to create the system identity.
+    #[serde(with = "http_serde::uri")]
+    pub identity_url: Uri,
+    /// Endpoint to obtain access tokens for the created system identity.
+    #[serde(with = "http_serde::uri")]
+    pub token_url: Uri,
+}
+
+/// Errors that can occur while enrolling the host.
+#[derive(Error, Debug)]
+pub enum EnrollmentError {
+    /// The install token could not be read.
+    #[error("reading the install token: {0}")]
+    InstallToken(String),
+    /// The key pair of the identity could not be generated.
+    #[error("generating the system identity key pair: {0}")]
+    KeyGeneration(String),
+    /// The system identity could not be created.
+    #[error("creating the system identity: {0}")]
+    IdentityCreation(String),
+    /// The client id of the created identity could not be stored.
+    #[error("storing the system identity client id: {0}")]
+    Storing(String),
+}
+
+/// Returns the OpAMP config authenticating with the identity of the host, enrolling the host
+/// first if it has no identity yet. Configs with `auth_config`, or without `enrollment`, are
+/// returned untouched. The identity is created connecting as `http_config` sets.
+pub fn enroll(
+    opamp_config: OpAMPClientConfig,
+    remote_dir: &Path,
+    http_config: &HttpConfig,
+) -> Result<OpAMPClientConfig, EnrollmentError> {
+    enroll_with(opamp_config, remote_dir, |enrollment, token, pub_key| {
+        create_identity(enrollment, token, pub_key, http_config)
+    })
+}
+
+/// Helper to allow injecting the identity creation.
+fn enroll_with<F>(
+    mut opamp_config: OpAMPClientConfig,
+    remote_dir: &Path,
+    create_identity: F,
+) -> Result<OpAMPClientConfig, EnrollmentError>
+where
+    F: Fn(&EnrollmentConfig, &str, PublicKeyPem) -> Result<String, EnrollmentError>,
+{
+    if opamp_config.auth_config.is_some() {
+        return Ok(opamp_config);
+    }
+    let Some(enrollment) = &opamp_config.enrollment else {
+        return Ok(opamp_config);
+    };
+
+    let local_config = LocalConfig::new(remote_dir.to_path_buf());
+    let client_id_path = remote_dir.join(AUTH_CLIENT_ID_FILE_NAME);
+    let secrets_provider = FileSecretProvider::new();
+
+    let client_id = match stored_client_id(&secrets_provider, &client_id_path, &local_config) {
+        Some(client_id) => {
+            debug!("Host already enrolled, using its system identity");
+            client_id
+        }
+        None => {
+            let token = secrets_provider
+                .get_secret(&enrollment.token_path.to_string_lossy())
+                .map_err(|err| EnrollmentError::InstallToken(err.to_string()))?;
+            let pub_key = LocalKeyPairGenerator::from(LocalKeyPairGeneratorConfig {
+                key_type: KeyType::Rsa4096,
+                file_path: local_config.private_key_path.clone(),
+            })
+            .generate()
+            .map_err(|err| EnrollmentError::KeyGeneration(err.to_string()))?;
+
+            let client_id = create_identity(enrollment, &token, pub_key)?;
+            LocalFile
+                .write(&client_id_path, client_id.clone())
+                .map_err(|err| EnrollmentError::Storing(err.to_string()))?;
+            info!(%client_id, "Host enrolled, the install token is no longer needed");
+            client_id
+        }
+    };
+
+    opamp_config.auth_config = Some(AuthConfig {
+        token_url: enrollment.token_url.clone(),
+        client_id: client_id.as_str().into(),
+        provider: Some(ProviderConfig::Local(local_config)),
+        retries: DEFAULT_TOKEN_RETRIES,
+    });
+    Ok(opamp_config)
+}
+
+/// Returns the client id stored on a previous enrollment, if the private key is stored too.
+fn stored_client_id<P: SecretsProvider>(
+    secrets_provider: &P,
+    client_id_path: &Path,
+    local_config: &LocalConfig,
+) -> Option<String> {
+    if !local_config.private_key_path.exists() {
+        return None;
+    }
+    secrets_provider
+        .get_secret(&client_id_path.to_string_lossy())
+        .ok()
+        .filter(|client_id| !client_id.is_empty())
+}
+
+/// Creates the system identity of the host with the install token, returning its client id.
+fn create_identity(
+    enrollment: &EnrollmentConfig,
+    token: &str,
+    pub_key: PublicKeyPem,
+    http_config: &HttpConfig,
+) -> Result<String, EnrollmentError> {
+    let http_client = HttpClient::new(
+        http_config
+            .clone()
+            .with_timeouts(IDENTITY_CREATION_TIMEOUT, IDENTITY_CREATION_TIMEOUT),
+    )
+    .map_err(|err| EnrollmentError::IdentityCreation(err.to_string()))?;
+
+    let metadata = SystemIdentityCreationMetadata {
+        organization_id: enrollment.organization_id.clone(),
+        name: None,
+        environment: NewRelicEnvironment::Custom {
+            token_renewal_endpoint: enrollment.token_url.clone(),
+            system_identity_creation_uri: enrollment.identity_url.clone(),
+        },
+    };
+    HttpIAMClient::new(http_client, metadata)
+        .create_l2_system_identity(&IAMAuthCredential::BearerToken(token.to_string()), &pub_key)
+        .map(|identity| identity.client_id)
+        .map_err(|err| EnrollmentError::IdentityCreation(err.to_string()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use assert_matches::assert_matches;
+    use std::cell::Cell;
+    use tempfile::tempdir;
+
+    fn opamp_config(token_path: PathBuf) -> OpAMPClientConfig {
+        OpAMPClientConfig {
+            enrollment: Some(EnrollmentConfig {
+                token_path,
+                organization_id: "org-id".to_string(),
+                identity_url: "https://identity.example.com".parse().unwrap(),
+                token_url: "https://token.example.com".parse().unwrap(),
+            }),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_enroll_once() {
+        let remote_dir = tempdir().unwrap();
+        let token_path = remote_dir.path().join("install_token");
+        std::fs::write(&token_path, "INSTALL_TOKEN\n").unwrap();
+        let created = Cell::new(0);
+        let create_identity = |_: &EnrollmentConfig, token: &str, _: PublicKeyPem| {
+            assert_eq!(token, "INSTALL_TOKEN");
+            created.set(created.get() + 1);
+            Ok("client-id".to_string())
+        };
+
+        let config = enroll_with(
+            opamp_config(token_path.clone()),
+            remote_dir.path(),
+            create_identity,
+        )
+        .unwrap();
+        let auth_config = config.auth_config.unwrap();
+        assert_eq!(auth_config.client_id, "client-id".into());
+        assert_eq!(
+            auth_config.provider,
+            Some(ProviderConfig::Local(LocalConfig::new(
+                remote_dir.path().to_path_buf()
+            )))
+        );
+        assert!(remote_dir.path().join(AUTH_CLIENT_ID_FILE_NAME).exists());
+
+        // The stored identity is used from then on, even without install token.
+        std::fs::remove_file(&token_path).unwrap();
+        let config =
+            enroll_with(opamp_config(token_path), remote_dir.path(), create_identity).unwrap();
+        assert_eq!(config.auth_config.unwrap().client_id, "client-id".into());
+        assert_eq!(created.get(), 1);
+    }
+
+    #[test]
+    fn test_enroll_without_install_token() {
+        let remote_dir = tempdir().unwrap();
+        let token_path = remote_dir.path().join("install_token");
+
+        let result = enroll_with(opamp_config(token_path), remote_dir.path(), |_, _, _| {
+            panic!("identity should not be created")
+        });
+        assert_matches!(result, Err(EnrollmentError::InstallToken(_)));
+    }
+
+    #[test]
+    fn test_no_enrollment_with_auth_config() {
+        let remote_dir = tempdir().unwrap();
+        let config = OpAMPClientConfig {
+            auth_config: Some(AuthConfig {
+                token_url: "https://token.example.com".parse().unwrap(),
+                client_id: "existing".into(),
+                provider: None,
+                retries: 0,
+            }),
+            ..opamp_config(remote_dir.path().join("install_token"))
+        };
+
+        let enrolled = enroll_with(config.clone(), remote_dir.path(), |_, _, _| {
+            panic!("identity should not be created")
+        })
+        .unwrap();
+        assert_eq!(enrolled, config);
+    }
+}
@@ -3,7 +3,6 @@ use super::config::AuthConfig;
 use crate::http::client::HttpBuildError;
 use crate::http::client::HttpClient;
 use crate::http::config::HttpConfig;
-use crate::http::config::ProxyConfig;
 use crate::secret_retriever;
 use chrono::DateTime;
 use nr_auth::{
@@ -60,11 +59,12 @@ impl TokenRetriever for TokenRetrieverImpl {
 
 impl TokenRetrieverImpl {
     /// Builds a token retriever from the optional auth config; returns the no-op variant when no
-    /// config is provided, otherwise an HTTP retriever signing requests with the secret's private key.
+    /// config is provided, otherwise an HTTP retriever signing requests with the secret's private key
+    /// and connecting as `http_config` sets.
     pub fn try_build<R>(
         auth_config: Option<AuthConfig>,
         secret_retriever: &R,
-        proxy_config: ProxyConfig,
+        http_config: HttpConfig,
     ) -> Result<Self, TokenRetrieverImplError>
     where
         R: secret_retriever::OpampSecretRetriever,
@@ -87,11 +87,8 @@ impl TokenRetrieverImpl {
 
         let jwt_signer = JwtSignerImpl::Local(signer);
 
-        let http_config = HttpConfig::new(
-            DEFAULT_AUTHENTICATOR_TIMEOUT,
-            DEFAULT_AUTHENTICATOR_TIMEOUT,
-            proxy_config,
-        );
+        let http_config =
+            http_config.with_timeouts(DEFAULT_AUTHENTICATOR_TIMEOUT, DEFAULT_AUTHENTICATOR_TIMEOUT);
 
         let client = HttpClient::new(http_config)?;
         let authenticator = HttpAuthenticator::new(client, ac.token_url.clone());
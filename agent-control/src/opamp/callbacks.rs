@@ -20,7 +20,7 @@ use opamp_client::{
     http::HttpClientError,
     opamp::proto::{
         AgentRemoteConfig, CustomMessage, EffectiveConfig, OpAmpConnectionSettings,
-        ServerErrorResponse, ServerToAgentCommand,
+        PackagesAvailable, ServerErrorResponse, ServerToAgentCommand,
     },
     operation::callbacks::{Callbacks, MessageData},
 };
@@ -144,6 +144,24 @@ where
             .publish(OpAMPEvent::RemoteConfigReceived(remote_config))?)
     }
 
+    fn publish_packages_available(&self, packages_available: PackagesAvailable) {
+        debug!(
+            agent_id = %self.agent_id,
+            "OpAMP packages offered: {:?}",
+            packages_available.packages.keys()
+        );
+        let _ = self
+            .publisher
+            .publish(OpAMPEvent::PackagesAvailable(packages_available))
+            .inspect_err(|err| {
+                error!(
+                    %self.agent_id,
+                    error_msg = %err,
+                    "error publishing opamp_event.packages_available"
+                )
+            });
+    }
+
     fn publish_on_connect(&self) {
         let _ = self
             .publisher
@@ -162,6 +180,11 @@ where
         } else {
             (None, err.to_string())
         };
+        trace!(
+            monotonic_counter.opamp_connect_errors = 1u64,
+            agent_id = %self.agent_id,
+            status_code = code.unwrap_or_default()
+        );
 
         let _ = self
             .publisher
@@ -195,6 +218,9 @@ where
 
     fn on_message(&self, msg: MessageData) {
         trace!(agent_id = %self.agent_id, "opamp message received: {:?}", msg);
+        if let Some(packages_available) = msg.packages_available {
+            self.publish_packages_available(packages_available);
+        }
         if let Some(msg_remote_config) = msg.remote_config {
             match self.process_remote_config(msg_remote_config, msg.custom_message) {
                 Ok(_) => trace!(agent_id = %self.agent_id, "on message ok"),
@@ -334,6 +360,35 @@ pub(crate) mod tests {
             .expect("transport error event should be received too");
     }
 
+    #[test]
+    fn test_packages_available() {
+        let (event_publisher, event_consumer) = pub_sub();
+        let effective_config_loader = MockEffectiveConfigLoader::new();
+
+        let callbacks = AgentCallbacks::new(
+            AgentID::try_from("agent").unwrap(),
+            event_publisher,
+            effective_config_loader,
+        );
+
+        let packages_available = PackagesAvailable {
+            all_packages_hash: b"hash".to_vec(),
+            ..Default::default()
+        };
+        callbacks.on_message(MessageData {
+            packages_available: Some(packages_available.clone()),
+            ..Default::default()
+        });
+
+        let event = event_consumer
+            .as_ref()
+            .recv_timeout(Duration::from_millis(1))
+            .expect("should receive an event");
+
+        assert_eq!(event, OpAMPEvent::PackagesAvailable(packages_available));
+        assert!(event_consumer.as_ref().is_empty());
+    }
+
     #[test]
     fn test_remote_config() {
         let valid_hash = "hash";
@@ -1,3 +1,4 @@
 //! Synchronous HTTP transport for the OpAMP client and its builder.
 pub mod builder;
 pub mod client;
+pub mod signing;
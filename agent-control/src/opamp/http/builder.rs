@@ -1,15 +1,19 @@
 //! Builder for the OpAMP HTTP client, including authentication and protobuf headers.
 use http::header::CONTENT_TYPE;
 use http::{HeaderMap, HeaderValue};
+use std::sync::{Arc, Mutex};
 use std::time::Duration;
 use tracing::error;
 
 use crate::agent_control::config::OpAMPClientConfig;
 use crate::http::client::{HttpBuildError, HttpClient};
 use crate::http::config::HttpConfig;
-use crate::http::config::ProxyConfig;
+use crate::http::config::{EgressConfig, ProxyConfig};
+use crate::http::exchange_log::ExchangeLogConfig;
+use crate::http::pinning::PinnedHost;
 use crate::opamp::auth::token_retriever::TokenRetrieverImpl;
 use crate::opamp::http::client::HttpOpAMPClient;
+use crate::opamp::http::signing::RequestSigner;
 use crate::secret_retriever::OpampSecretRetriever;
 use opamp_client::http::http_client::HttpClient as OpampHttpClient;
 
@@ -38,7 +42,12 @@ pub trait HttpClientBuilder {
 pub struct OpAMPHttpClientBuilder<R> {
     opamp_config: OpAMPClientConfig,
     proxy_config: ProxyConfig,
+    egress: EgressConfig,
+    exchange_log: ExchangeLogConfig,
     secret_retriever: R,
+    /// HTTP client shared by every OpAMP client built, and by the clones of this builder, so all
+    /// the agents reuse the same connections to Fleet Control. Built on first use.
+    shared_client: Arc<Mutex<Option<HttpClient>>>,
 }
 
 impl<R> OpAMPHttpClientBuilder<R>
@@ -54,10 +63,44 @@ where
         Self {
             opamp_config,
             proxy_config,
+            egress: EgressConfig::default(),
+            exchange_log: ExchangeLogConfig::default(),
             secret_retriever,
+            shared_client: Arc::default(),
         }
     }
 
+    /// Returns a copy of this builder binding the OpAMP connections as `egress` sets.
+    pub fn with_egress(self, egress: EgressConfig) -> Self {
+        Self { egress, ..self }
+    }
+
+    /// Returns a copy of this builder logging the OpAMP exchanges as `exchange_log` sets.
+    pub fn with_exchange_log(self, exchange_log: ExchangeLogConfig) -> Self {
+        Self {
+            exchange_log,
+            ..self
+        }
+    }
+
+    /// Returns the shared HTTP client, building it on first use.
+    fn shared_client(&self) -> Result<HttpClient, HttpClientBuilderError> {
+        let mut shared_client = self
+            .shared_client
+            .lock()
+            .expect("failed to acquire the lock");
+        if let Some(client) = shared_client.as_ref() {
+            return Ok(client.clone());
+        }
+        let client = HttpClient::new(self.http_config())?;
+        *shared_client = Some(client.clone());
+        Ok(client)
+    }
+
+    fn http_config(&self) -> HttpConfig {
+        opamp_http_config(&self.opamp_config, &self.proxy_config, self.egress.clone())
+    }
+
     /// Return the headers from the configuration + the Content-Type header
     /// necessary for OpAMP (application/x-protobuf)
     fn headers(&self) -> HeaderMap {
@@ -71,6 +114,34 @@ where
     }
 }
 
+/// Returns the HTTP config of the connections to Fleet Control: the OpAMP ones and the ones
+/// retrieving tokens or enrolling the host. The proxy set for the OpAMP communications takes
+/// precedence over the Agent Control `proxy_config`.
+pub fn opamp_http_config(
+    opamp_config: &OpAMPClientConfig,
+    proxy_config: &ProxyConfig,
+    egress: EgressConfig,
+) -> HttpConfig {
+    let proxy_config = opamp_config
+        .proxy
+        .clone()
+        .unwrap_or_else(|| proxy_config.clone());
+    let mut http_config =
+        HttpConfig::new(DEFAULT_CLIENT_TIMEOUT, DEFAULT_CLIENT_TIMEOUT, proxy_config)
+            .with_dns(opamp_config.dns.clone())
+            .with_egress(egress)
+            .with_tls(opamp_config.tls.clone());
+    if let Some(host) = opamp_config.endpoint.host_str()
+        && !opamp_config.pinned_public_keys.is_empty()
+    {
+        http_config = http_config.with_pinned_host(PinnedHost::new(
+            host,
+            opamp_config.pinned_public_keys.clone(),
+        ));
+    }
+    http_config
+}
+
 impl<R> HttpClientBuilder for OpAMPHttpClientBuilder<R>
 where
     R: OpampSecretRetriever,
@@ -80,19 +151,17 @@ where
     /// Build the HTTP Client. It will contain a Token Retriever, so in all
     /// post requests a Token will be retrieved from Identity System Service
     /// and injected as authorization header.
+    ///
+    /// Every client built shares the same underlying HTTP client, and therefore its connection
+    /// pool, while each agent keeps polling with its own instance id.
     fn build(&self) -> Result<Self::Client, HttpClientBuilderError> {
-        let http_config = HttpConfig::new(
-            DEFAULT_CLIENT_TIMEOUT,
-            DEFAULT_CLIENT_TIMEOUT,
-            self.proxy_config.clone(),
-        );
         let url = self.opamp_config.endpoint.clone();
         let headers = self.headers();
-        let client = HttpClient::new(http_config)?;
+        let client = self.shared_client()?;
         let token_retriever = TokenRetrieverImpl::try_build(
             self.opamp_config.clone().auth_config,
             &self.secret_retriever,
-            self.proxy_config.clone(),
+            self.http_config(),
         )
         .inspect_err(|err| error!("Could not build OpAMP's token retriever: {err}"))
         .map_err(|e| {
@@ -101,7 +170,19 @@ where
             ))
         })?;
 
-        Ok(HttpOpAMPClient::new(client, url, headers, token_retriever))
+        let client = HttpOpAMPClient::new(client, url, headers, token_retriever)
+            .with_exchange_log(self.exchange_log);
+        match &self.opamp_config.request_signing {
+            Some(request_signing) => {
+                let request_signer = RequestSigner::try_from(request_signing).map_err(|e| {
+                    HttpClientBuilderError::BuildingError(format!(
+                        "error trying to build OpAMP's request signer: {e}"
+                    ))
+                })?;
+                Ok(client.with_request_signer(request_signer))
+            }
+            None => Ok(client),
+        }
     }
 }
 
@@ -217,4 +298,70 @@ pub(crate) mod tests {
         };
         assert_matches!(err, OpAMPClientBuilderError::HttpClientBuilderError(_));
     }
+
+    #[derive(Debug, Clone)]
+    struct NoSecret;
+
+    impl OpampSecretRetriever for NoSecret {
+        type Error = std::io::Error;
+        fn retrieve(&self) -> Result<String, Self::Error> {
+            Err(std::io::Error::other("no secret"))
+        }
+    }
+
+    #[test]
+    fn test_opamp_http_client_builder_shares_http_client() {
+        let builder = OpAMPHttpClientBuilder::new(
+            OpAMPClientConfig::default(),
+            ProxyConfig::default(),
+            NoSecret,
+        );
+        let cloned = builder.clone();
+        assert!(builder.shared_client.lock().unwrap().is_none());
+
+        builder.build().unwrap();
+        cloned.build().unwrap();
+
+        assert!(Arc::ptr_eq(&builder.shared_client, &cloned.shared_client));
+        assert!(builder.shared_client.lock().unwrap().is_some());
+    }
+
+    #[test]
+    fn test_opamp_http_client_builder_proxy_override() {
+        let global_proxy = ProxyConfig::from_url("http://global.proxy:8080".to_string());
+        let opamp_proxy = ProxyConfig::from_url("http://opamp.proxy:8080".to_string());
+
+        let builder = OpAMPHttpClientBuilder::new(
+            OpAMPClientConfig::default(),
+            global_proxy.clone(),
+            NoSecret,
+        );
+        assert_eq!(builder.http_config().proxy, global_proxy);
+
+        let builder = OpAMPHttpClientBuilder::new(
+            OpAMPClientConfig {
+                proxy: Some(opamp_proxy.clone()),
+                ..Default::default()
+            },
+            global_proxy,
+            NoSecret,
+        );
+        assert_eq!(builder.http_config().proxy, opamp_proxy);
+    }
+
+    #[test]
+    fn test_opamp_http_config_binds_egress() {
+        let egress = EgressConfig {
+            source_address: Some("10.0.0.5".parse().unwrap()),
+            ..Default::default()
+        };
+
+        let http_config = opamp_http_config(
+            &OpAMPClientConfig::default(),
+            &ProxyConfig::default(),
+            egress.clone(),
+        );
+
+        assert_eq!(http_config.egress, egress);
+    }
 }
@@ -1,6 +1,8 @@
 //! # Synchronous OpAMP HTTP Client
 use crate::http::client::{HttpClient, HttpResponseError};
+use crate::http::exchange_log::{ExchangeLogConfig, ExchangeLogger, Outcome, REQUEST_ID_HEADER};
 use crate::opamp::http::client::OpAMPHttpClientError::AuthorizationHeadersError;
+use crate::opamp::http::signing::{RequestSigner, RequestSigningError};
 use http::header::AUTHORIZATION;
 use http::{HeaderMap, HeaderValue, Response};
 use nr_auth::TokenRetriever;
@@ -14,6 +16,9 @@ pub enum OpAMPHttpClientError {
     /// The authorization headers could not be built.
     #[error("could not build auth headers: {0}")]
     AuthorizationHeadersError(String),
+    /// The request could not be signed.
+    #[error("could not sign the request: {0}")]
+    RequestSigningError(#[from] RequestSigningError),
 }
 
 /// OpAMP HTTP client that signs each request with a token from the token retriever.
@@ -22,6 +27,8 @@ pub struct HttpOpAMPClient<T: TokenRetriever> {
     url: Url,
     headers: HeaderMap,
     token_retriever: T,
+    request_signer: Option<RequestSigner>,
+    exchange_log: ExchangeLogger,
 }
 
 impl<T> HttpOpAMPClient<T>
@@ -39,6 +46,24 @@ where
             url,
             headers,
             token_retriever,
+            request_signer: None,
+            exchange_log: ExchangeLogger::default(),
+        }
+    }
+
+    /// Returns a copy of this client signing every request with `request_signer`.
+    pub(super) fn with_request_signer(self, request_signer: RequestSigner) -> Self {
+        Self {
+            request_signer: Some(request_signer),
+            ..self
+        }
+    }
+
+    /// Returns a copy of this client logging the exchanges as `config` sets.
+    pub(super) fn with_exchange_log(self, config: ExchangeLogConfig) -> Self {
+        Self {
+            exchange_log: ExchangeLogger::new(config),
+            ..self
         }
     }
 
@@ -79,7 +104,14 @@ where
     T: TokenRetriever + Send + Sync + 'static,
 {
     fn post(&self, body: Vec<u8>) -> Result<Response<Vec<u8>>, HttpClientError> {
-        let headers = self.headers()?;
+        let mut headers = self.headers()?;
+        if let Some(signer) = &self.request_signer {
+            headers.extend(
+                signer
+                    .headers("POST", &self.url, &body)
+                    .map_err(OpAMPHttpClientError::from)?,
+            );
+        }
         let mut request = http::Request::builder()
             .method("POST")
             .uri(self.url.as_str())
@@ -88,7 +120,29 @@ where
         for (key, value) in &headers {
             request.headers_mut().insert(key, value.clone());
         }
-        Ok(self.client.send(request)?)
+
+        let Some(exchange) = self.exchange_log.start(&self.url) else {
+            return Ok(self.client.send(request)?);
+        };
+        if let Ok(request_id) = HeaderValue::from_str(exchange.request_id()) {
+            request.headers_mut().insert(REQUEST_ID_HEADER, request_id);
+        }
+        let request_size = Some(request.body().len() as u64);
+        let result = self.client.send(request);
+        match &result {
+            Ok(response) => exchange.finish(
+                Outcome::Status(response.status()),
+                request_size,
+                Some(response.body().len() as u64),
+            ),
+            Err(HttpResponseError::UnsuccessfulResponse { status_code, body }) => exchange.finish(
+                Outcome::Status(*status_code),
+                request_size,
+                Some(body.len() as u64),
+            ),
+            Err(err) => exchange.finish(Outcome::Failed(err), request_size, None),
+        }
+        Ok(result?)
     }
 }
 
@@ -113,6 +167,11 @@ pub mod tests {
     use mockall::mock;
 
     use crate::http::config::HttpConfig;
+    use crate::opamp::http::signing::{
+        KEY_ID_HEADER, NONCE_HEADER, SIGNATURE_HEADER, TIMESTAMP_HEADER,
+    };
+    use httpmock::Method::POST;
+    use httpmock::MockServer;
     use nr_auth::token::{AccessToken, Token, TokenType};
     use nr_auth::{TokenRetriever, TokenRetrieverError};
 
@@ -209,4 +268,51 @@ pub mod tests {
         let err = client.post("test".into()).unwrap_err();
         assert_matches!(err, HttpClientError::TransportError(_));
     }
+
+    #[test]
+    fn test_post_with_request_signer_adds_signature_headers() {
+        let server = MockServer::start();
+        let mock = server.mock(|when, then| {
+            when.method(POST)
+                .header_exists(TIMESTAMP_HEADER)
+                .header_exists(NONCE_HEADER)
+                .header_exists(SIGNATURE_HEADER)
+                .header(KEY_ID_HEADER, "key-1");
+            then.status(200);
+        });
+        let url = server.url("/v1/opamp").as_str().try_into().unwrap();
+        let http_client = HttpClient::new(HttpConfig::default()).unwrap();
+
+        let mut token_retriever = MockTokenRetriever::default();
+        token_retriever.should_retrieve(token_stub());
+
+        let client = HttpOpAMPClient::new(http_client, url, HeaderMap::new(), token_retriever)
+            .with_request_signer(RequestSigner::new(b"secret", "key-1".to_string()));
+
+        client.post("test".into()).unwrap();
+        mock.assert();
+    }
+
+    #[test]
+    fn test_post_with_exchange_log_adds_request_id_header() {
+        let server = MockServer::start();
+        let mock = server.mock(|when, then| {
+            when.method(POST).header_exists(REQUEST_ID_HEADER);
+            then.status(200);
+        });
+        let url = server.url("/v1/opamp").as_str().try_into().unwrap();
+        let http_client = HttpClient::new(HttpConfig::default()).unwrap();
+
+        let mut token_retriever = MockTokenRetriever::default();
+        token_retriever.should_retrieve(token_stub());
+
+        let client = HttpOpAMPClient::new(http_client, url, HeaderMap::new(), token_retriever)
+            .with_exchange_log(ExchangeLogConfig {
+                enabled: true,
+                ..Default::default()
+            });
+
+        client.post("test".into()).unwrap();
+        mock.assert();
+    }
 }
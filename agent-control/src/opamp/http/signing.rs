@@ -0,0 +1,332 @@
+//! HMAC request signing for the OpAMP HTTP requests.
+//!
+//! Each request carries a timestamp, a random nonce and an HMAC-SHA256 signature over them, the
+//! request method, host, path, query and body digest, computed with a key shared between the host
+//! and the server. It lets the server authenticate the host (and reject replayed requests)
+//! independently of the API key or access token sent in the headers.
+//!
+//! The signed string is the newline separated concatenation of:
+//!
+//! ```text
+//! <timestamp>\n<nonce>\n<METHOD>\n<host>\n<path>\n<query>\n<hex encoded SHA-256 of the body>
+//! ```
+//!
+//! The host includes the port when the url sets a non-default one (`host:port`), and the query is
+//! empty when the url has none.
+
+use aws_lc_rs::digest::{SHA256, digest};
+use aws_lc_rs::hmac;
+use aws_lc_rs::rand::{SecureRandom, SystemRandom};
+use base64::{Engine, prelude::BASE64_STANDARD};
+use http::{HeaderMap, HeaderValue};
+use serde::Deserialize;
+use std::path::PathBuf;
+use std::time::{SystemTime, UNIX_EPOCH};
+use url::Url;
+
+/// Unix time, in seconds, at which the request was signed.
+pub const TIMESTAMP_HEADER: &str = "x-nr-ac-timestamp";
+/// Random value, hex encoded, making each signed request unique.
+pub const NONCE_HEADER: &str = "x-nr-ac-nonce";
+/// Versioned signature: `v1=<base64 encoded HMAC-SHA256>`.
+pub const SIGNATURE_HEADER: &str = "x-nr-ac-signature";
+/// Identifier of the key used to sign the request, if configured.
+pub const KEY_ID_HEADER: &str = "x-nr-ac-key-id";
+
+const SIGNATURE_VERSION: &str = "v1";
+const NONCE_LEN: usize = 16;
+
+/// Configuration of the OpAMP requests signing.
+#[derive(Debug, Deserialize, PartialEq, Clone)]
+pub struct RequestSigningConfig {
+    /// Path to the file holding the shared key. Surrounding whitespace is ignored.
+    pub key_path: PathBuf,
+    /// Identifier of the key, sent along the signature so the server can rotate keys.
+    #[serde(default)]
+    pub key_id: String,
+}
+
+/// Errors produced while loading the signing key or signing a request.
+#[derive(thiserror::Error, Debug)]
+pub enum RequestSigningError {
+    /// The key file could not be read.
+    #[error("could not read the request signing key from {path}: {err}")]
+    ReadingKey {
+        /// Path of the key file.
+        path: String,
+        /// The underlying error message.
+        err: String,
+    },
+    /// The key file is empty.
+    #[error("the request signing key in {0} is empty")]
+    EmptyKey(String),
+    /// The random nonce could not be generated.
+    #[error("could not generate the request nonce")]
+    Nonce,
+    /// A signing header value is not valid.
+    #[error("invalid request signing header: {0}")]
+    InvalidHeader(String),
+}
+
+/// Signs requests with an HMAC-SHA256 key.
+#[derive(Debug)]
+pub struct RequestSigner {
+    key: hmac::Key,
+    key_id: String,
+    rng: SystemRandom,
+}
+
+impl TryFrom<&RequestSigningConfig> for RequestSigner {
+    type Error = RequestSigningError;
+
+    fn try_from(config: &RequestSigningConfig) -> Result<Self, Self::Error> {
+        let path = config.key_path.to_string_lossy().to_string();
+        let key =
+            std::fs::read(&config.key_path).map_err(|err| RequestSigningError::ReadingKey {
+                path: path.clone(),
+                err: err.to_string(),
+            })?;
+        let key = key.trim_ascii();
+        if key.is_empty() {
+            return Err(RequestSigningError::EmptyKey(path));
+        }
+        Ok(Self::new(key, config.key_id.clone()))
+    }
+}
+
+impl RequestSigner {
+    /// Builds a signer using `key` as HMAC key and identified by `key_id` (not sent if empty).
+    pub fn new(key: &[u8], key_id: String) -> Self {
+        Self {
+            key: hmac::Key::new(hmac::HMAC_SHA256, key),
+            key_id,
+            rng: SystemRandom::new(),
+        }
+    }
+
+    /// Returns the headers signing a request with the given method, url and body.
+    pub fn headers(
+        &self,
+        method: &str,
+        url: &Url,
+        body: &[u8],
+    ) -> Result<HeaderMap, RequestSigningError> {
+        let mut nonce = [0u8; NONCE_LEN];
+        self.rng
+            .fill(&mut nonce)
+            .map_err(|_| RequestSigningError::Nonce)?;
+        let timestamp = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .unwrap_or_default()
+            .as_secs();
+        self.signed_headers(timestamp, &hex(&nonce), method, url, body)
+    }
+
+    fn signed_headers(
+        &self,
+        timestamp: u64,
+        nonce: &str,
+        method: &str,
+        url: &Url,
+        body: &[u8],
+    ) -> Result<HeaderMap, RequestSigningError> {
+        let signature = self.signature(timestamp, nonce, method, url, body);
+
+        let mut headers = HeaderMap::new();
+        headers.insert(TIMESTAMP_HEADER, HeaderValue::from(timestamp));
+        headers.insert(NONCE_HEADER, header_value(nonce)?);
+        let mut signature_value = header_value(&format!("{SIGNATURE_VERSION}={signature}"))?;
+        signature_value.set_sensitive(true);
+        headers.insert(SIGNATURE_HEADER, signature_value);
+        if !self.key_id.is_empty() {
+            headers.insert(KEY_ID_HEADER, header_value(&self.key_id)?);
+        }
+        Ok(headers)
+    }
+
+    fn signature(
+        &self,
+        timestamp: u64,
+        nonce: &str,
+        method: &str,
+        url: &Url,
+        body: &[u8],
+    ) -> String {
+        let host = url.host_str().unwrap_or_default();
+        let host = match url.port() {
+            Some(port) => format!("{host}:{port}"),
+            None => host.to_string(),
+        };
+        let body_digest = hex(digest(&SHA256, body).as_ref());
+        let signed = format!(
+            "{timestamp}\n{nonce}\n{}\n{host}\n{}\n{}\n{body_digest}",
+            method.to_ascii_uppercase(),
+            url.path(),
+            url.query().unwrap_or_default()
+        );
+        BASE64_STANDARD.encode(hmac::sign(&self.key, signed.as_bytes()))
+    }
+}
+
+fn header_value(value: &str) -> Result<HeaderValue, RequestSigningError> {
+    HeaderValue::from_str(value).map_err(|err| RequestSigningError::InvalidHeader(err.to_string()))
+}
+
+fn hex(bytes: &[u8]) -> String {
+    bytes.iter().map(|b| format!("{b:02x}")).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use assert_matches::assert_matches;
+    use rstest::rstest;
+    use std::io::Write;
+    use tempfile::NamedTempFile;
+
+    fn url() -> Url {
+        "https://opamp.example.com/v1/opamp".try_into().unwrap()
+    }
+
+    fn url_from(url: &str) -> Url {
+        url.try_into().unwrap()
+    }
+
+    #[test]
+    fn test_signed_headers() {
+        let signer = RequestSigner::new(b"secret", "key-1".to_string());
+
+        let headers = signer
+            .signed_headers(1700000000, "0a1b", "post", &url(), b"body")
+            .unwrap();
+
+        // Signature of "1700000000\n0a1b\nPOST\nopamp.example.com\n/v1/opamp\n\n<sha256 of 'body'>"
+        // with key "secret"
+        let signed = format!(
+            "1700000000\n0a1b\nPOST\nopamp.example.com\n/v1/opamp\n\n{}",
+            hex(digest(&SHA256, b"body").as_ref())
+        );
+        let expected = BASE64_STANDARD.encode(hmac::sign(
+            &hmac::Key::new(hmac::HMAC_SHA256, b"secret"),
+            signed.as_bytes(),
+        ));
+        assert_eq!(headers.get(TIMESTAMP_HEADER).unwrap(), "1700000000");
+        assert_eq!(headers.get(NONCE_HEADER).unwrap(), "0a1b");
+        assert_eq!(
+            headers.get(SIGNATURE_HEADER).unwrap(),
+            format!("v1={expected}").as_str()
+        );
+        assert_eq!(headers.get(KEY_ID_HEADER).unwrap(), "key-1");
+    }
+
+    #[test]
+    fn test_signature_covers_request() {
+        let signer = RequestSigner::new(b"secret", String::new());
+        let signature = signer.signature(1, "nonce", "POST", &url(), b"body");
+
+        assert_ne!(
+            signature,
+            signer.signature(2, "nonce", "POST", &url(), b"body")
+        );
+        assert_ne!(
+            signature,
+            signer.signature(1, "other", "POST", &url(), b"body")
+        );
+        assert_ne!(
+            signature,
+            signer.signature(1, "nonce", "PUT", &url(), b"body")
+        );
+        assert_ne!(
+            signature,
+            signer.signature(1, "nonce", "POST", &url(), b"other")
+        );
+        assert_ne!(
+            signature,
+            RequestSigner::new(b"other", String::new()).signature(
+                1,
+                "nonce",
+                "POST",
+                &url(),
+                b"body"
+            )
+        );
+    }
+
+    #[rstest]
+    #[case::host("https://other.example.com/v1/opamp")]
+    #[case::port("https://opamp.example.com:8443/v1/opamp")]
+    #[case::path("https://opamp.example.com/other")]
+    #[case::query("https://opamp.example.com/v1/opamp?tenant=1")]
+    fn test_signature_covers_url(#[case] other_url: &str) {
+        let signer = RequestSigner::new(b"secret", String::new());
+
+        assert_ne!(
+            signer.signature(1, "nonce", "POST", &url(), b"body"),
+            signer.signature(1, "nonce", "POST", &url_from(other_url), b"body")
+        );
+    }
+
+    #[test]
+    fn test_signature_ignores_default_port() {
+        let signer = RequestSigner::new(b"secret", String::new());
+
+        assert_eq!(
+            signer.signature(1, "nonce", "POST", &url(), b"body"),
+            signer.signature(
+                1,
+                "nonce",
+                "POST",
+                &url_from("https://opamp.example.com:443/v1/opamp"),
+                b"body"
+            )
+        );
+    }
+
+    #[test]
+    fn test_nonces_are_unique() {
+        let signer = RequestSigner::new(b"secret", String::new());
+
+        let first = signer.headers("POST", &url(), b"body").unwrap();
+        let second = signer.headers("POST", &url(), b"body").unwrap();
+
+        assert_eq!(first.get(NONCE_HEADER).unwrap().len(), NONCE_LEN * 2);
+        assert_ne!(first.get(NONCE_HEADER), second.get(NONCE_HEADER));
+        assert!(first.get(KEY_ID_HEADER).is_none());
+    }
+
+    #[test]
+    fn test_signer_from_config() {
+        let mut key_file = NamedTempFile::new().unwrap();
+        writeln!(key_file, "secret").unwrap();
+        let config = RequestSigningConfig {
+            key_path: key_file.path().to_path_buf(),
+            key_id: String::new(),
+        };
+
+        let signer = RequestSigner::try_from(&config).unwrap();
+        // Surrounding whitespace is not part of the key
+        assert_eq!(
+            signer.signature(1, "nonce", "POST", &url(), b""),
+            RequestSigner::new(b"secret", String::new()).signature(1, "nonce", "POST", &url(), b"")
+        );
+
+        let empty_file = NamedTempFile::new().unwrap();
+        let config = RequestSigningConfig {
+            key_path: empty_file.path().to_path_buf(),
+            key_id: String::new(),
+        };
+        assert_matches!(
+            RequestSigner::try_from(&config),
+            Err(RequestSigningError::EmptyKey(_))
+        );
+
+        let config = RequestSigningConfig {
+            key_path: PathBuf::from("/non/existing/key"),
+            key_id: String::new(),
+        };
+        assert_matches!(
+            RequestSigner::try_from(&config),
+            Err(RequestSigningError::ReadingKey { .. })
+        );
+    }
+}
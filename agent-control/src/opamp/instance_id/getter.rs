@@ -49,6 +49,25 @@ where
             identifiers,
         }
     }
+
+    /// Replaces the stored instance id of the agent with a new one, so Fleet Control sees it as
+    /// a different instance from now on.
+    pub fn regenerate(&self, agent_id: &AgentID) -> Result<InstanceID, GetterError> {
+        let storer = self.storer.lock().expect("failed to acquire the lock");
+        self.persist_new(&storer, agent_id)
+    }
+
+    fn persist_new(&self, storer: &S, agent_id: &AgentID) -> Result<InstanceID, GetterError> {
+        let new_data = DataStored {
+            instance_id: InstanceID::create(),
+            identifiers: self.identifiers.clone(),
+        };
+
+        debug!(target_agent_id = %agent_id, "persisting instance id {}", new_data.instance_id);
+        storer.set(agent_id, &new_data)?;
+
+        Ok(new_data.instance_id)
+    }
 }
 
 impl<S> InstanceIDGetter for InstanceIDWithIdentifiersGetter<S>
@@ -71,15 +90,16 @@ where
             ),
         }
 
-        let new_data = DataStored {
-            instance_id: InstanceID::create(),
-            identifiers: self.identifiers.clone(),
-        };
-
-        debug!(target_agent_id = %agent_id, "persisting instance id {}", new_data.instance_id);
-        storer.set(agent_id, &new_data)?;
+        self.persist_new(&storer, agent_id)
+    }
+}
 
-        Ok(new_data.instance_id)
+impl<G> InstanceIDGetter for Arc<G>
+where
+    G: InstanceIDGetter + ?Sized,
+{
+    fn get(&self, agent_id: &AgentID) -> Result<InstanceID, GetterError> {
+        self.as_ref().get(agent_id)
     }
 }
 
@@ -234,6 +254,35 @@ pub mod tests {
         assert_ne!(instance_id, res.unwrap());
     }
 
+    #[test]
+    fn test_regenerate() {
+        let mut mock = MockInstanceIDStorer::new();
+        let agent_id = AgentID::try_from(AGENT_NAME).unwrap();
+        let stored = Arc::new(Mutex::new(None::<DataStored<MockIdentifiers>>));
+
+        let stored_get = stored.clone();
+        mock.expect_get()
+            .with(predicate::eq(agent_id.clone()))
+            .returning(move |_| Ok(stored_get.lock().unwrap().clone()));
+        let stored_set = stored.clone();
+        mock.expect_set()
+            .times(2)
+            .with(predicate::eq(agent_id.clone()), predicate::always())
+            .returning(move |_, data| {
+                *stored_set.lock().unwrap() = Some(data.clone());
+                Ok(())
+            });
+        let getter =
+            InstanceIDWithIdentifiersGetter::new(Arc::new(mock), MockIdentifiers::default());
+
+        let instance_id = getter.get(&agent_id).unwrap();
+        assert_eq!(getter.get(&agent_id).unwrap(), instance_id);
+
+        let regenerated = getter.regenerate(&agent_id).unwrap();
+        assert_ne!(regenerated, instance_id);
+        assert_eq!(getter.get(&agent_id).unwrap(), regenerated);
+    }
+
     #[test]
     fn test_thread_safety() {
         let mut mock = MockInstanceIDStorer::new();
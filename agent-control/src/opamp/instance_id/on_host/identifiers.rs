@@ -4,7 +4,6 @@ use crate::http::config::{HttpConfig, ProxyConfig};
 use crate::opamp::instance_id::definition::InstanceIdentifiers;
 
 use resource_detection::DetectError;
-use resource_detection::cloud::CLOUD_INSTANCE_ID;
 use resource_detection::cloud::aws::detector::{
     AWS_IPV4_METADATA_ENDPOINT, AWS_IPV4_METADATA_TOKEN_ENDPOINT, AWSDetector,
 };
@@ -12,12 +11,15 @@ use resource_detection::cloud::azure::detector::{AZURE_IPV4_METADATA_ENDPOINT, A
 use resource_detection::cloud::cloud_id::detector::CloudIdDetector;
 use resource_detection::cloud::gcp::detector::{GCP_IPV4_METADATA_ENDPOINT, GCPDetector};
 use resource_detection::cloud::http_client::{DEFAULT_CLIENT_TIMEOUT, HttpClientError};
+use resource_detection::cloud::{CLOUD_INSTANCE_ID, CLOUD_REGION, CLOUD_TYPE, CLOUD_TYPE_NO};
+use resource_detection::system::os_version::get_os_version;
 use resource_detection::system::{HOSTNAME_KEY, MACHINE_ID_KEY};
-use resource_detection::{Detector, system::detector::SystemDetector};
+use resource_detection::{Detector, Resource, system::detector::SystemDetector};
 use serde::{Deserialize, Serialize};
 use std::fmt::{Display, Formatter};
+use std::path::PathBuf;
 use thiserror::Error;
-use tracing::error;
+use tracing::{error, warn};
 
 /// On-host identifiers detected from system and cloud metadata.
 #[derive(Default, Debug, Deserialize, Serialize, PartialEq, Clone)]
@@ -36,6 +38,18 @@ pub struct Identifiers {
 
 impl InstanceIdentifiers for Identifiers {}
 
+/// Attributes describing the host that don't identify it, so they are not part of the
+/// [Identifiers] and can change without changing the instance ids.
+#[derive(Default, Debug, PartialEq, Clone)]
+pub struct HostDescription {
+    /// Version of the operating system, if known.
+    pub os_version: String,
+    /// Cloud provider of the host, like `aws`, if any.
+    pub cloud_provider: String,
+    /// Cloud region of the host, if any.
+    pub cloud_region: String,
+}
+
 impl Display for Identifiers {
     fn fmt(&self, f: &mut Formatter<'_>) -> std::fmt::Result {
         write!(
@@ -85,6 +99,26 @@ pub struct IdentifiersProvider<
     pub host_id: String,
     /// Configured fleet id.
     pub fleet_id: String,
+    /// File caching the last detected cloud instance id, used when the detection fails.
+    pub cloud_id_cache: Option<CloudIdCache>,
+}
+
+/// File caching the last detected cloud instance id along with the machine id of the host it was
+/// detected on, so it is only used on that same host (e.g. not on an instance created from an
+/// image of it).
+#[derive(Debug, Clone, PartialEq)]
+pub struct CloudIdCache {
+    /// Path of the cache file.
+    pub path: PathBuf,
+    /// Whether the newly detected cloud instance ids are written to the cache.
+    pub update: bool,
+}
+
+/// Content of the [CloudIdCache] file.
+#[derive(Debug, Default, Deserialize, Serialize, PartialEq)]
+struct CachedCloudId {
+    machine_id: String,
+    cloud_instance_id: String,
 }
 
 impl IdentifiersProvider {
@@ -103,6 +137,7 @@ impl IdentifiersProvider {
             ),
             host_id: String::default(),
             fleet_id: String::default(),
+            cloud_id_cache: None,
         }
     }
 
@@ -135,8 +170,38 @@ where
         Self { fleet_id, ..self }
     }
 
+    /// Returns the provider caching the detected cloud instance id in the given file, so the host
+    /// id doesn't change when the instance metadata endpoint is temporarily unavailable.
+    pub fn with_cloud_id_cache(self, path: PathBuf) -> Self {
+        Self {
+            cloud_id_cache: Some(CloudIdCache { path, update: true }),
+            ..self
+        }
+    }
+
+    /// Same as [IdentifiersProvider::with_cloud_id_cache], without writing the detected cloud
+    /// instance id to the cache. Meant for commands that only inspect the host.
+    pub fn with_read_only_cloud_id_cache(self, path: PathBuf) -> Self {
+        Self {
+            cloud_id_cache: Some(CloudIdCache {
+                path,
+                update: false,
+            }),
+            ..self
+        }
+    }
+
     /// Detects and returns the on-host identifiers, erroring if no host id can be determined.
     pub fn provide(&self) -> Result<Identifiers, IdentifiersProviderError> {
+        self.provide_with_description()
+            .map(|(identifiers, _)| identifiers)
+    }
+
+    /// Same as [IdentifiersProvider::provide], also returning the [HostDescription] from the same
+    /// detection, so the cloud metadata endpoints are queried only once.
+    pub fn provide_with_description(
+        &self,
+    ) -> Result<(Identifiers, HostDescription), IdentifiersProviderError> {
         let system_identifiers = self.system_detector.detect()?;
 
         let hostname: String = system_identifiers
@@ -147,7 +212,22 @@ where
             .get(MACHINE_ID_KEY.into())
             .map(|val| val.into())
             .unwrap_or_default();
-        let cloud_instance_id = self.cloud_instance_id();
+        let cloud_resource = self.detect_cloud();
+        let cloud_value = |key: &str| -> String {
+            cloud_resource
+                .as_ref()
+                .and_then(|resource| resource.get(key.into()))
+                .map(|val| val.into())
+                .unwrap_or_default()
+        };
+        let cloud_instance_id = self.cloud_instance_id(&machine_id, cloud_value(CLOUD_INSTANCE_ID));
+        let description = HostDescription {
+            os_version: get_os_version().unwrap_or_default(),
+            cloud_provider: Some(cloud_value(CLOUD_TYPE))
+                .filter(|provider| provider != CLOUD_TYPE_NO)
+                .unwrap_or_default(),
+            cloud_region: cloud_value(CLOUD_REGION),
+        };
 
         // host_id is an aggregated identifier required by newrelic fleet management
         // to identify the host entity.
@@ -167,34 +247,66 @@ where
             return Err(IdentifiersProviderError::MissingHostIDError);
         }
 
-        Ok(Identifiers {
+        let identifiers = Identifiers {
             // https://opentelemetry.io/docs/specs/semconv/resource/host/#collecting-hostid-from-non-containerized-systems
             host_id,
             hostname,
             machine_id,
             cloud_instance_id,
             fleet_id: self.fleet_id.clone(),
-        })
+        };
+        Ok((identifiers, description))
+    }
+
+    // Get the cloud instance_id, from the cache if it can't be detected and it was cached on the
+    // host with the same machine id
+    fn cloud_instance_id(&self, machine_id: &str, detected: String) -> String {
+        let Some(cache) = &self.cloud_id_cache else {
+            return detected;
+        };
+        let cached = std::fs::read_to_string(&cache.path)
+            .ok()
+            .and_then(|content| serde_saphyr::from_str::<CachedCloudId>(&content).ok())
+            .filter(|cached| !machine_id.is_empty() && cached.machine_id == machine_id)
+            .unwrap_or_default();
+        if detected.is_empty() {
+            if !cached.cloud_instance_id.is_empty() {
+                warn!(
+                    cloud_instance_id = cached.cloud_instance_id,
+                    "Using the last detected cloud instance id"
+                );
+            }
+            return cached.cloud_instance_id;
+        }
+        if cache.update && detected != cached.cloud_instance_id {
+            let content = CachedCloudId {
+                machine_id: machine_id.to_string(),
+                cloud_instance_id: detected.clone(),
+            };
+            if let Err(err) = serde_saphyr::to_string(&content)
+                .map_err(|err| err.to_string())
+                .and_then(|content| {
+                    std::fs::write(&cache.path, content).map_err(|err| err.to_string())
+                })
+            {
+                warn!("Could not cache the cloud instance id: {err}");
+            }
+        }
+        detected
     }
 
-    // Try to get cloud instance_id from different cloud providers
-    fn cloud_instance_id(&self) -> String {
+    // Try to get the cloud instance metadata from different cloud providers
+    fn detect_cloud(&self) -> Option<Resource> {
         // TODO: should we propagate cloud error?
         self.cloud_id_detector
             .detect()
-            .map(|c_identifiers| {
-                c_identifiers
-                    .get(CLOUD_INSTANCE_ID.into())
-                    .map(|val| val.into())
-                    .unwrap_or_else(|| {
-                        error!("cannot get cloud id identifier");
-                        "".to_string()
-                    })
-            })
-            .unwrap_or_else(|e| {
-                error!("aws cloud detector error: {}", e);
-                "".to_string()
+            .inspect(|c_identifiers| {
+                if c_identifiers.get(CLOUD_INSTANCE_ID.into()).is_none() {
+                    error!("cannot get cloud id identifier");
+                }
             })
+            .inspect_err(|e| error!("aws cloud detector error: {}", e))
+            .ok()
     }
 }
 
@@ -206,6 +318,7 @@ pub mod tests {
     };
     use assert_matches::assert_matches;
     use mockall::mock;
+    use resource_detection::cloud::cloud_id::detector::CloudIdDetectorError;
     use resource_detection::{DetectError, Detector, Key, Resource, Value};
 
     mock! {
@@ -286,6 +399,7 @@ pub mod tests {
                     cloud_id_detector: self.cloud_id_detector_mock,
                     host_id: self.host_id,
                     fleet_id: String::new(),
+                    cloud_id_cache: None,
                 };
                 let identifiers = identifiers_provider.provide().expect(self.name);
 
@@ -397,6 +511,63 @@ pub mod tests {
         }
     }
 
+    #[test]
+    fn test_provide_host_description() {
+        let mut system_detector_mock = MockSystemDetector::new();
+        system_detector_mock.should_detect(system_id());
+        let mut cloud_id_detector_mock = MockCloudDetector::new();
+        cloud_id_detector_mock.should_detect(Resource::new([
+            (
+                Key::from("cloud_instance_id".to_string()),
+                Value::from(CLOUD_ID.to_string()),
+            ),
+            (
+                Key::from("cloud_type".to_string()),
+                Value::from("aws".to_string()),
+            ),
+            (
+                Key::from("cloud_region".to_string()),
+                Value::from("us-west-2".to_string()),
+            ),
+        ]));
+
+        let identifiers_provider = IdentifiersProvider {
+            system_detector: system_detector_mock,
+            cloud_id_detector: cloud_id_detector_mock,
+            host_id: String::new(),
+            fleet_id: String::new(),
+            cloud_id_cache: None,
+        };
+        let (identifiers, description) = identifiers_provider.provide_with_description().unwrap();
+
+        assert_eq!(identifiers.host_id, CLOUD_ID);
+        assert_eq!(description.cloud_provider, "aws");
+        assert_eq!(description.cloud_region, "us-west-2");
+    }
+
+    #[test]
+    fn test_no_cloud_host_description() {
+        let mut system_detector_mock = MockSystemDetector::new();
+        system_detector_mock.should_detect(system_id());
+        let mut cloud_id_detector_mock = MockCloudDetector::new();
+        cloud_id_detector_mock.should_detect(Resource::new([(
+            Key::from("cloud_type".to_string()),
+            Value::from("no_cloud".to_string()),
+        )]));
+
+        let identifiers_provider = IdentifiersProvider {
+            system_detector: system_detector_mock,
+            cloud_id_detector: cloud_id_detector_mock,
+            host_id: String::new(),
+            fleet_id: String::new(),
+            cloud_id_cache: None,
+        };
+        let (_, description) = identifiers_provider.provide_with_description().unwrap();
+
+        assert!(description.cloud_provider.is_empty());
+        assert!(description.cloud_region.is_empty());
+    }
+
     #[test]
     fn test_empty_host_id_will_error() {
         let mut system_detector_mock = MockSystemDetector::new();
@@ -412,6 +583,7 @@ pub mod tests {
             cloud_id_detector: cloud_id_detector_mock,
             host_id: String::new(),
             fleet_id: String::new(),
+            cloud_id_cache: None,
         };
 
         let err = identifiers_provider
@@ -420,4 +592,94 @@ pub mod tests {
 
         assert_matches!(err, IdentifiersProviderError::MissingHostIDError);
     }
+
+    #[test]
+    fn test_cached_cloud_id_is_used_when_not_detected() {
+        let tmp_dir = tempfile::tempdir().unwrap();
+        let cache = tmp_dir.path().join("cloud_instance_id");
+        let provider = |cloud_id_detector_mock: MockCloudDetector| {
+            let mut system_detector_mock = MockSystemDetector::new();
+            system_detector_mock.should_detect(system_id());
+            IdentifiersProvider {
+                system_detector: system_detector_mock,
+                cloud_id_detector: cloud_id_detector_mock,
+                host_id: String::new(),
+                fleet_id: String::new(),
+                cloud_id_cache: None,
+            }
+            .with_cloud_id_cache(cache.clone())
+        };
+
+        let mut cloud_id_detector_mock = MockCloudDetector::new();
+        cloud_id_detector_mock.should_detect(cloud_id());
+        let identifiers = provider(cloud_id_detector_mock).provide().unwrap();
+        assert_eq!(identifiers.host_id, CLOUD_ID);
+        let cached: CachedCloudId =
+            serde_saphyr::from_str(&std::fs::read_to_string(&cache).unwrap()).unwrap();
+        assert_eq!(cached.cloud_instance_id, CLOUD_ID);
+        assert_eq!(cached.machine_id, MACHINE_ID);
+
+        // The instance metadata endpoint doesn't respond
+        let mut cloud_id_detector_mock = MockCloudDetector::new();
+        cloud_id_detector_mock.should_fail_detection(DetectError::CloudIdError(
+            CloudIdDetectorError::UnsuccessfulCloudIdCheck(),
+        ));
+        let identifiers = provider(cloud_id_detector_mock).provide().unwrap();
+        assert_eq!(identifiers.host_id, CLOUD_ID);
+        assert_eq!(identifiers.cloud_instance_id, CLOUD_ID);
+    }
+
+    #[test]
+    fn test_cached_cloud_id_of_other_machine_is_not_used() {
+        let tmp_dir = tempfile::tempdir().unwrap();
+        let cache = tmp_dir.path().join("cloud_instance_id");
+        // Cached on the host the image of this one was taken from.
+        std::fs::write(
+            &cache,
+            "machine_id: other-machine-id\ncloud_instance_id: other-cloud-id\n",
+        )
+        .unwrap();
+
+        let mut system_detector_mock = MockSystemDetector::new();
+        system_detector_mock.should_detect(system_id());
+        let mut cloud_id_detector_mock = MockCloudDetector::new();
+        cloud_id_detector_mock.should_fail_detection(DetectError::CloudIdError(
+            CloudIdDetectorError::UnsuccessfulCloudIdCheck(),
+        ));
+        let identifiers = IdentifiersProvider {
+            system_detector: system_detector_mock,
+            cloud_id_detector: cloud_id_detector_mock,
+            host_id: String::new(),
+            fleet_id: String::new(),
+            cloud_id_cache: None,
+        }
+        .with_cloud_id_cache(cache)
+        .provide()
+        .unwrap();
+        assert_eq!(identifiers.cloud_instance_id, "");
+        assert_eq!(identifiers.host_id, MACHINE_ID);
+    }
+
+    #[test]
+    fn test_read_only_cloud_id_cache_is_not_written() {
+        let tmp_dir = tempfile::tempdir().unwrap();
+        let cache = tmp_dir.path().join("cloud_instance_id");
+
+        let mut system_detector_mock = MockSystemDetector::new();
+        system_detector_mock.should_detect(system_id());
+        let mut cloud_id_detector_mock = MockCloudDetector::new();
+        cloud_id_detector_mock.should_detect(cloud_id());
+        let identifiers = IdentifiersProvider {
+            system_detector: system_detector_mock,
+            cloud_id_detector: cloud_id_detector_mock,
+            host_id: String::new(),
+            fleet_id: String::new(),
+            cloud_id_cache: None,
+        }
+        .with_read_only_cloud_id_cache(cache.clone())
+        .provide()
+        .unwrap();
+        assert_eq!(identifiers.cloud_instance_id, CLOUD_ID);
+        assert!(!cache.exists());
+    }
 }
@@ -0,0 +1,224 @@
+//! Optional boot-time wait for network availability before the OpAMP client is started.
+//!
+//! On slow-booting hosts the network may not be ready when Agent Control starts, which leads to
+//! noisy connection failures and a premature fallback to the persisted configuration. When
+//! enabled, Agent Control probes the OpAMP endpoint (or the configured proxy) with a TCP connection
+//! until it succeeds or the configured timeout elapses.
+
+use crate::http::config::ProxyConfig;
+use duration_str::deserialize_duration;
+use serde::Deserialize;
+use std::io;
+use std::net::{SocketAddr, TcpStream, ToSocketAddrs};
+use std::thread::sleep;
+use std::time::{Duration, Instant};
+use thiserror::Error;
+use tracing::{debug, info};
+use url::Url;
+use wrapper_with_default::WrapperWithDefault;
+
+const DEFAULT_NETWORK_WAIT_TIMEOUT: Duration = Duration::from_secs(60);
+const DEFAULT_NETWORK_WAIT_INTERVAL: Duration = Duration::from_secs(2);
+
+/// Maximum time to wait for the network before starting the OpAMP client anyway.
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_NETWORK_WAIT_TIMEOUT)]
+pub struct NetworkWaitTimeout(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// Time between network probes, also used as the connection timeout of each probe.
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_NETWORK_WAIT_INTERVAL)]
+pub struct NetworkWaitInterval(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// Configuration of the boot-time network wait. Disabled by default.
+#[derive(Debug, Default, Deserialize, Clone, PartialEq)]
+#[serde(default)]
+pub struct NetworkWaitConfig {
+    /// Whether Agent Control waits for the network before connecting to OpAMP.
+    pub enabled: bool,
+    /// Maximum time to wait.
+    pub timeout: NetworkWaitTimeout,
+    /// Time between probes.
+    pub interval: NetworkWaitInterval,
+}
+
+/// Errors returned while waiting for the network.
+#[derive(Debug, Error)]
+pub enum NetworkWaitError {
+    /// The host and port to probe could not be determined.
+    #[error("invalid network probe target: {0}")]
+    InvalidTarget(String),
+    /// The network was not reachable before the timeout elapsed.
+    #[error("network not reachable after {0:?}: {1}")]
+    Timeout(Duration, String),
+}
+
+/// Blocks until the OpAMP `endpoint` (or the proxy, when configured) accepts TCP connections or
+/// the configured timeout elapses. Returns immediately if the wait is disabled.
+pub fn wait_for_network(
+    config: &NetworkWaitConfig,
+    endpoint: &Url,
+    proxy: &ProxyConfig,
+) -> Result<(), NetworkWaitError> {
+    if !config.enabled {
+        return Ok(());
+    }
+
+    let (host, port) = probe_target(endpoint, proxy)?;
+    let interval = Duration::from(config.interval);
+    info!(%host, port, "Waiting for network availability before connecting to OpAMP");
+
+    wait_until(config.timeout.into(), interval, || {
+        probe(&host, port, interval)
+    })
+    .inspect(|_| debug!(%host, port, "Network is available"))
+}
+
+/// Returns the host and port that need to be reachable to talk to the OpAMP `endpoint`.
+fn probe_target(endpoint: &Url, proxy: &ProxyConfig) -> Result<(String, u16), NetworkWaitError> {
+    if let Some(proxy_url) = proxy.url() {
+        let host = proxy_url.host().ok_or_else(|| {
+            NetworkWaitError::InvalidTarget(format!("proxy url '{proxy_url}' has no host"))
+        })?;
+        let port = proxy_url
+            .port_u16()
+            .unwrap_or(match proxy_url.scheme_str() {
+                Some("https") => 443,
+                _ => 80,
+            });
+        // IPv6 literals are bracketed in URIs but not in socket addresses.
+        let host = host.trim_start_matches('[').trim_end_matches(']');
+        return Ok((host.to_string(), port));
+    }
+
+    let host = match endpoint.host() {
+        Some(url::Host::Ipv6(addr)) => addr.to_string(),
+        Some(host) => host.to_string(),
+        None => {
+            return Err(NetworkWaitError::InvalidTarget(format!(
+                "endpoint '{endpoint}' has no host"
+            )));
+        }
+    };
+    let port = endpoint.port_or_known_default().ok_or_else(|| {
+        NetworkWaitError::InvalidTarget(format!("endpoint '{endpoint}' has no port"))
+    })?;
+    Ok((host, port))
+}
+
+/// Resolves `host` and tries to open a TCP connection to any of its addresses.
+fn probe(host: &str, port: u16, connect_timeout: Duration) -> io::Result<()> {
+    let addrs: Vec<SocketAddr> = (host, port).to_socket_addrs()?.collect();
+    let mut last_err = io::Error::new(
+        io::ErrorKind::NotFound,
+        format!("'{host}' did not resolve to any address"),
+    );
+    for addr in addrs {
+        match TcpStream::connect_timeout(&addr, connect_timeout) {
+            Ok(_) => return Ok(()),
+            Err(err) => last_err = err,
+        }
+    }
+    Err(last_err)
+}
+
+/// Calls `probe` every `interval` until it succeeds or `timeout` elapses.
+fn wait_until<F>(
+    timeout: Duration,
+    interval: Duration,
+    mut probe: F,
+) -> Result<(), NetworkWaitError>
+where
+    F: FnMut() -> io::Result<()>,
+{
+    let deadline = Instant::now() + timeout;
+    loop {
+        match probe() {
+            Ok(()) => return Ok(()),
+            Err(err) if Instant::now() + interval >= deadline => {
+                return Err(NetworkWaitError::Timeout(timeout, err.to_string()));
+            }
+            Err(err) => {
+                debug!("Network not available yet: {err}");
+                sleep(interval);
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use assert_matches::assert_matches;
+    use std::net::TcpListener;
+
+    #[test]
+    fn test_disabled_wait_returns_immediately() {
+        let endpoint = Url::parse("https://unreachable.invalid/v1/opamp").unwrap();
+        assert!(
+            wait_for_network(
+                &NetworkWaitConfig::default(),
+                &endpoint,
+                &ProxyConfig::default()
+            )
+            .is_ok()
+        );
+    }
+
+    #[test]
+    fn test_wait_for_reachable_endpoint() {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let port = listener.local_addr().unwrap().port();
+        let endpoint = Url::parse(&format!("http://127.0.0.1:{port}/v1/opamp")).unwrap();
+        let config = NetworkWaitConfig {
+            enabled: true,
+            ..Default::default()
+        };
+
+        assert!(wait_for_network(&config, &endpoint, &ProxyConfig::default()).is_ok());
+    }
+
+    #[test]
+    fn test_probe_target_from_endpoint() {
+        let proxy = ProxyConfig::default();
+
+        let endpoint = Url::parse("https://opamp.service.newrelic.com/v1/opamp").unwrap();
+        assert_eq!(
+            probe_target(&endpoint, &proxy).unwrap(),
+            ("opamp.service.newrelic.com".to_string(), 443)
+        );
+
+        let endpoint = Url::parse("http://[::1]:8080/v1/opamp").unwrap();
+        assert_eq!(
+            probe_target(&endpoint, &proxy).unwrap(),
+            ("::1".to_string(), 8080)
+        );
+    }
+
+    #[test]
+    fn test_wait_until_retries_until_success() {
+        let mut attempts = 0;
+        let result = wait_until(Duration::from_secs(1), Duration::from_millis(1), || {
+            attempts += 1;
+            if attempts < 3 {
+                Err(io::Error::other("not yet"))
+            } else {
+                Ok(())
+            }
+        });
+
+        assert!(result.is_ok());
+        assert_eq!(attempts, 3);
+    }
+
+    #[test]
+    fn test_wait_until_times_out() {
+        let result = wait_until(Duration::from_millis(20), Duration::from_millis(5), || {
+            Err(io::Error::other("unreachable"))
+        });
+
+        assert_matches!(result, Err(NetworkWaitError::Timeout(_, msg)) => {
+            assert_eq!(msg, "unreachable");
+        });
+    }
+}
@@ -1,6 +1,7 @@
 //! OpAMP operations: assembling start settings and agent descriptions, and stopping the client.
 use super::{client_builder::OpAMPClientBuilderError, instance_id::getter::InstanceIDGetter};
 use crate::agent_control::defaults::{
+    INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY, INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE,
     OPAMP_SERVICE_NAME, OPAMP_SERVICE_NAMESPACE, OPAMP_SUPERVISOR_KEY,
     PARENT_AGENT_ID_ATTRIBUTE_KEY, default_capabilities, default_custom_capabilities,
 };
@@ -14,7 +15,7 @@ use std::collections::HashMap;
 use tracing::info;
 
 /// Builds the OpAMP [`StartSettings`] for a sub-agent, injecting the parent agent control instance
-/// id as a non-identifying attribute.
+/// id and the instrumentation provider as non-identifying attributes.
 pub fn sub_agent_start_settings<IG: InstanceIDGetter>(
     instance_id_getter: &IG,
     agent_identity: &AgentIdentity,
@@ -28,6 +29,10 @@ pub fn sub_agent_start_settings<IG: InstanceIDGetter>(
         PARENT_AGENT_ID_ATTRIBUTE_KEY.to_string(),
         DescriptionValueType::Bytes(parent_instance_id.into()),
     );
+    non_identifying_attributes.insert(
+        INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY.to_string(),
+        INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE.to_string().into(),
+    );
 
     Ok(StartSettings {
         instance_uid: instance_id_getter.get(&agent_identity.id)?.into(),
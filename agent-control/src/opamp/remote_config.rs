@@ -23,6 +23,10 @@ pub const AGENT_CONFIG_PREFIX: &str = "agentConfig";
 /// for details.
 pub const AGENT_CONFIG_OVERRIDE_PREFIX: &str = "override.agentConfig";
 
+/// Key of the requirements an agent configuration has on the agent, like the minimum version it needs.
+/// See [VersionRequirementsValidator](crate::opamp::remote_config::validators::version_requirements::VersionRequirementsValidator).
+pub const AGENT_CONFIG_REQUIREMENTS_KEY: &str = "requirements.agentConfig";
+
 /// This structure represents the remote configuration that we would retrieve from a server via OpAMP.
 /// Contains identifying metadata and the actual configuration values
 #[derive(Debug, PartialEq, Clone)]
@@ -116,6 +120,11 @@ impl OpampRemoteConfig {
         Ok(override_config)
     }
 
+    /// Returns the requirements of the configuration, identified by [AGENT_CONFIG_REQUIREMENTS_KEY], if any.
+    pub fn agent_config_requirements(&self) -> Option<&String> {
+        self.config_map.0.get(AGENT_CONFIG_REQUIREMENTS_KEY)
+    }
+
     /// Get the signature data for a config key
     pub fn signature(&self, config_name: &str) -> Result<SignatureData, OpampRemoteConfigError> {
         let Some(signatures) = &self.signatures else {
@@ -3,6 +3,7 @@ use crate::opamp::remote_config::Hash;
 use opamp_client::opamp::proto::RemoteConfigStatus;
 use opamp_client::opamp::proto::RemoteConfigStatuses;
 use opamp_client::{ClientError, StartedClient};
+use tracing::trace;
 
 use crate::opamp::remote_config::hash::ConfigState;
 
@@ -12,6 +13,11 @@ pub fn report_state<C: StartedClient>(
     hash: Hash,
     opamp_client: &C,
 ) -> Result<(), ClientError> {
+    match &state {
+        ConfigState::Applying => {}
+        ConfigState::Applied => trace!(monotonic_counter.remote_configs_applied = 1u64),
+        ConfigState::Failed { .. } => trace!(monotonic_counter.remote_configs_failed = 1u64),
+    }
     opamp_client.set_remote_config_status(RemoteConfigStatus {
         last_remote_config_hash: hash.to_string().into_bytes(),
         status: RemoteConfigStatuses::from(state.clone()) as i32,
@@ -28,3 +34,35 @@ impl From<ConfigState> for RemoteConfigStatuses {
         }
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::opamp::client_builder::tests::MockStartedOpAMPClient;
+    use tracing_test::traced_test;
+
+    #[traced_test]
+    #[test]
+    fn test_report_state_counts_final_states() {
+        let mut opamp_client = MockStartedOpAMPClient::new();
+        opamp_client
+            .expect_set_remote_config_status()
+            .times(3)
+            .returning(|_| Ok(()));
+
+        let hash = Hash::from("hash");
+        report_state(ConfigState::Applying, hash.clone(), &opamp_client).unwrap();
+        report_state(ConfigState::Applied, hash.clone(), &opamp_client).unwrap();
+        report_state(
+            ConfigState::Failed {
+                error_message: "some error".to_string(),
+            },
+            hash,
+            &opamp_client,
+        )
+        .unwrap();
+
+        assert!(logs_contain("monotonic_counter.remote_configs_applied=1"));
+        assert!(logs_contain("monotonic_counter.remote_configs_failed=1"));
+    }
+}
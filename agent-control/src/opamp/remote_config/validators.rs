@@ -1,13 +1,20 @@
-//! Remote configuration validators (signature and regex) and their static-dispatch enum.
+//! Remote configuration validators (signature, regex, OpenTelemetry components, Fluent Bit plugins
+//! and version requirements) and their static-dispatch enum.
+pub mod fluent_bit;
+pub mod otel_components;
 pub mod regexes;
 pub mod signature;
+pub mod version_requirements;
 
 use super::OpampRemoteConfig;
 use crate::sub_agent::identity::AgentIdentity;
+use fluent_bit::FluentBitValidator;
+use otel_components::OtelComponentsValidator;
 use regexes::RegexValidator;
 use signature::validator::SignatureValidator;
 use std::{fmt::Display, sync::Arc};
 use thiserror::Error;
+use version_requirements::VersionRequirementsValidator;
 
 /// Represents a validator for config remote
 pub trait RemoteConfigValidator {
@@ -33,6 +40,12 @@ pub enum SupportedRemoteConfigValidator {
     Signature(Arc<SignatureValidator>),
     /// Validates remote config content against denied-pattern regexes.
     Regex(RegexValidator),
+    /// Validates the OpenTelemetry collector components against the local policy.
+    OtelComponents(OtelComponentsValidator),
+    /// Validates the Fluent Bit plugins and files against the local policy.
+    FluentBit(FluentBitValidator),
+    /// Validates the agent version against the requirements of the remote config.
+    VersionRequirements(VersionRequirementsValidator),
 }
 
 impl RemoteConfigValidator for SupportedRemoteConfigValidator {
@@ -49,6 +62,15 @@ impl RemoteConfigValidator for SupportedRemoteConfigValidator {
             Self::Regex(r) => r
                 .validate(agent_identity, opamp_remote_config)
                 .map_err(|e| SupportedRemoteConfigValidatorError(e.to_string())),
+            Self::OtelComponents(o) => o
+                .validate(agent_identity, opamp_remote_config)
+                .map_err(|e| SupportedRemoteConfigValidatorError(e.to_string())),
+            Self::FluentBit(f) => f
+                .validate(agent_identity, opamp_remote_config)
+                .map_err(|e| SupportedRemoteConfigValidatorError(e.to_string())),
+            Self::VersionRequirements(v) => v
+                .validate(agent_identity, opamp_remote_config)
+                .map_err(|e| SupportedRemoteConfigValidatorError(e.to_string())),
         }
     }
 }
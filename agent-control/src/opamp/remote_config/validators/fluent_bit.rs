@@ -0,0 +1,376 @@
+//! Validator that rejects remote configs of on-host Fluent Bit agents using plugins or files
+//! outside the locally configured policy.
+//!
+//! The pipeline, parsers and include files of the `io.fluentbit` agent type are written as
+//! received, so without it a remote config could run commands through the `exec` input, run code
+//! through the `lua` filter or read host files with `@INCLUDE /etc/...`.
+use super::RemoteConfigValidator;
+use crate::agent_control::defaults::AGENT_TYPE_NAME_FLUENT_BIT;
+use crate::opamp::remote_config::OpampRemoteConfig;
+use crate::sub_agent::identity::AgentIdentity;
+use serde::Deserialize;
+use serde_json::Value;
+use std::collections::{BTreeMap, BTreeSet};
+use std::fmt::Display;
+use std::path::{Component, Path};
+use thiserror::Error;
+
+/// Errors produced by the Fluent Bit plugins validator.
+#[derive(Error, Debug)]
+pub enum FluentBitValidatorError {
+    /// The config uses a plugin the policy doesn't allow.
+    #[error("invalid config: {kind} plugin '{plugin}' is not allowed")]
+    NotAllowed {
+        /// Kind of the plugin.
+        kind: FluentBitPluginKind,
+        /// Name of the plugin.
+        plugin: String,
+    },
+    /// The config uses variables where they could hide a plugin, like in plugin names.
+    #[error("invalid config: variables are not allowed in '{0}'")]
+    VariableNotAllowed(String),
+    /// The config references a file outside the agent directory.
+    #[error("invalid config: '{0}' must be a relative path inside the agent directory")]
+    FileNotAllowed(String),
+    /// The config values can't be checked.
+    #[error("invalid config: {0}")]
+    Unparseable(String),
+}
+
+/// Kinds of plugins of a Fluent Bit configuration, named after their sections.
+#[derive(Debug, Deserialize, PartialEq, Eq, PartialOrd, Ord, Clone, Copy)]
+#[serde(rename_all = "lowercase")]
+pub enum FluentBitPluginKind {
+    /// `[INPUT]` plugins, collecting logs.
+    Inputs,
+    /// `[FILTER]` plugins, processing logs.
+    Filters,
+    /// `[OUTPUT]` plugins, sending logs.
+    Outputs,
+}
+
+impl FluentBitPluginKind {
+    fn from_section(section: &str) -> Option<Self> {
+        match section {
+            "INPUT" => Some(Self::Inputs),
+            "FILTER" => Some(Self::Filters),
+            "OUTPUT" => Some(Self::Outputs),
+            _ => None,
+        }
+    }
+}
+
+impl Display for FluentBitPluginKind {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(match self {
+            Self::Inputs => "input",
+            Self::Filters => "filter",
+            Self::Outputs => "output",
+        })
+    }
+}
+
+/// Plugin names, e.g. `tail` or `exec`, by kind.
+pub type FluentBitPlugins = BTreeMap<FluentBitPluginKind, BTreeSet<String>>;
+
+/// Policy of the Fluent Bit plugins remote configs can use.
+///
+/// ```yaml
+/// fluent_bit_plugins:
+///   allowed:
+///     outputs: [nrlogs]
+///   denied:
+///     inputs: [exec, exec_wasi]
+/// ```
+///
+/// A kind listed in `allowed` is restricted to the listed plugins, the rest of the kinds are not.
+/// Plugins listed in `denied` are rejected in any case. By default, the plugins running commands or
+/// code (`exec` and `exec_wasi` inputs, `lua` and `wasm` filters) and the `file` output are denied.
+#[derive(Debug, Deserialize, PartialEq, Clone)]
+#[serde(default)]
+pub struct FluentBitPluginsPolicy {
+    /// Only plugins that remote configs can use, by kind.
+    pub allowed: FluentBitPlugins,
+    /// Plugins that remote configs cannot use, by kind.
+    pub denied: FluentBitPlugins,
+}
+
+impl Default for FluentBitPluginsPolicy {
+    fn default() -> Self {
+        let plugins = |names: &[&str]| names.iter().map(ToString::to_string).collect();
+        Self {
+            allowed: FluentBitPlugins::default(),
+            denied: FluentBitPlugins::from([
+                (FluentBitPluginKind::Inputs, plugins(&["exec", "exec_wasi"])),
+                (FluentBitPluginKind::Filters, plugins(&["lua", "wasm"])),
+                (FluentBitPluginKind::Outputs, plugins(&["file"])),
+            ]),
+        }
+    }
+}
+
+impl FluentBitPluginsPolicy {
+    fn allows(&self, kind: FluentBitPluginKind, plugin: &str) -> bool {
+        // Plugin names are case-insensitive.
+        let plugin = plugin.to_ascii_lowercase();
+        let allowed = self
+            .allowed
+            .get(&kind)
+            .is_none_or(|plugins| plugins.contains(&plugin));
+        let denied = self
+            .denied
+            .get(&kind)
+            .is_some_and(|plugins| plugins.contains(&plugin));
+        allowed && !denied
+    }
+}
+
+/// Values of the on-host Fluent Bit agent type holding configuration files.
+#[derive(Debug, Default, Deserialize)]
+struct FluentBitValues {
+    #[serde(default)]
+    config: String,
+    #[serde(default)]
+    parsers: String,
+    #[serde(default)]
+    includes: BTreeMap<String, Value>,
+}
+
+/// Checks the plugins and the files referenced in the Fluent Bit configuration files of the remote
+/// configs against a [FluentBitPluginsPolicy]. The files Fluent Bit loads, through `@INCLUDE` or
+/// `[SERVICE]` properties like `Plugins_File`, can only be referenced with relative paths that don't
+/// leave the agent directory. Plugin names can't use variables, and `@SET` is rejected, as they
+/// could resolve to any plugin. Remote configs that can't be checked are rejected.
+pub struct FluentBitValidator {
+    policy: FluentBitPluginsPolicy,
+}
+
+impl FluentBitValidator {
+    /// Returns a validator enforcing the given policy.
+    pub fn new(policy: FluentBitPluginsPolicy) -> Self {
+        Self { policy }
+    }
+
+    fn validate_values(&self, raw_config: &str) -> Result<(), FluentBitValidatorError> {
+        let values = serde_saphyr::from_str::<Option<FluentBitValues>>(raw_config)
+            .map_err(|err| FluentBitValidatorError::Unparseable(err.to_string()))?
+            .unwrap_or_default();
+
+        self.validate_file(&values.config)?;
+        self.validate_file(&values.parsers)?;
+        values.includes.iter().try_for_each(|(name, content)| {
+            let Value::String(content) = content else {
+                return Err(FluentBitValidatorError::Unparseable(format!(
+                    "include file '{name}' must be a string"
+                )));
+            };
+            self.validate_file(content)
+        })
+    }
+
+    /// Checks a file in the Fluent Bit classic configuration format.
+    fn validate_file(&self, content: &str) -> Result<(), FluentBitValidatorError> {
+        let mut section = String::new();
+        for line in content.lines().map(str::trim) {
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            if let Some(name) = line.strip_prefix('[').and_then(|l| l.strip_suffix(']')) {
+                section = name.trim().to_ascii_uppercase();
+                continue;
+            }
+            let (key, value) = line
+                .split_once(char::is_whitespace)
+                .map_or((line, ""), |(key, value)| (key, value.trim()));
+            let key = key.to_ascii_lowercase();
+
+            // Variables could expand to plugin names the policy doesn't allow.
+            if key == "@set" {
+                return Err(FluentBitValidatorError::VariableNotAllowed(
+                    line.to_string(),
+                ));
+            }
+
+            // Files loaded by Fluent Bit itself, rather than by the plugins.
+            if key == "@include" || (section == "SERVICE" && key.ends_with("_file")) {
+                validate_path(value)?;
+                continue;
+            }
+            let Some(kind) = FluentBitPluginKind::from_section(&section) else {
+                continue;
+            };
+            if key == "name" && value.contains("${") {
+                return Err(FluentBitValidatorError::VariableNotAllowed(
+                    line.to_string(),
+                ));
+            }
+            if key == "name" && !self.policy.allows(kind, value) {
+                return Err(FluentBitValidatorError::NotAllowed {
+                    kind,
+                    plugin: value.to_string(),
+                });
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Fluent Bit resolves relative paths from the directory of the main configuration file, the
+/// agent directory.
+fn validate_path(path: &str) -> Result<(), FluentBitValidatorError> {
+    let inside_agent_dir = Path::new(path)
+        .components()
+        .all(|component| matches!(component, Component::Normal(_) | Component::CurDir));
+    // Environment variables could expand to any path.
+    if path.is_empty() || !inside_agent_dir || path.contains("${") {
+        return Err(FluentBitValidatorError::FileNotAllowed(path.to_string()));
+    }
+    Ok(())
+}
+
+impl RemoteConfigValidator for FluentBitValidator {
+    type Err = FluentBitValidatorError;
+    fn validate(
+        &self,
+        agent_identity: &AgentIdentity,
+        opamp_remote_config: &OpampRemoteConfig,
+    ) -> Result<(), FluentBitValidatorError> {
+        if agent_identity.agent_type_id.name() != AGENT_TYPE_NAME_FLUENT_BIT {
+            return Ok(());
+        }
+
+        opamp_remote_config
+            .agent_configs_iter()
+            .try_for_each(|(_, raw_config)| self.validate_values(raw_config))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_control::agent_id::AgentID;
+    use crate::agent_control::defaults::AGENT_TYPE_NAME_OTEL_COLLECTOR;
+    use crate::agent_type::agent_type_id::AgentTypeID;
+    use crate::opamp::remote_config::AGENT_CONFIG_PREFIX;
+    use crate::opamp::remote_config::ConfigurationMap;
+    use crate::opamp::remote_config::hash::{ConfigState, Hash};
+    use rstest::rstest;
+    use std::collections::HashMap;
+
+    fn validate(
+        policy: FluentBitPluginsPolicy,
+        agent_type_name: &str,
+        content: &str,
+    ) -> Result<(), FluentBitValidatorError> {
+        let agent_identity = AgentIdentity {
+            id: AgentID::try_from("test").unwrap(),
+            agent_type_id: AgentTypeID::try_from(
+                format!("newrelic/{agent_type_name}:0.1.0").as_str(),
+            )
+            .unwrap(),
+        };
+        let remote_config = OpampRemoteConfig::new(
+            agent_identity.id.clone(),
+            Hash::from("hash"),
+            ConfigState::Applying,
+            ConfigurationMap::new(HashMap::from([(
+                AGENT_CONFIG_PREFIX.to_string(),
+                content.to_string(),
+            )])),
+        );
+        FluentBitValidator::new(policy).validate(&agent_identity, &remote_config)
+    }
+
+    #[rstest]
+    #[case::allowed_plugins(
+        "config: |\n  [INPUT]\n      Name tail\n      Path /var/log/*.log\n  [OUTPUT]\n      Name nrlogs\n      Match *",
+        None
+    )]
+    #[case::relative_include(
+        "config: '@INCLUDE include/inputs.conf'\nincludes:\n  inputs.conf: \"[INPUT]\\n    Name tail\"",
+        None
+    )]
+    #[case::exec_input(
+        "config: |\n  [INPUT]\n      Name exec\n      Command id",
+        Some("input plugin 'exec' is not allowed")
+    )]
+    #[case::case_insensitive(
+        "config: |\n  [input]\n      NAME Exec_WASI",
+        Some("input plugin 'Exec_WASI' is not allowed")
+    )]
+    #[case::lua_filter_in_include(
+        "includes:\n  filters.conf: |\n    [FILTER]\n        Name lua\n        code function f() end",
+        Some("filter plugin 'lua' is not allowed")
+    )]
+    #[case::file_output(
+        "config: |\n  [OUTPUT]\n      Name file\n      Path /etc",
+        Some("output plugin 'file' is not allowed")
+    )]
+    #[case::absolute_include(
+        "config: '@INCLUDE /etc/fluent-bit/other.conf'",
+        Some("'/etc/fluent-bit/other.conf' must be a relative path inside the agent directory")
+    )]
+    #[case::parent_include(
+        "config: '@INCLUDE include/../../other.conf'",
+        Some("'include/../../other.conf' must be a relative path inside the agent directory")
+    )]
+    #[case::env_include(
+        "config: '@INCLUDE ${HOME}/other.conf'",
+        Some("'${HOME}/other.conf' must be a relative path inside the agent directory")
+    )]
+    #[case::absolute_plugins_file(
+        "config: |\n  [SERVICE]\n      Plugins_File /tmp/plugins.conf",
+        Some("'/tmp/plugins.conf' must be a relative path inside the agent directory")
+    )]
+    #[case::plugin_file_property(
+        "config: |\n  [OUTPUT]\n      Name nrlogs\n      tls.ca_file /etc/ssl/certs/ca.pem",
+        None
+    )]
+    #[case::non_string_include(
+        "includes:\n  inputs.conf:\n    key: value",
+        Some("include file 'inputs.conf' must be a string")
+    )]
+    #[case::set_variable_name(
+        "config: |\n  @SET p=exec\n  [INPUT]\n      Name ${p}\n      Command id",
+        Some("variables are not allowed in '@SET p=exec'")
+    )]
+    #[case::env_variable_name(
+        "config: |\n  [INPUT]\n      Name ${SOME_ENV}\n      Command id",
+        Some("variables are not allowed in 'Name ${SOME_ENV}'")
+    )]
+    #[case::set_in_include(
+        "includes:\n  vars.conf: '@set p=exec'",
+        Some("variables are not allowed in '@set p=exec'")
+    )]
+    #[case::unparseable("config: [not, a, string", Some(""))]
+    fn test_validate(#[case] content: &str, #[case] rejected: Option<&str>) {
+        let result = validate(
+            FluentBitPluginsPolicy::default(),
+            AGENT_TYPE_NAME_FLUENT_BIT,
+            content,
+        );
+        match rejected {
+            None => result.unwrap(),
+            Some(reason) => assert!(
+                result.as_ref().unwrap_err().to_string().contains(reason),
+                "{result:?}"
+            ),
+        }
+    }
+
+    #[test]
+    fn test_validate_policy() {
+        let policy: FluentBitPluginsPolicy =
+            serde_saphyr::from_str("allowed: {outputs: [nrlogs]}").unwrap();
+        assert!(policy.denied.is_empty());
+
+        let config = "config: |\n  [OUTPUT]\n      Name http";
+        assert_eq!(
+            validate(policy.clone(), AGENT_TYPE_NAME_FLUENT_BIT, config)
+                .unwrap_err()
+                .to_string(),
+            "invalid config: output plugin 'http' is not allowed"
+        );
+        validate(policy, AGENT_TYPE_NAME_OTEL_COLLECTOR, config).unwrap();
+    }
+}
@@ -0,0 +1,379 @@
+//! Validator that rejects remote configs of on-host OpenTelemetry collectors enabling components
+//! outside the locally configured policy.
+//!
+//! It protects hosts from remote configurations that could be used to exfiltrate data, e.g. a
+//! `file` exporter writing telemetry to an arbitrary path.
+use super::RemoteConfigValidator;
+use crate::agent_control::defaults::{AGENT_TYPE_NAME_NRDOT, AGENT_TYPE_NAME_OTEL_COLLECTOR};
+use crate::agent_type::templates::template_re;
+use crate::opamp::remote_config::OpampRemoteConfig;
+use crate::sub_agent::identity::AgentIdentity;
+use serde::Deserialize;
+use serde_json::Value;
+use std::collections::{BTreeMap, BTreeSet};
+use std::fmt::Display;
+use thiserror::Error;
+
+/// Errors produced by the OpenTelemetry components validator.
+#[derive(Error, Debug)]
+pub enum OtelComponentsValidatorError {
+    /// The config enables a component the policy doesn't allow.
+    #[error("invalid config: {kind} '{component}' is not allowed")]
+    NotAllowed {
+        /// Kind of the component.
+        kind: OtelComponentKind,
+        /// Id of the component in the collector config.
+        component: String,
+    },
+    /// The config uses a collector config provider, e.g. `${file:/etc/...}`, which could load
+    /// components the policy can't check.
+    #[error("invalid config: config provider reference '{0}' is not allowed")]
+    ProviderReference(String),
+    /// The config can't be checked against the policy.
+    #[error("invalid config: {0}")]
+    Unparseable(String),
+}
+
+/// Kinds of components of an OpenTelemetry collector configuration.
+#[derive(Debug, Deserialize, PartialEq, Eq, PartialOrd, Ord, Clone, Copy)]
+#[serde(rename_all = "lowercase")]
+pub enum OtelComponentKind {
+    /// Components receiving telemetry.
+    Receivers,
+    /// Components processing telemetry.
+    Processors,
+    /// Components sending telemetry.
+    Exporters,
+    /// Components adding capabilities to the collector.
+    Extensions,
+    /// Components connecting pipelines.
+    Connectors,
+}
+
+impl OtelComponentKind {
+    /// Section of the collector configuration defining the components of this kind.
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Receivers => "receivers",
+            Self::Processors => "processors",
+            Self::Exporters => "exporters",
+            Self::Extensions => "extensions",
+            Self::Connectors => "connectors",
+        }
+    }
+
+    const ALL: [Self; 5] = [
+        Self::Receivers,
+        Self::Processors,
+        Self::Exporters,
+        Self::Extensions,
+        Self::Connectors,
+    ];
+}
+
+impl Display for OtelComponentKind {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        // Singular, as used in the error messages.
+        f.write_str(self.as_str().trim_end_matches('s'))
+    }
+}
+
+/// Component types, e.g. `otlp` or `file`, by kind.
+pub type OtelComponentTypes = BTreeMap<OtelComponentKind, BTreeSet<String>>;
+
+/// Policy of the OpenTelemetry collector components remote configs can enable.
+///
+/// ```yaml
+/// otel_components:
+///   allowed:
+///     exporters: [otlp, otlphttp]
+///   denied:
+///     receivers: [filelog]
+/// ```
+///
+/// A kind listed in `allowed` is restricted to the listed types, the rest of the kinds are not.
+/// Types listed in `denied` are rejected in any case. Components are matched by type, so
+/// `otlphttp/eu` is an `otlphttp` exporter.
+#[derive(Debug, Default, Deserialize, PartialEq, Clone)]
+#[serde(default)]
+pub struct OtelComponentsPolicy {
+    /// Only types that remote configs can enable, by kind.
+    pub allowed: OtelComponentTypes,
+    /// Types that remote configs cannot enable, by kind.
+    pub denied: OtelComponentTypes,
+}
+
+impl OtelComponentsPolicy {
+    fn is_empty(&self) -> bool {
+        self.allowed.is_empty() && self.denied.is_empty()
+    }
+
+    fn allows(&self, kind: OtelComponentKind, component_type: &str) -> bool {
+        let allowed = self
+            .allowed
+            .get(&kind)
+            .is_none_or(|types| types.contains(component_type));
+        let denied = self
+            .denied
+            .get(&kind)
+            .is_some_and(|types| types.contains(component_type));
+        allowed && !denied
+    }
+}
+
+/// Values of the on-host collector agent types. The collector config is in the `config` variable.
+#[derive(Debug, Default, Deserialize)]
+struct CollectorValues {
+    #[serde(default)]
+    config: Value,
+}
+
+/// Checks the components defined in the collector config of the remote configs against an
+/// [OtelComponentsPolicy]. Since the policy could be bypassed otherwise, remote configs that can't
+/// be parsed, whose component sections aren't mappings or that use collector config providers
+/// (`${file:...}`, `${env:...}`, `${https://...}`...) are rejected. Agent Control placeholders like
+/// `${nr-vault:...}` are allowed, they are replaced before the config reaches the collector.
+pub struct OtelComponentsValidator {
+    policy: OtelComponentsPolicy,
+}
+
+impl OtelComponentsValidator {
+    /// Returns a validator enforcing the given policy.
+    pub fn new(policy: OtelComponentsPolicy) -> Self {
+        Self { policy }
+    }
+
+    fn validate_components(&self, raw_config: &str) -> Result<(), OtelComponentsValidatorError> {
+        let values = serde_saphyr::from_str::<Option<CollectorValues>>(raw_config)
+            .map_err(|err| OtelComponentsValidatorError::Unparseable(err.to_string()))?
+            .unwrap_or_default();
+        validate_no_provider_references(&values.config)?;
+        let config = match &values.config {
+            Value::Object(config) => config,
+            Value::Null => return Ok(()),
+            _ => {
+                return Err(OtelComponentsValidatorError::Unparseable(
+                    "the collector config must be a mapping".to_string(),
+                ));
+            }
+        };
+
+        for kind in OtelComponentKind::ALL {
+            let components = match config.get(kind.as_str()) {
+                Some(Value::Object(components)) => components,
+                None | Some(Value::Null) => continue,
+                Some(_) => {
+                    return Err(OtelComponentsValidatorError::Unparseable(format!(
+                        "'{}' must be a mapping",
+                        kind.as_str()
+                    )));
+                }
+            };
+            if let Some(component) = components.keys().find(|id| {
+                let component_type = id.split_once('/').map_or(id.as_str(), |(t, _)| t);
+                !self.policy.allows(kind, component_type)
+            }) {
+                return Err(OtelComponentsValidatorError::NotAllowed {
+                    kind,
+                    component: component.to_string(),
+                });
+            }
+        }
+
+        Ok(())
+    }
+}
+
+/// Looks for collector config provider references in the keys and values of the config.
+fn validate_no_provider_references(value: &Value) -> Result<(), OtelComponentsValidatorError> {
+    let validate_str = |s: &str| {
+        if template_re().replace_all(s, "").contains("${") {
+            return Err(OtelComponentsValidatorError::ProviderReference(
+                s.to_string(),
+            ));
+        }
+        Ok(())
+    };
+    match value {
+        Value::String(s) => validate_str(s),
+        Value::Array(values) => values.iter().try_for_each(validate_no_provider_references),
+        Value::Object(map) => map.iter().try_for_each(|(key, value)| {
+            validate_str(key)?;
+            validate_no_provider_references(value)
+        }),
+        _ => Ok(()),
+    }
+}
+
+impl RemoteConfigValidator for OtelComponentsValidator {
+    type Err = OtelComponentsValidatorError;
+    fn validate(
+        &self,
+        agent_identity: &AgentIdentity,
+        opamp_remote_config: &OpampRemoteConfig,
+    ) -> Result<(), OtelComponentsValidatorError> {
+        let agent_type_name = agent_identity.agent_type_id.name();
+        if self.policy.is_empty()
+            || ![AGENT_TYPE_NAME_NRDOT, AGENT_TYPE_NAME_OTEL_COLLECTOR].contains(&agent_type_name)
+        {
+            return Ok(());
+        }
+
+        opamp_remote_config
+            .agent_configs_iter()
+            .try_for_each(|(_, raw_config)| self.validate_components(raw_config))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_control::agent_id::AgentID;
+    use crate::agent_control::defaults::AGENT_TYPE_NAME_INFRA_AGENT;
+    use crate::agent_type::agent_type_id::AgentTypeID;
+    use crate::opamp::remote_config::AGENT_CONFIG_PREFIX;
+    use crate::opamp::remote_config::ConfigurationMap;
+    use crate::opamp::remote_config::hash::{ConfigState, Hash};
+    use rstest::rstest;
+    use std::collections::HashMap;
+
+    const COLLECTOR_CONFIG: &str = r#"
+config:
+  extensions:
+    health_check:
+  receivers:
+    otlp:
+      protocols:
+        grpc:
+  exporters:
+    otlphttp/eu:
+      endpoint: https://otlp.eu01.nr-data.net
+    file:
+      path: /tmp/telemetry.json
+  service:
+    extensions: [health_check]
+    pipelines:
+      traces:
+        receivers: [otlp]
+        exporters: [otlphttp/eu, file]
+"#;
+
+    fn policy(yaml: &str) -> OtelComponentsPolicy {
+        serde_saphyr::from_str(yaml).unwrap()
+    }
+
+    fn validate(
+        policy: OtelComponentsPolicy,
+        agent_type_name: &str,
+        content: &str,
+    ) -> Result<(), OtelComponentsValidatorError> {
+        let agent_identity = AgentIdentity {
+            id: AgentID::try_from("test").unwrap(),
+            agent_type_id: AgentTypeID::try_from(
+                format!("newrelic/{agent_type_name}:0.0.1").as_str(),
+            )
+            .unwrap(),
+        };
+        let remote_config = OpampRemoteConfig::new(
+            agent_identity.id.clone(),
+            Hash::from("hash"),
+            ConfigState::Applying,
+            ConfigurationMap::new(HashMap::from([(
+                AGENT_CONFIG_PREFIX.to_string(),
+                content.to_string(),
+            )])),
+        );
+        OtelComponentsValidator::new(policy).validate(&agent_identity, &remote_config)
+    }
+
+    #[rstest]
+    #[case::empty_policy("{}", None)]
+    #[case::denied_exporter("denied: {exporters: [file]}", Some("exporter 'file'"))]
+    #[case::denied_other_type("denied: {exporters: [debug]}", None)]
+    #[case::allowed_exporters("allowed: {exporters: [otlphttp, file]}", None)]
+    #[case::named_component_not_allowed(
+        "allowed: {exporters: [file]}",
+        Some("exporter 'otlphttp/eu'")
+    )]
+    #[case::extension_not_allowed("allowed: {extensions: []}", Some("extension 'health_check'"))]
+    #[case::denied_over_allowed(
+        "{allowed: {exporters: [otlphttp, file]}, denied: {exporters: [file]}}",
+        Some("exporter 'file'")
+    )]
+    fn test_validate_components(#[case] policy_yaml: &str, #[case] rejected: Option<&str>) {
+        let result = validate(policy(policy_yaml), AGENT_TYPE_NAME_NRDOT, COLLECTOR_CONFIG);
+        match rejected {
+            None => result.unwrap(),
+            Some(component) => assert_eq!(
+                result.unwrap_err().to_string(),
+                format!("invalid config: {component} is not allowed")
+            ),
+        }
+    }
+
+    #[rstest]
+    #[case::upstream_collector(AGENT_TYPE_NAME_OTEL_COLLECTOR, false)]
+    #[case::other_agent_type(AGENT_TYPE_NAME_INFRA_AGENT, true)]
+    fn test_validate_agent_types(#[case] agent_type_name: &str, #[case] valid: bool) {
+        let result = validate(
+            policy("denied: {exporters: [file]}"),
+            agent_type_name,
+            COLLECTOR_CONFIG,
+        );
+        assert_eq!(result.is_ok(), valid);
+    }
+
+    #[rstest]
+    #[case::unparseable("config: [not, a, map", Some(""))]
+    #[case::config_not_a_mapping("config: [otlp]", Some("the collector config must be a mapping"))]
+    #[case::config_from_provider(
+        "config: ${file:/etc/otelcol/config.yaml}",
+        Some("config provider reference '${file:/etc/otelcol/config.yaml}' is not allowed")
+    )]
+    #[case::section_not_a_mapping(
+        "config:\n  exporters: [file]",
+        Some("'exporters' must be a mapping")
+    )]
+    #[case::section_from_provider(
+        "config:\n  exporters: ${https://example.com/exporters.yaml}",
+        Some("config provider reference '${https://example.com/exporters.yaml}' is not allowed")
+    )]
+    #[case::provider_in_component(
+        "config:\n  exporters:\n    otlphttp:\n      endpoint: ${env:ENDPOINT}",
+        Some("config provider reference '${env:ENDPOINT}' is not allowed")
+    )]
+    #[case::provider_in_service(
+        "config:\n  service:\n    pipelines: ${file:/tmp/pipelines.yaml}",
+        Some("config provider reference '${file:/tmp/pipelines.yaml}' is not allowed")
+    )]
+    #[case::ac_placeholder(
+        "config:\n  exporters:\n    otlphttp:\n      headers:\n        api-key: ${nr-vault:source:path}",
+        None
+    )]
+    #[case::no_config("backoff_delay: 30s", None)]
+    #[case::empty("", None)]
+    fn test_validate_config_bypasses(#[case] content: &str, #[case] rejected: Option<&str>) {
+        let result = validate(
+            policy("denied: {exporters: [file]}"),
+            AGENT_TYPE_NAME_NRDOT,
+            content,
+        );
+        match rejected {
+            None => result.unwrap(),
+            Some(reason) => assert!(
+                result.as_ref().unwrap_err().to_string().contains(reason),
+                "{result:?}"
+            ),
+        }
+    }
+
+    #[test]
+    fn test_validate_unparseable_config_without_policy() {
+        validate(
+            OtelComponentsPolicy::default(),
+            AGENT_TYPE_NAME_NRDOT,
+            "config: [not, a, map",
+        )
+        .unwrap();
+    }
+}
@@ -0,0 +1,265 @@
+//! Validator that rejects remote configs requiring a newer version of the agent than the one it
+//! runs.
+//!
+//! Remote configs can declare their requirements in the [AGENT_CONFIG_REQUIREMENTS_KEY] entry:
+//!
+//! ```yaml
+//! min_version: 0.120.0
+//! ```
+//!
+//! The `min_version` is compared with the version reported by the binaries the agent runs, with
+//! `--version`. Configs that would make the agent fail with the version it runs, like collector
+//! configs using components of later releases, are rejected instead of restarting the agent into a
+//! failing state.
+use super::RemoteConfigValidator;
+use crate::opamp::remote_config::{AGENT_CONFIG_REQUIREMENTS_KEY, OpampRemoteConfig};
+use crate::sub_agent::identity::AgentIdentity;
+use crate::sub_agent::on_host::processes::SupervisedProcesses;
+use regex::Regex;
+use semver::Version;
+use serde::Deserialize;
+use std::io::Read;
+use std::process::{Command, Stdio};
+use std::sync::LazyLock;
+use std::thread;
+use std::time::{Duration, Instant};
+use thiserror::Error;
+use tracing::debug;
+
+/// Argument making the agent binaries print their version.
+const VERSION_ARG: &str = "--version";
+/// Time the agent binaries have to print their version.
+const VERSION_TIMEOUT: Duration = Duration::from_secs(5);
+const POLL_INTERVAL: Duration = Duration::from_millis(50);
+
+/// Matches the first semantic version in the output of the agent binaries, like `0.120.0` in
+/// `otelcol-contrib version 0.120.0`.
+static VERSION_RE: LazyLock<Regex> = LazyLock::new(|| {
+    Regex::new(r"v?\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?").unwrap()
+});
+
+/// Errors produced by the version requirements validator.
+#[derive(Error, Debug)]
+pub enum VersionRequirementsValidatorError {
+    /// The requirements entry is malformed.
+    #[error("invalid '{AGENT_CONFIG_REQUIREMENTS_KEY}': {0}")]
+    InvalidRequirements(String),
+
+    /// The config requires a newer version than the one the agent runs.
+    #[error(
+        "the config requires version {min_version} or newer, but the agent version is {version}"
+    )]
+    IncompatibleVersion {
+        /// Version of the agent.
+        version: Version,
+        /// Minimum version required by the config.
+        min_version: Version,
+    },
+}
+
+/// Requirements declared by a remote config.
+#[derive(Debug, Default, Deserialize)]
+struct Requirements {
+    min_version: Option<String>,
+}
+
+/// Checks the version of the running agent against the `min_version` required by the remote
+/// configs. Configs without requirements are valid, as well as the ones of agents that are not
+/// running or whose binaries don't report a semantic version.
+pub struct VersionRequirementsValidator {
+    supervised_processes: SupervisedProcesses,
+}
+
+impl VersionRequirementsValidator {
+    /// Returns a validator discovering the versions of the binaries in `supervised_processes`.
+    pub fn new(supervised_processes: SupervisedProcesses) -> Self {
+        Self {
+            supervised_processes,
+        }
+    }
+}
+
+impl RemoteConfigValidator for VersionRequirementsValidator {
+    type Err = VersionRequirementsValidatorError;
+    fn validate(
+        &self,
+        agent_identity: &AgentIdentity,
+        opamp_remote_config: &OpampRemoteConfig,
+    ) -> Result<(), VersionRequirementsValidatorError> {
+        let Some(raw_requirements) = opamp_remote_config.agent_config_requirements() else {
+            return Ok(());
+        };
+        let requirements =
+            serde_saphyr::from_str::<Requirements>(raw_requirements).map_err(|err| {
+                VersionRequirementsValidatorError::InvalidRequirements(err.to_string())
+            })?;
+        let Some(min_version) = requirements.min_version else {
+            return Ok(());
+        };
+        let min_version = parse_version(&min_version).ok_or_else(|| {
+            VersionRequirementsValidatorError::InvalidRequirements(format!(
+                "invalid min_version '{min_version}'"
+            ))
+        })?;
+
+        let Some(version) = self
+            .supervised_processes
+            .paths(&agent_identity.id)
+            .iter()
+            .find_map(|path| binary_version(path))
+        else {
+            debug!(agent_id = %agent_identity.id, "The version required by the config can't be checked, the agent is not running or doesn't report a semantic version");
+            return Ok(());
+        };
+
+        if version < min_version {
+            return Err(VersionRequirementsValidatorError::IncompatibleVersion {
+                version,
+                min_version,
+            });
+        }
+        Ok(())
+    }
+}
+
+/// Returns the version printed by the binary at `path` when run with [VERSION_ARG], if any.
+fn binary_version(path: &str) -> Option<Version> {
+    let mut child = Command::new(path)
+        .arg(VERSION_ARG)
+        .stdin(Stdio::null())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::null())
+        .spawn()
+        .inspect_err(|err| debug!(path, "Could not get the agent version: {err}"))
+        .ok()?;
+
+    let deadline = Instant::now() + VERSION_TIMEOUT;
+    loop {
+        match child.try_wait() {
+            Ok(Some(_)) => break,
+            Ok(None) if Instant::now() < deadline => thread::sleep(POLL_INTERVAL),
+            _ => {
+                debug!(
+                    path,
+                    "Could not get the agent version, the binary didn't exit"
+                );
+                let _ = child.kill();
+                let _ = child.wait();
+                return None;
+            }
+        }
+    }
+
+    let mut output = String::new();
+    child.stdout.take()?.read_to_string(&mut output).ok()?;
+    VERSION_RE
+        .find(&output)
+        .and_then(|version| parse_version(version.as_str()))
+}
+
+/// Parses a semantic version, allowing a leading `v` as in `v1.2.3`.
+fn parse_version(version: &str) -> Option<Version> {
+    let version = version.trim();
+    Version::parse(version.strip_prefix('v').unwrap_or(version)).ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_control::agent_id::AgentID;
+    use crate::opamp::remote_config::hash::{ConfigState, Hash};
+    use crate::opamp::remote_config::{AGENT_CONFIG_PREFIX, ConfigurationMap};
+    use assert_matches::assert_matches;
+    use rstest::rstest;
+    use std::collections::HashMap;
+
+    /// Validates the requirements for an agent running a binary that prints `version_output`.
+    fn validate(
+        requirements: Option<&str>,
+        version_output: Option<&str>,
+    ) -> Result<(), VersionRequirementsValidatorError> {
+        let agent_identity = AgentIdentity {
+            id: AgentID::try_from("test").unwrap(),
+            ..Default::default()
+        };
+        let config_map = HashMap::from([(AGENT_CONFIG_PREFIX.to_string(), "{}".to_string())])
+            .into_iter()
+            .chain(requirements.map(|requirements| {
+                (
+                    AGENT_CONFIG_REQUIREMENTS_KEY.to_string(),
+                    requirements.to_string(),
+                )
+            }))
+            .collect();
+        let remote_config = OpampRemoteConfig::new(
+            agent_identity.id.clone(),
+            Hash::from("hash"),
+            ConfigState::Applying,
+            ConfigurationMap::new(config_map),
+        );
+
+        let tmp_dir = tempfile::tempdir().unwrap();
+        let supervised_processes = SupervisedProcesses::default();
+        if let Some(version_output) = version_output {
+            let binary = fake_binary(tmp_dir.path(), version_output);
+            supervised_processes.register(&agent_identity.id, "agent", 1, &binary);
+        }
+        VersionRequirementsValidator::new(supervised_processes)
+            .validate(&agent_identity, &remote_config)
+    }
+
+    /// Writes a binary printing `output` when run.
+    #[cfg(target_family = "unix")]
+    fn fake_binary(dir: &std::path::Path, output: &str) -> String {
+        use std::os::unix::fs::PermissionsExt;
+
+        let path = dir.join("agent");
+        std::fs::write(&path, format!("#!/bin/sh\necho '{output}'\n")).unwrap();
+        std::fs::set_permissions(&path, std::fs::Permissions::from_mode(0o755)).unwrap();
+        path.to_string_lossy().to_string()
+    }
+
+    #[cfg(target_family = "windows")]
+    fn fake_binary(dir: &std::path::Path, output: &str) -> String {
+        let path = dir.join("agent.cmd");
+        std::fs::write(&path, format!("@echo {output}\r\n")).unwrap();
+        path.to_string_lossy().to_string()
+    }
+
+    #[rstest]
+    #[case::no_requirements(None, Some("otelcol version 0.100.0"))]
+    #[case::no_min_version(Some("{}"), Some("otelcol version 0.100.0"))]
+    #[case::same_version(Some("min_version: 0.120.0"), Some("otelcol version 0.120.0"))]
+    #[case::newer_version(Some("min_version: 0.120.0"), Some("fluent-bit v1.0.0"))]
+    #[case::not_running(Some("min_version: 0.120.0"), None)]
+    #[case::not_semantic_version(Some("min_version: 0.120.0"), Some("otelcol version latest"))]
+    fn test_valid_requirements(
+        #[case] requirements: Option<&str>,
+        #[case] version_output: Option<&str>,
+    ) {
+        validate(requirements, version_output).unwrap();
+    }
+
+    #[test]
+    fn test_incompatible_version() {
+        let err = validate(
+            Some("min_version: 0.120.0"),
+            Some("otelcol-contrib version 0.119.1"),
+        )
+        .unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "the config requires version 0.120.0 or newer, but the agent version is 0.119.1"
+        );
+    }
+
+    #[rstest]
+    #[case::invalid_yaml("min_version: [")]
+    #[case::invalid_min_version("min_version: latest")]
+    fn test_invalid_requirements(#[case] requirements: &str) {
+        assert_matches!(
+            validate(Some(requirements), Some("otelcol version 0.120.0")),
+            Err(VersionRequirementsValidatorError::InvalidRequirements(_))
+        );
+    }
+}
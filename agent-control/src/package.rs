@@ -1,4 +1,8 @@
 //! Package installation, removal and update management.
+pub mod bundle;
+pub mod integrity;
 pub mod manager;
 pub mod oci;
+pub mod offline;
 pub mod post_download_hook_executor;
+pub mod syncer;
@@ -0,0 +1,167 @@
+//! Multi-architecture bundles: packages shipping the binaries of several platforms under
+//! `artifacts/<arch>/`, along with a `SHA256SUMS` manifest listing their checksums.
+//!
+//! The manifest uses the `sha256sum` output format (`<hex digest>  <path relative to the package
+//! root>`). When a package includes it, every listed file is verified right after extraction, so
+//! a corrupted or incomplete bundle is never installed. Agent types reach the binaries of the
+//! host architecture through the `${nr-sub:packages.<id>.artifacts_dir}` reserved variable.
+
+use super::integrity::sha256_file;
+use crate::utils::platform;
+use std::io;
+use std::path::{Component, Path, PathBuf};
+use thiserror::Error;
+
+/// File name of the bundle manifest in the package root.
+pub const BUNDLE_MANIFEST_FILE_NAME: &str = "SHA256SUMS";
+/// Directory of the package holding the binaries of each architecture.
+pub const BUNDLE_ARTIFACTS_DIR: &str = "artifacts";
+
+/// Errors verifying a bundle against its manifest.
+#[derive(Debug, Error)]
+pub enum BundleError {
+    /// A manifest line is not a valid `<digest>  <path>` entry.
+    #[error("invalid {BUNDLE_MANIFEST_FILE_NAME} line {line}: '{content}'")]
+    InvalidManifest {
+        /// Number of the line, starting at 1.
+        line: usize,
+        /// Content of the line.
+        content: String,
+    },
+    /// The digest of a file doesn't match the manifest.
+    #[error("digest of '{path}' is sha256:{actual}, expected sha256:{expected}")]
+    Mismatch {
+        /// Path of the file, relative to the package root.
+        path: String,
+        /// Digest listed in the manifest.
+        expected: String,
+        /// Digest of the file.
+        actual: String,
+    },
+    /// The manifest or a listed file could not be read.
+    #[error("reading '{0}': {1}")]
+    Io(String, #[source] io::Error),
+}
+
+/// Returns the directory holding the binaries of the host architecture in the bundle installed at
+/// `package_dir`.
+pub fn artifacts_dir(package_dir: &Path) -> PathBuf {
+    package_dir
+        .join(BUNDLE_ARTIFACTS_DIR)
+        .join(platform::arch())
+}
+
+/// Verifies the files of the package installed at `package_dir` against its bundle manifest,
+/// returning whether it has one.
+pub fn verify(package_dir: &Path) -> Result<bool, BundleError> {
+    let manifest_path = package_dir.join(BUNDLE_MANIFEST_FILE_NAME);
+    let manifest = match std::fs::read_to_string(&manifest_path) {
+        Ok(manifest) => manifest,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(false),
+        Err(err) => return Err(BundleError::Io(BUNDLE_MANIFEST_FILE_NAME.to_string(), err)),
+    };
+
+    verify_manifest(package_dir, &manifest)?;
+    Ok(true)
+}
+
+/// Verifies the files under `root` against the `manifest` content, returning the listed paths
+/// relative to `root`.
+pub fn verify_manifest(root: &Path, manifest: &str) -> Result<Vec<PathBuf>, BundleError> {
+    let mut listed = Vec::new();
+    for (index, line) in manifest.lines().enumerate() {
+        if line.trim().is_empty() {
+            continue;
+        }
+        let (expected, path) = parse_line(line).ok_or_else(|| BundleError::InvalidManifest {
+            line: index + 1,
+            content: line.to_string(),
+        })?;
+        let actual =
+            sha256_file(&root.join(path)).map_err(|err| BundleError::Io(path.to_string(), err))?;
+        if !actual.eq_ignore_ascii_case(expected) {
+            return Err(BundleError::Mismatch {
+                path: path.to_string(),
+                expected: expected.to_string(),
+                actual,
+            });
+        }
+        listed.push(PathBuf::from(path));
+    }
+    Ok(listed)
+}
+
+/// Parses a `<digest>  <path>` line. The path may be marked as binary with a leading `*`, and
+/// must stay inside the package.
+fn parse_line(line: &str) -> Option<(&str, &str)> {
+    let (digest, path) = line.trim_end().split_once(char::is_whitespace)?;
+    let path = path.trim_start();
+    let path = path.strip_prefix('*').unwrap_or(path);
+    let is_hex_digest = digest.len() == 64 && digest.chars().all(|c| c.is_ascii_hexdigit());
+    let is_relative = !path.is_empty()
+        && Path::new(path)
+            .components()
+            .all(|component| matches!(component, Component::Normal(_) | Component::CurDir));
+    (is_hex_digest && is_relative).then_some((digest, path))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use assert_matches::assert_matches;
+    use tempfile::tempdir;
+
+    // sha256 of "abc".
+    const ABC_DIGEST: &str = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad";
+
+    fn bundle(manifest: &str) -> tempfile::TempDir {
+        let dir = tempdir().unwrap();
+        let arch_dir = dir.path().join(BUNDLE_ARTIFACTS_DIR).join("amd64");
+        std::fs::create_dir_all(&arch_dir).unwrap();
+        std::fs::write(arch_dir.join("agent"), b"abc").unwrap();
+        std::fs::write(dir.path().join(BUNDLE_MANIFEST_FILE_NAME), manifest).unwrap();
+        dir
+    }
+
+    #[test]
+    fn test_verify_bundle() {
+        let dir = bundle(&format!("{ABC_DIGEST}  artifacts/amd64/agent\n\n"));
+        assert!(verify(dir.path()).unwrap());
+
+        let dir = bundle(&format!("{ABC_DIGEST} *artifacts/amd64/agent"));
+        assert!(verify(dir.path()).unwrap());
+    }
+
+    #[test]
+    fn test_verify_without_manifest() {
+        assert!(!verify(tempdir().unwrap().path()).unwrap());
+    }
+
+    #[test]
+    fn test_verify_mismatch() {
+        let dir = bundle(&format!("{ABC_DIGEST}  artifacts/amd64/agent"));
+        std::fs::write(dir.path().join("artifacts/amd64/agent"), b"tampered").unwrap();
+        assert_matches!(verify(dir.path()), Err(BundleError::Mismatch { .. }));
+    }
+
+    #[test]
+    fn test_verify_missing_file() {
+        let dir = bundle(&format!("{ABC_DIGEST}  artifacts/arm64/agent"));
+        assert_matches!(verify(dir.path()), Err(BundleError::Io(..)));
+    }
+
+    #[test]
+    fn test_verify_invalid_manifest() {
+        for manifest in [
+            "not-a-digest  artifacts/amd64/agent".to_string(),
+            format!("{ABC_DIGEST}  ../outside"),
+            format!("{ABC_DIGEST}  /etc/passwd"),
+            ABC_DIGEST.to_string(),
+        ] {
+            assert_matches!(
+                verify(bundle(&manifest).path()),
+                Err(BundleError::InvalidManifest { line: 1, .. })
+            );
+        }
+    }
+}
@@ -0,0 +1,261 @@
+//! Integrity manifest of installed packages, used to detect binaries modified after installation.
+//!
+//! When a package is installed, the SHA-256 digest of every file of the verified artifact is
+//! recorded, right after its extraction, in a manifest stored next to the installation directory
+//! rather than inside it. Before an executable from the package is started, its digest is compared
+//! with the recorded one, so a tampered binary is reported instead of run.
+
+use aws_lc_rs::digest::{Context, SHA256};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::ffi::OsString;
+use std::fs::File;
+use std::io::{self, Read};
+use std::path::{Path, PathBuf};
+use thiserror::Error;
+
+/// Suffix appended to the package installation directory to get the path of its manifest.
+const INTEGRITY_MANIFEST_SUFFIX: &str = ".integrity.json";
+
+/// Errors returned when checking the integrity of a package file.
+#[derive(Debug, Error)]
+pub enum IntegrityError {
+    /// The digest of the file doesn't match the one recorded when the package was installed.
+    #[error(
+        "digest of '{path}' is sha256:{actual}, expected sha256:{expected}. It was modified after installation"
+    )]
+    Mismatch {
+        /// Path of the checked file.
+        path: String,
+        /// Digest recorded when the package was installed.
+        expected: String,
+        /// Current digest of the file.
+        actual: String,
+    },
+    /// The file is not part of the package as published, or was changed by its post-download hook.
+    #[error("'{0}' is not part of the verified package")]
+    Unknown(String),
+    /// The file or the manifest could not be read.
+    #[error("reading '{0}': {1}")]
+    Io(String, #[source] io::Error),
+}
+
+/// Digests of the files of an installed package, by path relative to its installation directory.
+#[derive(Debug, Default, PartialEq, Serialize, Deserialize)]
+pub struct IntegrityManifest {
+    files: BTreeMap<PathBuf, String>,
+}
+
+impl IntegrityManifest {
+    /// Computes the digests of every file under `package_dir`.
+    pub fn compute(package_dir: &Path) -> io::Result<Self> {
+        let mut files = BTreeMap::new();
+        let mut pending = vec![package_dir.to_path_buf()];
+        while let Some(dir) = pending.pop() {
+            for entry in std::fs::read_dir(&dir)? {
+                let path = entry?.path();
+                if path.is_dir() {
+                    pending.push(path);
+                } else if path.is_file() {
+                    let relative = path
+                        .strip_prefix(package_dir)
+                        .expect("entries are listed under the package dir");
+                    files.insert(relative.to_path_buf(), sha256_file(&path)?);
+                }
+            }
+        }
+        Ok(Self { files })
+    }
+
+    /// Removes the files whose content differs from the recorded one, e.g. because the
+    /// post-download hook of the package changed them, returning their paths. They are not part
+    /// of the verified package anymore, so executables among them are rejected.
+    pub fn discard_modified(&mut self, package_dir: &Path) -> Vec<PathBuf> {
+        let mut modified = Vec::new();
+        self.files.retain(|relative, digest| {
+            let unchanged = sha256_file(&package_dir.join(relative)).is_ok_and(|d| &d == digest);
+            if !unchanged {
+                modified.push(relative.clone());
+            }
+            unchanged
+        });
+        modified
+    }
+
+    /// Writes the manifest of the package installed in `package_dir`.
+    pub fn save(&self, package_dir: &Path) -> io::Result<()> {
+        let body = serde_json::to_vec(self)
+            .map_err(|err| io::Error::new(io::ErrorKind::InvalidData, err))?;
+        std::fs::write(manifest_path(package_dir), body)
+    }
+
+    /// Loads the manifest of the package installed in `package_dir`. Returns `None` if the
+    /// package was installed without one, e.g. by a previous version of Agent Control.
+    pub fn load(package_dir: &Path) -> io::Result<Option<Self>> {
+        let raw = match std::fs::read(manifest_path(package_dir)) {
+            Ok(raw) => raw,
+            Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(None),
+            Err(err) => return Err(err),
+        };
+        serde_json::from_slice(&raw)
+            .map(Some)
+            .map_err(|err| io::Error::new(io::ErrorKind::InvalidData, err))
+    }
+
+    /// Removes the manifest of the package installed in `package_dir`, if any.
+    pub fn delete(package_dir: &Path) -> io::Result<()> {
+        match std::fs::remove_file(manifest_path(package_dir)) {
+            Err(err) if err.kind() != io::ErrorKind::NotFound => Err(err),
+            _ => Ok(()),
+        }
+    }
+
+    /// Checks that the file at `path`, located in `package_dir`, is unchanged since installation.
+    pub fn verify(&self, package_dir: &Path, path: &Path) -> Result<(), IntegrityError> {
+        let display = path.display().to_string();
+        let relative = path
+            .strip_prefix(package_dir)
+            .map_err(|_| IntegrityError::Unknown(display.clone()))?;
+        let expected = self
+            .files
+            .get(relative)
+            .ok_or_else(|| IntegrityError::Unknown(display.clone()))?;
+        let actual = sha256_file(path).map_err(|err| IntegrityError::Io(display.clone(), err))?;
+        if &actual != expected {
+            return Err(IntegrityError::Mismatch {
+                path: display,
+                expected: expected.clone(),
+                actual,
+            });
+        }
+        Ok(())
+    }
+}
+
+/// Returns whether `path` is the manifest of an installed package.
+pub fn is_manifest(path: &Path) -> bool {
+    path.file_name()
+        .is_some_and(|name| name.to_string_lossy().ends_with(INTEGRITY_MANIFEST_SUFFIX))
+}
+
+/// The manifest is kept next to the installation directory, out of the files of the package.
+fn manifest_path(package_dir: &Path) -> PathBuf {
+    let mut path = OsString::from(package_dir);
+    path.push(INTEGRITY_MANIFEST_SUFFIX);
+    PathBuf::from(path)
+}
+
+/// Returns the hex-encoded SHA-256 digest of the file at `path`.
+pub(crate) fn sha256_file(path: &Path) -> io::Result<String> {
+    let mut file = File::open(path)?;
+    let mut context = Context::new(&SHA256);
+    let mut buf = [0u8; 8192];
+    loop {
+        let read = file.read(&mut buf)?;
+        if read == 0 {
+            break;
+        }
+        context.update(&buf[..read]);
+    }
+    Ok(context
+        .finish()
+        .as_ref()
+        .iter()
+        .map(|byte| format!("{byte:02x}"))
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use assert_matches::assert_matches;
+    use tempfile::tempdir;
+
+    fn package_dir() -> tempfile::TempDir {
+        let dir = tempdir().unwrap();
+        std::fs::create_dir(dir.path().join("bin")).unwrap();
+        std::fs::write(dir.path().join("bin").join("agent"), b"binary").unwrap();
+        std::fs::write(dir.path().join("README"), b"readme").unwrap();
+        dir
+    }
+
+    #[test]
+    fn test_manifest_roundtrip() {
+        let dir = package_dir();
+
+        let manifest = IntegrityManifest::compute(dir.path()).unwrap();
+        manifest.save(dir.path()).unwrap();
+
+        // The manifest is stored out of the package files.
+        let path = manifest_path(dir.path());
+        assert!(path.is_file() && !path.starts_with(dir.path()));
+        assert!(is_manifest(&path));
+        assert_eq!(IntegrityManifest::compute(dir.path()).unwrap(), manifest);
+        assert_eq!(IntegrityManifest::load(dir.path()).unwrap(), Some(manifest));
+
+        IntegrityManifest::delete(dir.path()).unwrap();
+        assert!(!path.exists());
+        IntegrityManifest::delete(dir.path()).unwrap();
+    }
+
+    #[test]
+    fn test_load_missing_manifest() {
+        let dir = package_dir();
+
+        assert_eq!(IntegrityManifest::load(dir.path()).unwrap(), None);
+    }
+
+    #[test]
+    fn test_discard_modified() {
+        let dir = package_dir();
+        let binary = dir.path().join("bin").join("agent");
+        let mut manifest = IntegrityManifest::compute(dir.path()).unwrap();
+
+        std::fs::write(&binary, b"patched by the hook").unwrap();
+        assert_eq!(
+            manifest.discard_modified(dir.path()),
+            vec![PathBuf::from("bin").join("agent")]
+        );
+        assert_matches!(
+            manifest.verify(dir.path(), &binary),
+            Err(IntegrityError::Unknown(_))
+        );
+        manifest
+            .verify(dir.path(), &dir.path().join("README"))
+            .unwrap();
+    }
+
+    #[test]
+    fn test_verify() {
+        let dir = package_dir();
+        let binary = dir.path().join("bin").join("agent");
+        let manifest = IntegrityManifest::compute(dir.path()).unwrap();
+
+        manifest.verify(dir.path(), &binary).unwrap();
+
+        std::fs::write(&binary, b"tampered").unwrap();
+        assert_matches!(
+            manifest.verify(dir.path(), &binary),
+            Err(IntegrityError::Mismatch { .. })
+        );
+
+        let added = dir.path().join("bin").join("other");
+        std::fs::write(&added, b"other").unwrap();
+        assert_matches!(
+            manifest.verify(dir.path(), &added),
+            Err(IntegrityError::Unknown(_))
+        );
+    }
+
+    #[test]
+    fn test_sha256_file() {
+        let dir = tempdir().unwrap();
+        let path = dir.path().join("file");
+        std::fs::write(&path, b"abc").unwrap();
+
+        assert_eq!(
+            sha256_file(&path).unwrap(),
+            "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+        );
+    }
+}
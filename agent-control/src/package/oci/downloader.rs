@@ -1,14 +1,16 @@
 //! Downloads Agent Packages from an OCI registry, optionally verifying their signature.
 use crate::agent_control::config::OciAuth;
 use crate::agent_control::config::Registry;
+use crate::agent_control::config::SignatureVerificationMode;
 use crate::oci::artifact_definitions::LocalAgentPackage;
 use crate::oci::{Client, OciArtifactFetcher, OciClientError};
 use crate::package::manager::PackageData;
+use crate::signature::public_key::PublicKey;
 use crate::utils::retry::BackoffPolicy;
 use oci_client::Reference;
 use oci_client::secrets::RegistryAuth;
 use std::path::Path;
-use tracing::{debug, warn};
+use tracing::{debug, trace, warn};
 use url::Url;
 
 /// An interface for downloading Agent Packages from an OCI registry.
@@ -88,6 +90,22 @@ impl OCIPackageArtifactDownloader {
         }
     }
 
+    /// Returns a new downloader that also verifies signatures with the provided trusted keys.
+    pub fn with_trusted_keys(self, trusted_keys: Vec<PublicKey>) -> Self {
+        Self {
+            fetcher: self.fetcher.with_trusted_keys(trusted_keys),
+            ..self
+        }
+    }
+
+    /// Returns a new downloader handling signature verification failures as `mode` sets.
+    pub fn with_verification_mode(self, mode: SignatureVerificationMode) -> Self {
+        Self {
+            fetcher: self.fetcher.with_verification_mode(mode),
+            ..self
+        }
+    }
+
     /// This helper returns the `public_key_url` if signature verification needs to be performed, None otherwise
     fn should_verify_signature<'a>(&self, public_key_url: &'a Option<Url>) -> Option<&'a Url> {
         if !self.signature_verification_enabled {
@@ -125,6 +143,7 @@ impl OCIPackageArtifactDownloader {
             })?;
 
         debug!("Artifact written to {}", layer_path.display());
+        trace!(monotonic_counter.package_download_bytes = layer.size.max(0) as u64);
 
         Ok(LocalAgentPackage::new(media_type, layer_path))
     }
@@ -136,7 +155,7 @@ pub mod tests {
     use std::str::FromStr;
 
     use crate::agent_type::runtime_config::on_host::package::rendered::{Oci, Repository, Version};
-    use crate::http::config::ProxyConfig;
+    use crate::http::config::{EgressConfig, ProxyConfig};
 
     use crate::oci::artifact_definitions::{
         LayerMediaType, ManifestArtifactType, PackageMediaType,
@@ -306,6 +325,7 @@ pub mod tests {
                 ..Default::default()
             },
             ProxyConfig::default(),
+            EgressConfig::default(),
             tokio_runtime(),
         )
         .unwrap();
@@ -2,13 +2,20 @@
 use super::downloader::OCIPackageDownloader;
 use crate::agent_control::agent_id::AgentID;
 use crate::agent_control::defaults::PACKAGES_FOLDER_NAME;
+use crate::audit::AuditTrail;
+use crate::event::cancellation::IoWorker;
+use crate::event::channel::EventConsumer;
 use crate::oci::OciClientError;
 use crate::oci::artifact_definitions::LocalAgentPackage;
+use crate::package::bundle::{self, BundleError};
+use crate::package::integrity::{self, IntegrityManifest};
 use crate::package::manager::{InstalledPackageData, PackageData, PackageManager};
 use crate::package::oci::downloader::OCIPackageArtifactDownloader;
 use crate::package::post_download_hook_executor::{
     PostDownloadHookExecutionError, PostDownloadHookExecutor,
 };
+use crate::utils::error_kind::{ClassifiedError, ErrorKind};
+use crossbeam::channel::never;
 use fs::directory_manager::{DirectoryManager, DirectoryManagerFs};
 use fs::file::LocalFile;
 use fs::file::reader::FileReader;
@@ -17,6 +24,7 @@ use std::fmt::Display;
 use std::io;
 use std::path::{Component, Path, PathBuf};
 use std::sync::Mutex;
+use std::time::Duration;
 use thiserror::Error;
 use tracing::{debug, error, warn};
 
@@ -35,6 +43,8 @@ where
     directory_manager: DM,
     remote_dir: PathBuf,
     latest_installed_packages: Mutex<HashMap<AgentID, InstalledPackageData>>,
+    io_timeout: Option<(Duration, IoWorker)>,
+    audit_trail: AuditTrail,
 }
 
 /// Errors returned by the OCI package manager.
@@ -59,9 +69,24 @@ pub enum OCIPackageManagerError {
     /// One or more packages could not be removed during the retention purge.
     #[error("errors removing packages: {0}")]
     RetainPackageErrors(RetainPackageErrors),
+    /// The package files don't match its bundle manifest.
+    #[error("verifying the package bundle: {0}")]
+    Bundle(#[from] BundleError),
     /// The package's post-download hook failed.
     #[error("post-download hook execution failed: {0}")]
     PostDownloadHook(#[from] PostDownloadHookExecutionError),
+    /// A filesystem operation didn't complete within the I/O timeout.
+    #[error("filesystem operation timed out after {0:?}")]
+    Timeout(Duration),
+}
+
+impl ClassifiedError for OCIPackageManagerError {
+    fn kind(&self) -> ErrorKind {
+        match self {
+            Self::Download(_) | Self::Timeout(_) => ErrorKind::Transient,
+            _ => ErrorKind::Fatal,
+        }
+    }
 }
 
 /// Collection of per-package errors gathered while purging retained packages.
@@ -92,6 +117,8 @@ impl Display for RetainPackageErrors {
 
 const TEMP_PCK_LOCATION: &str = "__temp_packages";
 const INSTALLED_PCK_LOCATION: &str = "stored_packages";
+/// Name of the thread running filesystem operations with a timeout.
+const PACKAGE_MANAGER_THREAD_NAME: &str = "package manager";
 
 impl<D, DM> OCIPackageManager<D, DM>
 where
@@ -105,9 +132,43 @@ where
             directory_manager,
             remote_dir,
             latest_installed_packages: Mutex::new(HashMap::new()),
+            io_timeout: None,
+            audit_trail: AuditTrail::default(),
+        }
+    }
+
+    /// Returns the package manager recording the post-download hooks executed in `audit_trail`.
+    pub fn with_audit_trail(self, audit_trail: AuditTrail) -> Self {
+        Self {
+            audit_trail,
+            ..self
         }
     }
 
+    /// Returns the package manager with filesystem metadata operations (package lookups)
+    /// failing with [OCIPackageManagerError::Timeout] if they don't complete within `io_timeout`.
+    /// Downloads and extractions are not bounded, as their duration depends on the package size.
+    pub fn with_io_timeout(self, io_timeout: Duration) -> Self {
+        Self {
+            io_timeout: Some((io_timeout, IoWorker::new(PACKAGE_MANAGER_THREAD_NAME))),
+            ..self
+        }
+    }
+
+    /// Runs the filesystem `operation` in the I/O worker, bounded by the I/O timeout if any.
+    fn run_io<T, F>(&self, operation: F) -> Result<T, OCIPackageManagerError>
+    where
+        F: FnOnce() -> T + Send + 'static,
+        T: Send + 'static,
+    {
+        let Some((io_timeout, io_worker)) = &self.io_timeout else {
+            return Ok(operation());
+        };
+        io_worker
+            .run(&EventConsumer::from(never()), Some(*io_timeout), operation)
+            .map_err(|_| OCIPackageManagerError::Timeout(*io_timeout))
+    }
+
     /// Downloads and installs the OCI package specified in `package_data`.
     /// The package is first downloaded to `temp_package_path` and then extracted to `package_path`.
     fn install_archive(
@@ -128,16 +189,49 @@ where
         self.extract_package(&downloaded_package, install_path)
             .inspect_err(|e| warn!("OCI package installation failed: {}", e))?;
 
+        // Checked before the hook runs, as it may legitimately modify the package files.
+        match bundle::verify(install_path) {
+            Ok(true) => debug!("OCI package verified against its bundle manifest"),
+            Ok(false) => {}
+            Err(err) => {
+                warn!("OCI package doesn't match its bundle manifest: {err}");
+                _ = self.directory_manager.delete(install_path).inspect_err(|e| {
+                    error!("Failed to delete installation directory after verification failure: {e}")
+                });
+                return Err(err.into());
+            }
+        }
+
+        // Recorded from the files just extracted from the verified artifact, so executables are
+        // later checked against the published content instead of whatever was found on disk.
+        let integrity = IntegrityManifest::compute(install_path)
+            .inspect_err(|e| warn!("Could not record the integrity manifest of the package: {e}"))
+            .ok();
+
         // Execute post-download hook if configured
         if let Some(ref hook) = package_data.post_download_hook {
             debug!(
                 "Executing post-download hook for package {}",
                 package_data.id
             );
-            let executor = PostDownloadHookExecutor::new(install_path.to_path_buf());
+            let executor = PostDownloadHookExecutor::new(install_path.to_path_buf())
+                .with_audit_trail(self.audit_trail.clone());
             executor.execute(hook)?;
         }
 
+        if let Some(mut integrity) = integrity {
+            let modified = integrity.discard_modified(install_path);
+            if !modified.is_empty() {
+                warn!(
+                    "Files changed by the post-download hook of package {} can't be verified: {modified:?}",
+                    package_data.id
+                );
+            }
+            let _ = integrity.save(install_path).inspect_err(|e| {
+                warn!("Could not record the integrity manifest of the package: {e}")
+            });
+        }
+
         debug!("OCI package installed at {}", install_path.display());
         Ok(InstalledPackageData {
             id: package_data.id.clone(),
@@ -219,46 +313,51 @@ where
         let installed_packages_dir =
             get_generic_package_location_path(&self.remote_dir, agent_id, INSTALLED_PCK_LOCATION);
 
-        let mut installed_packages = vec![];
-        let id_dirs = LocalFile
-            .dir_entries(&installed_packages_dir)
-            .map_err(OCIPackageManagerError::Install)?;
+        self.run_io(move || list_installed_packages(&installed_packages_dir))?
+            .map_err(OCIPackageManagerError::Install)
+    }
+}
 
-        for id_path in id_dirs {
-            if !id_path.is_dir() {
-                debug!(
-                    "Unexpected file found on packages id dir: {}",
-                    id_path.display()
-                );
+/// Lists the packages installed in `installed_packages_dir`.
+fn list_installed_packages(installed_packages_dir: &Path) -> io::Result<Vec<InstalledPackageData>> {
+    let mut installed_packages = vec![];
+    let id_dirs = LocalFile.dir_entries(installed_packages_dir)?;
+
+    for id_path in id_dirs {
+        if !id_path.is_dir() {
+            debug!(
+                "Unexpected file found on packages id dir: {}",
+                id_path.display()
+            );
+            continue;
+        }
+
+        let Some(package_id) = id_path.file_name().map(|name| name.to_string_lossy()) else {
+            debug!(
+                "Unexpected file found on packages id dir: {}",
+                id_path.display()
+            );
+            continue;
+        };
+        let package_dirs = LocalFile.dir_entries(&id_path)?;
+        for package_dir in package_dirs {
+            if integrity::is_manifest(&package_dir) {
                 continue;
             }
-
-            let Some(package_id) = id_path.file_name().map(|name| name.to_string_lossy()) else {
+            if !package_dir.is_dir() {
                 debug!(
-                    "Unexpected file found on packages id dir: {}",
-                    id_path.display()
+                    "Unexpected file found on packages dir: {}",
+                    package_dir.display()
                 );
                 continue;
-            };
-            let package_dirs = LocalFile
-                .dir_entries(&id_path)
-                .map_err(OCIPackageManagerError::Install)?;
-            for package_dir in package_dirs {
-                if !package_dir.is_dir() {
-                    debug!(
-                        "Unexpected file found on packages dir: {}",
-                        package_dir.display()
-                    );
-                    continue;
-                }
-                installed_packages.push(InstalledPackageData {
-                    id: package_id.to_string(),
-                    installation_path: package_dir,
-                });
             }
+            installed_packages.push(InstalledPackageData {
+                id: package_id.to_string(),
+                installation_path: package_dir,
+            });
         }
-        Ok(installed_packages)
     }
+    Ok(installed_packages)
 }
 
 /// Returns the installation path for the given package under `base_path`.
@@ -362,7 +461,8 @@ where
     ) -> Result<InstalledPackageData, OCIPackageManagerError> {
         let package_path = get_package_path(&self.remote_dir, agent_id, &package_data)?;
 
-        if package_path.exists() {
+        let lookup_path = package_path.clone();
+        if self.run_io(move || lookup_path.exists())? {
             debug!(
                 "Package already installed at {}. Skipping download and extraction.",
                 package_path.display()
@@ -402,6 +502,8 @@ where
     ) -> Result<(), OCIPackageManagerError> {
         self.directory_manager
             .delete(&package.installation_path)
+            .map_err(OCIPackageManagerError::Uninstall)?;
+        IntegrityManifest::delete(&package.installation_path)
             .map_err(OCIPackageManagerError::Uninstall)
     }
 }
@@ -414,6 +516,7 @@ mod tests {
 
     use crate::agent_type::runtime_config::on_host::package::rendered::{Oci, Repository, Version};
     use crate::oci::artifact_definitions::PackageMediaType;
+    use crate::package::bundle::BUNDLE_MANIFEST_FILE_NAME;
     use crate::package::oci::downloader::tests::MockOCIDownloader;
     use crate::utils::extract::tests::TestDataHelper;
     use fs::directory_manager::mock::MockDirectoryManager;
@@ -627,6 +730,11 @@ mod tests {
         let installed = pm.install(&agent_id, package_data).unwrap();
 
         TestDataHelper::test_data_uncompressed(installed.installation_path.as_path());
+        assert!(
+            IntegrityManifest::load(&installed.installation_path)
+                .unwrap()
+                .is_some()
+        );
 
         assert_eq!(installed.id, TEST_PACKAGE_ID.to_string());
     }
@@ -665,6 +773,48 @@ mod tests {
         assert!(matches!(err, OCIPackageManagerError::Extraction(_)));
     }
 
+    #[test]
+    fn test_install_bundle_mismatch() {
+        let mut downloader = MockOCIDownloader::new();
+        let agent_id = AgentID::try_from("agent-id").unwrap();
+        let package_data = test_package_data();
+
+        let root_dir = tempdir().unwrap();
+        let download_dir =
+            get_temp_package_path(root_dir.path(), &agent_id, &package_data).unwrap();
+
+        downloader.expect_download().once().returning(move |_, _| {
+            DirectoryManagerFs.create(&download_dir).unwrap();
+            let downloaded_file = download_dir.join("layer_digest.tar.gz");
+            let tmp_dir_to_compress = tempdir().unwrap();
+            std::fs::write(
+                tmp_dir_to_compress.path().join(BUNDLE_MANIFEST_FILE_NAME),
+                format!("{}  file1.txt", "0".repeat(64)),
+            )
+            .unwrap();
+            TestDataHelper::compress_tar_gz(tmp_dir_to_compress.path(), downloaded_file.as_path());
+
+            Ok(new_local_package(&downloaded_file))
+        });
+
+        let pm = OCIPackageManager::new(
+            downloader,
+            DirectoryManagerFs,
+            PathBuf::from(root_dir.path()),
+        );
+
+        let err = pm.install(&agent_id, package_data.clone()).unwrap_err();
+        assert!(matches!(
+            err,
+            OCIPackageManagerError::Bundle(BundleError::Mismatch { .. })
+        ));
+        assert!(
+            !get_package_path(root_dir.path(), &agent_id, &package_data)
+                .unwrap()
+                .exists()
+        );
+    }
+
     #[test]
     fn test_install_directory_creation_failure() {
         let downloader = MockOCIDownloader::new();
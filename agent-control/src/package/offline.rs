@@ -0,0 +1,412 @@
+//! Offline bundles: signed archives produced by Fleet Control holding the configuration and the
+//! agent packages of a host, for air-gapped fleets syncing through removable media or internal
+//! mirrors.
+//!
+//! A bundle is a tar.gz archive with the following layout:
+//! - `SHA256SUMS`: manifest listing the checksum of every other file, in the format of the
+//!   [bundle] manifest.
+//! - `SHA256SUMS.sig`: JSON document (`{"key_id": "...", "signature": "..."}`) holding the
+//!   base64-encoded Ed25519 signature of the manifest.
+//! - `config/`: Agent Control configuration set, merged as the `apply` command does.
+//! - `agents/<agent-id>.yaml`: local configuration of the agents.
+//! - `packages/<repository>/<version>/package.tar.gz` (or `package.zip`): agent packages.
+//!
+//! Once imported, the bundle is kept in the data directory and [OfflinePackageDownloader] serves
+//! its packages to the package manager instead of pulling them from the registry.
+
+use crate::agent_control::defaults::OFFLINE_BUNDLE_FOLDER_NAME;
+use crate::agent_type::runtime_config::on_host::package::rendered::{Repository, Version};
+use crate::oci::OciClientError;
+use crate::oci::artifact_definitions::{LocalAgentPackage, PackageMediaType};
+use crate::package::bundle::{self, BUNDLE_MANIFEST_FILE_NAME, BundleError};
+use crate::package::manager::PackageData;
+use crate::package::oci::downloader::OCIPackageDownloader;
+use crate::signature::public_key::{PubKeyError, PublicKey};
+use crate::utils::extract::{ExtractError, extract_tar_gz};
+use base64::Engine;
+use base64::prelude::BASE64_STANDARD;
+use serde::Deserialize;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use thiserror::Error;
+use tracing::{debug, info};
+
+/// File name of the manifest signature in the bundle root.
+pub const BUNDLE_SIGNATURE_FILE_NAME: &str = "SHA256SUMS.sig";
+/// Directory of the bundle holding the Agent Control configuration set.
+pub const BUNDLE_CONFIG_DIR: &str = "config";
+/// Directory of the bundle holding the local configuration of the agents.
+pub const BUNDLE_AGENTS_DIR: &str = "agents";
+/// Directory of the bundle holding the agent packages.
+pub const BUNDLE_PACKAGES_DIR: &str = "packages";
+
+pub(crate) const PACKAGE_TAR_GZ_FILE_NAME: &str = "package.tar.gz";
+pub(crate) const PACKAGE_ZIP_FILE_NAME: &str = "package.zip";
+
+/// Errors importing an offline bundle.
+#[derive(Debug, Error)]
+pub enum OfflineBundleError {
+    /// The bundle archive could not be extracted.
+    #[error("extracting the bundle: {0}")]
+    Extract(#[from] ExtractError),
+    /// A file of the bundle could not be read or written.
+    #[error("accessing '{0}': {1}")]
+    Io(String, #[source] io::Error),
+    /// The signature file is not valid.
+    #[error("invalid {BUNDLE_SIGNATURE_FILE_NAME}: {0}")]
+    InvalidSignature(String),
+    /// The bundle is signed with a key that is not trusted.
+    #[error("the bundle is signed with the untrusted key '{0}'")]
+    UntrustedKey(String),
+    /// The signature doesn't match the manifest.
+    #[error("verifying the bundle signature: {0}")]
+    Signature(#[from] PubKeyError),
+    /// The files don't match the manifest.
+    #[error("verifying the bundle: {0}")]
+    Manifest(#[from] BundleError),
+    /// A file is not covered by the signed manifest.
+    #[error("'{0}' is not listed in {BUNDLE_MANIFEST_FILE_NAME}")]
+    Unlisted(String),
+}
+
+#[derive(Debug, Deserialize)]
+struct BundleSignature {
+    key_id: String,
+    signature: String,
+}
+
+/// Imports the bundle at `archive` into the data directory, replacing the previously imported one
+/// once its signature and content are verified with `trusted_keys`. Returns the directory holding
+/// the imported bundle.
+pub fn import_bundle(
+    archive: &Path,
+    trusted_keys: &[PublicKey],
+    remote_dir: &Path,
+) -> Result<PathBuf, OfflineBundleError> {
+    let bundle_dir = remote_dir.join(OFFLINE_BUNDLE_FOLDER_NAME);
+    let staging_dir = bundle_dir.with_extension("new");
+    remove_dir(&staging_dir)?;
+    fs::create_dir_all(&staging_dir).map_err(|err| io_error(&staging_dir, err))?;
+
+    // The previous bundle is kept until the new one is verified.
+    let imported = extract_tar_gz(archive, &staging_dir)
+        .map_err(OfflineBundleError::from)
+        .and_then(|()| verify_bundle(&staging_dir, trusted_keys));
+    if let Err(err) = imported {
+        _ = fs::remove_dir_all(&staging_dir);
+        return Err(err);
+    }
+
+    remove_dir(&bundle_dir)?;
+    fs::rename(&staging_dir, &bundle_dir).map_err(|err| io_error(&bundle_dir, err))?;
+    info!(bundle = %archive.display(), "Offline bundle imported");
+    Ok(bundle_dir)
+}
+
+/// Verifies the manifest signature and that every file of the bundle is listed in the manifest
+/// with its checksum.
+fn verify_bundle(dir: &Path, trusted_keys: &[PublicKey]) -> Result<(), OfflineBundleError> {
+    let manifest_path = dir.join(BUNDLE_MANIFEST_FILE_NAME);
+    let manifest =
+        fs::read_to_string(&manifest_path).map_err(|err| io_error(&manifest_path, err))?;
+    let signature_path = dir.join(BUNDLE_SIGNATURE_FILE_NAME);
+    let signature = fs::read(&signature_path).map_err(|err| io_error(&signature_path, err))?;
+    let signature: BundleSignature = serde_json::from_slice(&signature)
+        .map_err(|err| OfflineBundleError::InvalidSignature(err.to_string()))?;
+
+    let key = trusted_keys
+        .iter()
+        .find(|key| key.key_id() == signature.key_id)
+        .ok_or_else(|| OfflineBundleError::UntrustedKey(signature.key_id.clone()))?;
+    let signature = BASE64_STANDARD
+        .decode(&signature.signature)
+        .map_err(|err| OfflineBundleError::InvalidSignature(err.to_string()))?;
+    key.verify_signature(manifest.as_bytes(), &signature)?;
+
+    let listed = bundle::verify_manifest(dir, &manifest)?;
+    let mut files = Vec::new();
+    list_files(dir, &mut files)?;
+    for file in files {
+        let relative = file.strip_prefix(dir).unwrap_or(&file);
+        if relative == Path::new(BUNDLE_MANIFEST_FILE_NAME)
+            || relative == Path::new(BUNDLE_SIGNATURE_FILE_NAME)
+        {
+            continue;
+        }
+        if !listed.iter().any(|path| path == relative) {
+            return Err(OfflineBundleError::Unlisted(relative.display().to_string()));
+        }
+    }
+    debug!("Offline bundle verified with key '{}'", key.key_id());
+    Ok(())
+}
+
+/// Appends the paths of the files under `dir` to `files`, recursively.
+fn list_files(dir: &Path, files: &mut Vec<PathBuf>) -> Result<(), OfflineBundleError> {
+    let entries = fs::read_dir(dir).map_err(|err| io_error(dir, err))?;
+    for entry in entries {
+        let entry = entry.map_err(|err| io_error(dir, err))?;
+        let is_dir = entry
+            .file_type()
+            .map_err(|err| io_error(&entry.path(), err))?
+            .is_dir();
+        if is_dir {
+            list_files(&entry.path(), files)?;
+        } else {
+            files.push(entry.path());
+        }
+    }
+    Ok(())
+}
+
+fn remove_dir(dir: &Path) -> Result<(), OfflineBundleError> {
+    match fs::remove_dir_all(dir) {
+        Err(err) if err.kind() != io::ErrorKind::NotFound => Err(io_error(dir, err)),
+        _ => Ok(()),
+    }
+}
+
+fn io_error(path: &Path, err: io::Error) -> OfflineBundleError {
+    OfflineBundleError::Io(path.display().to_string(), err)
+}
+
+/// Returns the directory holding the packages of the offline bundle imported in `remote_dir`.
+pub(crate) fn offline_packages_dir(remote_dir: &Path) -> PathBuf {
+    remote_dir
+        .join(OFFLINE_BUNDLE_FOLDER_NAME)
+        .join(BUNDLE_PACKAGES_DIR)
+}
+
+/// Returns the directory of `packages_dir` holding the package of `repository` and `version`.
+pub(crate) fn offline_package_dir(
+    packages_dir: &Path,
+    repository: &Repository,
+    version: &Version,
+) -> PathBuf {
+    packages_dir
+        .join(repository.to_string())
+        .join(version.to_string().replace(':', "_"))
+}
+
+/// Serves the packages of the imported offline bundle, downloading the ones it doesn't hold with
+/// the wrapped downloader.
+pub struct OfflinePackageDownloader<D> {
+    downloader: D,
+    packages_dir: PathBuf,
+}
+
+impl<D> OfflinePackageDownloader<D> {
+    /// Returns a downloader serving the packages of the bundle imported in `remote_dir`.
+    pub fn new(downloader: D, remote_dir: &Path) -> Self {
+        Self {
+            downloader,
+            packages_dir: offline_packages_dir(remote_dir),
+        }
+    }
+
+    /// Returns the media type and the path of the package in the bundle, if it holds it.
+    fn find(&self, package_data: &PackageData) -> Option<(PackageMediaType, PathBuf)> {
+        let dir = offline_package_dir(
+            &self.packages_dir,
+            &package_data.oci.repository,
+            &package_data.oci.version,
+        );
+        [
+            (
+                PackageMediaType::AgentPackageLayerTarGz,
+                PACKAGE_TAR_GZ_FILE_NAME,
+            ),
+            (
+                PackageMediaType::AgentPackageLayerZip,
+                PACKAGE_ZIP_FILE_NAME,
+            ),
+        ]
+        .into_iter()
+        .map(|(media_type, file_name)| (media_type, dir.join(file_name)))
+        .find(|(_, path)| path.is_file())
+    }
+}
+
+impl<D> OCIPackageDownloader for OfflinePackageDownloader<D>
+where
+    D: OCIPackageDownloader,
+{
+    /// Copies the package from the offline bundle to `package_dir`, or downloads it when the
+    /// bundle doesn't hold it. The bundle content was verified when it was imported.
+    fn download(
+        &self,
+        package_data: &PackageData,
+        package_dir: &Path,
+    ) -> Result<LocalAgentPackage, OciClientError> {
+        let Some((media_type, path)) = self.find(package_data) else {
+            return self.downloader.download(package_data, package_dir);
+        };
+        debug!(
+            "Using repository '{}' with version '{}' from the offline bundle",
+            package_data.oci.repository, package_data.oci.version
+        );
+        let blob_path = package_dir.join(path.file_name().unwrap_or_default());
+        fs::copy(&path, &blob_path).map_err(|err| {
+            OciClientError::FetchArtifact(format!("copying package from the offline bundle: {err}"))
+        })?;
+        Ok(LocalAgentPackage::new(media_type, blob_path))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_type::runtime_config::on_host::package::rendered::Oci;
+    use crate::package::integrity::sha256_file;
+    use crate::package::oci::downloader::tests::MockOCIDownloader;
+    use crate::signature::public_key::tests::TestKeyPair;
+    use assert_matches::assert_matches;
+    use flate2::Compression;
+    use flate2::write::GzEncoder;
+    use std::str::FromStr;
+    use tempfile::{TempDir, tempdir};
+
+    const PACKAGE_PATH: &str = "packages/newrelic/infra/1.0.0/package.tar.gz";
+
+    /// Writes a bundle with `files` signed by `key_pair`, adding the unlisted `extra` files.
+    fn write_bundle(
+        dir: &Path,
+        key_pair: &TestKeyPair,
+        files: &[(&str, &[u8])],
+        extra: &[(&str, &[u8])],
+    ) -> PathBuf {
+        let content_dir = dir.join("content");
+        let mut manifest = String::new();
+        for (name, content) in files.iter().chain(extra) {
+            let path = content_dir.join(name);
+            fs::create_dir_all(path.parent().unwrap()).unwrap();
+            fs::write(&path, content).unwrap();
+        }
+        for (name, _) in files {
+            let digest = sha256_file(&content_dir.join(name)).unwrap();
+            manifest.push_str(&format!("{digest}  {name}\n"));
+        }
+        fs::write(content_dir.join(BUNDLE_MANIFEST_FILE_NAME), &manifest).unwrap();
+        let signature = serde_json::json!({
+            "key_id": key_pair.key_id(),
+            "signature": BASE64_STANDARD.encode(key_pair.sign(manifest.as_bytes())),
+        });
+        fs::write(
+            content_dir.join(BUNDLE_SIGNATURE_FILE_NAME),
+            signature.to_string(),
+        )
+        .unwrap();
+
+        let archive = dir.join("bundle.tar.gz");
+        let mut tar = tar::Builder::new(GzEncoder::new(
+            fs::File::create(&archive).unwrap(),
+            Compression::default(),
+        ));
+        tar.append_dir_all(".", &content_dir).unwrap();
+        tar.into_inner().unwrap().finish().unwrap();
+        archive
+    }
+
+    fn bundle_files() -> Vec<(&'static str, &'static [u8])> {
+        vec![
+            ("config/agent-control.yaml", b"agents: {}\n"),
+            (PACKAGE_PATH, b"package"),
+        ]
+    }
+
+    fn package_data(version: &str) -> PackageData {
+        PackageData {
+            id: "infra".to_string(),
+            oci: Oci {
+                repository: Repository::from_str("newrelic/infra").unwrap(),
+                version: Version::from_str(version).unwrap(),
+                public_key_url: None,
+            },
+            post_download_hook: None,
+        }
+    }
+
+    #[test]
+    fn test_import_bundle() {
+        let tmp_dir = TempDir::new().unwrap();
+        let remote_dir = tmp_dir.path().join("data");
+        let key_pair = TestKeyPair::new(0);
+        let archive = write_bundle(tmp_dir.path(), &key_pair, &bundle_files(), &[]);
+
+        let bundle_dir = import_bundle(&archive, &[key_pair.public_key()], &remote_dir).unwrap();
+
+        assert_eq!(bundle_dir, remote_dir.join(OFFLINE_BUNDLE_FOLDER_NAME));
+        assert!(bundle_dir.join("config/agent-control.yaml").is_file());
+        assert!(bundle_dir.join(PACKAGE_PATH).is_file());
+        assert!(!bundle_dir.with_extension("new").exists());
+    }
+
+    #[test]
+    fn test_import_bundle_rejected() {
+        let tmp_dir = TempDir::new().unwrap();
+        let remote_dir = tmp_dir.path().join("data");
+        let key_pair = TestKeyPair::new(0);
+        let archive = write_bundle(&tmp_dir.path().join("ok"), &key_pair, &bundle_files(), &[]);
+        let bundle_dir = import_bundle(&archive, &[key_pair.public_key()], &remote_dir).unwrap();
+
+        let untrusted = TestKeyPair::new(1);
+        let archive = write_bundle(
+            &tmp_dir.path().join("untrusted"),
+            &untrusted,
+            &bundle_files(),
+            &[],
+        );
+        assert_matches!(
+            import_bundle(&archive, &[key_pair.public_key()], &remote_dir),
+            Err(OfflineBundleError::UntrustedKey(_))
+        );
+
+        let archive = write_bundle(
+            &tmp_dir.path().join("unlisted"),
+            &key_pair,
+            &bundle_files(),
+            &[("agents/injected.yaml", b"injected: true\n")],
+        );
+        assert_matches!(
+            import_bundle(&archive, &[key_pair.public_key()], &remote_dir),
+            Err(OfflineBundleError::Unlisted(_))
+        );
+
+        // The previously imported bundle is kept
+        assert!(bundle_dir.join(PACKAGE_PATH).is_file());
+        assert!(!bundle_dir.join("agents/injected.yaml").exists());
+        assert!(!bundle_dir.with_extension("new").exists());
+    }
+
+    #[test]
+    fn test_offline_package_downloader() {
+        let tmp_dir = TempDir::new().unwrap();
+        let remote_dir = tmp_dir.path().join("data");
+        let key_pair = TestKeyPair::new(0);
+        let archive = write_bundle(tmp_dir.path(), &key_pair, &bundle_files(), &[]);
+        import_bundle(&archive, &[key_pair.public_key()], &remote_dir).unwrap();
+
+        let package_dir = tempdir().unwrap();
+        let mut mock = MockOCIDownloader::new();
+        mock.expect_download()
+            .withf(|package_data, _| package_data.oci.version.to_string() == "2.0.0")
+            .times(1)
+            .returning(|_, _| Err(OciClientError::FetchArtifact("not found".to_string())));
+        let downloader = OfflinePackageDownloader::new(mock, &remote_dir);
+
+        let package = downloader
+            .download(&package_data("1.0.0"), package_dir.path())
+            .unwrap();
+        assert_eq!(
+            fs::read(package.path()).unwrap(),
+            b"package",
+            "the package is copied from the bundle"
+        );
+        assert!(
+            downloader
+                .download(&package_data("2.0.0"), package_dir.path())
+                .is_err()
+        );
+    }
+}
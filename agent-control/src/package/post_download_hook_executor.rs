@@ -7,6 +7,7 @@ use std::time::{Duration, Instant};
 use tracing::{debug, warn};
 
 use crate::agent_type::runtime_config::on_host::package::rendered::PostDownloadHook;
+use crate::audit::{AuditEvent, AuditTrail};
 
 #[cfg(unix)]
 use {
@@ -53,12 +54,24 @@ fn make_executable_if_exists(path: &str) {
 /// Runs a package's post-download hook within its installation directory.
 pub struct PostDownloadHookExecutor {
     package_dir: PathBuf,
+    audit_trail: AuditTrail,
 }
 
 impl PostDownloadHookExecutor {
     /// Creates an executor that runs hooks inside `package_dir`.
     pub fn new(package_dir: PathBuf) -> Self {
-        Self { package_dir }
+        Self {
+            package_dir,
+            audit_trail: AuditTrail::default(),
+        }
+    }
+
+    /// Records the executed hooks in `audit_trail`.
+    pub fn with_audit_trail(self, audit_trail: AuditTrail) -> Self {
+        Self {
+            audit_trail,
+            ..self
+        }
     }
 
     /// Executes the given post-download hook, returning an error on failure or timeout.
@@ -92,6 +105,10 @@ impl PostDownloadHookExecutor {
                 PostDownloadHookExecutionError::SpawnFailed(post_download_hook.path.clone(), e)
             }
         })?;
+        self.audit_trail.record(AuditEvent::CommandExecuted {
+            path: post_download_hook.path.clone(),
+            args: post_download_hook.args.0.clone(),
+        });
 
         // Wait for completion with timeout
         let timeout = Duration::from_secs(300);
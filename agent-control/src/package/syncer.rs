@@ -0,0 +1,359 @@
+//! Packages offered by the OpAMP server through `PackagesAvailable` messages.
+//!
+//! Each offered package is downloaded, verified against the SHA-256 hash of its content and staged
+//! in the packages directory of the offline bundle as `<name>/<version>/package.tar.gz` (or
+//! `package.zip`), the layout [OfflinePackageDownloader] serves. The package name is the
+//! repository of the package, so the agents installing that repository and version get the offered
+//! package instead of pulling it from the registry.
+//!
+//! Importing an offline bundle replaces the staged packages with the ones of the bundle.
+//!
+//! [OfflinePackageDownloader]: crate::package::offline::OfflinePackageDownloader
+
+use crate::agent_type::runtime_config::on_host::package::rendered::{Repository, Version};
+use crate::http::client::HttpClient;
+use crate::package::offline::{
+    PACKAGE_TAR_GZ_FILE_NAME, PACKAGE_ZIP_FILE_NAME, offline_package_dir, offline_packages_dir,
+};
+use aws_lc_rs::digest::{SHA256, digest};
+use http::Request;
+use opamp_client::opamp::proto::{
+    DownloadableFile, PackageAvailable, PackageStatus, PackageStatusEnum, PackageStatuses,
+    PackagesAvailable,
+};
+use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use std::sync::Mutex;
+use std::time::Duration;
+use thiserror::Error;
+use tracing::{debug, info};
+
+/// Timeout of the download of an offered package.
+pub const PACKAGE_DOWNLOAD_TIMEOUT: Duration = Duration::from_secs(600);
+/// Timeout establishing the connection to download an offered package.
+pub const PACKAGE_DOWNLOAD_CONNECT_TIMEOUT: Duration = Duration::from_secs(30);
+
+/// Errors staging an offered package.
+#[derive(Debug, Error)]
+pub enum PackageSyncError {
+    /// The package name is not a valid repository.
+    #[error("invalid package name: {0}")]
+    InvalidName(String),
+    /// The package version is not valid.
+    #[error("invalid package version: {0}")]
+    InvalidVersion(String),
+    /// The offer doesn't include a file to download.
+    #[error("the package has no file to download")]
+    MissingFile,
+    /// The package file could not be downloaded.
+    #[error("downloading the package: {0}")]
+    Download(String),
+    /// The downloaded file doesn't match the offered content hash.
+    #[error("the downloaded package doesn't match its content hash")]
+    ContentHash,
+    /// The package file could not be stored.
+    #[error("storing the package at '{0}': {1}")]
+    Io(String, #[source] std::io::Error),
+}
+
+/// Downloads the files of the offered packages.
+#[cfg_attr(test, mockall::automock)]
+pub trait PackageFileClient: Send + Sync {
+    /// Returns the content of `file`.
+    fn download(&self, file: &DownloadableFile) -> Result<Vec<u8>, PackageSyncError>;
+}
+
+impl PackageFileClient for HttpClient {
+    fn download(&self, file: &DownloadableFile) -> Result<Vec<u8>, PackageSyncError> {
+        let mut request = Request::get(&file.download_url);
+        for header in file.headers.iter().flat_map(|headers| &headers.headers) {
+            request = request.header(&header.key, &header.value);
+        }
+        let request = request
+            .body(Vec::new())
+            .map_err(|err| PackageSyncError::Download(err.to_string()))?;
+        let response = self
+            .send(request)
+            .map_err(|err| PackageSyncError::Download(err.to_string()))?;
+        Ok(response.into_body())
+    }
+}
+
+/// Result of staging the packages offered by the server.
+#[derive(Debug, Default, PartialEq)]
+pub struct SyncedPackages {
+    /// Status of every offered package.
+    pub statuses: PackageStatuses,
+    /// Whether any package was stored or replaced.
+    pub updated: bool,
+}
+
+/// Stages the packages offered through OpAMP.
+pub trait PackagesSyncer: Send + Sync {
+    /// Stages the offered packages and returns their statuses.
+    fn sync(&self, packages_available: &PackagesAvailable) -> SyncedPackages;
+}
+
+/// Stages the offered packages in the offline bundle, downloading them with the given client.
+pub struct OfflinePackagesSyncer<C> {
+    client: C,
+    packages_dir: PathBuf,
+    /// Hash of the last set of packages staged without errors, which doesn't need to be synced
+    /// again when it is offered once more.
+    synced_hash: Mutex<Option<Vec<u8>>>,
+}
+
+impl<C> OfflinePackagesSyncer<C>
+where
+    C: PackageFileClient,
+{
+    /// Returns a syncer staging the packages in the offline bundle of `remote_dir`.
+    pub fn new(client: C, remote_dir: &Path) -> Self {
+        Self {
+            client,
+            packages_dir: offline_packages_dir(remote_dir),
+            synced_hash: Mutex::default(),
+        }
+    }
+
+    /// Stores the package `name` if the staged one doesn't match the offered file, returning
+    /// whether it was stored.
+    fn stage(&self, name: &str, package: &PackageAvailable) -> Result<bool, PackageSyncError> {
+        let repository = Repository::from_str(name)
+            .map_err(|err| PackageSyncError::InvalidName(err.to_string()))?;
+        // Names are joined to the packages directory, so they must not leave it.
+        if name.split('/').any(|component| component == "..") {
+            return Err(PackageSyncError::InvalidName(name.to_string()));
+        }
+        let version = Version::from_str(&package.version)
+            .map_err(|err| PackageSyncError::InvalidVersion(err.to_string()))?;
+        let file = package.file.as_ref().ok_or(PackageSyncError::MissingFile)?;
+
+        let dir = offline_package_dir(&self.packages_dir, &repository, &version);
+        let (file_name, other_file_name) = if file.content_type.contains("zip") {
+            (PACKAGE_ZIP_FILE_NAME, PACKAGE_TAR_GZ_FILE_NAME)
+        } else {
+            (PACKAGE_TAR_GZ_FILE_NAME, PACKAGE_ZIP_FILE_NAME)
+        };
+        let path = dir.join(file_name);
+        if std::fs::read(&path).is_ok_and(|content| matches_hash(&content, &file.content_hash)) {
+            debug!(package = name, version = %version, "Offered package already staged");
+            return Ok(false);
+        }
+
+        let content = self.client.download(file)?;
+        if !matches_hash(&content, &file.content_hash) {
+            return Err(PackageSyncError::ContentHash);
+        }
+        let io_error = |path: &Path, err| PackageSyncError::Io(path.display().to_string(), err);
+        std::fs::create_dir_all(&dir).map_err(|err| io_error(&dir, err))?;
+        let staging_path = path.with_extension("new");
+        std::fs::write(&staging_path, &content).map_err(|err| io_error(&staging_path, err))?;
+        std::fs::rename(&staging_path, &path).map_err(|err| io_error(&path, err))?;
+        // The downloader prefers the tar.gz package, so a previous one in the other format is
+        // removed.
+        _ = std::fs::remove_file(dir.join(other_file_name));
+        info!(package = name, version = %version, "Offered package staged");
+        Ok(true)
+    }
+}
+
+impl<C> PackagesSyncer for OfflinePackagesSyncer<C>
+where
+    C: PackageFileClient,
+{
+    fn sync(&self, packages_available: &PackagesAvailable) -> SyncedPackages {
+        let mut synced_hash = self.synced_hash.lock().expect("synced hash lock poisoned");
+        let mut synced = SyncedPackages {
+            statuses: PackageStatuses {
+                server_provided_all_packages_hash: packages_available.all_packages_hash.clone(),
+                ..Default::default()
+            },
+            updated: false,
+        };
+
+        let unchanged = synced_hash.as_ref() == Some(&packages_available.all_packages_hash);
+        for (name, package) in &packages_available.packages {
+            let mut status = PackageStatus {
+                name: name.clone(),
+                server_offered_version: package.version.clone(),
+                server_offered_hash: package.hash.clone(),
+                ..Default::default()
+            };
+            let staged = if unchanged {
+                Ok(false)
+            } else {
+                self.stage(name, package)
+            };
+            match staged {
+                Ok(updated) => {
+                    synced.updated |= updated;
+                    status.agent_has_version = package.version.clone();
+                    status.agent_has_hash = package.hash.clone();
+                    status.status = PackageStatusEnum::Installed.into();
+                }
+                Err(err) => {
+                    status.status = PackageStatusEnum::InstallFailed.into();
+                    status.error_message = err.to_string();
+                }
+            }
+            synced.statuses.packages.insert(name.clone(), status);
+        }
+
+        let failed = synced
+            .statuses
+            .packages
+            .values()
+            .any(|status| status.status == i32::from(PackageStatusEnum::InstallFailed));
+        *synced_hash = (!failed).then(|| packages_available.all_packages_hash.clone());
+        synced
+    }
+}
+
+/// Returns whether `content` has the SHA-256 digest `hash`.
+fn matches_hash(content: &[u8], hash: &[u8]) -> bool {
+    digest(&SHA256, content).as_ref() == hash
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use assert_matches::assert_matches;
+    use tempfile::TempDir;
+
+    const PACKAGE_NAME: &str = "newrelic/infra";
+
+    fn offer(content: &[u8], content_type: &str) -> PackagesAvailable {
+        PackagesAvailable {
+            packages: [(
+                PACKAGE_NAME.to_string(),
+                PackageAvailable {
+                    version: "1.0.0".to_string(),
+                    file: Some(DownloadableFile {
+                        download_url: "https://packages.example.com/infra-1.0.0".to_string(),
+                        content_type: content_type.to_string(),
+                        content_hash: digest(&SHA256, content).as_ref().to_vec(),
+                        ..Default::default()
+                    }),
+                    hash: b"package-hash".to_vec(),
+                    ..Default::default()
+                },
+            )]
+            .into(),
+            all_packages_hash: b"all-packages-hash".to_vec(),
+        }
+    }
+
+    fn status(synced: &SyncedPackages) -> &PackageStatus {
+        &synced.statuses.packages[PACKAGE_NAME]
+    }
+
+    #[test]
+    fn test_sync_stages_offered_package() {
+        let tmp_dir = TempDir::new().unwrap();
+        let mut client = MockPackageFileClient::new();
+        client
+            .expect_download()
+            .once()
+            .returning(|_| Ok(b"package".to_vec()));
+        let syncer = OfflinePackagesSyncer::new(client, tmp_dir.path());
+        let packages_available = offer(b"package", "application/gzip");
+
+        let synced = syncer.sync(&packages_available);
+
+        assert!(synced.updated);
+        assert_eq!(
+            status(&synced).status,
+            i32::from(PackageStatusEnum::Installed)
+        );
+        assert_eq!(status(&synced).agent_has_version, "1.0.0");
+        assert_eq!(
+            synced.statuses.server_provided_all_packages_hash,
+            b"all-packages-hash"
+        );
+        let path = offline_packages_dir(tmp_dir.path()).join("newrelic/infra/1.0.0/package.tar.gz");
+        assert_eq!(std::fs::read(path).unwrap(), b"package");
+
+        // The same offer is not downloaded again
+        let synced = syncer.sync(&packages_available);
+        assert!(!synced.updated);
+        assert_eq!(
+            status(&synced).status,
+            i32::from(PackageStatusEnum::Installed)
+        );
+    }
+
+    #[test]
+    fn test_sync_replaces_package_format() {
+        let tmp_dir = TempDir::new().unwrap();
+        let dir = offline_packages_dir(tmp_dir.path()).join("newrelic/infra/1.0.0");
+        std::fs::create_dir_all(&dir).unwrap();
+        std::fs::write(dir.join(PACKAGE_TAR_GZ_FILE_NAME), b"old").unwrap();
+        let mut client = MockPackageFileClient::new();
+        client
+            .expect_download()
+            .once()
+            .returning(|_| Ok(b"package".to_vec()));
+        let syncer = OfflinePackagesSyncer::new(client, tmp_dir.path());
+
+        assert!(syncer.sync(&offer(b"package", "application/zip")).updated);
+
+        assert_eq!(
+            std::fs::read(dir.join(PACKAGE_ZIP_FILE_NAME)).unwrap(),
+            b"package"
+        );
+        assert!(!dir.join(PACKAGE_TAR_GZ_FILE_NAME).exists());
+    }
+
+    #[test]
+    fn test_sync_rejects_tampered_package() {
+        let tmp_dir = TempDir::new().unwrap();
+        let mut client = MockPackageFileClient::new();
+        client
+            .expect_download()
+            .times(2)
+            .returning(|_| Ok(b"tampered".to_vec()));
+        let syncer = OfflinePackagesSyncer::new(client, tmp_dir.path());
+        let packages_available = offer(b"package", "application/gzip");
+
+        let synced = syncer.sync(&packages_available);
+
+        assert!(!synced.updated);
+        assert_eq!(
+            status(&synced).status,
+            i32::from(PackageStatusEnum::InstallFailed)
+        );
+        assert!(status(&synced).agent_has_version.is_empty());
+        assert!(!offline_packages_dir(tmp_dir.path()).exists());
+        assert_matches!(
+            syncer.stage(PACKAGE_NAME, &packages_available.packages[PACKAGE_NAME]),
+            Err(PackageSyncError::ContentHash)
+        );
+    }
+
+    #[test]
+    fn test_sync_rejects_invalid_names() {
+        let tmp_dir = TempDir::new().unwrap();
+        let syncer = OfflinePackagesSyncer::new(MockPackageFileClient::new(), tmp_dir.path());
+        let package = offer(b"package", "application/gzip").packages[PACKAGE_NAME].clone();
+
+        assert_matches!(
+            syncer.stage("newrelic/../../etc", &package),
+            Err(PackageSyncError::InvalidName(_))
+        );
+        assert_matches!(
+            syncer.stage("NewRelic/infra", &package),
+            Err(PackageSyncError::InvalidName(_))
+        );
+        assert_matches!(
+            syncer.stage(
+                PACKAGE_NAME,
+                &PackageAvailable {
+                    file: None,
+                    ..package
+                }
+            ),
+            Err(PackageSyncError::MissingFile)
+        );
+    }
+}
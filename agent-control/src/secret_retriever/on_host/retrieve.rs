@@ -96,8 +96,15 @@ mod tests {
                 provider,
                 retries: 0,
             }),
+            enrollment: None,
             fleet_id: "".to_string(),
             signature_validation: Default::default(),
+            network_wait: Default::default(),
+            dns: Default::default(),
+            pinned_public_keys: Default::default(),
+            request_signing: Default::default(),
+            tls: Default::default(),
+            proxy: None,
         }
     }
 
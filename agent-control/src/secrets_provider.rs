@@ -1,5 +1,7 @@
-//! Secrets providers: retrieve secrets from various sources (env vars, files, Kubernetes, Vault).
+//! Secrets providers: retrieve secrets from various sources (env vars, files, Kubernetes, Vault,
+//! AWS Secrets Manager).
 
+pub mod aws_secrets_manager;
 pub mod env;
 pub mod file;
 pub mod k8s_secret;
@@ -7,6 +9,9 @@ pub mod vault;
 
 use crate::agent_type::variable::namespace::Namespace;
 use crate::k8s::client::{K8sClient, SyncK8sClient};
+use crate::secrets_provider::aws_secrets_manager::{
+    AwsSecretsManager, AwsSecretsManagerConfig, AwsSecretsManagerError,
+};
 use crate::secrets_provider::env::{Env, EnvError};
 use crate::secrets_provider::file::{FileSecretProvider, FileSecretProviderError};
 use crate::secrets_provider::k8s_secret::{K8sSecretProvider, K8sSecretProviderError};
@@ -41,6 +46,8 @@ use std::sync::Arc;
 pub struct SecretsProvidersConfig {
     /// Optional configuration for the HashiCorp Vault provider.
     pub vault: Option<VaultConfig>,
+    /// Optional configuration for the AWS Secrets Manager provider.
+    pub aws_secrets_manager: Option<AwsSecretsManagerConfig>,
 }
 
 /// Errors returned by the configured secrets providers.
@@ -50,6 +57,10 @@ pub enum SecretsProvidersError {
     #[error("vault provider failed: {0}")]
     VaultError(#[from] VaultError),
 
+    /// The AWS Secrets Manager provider failed.
+    #[error("aws secrets manager provider failed: {0}")]
+    AwsSecretsManagerError(#[from] AwsSecretsManagerError),
+
     /// The Kubernetes secret provider failed.
     #[error("k8s secret provider failed: {0}")]
     K8sSecretProviderError(#[from] K8sSecretProviderError),
@@ -85,6 +96,8 @@ pub trait SecretsProvider {
 pub enum SecretsProviderType<C: K8sClient = SyncK8sClient> {
     /// Secrets retrieved from HashiCorp Vault.
     Vault(Vault),
+    /// Secrets retrieved from AWS Secrets Manager.
+    AwsSecretsManager(AwsSecretsManager),
     /// Secrets retrieved from Kubernetes secrets.
     K8sSecret(K8sSecretProvider<C>),
     /// Secrets retrieved from the local filesystem.
@@ -99,6 +112,9 @@ impl<C: K8sClient> SecretsProvider for SecretsProviderType<C> {
     fn get_secret(&self, secret_path: &str) -> Result<String, Self::Error> {
         match self {
             SecretsProviderType::Vault(provider) => Ok(provider.get_secret(secret_path)?),
+            SecretsProviderType::AwsSecretsManager(provider) => {
+                Ok(provider.get_secret(secret_path)?)
+            }
             SecretsProviderType::K8sSecret(provider) => Ok(provider.get_secret(secret_path)?),
             SecretsProviderType::File(provider) => Ok(provider.get_secret(secret_path)?),
             SecretsProviderType::Env(provider) => Ok(provider.get_secret(secret_path)?),
@@ -153,7 +169,7 @@ impl Registry<SecretsProviderType> {
         self
     }
 
-    /// Registers providers derived from the given configuration (currently Vault).
+    /// Registers providers derived from the given configuration (Vault and AWS Secrets Manager).
     pub fn with_config(
         mut self,
         config: SecretsProvidersConfig,
@@ -163,6 +179,13 @@ impl Registry<SecretsProviderType> {
             self.0
                 .insert(Namespace::Vault, SecretsProviderType::Vault(vault));
         }
+        if let Some(aws_config) = config.aws_secrets_manager {
+            let aws_secrets_manager = AwsSecretsManager::try_build(aws_config)?;
+            self.0.insert(
+                Namespace::AwsSecretsManager,
+                SecretsProviderType::AwsSecretsManager(aws_secrets_manager),
+            );
+        }
         Ok(self)
     }
 }
@@ -0,0 +1,586 @@
+//! Secrets provider that reads secrets from AWS Secrets Manager.
+//!
+//! Secrets are referenced as `source:secret_name`, or `source:secret_name:key` to get a key of a
+//! secret holding a JSON object. The secret can also be referenced by its ARN, as in
+//! `source:arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf:key`. Requests are
+//! signed with AWS Signature Version 4 using the credentials of the source or, if not set, the
+//! ones of the standard AWS credential provider chain (see [credentials]).
+
+mod credentials;
+
+use super::SecretsProvider;
+use super::vault::ClientTimeout;
+use crate::http::client::{HttpBuildError, HttpClient, HttpResponseError};
+use crate::http::config::{HttpConfig, ProxyConfig};
+use aws_lc_rs::digest::{SHA256, digest};
+use aws_lc_rs::hmac;
+use chrono::{DateTime, Utc};
+use credentials::CredentialsChain;
+use http::Request;
+use serde::Deserialize;
+use serde_json::{Value, json};
+use std::collections::HashMap;
+use std::fmt::{self, Debug, Formatter};
+use std::str::FromStr;
+use thiserror::Error;
+use url::Url;
+
+const SERVICE: &str = "secretsmanager";
+const SIGNING_ALGORITHM: &str = "AWS4-HMAC-SHA256";
+const CONTENT_TYPE: &str = "application/x-amz-json-1.1";
+const GET_SECRET_VALUE_TARGET: &str = "secretsmanager.GetSecretValue";
+/// Replacement of secret values when printed.
+const REDACTED: &str = "<redacted>";
+
+/// Enumerates the possible errors that can occur when interacting with AWS Secrets Manager.
+#[derive(Debug, Error)]
+pub enum AwsSecretsManagerError {
+    /// The HTTP client could not be built.
+    #[error("could not build the aws secrets manager http client: {0}")]
+    HttpClient(#[from] HttpBuildError),
+
+    /// The secret path did not match the expected `source:secret_name[:key]` format.
+    #[error("secret path '{0}' does not have a valid format 'source:secret_name[:key]'")]
+    IncorrectSecretPath(String),
+
+    /// The requested secret source was not configured.
+    #[error("secret source not found")]
+    SourceNotFound,
+
+    /// There are no credentials to sign the requests.
+    #[error(
+        "missing aws credentials, set them in the source or in the environment, or run with an aws role"
+    )]
+    MissingCredentials,
+
+    /// The credentials of the credential provider chain could not be obtained.
+    #[error("could not get the aws credentials: {0}")]
+    Credentials(String),
+
+    /// The endpoint of the source is not valid.
+    #[error("invalid endpoint: {0}")]
+    InvalidEndpoint(String),
+
+    /// The request could not be built.
+    #[error("could not build the request: {0}")]
+    BuildingError(String),
+
+    /// The secret, or the key within it, was not found.
+    #[error("secret not found in the specified source")]
+    NotFound,
+
+    /// The response body could not be deserialized.
+    #[error("unable to deserialize body: {0}")]
+    DeserializeError(String),
+
+    /// The source url could not be reached.
+    #[error("source url can't be reached: {0}")]
+    ConnectionFailed(String),
+
+    /// A generic, unclassified AWS Secrets Manager error.
+    #[error("{0}")]
+    GenericError(String),
+}
+
+/// Represents a path to a secret in AWS Secrets Manager.
+#[derive(Clone, Debug, PartialEq, Eq)]
+pub struct AwsSecretPath {
+    /// Name of the configured source.
+    pub source: String,
+    /// Name of the secret.
+    pub name: String,
+    /// Key within the secret, when it holds a JSON object.
+    pub key: Option<String>,
+}
+
+/// Number of `:` separated parts of a secret ARN:
+/// `arn:partition:secretsmanager:region:account:secret:name`.
+const SECRET_ARN_PARTS: usize = 7;
+
+impl FromStr for AwsSecretPath {
+    type Err = AwsSecretsManagerError;
+
+    fn from_str(secret_path: &str) -> Result<Self, Self::Err> {
+        let incorrect = || AwsSecretsManagerError::IncorrectSecretPath(secret_path.to_string());
+        let parts: Vec<&str> = secret_path.split(':').collect();
+        if parts.iter().any(|p| p.is_empty()) {
+            return Err(incorrect());
+        }
+
+        // The ARN of the secret has its own ':' separated parts.
+        let name_parts = if parts.get(1) == Some(&"arn") {
+            if parts.get(3) != Some(&"secretsmanager") || parts.get(6) != Some(&"secret") {
+                return Err(incorrect());
+            }
+            SECRET_ARN_PARTS
+        } else {
+            1
+        };
+        let key_index = 1 + name_parts;
+        if !(key_index..=key_index + 1).contains(&parts.len()) {
+            return Err(incorrect());
+        }
+
+        Ok(AwsSecretPath {
+            source: parts[0].to_string(),
+            name: parts[1..key_index].join(":"),
+            key: parts.get(key_index).map(|key| key.to_string()),
+        })
+    }
+}
+
+/// AWS access keys.
+#[derive(Deserialize, PartialEq, Clone)]
+pub struct AwsCredentials {
+    access_key_id: String,
+    secret_access_key: String,
+    #[serde(default)]
+    session_token: Option<String>,
+    /// Expiration of temporary credentials.
+    #[serde(skip)]
+    expiration: Option<DateTime<Utc>>,
+}
+
+impl Debug for AwsCredentials {
+    fn fmt(&self, f: &mut Formatter<'_>) -> fmt::Result {
+        f.debug_struct("AwsCredentials")
+            .field("access_key_id", &self.access_key_id)
+            .field("secret_access_key", &REDACTED)
+            .field(
+                "session_token",
+                &self.session_token.as_ref().map(|_| REDACTED),
+            )
+            .field("expiration", &self.expiration)
+            .finish()
+    }
+}
+
+/// Configuration for an AWS Secrets Manager source.
+#[derive(Debug, Deserialize, PartialEq, Clone)]
+pub struct AwsSecretsManagerSourceConfig {
+    region: String,
+    /// Overrides the regional endpoint, e.g. for VPC endpoints.
+    #[serde(default)]
+    endpoint: Option<Url>,
+    /// Static credentials. If not set, they are resolved with the AWS credential provider chain.
+    #[serde(default)]
+    credentials: Option<AwsCredentials>,
+}
+
+/// Configuration for the AWS Secrets Manager provider: a set of named sources and HTTP client
+/// settings.
+#[derive(Debug, Deserialize, PartialEq, Clone, Default)]
+pub struct AwsSecretsManagerConfig {
+    pub(crate) sources: HashMap<String, AwsSecretsManagerSourceConfig>,
+
+    /// Client timeout
+    #[serde(default)]
+    pub(crate) client_timeout: ClientTimeout,
+
+    /// Proxy configuration used by the HTTP client.
+    #[serde(skip)]
+    pub proxy_config: ProxyConfig,
+}
+
+/// A configured source: the endpoint, region and credentials used to sign its requests.
+struct AwsSecretsManagerSource {
+    endpoint: Url,
+    region: String,
+    credentials: Option<AwsCredentials>,
+}
+
+impl AwsSecretsManagerSource {
+    fn try_new(config: AwsSecretsManagerSourceConfig) -> Result<Self, AwsSecretsManagerError> {
+        let endpoint = match config.endpoint {
+            Some(endpoint) => endpoint,
+            None => Url::parse(&format!(
+                "https://{SERVICE}.{}.amazonaws.com/",
+                config.region
+            ))
+            .map_err(|err| AwsSecretsManagerError::InvalidEndpoint(err.to_string()))?,
+        };
+        if endpoint.host_str().is_none() {
+            return Err(AwsSecretsManagerError::InvalidEndpoint(
+                endpoint.to_string(),
+            ));
+        }
+
+        Ok(Self {
+            endpoint,
+            region: config.region,
+            credentials: config.credentials,
+        })
+    }
+
+    /// Builds the `GetSecretValue` request of the given secret, signed with `credentials`.
+    fn request(
+        &self,
+        secret_name: &str,
+        credentials: &AwsCredentials,
+        now: DateTime<Utc>,
+    ) -> Result<Request<Vec<u8>>, AwsSecretsManagerError> {
+        let body = json!({ "SecretId": secret_name }).to_string().into_bytes();
+        let amz_date = now.format("%Y%m%dT%H%M%SZ").to_string();
+        let mut headers = vec![
+            ("content-type", CONTENT_TYPE.to_string()),
+            ("host", host(&self.endpoint)),
+            ("x-amz-date", amz_date.clone()),
+            ("x-amz-target", GET_SECRET_VALUE_TARGET.to_string()),
+        ];
+        if let Some(session_token) = &credentials.session_token {
+            headers.push(("x-amz-security-token", session_token.clone()));
+        }
+        // Canonical headers must be sorted by name.
+        headers.sort();
+
+        let signed_headers = headers
+            .iter()
+            .map(|(name, _)| *name)
+            .collect::<Vec<_>>()
+            .join(";");
+        let canonical_headers: String = headers
+            .iter()
+            .map(|(name, value)| format!("{name}:{}\n", value.trim()))
+            .collect();
+        let canonical_request = format!(
+            "POST\n{}\n\n{canonical_headers}\n{signed_headers}\n{}",
+            self.endpoint.path(),
+            hex(digest(&SHA256, &body).as_ref())
+        );
+
+        let date = now.format("%Y%m%d").to_string();
+        let scope = format!("{date}/{}/{SERVICE}/aws4_request", self.region);
+        let string_to_sign = format!(
+            "{SIGNING_ALGORITHM}\n{amz_date}\n{scope}\n{}",
+            hex(digest(&SHA256, canonical_request.as_bytes()).as_ref())
+        );
+        let signing_key = hmac::Key::new(
+            hmac::HMAC_SHA256,
+            &signing_key(&credentials.secret_access_key, &date, &self.region, SERVICE),
+        );
+        let signature = hex(hmac::sign(&signing_key, string_to_sign.as_bytes()).as_ref());
+
+        let mut builder = Request::builder()
+            .method("POST")
+            .uri(self.endpoint.as_str());
+        for (name, value) in headers.iter().filter(|(name, _)| *name != "host") {
+            builder = builder.header(*name, value);
+        }
+        builder
+            .header(
+                "authorization",
+                format!(
+                    "{SIGNING_ALGORITHM} Credential={}/{scope}, SignedHeaders={signed_headers}, Signature={signature}",
+                    credentials.access_key_id
+                ),
+            )
+            .body(body)
+            .map_err(|err| AwsSecretsManagerError::BuildingError(err.to_string()))
+    }
+}
+
+/// Represents the data structure of the `GetSecretValue` responses. Used for deserialization.
+#[derive(Deserialize)]
+struct SecretValue {
+    #[serde(rename = "SecretString")]
+    secret_string: Option<String>,
+}
+
+/// Represents an AWS Secrets Manager client, including HTTP client and configured sources.
+pub struct AwsSecretsManager {
+    client: HttpClient,
+    sources: HashMap<String, AwsSecretsManagerSource>,
+    credentials_chain: CredentialsChain,
+}
+
+impl AwsSecretsManager {
+    /// Attempts to build an AWS Secrets Manager instance from the given configuration.
+    pub fn try_build(config: AwsSecretsManagerConfig) -> Result<Self, AwsSecretsManagerError> {
+        let http_config = HttpConfig::new(
+            config.client_timeout.clone().into(),
+            config.client_timeout.into(),
+            config.proxy_config,
+        );
+
+        let sources = config
+            .sources
+            .into_iter()
+            .map(|(source_name, source_config)| {
+                Ok((
+                    source_name,
+                    AwsSecretsManagerSource::try_new(source_config)?,
+                ))
+            })
+            .collect::<Result<HashMap<_, _>, AwsSecretsManagerError>>()?;
+
+        Ok(Self {
+            client: HttpClient::new(http_config.clone())?,
+            sources,
+            credentials_chain: CredentialsChain::try_new(http_config)?,
+        })
+    }
+}
+
+impl SecretsProvider for AwsSecretsManager {
+    type Error = AwsSecretsManagerError;
+
+    fn get_secret(&self, secret_path: &str) -> Result<String, Self::Error> {
+        let AwsSecretPath { source, name, key } = AwsSecretPath::from_str(secret_path)?;
+
+        let source = self
+            .sources
+            .get(&source)
+            .ok_or(AwsSecretsManagerError::SourceNotFound)?;
+
+        let credentials = match &source.credentials {
+            Some(credentials) => credentials.clone(),
+            None => self.credentials_chain.credentials()?,
+        };
+
+        let response = self
+            .client
+            .send(source.request(&name, &credentials, Utc::now())?)
+            .map_err(|err| match err {
+                HttpResponseError::UnsuccessfulResponse { body, .. }
+                    if String::from_utf8_lossy(&body).contains("ResourceNotFoundException") =>
+                {
+                    AwsSecretsManagerError::NotFound
+                }
+                HttpResponseError::UnsuccessfulResponse { status_code, .. } => {
+                    AwsSecretsManagerError::GenericError(format!(
+                        "aws secrets manager responded with status: {status_code}"
+                    ))
+                }
+                err => AwsSecretsManagerError::ConnectionFailed(err.to_string()),
+            })?;
+
+        let secret_value: SecretValue = serde_json::from_slice(response.body())
+            .map_err(|err| AwsSecretsManagerError::DeserializeError(err.to_string()))?;
+        let secret = secret_value
+            .secret_string
+            .ok_or(AwsSecretsManagerError::NotFound)?;
+
+        let Some(key) = key else {
+            return Ok(secret);
+        };
+        let values: HashMap<String, Value> = serde_json::from_str(&secret)
+            .map_err(|err| AwsSecretsManagerError::DeserializeError(err.to_string()))?;
+        match values.get(&key) {
+            Some(Value::String(value)) => Ok(value.clone()),
+            Some(value) => Ok(value.to_string()),
+            None => Err(AwsSecretsManagerError::NotFound),
+        }
+    }
+}
+
+/// Derives the Signature Version 4 signing key of the day, region and service.
+fn signing_key(secret_access_key: &str, date: &str, region: &str, service: &str) -> Vec<u8> {
+    [date, region, service, "aws4_request"].iter().fold(
+        format!("AWS4{secret_access_key}").into_bytes(),
+        |key, data| {
+            hmac::sign(&hmac::Key::new(hmac::HMAC_SHA256, &key), data.as_bytes())
+                .as_ref()
+                .to_vec()
+        },
+    )
+}
+
+/// Value of the `host` header, including the port when it's not the default one.
+fn host(url: &Url) -> String {
+    let host = url.host_str().unwrap_or_default();
+    match url.port() {
+        Some(port) => format!("{host}:{port}"),
+        None => host.to_string(),
+    }
+}
+
+fn hex(bytes: &[u8]) -> String {
+    bytes.iter().map(|b| format!("{b:02x}")).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use assert_matches::assert_matches;
+    use httpmock::Method::POST;
+    use httpmock::MockServer;
+    use rstest::rstest;
+
+    fn provider(endpoint: &str) -> AwsSecretsManager {
+        let config = format!(
+            r#"
+sources:
+  prod:
+    region: us-east-1
+    endpoint: {endpoint}
+    credentials:
+      access_key_id: AKIDEXAMPLE
+      secret_access_key: wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY
+client_timeout: 3s
+"#
+        );
+        AwsSecretsManager::try_build(serde_saphyr::from_str(&config).unwrap()).unwrap()
+    }
+
+    #[rstest]
+    #[case::name("prod:db-password", "db-password", None)]
+    #[case::name_with_path("prod:team/db:password", "team/db", Some("password"))]
+    #[case::arn(
+        "prod:arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf",
+        "arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf",
+        None
+    )]
+    #[case::arn_with_key(
+        "prod:arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf:password",
+        "arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf",
+        Some("password")
+    )]
+    fn test_secret_path(#[case] path: &str, #[case] name: &str, #[case] key: Option<&str>) {
+        let secret_path = AwsSecretPath::from_str(path).unwrap();
+        assert_eq!(secret_path.source, "prod");
+        assert_eq!(secret_path.name, name);
+        assert_eq!(secret_path.key.as_deref(), key);
+    }
+
+    #[rstest]
+    #[case::missing_name("prod")]
+    #[case::empty_name("prod::key")]
+    #[case::too_many_parts("prod:name:key:other")]
+    #[case::incomplete_arn("prod:arn:aws:secretsmanager:us-east-1:123456789012:secret")]
+    #[case::not_a_secret_arn("prod:arn:aws:ssm:us-east-1:123456789012:parameter:db")]
+    #[case::arn_too_many_parts(
+        "prod:arn:aws:secretsmanager:us-east-1:123456789012:secret:db:key:other"
+    )]
+    fn test_invalid_secret_path(#[case] path: &str) {
+        assert_matches!(
+            AwsSecretPath::from_str(path),
+            Err(AwsSecretsManagerError::IncorrectSecretPath(_))
+        );
+    }
+
+    #[test]
+    fn test_signing_key() {
+        // Example of the AWS documentation on deriving a signing key.
+        let key = signing_key(
+            "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+            "20120215",
+            "us-east-1",
+            "iam",
+        );
+        assert_eq!(
+            hex(&key),
+            "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+        );
+    }
+
+    #[test]
+    fn test_request_is_signed() {
+        let source = AwsSecretsManagerSource::try_new(AwsSecretsManagerSourceConfig {
+            region: "eu-west-1".to_string(),
+            endpoint: None,
+            credentials: None,
+        })
+        .unwrap();
+        let credentials = AwsCredentials {
+            access_key_id: "AKIDEXAMPLE".to_string(),
+            secret_access_key: "secret".to_string(),
+            session_token: Some("token".to_string()),
+            expiration: None,
+        };
+        let now = DateTime::parse_from_rfc3339("2025-01-02T03:04:05Z")
+            .unwrap()
+            .to_utc();
+
+        let request = source.request("db-password", &credentials, now).unwrap();
+
+        assert_eq!(
+            request.uri().to_string(),
+            "https://secretsmanager.eu-west-1.amazonaws.com/"
+        );
+        assert_eq!(request.headers()["x-amz-date"], "20250102T030405Z");
+        assert_eq!(request.headers()["x-amz-target"], GET_SECRET_VALUE_TARGET);
+        assert_eq!(request.headers()["x-amz-security-token"], "token");
+        let authorization = request.headers()["authorization"].to_str().unwrap();
+        assert!(authorization.starts_with(
+            "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20250102/eu-west-1/secretsmanager/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature="
+        ));
+        assert_eq!(request.body(), br#"{"SecretId":"db-password"}"#);
+
+        // Signing is deterministic for the same request and time
+        assert_eq!(
+            source
+                .request("db-password", &credentials, now)
+                .unwrap()
+                .headers()["authorization"],
+            authorization
+        );
+    }
+
+    #[test]
+    fn test_get_secrets() {
+        let server = MockServer::start();
+        server.mock(|when, then| {
+            when.method(POST)
+                .path("/")
+                .header("x-amz-target", GET_SECRET_VALUE_TARGET)
+                .body(r#"{"SecretId":"db-password"}"#);
+            then.status(200)
+                .body(r#"{"Name":"db-password","SecretString":"s3cr3t"}"#);
+        });
+        server.mock(|when, then| {
+            when.method(POST).path("/").body(r#"{"SecretId":"db"}"#);
+            then.status(200)
+                .body(r#"{"Name":"db","SecretString":"{\"user\":\"admin\",\"port\":5432}"}"#);
+        });
+        server.mock(|when, then| {
+            when.method(POST).path("/").body(r#"{"SecretId":"missing"}"#);
+            then.status(400).body(
+                r#"{"__type":"ResourceNotFoundException","Message":"Secrets Manager can't find the specified secret."}"#,
+            );
+        });
+        server.mock(|when, then| {
+            when.method(POST)
+                .path("/")
+                .body(r#"{"SecretId":"forbidden"}"#);
+            then.status(400)
+                .body(r#"{"__type":"AccessDeniedException"}"#);
+        });
+
+        let provider = provider(&server.base_url());
+
+        assert_eq!(provider.get_secret("prod:db-password").unwrap(), "s3cr3t");
+        assert_eq!(provider.get_secret("prod:db:user").unwrap(), "admin");
+        assert_eq!(provider.get_secret("prod:db:port").unwrap(), "5432");
+        assert_matches!(
+            provider.get_secret("prod:db:password"),
+            Err(AwsSecretsManagerError::NotFound)
+        );
+        assert_matches!(
+            provider.get_secret("prod:missing"),
+            Err(AwsSecretsManagerError::NotFound)
+        );
+        assert_matches!(
+            provider.get_secret("prod:forbidden"),
+            Err(AwsSecretsManagerError::GenericError(m)) if m.contains("400")
+        );
+        assert_matches!(
+            provider.get_secret("staging:db-password"),
+            Err(AwsSecretsManagerError::SourceNotFound)
+        );
+    }
+
+    #[test]
+    fn test_debug_redacts_credentials() {
+        let credentials = AwsCredentials {
+            access_key_id: "AKIDEXAMPLE".to_string(),
+            secret_access_key: "some-secret".to_string(),
+            session_token: Some("some-token".to_string()),
+            expiration: None,
+        };
+
+        let debug = format!("{credentials:?}");
+        assert!(debug.contains("AKIDEXAMPLE"));
+        assert!(!debug.contains("some-secret"));
+        assert!(!debug.contains("some-token"));
+    }
+}
@@ -0,0 +1,485 @@
+//! Credentials of the sources without static ones, resolved with the standard AWS credential
+//! provider chain, in order:
+//!
+//! 1. The `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY` and `AWS_SESSION_TOKEN` environment
+//!    variables.
+//! 2. Web identity (e.g. IRSA on EKS): the token in `AWS_WEB_IDENTITY_TOKEN_FILE` is exchanged
+//!    for the credentials of `AWS_ROLE_ARN` with STS.
+//! 3. Container credentials (e.g. ECS task roles), from `AWS_CONTAINER_CREDENTIALS_RELATIVE_URI`
+//!    or `AWS_CONTAINER_CREDENTIALS_FULL_URI`.
+//! 4. The instance profile, from the EC2 instance metadata service (IMDSv2).
+//!
+//! Temporary credentials are cached and renewed shortly before they expire.
+use super::{AwsCredentials, AwsSecretsManagerError};
+use crate::http::client::HttpClient;
+use crate::http::config::{HttpConfig, ProxyConfig};
+use chrono::{DateTime, TimeDelta, Utc};
+use http::{Method, Request};
+use serde::Deserialize;
+use serde_json::Value;
+use std::sync::Mutex;
+use std::time::Duration;
+use url::Url;
+use url::form_urlencoded::Serializer;
+
+const IMDS_ENDPOINT: &str = "http://169.254.169.254/";
+const ECS_ENDPOINT: &str = "http://169.254.170.2/";
+const GLOBAL_STS_ENDPOINT: &str = "https://sts.amazonaws.com/";
+const IMDS_TOKEN_HEADER: &str = "x-aws-ec2-metadata-token";
+const IMDS_TOKEN_TTL_HEADER: &str = "x-aws-ec2-metadata-token-ttl-seconds";
+const IMDS_TOKEN_TTL_SECS: &str = "21600";
+const DEFAULT_ROLE_SESSION_NAME: &str = "newrelic-agent-control";
+/// Timeout of the requests to the metadata services, which are local to the host.
+const METADATA_TIMEOUT: Duration = Duration::from_secs(2);
+/// Cached credentials are renewed when they expire in less than this.
+const EXPIRATION_MARGIN: TimeDelta = TimeDelta::minutes(5);
+
+/// Resolves and caches the credentials of the standard AWS credential provider chain.
+pub(super) struct CredentialsChain {
+    sts_client: HttpClient,
+    metadata_client: HttpClient,
+    imds_endpoint: Url,
+    ecs_endpoint: Url,
+    sts_endpoint: Option<Url>,
+    cached: Mutex<Option<AwsCredentials>>,
+}
+
+/// Temporary credentials served by the container and instance metadata services.
+#[derive(Deserialize)]
+#[serde(rename_all = "PascalCase")]
+struct MetadataCredentials {
+    access_key_id: String,
+    secret_access_key: String,
+    token: String,
+    #[serde(default)]
+    expiration: Option<Value>,
+}
+
+/// Temporary credentials returned by STS.
+#[derive(Deserialize)]
+#[serde(rename_all = "PascalCase")]
+struct StsCredentials {
+    access_key_id: String,
+    secret_access_key: String,
+    session_token: String,
+    #[serde(default)]
+    expiration: Option<Value>,
+}
+
+impl CredentialsChain {
+    /// Builds the chain. STS is reached with `http_config`, and the metadata services, which are
+    /// local to the host, without proxy.
+    pub(super) fn try_new(http_config: HttpConfig) -> Result<Self, AwsSecretsManagerError> {
+        Ok(Self {
+            sts_client: HttpClient::new(http_config)?,
+            metadata_client: HttpClient::new(HttpConfig::new(
+                METADATA_TIMEOUT,
+                METADATA_TIMEOUT,
+                ProxyConfig::default(),
+            ))?,
+            imds_endpoint: Url::parse(IMDS_ENDPOINT).expect("valid imds endpoint"),
+            ecs_endpoint: Url::parse(ECS_ENDPOINT).expect("valid ecs endpoint"),
+            sts_endpoint: None,
+            cached: Mutex::new(None),
+        })
+    }
+
+    /// Returns the credentials of the first provider of the chain that has them.
+    pub(super) fn credentials(&self) -> Result<AwsCredentials, AwsSecretsManagerError> {
+        self.credentials_with(&|name| std::env::var(name).ok(), Utc::now())
+    }
+
+    fn credentials_with(
+        &self,
+        env: &dyn Fn(&str) -> Option<String>,
+        now: DateTime<Utc>,
+    ) -> Result<AwsCredentials, AwsSecretsManagerError> {
+        let mut cached = self.cached.lock().unwrap_or_else(|err| err.into_inner());
+        if let Some(credentials) = cached.as_ref().filter(|credentials| {
+            credentials
+                .expiration
+                .is_none_or(|expiration| expiration - EXPIRATION_MARGIN > now)
+        }) {
+            return Ok(credentials.clone());
+        }
+        let credentials = self.resolve(env)?;
+        *cached = Some(credentials.clone());
+        Ok(credentials)
+    }
+
+    fn resolve(
+        &self,
+        env: &dyn Fn(&str) -> Option<String>,
+    ) -> Result<AwsCredentials, AwsSecretsManagerError> {
+        if let (Some(access_key_id), Some(secret_access_key)) =
+            (env("AWS_ACCESS_KEY_ID"), env("AWS_SECRET_ACCESS_KEY"))
+        {
+            return Ok(AwsCredentials {
+                access_key_id,
+                secret_access_key,
+                session_token: env("AWS_SESSION_TOKEN"),
+                expiration: None,
+            });
+        }
+        if let (Some(token_file), Some(role_arn)) =
+            (env("AWS_WEB_IDENTITY_TOKEN_FILE"), env("AWS_ROLE_ARN"))
+        {
+            return self.web_identity(env, &token_file, &role_arn);
+        }
+        if env("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI").is_some()
+            || env("AWS_CONTAINER_CREDENTIALS_FULL_URI").is_some()
+        {
+            return self.container(env);
+        }
+        if env("AWS_EC2_METADATA_DISABLED").is_some_and(|disabled| disabled == "true") {
+            return Err(AwsSecretsManagerError::MissingCredentials);
+        }
+        // Outside EC2 the instance metadata service doesn't answer, nothing is configured.
+        self.instance_profile()
+            .map_err(|_| AwsSecretsManagerError::MissingCredentials)
+    }
+
+    /// Exchanges the web identity token for the credentials of the role.
+    fn web_identity(
+        &self,
+        env: &dyn Fn(&str) -> Option<String>,
+        token_file: &str,
+        role_arn: &str,
+    ) -> Result<AwsCredentials, AwsSecretsManagerError> {
+        let token = std::fs::read_to_string(token_file).map_err(|err| {
+            credentials_error(format!(
+                "reading the web identity token '{token_file}': {err}"
+            ))
+        })?;
+        let session_name =
+            env("AWS_ROLE_SESSION_NAME").unwrap_or_else(|| DEFAULT_ROLE_SESSION_NAME.to_string());
+        let endpoint = match &self.sts_endpoint {
+            Some(endpoint) => endpoint.to_string(),
+            None => env("AWS_REGION")
+                .or_else(|| env("AWS_DEFAULT_REGION"))
+                .map(|region| format!("https://sts.{region}.amazonaws.com/"))
+                .unwrap_or_else(|| GLOBAL_STS_ENDPOINT.to_string()),
+        };
+        let body = Serializer::new(String::new())
+            .append_pair("Action", "AssumeRoleWithWebIdentity")
+            .append_pair("Version", "2011-06-15")
+            .append_pair("RoleArn", role_arn)
+            .append_pair("RoleSessionName", &session_name)
+            .append_pair("WebIdentityToken", token.trim())
+            .finish();
+        let request = Request::builder()
+            .method(Method::POST)
+            .uri(endpoint)
+            .header("content-type", "application/x-www-form-urlencoded")
+            .header("accept", "application/json")
+            .body(body.into_bytes())
+            .map_err(|err| AwsSecretsManagerError::BuildingError(err.to_string()))?;
+        let response = self
+            .sts_client
+            .send(request)
+            .map_err(|err| credentials_error(format!("assuming role '{role_arn}': {err}")))?;
+
+        let response: Value = serde_json::from_slice(response.body())
+            .map_err(|err| AwsSecretsManagerError::DeserializeError(err.to_string()))?;
+        let credentials = response
+            .pointer(
+                "/AssumeRoleWithWebIdentityResponse/AssumeRoleWithWebIdentityResult/Credentials",
+            )
+            .cloned()
+            .ok_or_else(|| credentials_error("sts response without credentials".to_string()))?;
+        let credentials: StsCredentials = serde_json::from_value(credentials)
+            .map_err(|err| AwsSecretsManagerError::DeserializeError(err.to_string()))?;
+        Ok(AwsCredentials {
+            access_key_id: credentials.access_key_id,
+            secret_access_key: credentials.secret_access_key,
+            session_token: Some(credentials.session_token),
+            expiration: credentials.expiration.as_ref().and_then(parse_expiration),
+        })
+    }
+
+    /// Gets the credentials of the container role.
+    fn container(
+        &self,
+        env: &dyn Fn(&str) -> Option<String>,
+    ) -> Result<AwsCredentials, AwsSecretsManagerError> {
+        let url = match env("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") {
+            Some(relative_uri) => self.ecs_endpoint.join(&relative_uri),
+            None => Url::parse(&env("AWS_CONTAINER_CREDENTIALS_FULL_URI").unwrap_or_default()),
+        }
+        .map_err(|err| credentials_error(format!("invalid container credentials uri: {err}")))?;
+        let authorization = match env("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE") {
+            Some(token_file) => Some(
+                std::fs::read_to_string(&token_file)
+                    .map_err(|err| {
+                        credentials_error(format!(
+                            "reading the container authorization token '{token_file}': {err}"
+                        ))
+                    })?
+                    .trim()
+                    .to_string(),
+            ),
+            None => env("AWS_CONTAINER_AUTHORIZATION_TOKEN"),
+        };
+
+        let mut request = Request::builder().method(Method::GET).uri(url.as_str());
+        if let Some(authorization) = authorization {
+            request = request.header("authorization", authorization);
+        }
+        self.metadata_credentials(request)
+            .map_err(|err| credentials_error(format!("getting the container credentials: {err}")))
+    }
+
+    /// Gets the credentials of the role of the EC2 instance profile.
+    fn instance_profile(&self) -> Result<AwsCredentials, AwsSecretsManagerError> {
+        let token_request = Request::builder()
+            .method(Method::PUT)
+            .uri(self.imds_url("latest/api/token")?.as_str())
+            .header(IMDS_TOKEN_TTL_HEADER, IMDS_TOKEN_TTL_SECS)
+            .body(Vec::new())
+            .map_err(|err| AwsSecretsManagerError::BuildingError(err.to_string()))?;
+        let token = self
+            .metadata_client
+            .send(token_request)
+            .map_err(|err| credentials_error(format!("getting the imds token: {err}")))?;
+        let token = String::from_utf8_lossy(token.body()).trim().to_string();
+
+        let roles_url = self.imds_url("latest/meta-data/iam/security-credentials/")?;
+        let roles_request = Request::builder()
+            .method(Method::GET)
+            .uri(roles_url.as_str())
+            .header(IMDS_TOKEN_HEADER, &token)
+            .body(Vec::new())
+            .map_err(|err| AwsSecretsManagerError::BuildingError(err.to_string()))?;
+        let roles = self
+            .metadata_client
+            .send(roles_request)
+            .map_err(|err| credentials_error(format!("getting the instance profile: {err}")))?;
+        let roles = String::from_utf8_lossy(roles.body()).to_string();
+        let role = roles
+            .lines()
+            .map(str::trim)
+            .find(|role| !role.is_empty())
+            .ok_or_else(|| credentials_error("the instance has no instance profile".to_string()))?;
+
+        let request = Request::builder()
+            .method(Method::GET)
+            .uri(
+                self.imds_url(&format!("{}{role}", roles_url.path()))?
+                    .as_str(),
+            )
+            .header(IMDS_TOKEN_HEADER, &token);
+        self.metadata_credentials(request)
+            .map_err(|err| credentials_error(format!("getting the instance credentials: {err}")))
+    }
+
+    fn imds_url(&self, path: &str) -> Result<Url, AwsSecretsManagerError> {
+        self.imds_endpoint
+            .join(path)
+            .map_err(|err| AwsSecretsManagerError::InvalidEndpoint(err.to_string()))
+    }
+
+    /// Sends the request to a metadata service, parsing the credentials it returns.
+    fn metadata_credentials(
+        &self,
+        request: http::request::Builder,
+    ) -> Result<AwsCredentials, String> {
+        let request = request.body(Vec::new()).map_err(|err| err.to_string())?;
+        let response = self
+            .metadata_client
+            .send(request)
+            .map_err(|err| err.to_string())?;
+        let credentials: MetadataCredentials =
+            serde_json::from_slice(response.body()).map_err(|err| err.to_string())?;
+        Ok(AwsCredentials {
+            access_key_id: credentials.access_key_id,
+            secret_access_key: credentials.secret_access_key,
+            session_token: Some(credentials.token),
+            expiration: credentials.expiration.as_ref().and_then(parse_expiration),
+        })
+    }
+}
+
+fn credentials_error(message: String) -> AwsSecretsManagerError {
+    AwsSecretsManagerError::Credentials(message)
+}
+
+/// Parses an expiration time, either RFC 3339 (metadata services) or epoch seconds (STS).
+fn parse_expiration(expiration: &Value) -> Option<DateTime<Utc>> {
+    match expiration {
+        Value::String(expiration) => DateTime::parse_from_rfc3339(expiration)
+            .ok()
+            .map(|expiration| expiration.to_utc()),
+        Value::Number(seconds) => DateTime::from_timestamp(seconds.as_f64()? as i64, 0),
+        _ => None,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use assert_matches::assert_matches;
+    use httpmock::Method::{GET, POST, PUT};
+    use httpmock::MockServer;
+    use std::collections::HashMap;
+
+    fn chain(server: &MockServer) -> CredentialsChain {
+        let endpoint = Url::parse(&server.base_url()).unwrap();
+        CredentialsChain {
+            imds_endpoint: endpoint.clone(),
+            ecs_endpoint: endpoint.clone(),
+            sts_endpoint: Some(endpoint),
+            ..CredentialsChain::try_new(HttpConfig::default()).unwrap()
+        }
+    }
+
+    fn env(vars: &[(&str, &str)]) -> impl Fn(&str) -> Option<String> {
+        let vars: HashMap<String, String> = vars
+            .iter()
+            .map(|(name, value)| (name.to_string(), value.to_string()))
+            .collect();
+        move |name| vars.get(name).cloned()
+    }
+
+    const METADATA_CREDENTIALS: &str = r#"{"Code":"Success","AccessKeyId":"ASIAEXAMPLE","SecretAccessKey":"secret","Token":"token","Expiration":"2025-01-02T04:00:00Z"}"#;
+
+    fn now() -> DateTime<Utc> {
+        DateTime::parse_from_rfc3339("2025-01-02T03:00:00Z")
+            .unwrap()
+            .to_utc()
+    }
+
+    #[test]
+    fn test_environment_credentials() {
+        let server = MockServer::start();
+        let credentials = chain(&server)
+            .credentials_with(
+                &env(&[
+                    ("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE"),
+                    ("AWS_SECRET_ACCESS_KEY", "secret"),
+                ]),
+                now(),
+            )
+            .unwrap();
+        assert_eq!(credentials.access_key_id, "AKIDEXAMPLE");
+        assert_eq!(credentials.session_token, None);
+    }
+
+    #[test]
+    fn test_web_identity_credentials() {
+        let server = MockServer::start();
+        let mock = server.mock(|when, then| {
+            when.method(POST)
+                .path("/")
+                .header("accept", "application/json")
+                .body_includes("Action=AssumeRoleWithWebIdentity")
+                .body_includes("WebIdentityToken=web-identity-token");
+            then.status(200).body(
+                r#"{"AssumeRoleWithWebIdentityResponse":{"AssumeRoleWithWebIdentityResult":{"Credentials":{"AccessKeyId":"ASIAEXAMPLE","SecretAccessKey":"secret","SessionToken":"token","Expiration":1.7358E9}}}}"#,
+            );
+        });
+        let tmp_dir = tempfile::tempdir().unwrap();
+        let token_file = tmp_dir.path().join("token");
+        std::fs::write(&token_file, "web-identity-token\n").unwrap();
+
+        let credentials = chain(&server)
+            .credentials_with(
+                &env(&[
+                    ("AWS_WEB_IDENTITY_TOKEN_FILE", &token_file.to_string_lossy()),
+                    (
+                        "AWS_ROLE_ARN",
+                        "arn:aws:iam::123456789012:role/agent-control",
+                    ),
+                ]),
+                now(),
+            )
+            .unwrap();
+        mock.assert();
+        assert_eq!(credentials.access_key_id, "ASIAEXAMPLE");
+        assert_eq!(credentials.session_token.as_deref(), Some("token"));
+        assert_eq!(
+            credentials.expiration,
+            DateTime::from_timestamp(1_735_800_000, 0)
+        );
+    }
+
+    #[test]
+    fn test_container_credentials() {
+        let server = MockServer::start();
+        let mock = server.mock(|when, then| {
+            when.method(GET)
+                .path("/v2/credentials/task")
+                .header("authorization", "auth-token");
+            then.status(200).body(METADATA_CREDENTIALS);
+        });
+
+        let credentials = chain(&server)
+            .credentials_with(
+                &env(&[
+                    (
+                        "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+                        "/v2/credentials/task",
+                    ),
+                    ("AWS_CONTAINER_AUTHORIZATION_TOKEN", "auth-token"),
+                ]),
+                now(),
+            )
+            .unwrap();
+        mock.assert();
+        assert_eq!(credentials.access_key_id, "ASIAEXAMPLE");
+    }
+
+    #[test]
+    fn test_instance_profile_credentials_are_cached_until_they_expire() {
+        let server = MockServer::start();
+        let token = server.mock(|when, then| {
+            when.method(PUT)
+                .path("/latest/api/token")
+                .header(IMDS_TOKEN_TTL_HEADER, IMDS_TOKEN_TTL_SECS);
+            then.status(200).body("imds-token");
+        });
+        server.mock(|when, then| {
+            when.method(GET)
+                .path("/latest/meta-data/iam/security-credentials/")
+                .header(IMDS_TOKEN_HEADER, "imds-token");
+            then.status(200).body("agent-control-role\n");
+        });
+        let credentials_mock = server.mock(|when, then| {
+            when.method(GET)
+                .path("/latest/meta-data/iam/security-credentials/agent-control-role")
+                .header(IMDS_TOKEN_HEADER, "imds-token");
+            then.status(200).body(METADATA_CREDENTIALS);
+        });
+
+        let chain = chain(&server);
+        let credentials = chain.credentials_with(&env(&[]), now()).unwrap();
+        assert_eq!(credentials.access_key_id, "ASIAEXAMPLE");
+        chain
+            .credentials_with(&env(&[]), now() + TimeDelta::minutes(30))
+            .unwrap();
+        credentials_mock.assert_hits(1);
+
+        // Renewed when they are about to expire.
+        chain
+            .credentials_with(&env(&[]), now() + TimeDelta::minutes(58))
+            .unwrap();
+        credentials_mock.assert_hits(2);
+        token.assert_hits(2);
+    }
+
+    #[test]
+    fn test_missing_credentials() {
+        let server = MockServer::start();
+        server.mock(|when, then| {
+            when.method(PUT).path("/latest/api/token");
+            then.status(404);
+        });
+        assert_matches!(
+            chain(&server).credentials_with(&env(&[]), now()),
+            Err(AwsSecretsManagerError::MissingCredentials)
+        );
+        assert_matches!(
+            chain(&server).credentials_with(&env(&[("AWS_EC2_METADATA_DISABLED", "true")]), now()),
+            Err(AwsSecretsManagerError::MissingCredentials)
+        );
+    }
+}
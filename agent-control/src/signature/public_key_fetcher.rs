@@ -4,6 +4,7 @@ use crate::http::client::HttpClient;
 use crate::signature::public_key::PublicKey;
 use http::Request;
 use serde::{Deserialize, Serialize};
+use std::path::Path;
 use thiserror::Error;
 use tracing::warn;
 use url::Url;
@@ -41,35 +42,7 @@ impl PublicKeyFetcher {
     /// skipped and a warning will be logged. If no valid keys are found, an error will be returned.
     pub fn fetch(&self, url: &Url) -> Result<Vec<PublicKey>, PubKeyFetcherError> {
         let payload = self.fetch_jwks(url)?;
-
-        let mut keys = Vec::new();
-        let mut errors = Vec::new();
-
-        for key_data in payload.keys.iter() {
-            match PublicKey::try_from(key_data) {
-                Ok(key) => keys.push(key),
-                Err(e) => {
-                    let error_msg = format!("invalid key {}: {}", key_data.kid, e);
-                    warn!("{}", error_msg);
-                    errors.push(error_msg);
-                }
-            }
-        }
-
-        if keys.is_empty() {
-            let error_msg = if errors.is_empty() {
-                "no keys found".to_string()
-            } else {
-                format!(
-                    "no valid keys found ({} invalid keys: {})",
-                    errors.len(),
-                    errors.join(", ")
-                )
-            };
-            return Err(PubKeyFetcherError(error_msg));
-        }
-
-        Ok(keys)
+        valid_keys(&payload)
     }
 
     fn fetch_jwks(&self, url: &Url) -> Result<PubKeyPayload, PubKeyFetcherError> {
@@ -91,6 +64,48 @@ impl PublicKeyFetcher {
     }
 }
 
+/// Reads the public keys of a local JWKS file, used as a key ring of trusted keys. As when
+/// fetching them, invalid keys are skipped and an error is returned if none is valid.
+pub fn read_key_ring(path: &Path) -> Result<Vec<PublicKey>, PubKeyFetcherError> {
+    let content = std::fs::read(path)
+        .map_err(|e| PubKeyFetcherError(format!("reading key ring {}: {}", path.display(), e)))?;
+    let payload: PubKeyPayload = serde_json::from_slice(&content)
+        .map_err(|e| PubKeyFetcherError(format!("decoding key ring {}: {}", path.display(), e)))?;
+    valid_keys(&payload)
+}
+
+/// Returns the valid keys of the payload, logging the invalid ones.
+fn valid_keys(payload: &PubKeyPayload) -> Result<Vec<PublicKey>, PubKeyFetcherError> {
+    let mut keys = Vec::new();
+    let mut errors = Vec::new();
+
+    for key_data in payload.keys.iter() {
+        match PublicKey::try_from(key_data) {
+            Ok(key) => keys.push(key),
+            Err(e) => {
+                let error_msg = format!("invalid key {}: {}", key_data.kid, e);
+                warn!("{}", error_msg);
+                errors.push(error_msg);
+            }
+        }
+    }
+
+    if keys.is_empty() {
+        let error_msg = if errors.is_empty() {
+            "no keys found".to_string()
+        } else {
+            format!(
+                "no valid keys found ({} invalid keys: {})",
+                errors.len(),
+                errors.join(", ")
+            )
+        };
+        return Err(PubKeyFetcherError(error_msg));
+    }
+
+    Ok(keys)
+}
+
 /// Represents the payload returned by the JWKS endpoint.
 #[derive(Serialize, Deserialize, Debug, Clone)]
 pub struct PubKeyPayload {
@@ -404,4 +419,24 @@ pub mod tests {
             mock.assert();
         }
     }
+
+    #[test]
+    fn read_key_ring_from_file() {
+        let key_pair = TestKeyPair::new(0);
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("keys.jwks.json");
+        std::fs::write(
+            &path,
+            json!({"keys": [key_pair.public_key_jwk()]}).to_string(),
+        )
+        .unwrap();
+
+        let keys = read_key_ring(&path).unwrap();
+        assert_eq!(keys.len(), 1);
+        assert_eq!(keys[0].key_id(), key_pair.key_id());
+
+        std::fs::write(&path, r#"{"keys": []}"#).unwrap();
+        assert!(read_key_ring(&path).is_err());
+        assert!(read_key_ring(&dir.path().join("missing.json")).is_err());
+    }
 }
@@ -6,6 +6,9 @@
 //! implementation, and the platform-agnostic remote-config handling that drives the supervisor.
 
 pub mod collection;
+pub mod config_apply_latency;
+pub mod config_lint;
+pub mod config_verification;
 pub mod effective_agents_assembler;
 pub mod error;
 pub(crate) mod event_handler;
@@ -15,9 +18,11 @@ pub mod k8s;
 pub mod on_host;
 pub mod remote_config_parser;
 pub mod supervisor;
+pub mod watchdog;
 
 use crate::agent_control::defaults::default_capabilities;
 use crate::agent_control::uptime_report::{UptimeReportConfig, UptimeReporter};
+use crate::audit::{AuditEvent, AuditTrail};
 use crate::checkers::health::events::HealthEventPublisher;
 use crate::checkers::health::health_checker::{Health, Unhealthy};
 use crate::checkers::health::with_start_time::HealthWithStartTime;
@@ -25,17 +30,21 @@ use crate::event::SubAgentEvent::SubAgentStarted;
 use crate::event::broadcaster::unbounded::UnboundedBroadcast;
 use crate::event::channel::{EventConsumer, EventPublisher};
 use crate::event::{OpAMPEvent, SubAgentEvent, SubAgentInternalEvent};
+use crate::lifecycle_hooks::{LifecycleEvent, LifecycleHooks};
 use crate::opamp::attributes::update_opamp_attributes;
 use crate::opamp::operations::stop_opamp_client;
 use crate::opamp::remote_config::OpampRemoteConfig;
 use crate::opamp::remote_config::hash::{ConfigState, Hash};
 use crate::opamp::remote_config::report::report_state;
 use crate::resource_ownership::ResourceOwnership;
+use crate::utils::error_kind::ClassifiedError;
 use crate::utils::threads::spawn_named_thread;
 use crate::values::config::{Config, RemoteConfig};
-use crate::values::config_repository::ConfigRepository;
+use crate::values::config_repository::{ConfigRepository, ConfigRepositoryError};
 use crate::values::yaml_config::YAMLConfig;
-use crossbeam::channel::never;
+use config_apply_latency::{ConfigApplyLatency, ConfigApplyPhase};
+use config_verification::{PendingConfig, RemoteConfigStatusConfig};
+use crossbeam::channel::{at, never, tick};
 use crossbeam::select;
 use effective_agents_assembler::EffectiveAgentsAssemblerError;
 use effective_agents_assembler::{EffectiveAgent, EffectiveAgentsAssembler};
@@ -46,11 +55,12 @@ use identity::AgentIdentity;
 use opamp_client::StartedClient;
 use remote_config_parser::RemoteConfigParser;
 use std::fmt::Display;
-use std::sync::Arc;
+use std::sync::{Arc, Mutex};
 use std::thread::JoinHandle;
-use std::time::SystemTime;
+use std::time::{Duration, Instant, SystemTime};
 use supervisor::{Supervisor, SupervisorBuilder, SupervisorStarter};
 use tracing::{debug, error, info, info_span, trace, warn};
+use watchdog::{HEARTBEAT_INTERVAL, Heartbeat};
 
 /// NotStartedSubAgent exposes a run method that starts processing events and, if present, the supervisor.
 pub trait NotStartedSubAgent {
@@ -68,6 +78,22 @@ pub trait NotStartedSubAgent {
 pub trait StartedSubAgent {
     /// Stops all internal services owned by the SubAgent
     fn stop(self) -> Result<(), SubAgentStopError>;
+
+    /// Time elapsed since the runtime last made progress. See [watchdog].
+    fn since_last_progress(&self) -> Duration {
+        Duration::ZERO
+    }
+
+    /// Reports the SubAgent unhealthy because its runtime didn't make progress for `stalled_for`.
+    fn report_stalled(&self, _stalled_for: Duration) {}
+
+    /// Requests the runtime to stop without waiting for it, as it might never finish if stalled.
+    fn request_stop(&self) {}
+
+    /// Whether the runtime finished, so [StartedSubAgent::stop] returns without waiting.
+    fn is_finished(&self) -> bool {
+        true
+    }
 }
 
 /// Builds a [NotStartedSubAgent] for a given [AgentIdentity].
@@ -94,7 +120,10 @@ type AgentSupervisor<B> = <AgentSupervisorStarter<B> as SupervisorStarter>::Supe
 /// the exposed method Stop that will publish a StopRequested event to the runtime
 /// and wait on the JoinHandle for the runtime to finish.
 pub struct SubAgentStopper {
+    identity: AgentIdentity,
     sub_agent_internal_publisher: EventPublisher<SubAgentInternalEvent>,
+    sub_agent_publisher: UnboundedBroadcast<SubAgentEvent>,
+    heartbeat: Heartbeat,
     runtime: JoinHandle<Result<(), SubAgentError>>,
 }
 
@@ -122,6 +151,24 @@ where
     supervisor_builder: Arc<B>,
     config_repository: Arc<Y>,
     effective_agent_assembler: Arc<A>,
+    /// Tracks the remote config being applied, until the agent is healthy on it.
+    config_apply_latency: Mutex<Option<ConfigApplyLatency>>,
+    /// Time the agent must run on a new configuration before it is reported as applied.
+    health_grace_period: Duration,
+    /// Restore the last known good configuration when the agent fails on a new one.
+    rollback_on_failure: bool,
+    /// Configuration the agent was started with, waiting for the grace period to report its state.
+    pending_config: Mutex<Option<PendingConfig>>,
+    /// Last configuration the agent was verified to run healthy on, if rollbacks are enabled.
+    last_good_config: Mutex<Option<Config>>,
+    /// Hash and reported state of the last configuration rolled back, not to apply it again.
+    rolled_back_config: Mutex<Option<(Hash, ConfigState)>>,
+    /// Beaten by the runtime loop on every iteration.
+    heartbeat: Heartbeat,
+    /// Notified of the applied, failed and rolled back configurations.
+    lifecycle_hooks: LifecycleHooks,
+    /// Records the configurations applied.
+    audit_trail: AuditTrail,
 }
 
 impl<C, B, R, Y, A> SubAgent<C, B, R, Y, A>
@@ -160,6 +207,41 @@ where
             remote_config_parser,
             config_repository,
             effective_agent_assembler,
+            config_apply_latency: Mutex::default(),
+            health_grace_period: Duration::ZERO,
+            rollback_on_failure: false,
+            pending_config: Mutex::default(),
+            last_good_config: Mutex::default(),
+            rolled_back_config: Mutex::default(),
+            heartbeat: Heartbeat::default(),
+            lifecycle_hooks: LifecycleHooks::default(),
+            audit_trail: AuditTrail::default(),
+        }
+    }
+
+    /// Sets how the remote configurations applied to the agent are verified before reporting
+    /// their state. See [config_verification].
+    pub fn with_remote_config_status(self, config: &RemoteConfigStatusConfig) -> Self {
+        Self {
+            health_grace_period: config.health_grace_period.into(),
+            rollback_on_failure: config.rollback_on_failure,
+            ..self
+        }
+    }
+
+    /// Sets the hooks notified of the configurations applied, failed and rolled back.
+    pub fn with_lifecycle_hooks(self, lifecycle_hooks: LifecycleHooks) -> Self {
+        Self {
+            lifecycle_hooks,
+            ..self
+        }
+    }
+
+    /// Sets the audit trail recording the configurations applied.
+    pub fn with_audit_trail(self, audit_trail: AuditTrail) -> Self {
+        Self {
+            audit_trail,
+            ..self
         }
     }
 
@@ -241,20 +323,25 @@ where
                 })
         });
 
+        // A configuration already verified is the one to roll back to if a new one fails.
+        if started_supervisor.is_ok() && config.get_state().is_none_or(|s| s.is_applied()) {
+            self.set_last_good_config(Some(config.clone()));
+        }
+
         // After all operations, set the hash to a final state
         // only if it was in the `applying` state.
         if let Config::RemoteConfig(remote_config) = config
             && remote_config.is_applying()
         {
-            let state = match &started_supervisor {
-                Ok(_) => ConfigState::Applied,
-                Err(e) => ConfigState::Failed {
-                    error_message: e.to_string(),
-                },
-            };
-            let remote_config = remote_config.with_state(state);
-
-            self.report_and_persist_state(remote_config.state, &remote_config.hash);
+            match &started_supervisor {
+                Ok(_) => self.report_and_persist_applied(&remote_config.hash),
+                Err(e) => self.report_and_persist_state(
+                    ConfigState::Failed {
+                        error_message: e.to_string(),
+                    },
+                    &remote_config.hash,
+                ),
+            }
         }
 
         started_supervisor
@@ -274,7 +361,7 @@ where
     /// OpAMP client.
     pub fn runtime(self) -> JoinHandle<Result<(), SubAgentError>> {
         spawn_named_thread("Subagent runtime", move || {
-            let span = info_span!("start_agent", id=%self.identity.id);
+            let span = info_span!("start_agent", id=%self.identity.id, agent_type=%self.identity.agent_type_id);
             let _span_guard = span.enter();
 
             let mut supervisor = self.init_supervisor();
@@ -316,12 +403,24 @@ where
 
             drop(_span_guard);
 
+            // Keeps the heartbeat going while there are no events to process.
+            let heartbeat_ticker = tick(HEARTBEAT_INTERVAL);
+
             // Count the received remote configs during execution
             let mut remote_config_count = 0;
             loop {
+                self.heartbeat.beat();
+                // Fires when the grace period of the pending configuration elapses, if any.
+                let pending_config_deadline = self
+                    .pending_config
+                    .lock()
+                    .expect("pending config lock poisoned")
+                    .as_ref()
+                    .map(|pending| at(pending.deadline()))
+                    .unwrap_or_else(never);
                 select! {
                     recv(opamp_receiver.as_ref()) -> opamp_event_res => {
-                        let span = info_span!("process_fleet_event", id=%self.identity.id);
+                        let span = info_span!("process_fleet_event", id=%self.identity.id, agent_type=%self.identity.agent_type_id);
                         let _span_guard = span.enter();
                         match opamp_event_res {
                             Err(e) => {
@@ -345,11 +444,12 @@ where
                                 // Refresh the supervisor according to the received config
                                 supervisor = self.handle_remote_config(opamp_client, config, supervisor);
                             },
-                            Ok(OpAMPEvent::Connected) | Ok(OpAMPEvent::ConnectFailed(_, _)) => {},
+                            // Only Agent Control accepts packages
+                            Ok(OpAMPEvent::Connected) | Ok(OpAMPEvent::ConnectFailed(_, _)) | Ok(OpAMPEvent::PackagesAvailable(_)) => {},
                         }
                     },
                     recv(&self.sub_agent_internal_consumer.as_ref()) -> sub_agent_internal_event_res => {
-                        let span = info_span!("process_event", id=%self.identity.id);
+                        let span = info_span!("process_event", id=%self.identity.id, agent_type=%self.identity.agent_type_id);
                         let _span_guard = span.enter();
                         match sub_agent_internal_event_res {
                             Err(e) => {
@@ -369,6 +469,8 @@ where
                                     log_health_info(&health_state);
                                 }
                                 previous_health = Some(health_state);
+                                self.report_config_apply_latency(&health);
+                                self.record_pending_config_health(&health);
                                 let _ = on_health(
                                     health,
                                     self.maybe_opamp_client.as_ref(),
@@ -388,6 +490,12 @@ where
                         }
                     }
                     recv(uptime_reporter.receiver()) -> _tick => { let _ = uptime_reporter.report(); },
+                    recv(heartbeat_ticker) -> _tick => {},
+                    recv(pending_config_deadline) -> _ => {
+                        let span = info_span!("verify_remote_config", id=%self.identity.id, agent_type=%self.identity.agent_type_id);
+                        let _span_guard = span.enter();
+                        supervisor = self.report_pending_config_state(supervisor);
+                    },
                 }
             }
 
@@ -412,6 +520,18 @@ where
             return old_supervisor;
         }
 
+        // A configuration rolled back because the agent failed on it is not applied again.
+        if let Some((hash, state)) = self
+            .rolled_back_config
+            .lock()
+            .expect("rolled back config lock poisoned")
+            .as_ref()
+            && config.hash == *hash
+        {
+            self.report_state(state.clone(), hash);
+            return old_supervisor;
+        }
+
         // If the remote hash comes failed from the pre-processing steps (performed in the OpAMP
         // client callbacks, see `process_remote_config` in `opamp::callbacks`),
         // the previous working supervisor will keep running and the hash won't be updated.
@@ -420,13 +540,24 @@ where
         }
 
         info!(hash = config.hash.to_string(), "Applying remote config");
+        // The new configuration supersedes the one pending verification, if any.
+        self.pending_config
+            .lock()
+            .expect("pending config lock poisoned")
+            .take();
+        *self
+            .config_apply_latency
+            .lock()
+            .expect("config apply latency lock poisoned") = Some(ConfigApplyLatency::start());
         self.report_state(ConfigState::Applying, &config.hash);
 
         // Start transforming the remote config
         // Attempt to parse/validate the remote config
-        let parsed_remote_config_result = self
-            .remote_config_parser
-            .parse(self.identity.clone(), &config);
+        let parsed_remote_config_result =
+            self.measure_config_apply_phase(ConfigApplyPhase::Validation, || {
+                self.remote_config_parser
+                    .parse(self.identity.clone(), &config)
+            });
 
         match parsed_remote_config_result {
             // Some configuration correctly parsed: apply configuration or build supervisor if there was none
@@ -466,38 +597,44 @@ where
         old_supervisor: Option<AgentSupervisor<B>>,
         opamp_client: &C,
     ) -> Option<AgentSupervisor<B>> {
-        match self.effective_agent(yaml_config) {
+        match self.measure_config_apply_phase(ConfigApplyPhase::Merge, || {
+            self.effective_agent(yaml_config)
+        }) {
             Ok(effective_agent) => {
                 // Store remote config if there is any
                 if let Some(remote_config) = maybe_remote_config {
                     let _ = self
-                        .config_repository
-                        .store_remote(
-                            &self.identity.id,
-                            ResourceOwnership::SubAgent(self.identity.agent_type_id.clone()),
-                            remote_config,
-                        )
+                        .measure_config_apply_phase(ConfigApplyPhase::Write, || {
+                            self.config_repository.store_remote(
+                                &self.identity.id,
+                                ResourceOwnership::SubAgent(self.identity.agent_type_id.clone()),
+                                remote_config,
+                            )
+                        })
                         .inspect_err(|err| {
                             warn!("Failed to store remote configuration: {err}");
+                            self.report_unhealthy_on_storage_timeout(err);
                         });
                 }
 
-                // Apply config if there was a supervisor already
-                if let Some(previous_supervisor) = old_supervisor {
-                    self.apply_config_to_existing_supervisor(
-                        opamp_client,
-                        hash,
-                        previous_supervisor,
-                        effective_agent,
-                    )
-                } else {
-                    // Try to build a new supervisor otherwise
-                    self.build_and_start_supervisor_from_effective_agent(
-                        effective_agent,
-                        hash,
-                        opamp_client,
-                    )
-                }
+                self.measure_config_apply_phase(ConfigApplyPhase::Restart, || {
+                    // Apply config if there was a supervisor already
+                    if let Some(previous_supervisor) = old_supervisor {
+                        self.apply_config_to_existing_supervisor(
+                            opamp_client,
+                            hash,
+                            previous_supervisor,
+                            effective_agent,
+                        )
+                    } else {
+                        // Try to build a new supervisor otherwise
+                        self.build_and_start_supervisor_from_effective_agent(
+                            effective_agent,
+                            hash,
+                            opamp_client,
+                        )
+                    }
+                })
             }
             Err(err) => {
                 if maybe_remote_config.is_none() {
@@ -578,7 +715,7 @@ where
                 supervisor_starter,
             ),
             Err(err) => {
-                warn!("Failed to build supervisor: {err}");
+                warn!(error_kind = %err.kind(), "Failed to build supervisor: {err}");
                 self.report_state(
                     ConfigState::Failed {
                         error_message: format!("could not build the supervisor: {err}"),
@@ -626,16 +763,16 @@ where
             .apply(effective_agent)
             // Report Applied and return the updated supervisor when apply is successful
             .inspect(|_| {
-                self.report_and_persist_state(ConfigState::Applied, hash);
+                self.report_and_persist_applied(hash);
             })
             // Report Failed and return the supervisor from the corresponding error when apply fails
             .inspect_err(|err| {
-                warn!("Failure applying remote configuration: {err}");
+                warn!(
+                    error_kind = %err.kind(),
+                    "Failure applying remote configuration: {err}"
+                );
                 self.report_unhealthy_from_error(err);
-                let state = ConfigState::Failed {
-                    error_message: err.to_string(),
-                };
-                self.report_and_persist_state(state, hash);
+                self.report_failed_state(err, hash);
             })
             .ok()
     }
@@ -658,15 +795,12 @@ where
             // Alter the state depending on the outcome
             .inspect(|_| {
                 // Report the empty remote config as applied
-                self.report_and_persist_state(ConfigState::Applied, hash);
+                self.report_and_persist_applied(hash);
             })
             .inspect_err(|e| {
-                error!("Failure starting the supervisor: {e}");
+                error!(error_kind = %e.kind(), "Failure starting the supervisor: {e}");
                 self.report_unhealthy_from_error(e);
-                let state = ConfigState::Failed {
-                    error_message: e.to_string(),
-                };
-                self.report_and_persist_state(state, hash);
+                self.report_failed_state(e, hash);
             })
             // Return it
             .ok()
@@ -694,18 +828,258 @@ where
         &self,
         yaml_config: YAMLConfig,
     ) -> Result<EffectiveAgent, EffectiveAgentsAssemblerError> {
+        // Local mandatory values take precedence over any remote or local config
+        let yaml_config = self
+            .config_repository
+            .apply_local_mandatory(&self.identity.id, yaml_config)
+            .map_err(|err| {
+                EffectiveAgentsAssemblerError::EffectiveAgentsAssemblerError(err.to_string())
+            })?;
+        self.lint_config(&yaml_config);
         // Assemble the new agent
         self.effective_agent_assembler
             .assemble_agent(&self.identity, yaml_config)
     }
 
+    /// Logs the lint warnings of the config and publishes them, so they are shown in the status.
+    fn lint_config(&self, yaml_config: &YAMLConfig) {
+        let warnings = config_lint::lint(&self.identity.agent_type_id, yaml_config);
+        for warning in &warnings {
+            warn!("Configuration warning: {warning}");
+        }
+        self.sub_agent_publisher
+            .broadcast(SubAgentEvent::ConfigLinted(self.identity.clone(), warnings));
+    }
+
+    /// Runs `f` recording its duration as a phase of the remote config being applied, if any.
+    fn measure_config_apply_phase<T>(&self, phase: ConfigApplyPhase, f: impl FnOnce() -> T) -> T {
+        let start = Instant::now();
+        let result = info_span!("config_apply_phase", id=%self.identity.id, phase = phase.as_str())
+            .in_scope(f);
+        if let Some(latency) = self
+            .config_apply_latency
+            .lock()
+            .expect("config apply latency lock poisoned")
+            .as_mut()
+        {
+            latency.record(phase, start.elapsed());
+        }
+        result
+    }
+
+    /// Marks the remote config being applied as applied, or stops tracking it if it failed.
+    fn track_config_apply_state(&self, state: &ConfigState) {
+        let mut maybe_latency = self
+            .config_apply_latency
+            .lock()
+            .expect("config apply latency lock poisoned");
+        if state.is_failed() {
+            *maybe_latency = None;
+        } else if state.is_applied()
+            && let Some(latency) = maybe_latency.as_mut()
+        {
+            latency.applied();
+        }
+    }
+
+    /// Reports the apply latency of the remote config once the agent is healthy on it.
+    fn report_config_apply_latency(&self, health: &HealthWithStartTime) {
+        let mut maybe_latency = self
+            .config_apply_latency
+            .lock()
+            .expect("config apply latency lock poisoned");
+        if maybe_latency
+            .as_ref()
+            .is_some_and(|latency| latency.report_if_healthy(health))
+        {
+            *maybe_latency = None;
+        }
+    }
+
+    /// Reports the configuration the agent was started with as applied or, if a health grace
+    /// period is configured, keeps it applying until the grace period elapses.
+    fn report_and_persist_applied(&self, hash: &Hash) {
+        if self.health_grace_period.is_zero() {
+            self.report_and_persist_state(ConfigState::Applied, hash);
+            return;
+        }
+        debug!(
+            %hash,
+            "Waiting {:?} to report the remote config state", self.health_grace_period
+        );
+        // The agent is already running on the configuration, so its apply latency is measured.
+        self.track_config_apply_state(&ConfigState::Applied);
+        *self
+            .pending_config
+            .lock()
+            .expect("pending config lock poisoned") =
+            Some(PendingConfig::new(hash.clone(), self.health_grace_period));
+    }
+
+    /// Records the health of the agent on the configuration pending verification, if any.
+    fn record_pending_config_health(&self, health: &HealthWithStartTime) {
+        if let Some(pending) = self
+            .pending_config
+            .lock()
+            .expect("pending config lock poisoned")
+            .as_mut()
+        {
+            pending.record(health);
+        }
+    }
+
+    /// Reports and persists the state of the configuration whose grace period elapsed. If the
+    /// agent failed on it and rollbacks are enabled, the last known good configuration is
+    /// restored instead.
+    fn report_pending_config_state(
+        &self,
+        supervisor: Option<AgentSupervisor<B>>,
+    ) -> Option<AgentSupervisor<B>> {
+        let Some(pending) = self
+            .pending_config
+            .lock()
+            .expect("pending config lock poisoned")
+            .take()
+        else {
+            return supervisor;
+        };
+        let state = pending.state();
+        let Some(error_message) = state.error_message().cloned() else {
+            self.report_and_persist_state(state, pending.hash());
+            self.set_last_good_config(
+                self.config_repository
+                    .load_remote_fallback_local(&self.identity.id, &default_capabilities())
+                    .inspect_err(|e| warn!("Failed to load the applied configuration: {e}"))
+                    .ok()
+                    .flatten(),
+            );
+            return supervisor;
+        };
+        warn!(hash = %pending.hash(), "Remote configuration failed: {error_message}");
+
+        let last_good_config = self
+            .last_good_config
+            .lock()
+            .expect("last good config lock poisoned")
+            .clone();
+        let Some(last_good_config) = last_good_config.filter(|_| self.rollback_on_failure) else {
+            self.report_and_persist_state(state, pending.hash());
+            return supervisor;
+        };
+
+        let supervisor = self.rollback_config(last_good_config, supervisor);
+        self.lifecycle_hooks.notify(LifecycleEvent::Rollback {
+            agent_id: self.identity.id.to_string(),
+            reason: error_message.clone(),
+        });
+        // The failed configuration is not persisted, the storage holds the restored one.
+        let state = ConfigState::Failed {
+            error_message: format!(
+                "{error_message}, rolled back to the last known good configuration"
+            ),
+        };
+        self.track_config_apply_state(&state);
+        self.report_state(state.clone(), pending.hash());
+        *self
+            .rolled_back_config
+            .lock()
+            .expect("rolled back config lock poisoned") = Some((pending.hash().clone(), state));
+        supervisor
+    }
+
+    /// Records the configuration the agent is verified to run healthy on, if rollbacks are
+    /// enabled.
+    fn set_last_good_config(&self, config: Option<Config>) {
+        if self.rollback_on_failure {
+            *self
+                .last_good_config
+                .lock()
+                .expect("last good config lock poisoned") = config;
+        }
+    }
+
+    /// Restores the `config` in the storage and applies it to the running supervisor.
+    fn rollback_config(
+        &self,
+        config: Config,
+        supervisor: Option<AgentSupervisor<B>>,
+    ) -> Option<AgentSupervisor<B>> {
+        info!(hash = ?config.get_hash(), "Rolling back to the last known good configuration");
+        let restored = match &config {
+            Config::RemoteConfig(remote_config) => self.config_repository.store_remote(
+                &self.identity.id,
+                ResourceOwnership::SubAgent(self.identity.agent_type_id.clone()),
+                &remote_config.clone().with_state(ConfigState::Applied),
+            ),
+            Config::LocalConfig(_) => self.config_repository.delete_remote(&self.identity.id),
+        };
+        if let Err(err) = restored {
+            warn!("Failed to restore the last known good configuration: {err}");
+            self.report_unhealthy_on_storage_timeout(&err);
+        }
+
+        let effective_agent = match self.effective_agent(config.get_yaml_config().clone()) {
+            Ok(effective_agent) => effective_agent,
+            Err(err) => {
+                error!("Failed to assemble the last known good configuration: {err}");
+                return supervisor;
+            }
+        };
+        self.maybe_opamp_client.as_ref().inspect(|c| {
+            let _ = c
+                .update_effective_config()
+                .inspect_err(|e| error!("Effective config update failed: {e}"));
+        });
+
+        // Configurations are only verified while the agent runs, so there is a supervisor.
+        supervisor?
+            .apply(effective_agent)
+            .inspect_err(|err| {
+                error!(
+                    error_kind = %err.kind(),
+                    "Failure applying the last known good configuration: {err}"
+                );
+                self.report_unhealthy_from_error(err);
+            })
+            .ok()
+    }
+
     fn report_state(&self, state: ConfigState, hash: &Hash) {
+        if let ConfigState::Failed { error_message } = &state {
+            self.lifecycle_hooks.notify(LifecycleEvent::ConfigFailed {
+                agent_id: self.identity.id.to_string(),
+                hash: hash.to_string(),
+                error_message: error_message.clone(),
+            });
+            self.sub_agent_publisher
+                .broadcast(SubAgentEvent::ConfigFailed(
+                    self.identity.clone(),
+                    hash.clone(),
+                    error_message.clone(),
+                ));
+        }
         if let Some(opamp_client) = self.maybe_opamp_client.as_ref() {
             let _ = report_state(state, hash.clone(), opamp_client);
         }
     }
 
     fn report_and_persist_state(&self, state: ConfigState, hash: &Hash) {
+        self.track_config_apply_state(&state);
+        if state == ConfigState::Applied {
+            self.audit_trail.record(AuditEvent::ConfigApplied {
+                agent_id: self.identity.id.to_string(),
+                hash: hash.to_string(),
+            });
+            self.lifecycle_hooks.notify(LifecycleEvent::ConfigApplied {
+                agent_id: self.identity.id.to_string(),
+                hash: hash.to_string(),
+            });
+            self.sub_agent_publisher
+                .broadcast(SubAgentEvent::ConfigApplied(
+                    self.identity.clone(),
+                    hash.clone(),
+                ));
+        }
         self.report_state(state.clone(), hash);
         let _ = self
             .config_repository
@@ -716,9 +1090,33 @@ where
             )
             .inspect_err(|err| {
                 warn!("Could not update the config state: {err}");
+                self.report_unhealthy_on_storage_timeout(err);
             });
     }
 
+    /// Reports the sub-agent as unhealthy if a config repository operation timed out, as the
+    /// storage is likely hung and configuration changes are not being persisted.
+    fn report_unhealthy_on_storage_timeout(&self, err: &ConfigRepositoryError) {
+        if let ConfigRepositoryError::Timeout(_) = err {
+            self.report_unhealthy_from_error(err);
+        }
+    }
+
+    /// Reports the configuration as failed because of the provided error. Failures caused by
+    /// transient errors are not persisted, so the configuration is applied again when the
+    /// sub-agent restarts instead of being discarded as failed.
+    fn report_failed_state(&self, err: &impl ClassifiedError, hash: &Hash) {
+        let state = ConfigState::Failed {
+            error_message: err.to_string(),
+        };
+        if err.is_retryable() {
+            self.track_config_apply_state(&state);
+            self.report_state(state, hash);
+        } else {
+            self.report_and_persist_state(state, hash);
+        }
+    }
+
     /// Helper to report unhealthy given an error
     fn report_unhealthy_from_error(&self, err: impl Display) {
         let unhealthy =
@@ -770,6 +1168,35 @@ impl StartedSubAgent for SubAgentStopper {
         })?;
         Ok(runtime_join_result?)
     }
+
+    fn since_last_progress(&self) -> Duration {
+        self.heartbeat.elapsed()
+    }
+
+    fn report_stalled(&self, stalled_for: Duration) {
+        let unhealthy = Unhealthy::new(format!(
+            "the agent runtime made no progress for {}s",
+            stalled_for.as_secs()
+        ))
+        .with_status("stalled".to_string());
+        self.sub_agent_publisher
+            .broadcast(SubAgentEvent::HealthUpdated(
+                self.identity.clone(),
+                HealthWithStartTime::from_unhealthy(unhealthy, SystemTime::now()),
+            ));
+    }
+
+    fn request_stop(&self) {
+        // The runtime stops its supervisor once it processes the request, if it ever does.
+        let _ = self
+            .sub_agent_internal_publisher
+            .publish(SubAgentInternalEvent::StopRequested)
+            .inspect_err(|err| debug!(%err, "Could not request the stalled runtime to stop"));
+    }
+
+    fn is_finished(&self) -> bool {
+        self.runtime.is_finished()
+    }
 }
 
 /// Stops the supervisor if present, logging any error returned while stopping.
@@ -795,11 +1222,17 @@ where
     type StartedSubAgent = SubAgentStopper;
 
     fn run(self) -> Self::StartedSubAgent {
+        let identity = self.identity.clone();
         let sub_agent_internal_publisher = self.sub_agent_internal_publisher.clone();
+        let sub_agent_publisher = self.sub_agent_publisher.clone();
+        let heartbeat = self.heartbeat.clone();
         let runtime_handle = self.runtime();
 
         SubAgentStopper {
+            identity,
             sub_agent_internal_publisher,
+            sub_agent_publisher,
+            heartbeat,
             runtime: runtime_handle,
         }
     }
@@ -828,6 +1261,7 @@ pub mod tests {
     use crate::opamp::remote_config::validators::tests::MockRemoteConfigValidator;
     use crate::opamp::remote_config::{AGENT_CONFIG_PREFIX, ConfigurationMap, OpampRemoteConfig};
     use crate::secrets_provider::SecretsProviders;
+    use crate::utils::error_kind::ErrorKind;
     use crate::values::config::RemoteConfig;
     use crate::values::config_repository::tests::InMemoryConfigRepository;
     use assert_matches::assert_matches;
@@ -853,6 +1287,10 @@ pub mod tests {
 
         impl StartedSubAgent for StartedSubAgent {
             fn stop(self) -> Result<(), SubAgentStopError>;
+            fn since_last_progress(&self) -> Duration;
+            fn report_stalled(&self, stalled_for: Duration);
+            fn request_stop(&self);
+            fn is_finished(&self) -> bool;
         }
     }
 
@@ -1146,14 +1584,14 @@ deployment:
         supervisor
     }
 
-    fn expect_supervisor_apply_failure(err_reason: &str) -> MockSupervisor {
+    fn expect_supervisor_apply_failure(err_reason: &str, kind: ErrorKind) -> MockSupervisor {
         let err_reason = err_reason.to_string();
         let mut supervisor = MockSupervisor::new();
         supervisor
             .expect_apply()
             .with(predicate::always()) // TODO: check if we can do some assertion on effective_config
             .once()
-            .return_once(move |_| Err(TestingSupervisorError(err_reason.to_string())));
+            .return_once(move |_| Err(TestingSupervisorError(err_reason.to_string(), kind)));
         supervisor
     }
 
@@ -1175,7 +1613,12 @@ deployment:
         supervisor_builder
             .expect_build_supervisor()
             .once()
-            .return_once(|_| Err(TestingSupervisorError("no configuration found".to_string())));
+            .return_once(|_| {
+                Err(TestingSupervisorError(
+                    "no configuration found".to_string(),
+                    ErrorKind::Misconfiguration,
+                ))
+            });
         supervisor_builder
     }
 
@@ -1183,10 +1626,12 @@ deployment:
     -> MockSupervisorBuilder<MockSupervisorStarter<MockSupervisor>> {
         let mut supervisor_builder = MockSupervisorBuilder::new();
         let mut stopped_supervisor = MockSupervisorStarter::new();
-        stopped_supervisor
-            .expect_start()
-            .once()
-            .returning(|_| Err(TestingSupervisorError("start failed".to_string())));
+        stopped_supervisor.expect_start().once().returning(|_| {
+            Err(TestingSupervisorError(
+                "start failed".to_string(),
+                ErrorKind::Fatal,
+            ))
+        });
         supervisor_builder
             .expect_build_supervisor()
             .once()
@@ -1285,7 +1730,10 @@ deployment:
             config_repository.clone(),
         );
 
-        let old_supervisor = Some(expect_supervisor_apply_failure(apply_fail_reason));
+        let old_supervisor = Some(expect_supervisor_apply_failure(
+            apply_fail_reason,
+            ErrorKind::Fatal,
+        ));
 
         let new_supervisor = sub_agent.handle_remote_config(
             sub_agent.maybe_opamp_client.as_ref().unwrap(),
@@ -1308,6 +1756,163 @@ deployment:
         assert!(new_supervisor.is_none());
     }
 
+    #[test]
+    fn test_remote_config_state_reported_after_health_grace_period() {
+        let (config_repository, mut opamp_client) = test_mocks();
+
+        opamp_client.should_update_effective_config(1);
+        opamp_client.should_set_remote_config_status_seq(vec![
+            TestAgent::status_applying(),
+            TestAgent::status_apply_failed_config_error(
+                "agent unhealthy on the configuration: failing",
+            ),
+        ]);
+
+        let sub_agent = sub_agent(
+            Some(opamp_client),
+            MockSupervisorBuilder::new(),
+            config_repository.clone(),
+        )
+        .with_health_grace_period(Duration::from_secs(60));
+
+        let new_supervisor = sub_agent.handle_remote_config(
+            sub_agent.maybe_opamp_client.as_ref().unwrap(),
+            TestAgent::valid_remote_config(),
+            Some(expect_supervisor_apply()),
+        );
+        assert!(new_supervisor.is_some());
+
+        // The config is kept applying until the grace period elapses
+        assert_remote_config(
+            config_repository.as_ref(),
+            &TestAgent::id(),
+            |remote_config| assert!(remote_config.state.is_applying()),
+        );
+
+        sub_agent.record_pending_config_health(&HealthWithStartTime::from_unhealthy(
+            Unhealthy::new("failing".to_string()),
+            SystemTime::now(),
+        ));
+        let new_supervisor = sub_agent.report_pending_config_state(new_supervisor);
+        assert!(new_supervisor.is_some());
+
+        assert_remote_config(
+            config_repository.as_ref(),
+            &TestAgent::id(),
+            |remote_config| assert!(remote_config.state.is_failed()),
+        );
+    }
+
+    #[test]
+    fn test_failed_remote_config_rolled_back_to_last_good_config() {
+        let (config_repository, mut opamp_client) = test_mocks();
+
+        let rolled_back_status = TestAgent::status_apply_failed_config_error(
+            "agent unhealthy on the configuration: failing, rolled back to the last known good configuration",
+        );
+        opamp_client.should_update_effective_config(2);
+        opamp_client.should_set_remote_config_status_seq(vec![
+            TestAgent::status_applying(),
+            rolled_back_status.clone(),
+            // The rolled back config is not applied again
+            rolled_back_status,
+        ]);
+
+        let sub_agent = sub_agent(
+            Some(opamp_client),
+            MockSupervisorBuilder::new(),
+            config_repository.clone(),
+        )
+        .with_remote_config_status(&RemoteConfigStatusConfig {
+            health_grace_period: Duration::from_secs(60).into(),
+            rollback_on_failure: true,
+        });
+        // The agent was running on its local config
+        sub_agent.set_last_good_config(Some(Config::LocalConfig(
+            TestAgent::valid_config_yaml().into(),
+        )));
+
+        let mut rolled_back_supervisor = MockSupervisor::new();
+        rolled_back_supervisor
+            .expect_apply()
+            .once()
+            .return_once(|_| Ok(MockSupervisor::new()));
+        let mut old_supervisor = MockSupervisor::new();
+        old_supervisor
+            .expect_apply()
+            .once()
+            .return_once(|_| Ok(rolled_back_supervisor));
+
+        let opamp_client = sub_agent.maybe_opamp_client.as_ref().unwrap();
+        let supervisor = sub_agent.handle_remote_config(
+            opamp_client,
+            TestAgent::valid_remote_config(),
+            Some(old_supervisor),
+        );
+        sub_agent.record_pending_config_health(&HealthWithStartTime::from_unhealthy(
+            Unhealthy::new("failing".to_string()),
+            SystemTime::now(),
+        ));
+        let supervisor = sub_agent.report_pending_config_state(supervisor);
+        assert!(supervisor.is_some());
+
+        // The failed remote config is discarded
+        assert!(
+            config_repository
+                .get_remote_config(&TestAgent::id())
+                .unwrap()
+                .is_none()
+        );
+
+        let supervisor = sub_agent.handle_remote_config(
+            opamp_client,
+            TestAgent::valid_remote_config(),
+            supervisor,
+        );
+        assert!(supervisor.is_some());
+    }
+
+    #[test]
+    fn test_remote_config_applying_but_failed_to_apply_transient_error() {
+        let (config_repository, mut opamp_client) = test_mocks();
+
+        let supervisor_builder = MockSupervisorBuilder::new();
+
+        let apply_fail_reason = "registry unavailable";
+
+        opamp_client.should_update_effective_config(1);
+        opamp_client.should_set_remote_config_status_seq(vec![
+            TestAgent::status_applying(),
+            TestAgent::status_apply_failed_config_error(apply_fail_reason),
+        ]);
+
+        let sub_agent = sub_agent(
+            Some(opamp_client),
+            supervisor_builder,
+            config_repository.clone(),
+        );
+
+        let old_supervisor = Some(expect_supervisor_apply_failure(
+            apply_fail_reason,
+            ErrorKind::Transient,
+        ));
+
+        sub_agent.handle_remote_config(
+            sub_agent.maybe_opamp_client.as_ref().unwrap(),
+            TestAgent::valid_remote_config(),
+            old_supervisor,
+        );
+
+        // The failure is reported but not persisted, so the config is applied again on restart
+        assert_remote_config(
+            config_repository.as_ref(),
+            &TestAgent::id(),
+            |remote_config| {
+                assert!(remote_config.state.is_applying());
+            },
+        );
+    }
+
     #[test]
     fn test_remote_config_applying_to_applied() {
         let (config_repository, mut opamp_client) = test_mocks();
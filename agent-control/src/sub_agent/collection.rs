@@ -2,12 +2,19 @@
 
 use super::{StartedSubAgent, error::SubAgentCollectionError};
 use crate::agent_control::agent_id::AgentID;
-use std::collections::HashMap;
+use std::collections::{HashMap, HashSet};
+use std::time::Duration;
 use tracing::{error, info};
 
-pub(crate) struct StartedSubAgents<S>(HashMap<AgentID, S>)
+pub(crate) struct StartedSubAgents<S>
 where
-    S: StartedSubAgent;
+    S: StartedSubAgent,
+{
+    agents: HashMap<AgentID, S>,
+    /// Sub-agents requested to stop with [StartedSubAgents::request_stop], kept until their
+    /// runtime finishes.
+    stopping: HashSet<AgentID>,
+}
 
 impl<S> StartedSubAgents<S>
 where
@@ -19,11 +26,12 @@ where
         agent_id: &AgentID,
     ) -> Result<(), SubAgentCollectionError> {
         let sub_agent =
-            self.0
+            self.agents
                 .remove(agent_id)
                 .ok_or(SubAgentCollectionError::SubAgentNotFound(
                     agent_id.to_string(),
                 ))?;
+        self.stopping.remove(agent_id);
 
         info!("Stopping sub agent");
         Self::stop_sub_agent(sub_agent);
@@ -31,12 +39,67 @@ where
         Ok(())
     }
 
+    /// Requests the sub-agent to stop, without waiting for its runtime to finish, as it might never
+    /// do if stalled. It is kept until [StartedSubAgents::remove_finished] finds its runtime
+    /// finished.
+    #[tracing::instrument(skip_all)]
+    pub(crate) fn request_stop(
+        &mut self,
+        agent_id: &AgentID,
+    ) -> Result<(), SubAgentCollectionError> {
+        let sub_agent =
+            self.agents
+                .get(agent_id)
+                .ok_or(SubAgentCollectionError::SubAgentNotFound(
+                    agent_id.to_string(),
+                ))?;
+
+        info!("Requesting sub agent to stop");
+        sub_agent.request_stop();
+        self.stopping.insert(agent_id.clone());
+
+        Ok(())
+    }
+
+    /// Removes the sub-agents requested to stop whose runtime finished, returning their ids.
+    pub(crate) fn remove_finished(&mut self) -> Vec<AgentID> {
+        let finished: Vec<AgentID> = self
+            .stopping
+            .iter()
+            .filter(|agent_id| self.agents.get(*agent_id).is_none_or(S::is_finished))
+            .cloned()
+            .collect();
+        for agent_id in &finished {
+            self.stopping.remove(agent_id);
+            if let Some(sub_agent) = self.agents.remove(agent_id) {
+                Self::stop_sub_agent(sub_agent);
+            }
+        }
+        finished
+    }
+
+    /// Returns the sub-agents whose runtime made no progress within `deadline`, along with the
+    /// time elapsed since they last did. Sub-agents already requested to stop are left out.
+    pub(crate) fn stalled(&self, deadline: Duration) -> Vec<(&AgentID, &S, Duration)> {
+        self.agents
+            .iter()
+            .filter(|(agent_id, _)| !self.stopping.contains(*agent_id))
+            .map(|(agent_id, sub_agent)| (agent_id, sub_agent, sub_agent.since_last_progress()))
+            .filter(|(_, _, since_last_progress)| *since_last_progress > deadline)
+            .collect()
+    }
+
     pub(crate) fn insert(&mut self, agent_id: AgentID, sub_agent: S) -> Option<S> {
-        self.0.insert(agent_id, sub_agent)
+        self.stopping.remove(&agent_id);
+        self.agents.insert(agent_id, sub_agent)
+    }
+
+    pub(crate) fn agent_ids(&self) -> impl Iterator<Item = &AgentID> {
+        self.agents.keys()
     }
 
     pub(crate) fn stop(self) {
-        self.0.into_iter().for_each(|(_, sub_agent)| {
+        self.agents.into_iter().for_each(|(_, sub_agent)| {
             info!("Stopping sub agent");
             Self::stop_sub_agent(sub_agent);
         })
@@ -55,7 +118,10 @@ where
     S: StartedSubAgent,
 {
     fn default() -> Self {
-        StartedSubAgents(HashMap::default())
+        Self {
+            agents: HashMap::default(),
+            stopping: HashSet::default(),
+        }
     }
 }
 
@@ -65,14 +131,16 @@ pub mod tests {
     use crate::agent_control::agent_id::AgentID;
     use crate::sub_agent::StartedSubAgent;
     use crate::sub_agent::collection::StartedSubAgents;
+    use crate::sub_agent::tests::MockStartedSubAgent;
     use std::collections::HashMap;
+    use std::time::Duration;
 
     impl<S> StartedSubAgents<S>
     where
         S: StartedSubAgent,
     {
         pub(crate) fn agents(&mut self) -> &mut HashMap<AgentID, S> {
-            &mut self.0
+            &mut self.agents
         }
     }
 
@@ -80,8 +148,11 @@ pub mod tests {
     where
         S: StartedSubAgent,
     {
-        fn from(value: HashMap<AgentID, S>) -> Self {
-            StartedSubAgents(value)
+        fn from(agents: HashMap<AgentID, S>) -> Self {
+            StartedSubAgents {
+                agents,
+                ..Default::default()
+            }
         }
     }
 
@@ -90,7 +161,50 @@ pub mod tests {
         S: StartedSubAgent,
     {
         fn from(value: StartedSubAgents<S>) -> Self {
-            value.0
+            value.agents
         }
     }
+
+    #[test]
+    fn test_stalled_sub_agents_removed_once_finished() {
+        let mut stalled = MockStartedSubAgent::new();
+        stalled
+            .expect_since_last_progress()
+            .returning(|| Duration::from_secs(120));
+        stalled.expect_request_stop().once().return_const(());
+        let mut finished = [false, true].into_iter();
+        stalled
+            .expect_is_finished()
+            .times(2)
+            .returning(move || finished.next().unwrap());
+        stalled.should_stop();
+        let mut running = MockStartedSubAgent::new();
+        running
+            .expect_since_last_progress()
+            .returning(|| Duration::from_secs(1));
+
+        let stalled_id = AgentID::try_from("stalled").unwrap();
+        let running_id = AgentID::try_from("running").unwrap();
+        let mut sub_agents = StartedSubAgents::from(HashMap::from([
+            (stalled_id.clone(), stalled),
+            (running_id.clone(), running),
+        ]));
+
+        let stalled_ids: Vec<_> = sub_agents
+            .stalled(Duration::from_secs(60))
+            .into_iter()
+            .map(|(agent_id, _, _)| agent_id.clone())
+            .collect();
+        assert_eq!(stalled_ids, vec![stalled_id.clone()]);
+
+        sub_agents.request_stop(&stalled_id).unwrap();
+        assert!(sub_agents.stalled(Duration::from_secs(60)).is_empty());
+        assert!(sub_agents.remove_finished().is_empty());
+        assert_eq!(sub_agents.remove_finished(), vec![stalled_id.clone()]);
+        assert!(sub_agents.request_stop(&stalled_id).is_err());
+        assert_eq!(
+            sub_agents.agent_ids().collect::<Vec<_>>(),
+            vec![&running_id]
+        );
+    }
 }
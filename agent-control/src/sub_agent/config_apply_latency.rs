@@ -0,0 +1,137 @@
+//! Telemetry about the time remote configurations take to be applied.
+//!
+//! The time from receiving a remote configuration to the sub-agent reporting healthy on it is
+//! reported as metrics, broken down by phase, to monitor the performance of fleet rollouts.
+
+use crate::checkers::health::with_start_time::HealthWithStartTime;
+use std::time::{Duration, Instant, SystemTime};
+use tracing::{debug, trace};
+
+/// Phases of the apply of a remote configuration.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ConfigApplyPhase {
+    /// Parsing and validation (e.g. signature) of the received configuration.
+    Validation,
+    /// Merge with the local configuration and rendering of the effective agent.
+    Merge,
+    /// Persistence of the configuration.
+    Write,
+    /// Start or restart of the supervisor with the new configuration.
+    Restart,
+}
+
+impl ConfigApplyPhase {
+    /// Name of the phase, reported as the `phase` metric attribute.
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Validation => "validation",
+            Self::Merge => "merge",
+            Self::Write => "write",
+            Self::Restart => "restart",
+        }
+    }
+}
+
+/// Tracks the apply of a remote configuration, from its reception until the sub-agent is healthy.
+#[derive(Debug)]
+pub struct ConfigApplyLatency {
+    received_at: Instant,
+    phases: Vec<(ConfigApplyPhase, Duration)>,
+    applied_at: Option<SystemTime>,
+}
+
+impl ConfigApplyLatency {
+    /// Starts tracking a configuration received now.
+    pub fn start() -> Self {
+        Self {
+            received_at: Instant::now(),
+            phases: Vec::new(),
+            applied_at: None,
+        }
+    }
+
+    /// Records the duration of a phase. Durations of the same phase are added up.
+    pub fn record(&mut self, phase: ConfigApplyPhase, duration: Duration) {
+        match self.phases.iter_mut().find(|(p, _)| *p == phase) {
+            Some((_, total)) => *total += duration,
+            None => self.phases.push((phase, duration)),
+        }
+    }
+
+    /// Marks the configuration as applied. Only the health reported afterwards is considered
+    /// to be the health on the new configuration.
+    pub fn applied(&mut self) {
+        self.applied_at = Some(SystemTime::now());
+    }
+
+    /// Reports the metrics if `health` is healthy and was checked after the configuration was
+    /// applied. Returns whether they were reported, so tracking can stop.
+    pub fn report_if_healthy(&self, health: &HealthWithStartTime) -> bool {
+        let Some(applied_at) = self.applied_at else {
+            return false;
+        };
+        if !health.is_healthy() || health.status_time() < applied_at {
+            return false;
+        }
+
+        let total = self.received_at.elapsed();
+        for (phase, duration) in &self.phases {
+            trace!(
+                histogram.config_apply_phase_duration_seconds = duration.as_secs_f64(),
+                phase = phase.as_str()
+            );
+        }
+        trace!(histogram.config_apply_duration_seconds = total.as_secs_f64());
+        debug!(
+            phases = ?self.phases,
+            "Remote configuration applied and healthy after {total:?}"
+        );
+        true
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::checkers::health::health_checker::{Healthy, Unhealthy};
+
+    #[test]
+    fn test_record_adds_up_phases() {
+        let mut latency = ConfigApplyLatency::start();
+        latency.record(ConfigApplyPhase::Merge, Duration::from_millis(10));
+        latency.record(ConfigApplyPhase::Write, Duration::from_millis(5));
+        latency.record(ConfigApplyPhase::Merge, Duration::from_millis(20));
+
+        assert_eq!(
+            latency.phases,
+            vec![
+                (ConfigApplyPhase::Merge, Duration::from_millis(30)),
+                (ConfigApplyPhase::Write, Duration::from_millis(5)),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_report_if_healthy() {
+        let mut latency = ConfigApplyLatency::start();
+        let previous_healthy = HealthWithStartTime::from_healthy(
+            Healthy::new().with_status_time(SystemTime::now() - Duration::from_secs(1)),
+            SystemTime::now(),
+        );
+        // Not applied yet
+        assert!(!latency.report_if_healthy(&previous_healthy));
+
+        latency.applied();
+        // Health checked before the configuration was applied
+        assert!(!latency.report_if_healthy(&previous_healthy));
+
+        let unhealthy = HealthWithStartTime::from_unhealthy(
+            Unhealthy::new("failing".to_string()),
+            SystemTime::now(),
+        );
+        assert!(!latency.report_if_healthy(&unhealthy));
+
+        let healthy = HealthWithStartTime::from_healthy(Healthy::new(), SystemTime::now());
+        assert!(latency.report_if_healthy(&healthy));
+    }
+}
@@ -0,0 +1,186 @@
+//! Non-fatal checks of sub-agent configurations.
+//!
+//! Linting produces warnings about configurations that are valid but likely wrong or about to
+//! break (e.g. deprecated fields), so operators can fix them before they become failures.
+
+use crate::agent_control::defaults::AGENT_TYPE_NAME_NRDOT;
+use crate::agent_type::agent_type_id::AgentTypeID;
+use crate::values::yaml_config::YAMLConfig;
+use serde_json::Value;
+use std::collections::HashSet;
+
+/// Variable holding the collector configuration in the NRDOT agent type.
+const NRDOT_CONFIG_VARIABLE: &str = "config";
+/// Endpoint domains of the New Relic OTLP ingest.
+const NEW_RELIC_OTLP_DOMAINS: [&str; 2] = ["nr-data.net", "newrelic.com"];
+/// Header carrying the license key in the New Relic OTLP ingest.
+const NEW_RELIC_API_KEY_HEADER: &str = "api-key";
+
+/// Returns the lint warnings of the configuration of an agent of the given type.
+pub fn lint(agent_type_id: &AgentTypeID, config: &YAMLConfig) -> Vec<String> {
+    if agent_type_id.name() != AGENT_TYPE_NAME_NRDOT {
+        return Vec::new();
+    }
+    config
+        .get(NRDOT_CONFIG_VARIABLE)
+        .map(lint_collector_config)
+        .unwrap_or_default()
+}
+
+fn lint_collector_config(config: &Value) -> Vec<String> {
+    let mut warnings = Vec::new();
+
+    if let Some(exporters) = config.get("exporters").and_then(Value::as_object) {
+        for (name, exporter) in exporters {
+            if component_type(name) == "logging" {
+                warnings.push(format!(
+                    "exporter '{name}' is deprecated, use the 'debug' exporter instead"
+                ));
+            }
+            if component_type(name).starts_with("otlp") && is_new_relic_without_api_key(exporter) {
+                warnings.push(format!(
+                    "exporter '{name}' sends data to New Relic without an '{NEW_RELIC_API_KEY_HEADER}' header"
+                ));
+            }
+        }
+    }
+
+    if config
+        .pointer("/service/telemetry/metrics/address")
+        .is_some()
+    {
+        warnings.push(
+            "'service::telemetry::metrics::address' is deprecated, use 'service::telemetry::metrics::readers' instead"
+                .to_string(),
+        );
+    }
+
+    if let Some(pipelines) = config
+        .pointer("/service/pipelines")
+        .and_then(Value::as_object)
+    {
+        for (pipeline, pipeline_config) in pipelines {
+            let receivers = pipeline_config
+                .get("receivers")
+                .and_then(Value::as_array)
+                .into_iter()
+                .flatten()
+                .filter_map(Value::as_str);
+            let mut seen = HashSet::new();
+            for receiver in receivers {
+                if !seen.insert(receiver) {
+                    warnings.push(format!(
+                        "receiver '{receiver}' is listed more than once in pipeline '{pipeline}'"
+                    ));
+                }
+            }
+        }
+    }
+
+    warnings
+}
+
+/// Returns the type of a component from its `type[/name]` identifier.
+fn component_type(id: &str) -> &str {
+    id.split_once('/')
+        .map_or(id, |(component_type, _)| component_type)
+}
+
+fn is_new_relic_without_api_key(exporter: &Value) -> bool {
+    let sends_to_new_relic = exporter
+        .get("endpoint")
+        .and_then(Value::as_str)
+        .is_some_and(|endpoint| {
+            NEW_RELIC_OTLP_DOMAINS
+                .iter()
+                .any(|domain| endpoint.contains(domain))
+        });
+    let has_api_key = exporter
+        .get("headers")
+        .and_then(Value::as_object)
+        .is_some_and(|headers| {
+            headers
+                .keys()
+                .any(|header| header.eq_ignore_ascii_case(NEW_RELIC_API_KEY_HEADER))
+        });
+    sends_to_new_relic && !has_api_key
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+
+    const NRDOT_AGENT_TYPE: &str = "newrelic/com.newrelic.opentelemetry.collector:0.1.0";
+
+    #[rstest]
+    #[case::valid_config(
+        r#"
+config:
+  exporters:
+    otlphttp:
+      endpoint: https://otlp.nr-data.net
+      headers:
+        api-key: some-key
+    debug: {}
+  service:
+    pipelines:
+      metrics:
+        receivers: [otlp, hostmetrics]
+        exporters: [otlphttp]
+"#,
+        &[]
+    )]
+    #[case::deprecated_fields(
+        r#"
+config:
+  exporters:
+    logging/detailed:
+      verbosity: detailed
+  service:
+    telemetry:
+      metrics:
+        address: 0.0.0.0:8888
+"#,
+        &[
+            "exporter 'logging/detailed' is deprecated, use the 'debug' exporter instead",
+            "'service::telemetry::metrics::address' is deprecated, use 'service::telemetry::metrics::readers' instead",
+        ]
+    )]
+    #[case::duplicated_receivers(
+        r#"
+config:
+  service:
+    pipelines:
+      logs:
+        receivers: [filelog, otlp, filelog]
+"#,
+        &["receiver 'filelog' is listed more than once in pipeline 'logs'"]
+    )]
+    #[case::missing_api_key(
+        r#"
+config:
+  exporters:
+    otlp/nr:
+      endpoint: otlp.eu01.nr-data.net:4317
+    otlp/other:
+      endpoint: collector.example.com:4317
+"#,
+        &["exporter 'otlp/nr' sends data to New Relic without an 'api-key' header"]
+    )]
+    fn test_lint_nrdot(#[case] config: &str, #[case] expected: &[&str]) {
+        let agent_type_id = AgentTypeID::try_from(NRDOT_AGENT_TYPE).unwrap();
+        let config = YAMLConfig::try_from(config).unwrap();
+
+        assert_eq!(lint(&agent_type_id, &config), expected);
+    }
+
+    #[test]
+    fn test_lint_other_agent_types() {
+        let agent_type_id =
+            AgentTypeID::try_from("newrelic/com.newrelic.infrastructure:0.1.0").unwrap();
+        let config = YAMLConfig::try_from("config:\n  exporters:\n    logging: {}\n").unwrap();
+
+        assert!(lint(&agent_type_id, &config).is_empty());
+    }
+}
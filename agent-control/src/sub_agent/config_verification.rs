@@ -0,0 +1,146 @@
+//! Verification of the health of sub-agents on newly applied remote configurations.
+//!
+//! By default, a remote configuration is reported as applied as soon as the supervisor is started
+//! or updated with it. When a health grace period is configured, the configuration remains
+//! applying (also in the storage, so it is verified again if Agent Control restarts) until the
+//! grace period elapses. Then it is reported as applied if the agent is healthy, or as failed with
+//! the health error otherwise.
+//!
+//! Failed configurations can be rolled back: the last known good configuration of the agent, the
+//! last one it was healthy on, is restored and applied again, and the failed configuration is not
+//! applied again if Fleet Control sends it once more.
+//!
+//! ```yaml
+//! remote_config_status:
+//!   health_grace_period: 2m
+//!   rollback_on_failure: true
+//! ```
+
+use crate::checkers::health::with_start_time::HealthWithStartTime;
+use crate::opamp::remote_config::hash::{ConfigState, Hash};
+use duration_str::deserialize_duration;
+use serde::Deserialize;
+use std::time::{Duration, Instant, SystemTime};
+use wrapper_with_default::WrapperWithDefault;
+
+const DEFAULT_HEALTH_GRACE_PERIOD: Duration = Duration::ZERO;
+
+/// Configuration of the remote configuration status reported to Fleet Control.
+#[derive(Debug, Deserialize, PartialEq, Clone, Default)]
+pub struct RemoteConfigStatusConfig {
+    /// Time the agent must run on a new configuration before it is reported as applied.
+    /// Zero reports it as applied as soon as the agent is started with it.
+    #[serde(default)]
+    pub health_grace_period: HealthGracePeriod,
+    /// Restore the last known good configuration when the agent is unhealthy on a new one once
+    /// the grace period elapses.
+    #[serde(default)]
+    pub rollback_on_failure: bool,
+}
+
+/// Time the agent must run on a new configuration before it is reported as applied.
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_HEALTH_GRACE_PERIOD)]
+pub struct HealthGracePeriod(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// A configuration the agent was started with, waiting for the grace period to report its state.
+#[derive(Debug)]
+pub struct PendingConfig {
+    hash: Hash,
+    applied_at: SystemTime,
+    deadline: Instant,
+    last_health: Option<HealthWithStartTime>,
+}
+
+impl PendingConfig {
+    /// Starts verifying the configuration the agent was just started with.
+    pub fn new(hash: Hash, grace_period: Duration) -> Self {
+        Self {
+            hash,
+            applied_at: SystemTime::now(),
+            deadline: Instant::now() + grace_period,
+            last_health: None,
+        }
+    }
+
+    /// Hash of the configuration.
+    pub fn hash(&self) -> &Hash {
+        &self.hash
+    }
+
+    /// Instant when the grace period elapses.
+    pub fn deadline(&self) -> Instant {
+        self.deadline
+    }
+
+    /// Records the health of the agent. Health checked before the configuration was applied
+    /// belongs to the previous one, so it is ignored.
+    pub fn record(&mut self, health: &HealthWithStartTime) {
+        if health.status_time() >= self.applied_at {
+            self.last_health = Some(health.clone());
+        }
+    }
+
+    /// Final state of the configuration: failed if the agent is unhealthy on it, applied
+    /// otherwise.
+    pub fn state(&self) -> ConfigState {
+        match self.last_health.as_ref().and_then(|h| h.last_error()) {
+            Some(last_error) => ConfigState::Failed {
+                error_message: format!("agent unhealthy on the configuration: {last_error}"),
+            },
+            None => ConfigState::Applied,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::checkers::health::health_checker::{Healthy, Unhealthy};
+
+    #[test]
+    fn test_pending_config_state() {
+        let mut pending = PendingConfig::new(Hash::from("hash"), Duration::from_secs(60));
+        // Nothing reported, the agent is running on it
+        assert_eq!(pending.state(), ConfigState::Applied);
+
+        let previous_unhealthy = HealthWithStartTime::from_unhealthy(
+            Unhealthy::new("previous config".to_string())
+                .with_status_time(SystemTime::now() - Duration::from_secs(1)),
+            SystemTime::now(),
+        );
+        pending.record(&previous_unhealthy);
+        assert_eq!(pending.state(), ConfigState::Applied);
+
+        let unhealthy = HealthWithStartTime::from_unhealthy(
+            Unhealthy::new("failing".to_string()),
+            SystemTime::now(),
+        );
+        pending.record(&unhealthy);
+        assert_eq!(
+            pending.state(),
+            ConfigState::Failed {
+                error_message: "agent unhealthy on the configuration: failing".to_string()
+            }
+        );
+
+        let healthy = HealthWithStartTime::from_healthy(Healthy::new(), SystemTime::now());
+        pending.record(&healthy);
+        assert_eq!(pending.state(), ConfigState::Applied);
+    }
+
+    #[test]
+    fn test_health_grace_period_config() {
+        let config: RemoteConfigStatusConfig = serde_saphyr::from_str("{}").unwrap();
+        assert_eq!(Duration::from(config.health_grace_period), Duration::ZERO);
+        assert!(!config.rollback_on_failure);
+
+        let config: RemoteConfigStatusConfig =
+            serde_saphyr::from_str("health_grace_period: 2m\nrollback_on_failure: true").unwrap();
+        assert_eq!(
+            Duration::from(config.health_grace_period),
+            Duration::from_secs(120)
+        );
+        assert!(config.rollback_on_failure);
+    }
+}
@@ -14,6 +14,9 @@ use crate::agent_type::variable::secret_variables::{
 };
 use crate::secrets_provider::SecretsProviders;
 use crate::sub_agent::identity::AgentIdentity;
+use crate::sub_agent::on_host::config_validation::{ConfigValidationError, validate_config};
+use crate::sub_agent::on_host::default_exporter::DefaultExporter;
+use crate::utils::error_kind::{ClassifiedError, ErrorKind};
 use crate::values::yaml_config::YAMLConfig;
 
 use std::fmt::Display;
@@ -42,6 +45,21 @@ pub enum EffectiveAgentsAssemblerError {
     /// Secret variables could not be loaded.
     #[error("error loading secrets: {0}")]
     SecretVariablesError(#[from] SecretVariablesError),
+    /// The agent rejected the configuration.
+    #[error("error validating the config: {0}")]
+    ConfigValidationError(#[from] ConfigValidationError),
+}
+
+impl ClassifiedError for EffectiveAgentsAssemblerError {
+    fn kind(&self) -> ErrorKind {
+        match self {
+            // Secrets are fetched from external providers which might be temporarily unavailable
+            Self::SecretVariablesError(SecretVariablesError::SecretsLoadError { .. }) => {
+                ErrorKind::Transient
+            }
+            _ => ErrorKind::Misconfiguration,
+        }
+    }
 }
 
 /// An agent with its identity and fully rendered runtime configuration.
@@ -134,6 +152,8 @@ where
     variable_constraints: VariableConstraints,
     secrets_providers: SecretsProviders,
     remote_dir: PathBuf,
+    agent_filesystem_dir: Option<PathBuf>,
+    default_exporter: Option<DefaultExporter>,
 }
 
 impl<R> LocalEffectiveAgentsAssembler<R>
@@ -155,6 +175,25 @@ where
             variable_constraints,
             secrets_providers,
             remote_dir: remote_dir.to_path_buf(),
+            agent_filesystem_dir: None,
+            default_exporter: None,
+        }
+    }
+
+    /// Renders the sub-agents' dedicated filesystem directories under `agent_filesystem_dir`
+    /// instead of the remote configuration directory.
+    pub fn with_agent_filesystem_dir(self, agent_filesystem_dir: PathBuf) -> Self {
+        Self {
+            agent_filesystem_dir: Some(agent_filesystem_dir),
+            ..self
+        }
+    }
+
+    /// Adds the default exporter to the collector configs that have none. See [DefaultExporter].
+    pub fn with_default_exporter(self, default_exporter: DefaultExporter) -> Self {
+        Self {
+            default_exporter: Some(default_exporter),
+            ..self
         }
     }
 }
@@ -175,11 +214,14 @@ where
             .get(&agent_identity.agent_type_id)?
             .with_constraints(&self.variable_constraints);
 
-        let attributes =
+        let mut attributes =
             AgentAttributes::try_new(agent_identity.id.to_owned(), self.remote_dir.to_path_buf())
                 .map_err(|e| {
                 EffectiveAgentsAssemblerError::EffectiveAgentsAssemblerError(e.to_string())
             })?;
+        if let Some(agent_filesystem_dir) = &self.agent_filesystem_dir {
+            attributes = attributes.with_filesystem_dir(agent_filesystem_dir);
+        }
 
         // Values are expanded substituting all ${nr-env...} with environment variables.
         // Notice that only environment variables are taken into consideration (no other vars for example)
@@ -187,10 +229,28 @@ where
         let env_vars = load_env_vars();
         let secrets = secret_variables.load_secrets(&self.secrets_providers)?;
 
-        let runtime_config = self
+        let default_exporter = self
+            .default_exporter
+            .as_ref()
+            .filter(|exporter| exporter.applies_to(&agent_identity.agent_type_id, &values));
+        let values = match default_exporter {
+            Some(default_exporter) => {
+                default_exporter.scaffold(&agent_identity.agent_type_id, values)
+            }
+            None => values,
+        };
+
+        let mut runtime_config = self
             .renderer
             .render(agent_type, values, attributes, env_vars, secrets)?;
 
+        if let rendered::Deployment::Host(on_host) = &mut runtime_config.deployment {
+            if let Some(default_exporter) = default_exporter {
+                default_exporter.set_license_key_env(on_host);
+            }
+            validate_config(on_host)?;
+        }
+
         Ok(EffectiveAgent::new(agent_identity.clone(), runtime_config))
     }
 }
@@ -235,6 +295,8 @@ pub(crate) mod tests {
                 variable_constraints: VariableConstraints::default(),
                 secrets_providers: SecretsProviders::default(),
                 remote_dir: PathBuf::default(),
+                agent_filesystem_dir: None,
+                default_exporter: None,
             }
         }
     }
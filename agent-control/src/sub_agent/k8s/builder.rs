@@ -1,14 +1,19 @@
 //! Builders for Kubernetes sub-agents and their supervisors.
 
-use crate::agent_control::config::K8sConfig;
-use crate::agent_control::defaults::{CLUSTER_NAME_ATTRIBUTE_KEY, OPAMP_SERVICE_VERSION};
+use crate::agent_control::config::{K8sConfig, ReleaseChannel};
+use crate::agent_control::defaults::{
+    CLUSTER_NAME_ATTRIBUTE_KEY, OPAMP_SERVICE_VERSION, RELEASE_CHANNEL_ATTRIBUTE_KEY,
+};
+use crate::audit::AuditTrail;
 use crate::event::SubAgentEvent;
 use crate::event::broadcaster::unbounded::UnboundedBroadcast;
 use crate::event::channel::pub_sub;
 use crate::k8s::client::{K8sClient, SyncK8sClient};
+use crate::lifecycle_hooks::LifecycleHooks;
 use crate::opamp::instance_id::getter::InstanceIDGetter;
 use crate::opamp::operations::sub_agent_start_settings;
 use crate::sub_agent::SubAgent;
+use crate::sub_agent::config_verification::RemoteConfigStatusConfig;
 use crate::sub_agent::effective_agents_assembler::{EffectiveAgent, EffectiveAgentsAssembler};
 use crate::sub_agent::identity::AgentIdentity;
 use crate::sub_agent::k8s::supervisor::SupervisorError;
@@ -43,6 +48,13 @@ where
     pub(crate) config_repository: Arc<Y>,
     pub(crate) effective_agents_assembler: Arc<A>,
     pub(crate) sub_agent_publisher: UnboundedBroadcast<SubAgentEvent>,
+    pub(crate) release_channel: ReleaseChannel,
+    /// Verification of the remote configurations applied to the agents.
+    pub(crate) remote_config_status: RemoteConfigStatusConfig,
+    /// Hooks notified of the lifecycle events of the agents.
+    pub(crate) lifecycle_hooks: LifecycleHooks,
+    /// Records the configurations applied to the agents.
+    pub(crate) audit_trail: AuditTrail,
 }
 
 impl<O, I, B, R, Y, A> SubAgentBuilder for K8sSubAgentBuilder<O, I, B, R, Y, A>
@@ -66,10 +78,16 @@ where
         let opamp_start_settings = sub_agent_start_settings(
             &self.instance_id_getter,
             agent_identity,
-            HashMap::from([(
-                OPAMP_SERVICE_VERSION.to_string(),
-                agent_identity.agent_type_id.version().to_string().into(),
-            )]),
+            HashMap::from([
+                (
+                    OPAMP_SERVICE_VERSION.to_string(),
+                    agent_identity.agent_type_id.version().to_string().into(),
+                ),
+                (
+                    RELEASE_CHANNEL_ATTRIBUTE_KEY.to_string(),
+                    self.release_channel.to_string().into(),
+                ),
+            ]),
             HashMap::from([(
                 CLUSTER_NAME_ATTRIBUTE_KEY.to_string(),
                 DescriptionValueType::String(self.k8s_config.cluster_name.to_string()),
@@ -106,7 +124,10 @@ where
             self.remote_config_parser.clone(),
             self.config_repository.clone(),
             self.effective_agents_assembler.clone(),
-        ))
+        )
+        .with_remote_config_status(&self.remote_config_status)
+        .with_lifecycle_hooks(self.lifecycle_hooks.clone())
+        .with_audit_trail(self.audit_trail.clone()))
     }
 }
 
@@ -174,6 +195,7 @@ pub mod tests {
     use crate::agent_control::agent_id::AgentID;
 
     use crate::agent_control::defaults::{
+        INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY, INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE,
         PARENT_AGENT_ID_ATTRIBUTE_KEY, default_capabilities, default_custom_capabilities,
     };
     use crate::agent_type::agent_type_id::AgentTypeID;
@@ -232,6 +254,10 @@ pub mod tests {
             config_repository: Arc::new(MockConfigRepository::new()),
             effective_agents_assembler: Arc::new(effective_agents_assembler),
             sub_agent_publisher: UnboundedBroadcast::default(),
+            release_channel: ReleaseChannel::default(),
+            remote_config_status: RemoteConfigStatusConfig::default(),
+            lifecycle_hooks: LifecycleHooks::default(),
+            audit_trail: AuditTrail::default(),
         };
 
         builder.build(&agent_identity).unwrap();
@@ -268,6 +294,10 @@ pub mod tests {
             config_repository: Arc::new(MockConfigRepository::new()),
             effective_agents_assembler: Arc::new(effective_agents_assembler),
             sub_agent_publisher: UnboundedBroadcast::default(),
+            release_channel: ReleaseChannel::default(),
+            remote_config_status: RemoteConfigStatusConfig::default(),
+            lifecycle_hooks: LifecycleHooks::default(),
+            audit_trail: AuditTrail::default(),
         };
 
         let result = builder.build(&agent_identity);
@@ -359,10 +389,16 @@ pub mod tests {
 
         let agent_description = agent_description(
             &agent_identity,
-            HashMap::from([(
-                OPAMP_SERVICE_VERSION.to_string(),
-                agent_identity.agent_type_id.version().to_string().into(),
-            )]),
+            HashMap::from([
+                (
+                    OPAMP_SERVICE_VERSION.to_string(),
+                    agent_identity.agent_type_id.version().to_string().into(),
+                ),
+                (
+                    RELEASE_CHANNEL_ATTRIBUTE_KEY.to_string(),
+                    "stable".to_string().into(),
+                ),
+            ]),
             HashMap::from([
                 (
                     CLUSTER_NAME_ATTRIBUTE_KEY.to_string(),
@@ -372,6 +408,10 @@ pub mod tests {
                     PARENT_AGENT_ID_ATTRIBUTE_KEY.to_string(),
                     DescriptionValueType::Bytes(instance_id.clone().into()),
                 ),
+                (
+                    INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY.to_string(),
+                    INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE.into(),
+                ),
             ]),
         );
 
@@ -19,6 +19,7 @@ use crate::k8s::utils::retain_not_null;
 use crate::sub_agent::effective_agents_assembler::{EffectiveAgent, EffectiveAgentsAssemblerError};
 use crate::sub_agent::identity::{AgentIdentity, ID_ATTRIBUTE_NAME};
 use crate::sub_agent::supervisor::{Supervisor, SupervisorStarter};
+use crate::utils::error_kind::{ClassifiedError, ErrorKind};
 use crate::utils::thread_context::{
     NotStartedThreadContext, StartedThreadContext, ThreadCollectionStopperExt,
     ThreadContextStopperError,
@@ -62,6 +63,20 @@ pub enum SupervisorError {
     },
 }
 
+impl ClassifiedError for SupervisorError {
+    fn kind(&self) -> ErrorKind {
+        match self {
+            // Errors from the API server are usually temporary
+            Self::K8s(_) => ErrorKind::Transient,
+            Self::K8sConfig(_) | Self::RuntimeConfig(_) | Self::UnsupportedK8sObject { .. } => {
+                ErrorKind::Misconfiguration
+            }
+            Self::IncomingConfig(err) => err.kind(),
+            Self::StoppingPreviousSupervisor(_) => ErrorKind::Fatal,
+        }
+    }
+}
+
 /// A Kubernetes supervisor ready to be started.
 #[derive(Debug, Clone)]
 pub struct NotStartedSupervisorK8s<C: K8sClient = SyncK8sClient> {
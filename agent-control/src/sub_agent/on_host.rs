@@ -2,4 +2,12 @@
 
 pub mod builder;
 pub mod command;
+pub mod config_validation;
+pub mod crash;
+pub mod default_exporter;
+pub mod integrations;
+pub mod process_watch;
+pub mod processes;
+pub mod restart_limiter;
+pub mod restart_requests;
 pub mod supervisor;
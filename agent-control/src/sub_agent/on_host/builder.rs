@@ -1,19 +1,30 @@
 //! Builders for on-host sub-agents and their supervisors.
 
+use crate::agent_control::config::ReleaseChannel;
 use crate::agent_control::defaults::{
-    HOST_NAME_ATTRIBUTE_KEY, OPAMP_SERVICE_VERSION, OS_ATTRIBUTE_KEY, OS_ATTRIBUTE_VALUE,
+    HOST_ID_ATTRIBUTE_KEY, HOST_NAME_ATTRIBUTE_KEY, OPAMP_SERVICE_VERSION, OS_ATTRIBUTE_KEY,
+    OS_ATTRIBUTE_VALUE, RELEASE_CHANNEL_ATTRIBUTE_KEY,
 };
-use crate::event::SubAgentEvent;
+use crate::audit::AuditTrail;
 use crate::event::broadcaster::unbounded::UnboundedBroadcast;
+use crate::event::channel::EventPublisher;
 use crate::event::channel::pub_sub;
+use crate::event::{AgentControlInternalEvent, SubAgentEvent};
+use crate::lifecycle_hooks::LifecycleHooks;
 use crate::opamp::client_builder::BuildOpAMPClient;
 use crate::opamp::instance_id::getter::InstanceIDGetter;
 use crate::opamp::operations::sub_agent_start_settings;
 use crate::package::manager::PackageManager;
 use crate::sub_agent::SubAgent;
+use crate::sub_agent::config_verification::RemoteConfigStatusConfig;
 use crate::sub_agent::effective_agents_assembler::{EffectiveAgent, EffectiveAgentsAssembler};
 use crate::sub_agent::identity::AgentIdentity;
+use crate::sub_agent::on_host::command::allow_list::ExecutableAllowList;
 use crate::sub_agent::on_host::command::executable_data::ExecutableData;
+use crate::sub_agent::on_host::crash::CrashReportsConfig;
+use crate::sub_agent::on_host::process_watch::ProcessWatchConfig;
+use crate::sub_agent::on_host::processes::SupervisedProcesses;
+use crate::sub_agent::on_host::restart_limiter::RestartLimiter;
 use crate::sub_agent::on_host::supervisor::{NotStartedSupervisorOnHost, SupervisorError};
 use crate::sub_agent::remote_config_parser::RemoteConfigParser;
 use crate::sub_agent::supervisor::SupervisorBuilder;
@@ -43,6 +54,15 @@ where
     pub(crate) yaml_config_repository: Arc<Y>,
     pub(crate) effective_agents_assembler: Arc<A>,
     pub(crate) sub_agent_publisher: UnboundedBroadcast<SubAgentEvent>,
+    pub(crate) release_channel: ReleaseChannel,
+    /// Host id of the host, reported by the sub-agents so their entities relate to it.
+    pub(crate) host_id: String,
+    /// Verification of the remote configurations applied to the agents.
+    pub(crate) remote_config_status: RemoteConfigStatusConfig,
+    /// Hooks notified of the lifecycle events of the agents.
+    pub(crate) lifecycle_hooks: LifecycleHooks,
+    /// Records the configurations applied to the agents.
+    pub(crate) audit_trail: AuditTrail,
 }
 
 impl<O, I, B, R, Y, A> SubAgentBuilder for OnHostSubAgentBuilder<O, I, B, R, Y, A>
@@ -70,12 +90,22 @@ where
         let opamp_start_settings = sub_agent_start_settings(
             &self.instance_id_getter,
             agent_identity,
-            HashMap::from([(
-                OPAMP_SERVICE_VERSION.to_string(),
-                agent_identity.agent_type_id.version().to_string().into(),
-            )]),
+            HashMap::from([
+                (
+                    OPAMP_SERVICE_VERSION.to_string(),
+                    agent_identity.agent_type_id.version().to_string().into(),
+                ),
+                (
+                    RELEASE_CHANNEL_ATTRIBUTE_KEY.to_string(),
+                    self.release_channel.to_string().into(),
+                ),
+            ]),
             HashMap::from([
                 (HOST_NAME_ATTRIBUTE_KEY.to_string(), hostname),
+                (
+                    HOST_ID_ATTRIBUTE_KEY.to_string(),
+                    self.host_id.clone().into(),
+                ),
                 (
                     OS_ATTRIBUTE_KEY.to_string(),
                     DescriptionValueType::String(OS_ATTRIBUTE_VALUE.to_string()),
@@ -112,7 +142,10 @@ where
             self.remote_config_parser.clone(),
             self.yaml_config_repository.clone(),
             self.effective_agents_assembler.clone(),
-        ))
+        )
+        .with_remote_config_status(&self.remote_config_status)
+        .with_lifecycle_hooks(self.lifecycle_hooks.clone())
+        .with_audit_trail(self.audit_trail.clone()))
     }
 }
 
@@ -125,6 +158,22 @@ where
     pub logging_path: PathBuf,
     /// Package manager used to install agent packages.
     pub package_manager: Arc<PM>,
+    /// Executables the supervisors are allowed to run.
+    pub allowed_executables: ExecutableAllowList,
+    /// Crash reports configuration of the supervised executables.
+    pub crash_reports: CrashReportsConfig,
+    /// Configuration of the watch of processes started outside Agent Control.
+    pub process_watch: ProcessWatchConfig,
+    /// Registry where the supervisors register the processes they run.
+    pub supervised_processes: SupervisedProcesses,
+    /// Limiter of the restarts of the executables of every supervisor.
+    pub restart_limiter: RestartLimiter,
+    /// Hooks notified of the executables in a crash loop.
+    pub lifecycle_hooks: LifecycleHooks,
+    /// Records the supervised processes stopped or started outside Agent Control.
+    pub audit_trail: AuditTrail,
+    /// Notified of the executables exhausting their restart policy.
+    pub crash_loop_publisher: Option<EventPublisher<AgentControlInternalEvent>>,
 }
 
 impl<PM> SupervisorBuilder for SupervisorBuilderOnHost<PM>
@@ -157,6 +206,9 @@ where
                     .with_args(e.args.0)
                     .with_env(e.env.0)
                     .with_restart_policy(e.restart_policy.into())
+                    .with_scheduling(e.scheduling)
+                    .with_listen_sockets(e.listen_sockets)
+                    .with_shutdown_timeout(e.shutdown_timeout.into())
             })
             .collect();
 
@@ -169,7 +221,15 @@ where
             on_host.enable_file_logging,
             self.logging_path.to_path_buf(),
             on_host.filesystem,
-        ))
+        )
+        .with_allowed_executables(self.allowed_executables.clone())
+        .with_crash_reports(self.crash_reports.clone())
+        .with_process_watch(self.process_watch.clone())
+        .with_supervised_processes(self.supervised_processes.clone())
+        .with_restart_limiter(self.restart_limiter.clone())
+        .with_lifecycle_hooks(self.lifecycle_hooks.clone())
+        .with_audit_trail(self.audit_trail.clone())
+        .with_crash_loop_publisher(self.crash_loop_publisher.clone()))
     }
 }
 
@@ -178,6 +238,7 @@ mod tests {
     use super::*;
     use crate::agent_control::agent_id::AgentID;
     use crate::agent_control::defaults::{
+        INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY, INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE,
         OPAMP_SERVICE_NAME, OPAMP_SERVICE_NAMESPACE, OPAMP_SUPERVISOR_KEY,
         PARENT_AGENT_ID_ATTRIBUTE_KEY, default_capabilities, default_custom_capabilities,
     };
@@ -238,6 +299,11 @@ mod tests {
             yaml_config_repository: Arc::new(MockConfigRepository::new()),
             effective_agents_assembler: Arc::new(MockEffectiveAgentAssembler::new()),
             sub_agent_publisher: UnboundedBroadcast::default(),
+            release_channel: ReleaseChannel::Canary,
+            host_id: "host-id".to_string(),
+            remote_config_status: RemoteConfigStatusConfig::default(),
+            lifecycle_hooks: LifecycleHooks::default(),
+            audit_trail: AuditTrail::default(),
         };
 
         assert!(on_host_builder.build(&agent_identity).is_ok());
@@ -267,6 +333,10 @@ mod tests {
                 OPAMP_SERVICE_VERSION.to_string(),
                 agent_identity.agent_type_id.version().to_string().into(),
             ),
+            (
+                RELEASE_CHANNEL_ATTRIBUTE_KEY.to_string(),
+                "canary".to_string().into(),
+            ),
         ]);
         StartSettings {
             instance_uid: sub_agent_instance_id.into(),
@@ -279,11 +349,19 @@ mod tests {
                         HOST_NAME_ATTRIBUTE_KEY.to_string(),
                         DescriptionValueType::String(hostname.to_string()),
                     ),
+                    (
+                        HOST_ID_ATTRIBUTE_KEY.to_string(),
+                        DescriptionValueType::String("host-id".to_string()),
+                    ),
                     (
                         PARENT_AGENT_ID_ATTRIBUTE_KEY.to_string(),
                         DescriptionValueType::Bytes(agent_control_instance_id.into()),
                     ),
                     (OS_ATTRIBUTE_KEY.to_string(), OS_ATTRIBUTE_VALUE.into()),
+                    (
+                        INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY.to_string(),
+                        INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE.into(),
+                    ),
                 ]),
             },
         }
@@ -1,5 +1,6 @@
 //! OS process management for on-host executables: spawning, logging, restart policy, and shutdown.
 
+pub mod allow_list;
 pub mod command_os;
 pub mod error;
 pub mod executable_data;
@@ -7,3 +8,5 @@ pub mod executable_data;
 pub mod job_object;
 pub mod logging;
 pub mod restart_policy;
+pub mod scheduling;
+pub mod socket_activation;
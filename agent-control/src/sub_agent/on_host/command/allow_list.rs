@@ -0,0 +1,94 @@
+//! [ExecutableAllowList]: glob patterns constraining the executables on-host supervisors may run.
+
+use glob::{MatchOptions, Pattern, PatternError};
+use serde::{Deserialize, Deserializer};
+use std::path::{Component, Path};
+
+const MATCH_OPTIONS: MatchOptions = MatchOptions {
+    case_sensitive: true,
+    // `*` matches within a directory, `**` across directories.
+    require_literal_separator: true,
+    require_literal_leading_dot: false,
+};
+
+/// Glob patterns of the executable paths on-host supervisors are allowed to run, so a remote
+/// config cannot make a sub-agent run arbitrary host binaries. An empty list allows any path.
+#[derive(Debug, Default, Clone, PartialEq)]
+pub struct ExecutableAllowList(Vec<Pattern>);
+
+impl ExecutableAllowList {
+    /// Builds an allow-list from glob patterns such as `/var/lib/newrelic-agent-control/packages/**`.
+    pub fn try_new<S: AsRef<str>>(
+        patterns: impl IntoIterator<Item = S>,
+    ) -> Result<Self, PatternError> {
+        patterns
+            .into_iter()
+            .map(|pattern| Pattern::new(pattern.as_ref()))
+            .collect::<Result<_, _>>()
+            .map(Self)
+    }
+
+    /// Returns whether `path` may be executed. Paths with `..` components are only allowed when
+    /// the list is empty, as they could escape the directories matched by the patterns.
+    pub fn allows(&self, path: &Path) -> bool {
+        if self.0.is_empty() {
+            return true;
+        }
+        if path.components().any(|c| c == Component::ParentDir) {
+            return false;
+        }
+        self.0
+            .iter()
+            .any(|pattern| pattern.matches_path_with(path, MATCH_OPTIONS))
+    }
+}
+
+impl<'de> Deserialize<'de> for ExecutableAllowList {
+    fn deserialize<D>(deserializer: D) -> Result<Self, D::Error>
+    where
+        D: Deserializer<'de>,
+    {
+        let patterns = Vec::<String>::deserialize(deserializer)?;
+        Self::try_new(&patterns).map_err(serde::de::Error::custom)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+
+    #[rstest]
+    #[case::package_binary("/var/lib/nr/packages/infra/bin/newrelic-infra", true)]
+    #[case::exact("/usr/bin/otelcol", true)]
+    #[case::single_level_wildcard("/opt/agents/agent", true)]
+    #[case::single_level_wildcard_nested("/opt/agents/nested/agent", false)]
+    #[case::other("/bin/sh", false)]
+    #[case::parent_dir("/var/lib/nr/packages/../../../../bin/sh", false)]
+    fn test_allows(#[case] path: &str, #[case] expected: bool) {
+        let allow_list = ExecutableAllowList::try_new([
+            "/var/lib/nr/packages/**",
+            "/usr/bin/otelcol",
+            "/opt/agents/*",
+        ])
+        .unwrap();
+
+        assert_eq!(allow_list.allows(Path::new(path)), expected);
+    }
+
+    #[test]
+    fn test_empty_allows_any() {
+        let allow_list = ExecutableAllowList::default();
+
+        assert!(allow_list.allows(Path::new("/bin/sh")));
+        assert!(allow_list.allows(Path::new("../bin/sh")));
+    }
+
+    #[test]
+    fn test_deserialize() {
+        let allow_list: ExecutableAllowList = serde_saphyr::from_str("[\"/usr/bin/*\"]").unwrap();
+        assert!(allow_list.allows(Path::new("/usr/bin/otelcol")));
+
+        assert!(serde_saphyr::from_str::<ExecutableAllowList>("[\"/usr/[bin\"]").is_err());
+    }
+}
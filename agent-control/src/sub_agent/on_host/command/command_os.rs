@@ -5,6 +5,7 @@ use tracing::warn;
 use crate::agent_control::agent_id::AgentID;
 use crate::agent_control::defaults::{STDERR_LOG_FILE_NAME_SUFFIX, STDOUT_LOG_FILE_NAME_SUFFIX};
 use crate::sub_agent::on_host::command::executable_data::ExecutableData;
+use crate::sub_agent::on_host::command::socket_activation::ActivationSockets;
 use std::time::{Duration, Instant};
 use std::{
     path::PathBuf,
@@ -17,6 +18,7 @@ use super::{
         self,
         file_logger::{FileSystemLoggers, file_logger},
         logger::Logger,
+        tail::OutputTail,
     },
 };
 #[cfg(target_family = "windows")]
@@ -40,6 +42,7 @@ pub struct CommandOSStarted {
     agent_id: AgentID,
     process: Child,
     loggers: Option<FileSystemLoggers>,
+    stderr_tail: OutputTail,
     shutdown_timeout: Duration,
 
     #[cfg(target_family = "windows")]
@@ -62,6 +65,21 @@ impl CommandOSNotStarted {
             .envs(&executable_data.env)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped());
+        // A process group of its own lets the process receive CTRL_BREAK on shutdown without
+        // delivering it to Agent Control. Without a console to share, e.g. when running as a
+        // service, the process gets a hidden one Agent Control can attach to on shutdown.
+        #[cfg(target_family = "windows")]
+        {
+            use std::os::windows::process::CommandExt;
+            use windows::Win32::System::Console::GetConsoleWindow;
+            use windows::Win32::System::Threading::{CREATE_NEW_PROCESS_GROUP, CREATE_NO_WINDOW};
+            let mut flags = CREATE_NEW_PROCESS_GROUP.0;
+            // SAFETY: plain query of the console of the current process.
+            if unsafe { GetConsoleWindow() }.is_invalid() {
+                flags |= CREATE_NO_WINDOW.0;
+            }
+            cmd.creation_flags(flags);
+        }
 
         Self {
             agent_id,
@@ -72,6 +90,45 @@ impl CommandOSNotStarted {
         }
     }
 
+    /// Makes the process run with the scheduling settings of the executable. It must be set
+    /// before [CommandOSNotStarted::with_activation_sockets], which executes the process right
+    /// after forking.
+    pub fn with_scheduling(
+        mut self,
+        executable_data: &ExecutableData,
+    ) -> Result<Self, CommandError> {
+        super::scheduling::apply(&mut self.cmd, &executable_data.scheduling)?;
+        Ok(self)
+    }
+
+    /// Makes the process inherit the listening sockets declared by the executable, which are kept
+    /// open in `sockets` across process restarts.
+    pub fn with_activation_sockets(
+        self,
+        executable_data: &ExecutableData,
+        sockets: &ActivationSockets,
+    ) -> Result<Self, CommandError> {
+        if executable_data.listen_sockets.is_empty() {
+            return Ok(self);
+        }
+        #[cfg(target_family = "unix")]
+        {
+            let mut command = self;
+            let inherited =
+                sockets.inherited(&executable_data.id, &executable_data.listen_sockets)?;
+            super::socket_activation::activate(&mut command.cmd, executable_data, inherited)?;
+            Ok(command)
+        }
+        #[cfg(target_family = "windows")]
+        {
+            let _ = sockets;
+            Err(CommandError::IOError(std::io::Error::new(
+                std::io::ErrorKind::Unsupported,
+                "listen sockets are only supported on Unix",
+            )))
+        }
+    }
+
     /// Spawns the process, setting up file loggers and (on Windows) a job object.
     pub fn start(mut self) -> Result<CommandOSStarted, CommandError> {
         let agent_id = self.agent_id;
@@ -92,6 +149,7 @@ impl CommandOSNotStarted {
                 agent_id,
                 process: child,
                 loggers,
+                stderr_tail: OutputTail::default(),
                 shutdown_timeout: self.shutdown_timeout,
             })
         }
@@ -106,6 +164,7 @@ impl CommandOSNotStarted {
                 process: child,
                 job_object: Some(job_object),
                 loggers,
+                stderr_tail: OutputTail::default(),
                 shutdown_timeout: self.shutdown_timeout,
             })
         }
@@ -124,10 +183,23 @@ impl CommandOSStarted {
 
     /// Returns whether the process is still running.
     pub fn is_running(&mut self) -> bool {
-        self.process.try_wait().is_ok_and(|v| v.is_none())
+        self.try_wait().is_ok_and(|v| v.is_none())
+    }
+
+    /// Returns the exit status if the process already exited, without blocking. Starting a
+    /// command doesn't block either, so callers can keep doing work meanwhile (e.g. reporting
+    /// health) and check for its termination periodically.
+    pub fn try_wait(&mut self) -> Result<Option<ExitStatus>, CommandError> {
+        self.process.try_wait().map_err(CommandError::from)
+    }
+
+    /// Returns the last lines written by the process to stderr, kept once it is streamed.
+    pub(crate) fn stderr_tail(&self) -> OutputTail {
+        self.stderr_tail.clone()
     }
 
-    pub(crate) fn wait(mut self) -> Result<ExitStatus, CommandError> {
+    /// Blocks until the process exits, returning its exit status.
+    pub fn wait(mut self) -> Result<ExitStatus, CommandError> {
         self.process.wait().map_err(CommandError::from)
     }
 
@@ -145,7 +217,10 @@ impl CommandOSStarted {
             .ok_or(CommandError::StreamPipeError("stderr".to_string()))?;
 
         let mut stdout_loggers = vec![Logger::Stdout(self.agent_id.clone())];
-        let mut stderr_loggers = vec![Logger::Stderr(self.agent_id.clone())];
+        let mut stderr_loggers = vec![
+            Logger::Stderr(self.agent_id.clone()),
+            Logger::Tail(self.stderr_tail.clone()),
+        ];
 
         if let Some(l) = self.loggers.take() {
             let (out, err) = l.into_loggers();
@@ -185,12 +260,26 @@ impl CommandOSStarted {
 
         if graceful_shutdown_result.is_err() || self.is_running_after_timeout(self.shutdown_timeout)
         {
-            self.process.kill().map_err(CommandError::from)?;
+            self.kill()?;
         }
 
         Ok(())
     }
 
+    #[cfg(target_family = "unix")]
+    fn kill(&mut self) -> Result<(), CommandError> {
+        self.process.kill().map_err(CommandError::from)
+    }
+
+    /// Terminates the Job Object, so the processes spawned by the process are killed too.
+    #[cfg(target_family = "windows")]
+    fn kill(&mut self) -> Result<(), CommandError> {
+        if let Some(job_object) = self.job_object.take() {
+            job_object.kill()?;
+        }
+        self.process.kill().map_err(CommandError::from)
+    }
+
     #[cfg(target_family = "unix")]
     fn graceful_shutdown(&self) -> Result<(), CommandError> {
         use nix::{sys::signal, unistd::Pid};
@@ -204,12 +293,106 @@ impl CommandOSStarted {
     /// On Windows there is no direct equivalent to sending SIGTERM. Applications that runs as
     /// services handles stops signals via Service Control Manager (SCM), and console applications
     /// can handle Ctrl-C or Ctrl-Break events via attached consoles.
-    /// The current implementation uses Job Objects to manage process groups, and there is no graceful
-    /// shutdown signal sent to the processes. The Job Object will terminate all associated processes.
-    fn graceful_shutdown(&mut self) -> Result<(), CommandError> {
-        if let Some(job_object) = self.job_object.take() {
-            job_object.kill()?;
+    /// A CTRL_BREAK event is sent to the process group of the process, through the console shared
+    /// with it or, when Agent Control has none (e.g. when running as a service), by attaching to
+    /// the hidden console of the process. If no event can be sent, a non-forced `taskkill` asks
+    /// the process to close. The Job Object terminates the processes that are still running after
+    /// the shutdown timeout.
+    fn graceful_shutdown(&self) -> Result<(), CommandError> {
+        let pid = self.get_pid();
+        windows_console::send_ctrl_break(pid).or_else(|err| {
+            warn!(agent_id = %self.agent_id, "Could not send CTRL_BREAK to process {pid}: {err}");
+            windows_console::taskkill(pid)
+        })
+    }
+}
+
+#[cfg(target_family = "windows")]
+mod windows_console {
+    use super::CommandError;
+    use std::path::PathBuf;
+    use std::process::{Command, Stdio};
+    use std::sync::Mutex;
+    use windows::Win32::System::Console::{
+        AttachConsole, CTRL_BREAK_EVENT, FreeConsole, GenerateConsoleCtrlEvent,
+        SetConsoleCtrlHandler,
+    };
+
+    /// A process can only be attached to one console, so the processes are stopped one at a time.
+    static CONSOLE_LOCK: Mutex<()> = Mutex::new(());
+
+    /// Sends CTRL_BREAK to the process group of `pid`.
+    pub(super) fn send_ctrl_break(pid: u32) -> Result<(), CommandError> {
+        let _guard = CONSOLE_LOCK.lock().unwrap_or_else(|err| err.into_inner());
+
+        // SAFETY: plain call sending the event to the process group created for the process.
+        if unsafe { GenerateConsoleCtrlEvent(CTRL_BREAK_EVENT, pid) }.is_ok() {
+            return Ok(());
+        }
+
+        // SAFETY: attaching fails if Agent Control already has a console, which is kept.
+        unsafe { AttachConsole(pid) }
+            .map_err(|e| CommandError::WinError(format!("attaching to the console: {e}")))?;
+        // SAFETY: ignores the event in Agent Control while it shares the console of the process,
+        // restoring the default handling once detached.
+        let result = unsafe {
+            _ = SetConsoleCtrlHandler(None, true);
+            let result = GenerateConsoleCtrlEvent(CTRL_BREAK_EVENT, pid);
+            _ = FreeConsole();
+            _ = SetConsoleCtrlHandler(None, false);
+            result
+        };
+        result.map_err(|e| CommandError::WinError(format!("sending CTRL_BREAK: {e}")))
+    }
+
+    /// Asks the process tree of `pid` to close with a non-forced `taskkill`.
+    pub(super) fn taskkill(pid: u32) -> Result<(), CommandError> {
+        // Not looked up in the PATH.
+        let system_root = std::env::var_os("SystemRoot").unwrap_or_else(|| "C:\\Windows".into());
+        let status = Command::new(
+            PathBuf::from(system_root)
+                .join("System32")
+                .join("taskkill.exe"),
+        )
+        .args(["/PID", &pid.to_string(), "/T"])
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .status()?;
+        if !status.success() {
+            return Err(CommandError::WinError(format!(
+                "taskkill exited with {status}"
+            )));
         }
         Ok(())
     }
 }
+
+#[cfg(all(test, target_family = "unix"))]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_started_command_does_not_block() {
+        let exec_data = ExecutableData::new("sleep".to_owned(), "sleep".to_owned())
+            .with_args(vec!["1".to_owned()]);
+        let mut command =
+            CommandOSNotStarted::new(AgentID::AgentControl, &exec_data, false, PathBuf::new())
+                .start()
+                .unwrap();
+
+        assert!(command.try_wait().unwrap().is_none());
+        assert!(command.is_running());
+
+        let deadline = Instant::now() + Duration::from_secs(10);
+        let exit_status = loop {
+            if let Some(exit_status) = command.try_wait().unwrap() {
+                break exit_status;
+            }
+            assert!(Instant::now() < deadline, "the command should have exited");
+            std::thread::sleep(POLL_INTERVAL);
+        };
+        assert!(exit_status.success());
+        assert!(!command.is_running());
+        assert!(command.wait().unwrap().success());
+    }
+}
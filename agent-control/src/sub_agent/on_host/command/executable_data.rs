@@ -1,10 +1,11 @@
 //! [ExecutableData]: the binary, arguments, environment, restart policy, and shutdown timeout for a supervised process.
 
+use crate::agent_type::runtime_config::on_host::executable::DEFAULT_SHUTDOWN_TIMEOUT;
+use crate::agent_type::runtime_config::on_host::executable::rendered::ListenSocket;
+use crate::agent_type::runtime_config::scheduling::rendered::SchedulingConfig;
 use crate::sub_agent::on_host::command::restart_policy::RestartPolicy;
 use std::{collections::HashMap, time::Duration};
 
-const DEFAULT_SHUTDOWN_TIMEOUT: Duration = Duration::from_secs(10);
-
 /// Describes a supervised executable and how it should be launched and stopped.
 #[derive(Clone)]
 pub struct ExecutableData {
@@ -20,6 +21,10 @@ pub struct ExecutableData {
     pub restart_policy: RestartPolicy,
     /// Time to wait for a graceful shutdown before forcing termination.
     pub shutdown_timeout: Duration,
+    /// Scheduling settings (nice level, I/O priority and CPU affinity) of the process.
+    pub scheduling: SchedulingConfig,
+    /// Listening sockets inherited by the process.
+    pub listen_sockets: Vec<ListenSocket>,
 }
 
 impl ExecutableData {
@@ -32,6 +37,8 @@ impl ExecutableData {
             env: HashMap::default(),
             restart_policy: RestartPolicy::default(),
             shutdown_timeout: DEFAULT_SHUTDOWN_TIMEOUT,
+            scheduling: SchedulingConfig::default(),
+            listen_sockets: Vec::default(),
         }
     }
 
@@ -52,4 +59,25 @@ impl ExecutableData {
             ..self
         }
     }
+
+    /// Returns a copy with the given scheduling settings.
+    pub fn with_scheduling(self, scheduling: SchedulingConfig) -> Self {
+        Self { scheduling, ..self }
+    }
+
+    /// Returns a copy with the given shutdown timeout.
+    pub fn with_shutdown_timeout(self, shutdown_timeout: Duration) -> Self {
+        Self {
+            shutdown_timeout,
+            ..self
+        }
+    }
+
+    /// Returns a copy with the given listening sockets.
+    pub fn with_listen_sockets(self, listen_sockets: Vec<ListenSocket>) -> Self {
+        Self {
+            listen_sockets,
+            ..self
+        }
+    }
 }
@@ -1,5 +1,7 @@
-//! Logging for on-host executable output: stdout/stderr forwarding and optional file logging.
+//! Logging for on-host executable output: stdout/stderr forwarding, optional file logging and
+//! the stderr tail reported on crashes.
 
 pub mod file_logger;
 pub(crate) mod logger;
+pub(crate) mod tail;
 pub(crate) mod thread;
@@ -1,6 +1,7 @@
 //! Logger targets (stdout, stderr, file) that consume executable output lines on dedicated threads.
 
 use super::file_logger::FileLogger;
+use super::tail::OutputTail;
 use crate::agent_control::agent_id::AgentID;
 use crate::utils::threads::spawn_named_thread;
 use crossbeam::channel::Receiver;
@@ -11,6 +12,7 @@ pub(crate) enum Logger {
     File(Box<FileLogger>, AgentID),
     Stdout(AgentID),
     Stderr(AgentID),
+    Tail(OutputTail),
 }
 
 impl Logger {
@@ -41,6 +43,9 @@ impl Logger {
                     rx.iter()
                         .for_each(|line| debug!(%agent_id, "{}", line.to_string()));
                 }
+                Self::Tail(tail) => {
+                    rx.iter().for_each(|line| tail.push(line.to_string()));
+                }
             }
         })
     }
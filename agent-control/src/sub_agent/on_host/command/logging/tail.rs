@@ -0,0 +1,60 @@
+//! Keeps the last lines of an executable output, so they can be reported when it crashes.
+
+use std::collections::VecDeque;
+use std::sync::{Arc, Mutex};
+
+/// Number of lines kept by default.
+pub(crate) const DEFAULT_TAIL_LINES: usize = 50;
+
+/// Last lines of an executable output. Clones share the same lines.
+#[derive(Debug, Clone)]
+pub(crate) struct OutputTail {
+    lines: Arc<Mutex<VecDeque<String>>>,
+    capacity: usize,
+}
+
+impl Default for OutputTail {
+    fn default() -> Self {
+        Self::new(DEFAULT_TAIL_LINES)
+    }
+}
+
+impl OutputTail {
+    pub(crate) fn new(capacity: usize) -> Self {
+        Self {
+            lines: Arc::new(Mutex::new(VecDeque::with_capacity(capacity))),
+            capacity,
+        }
+    }
+
+    /// Adds a line, dropping the oldest one if the tail is full.
+    pub(crate) fn push(&self, line: String) {
+        let mut lines = self.lines.lock().unwrap_or_else(|err| err.into_inner());
+        if lines.len() == self.capacity {
+            lines.pop_front();
+        }
+        lines.push_back(line);
+    }
+
+    /// Returns the kept lines, oldest first.
+    pub(crate) fn lines(&self) -> Vec<String> {
+        let lines = self.lines.lock().unwrap_or_else(|err| err.into_inner());
+        lines.iter().cloned().collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_output_tail() {
+        let tail = OutputTail::new(2);
+        assert!(tail.lines().is_empty());
+
+        tail.clone().push("first".to_string());
+        tail.push("second".to_string());
+        tail.push("third".to_string());
+        assert_eq!(tail.lines(), vec!["second", "third"]);
+    }
+}
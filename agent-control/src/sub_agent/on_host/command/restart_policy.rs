@@ -1,8 +1,10 @@
 //! Restart policy and backoff strategies governing how supervised executables are retried.
 
 use crate::agent_type::runtime_config::restart_policy::{
-    BackoffStrategyType, rendered::BackoffStrategyConfig, rendered::RestartPolicyConfig,
+    BackoffStrategyType, DEFAULT_STABLE_UPTIME, rendered::BackoffStrategyConfig,
+    rendered::RestartPolicyConfig,
 };
+use crate::utils::retry::full_jitter;
 use std::cmp::max;
 use std::time::{Duration, Instant};
 
@@ -11,12 +13,58 @@ use std::time::{Duration, Instant};
 pub struct RestartPolicy {
     /// The backoff strategy that drives retry timing and limits.
     pub backoff: BackoffStrategy,
+    /// Time the executable can keep failing before it is disabled. Zero never disables it.
+    failure_window: Duration,
+    /// Uptime after which the executable is considered recovered and the policy is reset.
+    stable_uptime: Duration,
+    /// Start of the current sequence of failures.
+    failing_since: Option<Instant>,
 }
 
 impl RestartPolicy {
     /// Creates a restart policy from the given backoff strategy.
     pub fn new(backoff: BackoffStrategy) -> Self {
-        Self { backoff }
+        Self {
+            backoff,
+            failure_window: Duration::ZERO,
+            stable_uptime: DEFAULT_STABLE_UPTIME,
+            failing_since: None,
+        }
+    }
+
+    /// Returns a copy disabling the executable once it keeps failing for longer than
+    /// `failure_window`.
+    pub fn with_failure_window(self, failure_window: Duration) -> Self {
+        Self {
+            failure_window,
+            ..self
+        }
+    }
+
+    /// Time the executable can keep failing before it is disabled.
+    pub fn failure_window(&self) -> Duration {
+        self.failure_window
+    }
+
+    /// Returns a copy considering the executable recovered once it runs for `stable_uptime`.
+    pub fn with_stable_uptime(self, stable_uptime: Duration) -> Self {
+        Self {
+            stable_uptime,
+            ..self
+        }
+    }
+
+    /// Returns whether an executable that ran for `uptime` before exiting had recovered from its
+    /// previous failures, so the policy should be reset.
+    pub fn is_stable(&self, uptime: Duration) -> bool {
+        uptime >= self.stable_uptime
+    }
+
+    /// Records a failure of the executable and returns whether it has been failing for longer
+    /// than the failure window.
+    pub fn failure_window_exceeded(&mut self) -> bool {
+        let failing_since = *self.failing_since.get_or_insert_with(Instant::now);
+        !self.failure_window.is_zero() && failing_since.elapsed() > self.failure_window
     }
 
     /// Returns whether another retry should be attempted, updating internal counters.
@@ -24,6 +72,12 @@ impl RestartPolicy {
         self.backoff.should_backoff()
     }
 
+    /// Starts the backoff sequence again, e.g. after the executable ran healthy for a while.
+    pub fn reset(&mut self) {
+        self.backoff.reset();
+        self.failing_since = None;
+    }
+
     /// Applies the backoff delay by invoking `sleep_func` with the computed duration.
     pub fn backoff<S>(&mut self, sleep_func: S)
     where
@@ -42,6 +96,8 @@ impl Default for RestartPolicy {
 impl From<RestartPolicyConfig> for RestartPolicy {
     fn from(value: RestartPolicyConfig) -> Self {
         RestartPolicy::new(value.backoff_strategy.into())
+            .with_failure_window(value.failure_window.into())
+            .with_stable_uptime(value.stable_uptime.into())
     }
 }
 
@@ -52,7 +108,8 @@ pub enum BackoffStrategy {
     Fixed(Backoff),
     /// Delay growing linearly with the number of retries.
     Linear(Backoff),
-    /// Delay growing exponentially (base 2) with the number of retries.
+    /// Delay growing exponentially (base 2 unless another multiplier is set) with the number
+    /// of retries.
     Exponential(Backoff),
 }
 
@@ -62,6 +119,9 @@ pub enum BackoffStrategy {
 /// If duration is higher, then the backoff will reset its values to start a new sequence
 pub const LAST_RETRY_INTERVAL: Duration = Duration::new(30, 0);
 
+/// Saturation value of the exponential delays, so they don't overflow after many retries.
+const MAX_EXPONENTIAL_DELAY: Duration = Duration::from_secs(u32::MAX as u64);
+
 impl BackoffStrategy {
     /// Returns the maximum number of retries (0 means unlimited).
     pub fn max_retries(&self) -> usize {
@@ -80,6 +140,14 @@ impl BackoffStrategy {
         }
     }
 
+    fn reset(&mut self) {
+        match self {
+            BackoffStrategy::Fixed(b)
+            | BackoffStrategy::Linear(b)
+            | BackoffStrategy::Exponential(b) => b.reset(),
+        }
+    }
+
     fn backoff<S>(&mut self, sleep_func: S)
     where
         S: FnOnce(Duration),
@@ -87,7 +155,15 @@ impl BackoffStrategy {
         match self {
             BackoffStrategy::Fixed(b) => b.backoff(fixed, sleep_func),
             BackoffStrategy::Linear(b) => b.backoff(linear, sleep_func),
-            BackoffStrategy::Exponential(b) => b.backoff(exponential, sleep_func),
+            BackoffStrategy::Exponential(b) => {
+                let multiplier = b.multiplier;
+                b.backoff(
+                    |tries, initial_delay| {
+                        exponential_with_multiplier(tries, initial_delay, multiplier)
+                    },
+                    sleep_func,
+                )
+            }
         }
     }
 }
@@ -100,6 +176,9 @@ pub struct Backoff {
     initial_delay: Duration,
     max_retries: usize,
     last_retry_interval: Duration,
+    multiplier: f64,
+    max_delay: Option<Duration>,
+    jitter: f64,
 }
 
 impl Default for Backoff {
@@ -110,6 +189,9 @@ impl Default for Backoff {
             initial_delay: Duration::new(1, 0),
             max_retries: 0,
             last_retry_interval: LAST_RETRY_INTERVAL,
+            multiplier: 2.0,
+            max_delay: None,
+            jitter: 0.0,
         }
     }
 }
@@ -133,21 +215,52 @@ impl Backoff {
         self
     }
 
+    /// Returns a copy with the given factor the exponential delay grows by on each retry.
+    pub fn with_multiplier(mut self, multiplier: f64) -> Self {
+        self.multiplier = multiplier;
+        self
+    }
+
+    /// Returns a copy with the given upper bound of the delay (`None` means unbounded).
+    pub fn with_max_delay(mut self, max_delay: Option<Duration>) -> Self {
+        self.max_delay = max_delay;
+        self
+    }
+
+    /// Returns a copy with the given fraction of each delay, from `0` to `1`, randomly subtracted
+    /// from it.
+    pub fn with_jitter(mut self, jitter: f64) -> Self {
+        self.jitter = jitter.clamp(0.0, 1.0);
+        self
+    }
+
     fn should_backoff(&mut self) -> bool {
         let duration = self.last_retry.elapsed();
         if duration > self.last_retry_interval {
-            self.tries = 0
+            self.reset();
         }
 
         self.max_retries == 0 || self.tries < self.max_retries
     }
 
+    fn reset(&mut self) {
+        self.tries = 0;
+    }
+
     fn backoff<B, S>(&mut self, backoff_func: B, sleep_func: S)
     where
-        B: FnOnce(usize, Duration, S),
+        B: FnOnce(usize, Duration) -> Duration,
         S: FnOnce(Duration),
     {
-        backoff_func(self.tries, self.initial_delay, sleep_func);
+        let delay = backoff_func(self.tries, self.initial_delay);
+        let delay = self
+            .max_delay
+            .map_or(delay, |max_delay| delay.min(max_delay));
+        if self.jitter > 0.0 {
+            sleep_func(delay.mul_f64(1.0 - self.jitter) + full_jitter(delay.mul_f64(self.jitter)));
+        } else {
+            sleep_func(delay);
+        }
         self.last_retry = Instant::now();
         self.tries += 1;
     }
@@ -170,37 +283,43 @@ fn realize_backoff_config(i: BackoffStrategyConfig) -> Backoff {
         .with_initial_delay(i.backoff_delay.into())
         .with_max_retries(i.max_retries.into())
         .with_last_retry_interval(i.last_retry_interval.into())
+        .with_multiplier(i.multiplier.into())
+        .with_max_delay(Some(Duration::from(i.max_delay)).filter(|d| !d.is_zero()))
+        .with_jitter(i.jitter.into())
 }
 
-/// fixed is a function executing a sleep function with a constant delay
-pub fn fixed<S>(_: usize, initial_delay: Duration, sleep_func: S)
-where
-    S: FnOnce(Duration),
-{
-    sleep_func(initial_delay);
+/// fixed returns a constant delay
+pub fn fixed(_: usize, initial_delay: Duration) -> Duration {
+    initial_delay
 }
 
-/// linear is a function executing a sleep function with a delay incrementing linearly
-pub fn linear<S>(tries: usize, initial_delay: Duration, sleep_func: S)
-where
-    S: FnOnce(Duration),
-{
+/// linear returns a delay incrementing linearly
+pub fn linear(tries: usize, initial_delay: Duration) -> Duration {
     let total_secs_duration = tries as f32 * initial_delay.as_secs_f32();
-    sleep_func(Duration::from_secs_f32(total_secs_duration));
+    Duration::from_secs_f32(total_secs_duration)
 }
 
-/// exponential is a function executing a sleep function with a delay incrementing exponentially in base 2
-pub fn exponential<S>(tries: usize, initial_delay: Duration, sleep_func: S)
-where
-    S: FnOnce(Duration),
-{
-    let base: u32 = 2;
-    sleep_func(initial_delay * base.pow(max(tries as u32, 1) - 1));
+/// exponential returns a delay incrementing exponentially in base 2
+pub fn exponential(tries: usize, initial_delay: Duration) -> Duration {
+    exponential_with_multiplier(tries, initial_delay, 2.0)
+}
+
+/// exponential_with_multiplier returns a delay incrementing exponentially in base `multiplier`
+pub fn exponential_with_multiplier(
+    tries: usize,
+    initial_delay: Duration,
+    multiplier: f64,
+) -> Duration {
+    let exponent = i32::try_from(max(tries, 1) - 1).unwrap_or(i32::MAX);
+    Duration::try_from_secs_f64(initial_delay.as_secs_f64() * multiplier.powi(exponent))
+        .unwrap_or(MAX_EXPONENTIAL_DELAY)
+        .min(MAX_EXPONENTIAL_DELAY)
 }
 
 #[cfg(test)]
 mod tests {
     use super::*;
+    use crate::agent_type::runtime_config::restart_policy::StableUptime;
     use std::thread::sleep;
     use std::time::Duration;
 
@@ -299,4 +418,84 @@ mod tests {
         });
         assert_eq!(Duration::from_secs(16), slept)
     }
+
+    #[test]
+    fn test_backoff_exponential_with_multiplier_and_max_delay() {
+        let mut slept = Vec::new();
+
+        let mut b = Backoff::default()
+            .with_multiplier(3.0)
+            .with_max_delay(Some(Duration::from_secs(20)));
+        for _ in 0..5 {
+            b.backoff(
+                |tries, initial_delay| exponential_with_multiplier(tries, initial_delay, 3.0),
+                |dur| slept.push(dur.as_secs()),
+            );
+        }
+        assert_eq!(slept, vec![1, 1, 3, 9, 20]);
+    }
+
+    #[test]
+    fn test_backoff_jitter_stays_within_the_fraction() {
+        let mut b = Backoff::default()
+            .with_initial_delay(Duration::from_secs(10))
+            .with_jitter(0.5);
+        for _ in 0..20 {
+            b.backoff(fixed, |dur| {
+                assert!(dur >= Duration::from_secs(5) && dur <= Duration::from_secs(10))
+            });
+        }
+    }
+
+    #[test]
+    fn test_exponential_saturates() {
+        assert_eq!(
+            exponential(usize::MAX, Duration::from_secs(1)),
+            MAX_EXPONENTIAL_DELAY
+        );
+    }
+
+    #[test]
+    fn test_restart_policy_reset() {
+        let backoff = Backoff::default().with_max_retries(1);
+        let mut policy = RestartPolicy::new(BackoffStrategy::Fixed(backoff));
+
+        assert!(policy.should_retry());
+        policy.backoff(|_| {});
+        assert!(!policy.should_retry());
+
+        policy.reset();
+        assert!(policy.should_retry());
+    }
+
+    #[test]
+    fn test_restart_policy_failure_window() {
+        let mut policy = RestartPolicy::default();
+        assert!(!policy.failure_window_exceeded());
+        sleep(Duration::from_millis(20));
+        assert!(!policy.failure_window_exceeded());
+
+        let mut policy = RestartPolicy::default().with_failure_window(Duration::from_millis(10));
+        assert!(!policy.failure_window_exceeded());
+        sleep(Duration::from_millis(20));
+        assert!(policy.failure_window_exceeded());
+
+        // Running long enough to reset the policy starts a new sequence of failures
+        policy.reset();
+        assert!(!policy.failure_window_exceeded());
+    }
+
+    #[test]
+    fn test_restart_policy_stable_uptime() {
+        let policy = RestartPolicy::default();
+        assert!(!policy.is_stable(Duration::from_secs(299)));
+        assert!(policy.is_stable(DEFAULT_STABLE_UPTIME));
+
+        let policy = RestartPolicy::from(RestartPolicyConfig {
+            stable_uptime: StableUptime::from_secs(30),
+            ..Default::default()
+        });
+        assert!(!policy.is_stable(Duration::from_secs(29)));
+        assert!(policy.is_stable(Duration::from_secs(30)));
+    }
 }
@@ -0,0 +1,197 @@
+//! Applies the scheduling settings of an executable (nice level, I/O priority and CPU affinity)
+//! to its process.
+//!
+//! The settings are applied in the forked child right before executing the process, so every
+//! thread it starts inherits them. The nice level is supported on Unix, the I/O priority and CPU
+//! affinity only on Linux. Unsupported settings are ignored with a warning.
+
+use crate::agent_type::runtime_config::scheduling::rendered::SchedulingConfig;
+use std::io;
+use std::process::Command;
+
+/// Makes the process spawned by `cmd` run with the `scheduling` settings.
+pub(crate) fn apply(cmd: &mut Command, scheduling: &SchedulingConfig) -> io::Result<()> {
+    if scheduling.is_inherited() {
+        return Ok(());
+    }
+    platform::apply(cmd, scheduling)
+}
+
+#[cfg(target_family = "unix")]
+mod platform {
+    use crate::agent_type::runtime_config::scheduling::rendered::SchedulingConfig;
+    use nix::libc;
+    use std::io;
+    use std::os::unix::process::CommandExt;
+    use std::process::Command;
+
+    pub(super) fn apply(cmd: &mut Command, scheduling: &SchedulingConfig) -> io::Result<()> {
+        let nice = scheduling.nice.0;
+        let io_priority = linux_settings::io_priority(scheduling);
+        let cpu_set = linux_settings::cpu_set(scheduling)?;
+        // SAFETY: the closure only performs async-signal-safe calls and doesn't allocate.
+        unsafe {
+            cmd.pre_exec(move || {
+                if let Some(nice) = nice {
+                    // SAFETY: plain syscall on the current process.
+                    if unsafe { libc::setpriority(libc::PRIO_PROCESS as _, 0, nice) } < 0 {
+                        return Err(io::Error::last_os_error());
+                    }
+                }
+                linux_settings::apply(io_priority, cpu_set.as_ref())
+            });
+        }
+        Ok(())
+    }
+
+    #[cfg(target_os = "linux")]
+    mod linux_settings {
+        use crate::agent_type::runtime_config::scheduling::IoPriority;
+        use crate::agent_type::runtime_config::scheduling::rendered::SchedulingConfig;
+        use nix::libc;
+        use std::io;
+
+        const IOPRIO_WHO_PROCESS: libc::c_int = 1;
+        const IOPRIO_CLASS_SHIFT: libc::c_int = 13;
+        const IOPRIO_CLASS_RT: libc::c_int = 1;
+        const IOPRIO_CLASS_BE: libc::c_int = 2;
+        const IOPRIO_CLASS_IDLE: libc::c_int = 3;
+
+        /// Returns the `ioprio_set` value of the configured I/O priority, if any.
+        pub(super) fn io_priority(scheduling: &SchedulingConfig) -> Option<libc::c_int> {
+            let ioprio = |class: libc::c_int, level: u8| {
+                (class << IOPRIO_CLASS_SHIFT) | libc::c_int::from(level)
+            };
+            match scheduling.io_priority {
+                IoPriority::Inherit => None,
+                IoPriority::Idle => Some(ioprio(IOPRIO_CLASS_IDLE, 0)),
+                IoPriority::BestEffort(level) => Some(ioprio(IOPRIO_CLASS_BE, level)),
+                IoPriority::Realtime(level) => Some(ioprio(IOPRIO_CLASS_RT, level)),
+            }
+        }
+
+        /// Returns the CPU set of the configured affinity, if any.
+        pub(super) fn cpu_set(
+            scheduling: &SchedulingConfig,
+        ) -> io::Result<Option<libc::cpu_set_t>> {
+            let cpus = &scheduling.cpu_affinity.0;
+            if cpus.is_empty() {
+                return Ok(None);
+            }
+            // SAFETY: an all-zero `cpu_set_t` is the empty set.
+            let mut set: libc::cpu_set_t = unsafe { std::mem::zeroed() };
+            for cpu in cpus {
+                if *cpu >= libc::CPU_SETSIZE as usize {
+                    return Err(io::Error::new(
+                        io::ErrorKind::InvalidInput,
+                        format!("CPU {cpu} is out of the supported range"),
+                    ));
+                }
+                libc::CPU_SET(*cpu, &mut set);
+            }
+            Ok(Some(set))
+        }
+
+        /// Applies the I/O priority and CPU affinity to the current process.
+        pub(super) fn apply(
+            io_priority: Option<libc::c_int>,
+            cpu_set: Option<&libc::cpu_set_t>,
+        ) -> io::Result<()> {
+            if let Some(ioprio) = io_priority {
+                // SAFETY: plain syscall on the current process.
+                if unsafe { libc::syscall(libc::SYS_ioprio_set, IOPRIO_WHO_PROCESS, 0, ioprio) } < 0
+                {
+                    return Err(io::Error::last_os_error());
+                }
+            }
+            if let Some(set) = cpu_set {
+                // SAFETY: plain syscall on the current process with a valid CPU set.
+                if unsafe { libc::sched_setaffinity(0, size_of::<libc::cpu_set_t>(), set) } < 0 {
+                    return Err(io::Error::last_os_error());
+                }
+            }
+            Ok(())
+        }
+    }
+
+    /// The Linux-only settings are ignored elsewhere.
+    #[cfg(not(target_os = "linux"))]
+    mod linux_settings {
+        use crate::agent_type::runtime_config::scheduling::IoPriority;
+        use crate::agent_type::runtime_config::scheduling::rendered::SchedulingConfig;
+        use std::io;
+        use tracing::warn;
+
+        pub(super) fn io_priority(scheduling: &SchedulingConfig) -> Option<()> {
+            if scheduling.io_priority != IoPriority::Inherit {
+                warn!("The I/O priority of executables is only supported on Linux, ignoring it");
+            }
+            None
+        }
+
+        pub(super) fn cpu_set(scheduling: &SchedulingConfig) -> io::Result<Option<()>> {
+            if !scheduling.cpu_affinity.0.is_empty() {
+                warn!("The CPU affinity of executables is only supported on Linux, ignoring it");
+            }
+            Ok(None)
+        }
+
+        pub(super) fn apply(_io_priority: Option<()>, _cpu_set: Option<&()>) -> io::Result<()> {
+            Ok(())
+        }
+    }
+
+    #[cfg(all(test, target_os = "linux"))]
+    mod tests {
+        use super::*;
+        use crate::agent_type::runtime_config::scheduling::{CpuAffinity, IoPriority, NiceLevel};
+        use std::process::Stdio;
+
+        #[test]
+        fn test_process_runs_with_the_scheduling_settings() {
+            let scheduling = SchedulingConfig {
+                nice: NiceLevel(Some(19)),
+                io_priority: IoPriority::Idle,
+                cpu_affinity: CpuAffinity(vec![0]),
+            };
+            let mut cmd = Command::new("/bin/sh");
+            // The 19th field of `stat` is the nice level.
+            cmd.args([
+                "-c",
+                "cut -d' ' -f19 /proc/self/stat; grep Cpus_allowed_list /proc/self/status",
+            ])
+            .stdout(Stdio::piped());
+            apply(&mut cmd, &scheduling).unwrap();
+
+            let output = String::from_utf8(cmd.output().unwrap().stdout).unwrap();
+            let mut lines = output.lines();
+            assert_eq!(lines.next(), Some("19"));
+            assert_eq!(
+                lines.next().map(|l| l.split_whitespace().last()),
+                Some(Some("0"))
+            );
+        }
+
+        #[test]
+        fn test_cpu_out_of_range() {
+            let scheduling = SchedulingConfig {
+                cpu_affinity: CpuAffinity(vec![libc::CPU_SETSIZE as usize]),
+                ..Default::default()
+            };
+            assert!(apply(&mut Command::new("/bin/true"), &scheduling).is_err());
+        }
+    }
+}
+
+#[cfg(not(target_family = "unix"))]
+mod platform {
+    use crate::agent_type::runtime_config::scheduling::rendered::SchedulingConfig;
+    use std::io;
+    use std::process::Command;
+    use tracing::warn;
+
+    pub(super) fn apply(_cmd: &mut Command, _scheduling: &SchedulingConfig) -> io::Result<()> {
+        warn!("The scheduling settings of executables are only supported on Unix, ignoring them");
+        Ok(())
+    }
+}
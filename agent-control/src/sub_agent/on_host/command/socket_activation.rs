@@ -0,0 +1,416 @@
+//! Socket activation of supervised executables, in the style of systemd.
+//!
+//! The supervisor creates the listening sockets declared by an executable and keeps them open
+//! across restarts of the process and re-applies of the agent configuration, so the kernel
+//! queues the incoming connections instead of refusing them while the agent is swapped. The
+//! process inherits the sockets as file descriptors starting at `3`, described by the
+//! `LISTEN_FDS`, `LISTEN_FDNAMES` and `LISTEN_PID` environment variables like systemd does.
+//!
+//! ```yaml
+//! executables:
+//!   - id: otelcol
+//!     path: /usr/bin/otelcol
+//!     listen_sockets:
+//!       - name: otlp-grpc
+//!         address: tcp://0.0.0.0:4317
+//!       - name: otlp-local
+//!         address: unix:///run/otelcol/otlp.sock
+//! ```
+//!
+//! Socket activation is only supported on Unix.
+
+#[cfg(target_family = "unix")]
+use crate::agent_type::runtime_config::on_host::executable::rendered::ListenSocket;
+use crate::sub_agent::on_host::command::executable_data::ExecutableData;
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+
+use platform::Listener;
+#[cfg(target_family = "unix")]
+pub(crate) use platform::activate;
+
+/// Listening sockets kept open for the executables of an agent, by executable id and address.
+/// Clones share the same sockets.
+#[derive(Debug, Clone, Default)]
+pub struct ActivationSockets(Arc<Mutex<HashMap<(String, String), Listener>>>);
+
+impl ActivationSockets {
+    /// Returns the sockets of the executable `exec_id` along with their names, creating the ones
+    /// not open yet.
+    #[cfg(target_family = "unix")]
+    pub(crate) fn inherited(
+        &self,
+        exec_id: &str,
+        sockets: &[ListenSocket],
+    ) -> std::io::Result<Vec<(String, std::os::fd::OwnedFd)>> {
+        use std::collections::hash_map::Entry;
+
+        let mut listeners = self.0.lock().unwrap_or_else(|err| err.into_inner());
+        sockets
+            .iter()
+            .map(|socket| {
+                let listener = match listeners.entry((exec_id.to_string(), socket.address.clone()))
+                {
+                    Entry::Occupied(entry) => entry.into_mut(),
+                    Entry::Vacant(entry) => entry.insert(platform::listen(&socket.address)?),
+                };
+                Ok((socket.name.clone(), listener.try_clone()?))
+            })
+            .collect()
+    }
+
+    /// Closes the sockets not declared by any of the `executables`.
+    pub(crate) fn retain(&self, executables: &[ExecutableData]) {
+        let mut listeners = self.0.lock().unwrap_or_else(|err| err.into_inner());
+        listeners.retain(|(exec_id, address), _| {
+            executables
+                .iter()
+                .any(|e| &e.id == exec_id && e.listen_sockets.iter().any(|s| &s.address == address))
+        });
+    }
+
+    /// Returns whether no socket is open.
+    pub fn is_empty(&self) -> bool {
+        self.0
+            .lock()
+            .unwrap_or_else(|err| err.into_inner())
+            .is_empty()
+    }
+}
+
+#[cfg(target_family = "unix")]
+mod platform {
+    use crate::sub_agent::on_host::command::executable_data::ExecutableData;
+    use nix::libc;
+    use std::collections::HashMap;
+    use std::ffi::{CString, OsStr, OsString};
+    use std::fs;
+    use std::io;
+    use std::net::TcpListener;
+    use std::os::fd::{AsRawFd, OwnedFd, RawFd};
+    use std::os::unix::ffi::{OsStrExt, OsStringExt};
+    use std::os::unix::fs::{FileTypeExt, PermissionsExt};
+    use std::os::unix::net::UnixListener;
+    use std::os::unix::process::CommandExt;
+    use std::path::{Path, PathBuf};
+    use std::process::Command;
+
+    pub(super) type Listener = OwnedFd;
+
+    /// First inherited descriptor, after stdin, stdout and stderr.
+    const LISTEN_FDS_START: RawFd = 3;
+    const LISTEN_PID_PREFIX: &[u8] = b"LISTEN_PID=";
+    /// Room for the decimal digits of any pid.
+    const PID_DIGITS: usize = 10;
+
+    /// Creates a listening socket for `tcp://<host>:<port>` or `unix://<path>` addresses.
+    pub(super) fn listen(address: &str) -> io::Result<OwnedFd> {
+        if let Some(address) = address.strip_prefix("tcp://") {
+            Ok(TcpListener::bind(address)?.into())
+        } else if let Some(path) = address.strip_prefix("unix://") {
+            // Replace a stale socket left behind by a previous run.
+            if fs::symlink_metadata(path).is_ok_and(|m| m.file_type().is_socket()) {
+                fs::remove_file(path)?;
+            }
+            Ok(UnixListener::bind(path)?.into())
+        } else {
+            Err(io::Error::new(
+                io::ErrorKind::InvalidInput,
+                format!(
+                    "unsupported listen address '{address}', expected tcp://<host>:<port> or unix://<path>"
+                ),
+            ))
+        }
+    }
+
+    /// Makes the process spawned by `cmd` inherit `sockets`.
+    ///
+    /// `LISTEN_PID` must hold the pid of the process, which is only known once forked, so the
+    /// process is executed right from the forked child with the executable arguments and
+    /// environment prepared beforehand.
+    pub(crate) fn activate(
+        cmd: &mut Command,
+        executable: &ExecutableData,
+        sockets: Vec<(String, OwnedFd)>,
+    ) -> io::Result<()> {
+        let mut exec = ActivatedExec::new(executable, sockets)?;
+        // SAFETY: `ActivatedExec::exec` only performs async-signal-safe calls and doesn't allocate.
+        unsafe {
+            cmd.pre_exec(move || exec.exec());
+        }
+        Ok(())
+    }
+
+    /// Everything needed to execute the process from the forked child.
+    struct ActivatedExec {
+        program: CString,
+        _args: Vec<CString>,
+        argv: Vec<*const libc::c_char>,
+        _env: Vec<CString>,
+        _listen_pid: Vec<u8>,
+        listen_pid_digits: *mut u8,
+        envp: Vec<*const libc::c_char>,
+        fds: Vec<OwnedFd>,
+        moved_fds: Vec<RawFd>,
+    }
+
+    // SAFETY: the pointers reference buffers owned by the struct, which are only accessed from the
+    // forked child.
+    unsafe impl Send for ActivatedExec {}
+    unsafe impl Sync for ActivatedExec {}
+
+    impl ActivatedExec {
+        fn new(executable: &ExecutableData, sockets: Vec<(String, OwnedFd)>) -> io::Result<Self> {
+            let mut env: HashMap<OsString, OsString> = std::env::vars_os()
+                .filter(|(key, _)| !key.as_bytes().starts_with(b"LISTEN_"))
+                .collect();
+            env.extend(
+                executable
+                    .env
+                    .iter()
+                    .map(|(key, value)| (key.into(), value.into())),
+            );
+            env.insert("LISTEN_FDS".into(), sockets.len().to_string().into());
+            env.insert("LISTEN_FDNAMES".into(), fd_names(&sockets).into());
+
+            let program = resolve_program(&executable.bin, env.get(OsStr::new("PATH")))?;
+            let args = std::iter::once(executable.bin.as_str())
+                .chain(executable.args.iter().map(String::as_str))
+                .map(|arg| c_string(arg.as_bytes().to_vec()))
+                .collect::<io::Result<Vec<_>>>()?;
+            let env = env
+                .into_iter()
+                .map(|(key, value)| {
+                    let mut entry = key.into_vec();
+                    entry.push(b'=');
+                    entry.extend(value.into_vec());
+                    c_string(entry)
+                })
+                .collect::<io::Result<Vec<_>>>()?;
+
+            // Filled with the pid in the forked child, the trailing zeroes terminate the string.
+            let mut listen_pid = LISTEN_PID_PREFIX.to_vec();
+            listen_pid.resize(LISTEN_PID_PREFIX.len() + PID_DIGITS + 1, 0);
+            let listen_pid_ptr = listen_pid.as_mut_ptr();
+
+            let argv = args
+                .iter()
+                .map(|arg| arg.as_ptr())
+                .chain(std::iter::once(std::ptr::null()))
+                .collect();
+            let envp = env
+                .iter()
+                .map(|entry| entry.as_ptr())
+                .chain([listen_pid_ptr as *const libc::c_char, std::ptr::null()])
+                .collect();
+
+            Ok(Self {
+                program: c_string(program.into_os_string().into_vec())?,
+                _args: args,
+                argv,
+                _env: env,
+                _listen_pid: listen_pid,
+                // SAFETY: the prefix is within the buffer.
+                listen_pid_digits: unsafe { listen_pid_ptr.add(LISTEN_PID_PREFIX.len()) },
+                envp,
+                moved_fds: vec![-1; sockets.len()],
+                fds: sockets.into_iter().map(|(_, fd)| fd).collect(),
+            })
+        }
+
+        /// Moves the sockets to the descriptors starting at [LISTEN_FDS_START] and executes the
+        /// process. Runs in the forked child, so it must not allocate.
+        fn exec(&mut self) -> io::Result<()> {
+            let fds_end = LISTEN_FDS_START + self.fds.len() as RawFd;
+            // Sockets are moved out of the target range first, as they may be already using it.
+            for (fd, moved) in self.fds.iter().zip(self.moved_fds.iter_mut()) {
+                // SAFETY: plain syscall on a valid descriptor.
+                *moved = unsafe { libc::fcntl(fd.as_raw_fd(), libc::F_DUPFD, fds_end) };
+                if *moved < 0 {
+                    return Err(io::Error::last_os_error());
+                }
+            }
+            for (target, moved) in (LISTEN_FDS_START..).zip(self.moved_fds.iter()) {
+                // SAFETY: plain syscalls on valid descriptors. The duplicate doesn't have
+                // `FD_CLOEXEC`, so it is inherited.
+                unsafe {
+                    if libc::dup2(*moved, target) < 0 {
+                        return Err(io::Error::last_os_error());
+                    }
+                    libc::close(*moved);
+                }
+            }
+
+            // SAFETY: `getpid` can't fail.
+            let pid = unsafe { libc::getpid() } as u32;
+            let mut digits = [0u8; PID_DIGITS];
+            let mut len = 0;
+            let mut remaining = pid;
+            loop {
+                digits[len] = b'0' + (remaining % 10) as u8;
+                len += 1;
+                remaining /= 10;
+                if remaining == 0 {
+                    break;
+                }
+            }
+            for (i, digit) in digits[..len].iter().rev().enumerate() {
+                // SAFETY: the buffer has room for `PID_DIGITS` digits.
+                unsafe { *self.listen_pid_digits.add(i) = *digit };
+            }
+
+            // SAFETY: `argv` and `envp` are null-terminated arrays of valid C strings.
+            unsafe {
+                libc::execve(
+                    self.program.as_ptr(),
+                    self.argv.as_ptr(),
+                    self.envp.as_ptr(),
+                )
+            };
+            Err(io::Error::last_os_error())
+        }
+    }
+
+    /// Returns the socket names in the format of `LISTEN_FDNAMES`.
+    fn fd_names(sockets: &[(String, OwnedFd)]) -> String {
+        sockets
+            .iter()
+            .map(|(name, _)| name.as_str())
+            .collect::<Vec<_>>()
+            .join(":")
+    }
+
+    fn c_string(bytes: Vec<u8>) -> io::Result<CString> {
+        CString::new(bytes).map_err(|err| io::Error::new(io::ErrorKind::InvalidInput, err))
+    }
+
+    /// Looks `bin` up in `path` unless it is already a path, like `execvp` does.
+    fn resolve_program(bin: &str, path: Option<&OsString>) -> io::Result<PathBuf> {
+        if bin.contains('/') {
+            return Ok(PathBuf::from(bin));
+        }
+        path.into_iter()
+            .flat_map(std::env::split_paths)
+            .map(|dir| dir.join(bin))
+            .find(|candidate| is_executable(candidate))
+            .ok_or_else(|| {
+                io::Error::new(
+                    io::ErrorKind::NotFound,
+                    format!("'{bin}' not found in PATH"),
+                )
+            })
+    }
+
+    fn is_executable(path: &Path) -> bool {
+        fs::metadata(path).is_ok_and(|m| m.is_file() && m.permissions().mode() & 0o111 != 0)
+    }
+
+    #[cfg(test)]
+    mod tests {
+        use super::*;
+        use std::io::Read;
+        use std::process::Stdio;
+        use tempfile::tempdir;
+
+        #[test]
+        fn test_listen_rejects_unknown_addresses() {
+            assert_eq!(
+                listen("udp://0.0.0.0:8125").unwrap_err().kind(),
+                io::ErrorKind::InvalidInput
+            );
+        }
+
+        #[test]
+        fn test_resolve_program() {
+            let path = std::env::join_paths(["/nonexistent", "/bin", "/usr/bin"]).unwrap();
+            assert!(resolve_program("sh", Some(&path)).unwrap().ends_with("sh"));
+            assert_eq!(
+                resolve_program("./agent", None).unwrap(),
+                PathBuf::from("./agent")
+            );
+            assert!(resolve_program("not-a-real-binary", Some(&path)).is_err());
+        }
+
+        #[test]
+        fn test_process_inherits_the_sockets() {
+            let dir = tempdir().unwrap();
+            let socket_path = dir.path().join("agent.sock");
+            let sockets = vec![
+                ("tcp".to_string(), listen("tcp://127.0.0.1:0").unwrap()),
+                (
+                    "local".to_string(),
+                    listen(&format!("unix://{}", socket_path.display())).unwrap(),
+                ),
+            ];
+            let executable = ExecutableData::new("sh".to_string(), "/bin/sh".to_string())
+                .with_args(vec![
+                    "-c".to_string(),
+                    // Fails unless both descriptors are open in the process.
+                    r#"test -e /dev/fd/3 && test -e /dev/fd/4 && [ "$LISTEN_PID" = "$$" ] && echo "$LISTEN_FDS $LISTEN_FDNAMES""#
+                        .to_string(),
+                ]);
+
+            let mut cmd = Command::new(&executable.bin);
+            cmd.stdout(Stdio::piped());
+            activate(&mut cmd, &executable, sockets).unwrap();
+            let mut child = cmd.spawn().unwrap();
+
+            let mut output = String::new();
+            child
+                .stdout
+                .take()
+                .unwrap()
+                .read_to_string(&mut output)
+                .unwrap();
+            assert!(child.wait().unwrap().success());
+            assert_eq!(output.trim(), "2 tcp:local");
+        }
+    }
+}
+
+#[cfg(not(target_family = "unix"))]
+mod platform {
+    pub(super) type Listener = std::convert::Infallible;
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[cfg(target_family = "unix")]
+    #[test]
+    fn test_sockets_are_kept_while_declared() {
+        use std::net::TcpListener;
+
+        let socket = ListenSocket {
+            name: "otlp".to_string(),
+            address: "tcp://127.0.0.1:0".to_string(),
+        };
+        let executable = ExecutableData::new("agent".to_string(), "/bin/agent".to_string())
+            .with_listen_sockets(vec![socket.clone()]);
+        let sockets = ActivationSockets::default();
+
+        let (_, first) = sockets
+            .inherited("agent", &[socket.clone()])
+            .unwrap()
+            .remove(0);
+        // The same socket is inherited by the next process instance.
+        let (_, second) = sockets
+            .clone()
+            .inherited("agent", &[socket.clone()])
+            .unwrap()
+            .remove(0);
+        assert_eq!(
+            TcpListener::from(first).local_addr().unwrap(),
+            TcpListener::from(second).local_addr().unwrap()
+        );
+
+        sockets.retain(std::slice::from_ref(&executable));
+        assert!(!sockets.is_empty());
+        sockets.retain(&[ExecutableData::new(
+            "agent".to_string(),
+            "/bin/agent".to_string(),
+        )]);
+        assert!(sockets.is_empty());
+    }
+}
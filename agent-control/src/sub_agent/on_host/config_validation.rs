@@ -0,0 +1,224 @@
+//! Validation of the candidate configuration of on-host agents before it is applied.
+//!
+//! Agent types can define a `validation` command, like `otelcol validate --config <file>`. The
+//! rendered filesystem of the candidate config is written to a temporary directory and the command
+//! runs on it, so a config the agent would refuse to start with is rejected, and reported as
+//! failed, without restarting the running agent.
+use crate::agent_type::runtime_config::on_host::rendered::OnHost;
+use fs::directory_manager::DirectoryManagerFs;
+use fs::file::LocalFile;
+use std::io::{ErrorKind, Read};
+use std::process::{Command, Stdio};
+use std::thread;
+use std::time::{Duration, Instant};
+use thiserror::Error;
+use tracing::debug;
+
+const POLL_INTERVAL: Duration = Duration::from_millis(100);
+/// Maximum length of the command output included in the errors.
+const MAX_OUTPUT_LEN: usize = 1024;
+
+/// Errors produced while validating a configuration.
+#[derive(Debug, Error)]
+pub enum ConfigValidationError {
+    /// The candidate files could not be written.
+    #[error("could not write the config to validate: {0}")]
+    Write(String),
+    /// The validation command could not be run.
+    #[error("could not run the validation command '{0}': {1}")]
+    Run(String, String),
+    /// The validation command rejected the config.
+    #[error("the config was rejected by '{path}' with exit code {code:?}: {output}")]
+    Rejected {
+        /// Path of the validation command.
+        path: String,
+        /// Exit code of the command.
+        code: Option<i32>,
+        /// Error output of the command.
+        output: String,
+    },
+    /// The validation command didn't finish in time.
+    #[error("the validation command '{0}' timed out after {1:?}")]
+    Timeout(String, Duration),
+}
+
+/// Runs the validation command of the agent, if any, on a copy of its rendered filesystem.
+///
+/// Validation is skipped when the command is not found, e.g. because it belongs to a package that
+/// is not installed yet.
+pub fn validate_config(on_host: &OnHost) -> Result<(), ConfigValidationError> {
+    let Some(validation) = &on_host.validation else {
+        return Ok(());
+    };
+
+    let candidate_dir =
+        tempfile::tempdir().map_err(|err| ConfigValidationError::Write(err.to_string()))?;
+    on_host
+        .filesystem
+        .relocated(candidate_dir.path())
+        .write(&LocalFile, &DirectoryManagerFs)
+        .map_err(|err| ConfigValidationError::Write(err.to_string()))?;
+
+    // Paths in the arguments point to the current files, replace them by the candidate ones.
+    let filesystem_dir = on_host.filesystem.base_dir().to_string_lossy().to_string();
+    let candidate_dir_str = candidate_dir.path().to_string_lossy().to_string();
+    let args: Vec<String> = validation
+        .args
+        .0
+        .iter()
+        .map(|arg| arg.replace(&filesystem_dir, &candidate_dir_str))
+        .collect();
+
+    debug!(path = %validation.path, ?args, "Validating the config");
+    let mut child = match Command::new(&validation.path)
+        .args(&args)
+        .stdout(Stdio::null())
+        .stderr(Stdio::piped())
+        .spawn()
+    {
+        Ok(child) => child,
+        Err(err) if err.kind() == ErrorKind::NotFound => {
+            debug!(path = %validation.path, "Validation command not found, skipping the validation");
+            return Ok(());
+        }
+        Err(err) => {
+            return Err(ConfigValidationError::Run(
+                validation.path.clone(),
+                err.to_string(),
+            ));
+        }
+    };
+
+    let deadline = Instant::now() + validation.timeout;
+    loop {
+        let status = child
+            .try_wait()
+            .map_err(|err| ConfigValidationError::Run(validation.path.clone(), err.to_string()))?;
+        if let Some(status) = status {
+            if status.success() {
+                return Ok(());
+            }
+            let mut output = String::new();
+            if let Some(mut stderr) = child.stderr.take() {
+                let _ = stderr.read_to_string(&mut output);
+            }
+            return Err(ConfigValidationError::Rejected {
+                path: validation.path.clone(),
+                code: status.code(),
+                output: truncate(output.trim()),
+            });
+        }
+        if Instant::now() >= deadline {
+            let _ = child.kill();
+            let _ = child.wait();
+            return Err(ConfigValidationError::Timeout(
+                validation.path.clone(),
+                validation.timeout,
+            ));
+        }
+        thread::sleep(POLL_INTERVAL);
+    }
+}
+
+fn truncate(output: &str) -> String {
+    match output.char_indices().nth(MAX_OUTPUT_LEN) {
+        Some((end, _)) => format!("{}...", &output[..end]),
+        None => output.to_string(),
+    }
+}
+
+#[cfg(all(test, target_family = "unix"))]
+mod tests {
+    use super::*;
+    use crate::agent_type::agent_attributes::AgentAttributes;
+    use crate::agent_type::definition::Variables;
+    use crate::agent_type::runtime_config::on_host::OnHost as OnHostDefinition;
+    use crate::agent_type::templates::Templateable;
+    use crate::agent_type::variable::Variable;
+    use crate::agent_type::variable::namespace::Namespace;
+    use assert_matches::assert_matches;
+    use tempfile::TempDir;
+
+    fn on_host(filesystem_dir: &TempDir, validation: &str) -> OnHost {
+        let yaml = format!(
+            r#"
+filesystem:
+  otel-config:
+    kind: dir
+    entries:
+      config.yaml:
+        kind: file
+        text: "receivers: {{}}"
+validation:
+{validation}
+"#
+        );
+        let variables = Variables::from([(
+            Namespace::SubAgent.namespaced_name(AgentAttributes::VARIABLE_FILESYSTEM_AGENT_DIR),
+            Variable::new_final_string_variable(filesystem_dir.path().to_string_lossy()),
+        )]);
+        serde_saphyr::from_str::<OnHostDefinition>(&yaml)
+            .unwrap()
+            .template_with(&variables)
+            .unwrap()
+    }
+
+    #[test]
+    fn test_valid_config() {
+        let filesystem_dir = TempDir::new().unwrap();
+        let on_host = on_host(
+            &filesystem_dir,
+            r#"
+  path: sh
+  args: ["-c", "grep -q receivers \"$0\"", "${nr-sub:filesystem_agent_dir}/otel-config/config.yaml"]
+"#,
+        );
+
+        validate_config(&on_host).unwrap();
+        // The current files are untouched
+        assert!(!filesystem_dir.path().join("otel-config").exists());
+    }
+
+    #[test]
+    fn test_rejected_config() {
+        let filesystem_dir = TempDir::new().unwrap();
+        let on_host = on_host(
+            &filesystem_dir,
+            r#"
+  path: sh
+  args: ["-c", "echo 'unknown receiver' >&2; exit 1"]
+"#,
+        );
+
+        assert_matches!(
+            validate_config(&on_host),
+            Err(ConfigValidationError::Rejected { code: Some(1), output, .. }) if output == "unknown receiver"
+        );
+    }
+
+    #[test]
+    fn test_validation_timeout() {
+        let filesystem_dir = TempDir::new().unwrap();
+        let on_host = on_host(
+            &filesystem_dir,
+            r#"
+  path: sleep
+  args: ["10"]
+  timeout: 200ms
+"#,
+        );
+
+        assert_matches!(
+            validate_config(&on_host),
+            Err(ConfigValidationError::Timeout(_, _))
+        );
+    }
+
+    #[test]
+    fn test_missing_command_is_skipped() {
+        let filesystem_dir = TempDir::new().unwrap();
+        let on_host = on_host(&filesystem_dir, "  path: /non/existing/otelcol");
+
+        validate_config(&on_host).unwrap();
+    }
+}
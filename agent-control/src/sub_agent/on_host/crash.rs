@@ -0,0 +1,474 @@
+//! Crash reports of the on-host executables.
+//!
+//! When an executable is killed by a signal (not sent by its supervisor), a crash report is
+//! written to the `crashes` directory of the agent logs, so it is collected along with them in
+//! diagnostics bundles. The report includes the signal, whether (and where) a core dump was
+//! written and the last lines of the executable stderr. If a symbolizer is configured and the
+//! core dump is available as a file, it is run with the executable and core dump paths as its last
+//! arguments, and its output is stored next to the report.
+//!
+//! ```yaml
+//! crash_reports:
+//!   symbolizer:
+//!     path: /usr/bin/gdb
+//!     args: [-batch, -ex, "thread apply all bt"]
+//!     timeout: 30s
+//! ```
+
+use crate::sub_agent::on_host::command::executable_data::ExecutableData;
+use crate::sub_agent::on_host::command::logging::tail::OutputTail;
+use chrono::Utc;
+use duration_str::deserialize_duration;
+use fs::directory_manager::{DirectoryManager, DirectoryManagerFs};
+use fs::file::{LocalFile, writer::FileWriter};
+use serde::{Deserialize, Serialize};
+use std::fs::File;
+use std::path::{Path, PathBuf};
+use std::process::{Command, ExitStatus, Stdio};
+use std::time::{Duration, Instant};
+use tracing::{debug, warn};
+use wrapper_with_default::WrapperWithDefault;
+
+const DEFAULT_SYMBOLIZER_TIMEOUT: Duration = Duration::from_secs(30);
+const SYMBOLIZER_POLL_INTERVAL: Duration = Duration::from_millis(100);
+const KERNEL_PARAMS_DIR: &str = "/proc/sys/kernel";
+/// The kernel truncates the executable name in core dump patterns (`%e`) to this length.
+const MAX_COMM_LEN: usize = 15;
+
+/// Crash reports configuration.
+#[derive(Debug, Deserialize, PartialEq, Clone, Default)]
+pub struct CrashReportsConfig {
+    /// Command run to symbolize the core dumps.
+    #[serde(default)]
+    pub symbolizer: Option<SymbolizerConfig>,
+}
+
+/// Command symbolizing a core dump. It gets the executable and the core dump paths appended to
+/// its arguments.
+#[derive(Debug, Deserialize, PartialEq, Clone)]
+pub struct SymbolizerConfig {
+    /// Path of the symbolizer executable.
+    pub path: PathBuf,
+    /// Arguments preceding the executable and core dump paths.
+    #[serde(default)]
+    pub args: Vec<String>,
+    /// Time the symbolizer is allowed to run, it is killed afterwards.
+    #[serde(default)]
+    pub timeout: SymbolizerTimeout,
+}
+
+/// Time the symbolizer is allowed to run.
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_SYMBOLIZER_TIMEOUT)]
+pub struct SymbolizerTimeout(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// Report of an executable killed by a signal.
+#[derive(Debug, Serialize, PartialEq)]
+pub struct CrashReport {
+    /// Time of the crash, RFC 3339 formatted.
+    pub time: String,
+    /// Identifier of the executable within the agent.
+    pub executable_id: String,
+    /// Path of the executable.
+    pub executable: String,
+    /// Process id of the crashed process.
+    pub pid: u32,
+    /// Signal that killed the process.
+    pub signal: String,
+    /// Whether the kernel dumped the process core.
+    pub core_dumped: bool,
+    /// Path of the core dump, when written to a file.
+    pub core_dump_path: Option<PathBuf>,
+    /// Program the core dump was piped to, like `systemd-coredump`.
+    pub core_dump_handler: Option<String>,
+    /// Path of the symbolizer output.
+    pub symbolized_path: Option<PathBuf>,
+    /// Last lines written by the process to stderr.
+    pub stderr_tail: Vec<String>,
+    /// Path the report was written to.
+    #[serde(skip)]
+    pub report_path: Option<PathBuf>,
+}
+
+impl CrashReport {
+    /// One line summary of the crash, reported as health error.
+    pub fn summary(&self) -> String {
+        let mut summary = format!("killed by signal {}", self.signal);
+        match (&self.core_dump_path, &self.core_dump_handler) {
+            (Some(path), _) => summary.push_str(&format!(", core dumped to {}", path.display())),
+            (None, Some(handler)) => summary.push_str(&format!(", core dumped to '{handler}'")),
+            (None, None) if self.core_dumped => summary.push_str(", core dumped"),
+            _ => {}
+        }
+        if let Some(line) = self.stderr_tail.last() {
+            summary.push_str(&format!(", last stderr line: '{line}'"));
+        }
+        if let Some(path) = &self.report_path {
+            summary.push_str(&format!(", crash report: {}", path.display()));
+        }
+        summary
+    }
+}
+
+/// Builds and stores the crash reports of the executables of an agent.
+#[derive(Debug, Clone)]
+pub struct CrashCollector {
+    reports_dir: PathBuf,
+    symbolizer: Option<SymbolizerConfig>,
+    kernel_params_dir: PathBuf,
+}
+
+impl CrashCollector {
+    /// Returns a collector writing reports to `reports_dir`.
+    pub fn new(reports_dir: PathBuf, config: &CrashReportsConfig) -> Self {
+        Self {
+            reports_dir,
+            symbolizer: config.symbolizer.clone(),
+            kernel_params_dir: PathBuf::from(KERNEL_PARAMS_DIR),
+        }
+    }
+
+    /// Returns the crash report of the process if it was killed by a signal, storing it.
+    pub(crate) fn collect(
+        &self,
+        exec_data: &ExecutableData,
+        pid: u32,
+        exit_status: &ExitStatus,
+        stderr_tail: &OutputTail,
+    ) -> Option<CrashReport> {
+        let (signal, core_dumped) = termination_signal(exit_status)?;
+        let now = Utc::now();
+        let file_prefix = format!("{}-{}", now.format("%Y%m%dT%H%M%SZ"), exec_data.id);
+
+        let mut report = CrashReport {
+            time: now.to_rfc3339(),
+            executable_id: exec_data.id.clone(),
+            executable: exec_data.bin.clone(),
+            pid,
+            signal,
+            core_dumped,
+            core_dump_path: None,
+            core_dump_handler: None,
+            symbolized_path: None,
+            stderr_tail: stderr_tail.lines(),
+            report_path: None,
+        };
+        if core_dumped {
+            self.locate_core_dump(&mut report);
+        }
+
+        if let Err(err) = DirectoryManagerFs.create(&self.reports_dir) {
+            warn!(executable = %exec_data.bin, "Could not store the crash report: {err}");
+            return Some(report);
+        }
+        if let (Some(symbolizer), Some(core_dump)) = (&self.symbolizer, &report.core_dump_path) {
+            let output_path = self
+                .reports_dir
+                .join(format!("{file_prefix}.symbolized.txt"));
+            report.symbolized_path = symbolize(symbolizer, &exec_data.bin, core_dump, &output_path)
+                .inspect_err(|err| warn!(executable = %exec_data.bin, "Symbolizing crash: {err}"))
+                .ok()
+                .map(|_| output_path);
+        }
+
+        let report_path = self.reports_dir.join(format!("{file_prefix}.yaml"));
+        let stored = serde_saphyr::to_string(&report)
+            .map_err(|err| err.to_string())
+            .and_then(|content| {
+                LocalFile
+                    .write(&report_path, content)
+                    .map_err(|err| err.to_string())
+            });
+        match stored {
+            Ok(()) => report.report_path = Some(report_path),
+            Err(err) => {
+                warn!(executable = %exec_data.bin, "Could not store the crash report: {err}")
+            }
+        }
+        Some(report)
+    }
+
+    /// Sets where the core dump of the report was written to, according to the kernel
+    /// `core_pattern`. Dump files are only reported if they exist.
+    fn locate_core_dump(&self, report: &mut CrashReport) {
+        let Ok(pattern) = std::fs::read_to_string(self.kernel_params_dir.join("core_pattern"))
+        else {
+            return;
+        };
+        let pattern = pattern.trim();
+        if let Some(handler) = pattern.strip_prefix('|') {
+            report.core_dump_handler = handler.split_whitespace().next().map(String::from);
+            return;
+        }
+        let uses_pid = std::fs::read_to_string(self.kernel_params_dir.join("core_uses_pid"))
+            .is_ok_and(|value| value.trim() == "1");
+        let Some(path) = core_dump_path(pattern, uses_pid, report.pid, &report.executable) else {
+            debug!(
+                pattern,
+                "Core dump pattern not supported, the core dump is not located"
+            );
+            return;
+        };
+        // Relative patterns are relative to the working directory of the process, inherited from
+        // Agent Control.
+        let path = match std::env::current_dir() {
+            Ok(cwd) if path.is_relative() => cwd.join(path),
+            _ => path,
+        };
+        report.core_dump_path = path.exists().then_some(path);
+    }
+}
+
+/// Returns the signal that killed the process, and whether its core was dumped.
+#[cfg(target_family = "unix")]
+fn termination_signal(exit_status: &ExitStatus) -> Option<(String, bool)> {
+    use nix::sys::signal::Signal;
+    use std::os::unix::process::ExitStatusExt;
+
+    let signal = exit_status.signal()?;
+    let name = Signal::try_from(signal)
+        .map(|signal| signal.as_str().to_string())
+        .unwrap_or_else(|_| signal.to_string());
+    Some((name, exit_status.core_dumped()))
+}
+
+/// Processes are not terminated by signals on Windows.
+#[cfg(target_family = "windows")]
+fn termination_signal(_exit_status: &ExitStatus) -> Option<(String, bool)> {
+    None
+}
+
+/// Expands a kernel `core_pattern` for the given process. Returns `None` if the pattern contains
+/// specifiers that can't be known after the crash, like the dump time.
+fn core_dump_path(pattern: &str, uses_pid: bool, pid: u32, executable: &str) -> Option<PathBuf> {
+    let mut path = String::new();
+    let mut has_pid = false;
+    let mut chars = pattern.chars();
+    while let Some(c) = chars.next() {
+        if c != '%' {
+            path.push(c);
+            continue;
+        }
+        match chars.next()? {
+            '%' => path.push('%'),
+            'p' | 'P' => {
+                path.push_str(&pid.to_string());
+                has_pid = true;
+            }
+            'e' => {
+                let name = Path::new(executable).file_name()?.to_string_lossy();
+                path.extend(name.chars().take(MAX_COMM_LEN));
+            }
+            'E' => path.push_str(&executable.replace('/', "!")),
+            _ => return None,
+        }
+    }
+    if uses_pid && !has_pid {
+        path.push_str(&format!(".{pid}"));
+    }
+    Some(PathBuf::from(path))
+}
+
+/// Runs the symbolizer, writing its output to `output_path`.
+fn symbolize(
+    config: &SymbolizerConfig,
+    executable: &str,
+    core_dump: &Path,
+    output_path: &Path,
+) -> Result<(), String> {
+    let output = File::create(output_path).map_err(|err| err.to_string())?;
+    let stderr = output.try_clone().map_err(|err| err.to_string())?;
+    let mut child = Command::new(&config.path)
+        .args(&config.args)
+        .arg(executable)
+        .arg(core_dump)
+        .stdin(Stdio::null())
+        .stdout(output)
+        .stderr(stderr)
+        .spawn()
+        .map_err(|err| format!("running {}: {err}", config.path.display()))?;
+
+    let deadline = Instant::now() + Duration::from(config.timeout);
+    loop {
+        match child.try_wait().map_err(|err| err.to_string())? {
+            Some(status) if status.success() => return Ok(()),
+            Some(status) => return Err(format!("the symbolizer failed with '{status}'")),
+            None if Instant::now() > deadline => {
+                let _ = child.kill();
+                let _ = child.wait();
+                return Err("the symbolizer timed out".to_string());
+            }
+            None => std::thread::sleep(SYMBOLIZER_POLL_INTERVAL),
+        }
+    }
+}
+
+#[cfg(all(test, target_family = "unix"))]
+mod tests {
+    use super::*;
+    use std::os::unix::process::ExitStatusExt;
+    use tempfile::tempdir;
+
+    const SIGSEGV: i32 = 11;
+    const CORE_DUMP_FLAG: i32 = 0x80;
+
+    fn exec_data() -> ExecutableData {
+        ExecutableData::new("agent".to_string(), "/usr/bin/agent".to_string())
+    }
+
+    fn collector(reports_dir: PathBuf, kernel_params_dir: PathBuf) -> CrashCollector {
+        CrashCollector {
+            reports_dir,
+            symbolizer: None,
+            kernel_params_dir,
+        }
+    }
+
+    #[test]
+    fn test_termination_signal() {
+        assert_eq!(
+            termination_signal(&ExitStatus::from_raw(SIGSEGV)),
+            Some(("SIGSEGV".to_string(), false))
+        );
+        assert_eq!(
+            termination_signal(&ExitStatus::from_raw(SIGSEGV | CORE_DUMP_FLAG)),
+            Some(("SIGSEGV".to_string(), true))
+        );
+        // Exit code 1
+        assert_eq!(termination_signal(&ExitStatus::from_raw(1 << 8)), None);
+    }
+
+    #[test]
+    fn test_core_dump_path() {
+        assert_eq!(
+            core_dump_path(
+                "/var/crash/core.%e.%p",
+                false,
+                42,
+                "/usr/bin/a-very-long-agent-name"
+            ),
+            Some(PathBuf::from("/var/crash/core.a-very-long-age.42"))
+        );
+        assert_eq!(
+            core_dump_path("core", true, 42, "/usr/bin/agent"),
+            Some(PathBuf::from("core.42"))
+        );
+        assert_eq!(
+            core_dump_path("/cores/%E-100%%", false, 42, "/usr/bin/agent"),
+            Some(PathBuf::from("/cores/!usr!bin!agent-100%"))
+        );
+        // The dump time is unknown
+        assert_eq!(
+            core_dump_path("/cores/%t", false, 42, "/usr/bin/agent"),
+            None
+        );
+    }
+
+    #[test]
+    fn test_collect_stores_report() {
+        let dir = tempdir().unwrap();
+        let reports_dir = dir.path().join("crashes");
+        let stderr_tail = OutputTail::default();
+        stderr_tail.push("starting".to_string());
+        stderr_tail.push("segmentation fault".to_string());
+
+        let report = collector(reports_dir.clone(), dir.path().join("no-kernel"))
+            .collect(
+                &exec_data(),
+                42,
+                &ExitStatus::from_raw(SIGSEGV),
+                &stderr_tail,
+            )
+            .unwrap();
+
+        assert_eq!(report.signal, "SIGSEGV");
+        assert_eq!(report.stderr_tail, vec!["starting", "segmentation fault"]);
+        let report_path = report.report_path.clone().unwrap();
+        assert!(report_path.starts_with(&reports_dir));
+        let stored = std::fs::read_to_string(&report_path).unwrap();
+        assert!(stored.contains("signal: SIGSEGV"));
+        assert_eq!(
+            report.summary(),
+            format!(
+                "killed by signal SIGSEGV, last stderr line: 'segmentation fault', crash report: {}",
+                report_path.display()
+            )
+        );
+    }
+
+    #[test]
+    fn test_collect_ignores_exit_codes() {
+        let dir = tempdir().unwrap();
+        let report = collector(dir.path().to_path_buf(), dir.path().to_path_buf()).collect(
+            &exec_data(),
+            42,
+            &ExitStatus::from_raw(1 << 8),
+            &OutputTail::default(),
+        );
+        assert!(report.is_none());
+        assert!(std::fs::read_dir(dir.path()).unwrap().next().is_none());
+    }
+
+    #[test]
+    fn test_collect_symbolizes_core_dump() {
+        let dir = tempdir().unwrap();
+        let kernel_params_dir = dir.path().join("kernel");
+        std::fs::create_dir(&kernel_params_dir).unwrap();
+        let core_pattern = dir.path().join("core.%p");
+        std::fs::write(
+            kernel_params_dir.join("core_pattern"),
+            format!("{}\n", core_pattern.display()),
+        )
+        .unwrap();
+        let core_dump = dir.path().join("core.42");
+        std::fs::write(&core_dump, "core").unwrap();
+
+        let collector = CrashCollector {
+            symbolizer: Some(SymbolizerConfig {
+                path: PathBuf::from("/bin/sh"),
+                args: vec!["-c".to_string(), "echo symbolized $0 $1".to_string()],
+                timeout: SymbolizerTimeout::default(),
+            }),
+            ..collector(dir.path().join("crashes"), kernel_params_dir)
+        };
+        let report = collector
+            .collect(
+                &exec_data(),
+                42,
+                &ExitStatus::from_raw(SIGSEGV | CORE_DUMP_FLAG),
+                &OutputTail::default(),
+            )
+            .unwrap();
+
+        assert_eq!(report.core_dump_path, Some(core_dump.clone()));
+        let symbolized = std::fs::read_to_string(report.symbolized_path.unwrap()).unwrap();
+        assert_eq!(
+            symbolized,
+            format!("symbolized /usr/bin/agent {}\n", core_dump.display())
+        );
+    }
+
+    #[test]
+    fn test_core_dump_handler() {
+        let dir = tempdir().unwrap();
+        std::fs::write(
+            dir.path().join("core_pattern"),
+            "|/usr/lib/systemd/systemd-coredump %P %u %g %s %t\n",
+        )
+        .unwrap();
+
+        let report = collector(dir.path().join("crashes"), dir.path().to_path_buf())
+            .collect(
+                &exec_data(),
+                42,
+                &ExitStatus::from_raw(SIGSEGV | CORE_DUMP_FLAG),
+                &OutputTail::default(),
+            )
+            .unwrap();
+        assert_eq!(
+            report.core_dump_handler.as_deref(),
+            Some("/usr/lib/systemd/systemd-coredump")
+        );
+        assert!(report.core_dump_path.is_none());
+    }
+}
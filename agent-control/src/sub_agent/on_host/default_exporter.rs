@@ -0,0 +1,280 @@
+//! Default New Relic exporter of the on-host OpenTelemetry collectors.
+//!
+//! When enabled, collector configs without exporters get an [DEFAULT_EXPORTER_ID] exporter sending
+//! their data to the OTLP endpoint of the New Relic region, authenticated with the license key of
+//! the Agent Control [Credentials]. So minimal configs, e.g. only defining receivers, still send
+//! their data somewhere sensible.
+//!
+//! The license key is not written to the config files: the exporter references the
+//! [LICENSE_KEY_ENV_VAR] environment variable, which is set on the collector executables.
+use crate::agent_control::credentials::Credentials;
+use crate::agent_control::defaults::{AGENT_TYPE_NAME_NRDOT, AGENT_TYPE_NAME_OTEL_COLLECTOR};
+use crate::agent_type::agent_type_id::AgentTypeID;
+use crate::agent_type::runtime_config::on_host::rendered::OnHost;
+use crate::cli::common::region::Region;
+use crate::values::yaml_config::YAMLConfig;
+use serde::Deserialize;
+use serde_json::{Map, Value, json};
+use thiserror::Error;
+
+/// Id of the exporter added to the collector configs.
+pub const DEFAULT_EXPORTER_ID: &str = "otlphttp/newrelic";
+/// Environment variable of the collector holding the license key of the exporter.
+pub const LICENSE_KEY_ENV_VAR: &str = "NEW_RELIC_LICENSE_KEY";
+/// Variable of the collector agent types holding the collector config.
+const COLLECTOR_CONFIG_VARIABLE: &str = "config";
+
+/// Configuration of the default exporter of the on-host collectors.
+#[derive(Debug, Default, Deserialize, PartialEq, Clone)]
+#[serde(default)]
+pub struct DefaultExporterConfig {
+    /// Whether collector configs without exporters get the default one. Disabled by default.
+    pub enabled: bool,
+}
+
+/// Errors building the default exporter.
+#[derive(Debug, Error)]
+pub enum DefaultExporterError {
+    /// There is no license key to authenticate the exporter.
+    #[error("the default exporter requires `credentials.license_key`")]
+    MissingLicenseKey,
+}
+
+/// Adds the New Relic exporter to the collector configs that have none.
+#[derive(Clone)]
+pub struct DefaultExporter {
+    endpoint: String,
+    license_key: String,
+}
+
+impl DefaultExporter {
+    /// Builds the exporter from the license key and region of the credentials, US by default.
+    pub fn try_new(credentials: &Credentials) -> Result<Self, DefaultExporterError> {
+        let license_key = credentials
+            .license_key
+            .clone()
+            .ok_or(DefaultExporterError::MissingLicenseKey)?;
+        let region = credentials.region.unwrap_or(Region::US);
+
+        Ok(Self {
+            endpoint: region
+                .otlp_http_endpoint()
+                .to_string()
+                .trim_end_matches('/')
+                .to_string(),
+            license_key,
+        })
+    }
+
+    /// Whether the agent is a collector whose config has no exporters, so it gets the exporter.
+    pub fn applies_to(&self, agent_type_id: &AgentTypeID, values: &YAMLConfig) -> bool {
+        [AGENT_TYPE_NAME_NRDOT, AGENT_TYPE_NAME_OTEL_COLLECTOR].contains(&agent_type_id.name())
+            && matches!(
+                values.get(COLLECTOR_CONFIG_VARIABLE),
+                Some(Value::Object(config)) if !has_exporters(config)
+            )
+    }
+
+    /// Returns the values of the agent, with the exporter added to the collector config if it
+    /// [applies to](Self::applies_to) the agent. Pipelines without exporters send their data to it.
+    pub fn scaffold(&self, agent_type_id: &AgentTypeID, mut values: YAMLConfig) -> YAMLConfig {
+        if !self.applies_to(agent_type_id, &values) {
+            return values;
+        }
+
+        if let Some(Value::Object(config)) = values.get(COLLECTOR_CONFIG_VARIABLE) {
+            let config = self.with_exporter(config.clone());
+            values.insert(COLLECTOR_CONFIG_VARIABLE, Value::Object(config));
+        }
+        values
+    }
+
+    /// Sets the license key referenced by the exporter in the environment of the executables of
+    /// the rendered collector.
+    pub fn set_license_key_env(&self, on_host: &mut OnHost) {
+        for executable in on_host.executables.iter_mut() {
+            executable
+                .env
+                .0
+                .insert(LICENSE_KEY_ENV_VAR.to_string(), self.license_key.clone());
+        }
+    }
+
+    fn with_exporter(&self, mut config: Map<String, Value>) -> Map<String, Value> {
+        config.insert(
+            "exporters".to_string(),
+            json!({
+                DEFAULT_EXPORTER_ID: {
+                    "endpoint": self.endpoint,
+                    "headers": { "api-key": format!("${{env:{LICENSE_KEY_ENV_VAR}}}") },
+                }
+            }),
+        );
+
+        let pipelines = config
+            .get_mut("service")
+            .and_then(|service| service.get_mut("pipelines"))
+            .and_then(Value::as_object_mut);
+        for pipeline in pipelines.into_iter().flat_map(|p| p.values_mut()) {
+            if let Value::Object(pipeline) = pipeline
+                && !pipeline
+                    .get("exporters")
+                    .and_then(Value::as_array)
+                    .is_some_and(|exporters| !exporters.is_empty())
+            {
+                pipeline.insert("exporters".to_string(), json!([DEFAULT_EXPORTER_ID]));
+            }
+        }
+        config
+    }
+}
+
+fn has_exporters(config: &Map<String, Value>) -> bool {
+    config
+        .get("exporters")
+        .and_then(Value::as_object)
+        .is_some_and(|exporters| !exporters.is_empty())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_control::defaults::AGENT_TYPE_NAME_INFRA_AGENT;
+    use crate::agent_type::runtime_config::on_host::executable::rendered::Executable;
+    use crate::agent_type::runtime_config::on_host::filesystem::rendered::FileSystem;
+    use assert_matches::assert_matches;
+
+    fn agent_type_id(name: &str) -> AgentTypeID {
+        AgentTypeID::try_from(format!("newrelic/{name}:0.0.1").as_str()).unwrap()
+    }
+
+    fn exporter() -> DefaultExporter {
+        DefaultExporter::try_new(&Credentials {
+            license_key: Some("license".to_string()),
+            region: Some(Region::EU),
+            ..Default::default()
+        })
+        .unwrap()
+    }
+
+    #[test]
+    fn test_try_new() {
+        assert_eq!(exporter().endpoint, "https://otlp.eu01.nr-data.net:4318");
+        let us = DefaultExporter::try_new(&Credentials {
+            license_key: Some("license".to_string()),
+            ..Default::default()
+        })
+        .unwrap();
+        assert_eq!(us.endpoint, "https://otlp.nr-data.net:4318");
+
+        assert_matches!(
+            DefaultExporter::try_new(&Credentials::default()),
+            Err(DefaultExporterError::MissingLicenseKey)
+        );
+    }
+
+    #[test]
+    fn test_scaffold_config_without_exporters() {
+        let values = YAMLConfig::try_from(
+            r#"
+config:
+  receivers:
+    otlp:
+  service:
+    pipelines:
+      traces:
+        receivers: [otlp]
+      metrics:
+        receivers: [otlp]
+        exporters: [forward]
+"#,
+        )
+        .unwrap();
+        let expected = YAMLConfig::try_from(
+            r#"
+config:
+  receivers:
+    otlp:
+  exporters:
+    otlphttp/newrelic:
+      endpoint: https://otlp.eu01.nr-data.net:4318
+      headers:
+        api-key: ${env:NEW_RELIC_LICENSE_KEY}
+  service:
+    pipelines:
+      traces:
+        receivers: [otlp]
+        exporters: [otlphttp/newrelic]
+      metrics:
+        receivers: [otlp]
+        exporters: [forward]
+"#,
+        )
+        .unwrap();
+
+        assert!(exporter().applies_to(&agent_type_id(AGENT_TYPE_NAME_NRDOT), &values));
+        let scaffolded = exporter().scaffold(&agent_type_id(AGENT_TYPE_NAME_NRDOT), values);
+        assert_eq!(scaffolded, expected);
+        assert!(
+            !serde_json::to_string(&scaffolded)
+                .unwrap()
+                .contains("license")
+        );
+    }
+
+    #[test]
+    fn test_set_license_key_env() {
+        let mut on_host = OnHost {
+            executables: vec![Executable::default(), Executable::default()],
+            enable_file_logging: false,
+            health: Default::default(),
+            filesystem: FileSystem::test_empty(),
+            packages: Default::default(),
+            validation: None,
+        };
+        exporter().set_license_key_env(&mut on_host);
+
+        for executable in on_host.executables {
+            assert_eq!(
+                executable
+                    .env
+                    .0
+                    .get(LICENSE_KEY_ENV_VAR)
+                    .map(String::as_str),
+                Some("license")
+            );
+        }
+    }
+
+    #[test]
+    fn test_scaffold_keeps_configs() {
+        let with_exporters = YAMLConfig::try_from(
+            r#"
+config:
+  exporters:
+    debug:
+"#,
+        )
+        .unwrap();
+        assert!(!exporter().applies_to(
+            &agent_type_id(AGENT_TYPE_NAME_OTEL_COLLECTOR),
+            &with_exporters
+        ));
+        assert_eq!(
+            exporter().scaffold(
+                &agent_type_id(AGENT_TYPE_NAME_OTEL_COLLECTOR),
+                with_exporters.clone()
+            ),
+            with_exporters
+        );
+
+        let other_agent = YAMLConfig::try_from("config: {}").unwrap();
+        assert_eq!(
+            exporter().scaffold(
+                &agent_type_id(AGENT_TYPE_NAME_INFRA_AGENT),
+                other_agent.clone()
+            ),
+            other_agent
+        );
+    }
+}
@@ -0,0 +1,138 @@
+//! Inventory of the on-host integrations enabled through the `integrations.d` directory of an
+//! agent, as the Infrastructure agent does.
+use crate::agent_type::runtime_config::on_host::filesystem::rendered::FileSystem;
+use serde::Deserialize;
+use std::collections::BTreeSet;
+use std::path::Path;
+use tracing::warn;
+
+/// Directory, relative to the agent filesystem dir, holding the integrations config files.
+pub const INTEGRATIONS_DIR: &str = "integrations.d";
+
+/// Integrations config file. Only the fields needed to identify the integrations are read.
+#[derive(Debug, Default, Deserialize)]
+struct IntegrationsConfig {
+    #[serde(default)]
+    integrations: Vec<IntegrationConfig>,
+    /// Name of the integration in the legacy (v3) config format.
+    #[serde(default)]
+    integration_name: Option<String>,
+}
+
+#[derive(Debug, Deserialize)]
+struct IntegrationConfig {
+    name: String,
+}
+
+/// Returns the sorted names of the integrations enabled in the `integrations.d` directory of the
+/// agent filesystem, or `None` if the agent doesn't declare such directory.
+///
+/// Files that are not YAML, as the Infrastructure agent ignores them too, or can't be parsed are
+/// skipped.
+pub fn enabled_integrations(filesystem: &FileSystem) -> Option<Vec<String>> {
+    let files = filesystem.dir_files(Path::new(INTEGRATIONS_DIR))?;
+
+    let mut names = BTreeSet::new();
+    for (file, content) in files {
+        if !is_yaml(file) || content.trim().is_empty() {
+            continue;
+        }
+        match serde_saphyr::from_str::<IntegrationsConfig>(content) {
+            Ok(config) => {
+                names.extend(config.integrations.into_iter().map(|i| i.name));
+                names.extend(config.integration_name);
+            }
+            Err(err) => {
+                warn!(file = %file.display(), "Skipping invalid integrations config: {err}");
+            }
+        }
+    }
+    Some(names.into_iter().collect())
+}
+
+fn is_yaml(file: &Path) -> bool {
+    file.extension()
+        .is_some_and(|ext| ext == "yaml" || ext == "yml")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent_type::agent_attributes::AgentAttributes;
+    use crate::agent_type::definition::Variables;
+    use crate::agent_type::runtime_config::on_host::filesystem::FileSystem as ParsedFileSystem;
+    use crate::agent_type::templates::Templateable;
+    use crate::agent_type::variable::{Variable, namespace::Namespace};
+
+    fn render(yaml: &str) -> FileSystem {
+        let variables = Variables::from_iter(vec![(
+            Namespace::SubAgent.namespaced_name(AgentAttributes::VARIABLE_FILESYSTEM_AGENT_DIR),
+            Variable::new_final_string_variable("/agent"),
+        )]);
+        serde_saphyr::from_str::<ParsedFileSystem>(yaml)
+            .unwrap()
+            .template_with(&variables)
+            .unwrap()
+    }
+
+    #[test]
+    fn test_enabled_integrations() {
+        let filesystem = render(
+            r#"
+integrations.d:
+  kind: dir
+  entries:
+    mysql.yaml:
+      kind: file
+      text: |
+        integrations:
+          - name: nri-mysql
+            interval: 30s
+          - name: nri-mysql
+            env:
+              PORT: 3307
+    redis.yml:
+      kind: file
+      text: |
+        integration_name: com.newrelic.redis
+        instances: []
+    flex.yaml:
+      kind: file
+      text: |
+        integrations:
+          - name: nri-flex
+    README.md:
+      kind: file
+      text: "integrations: [{name: nri-docs}]"
+    empty.yaml:
+      kind: file
+      text: ""
+    invalid.yaml:
+      kind: file
+      text: "integrations: not-a-list"
+"#,
+        );
+
+        assert_eq!(
+            enabled_integrations(&filesystem),
+            Some(vec![
+                "com.newrelic.redis".to_string(),
+                "nri-flex".to_string(),
+                "nri-mysql".to_string(),
+            ])
+        );
+    }
+
+    #[test]
+    fn test_no_integrations_dir() {
+        let filesystem = render(
+            r#"
+config.yaml:
+  kind: file
+  text: "key: value"
+"#,
+        );
+
+        assert_eq!(enabled_integrations(&filesystem), None);
+    }
+}
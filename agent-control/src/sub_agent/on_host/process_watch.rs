@@ -0,0 +1,433 @@
+//! Watch of the supervised executables for changes made outside Agent Control.
+//!
+//! Supervised processes killed by a termination signal Agent Control didn't send are always
+//! audited. When the watch is enabled, processes running a supervised executable that were not
+//! started by Agent Control (for example, the agent restarted manually or by the service manager,
+//! getting a new PID) are audited and terminated too, so the supervised process is the only
+//! running instance and the actual state doesn't silently drift from the supervised one.
+//!
+//! ```yaml
+//! process_watch:
+//!   enabled: true
+//!   interval: 30s
+//! ```
+//!
+//! Unsupervised processes are only detected on Linux and FreeBSD.
+
+use crate::agent_control::agent_id::AgentID;
+use crate::audit::{AuditEvent, AuditTrail};
+use duration_str::deserialize_duration;
+use serde::Deserialize;
+use std::path::PathBuf;
+use std::time::{Duration, Instant};
+use tracing::warn;
+use wrapper_with_default::WrapperWithDefault;
+
+const DEFAULT_WATCH_INTERVAL: Duration = Duration::from_secs(30);
+/// Signals killing a process on request, rather than because of a failure of the process.
+const TERMINATION_SIGNALS: [&str; 4] = ["SIGTERM", "SIGKILL", "SIGINT", "SIGHUP"];
+
+/// Configuration of the watch of processes started outside Agent Control.
+#[derive(Debug, Deserialize, PartialEq, Clone, Default)]
+pub struct ProcessWatchConfig {
+    /// Terminate the processes running a supervised executable not started by Agent Control.
+    #[serde(default)]
+    pub enabled: bool,
+    /// Interval between checks of the running processes.
+    #[serde(default)]
+    pub interval: ProcessWatchInterval,
+}
+
+/// Interval between checks of the running processes.
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_WATCH_INTERVAL)]
+pub struct ProcessWatchInterval(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// Returns whether a process killed by `signal` was stopped on request.
+pub(crate) fn is_termination_signal(signal: &str) -> bool {
+    TERMINATION_SIGNALS.contains(&signal)
+}
+
+/// Terminates the unsupervised processes running an executable.
+#[derive(Debug)]
+pub(crate) struct ProcessWatcher {
+    agent_id: AgentID,
+    executable: String,
+    config: ProcessWatchConfig,
+    next_check: Instant,
+    audit_trail: AuditTrail,
+}
+
+impl ProcessWatcher {
+    pub(crate) fn new(agent_id: AgentID, executable: &str, config: &ProcessWatchConfig) -> Self {
+        Self {
+            agent_id,
+            executable: executable.to_string(),
+            config: config.clone(),
+            next_check: Instant::now(),
+            audit_trail: AuditTrail::default(),
+        }
+    }
+
+    /// Records the actions on the processes of the executable in `audit_trail`.
+    pub(crate) fn with_audit_trail(self, audit_trail: AuditTrail) -> Self {
+        Self {
+            audit_trail,
+            ..self
+        }
+    }
+
+    /// Audits a supervised process killed by a signal Agent Control didn't send.
+    pub(crate) fn record_external_kill(&self, pid: u32, signal: &str) {
+        warn!(agent_id = %self.agent_id, executable = %self.executable, pid, signal, "Supervised process killed outside Agent Control");
+        self.audit_trail
+            .record(AuditEvent::ProcessKilledExternally {
+                agent_id: self.agent_id.to_string(),
+                executable: self.executable.clone(),
+                pid,
+                signal: signal.to_string(),
+            });
+    }
+
+    /// Re-asserts the supervised process if the check interval elapsed.
+    pub(crate) fn check(&mut self) {
+        if Instant::now() >= self.next_check {
+            self.reassert();
+        }
+    }
+
+    /// Terminates the processes running the executable not started by Agent Control.
+    pub(crate) fn reassert(&mut self) {
+        if !self.config.enabled {
+            return;
+        }
+        self.next_check = Instant::now() + Duration::from(self.config.interval);
+
+        let executable = std::fs::canonicalize(&self.executable)
+            .unwrap_or_else(|_| PathBuf::from(&self.executable));
+        for pid in platform::unsupervised_processes(&executable, std::process::id()) {
+            warn!(
+                agent_id = %self.agent_id,
+                executable = %self.executable,
+                pid,
+                "Terminating a process of the executable not started by Agent Control"
+            );
+            if let Err(err) = platform::terminate(pid) {
+                warn!(agent_id = %self.agent_id, pid, "Could not terminate the process: {err}");
+                continue;
+            }
+            self.audit_trail
+                .record(AuditEvent::UnsupervisedProcessTerminated {
+                    agent_id: self.agent_id.to_string(),
+                    executable: self.executable.clone(),
+                    pid,
+                });
+        }
+    }
+}
+
+#[cfg(target_os = "linux")]
+mod platform {
+    use nix::sys::signal::{self, Signal};
+    use nix::unistd::Pid;
+    use std::io;
+    use std::path::Path;
+
+    const PROC_DIR: &str = "/proc";
+    /// Bounds the walk up the process tree, in case of an inconsistent `/proc` read.
+    const MAX_TREE_DEPTH: usize = 256;
+
+    pub(super) fn unsupervised_processes(executable: &Path, own_pid: u32) -> Vec<u32> {
+        unsupervised_processes_in(Path::new(PROC_DIR), executable, own_pid)
+    }
+
+    pub(super) fn terminate(pid: u32) -> io::Result<()> {
+        signal::kill(Pid::from_raw(pid as i32), Signal::SIGTERM).map_err(io::Error::from)
+    }
+
+    /// Returns the processes running `executable` that don't descend from `own_pid`.
+    pub(super) fn unsupervised_processes_in(
+        proc_dir: &Path,
+        executable: &Path,
+        own_pid: u32,
+    ) -> Vec<u32> {
+        let Ok(entries) = std::fs::read_dir(proc_dir) else {
+            return Vec::new();
+        };
+        let mut pids: Vec<u32> = entries
+            .flatten()
+            .filter_map(|entry| entry.file_name().to_str()?.parse().ok())
+            .filter(|pid| {
+                std::fs::read_link(proc_dir.join(pid.to_string()).join("exe"))
+                    .is_ok_and(|exe| exe == executable)
+            })
+            .filter(|pid| !descends_from(proc_dir, *pid, own_pid))
+            .collect();
+        pids.sort();
+        pids
+    }
+
+    fn descends_from(proc_dir: &Path, pid: u32, ancestor: u32) -> bool {
+        let mut current = pid;
+        for _ in 0..MAX_TREE_DEPTH {
+            if current == ancestor {
+                return true;
+            }
+            match parent_pid(proc_dir, current) {
+                Some(parent) if parent != 0 => current = parent,
+                _ => return false,
+            }
+        }
+        false
+    }
+
+    /// Reads the parent pid from `/proc/<pid>/stat`: `pid (comm) state ppid ...`. The command
+    /// name may contain spaces and parenthesis, so the fields are read after the last `)`.
+    fn parent_pid(proc_dir: &Path, pid: u32) -> Option<u32> {
+        let stat = std::fs::read_to_string(proc_dir.join(pid.to_string()).join("stat")).ok()?;
+        let (_, fields) = stat.rsplit_once(')')?;
+        fields.split_whitespace().nth(1)?.parse().ok()
+    }
+
+    #[cfg(test)]
+    mod tests {
+        use super::*;
+        use std::os::unix::fs::symlink;
+        use tempfile::tempdir;
+
+        fn add_process(proc_dir: &Path, pid: u32, ppid: u32, exe: &Path) {
+            let dir = proc_dir.join(pid.to_string());
+            std::fs::create_dir_all(&dir).unwrap();
+            std::fs::write(dir.join("stat"), format!("{pid} (a (b)) S {ppid} 1 1")).unwrap();
+            symlink(exe, dir.join("exe")).unwrap();
+        }
+
+        #[test]
+        fn test_unsupervised_processes() {
+            let dir = tempdir().unwrap();
+            let proc_dir = dir.path().join("proc");
+            let agent = dir.path().join("agent");
+            let other = dir.path().join("other");
+            std::fs::write(&agent, "").unwrap();
+            std::fs::write(&other, "").unwrap();
+
+            // Agent Control (10), its supervised agent (11) and a fork of it (12).
+            add_process(&proc_dir, 10, 1, &other);
+            add_process(&proc_dir, 11, 10, &agent);
+            add_process(&proc_dir, 12, 11, &agent);
+            // The agent started by someone else, and an unrelated process.
+            add_process(&proc_dir, 20, 1, &agent);
+            add_process(&proc_dir, 21, 1, &other);
+            std::fs::create_dir_all(proc_dir.join("self")).unwrap();
+
+            assert_eq!(unsupervised_processes_in(&proc_dir, &agent, 10), vec![20]);
+        }
+    }
+}
+
+/// FreeBSD usually has no `/proc` mounted, so the process table is read through `sysctl`.
+#[cfg(target_os = "freebsd")]
+mod platform {
+    use nix::libc;
+    use nix::sys::signal::{self, Signal};
+    use nix::unistd::Pid;
+    use std::collections::HashMap;
+    use std::ffi::OsString;
+    use std::io;
+    use std::os::unix::ffi::OsStringExt;
+    use std::path::{Path, PathBuf};
+
+    /// Bounds the walk up the process tree, in case of an inconsistent process table.
+    const MAX_TREE_DEPTH: usize = 256;
+
+    pub(super) fn unsupervised_processes(executable: &Path, own_pid: u32) -> Vec<u32> {
+        let Ok(parents) = process_parents() else {
+            return Vec::new();
+        };
+        unsupervised_processes_in(&parents, executable_path, executable, own_pid)
+    }
+
+    pub(super) fn terminate(pid: u32) -> io::Result<()> {
+        signal::kill(Pid::from_raw(pid as i32), Signal::SIGTERM).map_err(io::Error::from)
+    }
+
+    /// Returns the processes running `executable` that don't descend from `own_pid`, given the
+    /// parent of every process and the way to get their executable.
+    pub(super) fn unsupervised_processes_in(
+        parents: &HashMap<u32, u32>,
+        executable_of: impl Fn(u32) -> Option<PathBuf>,
+        executable: &Path,
+        own_pid: u32,
+    ) -> Vec<u32> {
+        let mut pids: Vec<u32> = parents
+            .keys()
+            .copied()
+            .filter(|pid| executable_of(*pid).is_some_and(|exe| exe == executable))
+            .filter(|pid| !descends_from(parents, *pid, own_pid))
+            .collect();
+        pids.sort();
+        pids
+    }
+
+    fn descends_from(parents: &HashMap<u32, u32>, pid: u32, ancestor: u32) -> bool {
+        let mut current = pid;
+        for _ in 0..MAX_TREE_DEPTH {
+            if current == ancestor {
+                return true;
+            }
+            match parents.get(&current) {
+                Some(&parent) if parent != 0 => current = parent,
+                _ => return false,
+            }
+        }
+        false
+    }
+
+    /// Reads the parent pid of every process from the `kern.proc.proc` sysctl.
+    fn process_parents() -> io::Result<HashMap<u32, u32>> {
+        let table = sysctl(&[libc::CTL_KERN, libc::KERN_PROC, libc::KERN_PROC_PROC])?;
+        Ok(table
+            .chunks_exact(size_of::<libc::kinfo_proc>())
+            .map(|entry| {
+                // SAFETY: the entry has the size of a `kinfo_proc`, read unaligned from the buffer.
+                let info: libc::kinfo_proc =
+                    unsafe { std::ptr::read_unaligned(entry.as_ptr().cast()) };
+                (info.ki_pid as u32, info.ki_ppid as u32)
+            })
+            .collect())
+    }
+
+    /// Reads the executable of the process from the `kern.proc.pathname` sysctl.
+    fn executable_path(pid: u32) -> Option<PathBuf> {
+        let mut path = sysctl(&[
+            libc::CTL_KERN,
+            libc::KERN_PROC,
+            libc::KERN_PROC_PATHNAME,
+            pid as libc::c_int,
+        ])
+        .ok()?;
+        if let Some(end) = path.iter().position(|byte| *byte == 0) {
+            path.truncate(end);
+        }
+        (!path.is_empty()).then(|| PathBuf::from(OsString::from_vec(path)))
+    }
+
+    /// Reads the value of the sysctl `mib`, retrying if it grows between getting its size and
+    /// reading it (new processes being started).
+    fn sysctl(mib: &[libc::c_int]) -> io::Result<Vec<u8>> {
+        loop {
+            let mut len: libc::size_t = 0;
+            // SAFETY: only the size of the value is requested.
+            let result = unsafe {
+                libc::sysctl(
+                    mib.as_ptr(),
+                    mib.len() as libc::c_uint,
+                    std::ptr::null_mut(),
+                    &mut len,
+                    std::ptr::null(),
+                    0,
+                )
+            };
+            if result != 0 {
+                return Err(io::Error::last_os_error());
+            }
+            len += len / 8;
+            let mut value = vec![0u8; len];
+            // SAFETY: the buffer is `len` bytes long, and the sysctl writes at most `len` bytes.
+            let result = unsafe {
+                libc::sysctl(
+                    mib.as_ptr(),
+                    mib.len() as libc::c_uint,
+                    value.as_mut_ptr().cast(),
+                    &mut len,
+                    std::ptr::null(),
+                    0,
+                )
+            };
+            if result == 0 {
+                value.truncate(len);
+                return Ok(value);
+            }
+            let err = io::Error::last_os_error();
+            if err.raw_os_error() != Some(libc::ENOMEM) {
+                return Err(err);
+            }
+        }
+    }
+
+    #[cfg(test)]
+    mod tests {
+        use super::*;
+
+        #[test]
+        fn test_unsupervised_processes() {
+            let agent = PathBuf::from("/usr/local/bin/agent");
+            let other = PathBuf::from("/usr/local/bin/other");
+            // Agent Control (10), its supervised agent (11) and a fork of it (12).
+            // The agent started by someone else (20), and an unrelated process (21).
+            let processes = HashMap::from([
+                (10, (1, other.clone())),
+                (11, (10, agent.clone())),
+                (12, (11, agent.clone())),
+                (20, (1, agent.clone())),
+                (21, (1, other.clone())),
+            ]);
+            let parents = processes
+                .iter()
+                .map(|(pid, (ppid, _))| (*pid, *ppid))
+                .collect();
+            let executable_of = |pid| processes.get(&pid).map(|(_, exe)| exe.clone());
+
+            assert_eq!(
+                unsupervised_processes_in(&parents, executable_of, &agent, 10),
+                vec![20]
+            );
+        }
+
+        #[test]
+        fn test_process_table() {
+            let own_pid = std::process::id();
+            assert!(process_parents().unwrap().contains_key(&own_pid));
+            assert_eq!(executable_path(own_pid), std::env::current_exe().ok());
+        }
+    }
+}
+
+#[cfg(not(any(target_os = "linux", target_os = "freebsd")))]
+mod platform {
+    use std::io;
+    use std::path::Path;
+
+    pub(super) fn unsupervised_processes(_executable: &Path, _own_pid: u32) -> Vec<u32> {
+        Vec::new()
+    }
+
+    pub(super) fn terminate(_pid: u32) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_termination_signals() {
+        assert!(is_termination_signal("SIGKILL"));
+        assert!(is_termination_signal("SIGTERM"));
+        assert!(!is_termination_signal("SIGSEGV"));
+        assert!(!is_termination_signal("SIGABRT"));
+    }
+
+    #[test]
+    fn test_disabled_watcher_does_nothing() {
+        let mut watcher = ProcessWatcher::new(
+            AgentID::try_from("agent").unwrap(),
+            "/bin/sh",
+            &ProcessWatchConfig::default(),
+        );
+        let next_check = watcher.next_check;
+        watcher.check();
+        assert_eq!(watcher.next_check, next_check);
+    }
+}
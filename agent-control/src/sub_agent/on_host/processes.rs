@@ -0,0 +1,203 @@
+//! Registry of the processes running for the on-host sub-agents, used to deliver signals to them
+//! (e.g. `SIGHUP` to reload their configuration or `SIGUSR1` to dump debug information) without
+//! restarting them, and to find the binaries they run.
+
+use crate::agent_control::agent_id::AgentID;
+use std::collections::{BTreeMap, HashMap};
+use std::sync::{Arc, Mutex};
+use thiserror::Error;
+
+/// Errors delivering a signal to the processes of a sub-agent.
+#[derive(Debug, Error, PartialEq)]
+pub enum SignalError {
+    /// The signal name is not valid.
+    #[error("unknown signal '{0}'")]
+    UnknownSignal(String),
+    /// The sub-agent has no running process.
+    #[error("agent '{0}' has no running processes")]
+    NoProcesses(String),
+    /// The signal could not be delivered to a process.
+    #[error("sending {signal} to process {pid}: {err}")]
+    Delivery {
+        /// Name of the signal.
+        signal: String,
+        /// Process the signal couldn't be delivered to.
+        pid: u32,
+        /// The underlying error message.
+        err: String,
+    },
+}
+
+/// Processes running for each sub-agent, by executable id. Clones share the same registry.
+#[derive(Debug, Clone, Default)]
+pub struct SupervisedProcesses(Arc<Mutex<HashMap<AgentID, BTreeMap<String, SupervisedProcess>>>>);
+
+/// A process running an executable of a sub-agent.
+#[derive(Debug, Clone, PartialEq)]
+struct SupervisedProcess {
+    pid: u32,
+    path: String,
+}
+
+impl SupervisedProcesses {
+    /// Registers the process running the executable `exec_id` of the agent, whose binary is `path`.
+    pub(crate) fn register(&self, agent_id: &AgentID, exec_id: &str, pid: u32, path: &str) {
+        let mut processes = self.0.lock().unwrap_or_else(|err| err.into_inner());
+        processes.entry(agent_id.clone()).or_default().insert(
+            exec_id.to_string(),
+            SupervisedProcess {
+                pid,
+                path: path.to_string(),
+            },
+        );
+    }
+
+    /// Removes the process of the executable `exec_id` of the agent, if it is still `pid`.
+    pub(crate) fn unregister(&self, agent_id: &AgentID, exec_id: &str, pid: u32) {
+        let mut processes = self.0.lock().unwrap_or_else(|err| err.into_inner());
+        if let Some(executables) = processes.get_mut(agent_id) {
+            if executables
+                .get(exec_id)
+                .is_some_and(|process| process.pid == pid)
+            {
+                executables.remove(exec_id);
+            }
+            if executables.is_empty() {
+                processes.remove(agent_id);
+            }
+        }
+    }
+
+    /// Returns the pids of the processes running for the agent, ordered by executable id.
+    pub fn pids(&self, agent_id: &AgentID) -> Vec<u32> {
+        let processes = self.0.lock().unwrap_or_else(|err| err.into_inner());
+        processes
+            .get(agent_id)
+            .map(|executables| executables.values().map(|process| process.pid).collect())
+            .unwrap_or_default()
+    }
+
+    /// Returns the binaries run by the processes of the agent, ordered by executable id.
+    pub fn paths(&self, agent_id: &AgentID) -> Vec<String> {
+        let processes = self.0.lock().unwrap_or_else(|err| err.into_inner());
+        processes
+            .get(agent_id)
+            .map(|executables| {
+                executables
+                    .values()
+                    .map(|process| process.path.clone())
+                    .collect()
+            })
+            .unwrap_or_default()
+    }
+
+    /// Delivers the signal named `signal` (like `SIGHUP` or `HUP`) to every process of the agent,
+    /// returning their pids.
+    #[cfg(target_family = "unix")]
+    pub fn signal(&self, agent_id: &AgentID, signal: &str) -> Result<Vec<u32>, SignalError> {
+        let signal = parse_signal(signal)?;
+        let pids = self.pids(agent_id);
+        if pids.is_empty() {
+            return Err(SignalError::NoProcesses(agent_id.to_string()));
+        }
+        for pid in pids.iter() {
+            send_signal(*pid, signal)?;
+        }
+        Ok(pids)
+    }
+}
+
+/// Parses a signal name, with or without the `SIG` prefix and in any case.
+#[cfg(target_family = "unix")]
+pub fn parse_signal(name: &str) -> Result<nix::sys::signal::Signal, SignalError> {
+    use std::str::FromStr;
+
+    let upper = name.trim().to_ascii_uppercase();
+    let full_name = if upper.starts_with("SIG") {
+        upper
+    } else {
+        format!("SIG{upper}")
+    };
+    nix::sys::signal::Signal::from_str(&full_name)
+        .map_err(|_| SignalError::UnknownSignal(name.to_string()))
+}
+
+/// Delivers `signal` to the process `pid`.
+#[cfg(target_family = "unix")]
+pub(crate) fn send_signal(pid: u32, signal: nix::sys::signal::Signal) -> Result<(), SignalError> {
+    use nix::unistd::Pid;
+
+    tracing::info!(pid, %signal, "Delivering signal to supervised process");
+    nix::sys::signal::kill(Pid::from_raw(pid as i32), signal).map_err(|err| SignalError::Delivery {
+        signal: signal.to_string(),
+        pid,
+        err: err.to_string(),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_register_and_unregister() {
+        let processes = SupervisedProcesses::default();
+        let agent_id = AgentID::try_from("agent").unwrap();
+
+        processes.clone().register(&agent_id, "b", 2, "/bin/b");
+        processes.register(&agent_id, "a", 1, "/bin/a");
+        assert_eq!(processes.pids(&agent_id), vec![1, 2]);
+        assert_eq!(processes.paths(&agent_id), vec!["/bin/a", "/bin/b"]);
+
+        // A restarted executable replaces its previous process, which is not unregistered anymore.
+        processes.register(&agent_id, "a", 3, "/bin/a");
+        processes.unregister(&agent_id, "a", 1);
+        assert_eq!(processes.pids(&agent_id), vec![3, 2]);
+
+        processes.unregister(&agent_id, "a", 3);
+        processes.unregister(&agent_id, "b", 2);
+        assert!(processes.pids(&agent_id).is_empty());
+    }
+
+    #[cfg(target_family = "unix")]
+    #[test]
+    fn test_parse_signal() {
+        use nix::sys::signal::Signal;
+
+        assert_eq!(parse_signal("SIGHUP"), Ok(Signal::SIGHUP));
+        assert_eq!(parse_signal("usr1"), Ok(Signal::SIGUSR1));
+        assert_eq!(
+            parse_signal("SIGNOPE"),
+            Err(SignalError::UnknownSignal("SIGNOPE".to_string()))
+        );
+    }
+
+    #[cfg(target_family = "unix")]
+    #[test]
+    fn test_signal_without_processes() {
+        let agent_id = AgentID::try_from("agent").unwrap();
+        assert_eq!(
+            SupervisedProcesses::default().signal(&agent_id, "HUP"),
+            Err(SignalError::NoProcesses("agent".to_string()))
+        );
+    }
+
+    #[cfg(target_family = "unix")]
+    #[test]
+    fn test_signal_running_process() {
+        use std::os::unix::process::ExitStatusExt;
+        use std::process::Command;
+
+        let mut child = Command::new("sleep").arg("10").spawn().unwrap();
+        let agent_id = AgentID::try_from("agent").unwrap();
+        let processes = SupervisedProcesses::default();
+        processes.register(&agent_id, "sleep", child.id(), "sleep");
+
+        assert_eq!(processes.signal(&agent_id, "USR1"), Ok(vec![child.id()]));
+        // `sleep` doesn't handle SIGUSR1, so it is killed by it.
+        assert_eq!(
+            child.wait().unwrap().signal(),
+            Some(nix::sys::signal::Signal::SIGUSR1 as i32)
+        );
+    }
+}
@@ -0,0 +1,175 @@
+//! Protection against restart storms of the on-host executables.
+//!
+//! When many executables fail at once (e.g. after the host resumes from suspension), restarting
+//! them all at the same instant hits the host and the Fleet Control endpoint with a thundering
+//! herd. Every restart waits for a random `jitter`, up to the configured one, and for a free slot:
+//! at most `max_concurrent_restarts` executables are restarting at the same time, across all
+//! agents. A restart is in progress until the restarted executable runs long enough to be
+//! considered healthy, or exits.
+//!
+//! ```yaml
+//! restart_storm_protection:
+//!   max_concurrent_restarts: 4 # Zero doesn't limit them.
+//!   jitter: 5s
+//! ```
+
+use crate::event::cancellation::CancellationMessage;
+use crate::event::channel::EventConsumer;
+use crate::utils::retry::full_jitter;
+use duration_str::deserialize_duration;
+use serde::Deserialize;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+use tracing::debug;
+use wrapper_with_default::WrapperWithDefault;
+
+const DEFAULT_MAX_CONCURRENT_RESTARTS: usize = 4;
+const DEFAULT_RESTART_JITTER: Duration = Duration::ZERO;
+/// Interval between checks for a free restart slot.
+const SLOT_CHECK_INTERVAL: Duration = Duration::from_millis(500);
+
+/// Configuration of the protection against restart storms.
+#[derive(Debug, Deserialize, PartialEq, Clone, Default)]
+pub struct RestartStormConfig {
+    /// Maximum number of executables restarting at the same time. Zero doesn't limit them.
+    #[serde(default)]
+    pub max_concurrent_restarts: MaxConcurrentRestarts,
+    /// Maximum random delay added to every restart.
+    #[serde(default)]
+    pub jitter: RestartJitter,
+}
+
+/// Maximum number of executables restarting at the same time (defaults to 4).
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_MAX_CONCURRENT_RESTARTS)]
+pub struct MaxConcurrentRestarts(usize);
+
+/// Maximum random delay added to every restart (defaults to 0s).
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_RESTART_JITTER)]
+pub struct RestartJitter(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// Limits the restarts of the executables of every agent. Clones share the same restart slots.
+#[derive(Debug, Clone, Default)]
+pub struct RestartLimiter {
+    config: RestartStormConfig,
+    in_progress: Arc<Mutex<usize>>,
+}
+
+impl RestartLimiter {
+    /// Returns a limiter applying `config`.
+    pub fn new(config: RestartStormConfig) -> Self {
+        Self {
+            config,
+            in_progress: Arc::default(),
+        }
+    }
+
+    /// Waits for the jitter and a free restart slot, returning the permit holding the slot, or
+    /// `None` if the supervisor is stopped meanwhile.
+    pub(crate) fn acquire(
+        &self,
+        stop_consumer: &EventConsumer<CancellationMessage>,
+    ) -> Option<RestartPermit> {
+        let jitter = full_jitter(self.config.jitter.into());
+        if !jitter.is_zero() {
+            debug!("Delaying restart {jitter:?}");
+            if stop_consumer.is_cancelled_with_timeout(jitter) {
+                return None;
+            }
+        }
+
+        let mut waiting = false;
+        loop {
+            if let Some(permit) = self.try_acquire() {
+                return Some(permit);
+            }
+            if !waiting {
+                debug!("Too many executables restarting, waiting for a restart slot");
+                waiting = true;
+            }
+            if stop_consumer.is_cancelled_with_timeout(SLOT_CHECK_INTERVAL) {
+                return None;
+            }
+        }
+    }
+
+    fn try_acquire(&self) -> Option<RestartPermit> {
+        let max_concurrent_restarts: usize = self.config.max_concurrent_restarts.into();
+        let mut in_progress = self
+            .in_progress
+            .lock()
+            .unwrap_or_else(|err| err.into_inner());
+        if max_concurrent_restarts != 0 && *in_progress >= max_concurrent_restarts {
+            return None;
+        }
+        *in_progress += 1;
+        Some(RestartPermit(self.in_progress.clone()))
+    }
+}
+
+/// Slot of a restart in progress, released when dropped.
+#[derive(Debug)]
+pub(crate) struct RestartPermit(Arc<Mutex<usize>>);
+
+impl Drop for RestartPermit {
+    fn drop(&mut self) {
+        let mut in_progress = self.0.lock().unwrap_or_else(|err| err.into_inner());
+        *in_progress = in_progress.saturating_sub(1);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::event::channel::pub_sub;
+    use std::time::Instant;
+
+    #[test]
+    fn test_restart_slots_are_limited() {
+        let limiter =
+            RestartLimiter::new(serde_saphyr::from_str("max_concurrent_restarts: 2").unwrap());
+        let shared = limiter.clone();
+
+        let first = limiter.try_acquire().unwrap();
+        let _second = shared.try_acquire().unwrap();
+        assert!(limiter.try_acquire().is_none());
+
+        drop(first);
+        assert!(shared.try_acquire().is_some());
+    }
+
+    #[test]
+    fn test_unlimited_restart_slots() {
+        let limiter =
+            RestartLimiter::new(serde_saphyr::from_str("max_concurrent_restarts: 0").unwrap());
+        let permits = (0..10).map(|_| limiter.try_acquire()).collect::<Vec<_>>();
+        assert!(permits.iter().all(Option::is_some));
+    }
+
+    #[test]
+    fn test_acquire_is_cancelled() {
+        let limiter =
+            RestartLimiter::new(serde_saphyr::from_str("max_concurrent_restarts: 1").unwrap());
+        let _permit = limiter.try_acquire().unwrap();
+
+        let (stop_publisher, stop_consumer) = pub_sub::<CancellationMessage>();
+        stop_publisher.publish(()).unwrap();
+        let start = Instant::now();
+        assert!(limiter.acquire(&stop_consumer).is_none());
+        assert!(start.elapsed() < Duration::from_secs(5));
+    }
+
+    #[test]
+    fn test_restart_storm_config() {
+        let config: RestartStormConfig = serde_saphyr::from_str("{}").unwrap();
+        assert_eq!(
+            usize::from(config.max_concurrent_restarts),
+            DEFAULT_MAX_CONCURRENT_RESTARTS
+        );
+        assert_eq!(Duration::from(config.jitter), Duration::ZERO);
+
+        let config: RestartStormConfig = serde_saphyr::from_str("jitter: 5s").unwrap();
+        assert_eq!(Duration::from(config.jitter), Duration::from_secs(5));
+    }
+}
@@ -0,0 +1,56 @@
+//! Requests to restart the executables of an on-host supervisor, made by its health checker when
+//! the health probes keep failing.
+
+use std::sync::Arc;
+use std::sync::atomic::{AtomicU64, Ordering};
+
+/// Counter of the restarts requested for the executables of a supervisor. Clones share the same
+/// counter.
+#[derive(Debug, Clone, Default)]
+pub struct RestartRequests(Arc<AtomicU64>);
+
+impl RestartRequests {
+    /// Requests every running executable to be restarted.
+    pub(crate) fn request(&self) {
+        self.0.fetch_add(1, Ordering::SeqCst);
+    }
+
+    /// Returns a watch of the restarts requested from now on, taken before starting a process.
+    pub(crate) fn watch(&self) -> RestartWatch {
+        RestartWatch {
+            requests: self.clone(),
+            seen: self.0.load(Ordering::SeqCst),
+        }
+    }
+}
+
+/// Tells whether a restart was requested since it was taken.
+#[derive(Debug)]
+pub(crate) struct RestartWatch {
+    requests: RestartRequests,
+    seen: u64,
+}
+
+impl RestartWatch {
+    pub(crate) fn is_requested(&self) -> bool {
+        self.requests.0.load(Ordering::SeqCst) != self.seen
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_watch_sees_later_requests_only() {
+        let requests = RestartRequests::default();
+        requests.request();
+
+        let watch = requests.watch();
+        assert!(!watch.is_requested());
+
+        requests.clone().request();
+        assert!(watch.is_requested());
+        assert!(!requests.watch().is_requested());
+    }
+}
@@ -2,30 +2,48 @@
 //! and version checks for a single sub-agent.
 
 use crate::agent_control::agent_id::AgentID;
-use crate::agent_control::defaults::OPAMP_AGENT_VERSION_ATTRIBUTE_KEY;
+use crate::agent_control::defaults::{
+    CRASH_REPORTS_DIR_NAME, INTEGRATIONS_ATTRIBUTE_KEY, OPAMP_AGENT_VERSION_ATTRIBUTE_KEY,
+};
 use crate::agent_type::runtime_config::health_config::rendered::OnHostHealthConfig;
 use crate::agent_type::runtime_config::on_host::filesystem::rendered::{
     FileSystem, FileSystemEntriesError,
 };
 use crate::agent_type::runtime_config::on_host::rendered::RenderedPackages;
+use crate::audit::AuditTrail;
 use crate::checkers::health::health_checker::{Health, HealthCheckerError, spawn_health_checker};
 use crate::checkers::health::health_checker::{Healthy, Unhealthy};
 use crate::checkers::health::on_host::health_checker::OnHostHealthCheckers;
 use crate::checkers::health::with_start_time::{HealthWithStartTime, StartTime};
-use crate::event::SubAgentInternalEvent;
 use crate::event::cancellation::CancellationMessage;
 use crate::event::channel::{EventConsumer, EventPublisher, pub_sub};
+use crate::event::{AgentControlInternalEvent, SubAgentInternalEvent};
 use crate::http::client::HttpClient;
 use crate::http::config::{HttpConfig, ProxyConfig};
+use crate::lifecycle_hooks::{LifecycleEvent, LifecycleHooks};
 use crate::opamp::attributes::publish_update_attributes_event;
-use crate::package::manager::{PackageData, PackageManager};
+use crate::package::integrity::{IntegrityError, IntegrityManifest};
+use crate::package::manager::{InstalledPackageData, PackageData, PackageManager};
 use crate::sub_agent::effective_agents_assembler::{EffectiveAgent, EffectiveAgentsAssemblerError};
 use crate::sub_agent::identity::AgentIdentity;
+use crate::sub_agent::on_host::command::allow_list::ExecutableAllowList;
 use crate::sub_agent::on_host::command::command_os::{CommandOSNotStarted, CommandOSStarted};
 use crate::sub_agent::on_host::command::error::CommandError;
 use crate::sub_agent::on_host::command::executable_data::ExecutableData;
 use crate::sub_agent::on_host::command::restart_policy::RestartPolicy;
+use crate::sub_agent::on_host::command::socket_activation::ActivationSockets;
+use crate::sub_agent::on_host::crash::{CrashCollector, CrashReport, CrashReportsConfig};
+use crate::sub_agent::on_host::integrations::enabled_integrations;
+use crate::sub_agent::on_host::process_watch::{
+    ProcessWatchConfig, ProcessWatcher, is_termination_signal,
+};
+#[cfg(target_family = "unix")]
+use crate::sub_agent::on_host::processes::SignalError;
+use crate::sub_agent::on_host::processes::SupervisedProcesses;
+use crate::sub_agent::on_host::restart_limiter::{RestartLimiter, RestartPermit};
+use crate::sub_agent::on_host::restart_requests::{RestartRequests, RestartWatch};
 use crate::sub_agent::supervisor::{Supervisor, SupervisorStarter};
+use crate::utils::error_kind::{ClassifiedError, ErrorKind};
 use crate::utils::thread_context::{
     NotStartedThreadContext, StartedThreadContext, ThreadContextStopperError,
 };
@@ -33,15 +51,17 @@ use fs::directory_manager::DirectoryManagerFs;
 use fs::file::LocalFile;
 use opamp_client::operation::settings::AgentDescription;
 use std::collections::HashMap;
-use std::path::PathBuf;
+use std::path::{Path, PathBuf};
 use std::process::ExitStatus;
 use std::sync::Arc;
 use std::time::{Duration, Instant, SystemTime};
 use thiserror::Error;
-use tracing::{Dispatch, debug, dispatcher, error, info, warn};
+use tracing::{Dispatch, debug, dispatcher, error, info, info_span, instrument, trace, warn};
 
 const WAIT_FOR_EXIT_TIMEOUT: Duration = Duration::from_secs(1);
 const HEALTHY_DELAY: Duration = Duration::from_secs(10);
+/// Health status of the executables disabled for exceeding their failure window.
+const DISABLED_STATUS: &str = "disabled";
 
 /// Errors produced while starting, applying, or stopping an on-host supervisor.
 #[derive(Debug, thiserror::Error)]
@@ -64,6 +84,24 @@ pub enum SupervisorError {
     /// The supervisor threads could not be stopped.
     #[error("failure stopping supervisor: {0}")]
     Stop(ThreadContextStopperError),
+    /// The executable path is not in the allow-list, it won't be started.
+    #[error("executable '{0}' is not in the allowed executables")]
+    ExecutableNotAllowed(String),
+    /// An executable from a package doesn't match the file installed, it won't be started.
+    #[error("integrity check of executable failed: {0}")]
+    Integrity(IntegrityError),
+}
+
+impl ClassifiedError for SupervisorError {
+    fn kind(&self) -> ErrorKind {
+        match self {
+            // Package installation mostly fails downloading the package
+            Self::InstallPackage(_) | Self::Install(_) => ErrorKind::Transient,
+            Self::HealthError(err) => err.kind(),
+            Self::RuntimeConfig(_) | Self::ExecutableNotAllowed(_) => ErrorKind::Misconfiguration,
+            Self::FileSystem(_) | Self::Stop(_) | Self::Integrity(_) => ErrorKind::Fatal,
+        }
+    }
 }
 
 /// Error describing the failure to install a specific package.
@@ -91,6 +129,24 @@ where
     pub logging_path: PathBuf,
     /// The agent's filesystem.
     pub filesystem: FileSystem,
+    /// Executables the supervisor is allowed to run.
+    pub allowed_executables: ExecutableAllowList,
+    /// Crash reports configuration.
+    pub crash_reports: CrashReportsConfig,
+    /// Configuration of the watch of processes started outside Agent Control.
+    pub process_watch: ProcessWatchConfig,
+    /// Registry of the running processes, to deliver signals to them.
+    pub supervised_processes: SupervisedProcesses,
+    /// Limiter of the restarts of the executables, shared with every supervisor.
+    pub restart_limiter: RestartLimiter,
+    /// Listening sockets inherited by the executables, kept open across restarts.
+    pub activation_sockets: ActivationSockets,
+    /// Hooks notified of the executables in a crash loop.
+    pub lifecycle_hooks: LifecycleHooks,
+    /// Records the processes stopped or started outside Agent Control.
+    pub audit_trail: AuditTrail,
+    /// Notified of the executables exhausting their restart policy.
+    pub crash_loop_publisher: Option<EventPublisher<AgentControlInternalEvent>>,
 }
 
 /// An on-host supervisor ready to be started.
@@ -106,6 +162,15 @@ where
     package_manager: Arc<PM>,
     packages_config: RenderedPackages,
     filesystem: FileSystem,
+    allowed_executables: ExecutableAllowList,
+    crash_reports: CrashReportsConfig,
+    process_watch: ProcessWatchConfig,
+    supervised_processes: SupervisedProcesses,
+    restart_limiter: RestartLimiter,
+    activation_sockets: ActivationSockets,
+    lifecycle_hooks: LifecycleHooks,
+    audit_trail: AuditTrail,
+    crash_loop_publisher: Option<EventPublisher<AgentControlInternalEvent>>,
 }
 
 impl<PM> SupervisorStarter for NotStartedSupervisorOnHost<PM>
@@ -124,12 +189,14 @@ where
         // package download so version reporting does not depend on the download finishing.
         self.check_subagent_version(sub_agent_internal_publisher.clone());
 
-        install_packages(
+        self.check_allowed_executables()?;
+        let installed_packages = install_packages(
             &self.package_manager,
             &self.agent_identity.id,
             &self.packages_config,
         )
         .map_err(SupervisorError::Install)?;
+        verify_executables_integrity(&self.executables, &installed_packages)?;
 
         self.spin_up(sub_agent_internal_publisher)
     }
@@ -155,6 +222,15 @@ where
             internal_publisher,
             thread_contexts,
             logging_path,
+            allowed_executables,
+            crash_reports,
+            process_watch,
+            supervised_processes,
+            restart_limiter,
+            activation_sockets,
+            lifecycle_hooks,
+            audit_trail,
+            crash_loop_publisher,
             ..
         } = self;
 
@@ -171,9 +247,9 @@ where
             SupervisorError::Stop(err)
         })?;
 
-        installation_result.map_err(SupervisorError::Install)?;
+        let installed_packages = installation_result.map_err(SupervisorError::Install)?;
 
-        let executables = onhost_config
+        let executables: Vec<ExecutableData> = onhost_config
             .executables
             .into_iter()
             .map(|e| {
@@ -181,8 +257,14 @@ where
                     .with_args(e.args.0)
                     .with_env(e.env.0)
                     .with_restart_policy(e.restart_policy.into())
+                    .with_scheduling(e.scheduling)
+                    .with_listen_sockets(e.listen_sockets)
+                    .with_shutdown_timeout(e.shutdown_timeout.into())
             })
             .collect();
+        verify_executables_integrity(&executables, &installed_packages)?;
+        // Sockets still declared are handed over to the new processes without being closed.
+        activation_sockets.retain(&executables);
 
         let starter = NotStartedSupervisorOnHost::new(
             agent_identity,
@@ -193,7 +275,17 @@ where
             onhost_config.enable_file_logging,
             logging_path,
             onhost_config.filesystem,
-        );
+        )
+        .with_allowed_executables(allowed_executables)
+        .with_crash_reports(crash_reports)
+        .with_process_watch(process_watch)
+        .with_supervised_processes(supervised_processes)
+        .with_restart_limiter(restart_limiter)
+        .with_activation_sockets(activation_sockets)
+        .with_lifecycle_hooks(lifecycle_hooks)
+        .with_audit_trail(audit_trail)
+        .with_crash_loop_publisher(crash_loop_publisher);
+        starter.check_allowed_executables()?;
 
         // No explicit file deletion is needed on apply: spin_up reconciles the filesystem. Its
         // `write` removes any path AC owned under the previous config but no longer declares, and
@@ -212,6 +304,19 @@ where
     }
 }
 
+impl<PM> StartedSupervisorOnHost<PM>
+where
+    PM: PackageManager,
+{
+    /// Delivers the signal named `signal` to the running processes of the agent without
+    /// restarting them, returning their pids.
+    #[cfg(target_family = "unix")]
+    pub fn signal(&self, signal: &str) -> Result<Vec<u32>, SignalError> {
+        self.supervised_processes
+            .signal(&self.agent_identity.id, signal)
+    }
+}
+
 impl<PM> NotStartedSupervisorOnHost<PM>
 where
     PM: PackageManager,
@@ -238,6 +343,109 @@ where
             package_manager,
             packages_config,
             filesystem,
+            allowed_executables: ExecutableAllowList::default(),
+            crash_reports: CrashReportsConfig::default(),
+            process_watch: ProcessWatchConfig::default(),
+            supervised_processes: SupervisedProcesses::default(),
+            restart_limiter: RestartLimiter::default(),
+            activation_sockets: ActivationSockets::default(),
+            lifecycle_hooks: LifecycleHooks::default(),
+            audit_trail: AuditTrail::default(),
+            crash_loop_publisher: None,
+        }
+    }
+
+    /// Returns the supervisor restricted to run the executables in `allowed_executables`.
+    pub fn with_allowed_executables(self, allowed_executables: ExecutableAllowList) -> Self {
+        Self {
+            allowed_executables,
+            ..self
+        }
+    }
+
+    /// Returns the supervisor reporting the crashes of its executables as configured.
+    pub fn with_crash_reports(self, crash_reports: CrashReportsConfig) -> Self {
+        Self {
+            crash_reports,
+            ..self
+        }
+    }
+
+    /// Returns the supervisor watching for processes of its executables started outside Agent
+    /// Control as configured.
+    pub fn with_process_watch(self, process_watch: ProcessWatchConfig) -> Self {
+        Self {
+            process_watch,
+            ..self
+        }
+    }
+
+    /// Returns the supervisor registering its running processes in `supervised_processes`.
+    pub fn with_supervised_processes(self, supervised_processes: SupervisedProcesses) -> Self {
+        Self {
+            supervised_processes,
+            ..self
+        }
+    }
+
+    /// Returns the supervisor limiting the restarts of its executables with `restart_limiter`.
+    pub fn with_restart_limiter(self, restart_limiter: RestartLimiter) -> Self {
+        Self {
+            restart_limiter,
+            ..self
+        }
+    }
+
+    /// Returns the supervisor handing the listening sockets in `activation_sockets` to its
+    /// executables.
+    pub fn with_activation_sockets(self, activation_sockets: ActivationSockets) -> Self {
+        Self {
+            activation_sockets,
+            ..self
+        }
+    }
+
+    /// Returns the supervisor notifying `lifecycle_hooks` when its executables won't be restarted
+    /// anymore.
+    pub fn with_lifecycle_hooks(self, lifecycle_hooks: LifecycleHooks) -> Self {
+        Self {
+            lifecycle_hooks,
+            ..self
+        }
+    }
+
+    /// Returns the supervisor recording in `audit_trail` its processes stopped or started outside
+    /// Agent Control.
+    pub fn with_audit_trail(self, audit_trail: AuditTrail) -> Self {
+        Self {
+            audit_trail,
+            ..self
+        }
+    }
+
+    /// Returns the supervisor publishing to `crash_loop_publisher`, if any, the executables that
+    /// exhaust their restart policy, so Agent Control exits.
+    pub fn with_crash_loop_publisher(
+        self,
+        crash_loop_publisher: Option<EventPublisher<AgentControlInternalEvent>>,
+    ) -> Self {
+        Self {
+            crash_loop_publisher,
+            ..self
+        }
+    }
+
+    /// Fails if any of the executables is not in the allow-list.
+    fn check_allowed_executables(&self) -> Result<(), SupervisorError> {
+        match self
+            .executables
+            .iter()
+            .find(|e| !self.allowed_executables.allows(Path::new(&e.bin)))
+        {
+            Some(executable) => Err(SupervisorError::ExecutableNotAllowed(
+                executable.bin.clone(),
+            )),
+            None => Ok(()),
         }
     }
 
@@ -245,6 +453,7 @@ where
         &self,
         sub_agent_internal_publisher: EventPublisher<SubAgentInternalEvent>,
         health_consumer: EventConsumer<(String, HealthWithStartTime)>,
+        restart_requests: RestartRequests,
     ) -> Result<Option<StartedThreadContext>, SupervisorError> {
         let start_time = StartTime::now();
         let client_timeout = Duration::from(self.health_config.clone().timeout);
@@ -257,8 +466,11 @@ where
             health_consumer,
             http_client,
             self.health_config.check.clone(),
+            self.health_config.data_flow.clone(),
+            client_timeout,
             start_time,
-        )?;
+        )?
+        .with_restart(self.health_config.restart_after_failures, restart_requests);
 
         let started_thread_context = spawn_health_checker(
             self.agent_identity.id.clone(),
@@ -318,11 +530,35 @@ where
         );
     }
 
+    /// Publishes the integrations enabled in the `integrations.d` directory of the agent, if it
+    /// has one, so the inventory of each host can be displayed.
+    fn report_integrations(
+        &self,
+        sub_agent_internal_publisher: &EventPublisher<SubAgentInternalEvent>,
+    ) {
+        let Some(integrations) = enabled_integrations(&self.filesystem) else {
+            return;
+        };
+        debug!(?integrations, "Reporting enabled integrations");
+
+        publish_update_attributes_event(
+            sub_agent_internal_publisher,
+            SubAgentInternalEvent::AgentAttributesUpdated(AgentDescription {
+                non_identifying_attributes: HashMap::from([(
+                    INTEGRATIONS_ATTRIBUTE_KEY.to_string(),
+                    integrations.join(",").into(),
+                )]),
+                ..Default::default()
+            }),
+        );
+    }
+
     fn spin_up(
         self,
         sub_agent_internal_publisher: EventPublisher<SubAgentInternalEvent>,
     ) -> Result<StartedSupervisorOnHost<PM>, SupervisorError> {
         let (health_publisher, health_consumer) = pub_sub();
+        let restart_requests = RestartRequests::default();
 
         // Ensure all ephemeral entries are removed before start in case it didn't work on stop.
         if let Err(err) = self.filesystem.delete_ephemeral() {
@@ -332,18 +568,21 @@ where
         self.filesystem
             .write(&LocalFile, &DirectoryManagerFs)
             .map_err(SupervisorError::FileSystem)?;
+        self.report_integrations(&sub_agent_internal_publisher);
 
-        let executable_thread_contexts = self
-            .executables
-            .iter()
-            .map(|e| self.start_process_thread(e, health_publisher.clone()));
+        let executable_thread_contexts = self.executables.iter().map(|e| {
+            self.start_process_thread(e, health_publisher.clone(), restart_requests.clone())
+        });
 
         self.check_subagent_version(sub_agent_internal_publisher.clone());
 
-        let thread_contexts =
-            [self.start_health_check(sub_agent_internal_publisher.clone(), health_consumer)?]
-                .into_iter()
-                .flatten();
+        let thread_contexts = [self.start_health_check(
+            sub_agent_internal_publisher.clone(),
+            health_consumer,
+            restart_requests,
+        )?]
+        .into_iter()
+        .flatten();
 
         let thread_contexts = executable_thread_contexts
             .into_iter()
@@ -357,6 +596,15 @@ where
             internal_publisher: sub_agent_internal_publisher,
             logging_path: self.file_logging_path,
             filesystem: self.filesystem,
+            allowed_executables: self.allowed_executables,
+            crash_reports: self.crash_reports,
+            process_watch: self.process_watch,
+            supervised_processes: self.supervised_processes,
+            restart_limiter: self.restart_limiter,
+            activation_sockets: self.activation_sockets,
+            lifecycle_hooks: self.lifecycle_hooks,
+            audit_trail: self.audit_trail,
+            crash_loop_publisher: self.crash_loop_publisher,
         })
     }
 
@@ -364,12 +612,25 @@ where
         &self,
         executable_data: &ExecutableData,
         health_publisher: EventPublisher<(String, HealthWithStartTime)>,
+        restart_requests: RestartRequests,
     ) -> StartedThreadContext {
         let mut restart_policy = executable_data.restart_policy.clone();
         let exec_data = executable_data.clone();
         let agent_id = self.agent_identity.id.clone();
         let log_to_file = self.file_logging_enable;
         let logging_path = self.file_logging_path.clone();
+        let crash_collector = CrashCollector::new(
+            logging_path.join(&agent_id).join(CRASH_REPORTS_DIR_NAME),
+            &self.crash_reports,
+        );
+        let mut process_watcher =
+            ProcessWatcher::new(agent_id.clone(), &exec_data.bin, &self.process_watch)
+                .with_audit_trail(self.audit_trail.clone());
+        let supervised_processes = self.supervised_processes.clone();
+        let activation_sockets = self.activation_sockets.clone();
+        let restart_limiter = self.restart_limiter.clone();
+        let lifecycle_hooks = self.lifecycle_hooks.clone();
+        let crash_loop_publisher = self.crash_loop_publisher.clone();
 
         let dispatch = dispatcher::get_default(|d: &Dispatch| d.clone());
         let span = tracing::Span::current();
@@ -381,6 +642,8 @@ where
             let exec_id = exec_data.id.clone();
 
             let mut i = 0;
+            // Held from a restart until the restarted executable is considered healthy or exits.
+            let mut restart_permit: Option<RestartPermit> = None;
             loop {
                 // Check if we need to cancel the process before even getting started.
                 // Otherwise, we would always execute the command at least once. This
@@ -395,6 +658,10 @@ where
                 // Otherwise, the published time won't be updated.
                 let health_handler = HealthHandler::new(exec_id.clone(), health_publisher.clone());
 
+                // An instance started outside Agent Control (e.g. after killing the supervised
+                // one) would run along the one about to be started.
+                process_watcher.reassert();
+
                 info!(%agent_id, %exec_id, "Starting executable");
                 let command = CommandOSNotStarted::new(
                     agent_id.clone(),
@@ -403,24 +670,72 @@ where
                     logging_path.clone(),
                 );
 
-                let started = command.start().and_then(|cmd| cmd.stream());
+                let started_at = Instant::now();
+                let restart_watch = restart_requests.watch();
+                let started = command
+                    .with_scheduling(&exec_data)
+                    .and_then(|cmd| cmd.with_activation_sockets(&exec_data, &activation_sockets))
+                    .and_then(|cmd| cmd.start())
+                    .and_then(|cmd| cmd.stream());
 
                 let executable_result = started.and_then(|cmd| {
-                    wait_exit(
+                    let pid = cmd.get_pid();
+                    let stderr_tail = cmd.stderr_tail();
+                    supervised_processes.register(&agent_id, &exec_id, pid, &exec_data.bin);
+                    let exit = wait_exit(
                         cmd,
                         &stop_consumer,
                         HEALTHY_DELAY,
                         &health_handler,
                         &agent_id,
                         &exec_id,
-                    )
+                        &mut process_watcher,
+                        &restart_watch,
+                        restart_permit.take(),
+                    );
+                    supervised_processes.unregister(&agent_id, &exec_id, pid);
+                    exit.map(|(exit_status, stopped)| {
+                        // Signals sent by the supervisor itself to stop the process aren't crashes.
+                        let crash = stopped
+                            .is_none()
+                            .then(|| {
+                                crash_collector.collect(&exec_data, pid, &exit_status, &stderr_tail)
+                            })
+                            .flatten();
+                        if let Some(crash) =
+                            crash.as_ref().filter(|c| is_termination_signal(&c.signal))
+                        {
+                            process_watcher.record_external_kill(pid, &crash.signal);
+                        }
+                        (exit_status, stopped, crash)
+                    })
                 });
 
                 match executable_result {
-                    Ok((exit_status, was_cancelled)) => {
-                        handle_exit(&agent_id, &exec_data, &exit_status, &health_handler);
-
-                        if was_cancelled {
+                    Ok((_, Some(StopReason::RestartRequested), _)) => {
+                        info!(%agent_id, %exec_id, "Restarting executable after failed health checks");
+                        trace!(
+                            monotonic_counter.process_restarts = 1u64,
+                            %agent_id,
+                            executable = %exec_id,
+                            reason = "health_check"
+                        );
+                        restart_permit = restart_limiter.acquire(&stop_consumer);
+                        if restart_permit.is_none() {
+                            break;
+                        }
+                        continue;
+                    }
+                    Ok((exit_status, stopped, crash)) => {
+                        handle_exit(
+                            &agent_id,
+                            &exec_data,
+                            &exit_status,
+                            crash.as_ref(),
+                            &health_handler,
+                        );
+
+                        if stopped == Some(StopReason::Cancelled) {
                             break;
                         }
                     }
@@ -433,18 +748,61 @@ where
 
                 info!(%agent_id, %exec_id, "Executable not running");
 
+                // Running long enough resets the policy, so a later failure starts the backoff
+                // from scratch.
+                if restart_policy.is_stable(started_at.elapsed()) {
+                    debug!(%agent_id, %exec_id, "Executable ran healthy, resetting the restart policy");
+                    restart_policy.reset();
+                }
+
+                if restart_policy.failure_window_exceeded() {
+                    let failure_window = restart_policy.failure_window();
+                    warn!(%agent_id, %exec_id, "Executable kept failing for longer than {failure_window:?}, disabling it");
+                    lifecycle_hooks.notify(LifecycleEvent::CrashLoop {
+                        agent_id: agent_id.to_string(),
+                        executable: exec_id.to_string(),
+                        reason: format!("kept failing for longer than {failure_window:?}"),
+                    });
+                    health_handler.publish_unhealthy_with_status(
+                        format!(
+                            "Executable disabled after failing for longer than {failure_window:?}, apply a new configuration or restart the agents to enable it again"
+                        ),
+                        DISABLED_STATUS.to_string(),
+                    );
+                    break;
+                }
+
                 if !restart_policy.should_retry() {
                     warn!(%agent_id, %exec_id, "Restart policy exceeded, executable won't restart anymore");
+                    lifecycle_hooks.notify(LifecycleEvent::CrashLoop {
+                        agent_id: agent_id.to_string(),
+                        executable: exec_id.to_string(),
+                        reason: "restart policy exceeded".to_string(),
+                    });
                     debug!(%agent_id, %exec_id, "Restart policy exceeded, marking as unhealthy");
                     health_handler.publish_unhealthy("Restart policy exceeded".to_string());
+                    if let Some(publisher) = &crash_loop_publisher {
+                        let _ = publisher
+                            .publish(AgentControlInternalEvent::SubAgentCrashLooped(
+                                agent_id.clone(),
+                            ))
+                            .inspect_err(|err| {
+                                error!(%agent_id, %exec_id, "Could not report the crash loop: {err}")
+                            });
+                    }
                     break;
                 }
 
                 i += 1;
-                let restart_cancelled = wait_restart(&mut restart_policy, i, &stop_consumer);
+                let restart_cancelled =
+                    wait_restart(&agent_id, &exec_id, &mut restart_policy, i, &stop_consumer);
                 if restart_cancelled {
                     break;
                 }
+                restart_permit = restart_limiter.acquire(&stop_consumer);
+                if restart_permit.is_none() {
+                    break;
+                }
             }
         };
 
@@ -453,14 +811,16 @@ where
 }
 
 /// Helper to install packages when starting a new supervisor or applying new configuration.
+#[instrument(skip_all, name = "install_packages", fields(%agent_id))]
 fn install_packages<PM: PackageManager>(
     package_manager: &Arc<PM>,
     agent_id: &AgentID,
     packages: &RenderedPackages,
-) -> Result<(), InstallPackageError> {
+) -> Result<Vec<InstalledPackageData>, InstallPackageError> {
+    let mut installed_packages = Vec::with_capacity(packages.len());
     for (id, package) in packages {
         debug!(%id, "Installing package");
-        package_manager
+        let installed_package = package_manager
             .install(
                 agent_id,
                 PackageData {
@@ -474,6 +834,38 @@ fn install_packages<PM: PackageManager>(
                 err_msg: err.to_string(),
             })?;
         debug!(%id, "Package successfully installed");
+        installed_packages.push(installed_package);
+    }
+    Ok(installed_packages)
+}
+
+/// Checks that the executables provided by the installed packages are unchanged since they were
+/// installed. Executables outside the packages, and packages installed without an integrity
+/// manifest, are not checked.
+fn verify_executables_integrity(
+    executables: &[ExecutableData],
+    installed_packages: &[InstalledPackageData],
+) -> Result<(), SupervisorError> {
+    for executable in executables {
+        let bin = Path::new(&executable.bin);
+        let Some(package) = installed_packages
+            .iter()
+            .find(|package| bin.starts_with(&package.installation_path))
+        else {
+            continue;
+        };
+        let package_dir = &package.installation_path;
+        let Some(manifest) = IntegrityManifest::load(package_dir).map_err(|err| {
+            SupervisorError::Integrity(IntegrityError::Io(package_dir.display().to_string(), err))
+        })?
+        else {
+            debug!(package_id = %package.id, "Package has no integrity manifest, skipping check");
+            continue;
+        };
+        manifest
+            .verify(package_dir, bin)
+            .map_err(SupervisorError::Integrity)?;
+        debug!(exec_id = %executable.id, "Executable integrity verified");
     }
     Ok(())
 }
@@ -498,7 +890,19 @@ fn stop_supervisor_threads(
     stop_result
 }
 
-/// Waits for the command to complete or be cancelled
+/// Why the supervisor stopped a running executable.
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum StopReason {
+    /// The supervisor is being stopped.
+    Cancelled,
+    /// The health checks failed enough times to request a restart.
+    RestartRequested,
+}
+
+/// Waits for the command to complete, be cancelled or be restarted, returning why the supervisor
+/// stopped it, if it did. The `restart_permit` of the command, if restarted, is released once it
+/// is considered healthy.
+#[allow(clippy::too_many_arguments)]
 fn wait_exit(
     mut command: CommandOSStarted,
     stop_consumer: &EventConsumer<CancellationMessage>,
@@ -506,9 +910,12 @@ fn wait_exit(
     health_handler: &HealthHandler,
     agent_id: &AgentID,
     exec_id: &str,
-) -> Result<(ExitStatus, bool), CommandError> {
+    process_watcher: &mut ProcessWatcher,
+    restart_watch: &RestartWatch,
+    mut restart_permit: Option<RestartPermit>,
+) -> Result<(ExitStatus, Option<StopReason>), CommandError> {
     info!(%agent_id, %exec_id, "Waiting for executable to complete or be cancelled");
-    let mut was_cancelled = false;
+    let mut stopped = None;
     let deadline = Instant::now() + healthy_publish_delay;
     let mut healthy_already_published = false;
 
@@ -522,15 +929,24 @@ fn wait_exit(
                 error!(%agent_id, %exec_id, "Failed to stop executable: {err}");
             }
             info!(%agent_id, %exec_id, "Executable terminated");
-            was_cancelled = true;
+            stopped = Some(StopReason::Cancelled);
+        } else if stopped.is_none() && restart_watch.is_requested() {
+            info!(%agent_id, %exec_id, "Stopping executable to restart it");
+            if let Err(err) = command.shutdown() {
+                error!(%agent_id, %exec_id, "Failed to stop executable: {err}");
+            }
+            stopped = Some(StopReason::RestartRequested);
         }
 
+        process_watcher.check();
+
         // Publish healthy status once after the process has been running
         // for an arbitrary long time without issues.
         if !healthy_already_published && Instant::now() > deadline {
             debug!(%agent_id, %exec_id, "{}", format!("Informing executable as healthy after running for {} seconds", healthy_publish_delay.as_secs()));
             health_handler.publish_healthy();
             healthy_already_published = true;
+            drop(restart_permit.take());
         }
     }
 
@@ -545,20 +961,28 @@ fn wait_exit(
                 health_handler.publish_healthy();
             }
         })
-        .map(|exit_status| (exit_status, was_cancelled))
+        .map(|exit_status| (exit_status, stopped))
 }
 
 /// Waits for the restart policy backoff timeout and returns whether it was cancelled or not
 fn wait_restart(
+    agent_id: &AgentID,
+    exec_id: &str,
     restart_policy: &mut RestartPolicy,
     step: u32,
     stop_consumer: &EventConsumer<CancellationMessage>,
 ) -> bool {
+    let _span = info_span!("restart_executable", %agent_id, executable = exec_id, step).entered();
     let max_retries = restart_policy.backoff.max_retries();
     info!("Waiting for restart policy backoff");
 
     let mut cancelled = false;
     restart_policy.backoff(|duration| {
+        trace!(
+            histogram.process_restart_backoff_seconds = duration.as_secs_f64(),
+            %agent_id,
+            executable = exec_id
+        );
         // early exit if supervisor timeout is canceled
         if stop_consumer.is_cancelled_with_timeout(duration) {
             cancelled = true;
@@ -571,6 +995,12 @@ fn wait_restart(
     };
     if !cancelled {
         info!("Restarting supervisor ({step}/{max_retries_str})");
+        trace!(
+            monotonic_counter.process_restarts = 1u64,
+            %agent_id,
+            executable = exec_id,
+            reason = "exit"
+        );
     } else {
         info!("Restarting supervisor ({step}/{max_retries_str}) was cancelled");
     }
@@ -583,6 +1013,7 @@ fn handle_exit(
     agent_id: &AgentID,
     exec_data: &ExecutableData,
     exit_status: &ExitStatus,
+    crash: Option<&CrashReport>,
     health_handler: &HealthHandler,
 ) {
     if exit_status.success() {
@@ -590,10 +1021,21 @@ fn handle_exit(
     }
 
     let ExecutableData { bin, args, .. } = &exec_data;
+    let args = args.join(" ");
+    if let Some(crash) = crash {
+        warn!(%agent_id, supervisor = bin, signal = %crash.signal, "Executable crashed");
+        let error = format!(
+            "path '{bin}' with args '{args}' crashed: {}",
+            crash.summary()
+        );
+        let status = format!("process killed by signal: {}", crash.signal);
+        health_handler.publish_unhealthy_with_status(error, status);
+        return;
+    }
+
     warn!(%agent_id,supervisor = bin,exit_code = ?exit_status.code(),"Executable exited unsuccessfully");
     debug!(%exit_status, "Error executing executable, marking as unhealthy");
 
-    let args = args.join(" ");
     let error = format!("path '{bin}' with args '{args}' failed with '{exit_status}'",);
     let status = format!(
         "process exited with code: {}",
@@ -943,7 +1385,9 @@ persistent.txt:
             "wrong-command".to_owned().try_into().unwrap(),
             AgentTypeID::try_from("ns/test:0.1.2").unwrap(),
         ));
+        let agent_id = agent_identity.id.clone();
 
+        let (crash_loop_publisher, crash_loop_consumer) = pub_sub();
         let agent = NotStartedSupervisorOnHost::new(
             agent_identity,
             executables,
@@ -953,7 +1397,8 @@ persistent.txt:
             false,
             PathBuf::default(),
             FileSystem::test_empty(),
-        );
+        )
+        .with_crash_loop_publisher(Some(crash_loop_publisher));
 
         let (sub_agent_internal_publisher, _sub_agent_internal_consumer) = pub_sub();
         let agent = agent.start(sub_agent_internal_publisher).expect("no error");
@@ -967,6 +1412,12 @@ persistent.txt:
                 }
             }
         }
+
+        // Exhausting the restart policy is reported so Agent Control exits
+        assert_eq!(
+            crash_loop_consumer.as_ref().try_recv().unwrap(),
+            AgentControlInternalEvent::SubAgentCrashLooped(agent_id)
+        );
     }
 
     #[test]
@@ -1164,9 +1615,9 @@ persistent.txt:
 
         let executables_clone = agent.executables.clone();
 
-        let executable_thread_contexts = executables_clone
-            .iter()
-            .map(|e| agent.start_process_thread(e, health_publisher.clone()));
+        let executable_thread_contexts = executables_clone.iter().map(|e| {
+            agent.start_process_thread(e, health_publisher.clone(), RestartRequests::default())
+        });
 
         for thread_context in executable_thread_contexts {
             while !thread_context.is_thread_finished() {
@@ -1218,6 +1669,51 @@ persistent.txt:
         assert_eq!(actual_ordered_events, expected_ordered_events);
     }
 
+    #[cfg(target_family = "unix")]
+    #[test]
+    fn test_supervisor_disables_executable_on_exceeded_failure_window() {
+        let backoff = Backoff::default().with_initial_delay(Duration::from_millis(10));
+        let restart_policy = RestartPolicy::new(BackoffStrategy::Fixed(backoff))
+            .with_failure_window(Duration::from_millis(50));
+        let executables = vec![
+            build_test_exec_data(r#"{"id":"false-process","path":"false","args":[]}"#)
+                .with_restart_policy(restart_policy),
+        ];
+        let agent_identity = AgentIdentity::from((
+            "false-process".to_owned().try_into().unwrap(),
+            AgentTypeID::try_from("ns/test:0.1.2").unwrap(),
+        ));
+        let agent = NotStartedSupervisorOnHost::new(
+            agent_identity,
+            executables,
+            OnHostHealthConfig::default(),
+            get_empty_packages(),
+            MockPackageManager::new_arc(),
+            false,
+            PathBuf::default(),
+            FileSystem::test_empty(),
+        );
+
+        let (health_publisher, health_consumer) = pub_sub();
+        let thread_context = agent.start_process_thread(
+            &agent.executables[0],
+            health_publisher,
+            RestartRequests::default(),
+        );
+        while !thread_context.is_thread_finished() {
+            thread::sleep(Duration::from_millis(15));
+        }
+
+        let last_health = health_consumer.as_ref().try_iter().last().unwrap().1;
+        assert_eq!(last_health.status(), DISABLED_STATUS);
+        assert!(
+            last_health
+                .last_error()
+                .unwrap()
+                .starts_with("Executable disabled")
+        );
+    }
+
     #[test]
     fn test_wait_on_exit_publish_healthy_once() {
         #[cfg(target_family = "unix")]
@@ -1243,6 +1739,9 @@ persistent.txt:
             &health_handler,
             &agent_id,
             &exec_data.id,
+            &mut ProcessWatcher::new(agent_id.clone(), &exec_data.bin, &Default::default()),
+            &RestartRequests::default().watch(),
+            None,
         );
 
         let start_time = SystemTime::now();
@@ -1293,11 +1792,49 @@ persistent.txt:
             &health_handler,
             &agent_id,
             &exec_data.id,
+            &mut ProcessWatcher::new(agent_id.clone(), &exec_data.bin, &Default::default()),
+            &RestartRequests::default().watch(),
+            None,
         );
 
         assert!(health_consumer.as_ref().is_empty())
     }
 
+    #[cfg(target_family = "unix")]
+    #[test]
+    fn test_wait_on_exit_stops_on_restart_request() {
+        let exec_data = ExecutableData::new("sleep".to_owned(), "sleep".to_owned())
+            .with_args(vec!["10".to_owned()]);
+
+        let agent_id = AgentID::AgentControl;
+        let command = CommandOSNotStarted::new(agent_id.clone(), &exec_data, false, PathBuf::new())
+            .start()
+            .unwrap();
+
+        let (health_publisher, _health_consumer) = pub_sub();
+        let health_handler = HealthHandler::new(exec_data.id.clone(), health_publisher);
+
+        let restart_requests = RestartRequests::default();
+        let restart_watch = restart_requests.watch();
+        restart_requests.request();
+
+        let (_stop_publisher, stop_consumer) = pub_sub::<CancellationMessage>();
+        let (_, stopped) = wait_exit(
+            command,
+            &stop_consumer,
+            Duration::from_secs(10),
+            &health_handler,
+            &agent_id,
+            &exec_data.id,
+            &mut ProcessWatcher::new(agent_id.clone(), &exec_data.bin, &Default::default()),
+            &restart_watch,
+            None,
+        )
+        .unwrap();
+
+        assert_eq!(stopped, Some(StopReason::RestartRequested));
+    }
+
     #[test]
     fn test_supervisor_reloading_keeps_file_logging() {
         let dir = tempfile::tempdir().unwrap();
@@ -1322,6 +1859,8 @@ persistent.txt:
             env: HashMap::new(),
             shutdown_timeout: Duration::from_secs(5),
             restart_policy: RestartPolicy::default(),
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
         };
 
         let agent_identity = AgentIdentity::from((
@@ -1365,6 +1904,9 @@ persistent.txt:
             args: Args(args_2),
             env: Env(HashMap::new()),
             restart_policy: RestartPolicyConfig::default(),
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
 
         let on_host_config = OnHost {
@@ -1373,6 +1915,7 @@ persistent.txt:
             health: OnHostHealthConfig::default(),
             filesystem: FileSystem::test_empty(),
             packages: get_empty_packages(),
+            validation: None,
         };
 
         let runtime = Runtime {
@@ -1447,6 +1990,8 @@ persistent.txt:
             env: HashMap::new(),
             shutdown_timeout: Duration::from_secs(5),
             restart_policy: RestartPolicy::default(),
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
         };
 
         let agent_identity = AgentIdentity::from((
@@ -1490,6 +2035,9 @@ persistent.txt:
             args: Args(args_2),
             env: Env(HashMap::new()),
             restart_policy: RestartPolicyConfig::default(),
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
 
         // ENABLING file logging on reload
@@ -1499,6 +2047,7 @@ persistent.txt:
             health: OnHostHealthConfig::default(),
             filesystem: FileSystem::test_empty(),
             packages: get_empty_packages(),
+            validation: None,
         };
 
         let runtime = Runtime {
@@ -1571,6 +2120,8 @@ persistent.txt:
             env: HashMap::new(),
             shutdown_timeout: Duration::from_secs(5),
             restart_policy: RestartPolicy::default(),
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
         };
 
         let agent_identity = AgentIdentity::from((
@@ -1614,6 +2165,9 @@ persistent.txt:
             args: Args(args_2),
             env: Env(HashMap::new()),
             restart_policy: RestartPolicyConfig::default(),
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
 
         // DISABLING file logging on reload
@@ -1623,6 +2177,7 @@ persistent.txt:
             health: OnHostHealthConfig::default(),
             filesystem: FileSystem::test_empty(),
             packages: get_empty_packages(),
+            validation: None,
         };
 
         let runtime = Runtime {
@@ -1696,6 +2251,8 @@ persistent.txt:
             env: HashMap::new(),
             shutdown_timeout: Duration::from_secs(5),
             restart_policy: RestartPolicy::default(),
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
         };
 
         let agent_identity = AgentIdentity::from((
@@ -1739,6 +2296,9 @@ persistent.txt:
             args: Args(args_2),
             env: Env(HashMap::new()),
             restart_policy: RestartPolicyConfig::default(),
+            scheduling: Default::default(),
+            listen_sockets: Vec::new(),
+            shutdown_timeout: Default::default(),
         };
 
         // KEEP logging DISABLED on reload
@@ -1748,6 +2308,7 @@ persistent.txt:
             health: OnHostHealthConfig::default(),
             filesystem: FileSystem::test_empty(),
             packages: get_empty_packages(),
+            validation: None,
         };
 
         let runtime = Runtime {
@@ -1771,4 +2332,67 @@ persistent.txt:
             agent_logs_dir
         );
     }
+
+    #[test]
+    fn test_supervisor_rejects_executable_not_allowed() {
+        let executables = vec![build_test_exec_data(
+            r#"{"id":"not-allowed","path":"/tmp/not-allowed","args":["x"]}"#,
+        )];
+
+        let agent_identity = AgentIdentity::from((
+            "not-allowed".to_owned().try_into().unwrap(),
+            AgentTypeID::try_from("ns/test:0.1.2").unwrap(),
+        ));
+
+        let agent = NotStartedSupervisorOnHost::new(
+            agent_identity,
+            executables,
+            OnHostHealthConfig::default(),
+            get_empty_packages(),
+            MockPackageManager::new_arc(),
+            false,
+            PathBuf::default(),
+            FileSystem::test_empty(),
+        )
+        .with_allowed_executables(ExecutableAllowList::try_new(["/opt/agents/**"]).unwrap());
+
+        let (sub_agent_internal_publisher, _sub_agent_internal_consumer) = pub_sub();
+        let Err(err) = agent.start(sub_agent_internal_publisher) else {
+            panic!("executable out of the allow-list should not start");
+        };
+        assert!(
+            matches!(err, SupervisorError::ExecutableNotAllowed(bin) if bin == "/tmp/not-allowed")
+        );
+    }
+
+    #[test]
+    fn test_verify_executables_integrity() {
+        let package_dir = tempfile::tempdir().unwrap();
+        let bin = package_dir.path().join("agent");
+        fs::write(&bin, b"binary").unwrap();
+        IntegrityManifest::compute(package_dir.path())
+            .unwrap()
+            .save(package_dir.path())
+            .unwrap();
+
+        let installed_packages = vec![InstalledPackageData {
+            id: "agent".to_string(),
+            installation_path: package_dir.path().to_path_buf(),
+        }];
+        let executables = vec![
+            ExecutableData::new("agent".to_string(), bin.to_string_lossy().to_string()),
+            // Executables outside the packages are not checked.
+            ExecutableData::new("sh".to_string(), "/bin/sh".to_string()),
+        ];
+
+        verify_executables_integrity(&executables, &installed_packages).unwrap();
+
+        fs::write(&bin, b"tampered").unwrap();
+        let err = verify_executables_integrity(&executables, &installed_packages).unwrap_err();
+        assert!(matches!(
+            err,
+            SupervisorError::Integrity(IntegrityError::Mismatch { .. })
+        ));
+        assert_eq!(err.kind(), ErrorKind::Fatal);
+    }
 }
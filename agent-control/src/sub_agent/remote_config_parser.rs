@@ -1,8 +1,10 @@
 //! Parsing and validation of OpAMP remote configurations into a [RemoteConfig].
 
+use crate::agent_control::defaults::REMOTE_CONFIG_MAX_SIZE_BYTES;
 use crate::opamp::remote_config::OpampRemoteConfig;
 use crate::opamp::remote_config::validators::RemoteConfigValidator;
 use crate::sub_agent::identity::AgentIdentity;
+use crate::utils::error_kind::{ClassifiedError, ErrorKind};
 use crate::values::config::RemoteConfig;
 use crate::values::yaml_config::YAMLConfig;
 use thiserror::Error;
@@ -22,6 +24,21 @@ pub enum RemoteConfigParserError {
     /// The configuration values are malformed (invalid YAML, duplicate keys, etc.).
     #[error("remote configuration with invalid values: {0}")]
     InvalidValues(String),
+    /// The configuration values exceed the maximum allowed size.
+    #[error("remote configuration of {size} bytes exceeds the maximum size of {max_size} bytes")]
+    TooLarge {
+        /// Size in bytes of the configuration values.
+        size: usize,
+        /// Maximum allowed size in bytes.
+        max_size: usize,
+    },
+}
+
+impl ClassifiedError for RemoteConfigParserError {
+    fn kind(&self) -> ErrorKind {
+        // The remote configuration will keep failing until a new one is received
+        ErrorKind::Misconfiguration
+    }
 }
 
 /// Defines how to parse the OpAMP remote configuration in order to validate it and extract
@@ -39,6 +56,7 @@ pub trait RemoteConfigParser {
 /// A [RemoteConfigParser] that runs a sequence of [RemoteConfigValidator]s before extracting values.
 pub struct AgentRemoteConfigParser<V> {
     remote_config_validators: Vec<V>,
+    max_config_size: usize,
 }
 
 impl<V> AgentRemoteConfigParser<V>
@@ -49,6 +67,16 @@ where
     pub fn new(remote_config_validators: Vec<V>) -> Self {
         AgentRemoteConfigParser {
             remote_config_validators,
+            max_config_size: REMOTE_CONFIG_MAX_SIZE_BYTES,
+        }
+    }
+
+    /// Sets the maximum size in bytes of the configuration values, defaults to
+    /// [REMOTE_CONFIG_MAX_SIZE_BYTES].
+    pub fn with_max_config_size(self, max_config_size: usize) -> Self {
+        Self {
+            max_config_size,
+            ..self
         }
     }
 }
@@ -78,7 +106,7 @@ where
                 return Err(RemoteConfigParserError::Validation(error_msg.to_string()));
             }
         }
-        extract_remote_config_values(config)
+        extract_remote_config_values(config, self.max_config_size)
     }
 }
 
@@ -96,6 +124,9 @@ where
 ///   The override configuration takes precedence, therefore key collisions are not errors in this case.
 /// - Returns `None` if the final merged configuration is empty.
 ///
+/// The size of the values is checked before parsing them and each entry is parsed and merged one at
+/// a time, so the memory needed is bounded by `max_config_size`.
+///
 /// # Example
 ///
 /// **Input**:
@@ -121,9 +152,27 @@ where
 /// - Duplicate keys are found when merging configurations.
 /// - There is more than one configuration starting with
 ///   [AGENT_CONFIG_OVERRIDE_PREFIX](crate::opamp::remote_config::AGENT_CONFIG_OVERRIDE_PREFIX)
+/// - The size of the values exceeds `max_config_size`.
 pub fn extract_remote_config_values(
     opamp_remote_config: &OpampRemoteConfig,
+    max_config_size: usize,
 ) -> Result<Option<RemoteConfig>, RemoteConfigParserError> {
+    let maybe_override_config = opamp_remote_config.agent_config_override().map_err(|err| {
+        RemoteConfigParserError::InvalidValues(format!("getting override values: {err}"))
+    })?;
+
+    let size = opamp_remote_config
+        .agent_configs_iter()
+        .map(|(_, content)| content.len())
+        .sum::<usize>()
+        + maybe_override_config.map_or(0, String::len);
+    if size > max_config_size {
+        return Err(RemoteConfigParserError::TooLarge {
+            size,
+            max_size: max_config_size,
+        });
+    }
+
     let mut config = opamp_remote_config.agent_configs_iter().try_fold(
         YAMLConfig::default(),
         |mut acc, (_, content)| {
@@ -137,9 +186,6 @@ pub fn extract_remote_config_values(
         },
     )?;
 
-    let maybe_override_config = opamp_remote_config.agent_config_override().map_err(|err| {
-        RemoteConfigParserError::InvalidValues(format!("getting override values: {err}"))
-    })?;
     if let Some(override_content) = maybe_override_config {
         let override_config = YAMLConfig::try_from(override_content.as_str()).map_err(|err| {
             RemoteConfigParserError::InvalidValues(format!("decoding override values: {err}"))
@@ -392,6 +438,54 @@ pub mod tests {
         });
     }
 
+    #[test]
+    fn test_agent_remote_config_parser_too_large_config() {
+        let agent_identity = AgentIdentity::default();
+
+        let config_map = ConfigurationMap::new(HashMap::from([
+            (
+                format!("{AGENT_CONFIG_PREFIX}-1"),
+                "key1: value1".to_string(),
+            ),
+            (
+                format!("{AGENT_CONFIG_PREFIX}-2"),
+                "key2: value2".to_string(),
+            ),
+            (
+                AGENT_CONFIG_OVERRIDE_PREFIX.to_string(),
+                "key1: value".to_string(),
+            ),
+            // Values not part of the agent configuration are not taken into account
+            ("non-agent-config".to_string(), "key3: value3".repeat(10)),
+        ]));
+        let opamp_remote_config = OpampRemoteConfig::new(
+            agent_identity.id.clone(),
+            Hash::from("some-hash"),
+            ConfigState::Applying,
+            config_map,
+        );
+
+        let mut validator = MockRemoteConfigValidator::new();
+        validator
+            .expect_validate()
+            .times(2)
+            .returning(|_, _| Ok(()));
+
+        let handler = AgentRemoteConfigParser::new(vec![validator]).with_max_config_size(34);
+        let result = handler.parse(agent_identity.clone(), &opamp_remote_config);
+        assert_matches!(
+            result,
+            Err(RemoteConfigParserError::TooLarge {
+                size: 35,
+                max_size: 34
+            })
+        );
+
+        let handler = handler.with_max_config_size(35);
+        let result = handler.parse(agent_identity, &opamp_remote_config);
+        assert!(result.unwrap().is_some());
+    }
+
     #[test]
     fn test_agent_remote_config_parser_empty_config() {
         let agent_identity = AgentIdentity::default();
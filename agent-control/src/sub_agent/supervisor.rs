@@ -25,6 +25,7 @@
 use crate::{
     event::{SubAgentInternalEvent, channel::EventPublisher},
     sub_agent::effective_agents_assembler::EffectiveAgent,
+    utils::error_kind::ClassifiedError,
 };
 
 use std::{error::Error, marker::Sized};
@@ -42,7 +43,7 @@ pub trait SupervisorBuilder {
     /// The not-yet-started supervisor produced by [`build_supervisor`](Self::build_supervisor).
     type Starter: SupervisorStarter;
     /// Error returned when building the starter fails.
-    type Error: Error;
+    type Error: ClassifiedError;
 
     /// Builds a supervisor starter from the given effective agent configuration.
     ///
@@ -74,7 +75,7 @@ pub trait SupervisorStarter {
     /// The running supervisor produced by [`start`](Self::start).
     type Supervisor: Supervisor;
     /// Error returned when starting the supervisor fails.
-    type Error: Error;
+    type Error: ClassifiedError;
 
     /// Starts the supervisor, consuming this starter.
     ///
@@ -110,7 +111,7 @@ pub trait SupervisorStarter {
 /// * `StopError` - The error type returned when stopping fails
 pub trait Supervisor: Sized {
     /// Error returned when applying a new configuration fails.
-    type ApplyError: Error;
+    type ApplyError: ClassifiedError;
     /// Error returned when stopping the supervisor fails.
     type StopError: Error;
 
@@ -151,11 +152,18 @@ pub trait Supervisor: Sized {
 #[allow(missing_docs)]
 pub(crate) mod tests {
     use super::*;
+    use crate::utils::error_kind::ErrorKind;
     use mockall::{mock, predicate};
 
     #[derive(Debug, thiserror::Error)]
     #[error("{0}")]
-    pub struct TestingSupervisorError(pub String);
+    pub struct TestingSupervisorError(pub String, pub ErrorKind);
+
+    impl ClassifiedError for TestingSupervisorError {
+        fn kind(&self) -> ErrorKind {
+            self.1
+        }
+    }
 
     mock! {
         pub SupervisorBuilder<A> where A: SupervisorStarter {}
@@ -0,0 +1,133 @@
+//! Detection of sub-agents whose runtime stopped making progress.
+//!
+//! The runtime loop of every sub-agent beats a [Heartbeat] on each iteration, and at least every
+//! [HEARTBEAT_INTERVAL] while idle. Agent Control checks the heartbeats periodically and, when a
+//! runtime didn't beat within the configured `deadline` (e.g. because it is blocked on a dead
+//! connection), reports the sub-agent unhealthy and, depending on the [StallAction], restarts it.
+
+use duration_str::deserialize_duration;
+use serde::Deserialize;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, LazyLock};
+use std::time::{Duration, Instant};
+use wrapper_with_default::WrapperWithDefault;
+
+/// Maximum time an idle runtime loop waits before beating.
+pub(crate) const HEARTBEAT_INTERVAL: Duration = Duration::from_secs(10);
+
+const DEFAULT_WATCHDOG_DEADLINE: Duration = Duration::from_secs(600);
+/// Minimum deadline, so idle runtimes have several heartbeats to report progress.
+const MIN_WATCHDOG_DEADLINE: Duration = Duration::from_secs(60);
+
+/// Reference of the heartbeats. They use a monotonic clock, so changes of the system clock or the
+/// time the host spends suspended aren't reported as stalls.
+static HEARTBEAT_EPOCH: LazyLock<Instant> = LazyLock::new(Instant::now);
+
+/// Last progress reported by the runtime of a sub-agent, in milliseconds since
+/// [HEARTBEAT_EPOCH]. Clones share the same heartbeat.
+#[derive(Debug, Clone)]
+pub struct Heartbeat(Arc<AtomicU64>);
+
+impl Default for Heartbeat {
+    fn default() -> Self {
+        Self(Arc::new(AtomicU64::new(now_millis())))
+    }
+}
+
+impl Heartbeat {
+    /// Reports progress.
+    pub(crate) fn beat(&self) {
+        self.0.store(now_millis(), Ordering::SeqCst);
+    }
+
+    /// Returns the time elapsed since the last progress reported.
+    pub(crate) fn elapsed(&self) -> Duration {
+        Duration::from_millis(now_millis().saturating_sub(self.0.load(Ordering::SeqCst)))
+    }
+}
+
+fn now_millis() -> u64 {
+    HEARTBEAT_EPOCH.elapsed().as_millis() as u64
+}
+
+/// Watchdog of the sub-agent runtimes configuration.
+#[derive(Debug, Default, Deserialize, PartialEq, Clone)]
+#[serde(default)]
+pub struct WatchdogConfig {
+    /// Whether the watchdog is enabled.
+    pub enabled: WatchdogEnabled,
+    /// Time a runtime can go without progress before it is considered stalled.
+    pub deadline: WatchdogDeadline,
+    /// What to do with stalled sub-agents.
+    pub on_stall: StallAction,
+}
+
+impl WatchdogConfig {
+    /// Whether the watchdog is enabled.
+    pub fn enabled(&self) -> bool {
+        self.enabled.0
+    }
+
+    /// Time a runtime can go without progress before it is considered stalled.
+    pub fn deadline(&self) -> Duration {
+        self.deadline.0.max(MIN_WATCHDOG_DEADLINE)
+    }
+
+    /// Interval between checks of the heartbeats.
+    pub fn check_interval(&self) -> Duration {
+        self.deadline() / 4
+    }
+}
+
+const DEFAULT_WATCHDOG_ENABLED: bool = true;
+/// Whether the watchdog is enabled (defaults to `true`).
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_WATCHDOG_ENABLED)]
+pub struct WatchdogEnabled(bool);
+
+/// Time a runtime can go without progress before it is considered stalled (defaults to 10m).
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq, WrapperWithDefault)]
+#[wrapper_default_value(DEFAULT_WATCHDOG_DEADLINE)]
+pub struct WatchdogDeadline(#[serde(deserialize_with = "deserialize_duration")] Duration);
+
+/// What to do with a stalled sub-agent.
+#[derive(Debug, Default, Deserialize, PartialEq, Clone, Copy)]
+#[serde(rename_all = "lowercase")]
+pub enum StallAction {
+    /// Report it unhealthy only.
+    #[default]
+    Report,
+    /// Report it unhealthy, request the stalled runtime to stop and start the sub-agent again once
+    /// it did, so its executables never run twice.
+    Restart,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_heartbeat() {
+        let heartbeat = Heartbeat::default();
+        let shared = heartbeat.clone();
+        std::thread::sleep(Duration::from_millis(100));
+        assert!(shared.elapsed() >= Duration::from_millis(100));
+
+        shared.beat();
+        assert!(heartbeat.elapsed() < Duration::from_millis(100));
+    }
+
+    #[test]
+    fn test_watchdog_config() {
+        let config: WatchdogConfig = serde_saphyr::from_str("{}").unwrap();
+        assert!(config.enabled());
+        assert_eq!(config.deadline(), DEFAULT_WATCHDOG_DEADLINE);
+        assert_eq!(config.on_stall, StallAction::Report);
+
+        let config: WatchdogConfig =
+            serde_saphyr::from_str("{deadline: 1s, on_stall: restart}").unwrap();
+        assert_eq!(config.deadline(), MIN_WATCHDOG_DEADLINE);
+        assert_eq!(config.check_interval(), Duration::from_secs(15));
+        assert_eq!(config.on_stall, StallAction::Restart);
+    }
+}
@@ -1,11 +1,15 @@
 //! Assorted internal utilities: backoff/retry scheduling, archive extraction, environment-variable
-//! loading, privilege detection, thread lifecycle management, time abstractions, and binary metadata.
+//! loading, error classification, privilege detection, platform names, confinement detection,
+//! thread lifecycle management, time abstractions, and binary metadata.
 
 pub mod backoff_gate;
 pub mod binary_metadata;
+pub mod confinement;
 pub mod env_var;
+pub mod error_kind;
 pub mod extract;
 pub mod is_elevated;
+pub mod platform;
 pub mod retry;
 pub mod thread_context;
 pub mod threads;
@@ -0,0 +1,139 @@
+//! Detection of confined packaging environments (strictly confined snaps and flatpaks), where
+//! Agent Control can only write to the directories provided by the sandbox.
+
+use std::ffi::OsString;
+use std::path::PathBuf;
+
+/// Name of the directory holding the Agent Control data in the flatpak data directory.
+const FLATPAK_DIR_NAME: &str = "newrelic-agent-control";
+/// Name of the Agent Control snap.
+const SNAP_NAME: &str = "newrelic-agent-control";
+/// Application id of the Agent Control flatpak.
+const FLATPAK_ID: &str = "com.newrelic.AgentControl";
+
+/// Confined packaging environment Agent Control runs in.
+#[derive(Debug, Clone, PartialEq)]
+pub enum Confinement {
+    /// Snap package. The root is `SNAP_COMMON`, kept across revisions of the snap.
+    Snap {
+        /// Writable directory holding everything Agent Control reads and writes.
+        root: PathBuf,
+    },
+    /// Flatpak application. The root is a directory in the `XDG_DATA_HOME` of the application.
+    Flatpak {
+        /// Writable directory holding everything Agent Control reads and writes.
+        root: PathBuf,
+    },
+}
+
+impl Confinement {
+    /// Returns the confined environment of the current process, if any.
+    pub fn detect() -> Option<Self> {
+        if !cfg!(target_os = "linux") {
+            return None;
+        }
+        Self::from_env(|key| std::env::var_os(key))
+    }
+
+    /// Detects the confined environment from the variables set by snapd and flatpak. They must
+    /// name the Agent Control snap or flatpak, so the variables inherited from another sandbox
+    /// (e.g. when started from a snapped terminal) don't move the Agent Control directories. Snaps
+    /// with classic or devmode confinement can reach the system directories and are not confined.
+    fn from_env(var: impl Fn(&str) -> Option<OsString>) -> Option<Self> {
+        if var("SNAP_NAME").is_some_and(|name| name == SNAP_NAME)
+            && var("SNAP_CONFINEMENT").is_some_and(|confinement| confinement == "strict")
+            && let Some(common) = var("SNAP_COMMON")
+        {
+            return Some(Self::Snap {
+                root: PathBuf::from(common),
+            });
+        }
+        if var("FLATPAK_ID").is_some_and(|id| id == FLATPAK_ID)
+            && let Some(data_home) = var("XDG_DATA_HOME")
+        {
+            return Some(Self::Flatpak {
+                root: PathBuf::from(data_home).join(FLATPAK_DIR_NAME),
+            });
+        }
+        None
+    }
+
+    /// Writable directory holding everything Agent Control reads and writes.
+    pub fn root(&self) -> &PathBuf {
+        match self {
+            Self::Snap { root } | Self::Flatpak { root } => root,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    fn from_vars(vars: &[(&str, &str)]) -> Option<Confinement> {
+        let vars = HashMap::<_, _>::from_iter(vars.iter().copied());
+        Confinement::from_env(|key| vars.get(key).map(OsString::from))
+    }
+
+    #[test]
+    fn test_detect_confinement() {
+        assert_eq!(
+            from_vars(&[
+                ("SNAP_NAME", "newrelic-agent-control"),
+                ("SNAP_CONFINEMENT", "strict"),
+                ("SNAP_COMMON", "/var/snap/newrelic-agent-control/common"),
+            ]),
+            Some(Confinement::Snap {
+                root: PathBuf::from("/var/snap/newrelic-agent-control/common")
+            })
+        );
+        assert_eq!(
+            from_vars(&[
+                ("FLATPAK_ID", "com.newrelic.AgentControl"),
+                (
+                    "XDG_DATA_HOME",
+                    "/home/user/.var/app/com.newrelic.AgentControl/data"
+                ),
+            ]),
+            Some(Confinement::Flatpak {
+                root: PathBuf::from(
+                    "/home/user/.var/app/com.newrelic.AgentControl/data/newrelic-agent-control"
+                )
+            })
+        );
+        assert_eq!(from_vars(&[("SNAP_NAME", "newrelic-agent-control")]), None);
+        assert_eq!(from_vars(&[]), None);
+    }
+
+    #[test]
+    fn test_detect_confinement_of_other_sandboxes() {
+        // Inherited from another snap, e.g. a snapped terminal.
+        assert_eq!(
+            from_vars(&[
+                ("SNAP_NAME", "alacritty"),
+                ("SNAP_CONFINEMENT", "strict"),
+                ("SNAP_COMMON", "/var/snap/alacritty/common"),
+            ]),
+            None
+        );
+        assert_eq!(
+            from_vars(&[
+                ("SNAP_NAME", "newrelic-agent-control"),
+                ("SNAP_CONFINEMENT", "classic"),
+                ("SNAP_COMMON", "/var/snap/newrelic-agent-control/common"),
+            ]),
+            None
+        );
+        assert_eq!(
+            from_vars(&[
+                ("FLATPAK_ID", "org.gnome.Terminal"),
+                (
+                    "XDG_DATA_HOME",
+                    "/home/user/.var/app/org.gnome.Terminal/data"
+                ),
+            ]),
+            None
+        );
+    }
+}
@@ -0,0 +1,65 @@
+//! Classification of errors by how the caller should react to them.
+//!
+//! Errors from different components (config, packages, health checks, supervisors) implement
+//! [ClassifiedError] so the sub-agent can decide whether a failure is worth retrying instead of
+//! treating every error as fatal.
+
+use std::error::Error;
+use std::fmt::{self, Display, Formatter};
+
+/// Kind of an error, describing how the caller should react to it.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ErrorKind {
+    /// Temporary failure (e.g. network or remote API unavailable). Retrying the same operation
+    /// later may succeed.
+    Transient,
+    /// Unexpected failure that retrying won't fix (e.g. filesystem errors).
+    Fatal,
+    /// The configuration is invalid. It will keep failing until the configuration changes.
+    Misconfiguration,
+}
+
+impl Display for ErrorKind {
+    fn fmt(&self, f: &mut Formatter<'_>) -> fmt::Result {
+        match self {
+            ErrorKind::Transient => f.write_str("transient"),
+            ErrorKind::Fatal => f.write_str("fatal"),
+            ErrorKind::Misconfiguration => f.write_str("misconfiguration"),
+        }
+    }
+}
+
+/// Errors that can be classified by [ErrorKind].
+pub trait ClassifiedError: Error {
+    /// Returns the kind of the error.
+    fn kind(&self) -> ErrorKind;
+
+    /// Returns `true` if retrying the failed operation may succeed.
+    fn is_retryable(&self) -> bool {
+        self.kind() == ErrorKind::Transient
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+
+    #[derive(Debug, thiserror::Error)]
+    #[error("some error")]
+    struct SomeError(ErrorKind);
+
+    impl ClassifiedError for SomeError {
+        fn kind(&self) -> ErrorKind {
+            self.0
+        }
+    }
+
+    #[rstest]
+    #[case::transient(ErrorKind::Transient, true)]
+    #[case::fatal(ErrorKind::Fatal, false)]
+    #[case::misconfiguration(ErrorKind::Misconfiguration, false)]
+    fn test_is_retryable(#[case] kind: ErrorKind, #[case] expected: bool) {
+        assert_eq!(SomeError(kind).is_retryable(), expected);
+    }
+}
@@ -0,0 +1,56 @@
+//! Names of the platform Agent Control runs on, following the Go `GOOS`/`GOARCH` conventions used
+//! by most agent release artifacts (e.g. `linux/amd64` or `windows/arm64`).
+
+use std::env::consts;
+
+/// Returns the operating system name, like `linux`, `windows` or `darwin`.
+pub fn os() -> &'static str {
+    go_os(consts::OS)
+}
+
+/// Returns the architecture name, like `amd64` or `arm64`.
+pub fn arch() -> &'static str {
+    go_arch(consts::ARCH)
+}
+
+/// Returns the `<os>/<arch>` pair, like `linux/amd64`.
+pub fn os_arch() -> String {
+    format!("{}/{}", os(), arch())
+}
+
+fn go_os(os: &'static str) -> &'static str {
+    match os {
+        "macos" => "darwin",
+        other => other,
+    }
+}
+
+fn go_arch(arch: &'static str) -> &'static str {
+    match arch {
+        "x86_64" => "amd64",
+        "aarch64" => "arm64",
+        "x86" => "386",
+        "powerpc64" => "ppc64",
+        "loongarch64" => "loong64",
+        other => other,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_go_names() {
+        assert_eq!(go_os("linux"), "linux");
+        assert_eq!(go_os("macos"), "darwin");
+        assert_eq!(go_arch("x86_64"), "amd64");
+        assert_eq!(go_arch("aarch64"), "arm64");
+        assert_eq!(go_arch("s390x"), "s390x");
+    }
+
+    #[test]
+    fn test_os_arch() {
+        assert_eq!(os_arch(), format!("{}/{}", os(), arch()));
+    }
+}
@@ -1,6 +1,9 @@
 //! Configuration values storage: types and repositories for agent local and remote configs.
 
 use std::sync::Arc;
+use std::time::Duration;
+
+use crossbeam::channel::never;
 
 use opamp_client::operation::capabilities::Capabilities;
 use tracing::debug;
@@ -8,9 +11,16 @@ use tracing::debug;
 use crate::{
     agent_control::{
         agent_id::AgentID,
-        defaults::{STORE_KEY_LOCAL_DATA_CONFIG, STORE_KEY_OPAMP_DATA_CONFIG},
+        defaults::{
+            STORE_KEY_LOCAL_DATA_CONFIG, STORE_KEY_LOCAL_MANDATORY_DATA_CONFIG,
+            STORE_KEY_OPAMP_DATA_CONFIG,
+        },
     },
     data_store::DataStore,
+    event::{
+        cancellation::{CancellationMessage, IoWorker},
+        channel::EventConsumer,
+    },
     opamp::remote_config::hash::ConfigState,
     resource_ownership::ResourceOwnership,
     values::{
@@ -23,11 +33,18 @@ use crate::{
 pub mod config;
 pub mod config_repository;
 pub mod yaml_config;
+pub mod yaml_document;
+
+/// Name of the thread running cancellable data store operations.
+const CONFIG_REPOSITORY_THREAD_NAME: &str = "config repository";
 
 /// [`ConfigRepository`] implementation backed by an OpAMP [`DataStore`].
 pub struct ConfigRepo<D: DataStore> {
     opamp_data_store: Arc<D>,
     remote_enabled: bool,
+    cancellation: Option<EventConsumer<CancellationMessage>>,
+    io_timeout: Option<Duration>,
+    io_worker: Option<IoWorker>,
 }
 
 impl<D: DataStore> ConfigRepo<D> {
@@ -36,6 +53,9 @@ impl<D: DataStore> ConfigRepo<D> {
         Self {
             opamp_data_store,
             remote_enabled: false,
+            cancellation: None,
+            io_timeout: None,
+            io_worker: None,
         }
     }
 
@@ -46,6 +66,62 @@ impl<D: DataStore> ConfigRepo<D> {
             ..self
         }
     }
+
+    /// Returns the repository with cancellable data store operations. Once the publisher of
+    /// `cancellation` is dropped, pending and new operations fail with
+    /// [ConfigRepositoryError::Cancelled] instead of blocking the caller, so a hung filesystem
+    /// doesn't prevent a graceful shutdown.
+    pub fn with_cancellation(self, cancellation: EventConsumer<CancellationMessage>) -> Self {
+        Self {
+            cancellation: Some(cancellation),
+            ..self
+        }
+        .with_io_worker()
+    }
+
+    /// Returns the repository with data store operations failing with
+    /// [ConfigRepositoryError::Timeout] if they don't complete within `io_timeout`.
+    pub fn with_io_timeout(self, io_timeout: Duration) -> Self {
+        Self {
+            io_timeout: Some(io_timeout),
+            ..self
+        }
+        .with_io_worker()
+    }
+
+    /// Runs the data store operations in an [IoWorker], keeping the one already created if any.
+    fn with_io_worker(self) -> Self {
+        let io_worker = self
+            .io_worker
+            .unwrap_or_else(|| IoWorker::new(CONFIG_REPOSITORY_THREAD_NAME));
+        Self {
+            io_worker: Some(io_worker),
+            ..self
+        }
+    }
+}
+
+impl<D> ConfigRepo<D>
+where
+    D: DataStore + Send + Sync + 'static,
+{
+    /// Runs the data store operation, in the I/O worker if the repository is cancellable or has
+    /// an I/O timeout. Interrupted writes that didn't start are not applied.
+    fn run<T, F>(&self, operation: F) -> Result<T, ConfigRepositoryError>
+    where
+        F: FnOnce(&D) -> Result<T, ConfigRepositoryError> + Send + 'static,
+        T: Send + 'static,
+    {
+        let data_store = self.opamp_data_store.clone();
+        let operation = move || operation(&data_store);
+        let Some(io_worker) = &self.io_worker else {
+            return operation();
+        };
+        match &self.cancellation {
+            Some(cancellation) => io_worker.run(cancellation, self.io_timeout, operation),
+            None => io_worker.run(&EventConsumer::from(never()), self.io_timeout, operation),
+        }?
+    }
 }
 
 impl<D> ConfigRepository for ConfigRepo<D>
@@ -54,10 +130,32 @@ where
 {
     #[tracing::instrument(skip_all, err)]
     fn load_local(&self, agent_id: &AgentID) -> Result<Option<Config>, ConfigRepositoryError> {
-        self.opamp_data_store
-            .get_local_data::<YAMLConfig>(agent_id, STORE_KEY_LOCAL_DATA_CONFIG)
-            .map_err(|err| ConfigRepositoryError::LoadError(format!("loading local config: {err}")))
-            .map(|opt_yaml| opt_yaml.map(|yc| Config::LocalConfig(yc.into())))
+        let agent_id = agent_id.clone();
+        self.run(move |data_store| {
+            data_store
+                .get_local_data::<YAMLConfig>(&agent_id, STORE_KEY_LOCAL_DATA_CONFIG)
+                .map_err(|err| {
+                    ConfigRepositoryError::LoadError(format!("loading local config: {err}"))
+                })
+        })
+        .map(|opt_yaml| opt_yaml.map(|yc| Config::LocalConfig(yc.into())))
+    }
+
+    #[tracing::instrument(skip_all, err)]
+    fn load_local_mandatory(
+        &self,
+        agent_id: &AgentID,
+    ) -> Result<Option<YAMLConfig>, ConfigRepositoryError> {
+        let agent_id = agent_id.clone();
+        self.run(move |data_store| {
+            data_store
+                .get_local_data::<YAMLConfig>(&agent_id, STORE_KEY_LOCAL_MANDATORY_DATA_CONFIG)
+                .map_err(|err| {
+                    ConfigRepositoryError::LoadError(format!(
+                        "loading local mandatory config: {err}"
+                    ))
+                })
+        })
     }
 
     #[tracing::instrument(skip_all, err)]
@@ -69,12 +167,15 @@ where
         if !self.remote_enabled || !has_remote_management(capabilities) {
             Ok(None)
         } else {
-            self.opamp_data_store
-                .get_remote_data::<RemoteConfig>(agent_id, STORE_KEY_OPAMP_DATA_CONFIG)
-                .map_err(|err| {
-                    ConfigRepositoryError::LoadError(format!("loading remote config: {err}"))
-                })
-                .map(|opt_rc| opt_rc.map(Config::RemoteConfig))
+            let agent_id = agent_id.clone();
+            self.run(move |data_store| {
+                data_store
+                    .get_remote_data::<RemoteConfig>(&agent_id, STORE_KEY_OPAMP_DATA_CONFIG)
+                    .map_err(|err| {
+                        ConfigRepositoryError::LoadError(format!("loading remote config: {err}"))
+                    })
+            })
+            .map(|opt_rc| opt_rc.map(Config::RemoteConfig))
         }
     }
 
@@ -87,25 +188,34 @@ where
     ) -> Result<(), ConfigRepositoryError> {
         debug!(agent_id = agent_id.to_string(), "saving remote config");
 
-        self.opamp_data_store
-            .set_remote_data(
-                agent_id,
-                ownership,
-                STORE_KEY_OPAMP_DATA_CONFIG,
-                remote_config,
-            )
-            .map_err(|e| ConfigRepositoryError::StoreError(format!("storing remote config: {}", e)))
+        let agent_id = agent_id.clone();
+        let remote_config = remote_config.clone();
+        self.run(move |data_store| {
+            data_store
+                .set_remote_data(
+                    &agent_id,
+                    ownership,
+                    STORE_KEY_OPAMP_DATA_CONFIG,
+                    &remote_config,
+                )
+                .map_err(|e| {
+                    ConfigRepositoryError::StoreError(format!("storing remote config: {}", e))
+                })
+        })
     }
 
     fn get_remote_config(
         &self,
         agent_id: &AgentID,
     ) -> Result<Option<RemoteConfig>, ConfigRepositoryError> {
-        self.opamp_data_store
-            .get_remote_data::<RemoteConfig>(agent_id, STORE_KEY_OPAMP_DATA_CONFIG)
-            .map_err(|e| {
-                ConfigRepositoryError::LoadError(format!("getting remote config hash: {}", e))
-            })
+        let agent_id = agent_id.clone();
+        self.run(move |data_store| {
+            data_store
+                .get_remote_data::<RemoteConfig>(&agent_id, STORE_KEY_OPAMP_DATA_CONFIG)
+                .map_err(|e| {
+                    ConfigRepositoryError::LoadError(format!("getting remote config hash: {}", e))
+                })
+        })
     }
 
     fn update_state(
@@ -119,31 +229,32 @@ where
             "updating remote config hash"
         );
 
-        let maybe_config = self
-            .opamp_data_store
-            .get_remote_data::<RemoteConfig>(agent_id, STORE_KEY_OPAMP_DATA_CONFIG)
-            .map_err(|e| {
-                ConfigRepositoryError::LoadError(format!("updating remote config state: {e}"))
-            })?;
+        let agent_id = agent_id.clone();
+        self.run(move |data_store| {
+            let maybe_config = data_store
+                .get_remote_data::<RemoteConfig>(&agent_id, STORE_KEY_OPAMP_DATA_CONFIG)
+                .map_err(|e| {
+                    ConfigRepositoryError::LoadError(format!("updating remote config state: {e}"))
+                })?;
 
-        match maybe_config {
-            Some(remote_config) => self
-                .opamp_data_store
-                .set_remote_data(
-                    agent_id,
-                    ownership,
-                    STORE_KEY_OPAMP_DATA_CONFIG,
-                    &remote_config.with_state(state),
-                )
-                .map_err(|err| {
-                    ConfigRepositoryError::StoreError(format!(
-                        "updating remote config state: {err}"
-                    ))
-                }),
-            None => Err(ConfigRepositoryError::UpdateHashStateError(
-                "No remote config found".to_string(),
-            )),
-        }
+            match maybe_config {
+                Some(remote_config) => data_store
+                    .set_remote_data(
+                        &agent_id,
+                        ownership,
+                        STORE_KEY_OPAMP_DATA_CONFIG,
+                        &remote_config.with_state(state),
+                    )
+                    .map_err(|err| {
+                        ConfigRepositoryError::StoreError(format!(
+                            "updating remote config state: {err}"
+                        ))
+                    }),
+                None => Err(ConfigRepositoryError::UpdateHashStateError(
+                    "No remote config found".to_string(),
+                )),
+            }
+        })
     }
 
     // TODO Currently we are not deleting the whole folder, therefore multiple files are not supported
@@ -153,10 +264,13 @@ where
     fn delete_remote(&self, agent_id: &AgentID) -> Result<(), ConfigRepositoryError> {
         debug!(agent_id = agent_id.to_string(), "deleting remote config");
 
-        self.opamp_data_store
-            .delete_remote_data(agent_id, STORE_KEY_OPAMP_DATA_CONFIG)
-            .map_err(|e| {
-                ConfigRepositoryError::DeleteError(format!("deleting remote config: {}", e))
-            })
+        let agent_id = agent_id.clone();
+        self.run(move |data_store| {
+            data_store
+                .delete_remote_data(&agent_id, STORE_KEY_OPAMP_DATA_CONFIG)
+                .map_err(|e| {
+                    ConfigRepositoryError::DeleteError(format!("deleting remote config: {}", e))
+                })
+        })
     }
 }
@@ -45,6 +45,20 @@ impl Config {
         }
     }
 
+    /// Returns the config with the values set in the local mandatory one overriding its own,
+    /// merging the mappings present in both.
+    pub fn with_local_mandatory(self, local_mandatory: YAMLConfig) -> Self {
+        match self {
+            Config::LocalConfig(local_config) => Config::LocalConfig(LocalConfig(
+                YAMLConfig::merge_deep(local_config.0, local_mandatory),
+            )),
+            Config::RemoteConfig(remote_config) => Config::RemoteConfig(RemoteConfig {
+                config: YAMLConfig::merge_deep(remote_config.config, local_mandatory),
+                ..remote_config
+            }),
+        }
+    }
+
     /// Returns the [`LocalConfig`] if this is a local config, otherwise `None`.
     pub fn local_config(&self) -> Option<&LocalConfig> {
         match self {
@@ -158,6 +172,36 @@ pub mod tests {
         }
     }
 
+    #[test]
+    fn test_with_local_mandatory() {
+        let local_mandatory: YAMLConfig = "key: pinned\nother: value\nnested: {a: pinned}"
+            .try_into()
+            .unwrap();
+        let expected: YAMLConfig =
+            "key: pinned\nother: value\nremote: value\nnested: {a: pinned, b: value}"
+                .try_into()
+                .unwrap();
+
+        let remote_config = Config::RemoteConfig(RemoteConfig {
+            config: "key: remote\nremote: value\nnested: {a: remote, b: value}"
+                .try_into()
+                .unwrap(),
+            hash: Hash::from("a-hash"),
+            state: ConfigState::Applied,
+        })
+        .with_local_mandatory(local_mandatory.clone());
+        assert_eq!(remote_config.get_yaml_config(), &expected);
+        assert_eq!(remote_config.get_hash(), Some(&Hash::from("a-hash")));
+
+        let local_config = Config::LocalConfig(
+            YAMLConfig::try_from("key: local\nremote: value\nnested: {a: local, b: value}")
+                .unwrap()
+                .into(),
+        )
+        .with_local_mandatory(local_mandatory);
+        assert_eq!(local_config.get_yaml_config(), &expected);
+    }
+
     #[rstest]
     #[case(EXAMPLE_REMOTE_CONFIG, RemoteConfig::is_applying, "applying")]
     #[case(EXAMPLE_REMOTE_CONFIG_WITH_ERROR, RemoteConfig::is_failed, "failed")]
@@ -3,9 +3,12 @@
 use crate::agent_control::agent_id::AgentID;
 use crate::resource_ownership::ResourceOwnership;
 use crate::values::config::{Config, RemoteConfig};
+use crate::values::yaml_config::YAMLConfig;
 
+use crate::event::cancellation::OperationInterrupted;
 use crate::opamp::remote_config::hash::ConfigState;
 use opamp_client::operation::capabilities::Capabilities;
+use std::time::Duration;
 use thiserror::Error;
 use tracing::debug;
 
@@ -24,6 +27,21 @@ pub enum ConfigRepositoryError {
     /// Failed to update the hash state because no remote config exists.
     #[error("error updating hash, no remote config to update: {0}")]
     UpdateHashStateError(String),
+    /// The operation was cancelled before completion (e.g. during shutdown).
+    #[error("operation cancelled")]
+    Cancelled,
+    /// The operation didn't complete within the I/O timeout (e.g. hung filesystem).
+    #[error("operation timed out after {0:?}")]
+    Timeout(Duration),
+}
+
+impl From<OperationInterrupted> for ConfigRepositoryError {
+    fn from(value: OperationInterrupted) -> Self {
+        match value {
+            OperationInterrupted::Cancelled => Self::Cancelled,
+            OperationInterrupted::TimedOut(timeout) => Self::Timeout(timeout),
+        }
+    }
 }
 
 /// Loads, stores, and deletes agent local and remote configurations.
@@ -38,8 +56,21 @@ pub trait ConfigRepository: Send + Sync + 'static {
         capabilities: &Capabilities,
     ) -> Result<Option<Config>, ConfigRepositoryError>;
 
+    /// Loads the local mandatory configuration for the given agent, if any.
+    /// Its values cannot be overridden remotely, see [ConfigRepository::load_remote_fallback_local].
+    fn load_local_mandatory(
+        &self,
+        _agent_id: &AgentID,
+    ) -> Result<Option<YAMLConfig>, ConfigRepositoryError> {
+        Ok(None)
+    }
+
     /// Looks for remote configs first, if unavailable checks the local ones.
     /// It returns none if no configuration is found.
+    ///
+    /// The configuration layers take precedence as follows: local mandatory > remote > local
+    /// default. The remote configuration replaces the local one as a whole, while the top-level
+    /// keys of the local mandatory configuration override the ones of the loaded configuration.
     fn load_remote_fallback_local(
         &self,
         agent_id: &AgentID,
@@ -47,12 +78,34 @@ pub trait ConfigRepository: Send + Sync + 'static {
     ) -> Result<Option<Config>, ConfigRepositoryError> {
         debug!("loading config");
 
-        if let remote @ Some(_) = self.load_remote(agent_id, capabilities)? {
-            return Ok(remote);
-        }
-        debug!("remote config not found, loading local");
+        let maybe_config = match self.load_remote(agent_id, capabilities)? {
+            remote @ Some(_) => remote,
+            None => {
+                debug!("remote config not found, loading local");
+                self.load_local(agent_id)?
+            }
+        };
+
+        maybe_config
+            .map(|config| {
+                Ok(match self.load_local_mandatory(agent_id)? {
+                    Some(local_mandatory) => config.with_local_mandatory(local_mandatory),
+                    None => config,
+                })
+            })
+            .transpose()
+    }
 
-        self.load_local(agent_id)
+    /// Applies the local mandatory configuration of the given agent on top of `config`.
+    fn apply_local_mandatory(
+        &self,
+        agent_id: &AgentID,
+        config: YAMLConfig,
+    ) -> Result<YAMLConfig, ConfigRepositoryError> {
+        Ok(match self.load_local_mandatory(agent_id)? {
+            Some(local_mandatory) => YAMLConfig::merge_deep(config, local_mandatory),
+            None => config,
+        })
     }
 
     /// Stores the remote configuration for the given agent.
@@ -100,6 +153,7 @@ pub mod tests {
     pub struct InMemoryConfigRepository {
         local_config: Mutex<HashMap<AgentID, Config>>,
         remote_config: Mutex<HashMap<AgentID, Config>>,
+        local_mandatory_config: Mutex<HashMap<AgentID, YAMLConfig>>,
     }
     impl InMemoryConfigRepository {
         pub fn store_local(
@@ -113,6 +167,12 @@ pub mod tests {
             );
             Ok(())
         }
+        pub fn store_local_mandatory(&self, agent_id: &AgentID, yaml_config: &YAMLConfig) {
+            self.local_mandatory_config
+                .lock()
+                .unwrap()
+                .insert(agent_id.clone(), yaml_config.clone());
+        }
         pub fn assert_no_config_for_agent(&self, agent_id: &AgentID) {
             assert!(
                 self.load_remote_fallback_local(agent_id, &Capabilities::default())
@@ -150,6 +210,18 @@ pub mod tests {
                 .map(|config| Config::LocalConfig(config.get_yaml_config().clone().into())))
         }
 
+        fn load_local_mandatory(
+            &self,
+            agent_id: &AgentID,
+        ) -> Result<Option<YAMLConfig>, ConfigRepositoryError> {
+            Ok(self
+                .local_mandatory_config
+                .lock()
+                .unwrap()
+                .get(agent_id)
+                .cloned())
+        }
+
         fn load_remote(
             &self,
             agent_id: &AgentID,
@@ -6,15 +6,27 @@ use crate::agent_type::error::AgentTypeError;
 use crate::agent_type::templates::Templateable;
 use opamp_client::opamp::proto::AgentCapabilities;
 use opamp_client::operation::capabilities::Capabilities;
-use serde::{Deserialize, Serialize};
+use serde::{Deserialize, Serialize, Serializer};
 use serde_json::Value;
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 use thiserror::Error;
 
 /// The YAMLConfig represent any YAML config that the AgentControl can read and store.
 /// It enforces that the root of the tree is a hashmap and not an array or a single element.
+/// Keys are serialized in order, so semantically identical configs are always serialized the same.
 #[derive(Debug, PartialEq, Deserialize, Serialize, Default, Clone)]
-pub struct YAMLConfig(HashMap<String, serde_json::Value>);
+pub struct YAMLConfig(
+    #[serde(serialize_with = "serialize_sorted")] HashMap<String, serde_json::Value>,
+);
+
+/// Serializes a map with its keys in order. Nested [serde_json::Value] maps are already ordered.
+fn serialize_sorted<S, V>(map: &HashMap<String, V>, serializer: S) -> Result<S::Ok, S::Error>
+where
+    S: Serializer,
+    V: Serialize,
+{
+    map.iter().collect::<BTreeMap<_, _>>().serialize(serializer)
+}
 
 impl YAMLConfig {
     /// Returns true if the YAMLConfig is empty.
@@ -22,6 +34,16 @@ impl YAMLConfig {
         self.0.is_empty()
     }
 
+    /// Returns the value of a key of the YAMLConfig, if it exists.
+    pub fn get(&self, key: &str) -> Option<&Value> {
+        self.0.get(key)
+    }
+
+    /// Sets the value of a key of the YAMLConfig, returning the previous one if it existed.
+    pub fn insert(&mut self, key: &str, value: Value) -> Option<Value> {
+        self.0.insert(key.to_string(), value)
+    }
+
     /// Removes a key from the YAMLConfig returning it if it exists.
     pub fn remove_key(&mut self, key: &str) -> Option<Value> {
         self.0.remove(key)
@@ -62,6 +84,48 @@ impl YAMLConfig {
             result
         })
     }
+
+    /// Merges the provided [YAMLConfig] values recursively: mappings present in both are merged
+    /// key by key and any other `b` value takes precedence, so only the leaves set in `b` override
+    /// the ones of `a`.
+    ///
+    /// # Example
+    /// ```
+    /// # use newrelic_agent_control::values::yaml_config::YAMLConfig;
+    /// # use serde_json::json;
+    /// let a: YAMLConfig = serde_json::from_value(json!({"key1": "value1", "key2": {"x": "y", "z": "w"}})).unwrap();
+    /// let b: YAMLConfig = serde_json::from_value(json!({"key2": {"x": "new"}, "key3": "value3"})).unwrap();
+    /// let merged = YAMLConfig::merge_deep(a, b);
+    /// assert_eq!(merged, serde_json::from_value(json!({"key1": "value1", "key2": {"x": "new", "z": "w"}, "key3": "value3"})).unwrap());
+    /// ```
+    pub fn merge_deep(a: Self, b: Self) -> Self {
+        b.0.into_iter().fold(a, |mut result, (k, v)| {
+            match result.0.get_mut(&k) {
+                Some(existing) => merge_value(existing, v),
+                None => {
+                    result.0.insert(k, v);
+                }
+            }
+            result
+        })
+    }
+}
+
+/// Merges `other` into `value`, recursing into the mappings present in both.
+fn merge_value(value: &mut serde_json::Value, other: serde_json::Value) {
+    match (value, other) {
+        (serde_json::Value::Object(map), serde_json::Value::Object(other_map)) => {
+            for (k, v) in other_map {
+                match map.get_mut(&k) {
+                    Some(existing) => merge_value(existing, v),
+                    None => {
+                        map.insert(k, v);
+                    }
+                }
+            }
+        }
+        (value, other) => *value = other,
+    }
 }
 
 /// Error produced while building, merging, or (de)serializing a [`YAMLConfig`].
@@ -166,10 +230,6 @@ mod tests {
         pub(crate) fn new(values: HashMap<String, Value>) -> Self {
             Self(values)
         }
-
-        pub(crate) fn get(&self, key: &str) -> Option<&Value> {
-            self.0.get(key)
-        }
     }
 
     const EXAMPLE_CONFIG: &str = r#"
@@ -190,6 +250,18 @@ config:
 verbose: true
 "#;
 
+    #[test]
+    fn test_serialization_is_sorted() {
+        let expected = "alpha:\n  a: 2\n  z: 1\nbeta: true\nmid: value\nzeta: 1\n";
+        // Each parsed map has its own random hashing state
+        for _ in 0..10 {
+            let config =
+                YAMLConfig::try_from("zeta: 1\nalpha:\n  z: 1\n  a: 2\nmid: value\nbeta: true\n")
+                    .unwrap();
+            assert_eq!(String::try_from(config).unwrap(), expected);
+        }
+    }
+
     #[test]
     fn example_config() {
         let actual = serde_saphyr::from_str::<YAMLConfig>(EXAMPLE_CONFIG);
@@ -435,4 +507,37 @@ deployment: {}
         let result = YAMLConfig::merge_override(config_a, config_b);
         assert_eq!(result, expected_config);
     }
+
+    #[rstest]
+    #[case::no_overlapping_keys(
+        json!({"key1": "value1"}),
+        json!({"key2": "value2"}),
+        json!({"key1": "value1", "key2": "value2"})
+    )]
+    #[case::nested_leaves_override(
+        json!({"key1": {"x": "y", "z": {"a": 1, "b": 2}}}),
+        json!({"key1": {"z": {"b": 3}}}),
+        json!({"key1": {"x": "y", "z": {"a": 1, "b": 3}}})
+    )]
+    #[case::empty_mapping_keeps_values(
+        json!({"agents": {"rolldice": {"agent_type": "ns/name:0.0.1"}}}),
+        json!({"agents": {}}),
+        json!({"agents": {"rolldice": {"agent_type": "ns/name:0.0.1"}}})
+    )]
+    #[case::non_mapping_replaces(
+        json!({"key1": {"x": "y"}, "key2": ["a", "b"]}),
+        json!({"key1": "value1", "key2": ["c"]}),
+        json!({"key1": "value1", "key2": ["c"]})
+    )]
+    fn test_merge_deep(
+        #[case] a: serde_json::Value,
+        #[case] b: serde_json::Value,
+        #[case] expected: serde_json::Value,
+    ) {
+        let config_a = serde_json::from_value::<YAMLConfig>(a).unwrap();
+        let config_b = serde_json::from_value::<YAMLConfig>(b).unwrap();
+        let expected_config = serde_json::from_value::<YAMLConfig>(expected).unwrap();
+
+        assert_eq!(YAMLConfig::merge_deep(config_a, config_b), expected_config);
+    }
 }
@@ -0,0 +1,203 @@
+//! The [`YAMLDocument`] type, merging YAML documents by their top-level keys while keeping the
+//! text of each key.
+//!
+//! [`YAMLConfig`](super::yaml_config::YAMLConfig) holds parsed values, which keep neither the
+//! comments nor the anchors and aliases of the document. Merging documents as text instead keeps
+//! both in the merged configuration, as long as they don't cross the top-level keys that are
+//! overridden. Callers are expected to check that the merged text parses to the merged values.
+
+/// Document start marker, kept only once in the merged document.
+const DOCUMENT_START: &str = "---";
+
+/// A YAML document whose root is a block mapping, split into its top-level keys.
+#[derive(Debug, Default, Clone, PartialEq)]
+pub struct YAMLDocument {
+    /// Lines before the first key, like the document start marker and header comments.
+    header: String,
+    entries: Vec<Entry>,
+    /// Comments and blank lines after the last key.
+    footer: String,
+}
+
+/// A top-level key with its text: the comments preceding it, the key line and its nested lines.
+#[derive(Debug, Clone, PartialEq)]
+struct Entry {
+    key: String,
+    text: String,
+}
+
+impl YAMLDocument {
+    /// Splits `content` into its top-level keys. Returns `None` if the root of the document is not
+    /// a plain block mapping, e.g. a flow mapping or a multi-document stream.
+    pub fn parse(content: &str) -> Option<Self> {
+        let mut document = Self::default();
+        // Comments and blank lines belong to the next key, unless nested lines follow them.
+        let mut pending = String::new();
+
+        for line in content.split_inclusive('\n') {
+            let line = with_line_break(line);
+            let trimmed = line.trim_end();
+            if trimmed.is_empty() || trimmed.starts_with('#') {
+                pending.push_str(&line);
+            } else if line.starts_with([' ', '\t']) {
+                let entry = document.entries.last_mut()?;
+                entry.text.push_str(&pending);
+                entry.text.push_str(&line);
+                pending.clear();
+            } else if trimmed == DOCUMENT_START && document.entries.is_empty() {
+                document.header.push_str(&pending);
+                document.header.push_str(&line);
+                pending.clear();
+            } else {
+                let key = top_level_key(trimmed)?;
+                if document.entries.iter().any(|entry| entry.key == key) {
+                    return None;
+                }
+                if document.entries.is_empty() {
+                    document.header.push_str(&pending);
+                    pending.clear();
+                }
+                document.entries.push(Entry {
+                    key,
+                    text: std::mem::take(&mut pending) + &line,
+                });
+            }
+        }
+        document.footer = pending;
+        Some(document)
+    }
+
+    /// Merges `other` into `self` by top-level key: the text of the keys of `other` replaces the
+    /// one of the same keys of `self`, in place, and new keys are appended.
+    pub fn merge_override(mut self, other: Self) -> Self {
+        for line in other.header.split_inclusive('\n') {
+            if line.trim_end() != DOCUMENT_START {
+                self.header.push_str(line);
+            }
+        }
+        for entry in other.entries {
+            match self.entries.iter_mut().find(|e| e.key == entry.key) {
+                Some(existing) => *existing = entry,
+                None => self.entries.push(entry),
+            }
+        }
+        self.footer.push_str(&other.footer);
+        self
+    }
+}
+
+impl std::fmt::Display for YAMLDocument {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&self.header)?;
+        for entry in &self.entries {
+            f.write_str(&entry.text)?;
+        }
+        f.write_str(&self.footer)
+    }
+}
+
+fn with_line_break(line: &str) -> String {
+    if line.ends_with('\n') {
+        line.to_string()
+    } else {
+        format!("{line}\n")
+    }
+}
+
+/// Returns the key of a top-level `key: ...` line, unquoting it if needed.
+fn top_level_key(line: &str) -> Option<String> {
+    let (key, rest) = match line.chars().next()? {
+        quote @ ('"' | '\'') => {
+            let end = line[1..].find(quote)? + 1;
+            (&line[1..end], line[end + 1..].trim_start())
+        }
+        '-' | '?' | '{' | '[' | '&' | '*' | '!' | '|' | '>' | '%' | '@' | '`' => return None,
+        _ => {
+            let colon = line
+                .find(": ")
+                .or_else(|| line.ends_with(':').then(|| line.len() - 1))?;
+            (line[..colon].trim_end(), &line[colon..])
+        }
+    };
+    (rest.starts_with(':') && !key.is_empty()).then(|| key.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+
+    #[test]
+    fn test_parse_keeps_the_text() {
+        let content = r#"---
+# Agent Control config
+fleet_control: &fleet
+  # reviewed on 2026-01-10
+  endpoint: https://opamp.service.newrelic.com/v1/opamp
+
+"quoted key": value
+agents: {}
+# the end"#;
+        let document = YAMLDocument::parse(content).unwrap();
+
+        assert_eq!(
+            document
+                .entries
+                .iter()
+                .map(|entry| entry.key.as_str())
+                .collect::<Vec<_>>(),
+            vec!["fleet_control", "quoted key", "agents"]
+        );
+        assert_eq!(document.to_string(), format!("{content}\n"));
+    }
+
+    #[rstest]
+    #[case::flow_mapping("{a: 1}")]
+    #[case::sequence("- a\n- b\n")]
+    #[case::multi_document("a: 1\n---\nb: 2\n")]
+    #[case::nested_line_first("  a: 1\n")]
+    #[case::duplicated_key("a: 1\na: 2\n")]
+    fn test_parse_unsupported_documents(#[case] content: &str) {
+        assert_eq!(YAMLDocument::parse(content), None);
+    }
+
+    #[test]
+    fn test_merge_override_keeps_comments_and_anchors() {
+        let base = YAMLDocument::parse(
+            r#"---
+# base config
+log:
+  level: info # verbose enough
+proxy: &proxy http://proxy:8080
+server:
+  proxy: *proxy
+"#,
+        )
+        .unwrap();
+        let other = YAMLDocument::parse(
+            r#"---
+# overrides
+log:
+  # audited by ops
+  level: debug
+agents: {}
+"#,
+        )
+        .unwrap();
+
+        assert_eq!(
+            base.merge_override(other).to_string(),
+            r#"---
+# base config
+# overrides
+log:
+  # audited by ops
+  level: debug
+proxy: &proxy http://proxy:8080
+server:
+  proxy: *proxy
+agents: {}
+"#
+        );
+    }
+}
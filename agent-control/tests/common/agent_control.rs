@@ -13,6 +13,7 @@ use newrelic_agent_control::event::channel::{EventPublisher, pub_sub};
 use newrelic_agent_control::on_host::file_store::FileStore;
 use newrelic_agent_control::values::ConfigRepo;
 use std::sync::Arc;
+use std::time::{Duration, Instant};
 
 /// Starts the agent-control in a separate thread. The agent-control will be stopped when the `StartedAgentControl` is dropped.
 /// Take into account that some of the logic from main is not present here.
@@ -67,6 +68,7 @@ fn start_agent_control(
             running_mode: ac_running_mode,
             application_event_consumer,
             self_replace_target,
+            log_level_reloader: Default::default(),
         };
         let runner = AgentControlRunner::try_new(runner_context).unwrap();
         match ac_running_mode {
@@ -98,6 +100,27 @@ impl StartedAgentControl {
         }
         false
     }
+
+    /// Requests Agent Control to stop and waits up to `timeout` for it to finish. Fails if it didn't
+    /// stop in time, which means some of its threads are stuck, or if it stopped with an error.
+    pub fn stop_within(mut self, timeout: Duration) -> Result<GracefulShutdownReason, String> {
+        let _ = self
+            .application_event_publisher
+            .publish(ApplicationEvent::StopRequested);
+
+        let handle = self.handle.take().expect("handle should exist");
+        let deadline = Instant::now() + timeout;
+        while !handle.is_finished() {
+            if Instant::now() >= deadline {
+                return Err(format!("Agent Control didn't stop within {timeout:?}"));
+            }
+            std::thread::sleep(Duration::from_millis(100));
+        }
+        handle
+            .join()
+            .map_err(|_| "Agent Control panicked".to_string())?
+            .map_err(|err| format!("Agent Control exited with error: {err}"))
+    }
 }
 
 impl Drop for StartedAgentControl {
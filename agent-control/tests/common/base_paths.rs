@@ -18,6 +18,8 @@ impl Default for TempBasePaths {
             local_dir: local_dir.path().to_path_buf(),
             remote_dir: remote_dir.path().to_path_buf(),
             log_dir: log_dir.path().to_path_buf(),
+            ephemeral_dir: None,
+            config_file: None,
         };
         Self {
             base_paths,
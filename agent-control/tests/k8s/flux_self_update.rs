@@ -31,7 +31,7 @@ use newrelic_agent_control::agent_control::agent_id::AgentID;
 use newrelic_agent_control::agent_control::defaults::{
     AGENT_CONTROL_ID, AGENT_CONTROL_VERSION, OPAMP_AC_CHART_VERSION_ATTRIBUTE_KEY,
     OPAMP_AGENT_VERSION_ATTRIBUTE_KEY, OPAMP_CD_CHART_VERSION_ATTRIBUTE_KEY, OPAMP_SERVICE_NAME,
-    OPAMP_SERVICE_NAMESPACE, OPAMP_SUPERVISOR_KEY,
+    OPAMP_SERVICE_NAMESPACE, OPAMP_SUPERVISOR_KEY, RELEASE_CHANNEL_ATTRIBUTE_KEY,
 };
 use newrelic_agent_control::cli::k8s::install::flux::HELM_REPOSITORY_NAME;
 use opamp_client::opamp::proto::any_value::Value;
@@ -233,6 +233,10 @@ fn expected_identifying_attributes(
     cd_chart_version: &str,
 ) -> Vec<KeyValue> {
     convert_to_vec_key_value(Vec::from([
+        (
+            RELEASE_CHANNEL_ATTRIBUTE_KEY,
+            Value::StringValue("stable".to_string()),
+        ),
         (
             OPAMP_SUPERVISOR_KEY,
             Value::StringValue(AGENT_CONTROL_ID.to_string()),
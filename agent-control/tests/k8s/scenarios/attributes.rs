@@ -16,9 +16,11 @@ use newrelic_agent_control::agent_control::agent_id::AgentID;
 use newrelic_agent_control::agent_control::defaults::{
     AGENT_CONTROL_VERSION, CD_EXTERNAL_ENABLED_ATTRIBUTE_KEY,
     CD_REMOTE_UPDATE_ENABLED_ATTRIBUTE_KEY, CLUSTER_NAME_ATTRIBUTE_KEY, FLEET_ID_ATTRIBUTE_KEY,
-    HOST_NAME_ATTRIBUTE_KEY, OPAMP_AGENT_VERSION_ATTRIBUTE_KEY, OPAMP_SERVICE_NAME,
-    OPAMP_SERVICE_NAMESPACE, OPAMP_SERVICE_VERSION, OPAMP_SUBAGENT_CHART_VERSION_ATTRIBUTE_KEY,
-    OPAMP_SUPERVISOR_KEY, PARENT_AGENT_ID_ATTRIBUTE_KEY, default_capabilities,
+    HOST_NAME_ATTRIBUTE_KEY, INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY,
+    INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE, MANAGED_AGENTS_ATTRIBUTE_KEY,
+    OPAMP_AGENT_VERSION_ATTRIBUTE_KEY, OPAMP_SERVICE_NAME, OPAMP_SERVICE_NAMESPACE,
+    OPAMP_SERVICE_VERSION, OPAMP_SUBAGENT_CHART_VERSION_ATTRIBUTE_KEY, OPAMP_SUPERVISOR_KEY,
+    PARENT_AGENT_ID_ATTRIBUTE_KEY, RELEASE_CHANNEL_ATTRIBUTE_KEY, default_capabilities,
 };
 use newrelic_agent_control::agent_control::run::k8s::K8S_CONFIG_ONLY_AGENTS_CUSTOM_CAPABILITY;
 use newrelic_agent_control::opamp::remote_config::signature::SIGNATURE_CUSTOM_CAPABILITY;
@@ -78,6 +80,10 @@ agents:
     );
 
     let ac_expected_identifying_attributes = convert_to_vec_key_value(Vec::from([
+        (
+            RELEASE_CHANNEL_ATTRIBUTE_KEY,
+            Value::StringValue("stable".to_string()),
+        ),
         (
             OPAMP_SUPERVISOR_KEY,
             Value::StringValue("agent-control".to_string()),
@@ -100,6 +106,12 @@ agents:
         ),
     ]));
 
+    let sub_agent_instance_id = instance_id::get_instance_id(
+        k8s.client.clone(),
+        &namespace,
+        &AgentID::try_from("hello-world").unwrap(),
+    );
+
     let ac_expected_non_identifying_attributes = convert_to_vec_key_value(Vec::from([
         (
             HOST_NAME_ATTRIBUTE_KEY,
@@ -118,6 +130,14 @@ agents:
             CD_REMOTE_UPDATE_ENABLED_ATTRIBUTE_KEY,
             Value::BoolValue(false),
         ),
+        (
+            INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY,
+            Value::StringValue(INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE.to_string()),
+        ),
+        (
+            MANAGED_AGENTS_ATTRIBUTE_KEY,
+            Value::StringValue(format!("hello-world={sub_agent_instance_id}")),
+        ),
     ]));
 
     // Check attributes and capabilities of Agent Control. With cd_release_name set and
@@ -144,6 +164,10 @@ agents:
     });
 
     let sub_agent_expected_identifying_attributes = convert_to_vec_key_value(Vec::from([
+        (
+            RELEASE_CHANNEL_ATTRIBUTE_KEY,
+            Value::StringValue("stable".to_string()),
+        ),
         (
             OPAMP_SUPERVISOR_KEY,
             Value::StringValue("hello-world".to_string()),
@@ -175,6 +199,10 @@ agents:
             PARENT_AGENT_ID_ATTRIBUTE_KEY,
             BytesValue(instance_id.clone().into()),
         ),
+        (
+            INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY,
+            Value::StringValue(INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE.to_string()),
+        ),
     ]));
 
     // Check attributes and capabilities of sub agent. Sub-agents always advertise the default
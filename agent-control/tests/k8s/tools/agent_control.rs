@@ -61,6 +61,8 @@ pub fn start_agent_control(
             local_dir: local_dir.to_path_buf(),
             remote_dir: local_dir.join("remote").to_path_buf(),
             log_dir: local_dir.join("log").to_path_buf(),
+            ephemeral_dir: None,
+            config_file: None,
         },
         Environment::K8s,
     )
@@ -44,6 +44,7 @@ fn k8s_run_updater_for_cd_and_ac() {
         version: None,
         chart_version: Some(NEW_AC_VERSION.to_string()),
         cd_chart_version: Some(NEW_CD_VERSION.to_string()),
+        feature_flag_overrides: Default::default(),
     };
 
     let ac_dynamic_object = create_helm_release(
@@ -9,7 +9,7 @@ use newrelic_agent_control::agent_type::registry::remote::RemoteRegistry;
 use newrelic_agent_control::agent_type::registry::{AgentTypeRegistry, AgentTypeRegistryError};
 use newrelic_agent_control::agent_type::runtime_config::on_host::package::rendered::Repository;
 use newrelic_agent_control::environment::Environment;
-use newrelic_agent_control::http::config::ProxyConfig;
+use newrelic_agent_control::http::config::{EgressConfig, ProxyConfig};
 use newrelic_agent_control::oci;
 use oci_client::Reference;
 use oci_client::client::{ClientConfig, ClientProtocol};
@@ -67,6 +67,7 @@ fn remote_registry(
             ..Default::default()
         },
         ProxyConfig::default(),
+        EgressConfig::default(),
         tokio_runtime(),
     )
     .unwrap();
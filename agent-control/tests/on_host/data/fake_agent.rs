@@ -0,0 +1,95 @@
+//! Fake agent used by the integration tests instead of platform specific scripts.
+//!
+//! Supported arguments:
+//! - `--start-delay <secs>`: time to wait before starting, e.g. to simulate slow starts.
+//! - `--run-for <secs>`: time to run before exiting, it runs forever if not set.
+//! - `--exit-code <code>`: exit code returned after `--run-for`, defaults to 0.
+//! - `--health-port <port>`: serves `GET /health` on `127.0.0.1:<port>` while running.
+//! - `--health-status <code>`: HTTP status returned by the health endpoint, defaults to 200.
+//! - `--echo <message>`: message printed to stdout once started.
+use std::io::{Read, Write};
+use std::net::TcpListener;
+use std::thread;
+use std::time::Duration;
+
+struct Options {
+    start_delay: u64,
+    run_for: Option<u64>,
+    exit_code: i32,
+    health_port: Option<u16>,
+    health_status: u16,
+    echo: Option<String>,
+}
+
+fn main() {
+    let options = parse_args();
+    eprintln!(
+        "started fake_agent with args: {:?}",
+        std::env::args().collect::<Vec<_>>()
+    );
+
+    thread::sleep(Duration::from_secs(options.start_delay));
+    if let Some(message) = &options.echo {
+        println!("{message}");
+    }
+    if let Some(port) = options.health_port {
+        let status = options.health_status;
+        thread::spawn(move || serve_health(port, status));
+    }
+
+    match options.run_for {
+        Some(secs) => thread::sleep(Duration::from_secs(secs)),
+        None => loop {
+            thread::sleep(Duration::from_secs(60));
+        },
+    }
+    eprintln!("finished fake_agent with exit code {}", options.exit_code);
+    std::process::exit(options.exit_code);
+}
+
+fn parse_args() -> Options {
+    let mut options = Options {
+        start_delay: 0,
+        run_for: None,
+        exit_code: 0,
+        health_port: None,
+        health_status: 200,
+        echo: None,
+    };
+    let mut args = std::env::args().skip(1);
+    while let Some(arg) = args.next() {
+        let value = args
+            .next()
+            .unwrap_or_else(|| panic!("missing value for '{arg}'"));
+        match arg.as_str() {
+            "--start-delay" => options.start_delay = parse(&arg, &value),
+            "--run-for" => options.run_for = Some(parse(&arg, &value)),
+            "--exit-code" => options.exit_code = parse(&arg, &value),
+            "--health-port" => options.health_port = Some(parse(&arg, &value)),
+            "--health-status" => options.health_status = parse(&arg, &value),
+            "--echo" => options.echo = Some(value),
+            _ => panic!("unknown argument '{arg}'"),
+        }
+    }
+    options
+}
+
+fn parse<T: std::str::FromStr>(arg: &str, value: &str) -> T {
+    value
+        .parse()
+        .unwrap_or_else(|_| panic!("invalid value '{value}' for '{arg}'"))
+}
+
+fn serve_health(port: u16, status: u16) {
+    let listener = TcpListener::bind(("127.0.0.1", port)).expect("failed to bind health port");
+    for mut stream in listener.incoming().flatten() {
+        let mut request = [0; 1024];
+        let _ = stream.read(&mut request);
+        let body = if status < 300 { "healthy" } else { "unhealthy" };
+        let _ = write!(
+            stream,
+            "HTTP/1.1 {status} Status\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{body}",
+            body.len()
+        );
+    }
+}
@@ -1,2 +0,0 @@
-Start-Sleep -Seconds 2
-exit 1
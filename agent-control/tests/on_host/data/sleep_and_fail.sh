@@ -1,3 +0,0 @@
-#!/bin/sh
-sleep 2
-exit 1
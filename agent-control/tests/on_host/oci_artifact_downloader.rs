@@ -6,7 +6,7 @@ use newrelic_agent_control::agent_control::run::on_host::OCI_TEST_REGISTRY_URL;
 use newrelic_agent_control::agent_type::runtime_config::on_host::package::rendered::{
     Oci, Repository, Version,
 };
-use newrelic_agent_control::http::config::ProxyConfig;
+use newrelic_agent_control::http::config::{EgressConfig, ProxyConfig};
 use newrelic_agent_control::oci;
 use newrelic_agent_control::package::manager::PackageData;
 use newrelic_agent_control::package::oci::downloader::{
@@ -27,6 +27,7 @@ fn create_client_with_proxy(proxy_config: ProxyConfig) -> oci::Client {
             ..Default::default()
         },
         proxy_config,
+        EgressConfig::default(),
         tokio_runtime(),
     )
     .unwrap()
@@ -1,5 +1,7 @@
 mod ac_self_update;
+mod agent_harness;
 mod attributes;
+mod declarative;
 mod empty_config;
 mod file_logging;
 mod filesystem_ops;
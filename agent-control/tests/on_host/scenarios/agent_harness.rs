@@ -0,0 +1,72 @@
+use crate::common::http_port::available_port;
+use crate::on_host::tools::agent_harness::AgentHarness;
+use crate::on_host::tools::custom_agent_type::CustomAgentType;
+
+/// The agent becomes healthy once its health endpoint is served, and stopping Agent Control
+/// leaves no threads nor processes behind.
+#[test]
+fn test_harness_agent_becomes_healthy_and_stops_cleanly() {
+    let health_port = available_port();
+    let mut harness = AgentHarness::start(
+        |fake_agent| {
+            CustomAgentType::default()
+                .with_executables(Some(&format!(
+                    r#"[{{"id": "fake-agent", "path": '{}', "args": ["--start-delay", "2", "--health-port", "{health_port}"]}}]"#,
+                    fake_agent.display()
+                )))
+                .with_health(Some(&format!(
+                    r#"{{"interval": "1s", "initial_delay": "0s", "timeout": "1s", "http": {{"path": "/health", "port": {health_port}}}}}"#
+                )))
+        },
+        "fake_variable: value",
+    );
+
+    harness.wait_for_health(60, |health| health.healthy);
+    assert!(
+        !harness.fake_agent_pids().is_empty(),
+        "the fake agent should be running"
+    );
+    assert!(
+        harness.health_transitions().last().unwrap().healthy,
+        "unexpected health transitions: {:?}",
+        harness.health_transitions()
+    );
+
+    harness.stop();
+}
+
+/// An agent exceeding its restart policy is reported unhealthy, and applying a remote config
+/// starts it again.
+#[test]
+fn test_harness_agent_health_transitions() {
+    let mut harness = AgentHarness::start(
+        |fake_agent| {
+            CustomAgentType::default()
+                .with_executables(Some(&format!(
+                    r#"[{{"id": "fake-agent", "path": '{}', "args": ["--run-for", "${{nr-var:fake_variable}}", "--exit-code", "1"],
+                         "restart_policy": {{"backoff_strategy": {{"type": "fixed", "backoff_delay": "1s", "max_retries": 1}}}}}}]"#,
+                    fake_agent.display()
+                )))
+                .with_health(Some(r#"{"interval": "1s", "initial_delay": "0s"}"#))
+        },
+        "fake_variable: '1'",
+    );
+
+    harness.wait_for_health(60, |health| {
+        !health.healthy && health.last_error.contains("Restart policy exceeded")
+    });
+
+    let instance_id = harness.instance_id().clone();
+    harness
+        .opamp_server()
+        .set_config_response(instance_id, "fake_variable: '3600'");
+    harness.wait_for_health(60, |health| health.healthy);
+
+    let transitions = harness.health_transitions();
+    assert!(
+        transitions.iter().any(|health| !health.healthy) && transitions.last().unwrap().healthy,
+        "unexpected health transitions: {transitions:?}"
+    );
+
+    harness.stop();
+}
@@ -13,9 +13,11 @@ use crate::on_host::tools::oci_package_manager::push_test_package;
 use fake_opamp_server::FakeServer;
 use newrelic_agent_control::agent_control::agent_id::AgentID;
 use newrelic_agent_control::agent_control::defaults::{
-    AGENT_CONTROL_NAMESPACE, HOST_NAME_ATTRIBUTE_KEY, OPAMP_AGENT_VERSION_ATTRIBUTE_KEY,
-    OPAMP_SERVICE_NAME, OPAMP_SERVICE_NAMESPACE, OPAMP_SERVICE_VERSION, OPAMP_SUPERVISOR_KEY,
-    OS_ATTRIBUTE_KEY, OS_ATTRIBUTE_VALUE, PARENT_AGENT_ID_ATTRIBUTE_KEY,
+    AGENT_CONTROL_NAMESPACE, HOST_ID_ATTRIBUTE_KEY, HOST_NAME_ATTRIBUTE_KEY,
+    INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY, INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE,
+    OPAMP_AGENT_VERSION_ATTRIBUTE_KEY, OPAMP_SERVICE_NAME, OPAMP_SERVICE_NAMESPACE,
+    OPAMP_SERVICE_VERSION, OPAMP_SUPERVISOR_KEY, OS_ATTRIBUTE_KEY, OS_ATTRIBUTE_VALUE,
+    PARENT_AGENT_ID_ATTRIBUTE_KEY, RELEASE_CHANNEL_ATTRIBUTE_KEY,
 };
 use newrelic_agent_control::agent_control::run::on_host::{
     AGENT_CONTROL_MODE_ON_HOST, OCI_TEST_REGISTRY_URL,
@@ -58,6 +60,10 @@ fn test_attributes_from_non_existing_agent_type() {
         get_instance_id(&AgentID::try_from(agent_id).unwrap(), dirs.base_paths());
 
     let expected_identifying_attributes = convert_to_vec_key_value(Vec::from([
+        (
+            RELEASE_CHANNEL_ATTRIBUTE_KEY,
+            Value::StringValue("stable".to_string()),
+        ),
         (
             OPAMP_SERVICE_NAMESPACE,
             Value::StringValue(DEFAULT_NAMESPACE.to_string()),
@@ -85,10 +91,18 @@ fn test_attributes_from_non_existing_agent_type() {
             HOST_NAME_ATTRIBUTE_KEY,
             Value::StringValue(get_hostname().unwrap_or_default()),
         ),
+        (
+            HOST_ID_ATTRIBUTE_KEY,
+            Value::StringValue("integration-test".to_string()),
+        ),
         (
             PARENT_AGENT_ID_ATTRIBUTE_KEY,
             BytesValue(ac_instance_id.clone().into()),
         ),
+        (
+            INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY,
+            Value::StringValue(INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE.to_string()),
+        ),
     ]));
 
     retry(30, Duration::from_secs(1), || {
@@ -222,6 +236,10 @@ agents:
         get_instance_id(&AgentID::try_from(agent_id).unwrap(), dirs.base_paths());
 
     let expected_identifying_attributes = convert_to_vec_key_value(Vec::from([
+        (
+            RELEASE_CHANNEL_ATTRIBUTE_KEY,
+            Value::StringValue("stable".to_string()),
+        ),
         (
             OPAMP_SERVICE_NAMESPACE,
             Value::StringValue(AGENT_CONTROL_NAMESPACE.to_string()),
@@ -253,10 +271,18 @@ agents:
             HOST_NAME_ATTRIBUTE_KEY,
             Value::StringValue(get_hostname().unwrap_or_default()),
         ),
+        (
+            HOST_ID_ATTRIBUTE_KEY,
+            Value::StringValue("integration-test".to_string()),
+        ),
         (
             PARENT_AGENT_ID_ATTRIBUTE_KEY,
             BytesValue(ac_instance_id.into()),
         ),
+        (
+            INSTRUMENTATION_PROVIDER_ATTRIBUTE_KEY,
+            Value::StringValue(INSTRUMENTATION_PROVIDER_ATTRIBUTE_VALUE.to_string()),
+        ),
     ]));
 
     retry(30, Duration::from_secs(1), || {
@@ -0,0 +1,13 @@
+//! Scenarios described in `tests/on_host/data/scenarios`, see
+//! [crate::on_host::tools::scenario_runner].
+use crate::on_host::tools::scenario_runner::run_scenario;
+
+#[test]
+fn onhost_scenario_remote_config_applied() {
+    run_scenario("remote_config_applied.yaml");
+}
+
+#[test]
+fn onhost_scenario_remote_config_rollback() {
+    run_scenario("remote_config_rollback.yaml");
+}
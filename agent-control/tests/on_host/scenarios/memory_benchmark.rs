@@ -5,6 +5,7 @@ use crate::common::retry::retry;
 use crate::common::runtime::tokio_runtime;
 use crate::on_host::tools::config::OnHostAgentControlConfigBuilder;
 use crate::on_host::tools::custom_agent_type::CustomAgentType;
+use crate::on_host::tools::fake_binary::build_fake_agent_binary;
 use crate::on_host::tools::instance_id::get_instance_id;
 use fake_opamp_server::FakeServer;
 use memory_stats::memory_stats;
@@ -20,6 +21,7 @@ fn test_memory_on_agent_substitution_and_version_update() {
     let max_memory_limit = 150 * 1024 * 1024;
 
     let dirs = TempBasePaths::default();
+    let (_fake_agent_dir, fake_agent) = build_fake_agent_binary();
     let agent_id = "nr-sleep-agent";
 
     let packages_config = format!(
@@ -36,11 +38,12 @@ fn test_memory_on_agent_substitution_and_version_update() {
 
     // Add custom agent_type to registry
     let sleep_agent_type = CustomAgentType::default()
-        .with_executables(Some(
+        .with_executables(Some(&format!(
             r#"[
-                {"id": "trap-term-sleep", "path": "sh", "args": ["tests/on_host/data/sleep_60.sh"]},
+                {{"id": "trap-term-sleep", "path": '{}'}},
             ]"#,
-        ))
+            fake_agent.display()
+        )))
         .with_packages(Some(&packages_config))
         .build(dirs.local_dir());
 
@@ -7,7 +7,7 @@ use crate::{
     },
     on_host::tools::{
         config::OnHostAgentControlConfigBuilder, custom_agent_type::CustomAgentType,
-        instance_id::get_instance_id,
+        fake_binary::build_fake_agent_binary, instance_id::get_instance_id,
     },
 };
 use fake_opamp_server::FakeServer;
@@ -20,15 +20,17 @@ fn onhost_subagent_multiple_executables_some_failed_launching() {
     let mut opamp_server = FakeServer::start(tokio_runtime().handle());
 
     let dirs = TempBasePaths::default();
+    let (_fake_agent_dir, fake_agent) = build_fake_agent_binary();
 
     // Add custom agent_type to registry
     let sleep_agent_type = CustomAgentType::default()
-        .with_executables(Some(
+        .with_executables(Some(&format!(
             r#"[
-                {"id": "trap-term-sleep", "path": "sh", "args": ["tests/on_host/data/sleep_60.sh"]},
-                {"id": "unknown", "path": "unknown-command"}
+                {{"id": "trap-term-sleep", "path": '{}'}},
+                {{"id": "unknown", "path": "unknown-command"}}
             ]"#,
-        ))
+            fake_agent.display()
+        )))
         .with_health(Some(r#"{"interval": "1s", "initial_delay": "2s"}"#))
         .build(dirs.local_dir());
 
@@ -66,17 +68,19 @@ fn onhost_subagent_multiple_executables_some_commands_failed_after_max_retries()
     let mut opamp_server = FakeServer::start(tokio_runtime().handle());
 
     let dirs = TempBasePaths::default();
+    let (_fake_agent_dir, fake_agent) = build_fake_agent_binary();
 
     // Add custom agent_type to registry
     let sleep_agent_type = CustomAgentType::default()
-        .with_executables(Some(
+        .with_executables(Some(&format!(
             r#"[
-                {"id": "trap-term-sleep", "path": "sh", "args": ["tests/on_host/data/sleep_60.sh"]},
-                {"id": "failing-process", "path": "sh", args: ["tests/on_host/data/sleep_and_fail.sh"],
-                 "restart_policy": {"backoff_strategy": {"type": "fixed", "backoff_delay": "1s", "max_retries": 2}}
-                }
+                {{"id": "trap-term-sleep", "path": '{fake_agent}'}},
+                {{"id": "failing-process", "path": '{fake_agent}', "args": ["--run-for", "2", "--exit-code", "1"],
+                 "restart_policy": {{"backoff_strategy": {{"type": "fixed", "backoff_delay": "1s", "max_retries": 2}}}}
+                }}
             ]"#,
-        ))
+            fake_agent = fake_agent.display()
+        )))
         .with_health(Some(r#"{"interval": "1s", "initial_delay": "2s"}"#))
         .build(dirs.local_dir());
 
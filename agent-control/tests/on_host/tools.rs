@@ -1,7 +1,9 @@
+pub mod agent_harness;
 pub mod config;
 pub mod custom_agent_type;
 pub mod fake_binary;
 pub mod instance_id;
 pub mod oci_package_manager;
+pub mod scenario_runner;
 #[cfg(target_family = "windows")]
 pub mod windows_process;
@@ -0,0 +1,169 @@
+use crate::common::agent_control::{StartedAgentControl, start_agent_control_with_custom_config};
+use crate::common::base_paths::TempBasePaths;
+use crate::common::process_finder::find_processes_by_pattern;
+use crate::common::retry::retry;
+use crate::common::runtime::tokio_runtime;
+use crate::on_host::tools::config::{OnHostAgentControlConfigBuilder, create_local_config};
+use crate::on_host::tools::custom_agent_type::CustomAgentType;
+use crate::on_host::tools::fake_binary::build_fake_agent_binary;
+use crate::on_host::tools::instance_id::get_instance_id;
+use fake_opamp_server::FakeServer;
+use newrelic_agent_control::agent_control::agent_id::AgentID;
+use newrelic_agent_control::agent_control::run::on_host::AGENT_CONTROL_MODE_ON_HOST;
+use newrelic_agent_control::opamp::instance_id::InstanceID;
+use opamp_client::opamp::proto::ComponentHealth;
+use std::path::{Path, PathBuf};
+use std::thread;
+use std::time::Duration;
+use tempfile::TempDir;
+
+/// Id of the agent run by the [AgentHarness].
+pub const HARNESS_AGENT_ID: &str = "agent-under-test";
+/// Time Agent Control has to stop before its threads are considered leaked.
+const STOP_TIMEOUT: Duration = Duration::from_secs(30);
+/// Time the executables have to finish once Agent Control stopped.
+const PROCESS_CLEANUP_TIMEOUT: Duration = Duration::from_secs(10);
+
+/// Runs a single agent type under Agent Control against the fake OpAMP server, so new agent types
+/// and sub-agent features can be tested end to end with a few lines:
+///
+/// - The agent type is built from the path of the fake agent binary (see `data/fake_agent.rs`),
+///   which is compiled into a directory owned by the harness, so its processes can be told apart
+///   from the ones of other tests running in parallel.
+/// - [AgentHarness::wait_for_health] records every health status reported to the server, and
+///   [AgentHarness::health_transitions] returns them to assert how the health evolved.
+/// - [AgentHarness::stop] fails if Agent Control doesn't stop in time (some thread is stuck),
+///   stops with an error, or leaves any fake agent process behind.
+pub struct AgentHarness {
+    // Dropped first, so Agent Control stops before its directories are removed.
+    agent_control: StartedAgentControl,
+    opamp_server: FakeServer,
+    _dirs: TempBasePaths,
+    _fake_agent_dir: TempDir,
+    fake_agent: PathBuf,
+    instance_id: InstanceID,
+    health_transitions: Vec<ComponentHealth>,
+}
+
+impl AgentHarness {
+    /// Starts Agent Control running the agent type returned by `agent_type`, which receives the
+    /// path of the fake agent binary, with the provided local `values`.
+    pub fn start(agent_type: impl FnOnce(&Path) -> CustomAgentType, values: &str) -> Self {
+        Self::start_with_config(agent_type, values, "")
+    }
+
+    /// Like [AgentHarness::start], adding the top-level fields in `extra_config` to the Agent
+    /// Control config.
+    pub fn start_with_config(
+        agent_type: impl FnOnce(&Path) -> CustomAgentType,
+        values: &str,
+        extra_config: &str,
+    ) -> Self {
+        let opamp_server = FakeServer::start(tokio_runtime().handle());
+        let dirs = TempBasePaths::default();
+        let (fake_agent_dir, fake_agent) = build_fake_agent_binary();
+
+        let agent_type = agent_type(&fake_agent).build(dirs.local_dir());
+        OnHostAgentControlConfigBuilder::new(opamp_server.endpoint(), opamp_server.jwks_endpoint())
+            .with_agents(format!(
+                r#"
+  {HARNESS_AGENT_ID}:
+    agent_type: "{agent_type}"
+"#
+            ))
+            .with_extra_config(extra_config)
+            .write(dirs.local_dir());
+        create_local_config(HARNESS_AGENT_ID, values, dirs.local_dir());
+
+        let agent_control =
+            start_agent_control_with_custom_config(dirs.base_paths(), AGENT_CONTROL_MODE_ON_HOST);
+        let instance_id = get_instance_id(
+            &AgentID::try_from(HARNESS_AGENT_ID).unwrap(),
+            dirs.base_paths(),
+        );
+
+        Self {
+            agent_control,
+            opamp_server,
+            _dirs: dirs,
+            _fake_agent_dir: fake_agent_dir,
+            fake_agent,
+            instance_id,
+            health_transitions: Vec::new(),
+        }
+    }
+
+    /// Fake OpAMP server the agent reports to, e.g. to send remote configs.
+    pub fn opamp_server(&mut self) -> &mut FakeServer {
+        &mut self.opamp_server
+    }
+
+    /// Instance id of the agent.
+    pub fn instance_id(&self) -> &InstanceID {
+        &self.instance_id
+    }
+
+    /// Waits up to `max_attempts` seconds for the health reported for the agent to match `check`,
+    /// recording the health statuses seen meanwhile.
+    pub fn wait_for_health(
+        &mut self,
+        max_attempts: usize,
+        check: impl Fn(&ComponentHealth) -> bool,
+    ) -> ComponentHealth {
+        retry(max_attempts, Duration::from_secs(1), || {
+            let health = self
+                .opamp_server
+                .get_health_status(self.instance_id.clone())
+                .ok_or("Health status not available")?;
+            record_health(&mut self.health_transitions, &health);
+            if check(&health) {
+                Ok(health)
+            } else {
+                Err(
+                    format!("The latest health status didn't match the expectations: {health:?}")
+                        .into(),
+                )
+            }
+        })
+    }
+
+    /// Distinct health statuses reported for the agent, in the order they were seen.
+    pub fn health_transitions(&self) -> &[ComponentHealth] {
+        &self.health_transitions
+    }
+
+    /// Pids of the running fake agent processes started by this harness.
+    pub fn fake_agent_pids(&self) -> Vec<String> {
+        find_processes_by_pattern(&self.fake_agent.to_string_lossy())
+    }
+
+    /// Stops Agent Control, panicking if it leaks threads or processes.
+    pub fn stop(self) {
+        if let Err(err) = self.agent_control.stop_within(STOP_TIMEOUT) {
+            panic!("{err}");
+        }
+
+        let fake_agent = self.fake_agent.to_string_lossy();
+        let mut pids = find_processes_by_pattern(&fake_agent);
+        for _ in 0..PROCESS_CLEANUP_TIMEOUT.as_secs() {
+            if pids.is_empty() {
+                return;
+            }
+            thread::sleep(Duration::from_secs(1));
+            pids = find_processes_by_pattern(&fake_agent);
+        }
+        panic!("Expected no agent processes after Agent Control stopped, found: {pids:?}");
+    }
+}
+
+/// Records `health` unless it matches the last recorded one.
+fn record_health(health_transitions: &mut Vec<ComponentHealth>, health: &ComponentHealth) {
+    let changed = health_transitions.last().is_none_or(|last| {
+        last.healthy != health.healthy
+            || last.status != health.status
+            || last.last_error != health.last_error
+    });
+    if changed {
+        health_transitions.push(health.clone());
+    }
+}
@@ -24,6 +24,7 @@ pub struct OnHostAgentControlConfigBuilder {
     proxy: Option<String>,
     self_update: Option<SelfUpdateConfig>,
     agent_types: Option<AgentTypes>,
+    extra_config: Option<String>,
 }
 
 struct SelfUpdateConfig {
@@ -48,6 +49,7 @@ impl OnHostAgentControlConfigBuilder {
             proxy: None,
             self_update: None,
             agent_types: None,
+            extra_config: None,
         }
     }
 
@@ -106,6 +108,12 @@ impl OnHostAgentControlConfigBuilder {
     }
 
     #[allow(dead_code)]
+    /// Appends top-level fields, like `remote_config_status`, to the generated config.
+    pub fn with_extra_config(mut self, extra_config: impl Into<String>) -> Self {
+        self.extra_config = Some(extra_config.into());
+        self
+    }
+
     pub fn with_proxy(mut self, proxy: impl Into<String>) -> Self {
         self.proxy = Some(proxy.into());
         self
@@ -168,6 +176,8 @@ impl OnHostAgentControlConfigBuilder {
             })
             .unwrap_or_default();
 
+        let extra_config = self.extra_config.unwrap_or_default();
+
         let agent_control_config = format!(
             r#"
 host_id: integration-test
@@ -178,6 +188,7 @@ host_id: integration-test
 {status_server_config}
 {self_update_config}
 {agent_types_config}
+{extra_config}
 "#,
         );
 
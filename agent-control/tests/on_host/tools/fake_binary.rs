@@ -7,6 +7,11 @@ const FAKE_AC_BINARY_NAME: &str = "newrelic-agent-control";
 #[cfg(target_family = "windows")]
 const FAKE_AC_BINARY_NAME: &str = "newrelic-agent-control.exe";
 
+#[cfg(target_family = "unix")]
+const FAKE_AGENT_BINARY_NAME: &str = "fake-agent";
+#[cfg(target_family = "windows")]
+const FAKE_AGENT_BINARY_NAME: &str = "fake-agent.exe";
+
 /// Compiles `tests/on_host/data/fake_ac.rs` into a temporary directory and returns
 /// both the directory (which must be kept alive) and the path to the binary.
 pub fn build_fake_ac_binary() -> (TempDir, PathBuf) {
@@ -18,14 +23,26 @@ pub fn build_invalid_fake_ac_binary() -> (TempDir, PathBuf) {
     compile_fake_binary("fake_ac_invalid.rs")
 }
 
+/// Compiles `tests/on_host/data/fake_agent.rs`, a fake agent supporting slow starts, crashes and
+/// health endpoints, so tests don't depend on platform specific scripts.
+/// Check the source file for the supported arguments.
+pub fn build_fake_agent_binary() -> (TempDir, PathBuf) {
+    compile_binary("fake_agent.rs", FAKE_AGENT_BINARY_NAME)
+}
+
 fn compile_fake_binary(src_file: &str) -> (TempDir, PathBuf) {
+    compile_binary(src_file, FAKE_AC_BINARY_NAME)
+}
+
+fn compile_binary(src_file: &str, binary_name: &str) -> (TempDir, PathBuf) {
     let dir = tempfile::tempdir().expect("failed to create temp dir for fake binary");
-    let binary_path = dir.path().join(FAKE_AC_BINARY_NAME);
+    let binary_path = dir.path().join(binary_name);
     let src = std::path::Path::new(env!("CARGO_MANIFEST_DIR"))
         .join("tests/on_host/data")
         .join(src_file);
     let status = std::process::Command::new("rustc")
         .arg(&src)
+        .args(["--edition", "2021"])
         .arg("-o")
         .arg(&binary_path)
         .env("FAKE_AC_TEST_ID", current_test_id())
@@ -4,7 +4,7 @@ use flate2::write::GzEncoder;
 use fs::directory_manager::DirectoryManagerFs;
 use newrelic_agent_control::{
     agent_control::config::Registry,
-    http::config::ProxyConfig,
+    http::config::{EgressConfig, ProxyConfig},
     oci,
     package::oci::{downloader::OCIPackageArtifactDownloader, package_manager::OCIPackageManager},
 };
@@ -25,6 +25,7 @@ pub fn new_testing_oci_package_manager(
             ..Default::default()
         },
         ProxyConfig::default(),
+        EgressConfig::default(),
         tokio_runtime(),
     )
     .unwrap();
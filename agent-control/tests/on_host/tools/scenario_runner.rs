@@ -0,0 +1,207 @@
+//! Runs end-to-end scenarios described in YAML on top of the [AgentHarness].
+//!
+//! Scenarios live in `tests/on_host/data/scenarios`. Each one defines the agent type to run, with
+//! `<fake_agent>` standing for the path of the fake agent binary, its local values and the steps to
+//! execute in order. Steps waiting for something poll every second, so scenarios don't need sleeps:
+//!
+//! ```yaml
+//! agent_control_config: |
+//!   remote_config_status:
+//!     health_grace_period: 5s
+//!     rollback_on_failure: true
+//! agent_type:
+//!   executables:
+//!     - id: fake-agent
+//!       path: '<fake_agent>'
+//!       args: ["--run-for", "${nr-var:fake_variable}", "--exit-code", "1"]
+//!   health:
+//!     interval: 1s
+//! values: "fake_variable: '3600'"
+//! steps:
+//!   - expect_running
+//!   - send_config: "fake_variable: '1'"
+//!   - expect_config_status: failed
+//!   - expect_health:
+//!       healthy: true
+//! ```
+use crate::common::remote_config_status::check_latest_remote_config_status_is_expected;
+use crate::common::retry::{retry, retry_never};
+use crate::on_host::tools::agent_harness::AgentHarness;
+use crate::on_host::tools::custom_agent_type::CustomAgentType;
+use duration_str::deserialize_duration;
+use opamp_client::opamp::proto::RemoteConfigStatuses;
+use serde::Deserialize;
+use std::path::Path;
+use std::time::Duration;
+
+/// Placeholder replaced by the path of the fake agent binary.
+const FAKE_AGENT_PLACEHOLDER: &str = "<fake_agent>";
+/// Attempts, one per second, of the steps waiting for something.
+const MAX_ATTEMPTS: usize = 60;
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct Scenario {
+    /// Top-level fields added to the Agent Control config.
+    #[serde(default)]
+    agent_control_config: String,
+    agent_type: ScenarioAgentType,
+    /// Local values of the agent.
+    #[serde(default)]
+    values: String,
+    steps: Vec<Step>,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct ScenarioAgentType {
+    executables: Option<serde_json::Value>,
+    health: Option<serde_json::Value>,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "snake_case")]
+enum Step {
+    /// Sends the remote config to the agent.
+    SendConfig(String),
+    /// Waits for the agent to report the remote config status.
+    ExpectConfigStatus(ConfigStatus),
+    /// Waits for the agent to report a matching health.
+    ExpectHealth(ExpectedHealth),
+    /// Waits for the fake agent to be running.
+    ExpectRunning,
+    /// Waits for the fake agent to be running with a different process than the last one seen.
+    ExpectRestart,
+    /// Checks the fake agent keeps running with the same process during the provided time.
+    ExpectNoRestart(#[serde(deserialize_with = "deserialize_duration")] Duration),
+}
+
+#[derive(Debug, Deserialize, Clone, Copy)]
+#[serde(rename_all = "snake_case")]
+enum ConfigStatus {
+    Applying,
+    Applied,
+    Failed,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct ExpectedHealth {
+    healthy: bool,
+    last_error_contains: Option<String>,
+}
+
+/// Runs the scenario in `tests/on_host/data/scenarios/<name>`, panicking on the first step that
+/// fails, and checks Agent Control stops without leaking threads or processes.
+pub fn run_scenario(name: &str) {
+    let path = Path::new(env!("CARGO_MANIFEST_DIR"))
+        .join("tests/on_host/data/scenarios")
+        .join(name);
+    let content = std::fs::read_to_string(&path)
+        .unwrap_or_else(|err| panic!("reading scenario {}: {err}", path.display()));
+
+    let scenario: Scenario = serde_saphyr::from_str(&content)
+        .unwrap_or_else(|err| panic!("invalid scenario {name}: {err}"));
+
+    let mut harness = AgentHarness::start_with_config(
+        |fake_agent| {
+            let fake_agent = fake_agent.to_string_lossy();
+            let with_fake_agent = |value: &serde_json::Value| {
+                serde_json::to_string(value)
+                    .unwrap()
+                    .replace(FAKE_AGENT_PLACEHOLDER, &fake_agent)
+            };
+            CustomAgentType::default()
+                .with_executables(
+                    scenario
+                        .agent_type
+                        .executables
+                        .as_ref()
+                        .map(with_fake_agent)
+                        .as_deref(),
+                )
+                .with_health(
+                    scenario
+                        .agent_type
+                        .health
+                        .as_ref()
+                        .map(with_fake_agent)
+                        .as_deref(),
+                )
+        },
+        &scenario.values,
+        &scenario.agent_control_config,
+    );
+
+    let mut last_pids = Vec::new();
+    for (index, step) in scenario.steps.iter().enumerate() {
+        eprintln!("scenario {name}, step {index}: {step:?}");
+        run_step(&mut harness, step, &mut last_pids);
+    }
+
+    harness.stop();
+}
+
+fn run_step(harness: &mut AgentHarness, step: &Step, last_pids: &mut Vec<String>) {
+    match step {
+        Step::SendConfig(config) => {
+            let instance_id = harness.instance_id().clone();
+            harness
+                .opamp_server()
+                .set_config_response(instance_id, config.as_str());
+        }
+        Step::ExpectConfigStatus(expected) => {
+            let expected = match expected {
+                ConfigStatus::Applying => RemoteConfigStatuses::Applying,
+                ConfigStatus::Applied => RemoteConfigStatuses::Applied,
+                ConfigStatus::Failed => RemoteConfigStatuses::Failed,
+            };
+            let instance_id = harness.instance_id().clone();
+            retry(MAX_ATTEMPTS, Duration::from_secs(1), || {
+                check_latest_remote_config_status_is_expected(
+                    harness.opamp_server(),
+                    &instance_id,
+                    expected as i32,
+                )
+            });
+        }
+        Step::ExpectHealth(expected) => {
+            harness.wait_for_health(MAX_ATTEMPTS, |health| {
+                health.healthy == expected.healthy
+                    && expected
+                        .last_error_contains
+                        .as_ref()
+                        .is_none_or(|error| health.last_error.contains(error))
+            });
+        }
+        Step::ExpectRunning => {
+            *last_pids = retry(MAX_ATTEMPTS, Duration::from_secs(1), || {
+                let pids = harness.fake_agent_pids();
+                if pids.is_empty() {
+                    return Err("the fake agent is not running".into());
+                }
+                Ok(pids)
+            });
+        }
+        Step::ExpectRestart => {
+            *last_pids = retry(MAX_ATTEMPTS, Duration::from_secs(1), || {
+                let pids = harness.fake_agent_pids();
+                if pids.is_empty() || pids.iter().any(|pid| last_pids.contains(pid)) {
+                    return Err(format!("the fake agent was not restarted: {pids:?}").into());
+                }
+                Ok(pids)
+            });
+        }
+        Step::ExpectNoRestart(duration) => {
+            retry_never(duration.as_secs() as usize, Duration::from_secs(1), || {
+                let pids = harness.fake_agent_pids();
+                if pids != *last_pids {
+                    return Err(
+                        format!("the fake agent was restarted: {last_pids:?} -> {pids:?}").into(),
+                    );
+                }
+                Ok(())
+            });
+        }
+    }
+}
@@ -15,6 +15,8 @@ pub const AZURE_INSTANCE_ID: &str = "azure_instance_id";
 pub const GCP_INSTANCE_ID: &str = "gcp_instance_id";
 /// CLOUD_INSTANCE_ID represents the key attribute for generic cloud instance id
 pub const CLOUD_INSTANCE_ID: &str = "cloud_instance_id";
+/// CLOUD_REGION represents the key attribute for the region of the cloud instance, if known
+pub const CLOUD_REGION: &str = "cloud_region";
 /// CLOUD_TYPE represents the key attribute for cloud type, ex: aws, azure
 pub const CLOUD_TYPE: &str = "cloud_type";
 /// CLOUD_TYPE_AWS is a constant fow aws
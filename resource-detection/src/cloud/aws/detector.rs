@@ -1,6 +1,7 @@
 //! AWS EC2 instance id detector implementation
 
 use super::metadata::AWSMetadata;
+use crate::cloud::CLOUD_REGION;
 use crate::cloud::aws::http_client::AWSHttpClient;
 use crate::cloud::http_client::{HttpClient, HttpClientError};
 use crate::{DetectError, Detector, Key, Resource, Value, cloud::AWS_INSTANCE_ID};
@@ -56,10 +57,13 @@ where
         let metadata: AWSMetadata =
             serde_json::from_slice(response.body()).map_err(AWSDetectorError::JsonError)?;
 
-        Ok(Resource::new([(
-            Key::from(AWS_INSTANCE_ID),
-            Value::from(metadata.instance_id),
-        )]))
+        Ok(Resource::new([
+            (
+                Key::from(AWS_INSTANCE_ID),
+                Value::from(metadata.instance_id),
+            ),
+            (Key::from(CLOUD_REGION), Value::from(metadata.region)),
+        ]))
     }
 }
 
@@ -123,6 +127,10 @@ mod tests {
         assert_eq!(
             "i-1234567890abcdef0".to_string(),
             String::from(identifiers.get(AWS_INSTANCE_ID.into()).unwrap())
+        );
+        assert_eq!(
+            "us-west-2".to_string(),
+            String::from(identifiers.get(CLOUD_REGION.into()).unwrap())
         )
     }
 
@@ -4,4 +4,6 @@ use serde::Deserialize;
 pub(super) struct AWSMetadata {
     #[serde(rename = "instanceId")]
     pub(super) instance_id: String,
+    #[serde(default)]
+    pub(super) region: String,
 }
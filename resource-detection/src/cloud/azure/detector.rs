@@ -1,5 +1,6 @@
 //! Azure EC2 instance id detector implementation
 use super::metadata::AzureMetadata;
+use crate::cloud::CLOUD_REGION;
 use crate::cloud::http_client::{HttpClient, HttpClientError};
 use crate::{DetectError, Detector, Key, Resource, Value, cloud::AZURE_INSTANCE_ID};
 use http::HeaderMap;
@@ -65,10 +66,16 @@ where
         let metadata: AzureMetadata =
             serde_json::from_slice(response.body()).map_err(AzureDetectorError::JsonError)?;
 
-        Ok(Resource::new([(
-            Key::from(AZURE_INSTANCE_ID),
-            Value::from(metadata.compute.instance_id),
-        )]))
+        Ok(Resource::new([
+            (
+                Key::from(AZURE_INSTANCE_ID),
+                Value::from(metadata.compute.instance_id),
+            ),
+            (
+                Key::from(CLOUD_REGION),
+                Value::from(metadata.compute.location),
+            ),
+        ]))
     }
 }
 
@@ -194,6 +201,10 @@ mod tests {
         assert_eq!(
             "02aab8a4-74ef-476e-8182-f6d2ba4166a7".to_string(),
             String::from(identifiers.get(AZURE_INSTANCE_ID.into()).unwrap())
+        );
+        assert_eq!(
+            "westus".to_string(),
+            String::from(identifiers.get(CLOUD_REGION.into()).unwrap())
         )
     }
 
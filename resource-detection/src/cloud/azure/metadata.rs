@@ -4,6 +4,8 @@ use serde::Deserialize;
 pub(super) struct AzureMetadataCompute {
     #[serde(rename = "vmId")]
     pub(super) instance_id: String,
+    #[serde(default)]
+    pub(super) location: String,
 }
 
 #[derive(Deserialize)]
@@ -5,8 +5,8 @@ use crate::cloud::azure::detector::AzureDetector;
 use crate::cloud::gcp::detector::GCPDetector;
 use crate::cloud::http_client::HttpClient;
 use crate::cloud::{
-    AZURE_INSTANCE_ID, CLOUD_INSTANCE_ID, CLOUD_TYPE, CLOUD_TYPE_AWS, CLOUD_TYPE_AZURE,
-    CLOUD_TYPE_GCP, CLOUD_TYPE_NO, GCP_INSTANCE_ID,
+    AZURE_INSTANCE_ID, CLOUD_INSTANCE_ID, CLOUD_REGION, CLOUD_TYPE, CLOUD_TYPE_AWS,
+    CLOUD_TYPE_AZURE, CLOUD_TYPE_GCP, CLOUD_TYPE_NO, GCP_INSTANCE_ID,
 };
 use crate::{DetectError, Detector, Key, Resource, Value, cloud::AWS_INSTANCE_ID};
 use thiserror::Error;
@@ -81,6 +81,12 @@ fn match_resource(
                 Key::from(CLOUD_TYPE),
                 Value::from(cloud_type_const.to_string()),
             ),
+            (
+                Key::from(CLOUD_REGION),
+                resource
+                    .get(CLOUD_REGION.into())
+                    .unwrap_or_else(|| Value::from(String::new())),
+            ),
         ]),
     }
 }
@@ -142,10 +148,16 @@ mod tests {
         let gcp_detector_mock = MockDetector::default();
 
         aws_detector_mock.expect_detect().once().returning(|| {
-            Ok(Resource::new([(
-                Key::from(AWS_INSTANCE_ID),
-                Value::from("i-1234567890abcdef0".to_string()),
-            )]))
+            Ok(Resource::new([
+                (
+                    Key::from(AWS_INSTANCE_ID),
+                    Value::from("i-1234567890abcdef0".to_string()),
+                ),
+                (
+                    Key::from(CLOUD_REGION),
+                    Value::from("us-west-2".to_string()),
+                ),
+            ]))
         });
 
         let detector = CloudIdDetector {
@@ -165,6 +177,11 @@ mod tests {
             CLOUD_TYPE_AWS.to_string(),
             String::from(identifiers.get(CLOUD_TYPE.into()).unwrap())
         );
+
+        assert_eq!(
+            "us-west-2".to_string(),
+            String::from(identifiers.get(CLOUD_REGION.into()).unwrap())
+        );
     }
 
     #[test]
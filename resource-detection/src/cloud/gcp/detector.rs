@@ -1,7 +1,7 @@
 //! GCP instance id detector implementation
 use super::metadata::GCPMetadata;
-use crate::cloud::GCP_INSTANCE_ID;
 use crate::cloud::http_client::{HttpClient, HttpClientError};
+use crate::cloud::{CLOUD_REGION, GCP_INSTANCE_ID};
 use crate::{DetectError, Detector, Key, Resource, Value};
 use http::HeaderMap;
 use thiserror::Error;
@@ -66,13 +66,27 @@ where
         let metadata: GCPMetadata =
             serde_json::from_slice(response.body()).map_err(GCPDetectorError::JsonError)?;
 
-        Ok(Resource::new([(
-            Key::from(GCP_INSTANCE_ID),
-            Value::from(metadata.instance_id.to_string()),
-        )]))
+        Ok(Resource::new([
+            (
+                Key::from(GCP_INSTANCE_ID),
+                Value::from(metadata.instance_id.to_string()),
+            ),
+            (
+                Key::from(CLOUD_REGION),
+                Value::from(region_from_zone(&metadata.zone)),
+            ),
+        ]))
     }
 }
 
+/// Returns the region of a zone like `projects/260890654058/zones/us-central1-c`, i.e. `us-central1`.
+fn region_from_zone(zone: &str) -> String {
+    let zone = zone.rsplit('/').next().unwrap_or_default();
+    zone.rsplit_once('-')
+        .map(|(region, _)| region.to_string())
+        .unwrap_or_default()
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -158,6 +172,10 @@ mod tests {
         assert_eq!(
             "6331980990053453154".to_string(),
             String::from(identifiers.get(GCP_INSTANCE_ID.into()).unwrap())
+        );
+        assert_eq!(
+            "us-central1".to_string(),
+            String::from(identifiers.get(CLOUD_REGION.into()).unwrap())
         )
     }
 
@@ -5,4 +5,7 @@ use serde_json::Number;
 pub(super) struct GCPMetadata {
     #[serde(rename = "id")]
     pub(super) instance_id: Number,
+    /// Zone of the instance, as `projects/<project-number>/zones/<zone>`.
+    #[serde(default)]
+    pub(super) zone: String,
 }
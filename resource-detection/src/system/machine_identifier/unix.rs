@@ -6,16 +6,28 @@ use fs::file::reader::FileReader;
 
 use crate::system::detector::SystemDetectorError;
 
+/// FreeBSD has no systemd machine-id, the `hostid` rc script stores the `kern.hostuuid` of the
+/// host in `/etc/hostid` instead.
+#[cfg(target_os = "freebsd")]
+const DEFAULT_MACHINE_ID_PATH: &str = "/etc/hostid";
+#[cfg(not(target_os = "freebsd"))]
+const DEFAULT_MACHINE_ID_PATH: &str = "/etc/machine-id";
+
+#[cfg(target_os = "freebsd")]
+const DEFAULT_DBUS_MACHINE_ID_PATH: &str = "/var/db/dbus/machine-id";
+#[cfg(not(target_os = "freebsd"))]
+const DEFAULT_DBUS_MACHINE_ID_PATH: &str = "/var/lib/dbus/machine-id";
+
 const MACHINE_ID_PATH: &str = if let Some(v) = option_env!("TEST_MACHINE_ID_PATH") {
     v
 } else {
-    "/etc/machine-id"
+    DEFAULT_MACHINE_ID_PATH
 };
 
 const DBUS_MACHINE_ID_PATH: &str = if let Some(v) = option_env!("TEST_DBUS_MACHINE_ID_PATH") {
     v
 } else {
-    "/var/lib/dbus/machine-id"
+    DEFAULT_DBUS_MACHINE_ID_PATH
 };
 
 pub struct MachineIdentityProvider<F = LocalFile> {
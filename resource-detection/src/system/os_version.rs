@@ -0,0 +1,57 @@
+//! Operating system version retriever
+
+#[cfg(target_family = "unix")]
+const OS_RELEASE_PATH: &str = "/etc/os-release";
+
+#[cfg(target_family = "unix")]
+/// Get the version of the distribution from `/etc/os-release`, e.g. `22.04` on Ubuntu 22.04.
+/// Returns `None` on systems without it, like macOS.
+pub fn get_os_version() -> Option<String> {
+    std::fs::read_to_string(OS_RELEASE_PATH)
+        .ok()
+        .and_then(|content| version_id(&content))
+}
+
+#[cfg(target_family = "windows")]
+/// Get the build number of Windows from the registry, e.g. `20348` on Windows Server 2022.
+pub fn get_os_version() -> Option<String> {
+    use super::machine_identifier::MachineIdentityProvider;
+
+    let key_path: Vec<u16> = "SOFTWARE\\Microsoft\\Windows NT\\CurrentVersion"
+        .encode_utf16()
+        .chain(std::iter::once(0))
+        .collect();
+    let key_name: Vec<u16> = "CurrentBuild"
+        .encode_utf16()
+        .chain(std::iter::once(0))
+        .collect();
+    MachineIdentityProvider::read_string_from_registry(key_path, key_name)
+        .ok()
+        .filter(|version| !version.is_empty())
+}
+
+/// Returns the `VERSION_ID` of an `os-release` file content, whose values can be quoted.
+#[cfg(target_family = "unix")]
+fn version_id(os_release: &str) -> Option<String> {
+    os_release
+        .lines()
+        .find_map(|line| line.trim().strip_prefix("VERSION_ID="))
+        .map(|value| value.trim().trim_matches(['"', '\'']).to_string())
+        .filter(|version| !version.is_empty())
+}
+
+#[cfg(all(test, target_family = "unix"))]
+mod tests {
+    use super::*;
+    use rstest::rstest;
+
+    #[rstest]
+    #[case::quoted("NAME=\"Ubuntu\"\nVERSION_ID=\"22.04\"\nID=ubuntu", Some("22.04"))]
+    #[case::single_quoted("VERSION_ID='9.3'", Some("9.3"))]
+    #[case::unquoted("ID=debian\nVERSION_ID=12\n", Some("12"))]
+    #[case::missing("NAME=\"Arch Linux\"\nBUILD_ID=rolling", None)]
+    #[case::empty("VERSION_ID=\"\"", None)]
+    fn test_version_id(#[case] os_release: &str, #[case] expected: Option<&str>) {
+        assert_eq!(version_id(os_release).as_deref(), expected);
+    }
+}
@@ -5,11 +5,12 @@
 //! 1. Read the current binary's permissions
 //! 2. Create a backup of the current binary
 //! 3. Copy/move the new binary into a temporary file in the **same directory** as the current
-//!    binary (ensures the final rename is on the same filesystem and therefore atomic)
+//!    binary (ensures the final rename is on the same filesystem and therefore atomic). On macOS,
+//!    its quarantine attribute is removed so Gatekeeper doesn't block it
 //! 4. Atomically rename the temp file over the current binary path
 //! 5. On failure, restore from backup
 //!
-//! The only platform difference is in step 2: on Unix the backup is a copy (the OS keeps the
+//! The main platform difference is in step 2: on Unix the backup is a copy (the OS keeps the
 //! inode alive for the running process), while on Windows it is a rename (running `.exe` files
 //! cannot be deleted but can be renamed).
 //!
@@ -244,9 +245,42 @@ fn create_temp_file(
     // Set correct permissions (from original binary)
     fs::set_permissions(&temp_path, permissions.clone()).map_err(ReplaceError::TempCopyFailed)?;
 
+    #[cfg(target_os = "macos")]
+    remove_quarantine(&temp_path);
+
     Ok(temp_path)
 }
 
+/// Removes the quarantine attribute macOS adds to downloaded files, so Gatekeeper doesn't block
+/// the new binary when it is executed by a launchd daemon with nobody to approve it.
+/// A binary without the attribute is not an error, and failing to remove it is only logged since
+/// notarized binaries are allowed to run anyway.
+#[cfg(target_os = "macos")]
+fn remove_quarantine(path: &Path) {
+    const QUARANTINE_ATTRIBUTE: &str = "com.apple.quarantine";
+    // Not looked up in the PATH, as it runs with the privileges of Agent Control.
+    const XATTR_PATH: &str = "/usr/bin/xattr";
+
+    match std::process::Command::new(XATTR_PATH)
+        .arg("-d")
+        .arg(QUARANTINE_ATTRIBUTE)
+        .arg(path)
+        .output()
+    {
+        Ok(output) if output.status.success() => {
+            debug!(path = %path.display(), "Quarantine attribute removed from the new binary");
+        }
+        Ok(_) => {}
+        Err(err) => {
+            debug!(
+                path = %path.display(),
+                error = %err,
+                "Failed to remove the quarantine attribute from the new binary"
+            );
+        }
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;